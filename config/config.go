@@ -3,31 +3,50 @@ package config
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"go-api-boilerplate/models"
+
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 )
 
 // Config holds all configuration for our application
 type Config struct {
-	App        AppConfig
-	Database   DatabaseConfig
-	Redis      RedisConfig
-	JWT        JWTConfig
-	Upload     UploadConfig
-	WebSocket  WebSocketConfig
-	Stream     StreamConfig
-	Encryption EncryptionConfig
-	CORS       CORSConfig
-	RateLimit  RateLimitConfig
-	Log        LogConfig
-	Swagger    SwaggerConfig
-	Monitoring MonitoringConfig
-	AWS        AWSConfig
-	SMTP       SMTPConfig
-	MongoDB    MongoDBConfig
+	App         AppConfig
+	Server      ServerConfig
+	GRPC        GRPCConfig
+	TLS         TLSConfig
+	Database    DatabaseConfig
+	Redis       RedisConfig
+	JWT         JWTConfig
+	Upload      UploadConfig
+	Import      ImportConfig
+	WebSocket   WebSocketConfig
+	Stream      StreamConfig
+	Encryption  EncryptionConfig
+	CORS        CORSConfig
+	RateLimit   RateLimitConfig
+	Log         LogConfig
+	Swagger     SwaggerConfig
+	Monitoring  MonitoringConfig
+	AWS         AWSConfig
+	SMTP        SMTPConfig
+	MongoDB     MongoDBConfig
+	Tracing     TracingConfig
+	Security    SecurityConfig
+	Session     SessionConfig
+	Auth        AuthConfig
+	Password    PasswordConfig
+	Compression CompressionConfig
+	Cache       CacheConfig
+	Response    ResponseConfig
+	Pagination  PaginationConfig
 }
 
 // AppConfig holds application specific configuration
@@ -39,6 +58,61 @@ type AppConfig struct {
 	Debug    bool
 }
 
+// ServerConfig holds HTTP server timeouts and limits. WriteTimeout covers
+// the whole response, so a video stream or a slow upload would be cut off
+// after it; those routes replace the deadlines per request with
+// StreamWriteTimeout and UploadTimeout instead.
+type ServerConfig struct {
+	ReadTimeout        time.Duration
+	ReadHeaderTimeout  time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	MaxHeaderBytes     int
+	StreamWriteTimeout time.Duration
+	UploadTimeout      time.Duration
+	// RequestTimeout bounds how long a handler may run before the request
+	// gets 503; streaming, WebSocket and upload routes are exempt
+	RequestTimeout time.Duration
+	// TrustedProxies lists the proxy IPs and CIDRs whose X-Forwarded-For is
+	// believed when resolving the client IP, for HTTP and for gRPC rate
+	// limiting; empty trusts none
+	TrustedProxies []string
+	// ShutdownTimeout is how long in-flight requests, WebSocket clients and
+	// gRPC calls get to finish on shutdown before they are cut off
+	ShutdownTimeout time.Duration
+}
+
+// GRPCConfig holds gRPC server message limits and keepalive settings
+type GRPCConfig struct {
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+	// KeepaliveMaxConnectionIdle closes connections with no active RPCs
+	// after this long; 0 keeps them open
+	KeepaliveMaxConnectionIdle time.Duration
+	// KeepaliveTime and KeepaliveTimeout control the server's own pings:
+	// an idle connection is pinged after KeepaliveTime and closed if the
+	// ping is not answered within KeepaliveTimeout
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	// KeepaliveMinTime is the shortest client ping interval allowed; clients
+	// pinging more often, or without active RPCs unless
+	// KeepalivePermitWithoutStream is set, are disconnected
+	KeepaliveMinTime             time.Duration
+	KeepalivePermitWithoutStream bool
+	// DefaultDeadline bounds unary calls whose client set no deadline; 0
+	// leaves them unbounded
+	DefaultDeadline time.Duration
+}
+
+// TLSConfig holds in-process TLS settings shared by the HTTP and gRPC
+// servers. ClientCAFile turns on mutual TLS for gRPC only.
+type TLSConfig struct {
+	Enabled      bool
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
 // DatabaseConfig holds database configuration
 type DatabaseConfig struct {
 	Driver          string
@@ -57,6 +131,9 @@ type DatabaseConfig struct {
 	ReadPort     string
 	ReadUser     string
 	ReadPassword string
+	// Startup connection retries
+	ConnectAttempts      int
+	ConnectRetryInterval time.Duration
 }
 
 // RedisConfig holds Redis configuration
@@ -67,21 +144,108 @@ type RedisConfig struct {
 	DB           int
 	PoolSize     int
 	MinIdleConns int
+	// Startup connection retries
+	ConnectAttempts      int
+	ConnectRetryInterval time.Duration
 }
 
 // JWTConfig holds JWT configuration
 type JWTConfig struct {
-	Secret        string
-	Expiry        time.Duration
-	RefreshExpiry time.Duration
-	Issuer        string
+	Secret         string
+	Expiry         time.Duration
+	RefreshExpiry  time.Duration
+	Issuer         string
+	Audience       string
+	TrustedIssuers []string
+	// Leeway tolerates clock skew between servers when checking the exp,
+	// nbf and iat claims
+	Leeway time.Duration
+}
+
+// AuthConfig holds account and credential configuration
+type AuthConfig struct {
+	EmailStripGmailAliases bool
+	PasswordResetTTL       time.Duration
+	EmailVerificationTTL   time.Duration
+	TokenCleanupInterval   time.Duration
+	// CacheTTL is how long user data stays cached in Redis; see UserCacheTTL
+	CacheTTL time.Duration
+	// RefreshCookie delivers the refresh token in an HttpOnly cookie instead
+	// of the response body, sharing the session cookie's domain, Secure and
+	// SameSite settings
+	RefreshCookie bool
+	// DefaultRole is assigned to self-registered users and to users created
+	// without a role; it cannot be admin
+	DefaultRole string
+}
+
+// PasswordConfig selects how passwords are hashed. Hashes made with another
+// algorithm or weaker parameters still verify and are re-hashed on login.
+type PasswordConfig struct {
+	// Hash is the algorithm for new hashes: bcrypt or argon2id
+	Hash       string
+	BcryptCost int
+	// Argon2Memory is in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism int
+}
+
+// SessionConfig holds cookie session configuration
+type SessionConfig struct {
+	Store          string
+	TTL            time.Duration
+	CookieName     string
+	CookieDomain   string
+	CookieSecure   bool
+	CookieSameSite string
 }
 
 // UploadConfig holds file upload configuration
 type UploadConfig struct {
-	MaxSize      int64
-	Path         string
-	AllowedTypes []string
+	MaxSize int64
+	// MaxTotalSize caps the combined size of the files in one multi-file
+	// upload; MaxMultipartMemory is how much of a multipart form is held in
+	// memory before the rest spills to temporary files
+	MaxTotalSize       int64
+	MaxMultipartMemory int64
+	// MaxConcurrentPerUser caps the uploads one user may have in progress
+	// at once; 0 is unlimited
+	MaxConcurrentPerUser int
+	Path                 string
+	AllowedTypes         []string
+	// Policies narrow the allowed MIME types for particular endpoints,
+	// keyed by policy name; see UploadAllowedTypes
+	Policies         map[string][]string
+	ScanEnabled      bool
+	ClamdAddress     string
+	ScanTimeout      time.Duration
+	Dedup            bool
+	FilenameStrategy string
+	// PublicFiles serves /uploads without authentication; only enable it
+	// when everything uploaded is meant to be public
+	PublicFiles bool
+	// Images larger than these are rejected before decoding; decoded images
+	// take about 4 bytes per pixel, so ImageMaxPixels caps decode memory
+	ImageMaxWidth  int
+	ImageMaxHeight int
+	ImageMaxPixels int64
+	// AvatarSizes are the variants generated for an uploaded avatar
+	AvatarSizes []AvatarSize
+}
+
+// AvatarSize is a named avatar variant; images are scaled down to fit in a
+// Size x Size square, keeping their aspect ratio
+type AvatarSize struct {
+	Name string
+	Size int
+}
+
+// ImportConfig holds bulk user import configuration
+type ImportConfig struct {
+	MaxFileSize int64
+	MaxRows     int
+	BatchSize   int
 }
 
 // WebSocketConfig holds WebSocket configuration
@@ -91,6 +255,23 @@ type WebSocketConfig struct {
 	MaxMessageSize  int64
 	PingPeriod      time.Duration
 	PongWait        time.Duration
+	// AllowedOrigins overrides CORS.AllowedOrigins for WebSocket upgrades
+	AllowedOrigins []string
+	// Connection limits, 0 disables the limit
+	MaxConnections        int
+	MaxConnectionsPerUser int
+	// Inbound messages per second per client, with bursts up to
+	// MessageBurst; 0 disables the limit
+	MessageRate  float64
+	MessageBurst int
+	// Offline queue for messages sent to users who are not connected
+	OfflineQueueEnabled   bool
+	OfflineQueueTTL       time.Duration
+	OfflineQueueMaxLength int64
+	// Compression negotiates permessage-deflate with clients that offer it,
+	// at CompressionLevel (-2 to 9, see compress/flate)
+	Compression      bool
+	CompressionLevel int
 }
 
 // StreamConfig holds video streaming configuration
@@ -98,6 +279,13 @@ type StreamConfig struct {
 	ChunkSize  int64
 	BufferSize int64
 	Path       string
+	// Signed URLs let players fetch media without an Authorization header;
+	// the key falls back to JWT_SECRET when empty
+	SigningKey   string
+	SignedURLTTL time.Duration
+	// HLSSignedURLs rewrites playlist URIs with a signature so a single
+	// authenticated playlist request authorizes its segments
+	HLSSignedURLs bool
 }
 
 // EncryptionConfig holds encryption configuration
@@ -113,6 +301,15 @@ type CORSConfig struct {
 	ExposedHeaders   []string
 	AllowCredentials bool
 	MaxAge           int
+	// RoutePolicies replace AllowedOrigins for paths under their prefix,
+	// longest prefix first; see CORSAllowedOrigins
+	RoutePolicies []CORSRoutePolicy
+}
+
+// CORSRoutePolicy is the set of origins allowed for one route prefix
+type CORSRoutePolicy struct {
+	Prefix         string
+	AllowedOrigins []string
 }
 
 // RateLimitConfig holds rate limiting configuration
@@ -128,20 +325,85 @@ type LogConfig struct {
 	Format   string
 	Output   string
 	FilePath string
+	// SkipPaths are path prefixes whose successful requests are not logged
+	SkipPaths []string
+	// SamplePaths are path prefixes where only one in SampleRate successful
+	// requests is logged; 4xx and 5xx responses are always logged
+	SamplePaths []string
+	SampleRate  int
+	// RedactKeys are the JSON keys whose values are replaced when bodies
+	// are logged; a key matches when it contains one, ignoring case
+	RedactKeys []string
 }
 
 // SwaggerConfig holds Swagger configuration
 type SwaggerConfig struct {
-	Enabled  bool
-	Host     string
-	BasePath string
+	Enabled   bool
+	Host      string
+	BasePath  string
+	Protected bool
+	Username  string
+	Password  string
+}
+
+// CacheConfig holds caching configuration
+type CacheConfig struct {
+	// ServeStaleOnError answers reads from the last cached copy when the
+	// database fails; StaleTTL is how long those copies are kept
+	ServeStaleOnError bool
+	StaleTTL          time.Duration
+}
+
+// ResponseConfig holds response format configuration
+type ResponseConfig struct {
+	// ListEnvelope wraps list endpoints in the standard envelope; when false
+	// they return a bare array with pagination in headers
+	ListEnvelope bool
+	// TimeFormat is how user DTOs encode timestamps: rfc3339 or epoch_millis
+	TimeFormat string
+	// IDFormat is how user DTOs encode IDs: number or string
+	IDFormat string
+}
+
+// PaginationConfig holds page size limits shared by REST and gRPC listings
+type PaginationConfig struct {
+	DefaultPerPage int
+	MaxPerPage     int
+	// RejectOverMax fails requests asking for more than MaxPerPage instead
+	// of clamping them to it
+	RejectOverMax bool
+}
+
+// CompressionConfig holds response compression configuration
+type CompressionConfig struct {
+	Enabled      bool
+	Level        int
+	MinSize      int
+	ContentTypes []string
 }
 
 // MonitoringConfig holds monitoring configuration
 type MonitoringConfig struct {
-	MetricsEnabled  bool
-	MetricsPath     string
-	HealthCheckPath string
+	MetricsEnabled bool
+	MetricsPath    string
+	// MetricsDropUnmatched leaves requests that match no route out of the
+	// HTTP metrics instead of counting them under the "unmatched" route
+	MetricsDropUnmatched bool
+	HealthCheckPath      string
+	HealthCheckTimeout   time.Duration
+	// HealthDiskMinFreePercent is the free space below which the upload and
+	// stream volumes are reported low by the readiness probe; 0 disables the
+	// check. A low volume marks the service degraded, or not ready when
+	// HealthDiskCritical is set.
+	HealthDiskMinFreePercent float64
+	HealthDiskCritical       bool
+	// GRPCReflection exposes the gRPC service schema; it is never registered
+	// in production regardless of this flag
+	GRPCReflection bool
+	// PprofEnabled serves net/http/pprof on its own listener at PprofAddr,
+	// separate from the public router
+	PprofEnabled bool
+	PprofAddr    string
 }
 
 // AWSConfig holds AWS configuration
@@ -169,6 +431,26 @@ type MongoDBConfig struct {
 	MaxPoolSize    uint64
 }
 
+// TracingConfig holds distributed tracing configuration
+type TracingConfig struct {
+	Enabled       bool
+	ServiceName   string
+	Endpoint      string
+	SamplingRatio float64
+}
+
+// SecurityConfig holds security response header configuration
+type SecurityConfig struct {
+	HSTSMaxAge            int
+	HSTSIncludeSubDomains bool
+	HSTSPreload           bool
+	ContentSecurityPolicy string
+	SwaggerCSP            string
+	FrameOptions          string
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+}
+
 var cfg *Config
 
 // Load loads configuration from environment variables
@@ -193,62 +475,157 @@ func Load() (*Config, error) {
 			GRPCPort: viper.GetString("GRPC_PORT"),
 			Debug:    viper.GetBool("APP_DEBUG"),
 		},
+		Server: ServerConfig{
+			ReadTimeout:        viper.GetDuration("SERVER_READ_TIMEOUT"),
+			ReadHeaderTimeout:  viper.GetDuration("SERVER_READ_HEADER_TIMEOUT"),
+			WriteTimeout:       viper.GetDuration("SERVER_WRITE_TIMEOUT"),
+			IdleTimeout:        viper.GetDuration("SERVER_IDLE_TIMEOUT"),
+			MaxHeaderBytes:     viper.GetInt("MAX_HEADER_BYTES"),
+			StreamWriteTimeout: viper.GetDuration("SERVER_STREAM_WRITE_TIMEOUT"),
+			UploadTimeout:      viper.GetDuration("SERVER_UPLOAD_TIMEOUT"),
+			RequestTimeout:     viper.GetDuration("REQUEST_TIMEOUT"),
+			TrustedProxies:     splitList(viper.GetStringSlice("TRUSTED_PROXIES")),
+			ShutdownTimeout:    viper.GetDuration("SHUTDOWN_TIMEOUT"),
+		},
+		GRPC: GRPCConfig{
+			MaxRecvMsgSize:               viper.GetInt("GRPC_MAX_RECV_MSG_SIZE"),
+			MaxSendMsgSize:               viper.GetInt("GRPC_MAX_SEND_MSG_SIZE"),
+			KeepaliveMaxConnectionIdle:   viper.GetDuration("GRPC_KEEPALIVE_MAX_CONNECTION_IDLE"),
+			KeepaliveTime:                viper.GetDuration("GRPC_KEEPALIVE_TIME"),
+			KeepaliveTimeout:             viper.GetDuration("GRPC_KEEPALIVE_TIMEOUT"),
+			KeepaliveMinTime:             viper.GetDuration("GRPC_KEEPALIVE_MIN_TIME"),
+			KeepalivePermitWithoutStream: viper.GetBool("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM"),
+			DefaultDeadline:              viper.GetDuration("GRPC_DEFAULT_DEADLINE"),
+		},
+		TLS: TLSConfig{
+			Enabled:      viper.GetBool("TLS_ENABLED"),
+			CertFile:     viper.GetString("TLS_CERT_FILE"),
+			KeyFile:      viper.GetString("TLS_KEY_FILE"),
+			ClientCAFile: viper.GetString("TLS_CLIENT_CA_FILE"),
+		},
 		Database: DatabaseConfig{
-			Driver:          viper.GetString("DB_DRIVER"),
-			Host:            viper.GetString("DB_HOST"),
-			Port:            viper.GetString("DB_PORT"),
-			Name:            viper.GetString("DB_NAME"),
-			User:            viper.GetString("DB_USER"),
-			Password:        viper.GetString("DB_PASSWORD"),
-			SSLMode:         viper.GetString("DB_SSL_MODE"),
-			MaxIdleConns:    viper.GetInt("MAX_IDLE_CONNS"),
-			MaxOpenConns:    viper.GetInt("MAX_OPEN_CONNS"),
-			ConnMaxLifetime: viper.GetDuration("CONN_MAX_LIFETIME"),
-			ConnMaxIdleTime: viper.GetDuration("CONN_MAX_IDLE_TIME"),
-			ReadHost:        viper.GetString("DB_READ_HOST"),
-			ReadPort:        viper.GetString("DB_READ_PORT"),
-			ReadUser:        viper.GetString("DB_READ_USER"),
-			ReadPassword:    viper.GetString("DB_READ_PASSWORD"),
+			Driver:               viper.GetString("DB_DRIVER"),
+			Host:                 viper.GetString("DB_HOST"),
+			Port:                 viper.GetString("DB_PORT"),
+			Name:                 viper.GetString("DB_NAME"),
+			User:                 viper.GetString("DB_USER"),
+			Password:             viper.GetString("DB_PASSWORD"),
+			SSLMode:              viper.GetString("DB_SSL_MODE"),
+			MaxIdleConns:         viper.GetInt("MAX_IDLE_CONNS"),
+			MaxOpenConns:         viper.GetInt("MAX_OPEN_CONNS"),
+			ConnMaxLifetime:      viper.GetDuration("CONN_MAX_LIFETIME"),
+			ConnMaxIdleTime:      viper.GetDuration("CONN_MAX_IDLE_TIME"),
+			ReadHost:             viper.GetString("DB_READ_HOST"),
+			ReadPort:             viper.GetString("DB_READ_PORT"),
+			ReadUser:             viper.GetString("DB_READ_USER"),
+			ReadPassword:         viper.GetString("DB_READ_PASSWORD"),
+			ConnectAttempts:      viper.GetInt("DB_CONNECT_ATTEMPTS"),
+			ConnectRetryInterval: viper.GetDuration("DB_CONNECT_RETRY_INTERVAL"),
 		},
 		Redis: RedisConfig{
-			Host:         viper.GetString("REDIS_HOST"),
-			Port:         viper.GetString("REDIS_PORT"),
-			Password:     viper.GetString("REDIS_PASSWORD"),
-			DB:           viper.GetInt("REDIS_DB"),
-			PoolSize:     viper.GetInt("REDIS_POOL_SIZE"),
-			MinIdleConns: viper.GetInt("REDIS_MIN_IDLE_CONNS"),
+			Host:                 viper.GetString("REDIS_HOST"),
+			Port:                 viper.GetString("REDIS_PORT"),
+			Password:             viper.GetString("REDIS_PASSWORD"),
+			DB:                   viper.GetInt("REDIS_DB"),
+			PoolSize:             viper.GetInt("REDIS_POOL_SIZE"),
+			MinIdleConns:         viper.GetInt("REDIS_MIN_IDLE_CONNS"),
+			ConnectAttempts:      viper.GetInt("REDIS_CONNECT_ATTEMPTS"),
+			ConnectRetryInterval: viper.GetDuration("REDIS_CONNECT_RETRY_INTERVAL"),
 		},
 		JWT: JWTConfig{
-			Secret:        viper.GetString("JWT_SECRET"),
-			Expiry:        viper.GetDuration("JWT_EXPIRY"),
-			RefreshExpiry: viper.GetDuration("JWT_REFRESH_EXPIRY"),
-			Issuer:        viper.GetString("JWT_ISSUER"),
+			Secret:         viper.GetString("JWT_SECRET"),
+			Expiry:         viper.GetDuration("JWT_EXPIRY"),
+			RefreshExpiry:  viper.GetDuration("JWT_REFRESH_EXPIRY"),
+			Issuer:         viper.GetString("JWT_ISSUER"),
+			Audience:       viper.GetString("JWT_AUDIENCE"),
+			TrustedIssuers: splitList(viper.GetStringSlice("JWT_TRUSTED_ISSUERS")),
+			Leeway:         viper.GetDuration("JWT_LEEWAY"),
 		},
+		Session: SessionConfig{
+			Store:          viper.GetString("SESSION_STORE"),
+			TTL:            viper.GetDuration("SESSION_TTL"),
+			CookieName:     viper.GetString("SESSION_COOKIE_NAME"),
+			CookieDomain:   viper.GetString("SESSION_COOKIE_DOMAIN"),
+			CookieSecure:   viper.GetBool("SESSION_COOKIE_SECURE"),
+			CookieSameSite: viper.GetString("SESSION_COOKIE_SAME_SITE"),
+		},
+		Auth: AuthConfig{
+			EmailStripGmailAliases: viper.GetBool("AUTH_EMAIL_STRIP_GMAIL_ALIASES"),
+			PasswordResetTTL:       viper.GetDuration("AUTH_PASSWORD_RESET_TTL"),
+			EmailVerificationTTL:   viper.GetDuration("AUTH_EMAIL_VERIFICATION_TTL"),
+			TokenCleanupInterval:   viper.GetDuration("AUTH_TOKEN_CLEANUP_INTERVAL"),
+			CacheTTL:               viper.GetDuration("AUTH_CACHE_TTL"),
+			RefreshCookie:          viper.GetBool("AUTH_REFRESH_COOKIE"),
+			DefaultRole:            strings.ToLower(viper.GetString("AUTH_DEFAULT_ROLE")),
+		},
+		Password: PasswordConfig{
+			Hash:              strings.ToLower(viper.GetString("PASSWORD_HASH")),
+			BcryptCost:        viper.GetInt("PASSWORD_BCRYPT_COST"),
+			Argon2Memory:      viper.GetUint32("PASSWORD_ARGON2_MEMORY"),
+			Argon2Iterations:  viper.GetUint32("PASSWORD_ARGON2_ITERATIONS"),
+			Argon2Parallelism: viper.GetInt("PASSWORD_ARGON2_PARALLELISM"),
+		},
+
 		Upload: UploadConfig{
-			MaxSize:      viper.GetInt64("UPLOAD_MAX_SIZE"),
-			Path:         viper.GetString("UPLOAD_PATH"),
-			AllowedTypes: viper.GetStringSlice("UPLOAD_ALLOWED_TYPES"),
+			MaxSize:              viper.GetInt64("UPLOAD_MAX_SIZE"),
+			MaxTotalSize:         viper.GetInt64("UPLOAD_MAX_TOTAL_SIZE"),
+			MaxMultipartMemory:   viper.GetInt64("MAX_MULTIPART_MEMORY"),
+			MaxConcurrentPerUser: viper.GetInt("UPLOAD_MAX_CONCURRENT_PER_USER"),
+			Path:                 viper.GetString("UPLOAD_PATH"),
+			AllowedTypes:         splitList(viper.GetStringSlice("UPLOAD_ALLOWED_TYPES")),
+			Policies: map[string][]string{
+				"avatar":   splitList(viper.GetStringSlice("UPLOAD_POLICY_AVATAR")),
+				"document": splitList(viper.GetStringSlice("UPLOAD_POLICY_DOCUMENT")),
+				"video":    splitList(viper.GetStringSlice("UPLOAD_POLICY_VIDEO")),
+			},
+			ScanEnabled:      viper.GetBool("UPLOAD_SCAN_ENABLED"),
+			ClamdAddress:     viper.GetString("UPLOAD_CLAMD_ADDRESS"),
+			ScanTimeout:      viper.GetDuration("UPLOAD_SCAN_TIMEOUT"),
+			Dedup:            viper.GetBool("UPLOAD_DEDUP"),
+			FilenameStrategy: viper.GetString("UPLOAD_FILENAME_STRATEGY"),
+			PublicFiles:      viper.GetBool("UPLOAD_PUBLIC_FILES"),
+			ImageMaxWidth:    viper.GetInt("UPLOAD_IMAGE_MAX_WIDTH"),
+			ImageMaxHeight:   viper.GetInt("UPLOAD_IMAGE_MAX_HEIGHT"),
+			ImageMaxPixels:   viper.GetInt64("UPLOAD_IMAGE_MAX_PIXELS"),
+		},
+		Import: ImportConfig{
+			MaxFileSize: viper.GetInt64("IMPORT_MAX_FILE_SIZE"),
+			MaxRows:     viper.GetInt("IMPORT_MAX_ROWS"),
+			BatchSize:   viper.GetInt("IMPORT_BATCH_SIZE"),
 		},
 		WebSocket: WebSocketConfig{
-			ReadBufferSize:  viper.GetInt("WS_READ_BUFFER_SIZE"),
-			WriteBufferSize: viper.GetInt("WS_WRITE_BUFFER_SIZE"),
-			MaxMessageSize:  viper.GetInt64("WS_MAX_MESSAGE_SIZE"),
-			PingPeriod:      viper.GetDuration("WS_PING_PERIOD"),
-			PongWait:        viper.GetDuration("WS_PONG_WAIT"),
+			ReadBufferSize:        viper.GetInt("WS_READ_BUFFER_SIZE"),
+			WriteBufferSize:       viper.GetInt("WS_WRITE_BUFFER_SIZE"),
+			MaxMessageSize:        viper.GetInt64("WS_MAX_MESSAGE_SIZE"),
+			PingPeriod:            viper.GetDuration("WS_PING_PERIOD"),
+			PongWait:              viper.GetDuration("WS_PONG_WAIT"),
+			AllowedOrigins:        splitList(viper.GetStringSlice("WS_ALLOWED_ORIGINS")),
+			MaxConnections:        viper.GetInt("WS_MAX_CONNECTIONS"),
+			MaxConnectionsPerUser: viper.GetInt("WS_MAX_CONNECTIONS_PER_USER"),
+			MessageRate:           viper.GetFloat64("WS_MESSAGE_RATE"),
+			MessageBurst:          viper.GetInt("WS_MESSAGE_BURST"),
+			OfflineQueueEnabled:   viper.GetBool("WS_OFFLINE_QUEUE_ENABLED"),
+			OfflineQueueTTL:       viper.GetDuration("WS_OFFLINE_QUEUE_TTL"),
+			OfflineQueueMaxLength: viper.GetInt64("WS_OFFLINE_QUEUE_MAX_LENGTH"),
+			Compression:           viper.GetBool("WS_COMPRESSION"),
+			CompressionLevel:      viper.GetInt("WS_COMPRESSION_LEVEL"),
 		},
 		Stream: StreamConfig{
-			ChunkSize:  viper.GetInt64("STREAM_CHUNK_SIZE"),
-			BufferSize: viper.GetInt64("STREAM_BUFFER_SIZE"),
-			Path:       viper.GetString("STREAM_PATH"),
+			ChunkSize:     viper.GetInt64("STREAM_CHUNK_SIZE"),
+			BufferSize:    viper.GetInt64("STREAM_BUFFER_SIZE"),
+			Path:          viper.GetString("STREAM_PATH"),
+			SigningKey:    viper.GetString("STREAM_SIGNING_KEY"),
+			SignedURLTTL:  viper.GetDuration("STREAM_SIGNED_URL_TTL"),
+			HLSSignedURLs: viper.GetBool("STREAM_HLS_SIGNED_URLS"),
 		},
 		Encryption: EncryptionConfig{
 			Key: viper.GetString("ENCRYPTION_KEY"),
 		},
 		CORS: CORSConfig{
-			AllowedOrigins:   viper.GetStringSlice("CORS_ALLOWED_ORIGINS"),
-			AllowedMethods:   viper.GetStringSlice("CORS_ALLOWED_METHODS"),
-			AllowedHeaders:   viper.GetStringSlice("CORS_ALLOWED_HEADERS"),
-			ExposedHeaders:   viper.GetStringSlice("CORS_EXPOSE_HEADERS"),
+			AllowedOrigins:   splitList(viper.GetStringSlice("CORS_ALLOWED_ORIGINS")),
+			AllowedMethods:   splitList(viper.GetStringSlice("CORS_ALLOWED_METHODS")),
+			AllowedHeaders:   splitList(viper.GetStringSlice("CORS_ALLOWED_HEADERS")),
+			ExposedHeaders:   splitList(viper.GetStringSlice("CORS_EXPOSE_HEADERS")),
 			AllowCredentials: viper.GetBool("CORS_ALLOW_CREDENTIALS"),
 			MaxAge:           viper.GetInt("CORS_MAX_AGE"),
 		},
@@ -258,20 +635,54 @@ func Load() (*Config, error) {
 			Duration: viper.GetDuration("RATE_LIMIT_DURATION"),
 		},
 		Log: LogConfig{
-			Level:    viper.GetString("LOG_LEVEL"),
-			Format:   viper.GetString("LOG_FORMAT"),
-			Output:   viper.GetString("LOG_OUTPUT"),
-			FilePath: viper.GetString("LOG_FILE_PATH"),
+			Level:       viper.GetString("LOG_LEVEL"),
+			Format:      viper.GetString("LOG_FORMAT"),
+			Output:      viper.GetString("LOG_OUTPUT"),
+			FilePath:    viper.GetString("LOG_FILE_PATH"),
+			SkipPaths:   splitList(viper.GetStringSlice("LOG_SKIP_PATHS")),
+			SamplePaths: splitList(viper.GetStringSlice("LOG_SAMPLE_PATHS")),
+			SampleRate:  viper.GetInt("LOG_SAMPLE_RATE"),
+			RedactKeys:  splitList(viper.GetStringSlice("LOG_REDACT_KEYS")),
 		},
 		Swagger: SwaggerConfig{
-			Enabled:  viper.GetBool("SWAGGER_ENABLED"),
-			Host:     viper.GetString("SWAGGER_HOST"),
-			BasePath: viper.GetString("SWAGGER_BASE_PATH"),
+			Enabled:   viper.GetBool("SWAGGER_ENABLED"),
+			Host:      viper.GetString("SWAGGER_HOST"),
+			BasePath:  viper.GetString("SWAGGER_BASE_PATH"),
+			Protected: viper.GetBool("SWAGGER_PROTECTED"),
+			Username:  viper.GetString("SWAGGER_USERNAME"),
+			Password:  viper.GetString("SWAGGER_PASSWORD"),
+		},
+		Cache: CacheConfig{
+			ServeStaleOnError: viper.GetBool("CACHE_SERVE_STALE_ON_ERROR"),
+			StaleTTL:          viper.GetDuration("CACHE_STALE_TTL"),
+		},
+		Response: ResponseConfig{
+			ListEnvelope: viper.GetBool("RESPONSE_LIST_ENVELOPE"),
+			TimeFormat:   strings.ToLower(viper.GetString("RESPONSE_TIME_FORMAT")),
+			IDFormat:     strings.ToLower(viper.GetString("RESPONSE_ID_FORMAT")),
+		},
+		Pagination: PaginationConfig{
+			DefaultPerPage: viper.GetInt("PAGINATION_DEFAULT_PER_PAGE"),
+			MaxPerPage:     viper.GetInt("PAGINATION_MAX_PER_PAGE"),
+			RejectOverMax:  viper.GetBool("PAGINATION_REJECT_OVER_MAX"),
+		},
+		Compression: CompressionConfig{
+			Enabled:      viper.GetBool("COMPRESSION_ENABLED"),
+			Level:        viper.GetInt("COMPRESSION_LEVEL"),
+			MinSize:      viper.GetInt("COMPRESSION_MIN_SIZE"),
+			ContentTypes: splitList(viper.GetStringSlice("COMPRESSION_CONTENT_TYPES")),
 		},
 		Monitoring: MonitoringConfig{
-			MetricsEnabled:  viper.GetBool("METRICS_ENABLED"),
-			MetricsPath:     viper.GetString("METRICS_PATH"),
-			HealthCheckPath: viper.GetString("HEALTH_CHECK_PATH"),
+			MetricsEnabled:           viper.GetBool("METRICS_ENABLED"),
+			MetricsPath:              viper.GetString("METRICS_PATH"),
+			MetricsDropUnmatched:     viper.GetBool("METRICS_DROP_UNMATCHED"),
+			HealthCheckPath:          viper.GetString("HEALTH_CHECK_PATH"),
+			HealthCheckTimeout:       viper.GetDuration("HEALTH_CHECK_TIMEOUT"),
+			HealthDiskMinFreePercent: viper.GetFloat64("HEALTH_DISK_MIN_FREE_PERCENT"),
+			HealthDiskCritical:       viper.GetBool("HEALTH_DISK_CRITICAL"),
+			GRPCReflection:           viper.GetBool("GRPC_REFLECTION"),
+			PprofEnabled:             viper.GetBool("PPROF_ENABLED"),
+			PprofAddr:                viper.GetString("PPROF_ADDR"),
 		},
 		AWS: AWSConfig{
 			Region:          viper.GetString("AWS_REGION"),
@@ -292,8 +703,36 @@ func Load() (*Config, error) {
 			ConnectTimeout: viper.GetDuration("MONGODB_CONNECT_TIMEOUT"),
 			MaxPoolSize:    viper.GetUint64("MONGODB_MAX_POOL_SIZE"),
 		},
+		Tracing: TracingConfig{
+			Enabled:       viper.GetBool("OTEL_ENABLED"),
+			ServiceName:   viper.GetString("OTEL_SERVICE_NAME"),
+			Endpoint:      viper.GetString("OTEL_EXPORTER_OTLP_ENDPOINT"),
+			SamplingRatio: viper.GetFloat64("OTEL_SAMPLING_RATIO"),
+		},
+		Security: SecurityConfig{
+			HSTSMaxAge:            viper.GetInt("SECURITY_HSTS_MAX_AGE"),
+			HSTSIncludeSubDomains: viper.GetBool("SECURITY_HSTS_INCLUDE_SUBDOMAINS"),
+			HSTSPreload:           viper.GetBool("SECURITY_HSTS_PRELOAD"),
+			ContentSecurityPolicy: viper.GetString("SECURITY_CSP"),
+			SwaggerCSP:            viper.GetString("SECURITY_SWAGGER_CSP"),
+			FrameOptions:          viper.GetString("SECURITY_FRAME_OPTIONS"),
+			ReferrerPolicy:        viper.GetString("SECURITY_REFERRER_POLICY"),
+			PermissionsPolicy:     viper.GetString("SECURITY_PERMISSIONS_POLICY"),
+		},
 	}
 
+	routePolicies, err := parseCORSRoutePolicies(splitList(viper.GetStringSlice("CORS_ROUTE_ORIGINS")))
+	if err != nil {
+		return nil, err
+	}
+	cfg.CORS.RoutePolicies = routePolicies
+
+	avatarSizes, err := parseAvatarSizes(splitList(viper.GetStringSlice("UPLOAD_AVATAR_SIZES")))
+	if err != nil {
+		return nil, err
+	}
+	cfg.Upload.AvatarSizes = avatarSizes
+
 	// Validate configuration
 	if err := validate(cfg); err != nil {
 		return nil, err
@@ -319,6 +758,30 @@ func setDefaults() {
 	viper.SetDefault("GRPC_PORT", "50051")
 	viper.SetDefault("APP_DEBUG", true)
 
+	// Server defaults
+	viper.SetDefault("SERVER_READ_TIMEOUT", "15s")
+	viper.SetDefault("SERVER_READ_HEADER_TIMEOUT", "5s")
+	viper.SetDefault("SERVER_WRITE_TIMEOUT", "15s")
+	viper.SetDefault("SERVER_IDLE_TIMEOUT", "60s")
+	viper.SetDefault("MAX_HEADER_BYTES", 1<<20)
+	viper.SetDefault("SERVER_STREAM_WRITE_TIMEOUT", "0s")
+	viper.SetDefault("SERVER_UPLOAD_TIMEOUT", "5m")
+	viper.SetDefault("REQUEST_TIMEOUT", "10s")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "30s")
+
+	// gRPC defaults
+	viper.SetDefault("GRPC_MAX_RECV_MSG_SIZE", 4<<20)
+	viper.SetDefault("GRPC_MAX_SEND_MSG_SIZE", 4<<20)
+	viper.SetDefault("GRPC_KEEPALIVE_MAX_CONNECTION_IDLE", "15m")
+	viper.SetDefault("GRPC_KEEPALIVE_TIME", "2h")
+	viper.SetDefault("GRPC_KEEPALIVE_TIMEOUT", "20s")
+	viper.SetDefault("GRPC_KEEPALIVE_MIN_TIME", "5m")
+	viper.SetDefault("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM", false)
+	viper.SetDefault("GRPC_DEFAULT_DEADLINE", "30s")
+
+	// TLS defaults
+	viper.SetDefault("TLS_ENABLED", false)
+
 	// Database defaults
 	viper.SetDefault("DB_DRIVER", "postgres")
 	viper.SetDefault("DB_HOST", "localhost")
@@ -328,6 +791,8 @@ func setDefaults() {
 	viper.SetDefault("MAX_OPEN_CONNS", 100)
 	viper.SetDefault("CONN_MAX_LIFETIME", "1h")
 	viper.SetDefault("CONN_MAX_IDLE_TIME", "10m")
+	viper.SetDefault("DB_CONNECT_ATTEMPTS", 5)
+	viper.SetDefault("DB_CONNECT_RETRY_INTERVAL", "1s")
 
 	// Redis defaults
 	viper.SetDefault("REDIS_HOST", "localhost")
@@ -335,16 +800,65 @@ func setDefaults() {
 	viper.SetDefault("REDIS_DB", 0)
 	viper.SetDefault("REDIS_POOL_SIZE", 10)
 	viper.SetDefault("REDIS_MIN_IDLE_CONNS", 5)
+	viper.SetDefault("REDIS_CONNECT_ATTEMPTS", 3)
+	viper.SetDefault("REDIS_CONNECT_RETRY_INTERVAL", "1s")
 
 	// JWT defaults
 	viper.SetDefault("JWT_EXPIRY", "24h")
 	viper.SetDefault("JWT_REFRESH_EXPIRY", "720h")
 	viper.SetDefault("JWT_ISSUER", "boilerplate-api")
+	viper.SetDefault("JWT_AUDIENCE", "")
+	viper.SetDefault("JWT_TRUSTED_ISSUERS", []string{})
+	viper.SetDefault("JWT_LEEWAY", "30s")
+
+	// Session defaults
+	viper.SetDefault("SESSION_STORE", "redis")
+	viper.SetDefault("SESSION_TTL", "24h")
+	viper.SetDefault("SESSION_COOKIE_NAME", "session_id")
+	viper.SetDefault("SESSION_COOKIE_SECURE", true)
+	viper.SetDefault("SESSION_COOKIE_SAME_SITE", "lax")
+
+	// Auth defaults
+	viper.SetDefault("AUTH_EMAIL_STRIP_GMAIL_ALIASES", false)
+	viper.SetDefault("AUTH_PASSWORD_RESET_TTL", "1h")
+	viper.SetDefault("AUTH_EMAIL_VERIFICATION_TTL", "24h")
+	viper.SetDefault("AUTH_TOKEN_CLEANUP_INTERVAL", "1h")
+	viper.SetDefault("AUTH_CACHE_TTL", "15m")
+	viper.SetDefault("AUTH_REFRESH_COOKIE", false)
+	viper.SetDefault("AUTH_DEFAULT_ROLE", "user")
+
+	// Password hashing defaults
+	viper.SetDefault("PASSWORD_HASH", "bcrypt")
+	viper.SetDefault("PASSWORD_BCRYPT_COST", 10)
+	viper.SetDefault("PASSWORD_ARGON2_MEMORY", 64*1024)
+	viper.SetDefault("PASSWORD_ARGON2_ITERATIONS", 3)
+	viper.SetDefault("PASSWORD_ARGON2_PARALLELISM", 2)
 
 	// Upload defaults
-	viper.SetDefault("UPLOAD_MAX_SIZE", 10485760) // 10MB
+	viper.SetDefault("UPLOAD_MAX_SIZE", 10485760)       // 10MB
+	viper.SetDefault("UPLOAD_MAX_TOTAL_SIZE", 52428800) // 50MB
+	viper.SetDefault("MAX_MULTIPART_MEMORY", 8388608)   // 8MB
+	viper.SetDefault("UPLOAD_MAX_CONCURRENT_PER_USER", 3)
 	viper.SetDefault("UPLOAD_PATH", "./uploads")
 	viper.SetDefault("UPLOAD_ALLOWED_TYPES", []string{"image/jpeg", "image/png", "image/gif", "video/mp4", "application/pdf"})
+	viper.SetDefault("UPLOAD_POLICY_AVATAR", []string{"image/jpeg", "image/png", "image/gif", "image/webp"})
+	viper.SetDefault("UPLOAD_POLICY_DOCUMENT", []string{"application/pdf"})
+	viper.SetDefault("UPLOAD_POLICY_VIDEO", []string{"video/mp4", "video/webm"})
+	viper.SetDefault("UPLOAD_SCAN_ENABLED", false)
+	viper.SetDefault("UPLOAD_CLAMD_ADDRESS", "localhost:3310")
+	viper.SetDefault("UPLOAD_SCAN_TIMEOUT", "30s")
+	viper.SetDefault("UPLOAD_DEDUP", false)
+	viper.SetDefault("UPLOAD_FILENAME_STRATEGY", "random")
+	viper.SetDefault("UPLOAD_PUBLIC_FILES", false)
+	viper.SetDefault("UPLOAD_IMAGE_MAX_WIDTH", 8192)
+	viper.SetDefault("UPLOAD_IMAGE_MAX_HEIGHT", 8192)
+	viper.SetDefault("UPLOAD_IMAGE_MAX_PIXELS", 40000000)
+	viper.SetDefault("UPLOAD_AVATAR_SIZES", []string{"small:64", "medium:256", "large:512"})
+
+	// Import defaults
+	viper.SetDefault("IMPORT_MAX_FILE_SIZE", 5242880) // 5MB
+	viper.SetDefault("IMPORT_MAX_ROWS", 10000)
+	viper.SetDefault("IMPORT_BATCH_SIZE", 100)
 
 	// WebSocket defaults
 	viper.SetDefault("WS_READ_BUFFER_SIZE", 1024)
@@ -352,19 +866,31 @@ func setDefaults() {
 	viper.SetDefault("WS_MAX_MESSAGE_SIZE", 512000)
 	viper.SetDefault("WS_PING_PERIOD", "54s")
 	viper.SetDefault("WS_PONG_WAIT", "60s")
+	viper.SetDefault("WS_MAX_CONNECTIONS", 10000)
+	viper.SetDefault("WS_MAX_CONNECTIONS_PER_USER", 10)
+	viper.SetDefault("WS_MESSAGE_RATE", 10)
+	viper.SetDefault("WS_MESSAGE_BURST", 20)
+	viper.SetDefault("WS_OFFLINE_QUEUE_ENABLED", true)
+	viper.SetDefault("WS_OFFLINE_QUEUE_TTL", "24h")
+	viper.SetDefault("WS_OFFLINE_QUEUE_MAX_LENGTH", 100)
+	viper.SetDefault("WS_COMPRESSION", false)
+	viper.SetDefault("WS_COMPRESSION_LEVEL", 1)
 
 	// Stream defaults
 	viper.SetDefault("STREAM_CHUNK_SIZE", 1048576)
 	viper.SetDefault("STREAM_BUFFER_SIZE", 4194304)
 	viper.SetDefault("STREAM_PATH", "./videos")
+	viper.SetDefault("STREAM_SIGNED_URL_TTL", "1h")
+	viper.SetDefault("STREAM_HLS_SIGNED_URLS", false)
 
 	// CORS defaults
 	viper.SetDefault("CORS_ALLOWED_ORIGINS", []string{"*"})
 	viper.SetDefault("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"})
 	viper.SetDefault("CORS_ALLOWED_HEADERS", []string{"Origin", "Content-Type", "Accept", "Authorization"})
-	viper.SetDefault("CORS_EXPOSE_HEADERS", []string{"X-Total-Count", "X-Page", "X-Per-Page"})
+	viper.SetDefault("CORS_EXPOSE_HEADERS", []string{"X-Total-Count", "X-Page", "X-Per-Page", "X-Total-Pages", "Link"})
 	viper.SetDefault("CORS_ALLOW_CREDENTIALS", true)
 	viper.SetDefault("CORS_MAX_AGE", 86400)
+	viper.SetDefault("CORS_ROUTE_ORIGINS", []string{})
 
 	// Rate limit defaults
 	viper.SetDefault("RATE_LIMIT_ENABLED", true)
@@ -376,22 +902,75 @@ func setDefaults() {
 	viper.SetDefault("LOG_FORMAT", "json")
 	viper.SetDefault("LOG_OUTPUT", "stdout")
 	viper.SetDefault("LOG_FILE_PATH", "./logs/app.log")
+	viper.SetDefault("LOG_SKIP_PATHS", []string{"/health", "/metrics"})
+	viper.SetDefault("LOG_SAMPLE_PATHS", []string{})
+	viper.SetDefault("LOG_SAMPLE_RATE", 10)
+	viper.SetDefault("LOG_REDACT_KEYS", []string{
+		"password", "token", "secret", "authorization", "api_key", "credit_card", "ssn",
+	})
 
 	// Swagger defaults
 	viper.SetDefault("SWAGGER_ENABLED", true)
 	viper.SetDefault("SWAGGER_HOST", "localhost:8080")
 	viper.SetDefault("SWAGGER_BASE_PATH", "/api/v1")
+	viper.SetDefault("SWAGGER_PROTECTED", true)
+
+	// Cache defaults
+	viper.SetDefault("CACHE_SERVE_STALE_ON_ERROR", false)
+	viper.SetDefault("CACHE_STALE_TTL", "24h")
+
+	// Response defaults
+	viper.SetDefault("RESPONSE_LIST_ENVELOPE", true)
+	viper.SetDefault("RESPONSE_TIME_FORMAT", "rfc3339")
+	viper.SetDefault("RESPONSE_ID_FORMAT", "number")
+
+	// Pagination defaults
+	viper.SetDefault("PAGINATION_DEFAULT_PER_PAGE", 20)
+	viper.SetDefault("PAGINATION_MAX_PER_PAGE", 100)
+	viper.SetDefault("PAGINATION_REJECT_OVER_MAX", true)
+
+	// Compression defaults
+	viper.SetDefault("COMPRESSION_ENABLED", true)
+	viper.SetDefault("COMPRESSION_LEVEL", -1)
+	viper.SetDefault("COMPRESSION_MIN_SIZE", 1024)
+	viper.SetDefault("COMPRESSION_CONTENT_TYPES", []string{
+		"application/json", "application/problem+json", "application/xml", "application/javascript",
+		"text/plain", "text/html", "text/css", "text/javascript", "text/xml", "image/svg+xml",
+	})
 
 	// Monitoring defaults
 	viper.SetDefault("METRICS_ENABLED", true)
 	viper.SetDefault("METRICS_PATH", "/metrics")
+	viper.SetDefault("METRICS_DROP_UNMATCHED", false)
 	viper.SetDefault("HEALTH_CHECK_PATH", "/health")
+	viper.SetDefault("HEALTH_CHECK_TIMEOUT", "2s")
+	viper.SetDefault("HEALTH_DISK_MIN_FREE_PERCENT", 5)
+	viper.SetDefault("HEALTH_DISK_CRITICAL", false)
+	viper.SetDefault("GRPC_REFLECTION", true)
+	viper.SetDefault("PPROF_ENABLED", false)
+	viper.SetDefault("PPROF_ADDR", "127.0.0.1:6060")
 
 	// MongoDB defaults
 	viper.SetDefault("MONGODB_URI", "mongodb://localhost:27017")
 	viper.SetDefault("MONGODB_DATABASE", "boilerplate")
 	viper.SetDefault("MONGODB_CONNECT_TIMEOUT", "10s")
 	viper.SetDefault("MONGODB_MAX_POOL_SIZE", 100)
+
+	// Tracing defaults
+	viper.SetDefault("OTEL_ENABLED", false)
+	viper.SetDefault("OTEL_SERVICE_NAME", "boilerplate-api")
+	viper.SetDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	viper.SetDefault("OTEL_SAMPLING_RATIO", 1.0)
+
+	// Security header defaults
+	viper.SetDefault("SECURITY_HSTS_MAX_AGE", 63072000) // 2 years
+	viper.SetDefault("SECURITY_HSTS_INCLUDE_SUBDOMAINS", true)
+	viper.SetDefault("SECURITY_HSTS_PRELOAD", false)
+	viper.SetDefault("SECURITY_CSP", "default-src 'none'; frame-ancestors 'none'")
+	viper.SetDefault("SECURITY_SWAGGER_CSP", "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:")
+	viper.SetDefault("SECURITY_FRAME_OPTIONS", "DENY")
+	viper.SetDefault("SECURITY_REFERRER_POLICY", "strict-origin-when-cross-origin")
+	viper.SetDefault("SECURITY_PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=()")
 }
 
 // validate validates the configuration
@@ -404,14 +983,195 @@ func validate(cfg *Config) error {
 		return fmt.Errorf("DB_DRIVER is required")
 	}
 
+	if cfg.Database.ConnectAttempts < 1 || cfg.Redis.ConnectAttempts < 1 {
+		return fmt.Errorf("DB_CONNECT_ATTEMPTS and REDIS_CONNECT_ATTEMPTS must be at least 1")
+	}
+
+	if cfg.Database.ConnectRetryInterval <= 0 || cfg.Redis.ConnectRetryInterval <= 0 {
+		return fmt.Errorf("DB_CONNECT_RETRY_INTERVAL and REDIS_CONNECT_RETRY_INTERVAL must be positive")
+	}
+
+	if cfg.Server.ReadTimeout < 0 || cfg.Server.ReadHeaderTimeout < 0 || cfg.Server.WriteTimeout < 0 ||
+		cfg.Server.IdleTimeout < 0 || cfg.Server.StreamWriteTimeout < 0 || cfg.Server.UploadTimeout < 0 || cfg.Server.RequestTimeout < 0 {
+		return fmt.Errorf("SERVER_* and REQUEST_TIMEOUT timeouts must not be negative")
+	}
+
+	// The timeout response could not be written after the write deadline
+	if cfg.Server.RequestTimeout > 0 && cfg.Server.WriteTimeout > 0 && cfg.Server.RequestTimeout >= cfg.Server.WriteTimeout {
+		return fmt.Errorf("REQUEST_TIMEOUT must be shorter than SERVER_WRITE_TIMEOUT")
+	}
+
+	if cfg.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("SHUTDOWN_TIMEOUT must be positive")
+	}
+
+	if cfg.Server.MaxHeaderBytes <= 0 {
+		return fmt.Errorf("MAX_HEADER_BYTES must be positive")
+	}
+
+	if cfg.GRPC.MaxRecvMsgSize <= 0 || cfg.GRPC.MaxSendMsgSize <= 0 {
+		return fmt.Errorf("GRPC_MAX_RECV_MSG_SIZE and GRPC_MAX_SEND_MSG_SIZE must be positive")
+	}
+
+	if cfg.GRPC.KeepaliveMaxConnectionIdle < 0 || cfg.GRPC.KeepaliveTime <= 0 || cfg.GRPC.KeepaliveTimeout <= 0 || cfg.GRPC.KeepaliveMinTime < 0 {
+		return fmt.Errorf("GRPC_KEEPALIVE_TIME and GRPC_KEEPALIVE_TIMEOUT must be positive and the other GRPC_KEEPALIVE_* durations not negative")
+	}
+
+	if cfg.GRPC.DefaultDeadline < 0 {
+		return fmt.Errorf("GRPC_DEFAULT_DEADLINE must not be negative")
+	}
+
+	if cfg.TLS.Enabled && (cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE are required when TLS_ENABLED is true")
+	}
+
+	if !cfg.TLS.Enabled && cfg.TLS.ClientCAFile != "" {
+		return fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_ENABLED")
+	}
+
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("TRUSTED_PROXIES contains an invalid IP or CIDR: %s", proxy)
+		}
+	}
+
+	if cfg.Monitoring.HealthDiskMinFreePercent < 0 || cfg.Monitoring.HealthDiskMinFreePercent >= 100 {
+		return fmt.Errorf("HEALTH_DISK_MIN_FREE_PERCENT must be between 0 and 100")
+	}
+
+	if cfg.Monitoring.PprofEnabled {
+		_, port, err := net.SplitHostPort(cfg.Monitoring.PprofAddr)
+		if err != nil {
+			return fmt.Errorf("PPROF_ADDR must be a host:port address: %w", err)
+		}
+		if port == cfg.App.Port || port == cfg.App.GRPCPort {
+			return fmt.Errorf("PPROF_ADDR must not share APP_PORT or GRPC_PORT")
+		}
+	}
+
+	if cfg.Auth.PasswordResetTTL <= 0 || cfg.Auth.EmailVerificationTTL <= 0 {
+		return fmt.Errorf("AUTH_PASSWORD_RESET_TTL and AUTH_EMAIL_VERIFICATION_TTL must be positive")
+	}
+
+	if cfg.Auth.CacheTTL <= 0 {
+		return fmt.Errorf("AUTH_CACHE_TTL must be positive")
+	}
+
+	if !models.IsValidRole(cfg.Auth.DefaultRole) || cfg.Auth.DefaultRole == models.RoleAdmin {
+		return fmt.Errorf("AUTH_DEFAULT_ROLE must be user or moderator")
+	}
+
+	if cfg.Response.TimeFormat != "rfc3339" && cfg.Response.TimeFormat != "epoch_millis" {
+		return fmt.Errorf("RESPONSE_TIME_FORMAT must be rfc3339 or epoch_millis")
+	}
+	if cfg.Response.IDFormat != "number" && cfg.Response.IDFormat != "string" {
+		return fmt.Errorf("RESPONSE_ID_FORMAT must be number or string")
+	}
+
+	switch cfg.Password.Hash {
+	case "bcrypt", "argon2id":
+	default:
+		return fmt.Errorf("PASSWORD_HASH must be bcrypt or argon2id")
+	}
+
+	if cfg.Password.BcryptCost < 4 || cfg.Password.BcryptCost > 31 {
+		return fmt.Errorf("PASSWORD_BCRYPT_COST must be between 4 and 31")
+	}
+
+	if cfg.Password.Argon2Parallelism < 1 || cfg.Password.Argon2Parallelism > 255 {
+		return fmt.Errorf("PASSWORD_ARGON2_PARALLELISM must be between 1 and 255")
+	}
+
+	if cfg.Password.Argon2Iterations == 0 || cfg.Password.Argon2Memory < 8*uint32(cfg.Password.Argon2Parallelism) {
+		return fmt.Errorf("PASSWORD_ARGON2_ITERATIONS must be positive and PASSWORD_ARGON2_MEMORY at least 8 KiB per thread")
+	}
+
+	if cfg.Import.MaxFileSize <= 0 || cfg.Import.MaxRows <= 0 || cfg.Import.BatchSize <= 0 {
+		return fmt.Errorf("IMPORT_MAX_FILE_SIZE, IMPORT_MAX_ROWS and IMPORT_BATCH_SIZE must be positive")
+	}
+
+	if cfg.Log.SampleRate < 1 {
+		return fmt.Errorf("LOG_SAMPLE_RATE must be at least 1")
+	}
+
+	if cfg.Pagination.DefaultPerPage < 1 || cfg.Pagination.MaxPerPage < cfg.Pagination.DefaultPerPage {
+		return fmt.Errorf("PAGINATION_DEFAULT_PER_PAGE must be positive and PAGINATION_MAX_PER_PAGE at least PAGINATION_DEFAULT_PER_PAGE")
+	}
+
+	if cfg.Compression.Level < -2 || cfg.Compression.Level > 9 {
+		return fmt.Errorf("COMPRESSION_LEVEL must be between -2 and 9")
+	}
+
 	if cfg.JWT.Secret == "" || len(cfg.JWT.Secret) < 32 {
 		return fmt.Errorf("JWT_SECRET must be at least 32 characters")
 	}
 
+	if cfg.JWT.Leeway < 0 || (cfg.JWT.Leeway >= cfg.JWT.Expiry && cfg.JWT.Expiry > 0) {
+		return fmt.Errorf("JWT_LEEWAY must not be negative and must be shorter than JWT_EXPIRY")
+	}
+
+	if cfg.Stream.SigningKey != "" && len(cfg.Stream.SigningKey) < 32 {
+		return fmt.Errorf("STREAM_SIGNING_KEY must be at least 32 characters")
+	}
+
+	if cfg.Stream.SignedURLTTL <= 0 {
+		return fmt.Errorf("STREAM_SIGNED_URL_TTL must be positive")
+	}
+
 	if cfg.Encryption.Key != "" && len(cfg.Encryption.Key) != 32 {
 		return fmt.Errorf("ENCRYPTION_KEY must be exactly 32 characters")
 	}
 
+	if cfg.WebSocket.MessageRate < 0 || (cfg.WebSocket.MessageRate > 0 && cfg.WebSocket.MessageBurst < 1) {
+		return fmt.Errorf("WS_MESSAGE_RATE must not be negative and WS_MESSAGE_BURST must be at least 1")
+	}
+
+	if cfg.WebSocket.CompressionLevel < -2 || cfg.WebSocket.CompressionLevel > 9 {
+		return fmt.Errorf("WS_COMPRESSION_LEVEL must be between -2 and 9")
+	}
+
+	if cfg.Upload.MaxSize <= 0 || cfg.Upload.MaxTotalSize < cfg.Upload.MaxSize || cfg.Upload.MaxMultipartMemory <= 0 {
+		return fmt.Errorf("UPLOAD_MAX_SIZE and MAX_MULTIPART_MEMORY must be positive and UPLOAD_MAX_TOTAL_SIZE at least UPLOAD_MAX_SIZE")
+	}
+
+	if cfg.Upload.MaxConcurrentPerUser < 0 {
+		return fmt.Errorf("UPLOAD_MAX_CONCURRENT_PER_USER must not be negative")
+	}
+
+	if cfg.Upload.ImageMaxWidth <= 0 || cfg.Upload.ImageMaxHeight <= 0 || cfg.Upload.ImageMaxPixels <= 0 {
+		return fmt.Errorf("UPLOAD_IMAGE_MAX_WIDTH, UPLOAD_IMAGE_MAX_HEIGHT and UPLOAD_IMAGE_MAX_PIXELS must be positive")
+	}
+
+	switch cfg.Upload.FilenameStrategy {
+	case "random", "slug", "uuid":
+	default:
+		return fmt.Errorf("UPLOAD_FILENAME_STRATEGY must be one of random, slug, uuid")
+	}
+
+	switch cfg.Session.Store {
+	case "redis", "memory":
+	default:
+		return fmt.Errorf("SESSION_STORE must be one of redis, memory")
+	}
+
+	switch cfg.Session.CookieSameSite {
+	case "lax", "strict", "none":
+	default:
+		return fmt.Errorf("SESSION_COOKIE_SAME_SITE must be one of lax, strict, none")
+	}
+
+	if cfg.Session.CookieSameSite == "none" && !cfg.Session.CookieSecure {
+		return fmt.Errorf("SESSION_COOKIE_SECURE must be enabled when SESSION_COOKIE_SAME_SITE is none")
+	}
+
+	if cfg.SwaggerRequiresAuth() && (cfg.Swagger.Username == "" || cfg.Swagger.Password == "") {
+		return fmt.Errorf("SWAGGER_USERNAME and SWAGGER_PASSWORD are required when SWAGGER_PROTECTED is enabled")
+	}
+
+	if cfg.Tracing.SamplingRatio < 0 || cfg.Tracing.SamplingRatio > 1 {
+		return fmt.Errorf("OTEL_SAMPLING_RATIO must be between 0 and 1")
+	}
+
 	// Create required directories
 	dirs := []string{cfg.Upload.Path, cfg.Stream.Path}
 	for _, dir := range dirs {
@@ -423,11 +1183,129 @@ func validate(cfg *Config) error {
 	return nil
 }
 
+// splitList splits comma separated entries, since environment values are
+// not split on commas by viper
+func splitList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseCORSRoutePolicies groups "prefix=origin" entries by prefix, ordered
+// longest prefix first so the most specific policy matches
+func parseCORSRoutePolicies(entries []string) ([]CORSRoutePolicy, error) {
+	var policies []CORSRoutePolicy
+	index := make(map[string]int)
+	for _, entry := range entries {
+		prefix, origin, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimRight(strings.TrimSpace(prefix), "/")
+		origin = strings.TrimSpace(origin)
+		if !ok || !strings.HasPrefix(prefix, "/") || origin == "" {
+			return nil, fmt.Errorf("CORS_ROUTE_ORIGINS entries must look like /path/prefix=origin: %s", entry)
+		}
+
+		i, seen := index[prefix]
+		if !seen {
+			i = len(policies)
+			index[prefix] = i
+			policies = append(policies, CORSRoutePolicy{Prefix: prefix})
+		}
+		policies[i].AllowedOrigins = append(policies[i].AllowedOrigins, origin)
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool {
+		return len(policies[i].Prefix) > len(policies[j].Prefix)
+	})
+	return policies, nil
+}
+
+// parseAvatarSizes parses "name:pixels" entries, keeping their order
+func parseAvatarSizes(entries []string) ([]AvatarSize, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("UPLOAD_AVATAR_SIZES must list at least one size")
+	}
+
+	sizes := make([]AvatarSize, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || !isAvatarSizeName(name) || err != nil || size < 1 || size > 4096 {
+			return nil, fmt.Errorf("UPLOAD_AVATAR_SIZES entries must look like name:pixels, with a name of letters, digits or _ and 1 to 4096 pixels: %s", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("UPLOAD_AVATAR_SIZES lists %s more than once", name)
+		}
+		seen[name] = true
+		sizes = append(sizes, AvatarSize{Name: name, Size: size})
+	}
+	return sizes, nil
+}
+
+// isAvatarSizeName reports whether name can be used as a variant name and
+// in file names
+func isAvatarSizeName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// CORSAllowedOrigins returns the origins allowed for a request path: those
+// of the longest CORS_ROUTE_ORIGINS prefix containing the path, or
+// CORS_ALLOWED_ORIGINS when no prefix does. Prefixes match whole path
+// segments, so /api/v1/admin does not cover /api/v1/administrators.
+func (c *Config) CORSAllowedOrigins(path string) []string {
+	for _, policy := range c.CORS.RoutePolicies {
+		if path == policy.Prefix || strings.HasPrefix(path, policy.Prefix+"/") {
+			return policy.AllowedOrigins
+		}
+	}
+	return c.CORS.AllowedOrigins
+}
+
+// UploadAllowedTypes returns the MIME types an upload policy accepts. The
+// default policy ("") and policies left empty use UPLOAD_ALLOWED_TYPES.
+func (c *Config) UploadAllowedTypes(policy string) []string {
+	if types := c.Upload.Policies[policy]; len(types) > 0 {
+		return types
+	}
+	return c.Upload.AllowedTypes
+}
+
+// UserCacheTTL returns how long cached user data may be served: AUTH_CACHE_TTL
+// capped at the access token lifetime, so a cached role or active flag never
+// outlives the tokens it was read for
+func (c *Config) UserCacheTTL() time.Duration {
+	if c.JWT.Expiry > 0 && c.JWT.Expiry < c.Auth.CacheTTL {
+		return c.JWT.Expiry
+	}
+	return c.Auth.CacheTTL
+}
+
 // IsProduction returns true if the application is running in production
 func (c *Config) IsProduction() bool {
 	return c.App.Env == "production"
 }
 
+// GRPCReflectionEnabled reports whether the gRPC reflection service should
+// be registered: only when GRPC_REFLECTION is on and outside production
+func (c *Config) GRPCReflectionEnabled() bool {
+	return c.Monitoring.GRPCReflection && !c.IsProduction()
+}
+
 // IsDevelopment returns true if the application is running in development
 func (c *Config) IsDevelopment() bool {
 	return c.App.Env == "development"
@@ -437,3 +1315,9 @@ func (c *Config) IsDevelopment() bool {
 func (c *Config) IsDebug() bool {
 	return c.App.Debug
 }
+
+// SwaggerRequiresAuth returns true if Swagger UI must be served behind credentials.
+// Swagger stays open in development regardless of SWAGGER_PROTECTED.
+func (c *Config) SwaggerRequiresAuth() bool {
+	return c.Swagger.Enabled && c.Swagger.Protected && !c.IsDevelopment()
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadWithEnv loads the configuration from the environment with env
+// overriding the defaults, returning Load's error
+func loadWithEnv(t *testing.T, env map[string]string) (*Config, error) {
+	t.Helper()
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("JWT_SECRET", "test-secret-that-is-at-least-32-characters")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	return Load()
+}
+
+func TestGRPCReflectionEnabled(t *testing.T) {
+	tests := []struct {
+		env        string
+		reflection string
+		want       bool
+	}{
+		{"development", "true", true},
+		{"development", "false", false},
+		{"staging", "true", true},
+		{"production", "true", false},
+		{"production", "false", false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{
+			App:        AppConfig{Env: tt.env},
+			Monitoring: MonitoringConfig{GRPCReflection: tt.reflection == "true"},
+		}
+		if got := cfg.GRPCReflectionEnabled(); got != tt.want {
+			t.Errorf("APP_ENV=%s GRPC_REFLECTION=%s: reflection = %v, want %v", tt.env, tt.reflection, got, tt.want)
+		}
+	}
+
+	cfg, err := loadWithEnv(t, nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.Monitoring.GRPCReflection {
+		t.Error("GRPC_REFLECTION defaults to off, want on outside production")
+	}
+}
+
+func TestPprofAddrValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"disabled", map[string]string{"PPROF_ADDR": "not an address"}, ""},
+		{"default address", map[string]string{"PPROF_ENABLED": "true"}, ""},
+		{"not host:port", map[string]string{"PPROF_ENABLED": "true", "PPROF_ADDR": "6060"}, "PPROF_ADDR must be a host:port"},
+		{"API port", map[string]string{"PPROF_ENABLED": "true", "APP_PORT": "8080", "PPROF_ADDR": "127.0.0.1:8080"}, "must not share"},
+		{"gRPC port", map[string]string{"PPROF_ENABLED": "true", "GRPC_PORT": "50051", "PPROF_ADDR": ":50051"}, "must not share"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadWithEnv(t, tt.env)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Load: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Load = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUploadSizeValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"defaults", nil, false},
+		{"total equal to the per-file limit", map[string]string{"UPLOAD_MAX_SIZE": "1024", "UPLOAD_MAX_TOTAL_SIZE": "1024"}, false},
+		{"total below the per-file limit", map[string]string{"UPLOAD_MAX_SIZE": "2048", "UPLOAD_MAX_TOTAL_SIZE": "1024"}, true},
+		{"no multipart memory", map[string]string{"MAX_MULTIPART_MEMORY": "0"}, true},
+		{"no per-file limit", map[string]string{"UPLOAD_MAX_SIZE": "0"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWithEnv(t, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Upload.MaxMultipartMemory <= 0 {
+				t.Errorf("MaxMultipartMemory = %d", cfg.Upload.MaxMultipartMemory)
+			}
+		})
+	}
+}
+
+func TestUserCacheTTLCappedAtTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"defaults", nil, 15 * time.Minute, false},
+		{"shorter than the token", map[string]string{"AUTH_CACHE_TTL": "5m", "JWT_EXPIRY": "1h"}, 5 * time.Minute, false},
+		{"longer than the token", map[string]string{"AUTH_CACHE_TTL": "24h", "JWT_EXPIRY": "10m"}, 10 * time.Minute, false},
+		{"equal to the token", map[string]string{"AUTH_CACHE_TTL": "30m", "JWT_EXPIRY": "30m"}, 30 * time.Minute, false},
+		{"zero", map[string]string{"AUTH_CACHE_TTL": "0s"}, 0, true},
+		{"negative", map[string]string{"AUTH_CACHE_TTL": "-1m"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWithEnv(t, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load = %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := cfg.UserCacheTTL(); got != tt.want || got > cfg.JWT.Expiry {
+				t.Errorf("UserCacheTTL = %v with JWT_EXPIRY %v, want %v", got, cfg.JWT.Expiry, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLSValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"plaintext by default", nil, false},
+		{"certificate and key", map[string]string{"TLS_ENABLED": "true", "TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem"}, false},
+		{"client CA", map[string]string{"TLS_ENABLED": "true", "TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem", "TLS_CLIENT_CA_FILE": "ca.pem"}, false},
+		{"no key", map[string]string{"TLS_ENABLED": "true", "TLS_CERT_FILE": "cert.pem"}, true},
+		{"no certificate", map[string]string{"TLS_ENABLED": "true", "TLS_KEY_FILE": "key.pem"}, true},
+		{"client CA without TLS", map[string]string{"TLS_CLIENT_CA_FILE": "ca.pem"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWithEnv(t, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.TLS.Enabled != (tt.env["TLS_ENABLED"] == "true") {
+				t.Errorf("TLS.Enabled = %v", cfg.TLS.Enabled)
+			}
+		})
+	}
+}
+
+func TestPaginationValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"defaults", nil, false},
+		{"default equal to the maximum", map[string]string{"PAGINATION_DEFAULT_PER_PAGE": "50", "PAGINATION_MAX_PER_PAGE": "50"}, false},
+		{"default over the maximum", map[string]string{"PAGINATION_DEFAULT_PER_PAGE": "51", "PAGINATION_MAX_PER_PAGE": "50"}, true},
+		{"no default", map[string]string{"PAGINATION_DEFAULT_PER_PAGE": "0"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWithEnv(t, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && tt.env == nil && (cfg.Pagination.DefaultPerPage != 20 || cfg.Pagination.MaxPerPage != 100 || !cfg.Pagination.RejectOverMax) {
+				t.Errorf("default pagination = %+v, want 20 per page, at most 100, rejecting more", cfg.Pagination)
+			}
+		})
+	}
+}
+
+func TestDefaultRoleValidation(t *testing.T) {
+	tests := []struct {
+		role     string
+		wantRole string
+		wantErr  bool
+	}{
+		{"", "user", false},
+		{"user", "user", false},
+		{"Moderator", "moderator", false},
+		{"admin", "", true},
+		{"superadmin", "", true},
+	}
+	for _, tt := range tests {
+		name := tt.role
+		if name == "" {
+			name = "unset"
+		}
+		t.Run(name, func(t *testing.T) {
+			env := map[string]string{}
+			if tt.role != "" {
+				env["AUTH_DEFAULT_ROLE"] = tt.role
+			}
+			cfg, err := loadWithEnv(t, env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Auth.DefaultRole != tt.wantRole {
+				t.Errorf("Auth.DefaultRole = %q, want %q", cfg.Auth.DefaultRole, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestResponseFormatValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"defaults", nil, false},
+		{"epoch millis and string IDs", map[string]string{"RESPONSE_TIME_FORMAT": "Epoch_Millis", "RESPONSE_ID_FORMAT": "STRING"}, false},
+		{"unknown time format", map[string]string{"RESPONSE_TIME_FORMAT": "unix"}, true},
+		{"unknown ID format", map[string]string{"RESPONSE_ID_FORMAT": "hex"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadWithEnv(t, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && tt.env != nil && (cfg.Response.TimeFormat != "epoch_millis" || cfg.Response.IDFormat != "string") {
+				t.Errorf("Response = %+v, want the lower-cased formats", cfg.Response)
+			}
+		})
+	}
+}
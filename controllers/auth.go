@@ -1,6 +1,11 @@
 package controllers
 
 import (
+	"errors"
+	"io"
+	"net/http"
+
+	"go-api-boilerplate/config"
 	middleware "go-api-boilerplate/middlewares"
 	"go-api-boilerplate/models"
 	"go-api-boilerplate/services"
@@ -10,15 +15,17 @@ import (
 )
 
 type AuthController struct {
-	authService *services.AuthService
-	userService *services.UserService
+	authService  *services.AuthService
+	userService  *services.UserService
+	sessionStore services.SessionStore
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthController(authService *services.AuthService, userService *services.UserService) *AuthController {
+func NewAuthController(authService *services.AuthService, userService *services.UserService, sessionStore services.SessionStore) *AuthController {
 	return &AuthController{
-		authService: authService,
-		userService: userService,
+		authService:  authService,
+		userService:  userService,
+		sessionStore: sessionStore,
 	}
 }
 
@@ -40,26 +47,23 @@ func (h *AuthController) Register(c *gin.Context) {
 		return
 	}
 
-	// Check if user already exists
-	exists, err := h.userService.UserExistsByEmail(input.Email)
-	if err != nil {
-		utils.InternalServerErrorResponse(c, "Failed to check user existence")
-		return
-	}
-	if exists {
-		utils.ConflictResponse(c, "Email already registered", nil)
-		return
-	}
-
-	// Create user
-	user, err := h.authService.Register(&input)
+	// Create user; emails are compared case-insensitively
+	user, err := h.authService.Register(c.Request.Context(), &input)
 	if err != nil {
+		if errors.Is(err, services.ErrAccountDeleted) {
+			utils.ErrorResponse(c, http.StatusConflict, "An account with this email was deleted; ask an administrator to restore it", "ACCOUNT_DELETED", nil)
+			return
+		}
+		if errors.Is(err, services.ErrUserAlreadyExists) {
+			utils.ConflictResponse(c, "Email already registered", nil)
+			return
+		}
 		utils.InternalServerErrorResponse(c, "Failed to register user")
 		return
 	}
 
 	// Generate tokens
-	tokens, err := h.authService.GenerateTokens(user)
+	tokens, err := h.authService.GenerateTokens(c.Request.Context(), user)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate tokens")
 		return
@@ -68,7 +72,7 @@ func (h *AuthController) Register(c *gin.Context) {
 	// Prepare response
 	response := models.LoginResponse{
 		User:   user.ToResponse(),
-		Tokens: tokens,
+		Tokens: deliverRefreshToken(c, tokens),
 	}
 
 	utils.CreatedResponse(c, "Registration successful", response)
@@ -93,7 +97,7 @@ func (h *AuthController) Login(c *gin.Context) {
 	}
 
 	// Authenticate user
-	user, err := h.authService.Login(input.Email, input.Password, c.ClientIP())
+	user, err := h.authService.Login(c.Request.Context(), input.Email, input.Password, c.ClientIP())
 	if err != nil {
 		if err == services.ErrInvalidCredentials {
 			utils.UnauthorizedResponse(c, "Invalid email or password")
@@ -104,7 +108,7 @@ func (h *AuthController) Login(c *gin.Context) {
 	}
 
 	// Generate tokens
-	tokens, err := h.authService.GenerateTokens(user)
+	tokens, err := h.authService.GenerateTokens(c.Request.Context(), user)
 	if err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to generate tokens")
 		return
@@ -113,32 +117,172 @@ func (h *AuthController) Login(c *gin.Context) {
 	// Prepare response
 	response := models.LoginResponse{
 		User:   user.ToResponse(),
-		Tokens: tokens,
+		Tokens: deliverRefreshToken(c, tokens),
 	}
 
 	utils.SuccessResponse(c, "Login successful", response)
 }
 
+// SessionLogin godoc
+// @Summary Login with a session cookie
+// @Description Authenticate and start a cookie-based session instead of issuing bearer tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param input body models.LoginInput true "Login credentials"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Router /auth/session [post]
+func (h *AuthController) SessionLogin(c *gin.Context) {
+	var input models.LoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
+	// Authenticate user
+	user, err := h.authService.Login(c.Request.Context(), input.Email, input.Password, c.ClientIP())
+	if err != nil {
+		if err == services.ErrInvalidCredentials {
+			utils.UnauthorizedResponse(c, "Invalid email or password")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Login failed")
+		return
+	}
+
+	// Create session
+	sessionID, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create session")
+		return
+	}
+
+	cfg := config.Get()
+	sessionData := map[string]interface{}{
+		"user_id":   user.ID,
+		"email":     user.Email,
+		"name":      user.Name,
+		"role":      user.Role,
+		"is_active": user.IsActive,
+	}
+	if err := h.sessionStore.Set(sessionID, sessionData, cfg.Session.TTL); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to create session")
+		return
+	}
+	// Tracked sessions are ended when the user is deleted
+	if err := h.sessionStore.Track(user.ID, sessionID); err != nil {
+		_ = h.sessionStore.Delete(sessionID)
+		utils.InternalServerErrorResponse(c, "Failed to create session")
+		return
+	}
+
+	setSessionCookie(c, sessionID, int(cfg.Session.TTL.Seconds()))
+
+	utils.SuccessResponse(c, "Login successful", user.ToResponse())
+}
+
+// SessionLogout godoc
+// @Summary Logout a cookie session
+// @Description Destroy the current session and clear the session cookie
+// @Tags auth
+// @Success 200 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Router /auth/session [delete]
+func (h *AuthController) SessionLogout(c *gin.Context) {
+	sessionID := c.GetString("session_id")
+	if err := h.sessionStore.Delete(sessionID); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to logout")
+		return
+	}
+
+	setSessionCookie(c, "", -1)
+
+	utils.SuccessResponse(c, "Logged out successfully", nil)
+}
+
+// setSessionCookie writes the session cookie; a negative maxAge clears it
+func setSessionCookie(c *gin.Context, sessionID string, maxAge int) {
+	setAuthCookie(c, config.Get().Session.CookieName, sessionID, "/", maxAge)
+}
+
+// refreshCookieName and refreshCookiePath scope the refresh cookie to the
+// auth routes so it is not sent with every API request
+const (
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/api/v1/auth"
+)
+
+// deliverRefreshToken moves the refresh token into the refresh cookie when
+// AUTH_REFRESH_COOKIE is enabled, keeping it out of reach of page scripts,
+// and returns the tokens to put in the response body
+func deliverRefreshToken(c *gin.Context, tokens *models.AuthTokens) *models.AuthTokens {
+	cfg := config.Get()
+	if !cfg.Auth.RefreshCookie {
+		return tokens
+	}
+
+	setAuthCookie(c, refreshCookieName, tokens.RefreshToken, refreshCookiePath, int(cfg.JWT.RefreshExpiry.Seconds()))
+
+	body := *tokens
+	body.RefreshToken = ""
+	return &body
+}
+
+// clearRefreshCookie expires the refresh cookie when cookie delivery is on
+func clearRefreshCookie(c *gin.Context) {
+	if config.Get().Auth.RefreshCookie {
+		setAuthCookie(c, refreshCookieName, "", refreshCookiePath, -1)
+	}
+}
+
+// setAuthCookie writes an HttpOnly cookie with the session cookie's domain,
+// Secure and SameSite settings; a negative maxAge clears it
+func setAuthCookie(c *gin.Context, name, value, path string, maxAge int) {
+	cfg := config.Get()
+
+	sameSite := http.SameSiteLaxMode
+	switch cfg.Session.CookieSameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	c.SetSameSite(sameSite)
+	c.SetCookie(name, value, maxAge, path, cfg.Session.CookieDomain, cfg.Session.CookieSecure, true)
+}
+
 // RefreshToken godoc
 // @Summary Refresh access token
-// @Description Refresh access token using refresh token
+// @Description Refresh access token using refresh token from the body or, when AUTH_REFRESH_COOKIE is enabled, the refresh cookie
 // @Tags auth
 // @Accept json
 // @Produce json
-// @Param input body models.RefreshTokenInput true "Refresh token"
+// @Param input body models.RefreshTokenInput false "Refresh token"
 // @Success 200 {object} models.AuthTokens
 // @Failure 400 {object} utils.Response
 // @Failure 401 {object} utils.Response
 // @Router /auth/refresh [post]
 func (h *AuthController) RefreshToken(c *gin.Context) {
 	var input models.RefreshTokenInput
-	if err := c.ShouldBindJSON(&input); err != nil {
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
 		utils.ValidationErrorResponse(c, err.Error())
 		return
 	}
 
+	// Browser clients send the refresh token in the cookie instead
+	if input.RefreshToken == "" && config.Get().Auth.RefreshCookie {
+		input.RefreshToken, _ = c.Cookie(refreshCookieName)
+	}
+	if input.RefreshToken == "" {
+		utils.ValidationErrorResponse(c, "refresh_token is required")
+		return
+	}
+
 	// Refresh tokens
-	tokens, err := h.authService.RefreshTokens(input.RefreshToken)
+	tokens, err := h.authService.RefreshTokens(c.Request.Context(), input.RefreshToken)
 	if err != nil {
 		if err == services.ErrInvalidToken {
 			utils.UnauthorizedResponse(c, "Invalid refresh token")
@@ -148,7 +292,7 @@ func (h *AuthController) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	utils.SuccessResponse(c, "Token refreshed successfully", tokens)
+	utils.SuccessResponse(c, "Token refreshed successfully", deliverRefreshToken(c, tokens))
 }
 
 // Logout godoc
@@ -172,14 +316,40 @@ func (h *AuthController) Logout(c *gin.Context) {
 	token, _ := utils.ExtractTokenFromHeader(authHeader)
 
 	// Logout user
-	if err := h.authService.Logout(userID, token); err != nil {
+	if err := h.authService.Logout(c.Request.Context(), userID, token); err != nil {
 		utils.InternalServerErrorResponse(c, "Failed to logout")
 		return
 	}
 
+	clearRefreshCookie(c)
 	utils.SuccessResponse(c, "Logged out successfully", nil)
 }
 
+// LogoutAll godoc
+// @Summary Logout from all devices
+// @Description Revoke every refresh and access token issued to the user
+// @Tags auth
+// @Security Bearer
+// @Success 200 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Router /auth/logout-all [post]
+func (h *AuthController) LogoutAll(c *gin.Context) {
+	// Get user ID from context
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "")
+		return
+	}
+
+	if err := h.authService.LogoutAll(c.Request.Context(), userID); err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to logout from all devices")
+		return
+	}
+
+	clearRefreshCookie(c)
+	utils.SuccessResponse(c, "Logged out from all devices", nil)
+}
+
 // ChangePassword godoc
 // @Summary Change password
 // @Description Change user password
@@ -207,7 +377,7 @@ func (h *AuthController) ChangePassword(c *gin.Context) {
 	}
 
 	// Change password
-	if err := h.authService.ChangePassword(userID, input.OldPassword, input.NewPassword); err != nil {
+	if err := h.authService.ChangePassword(c.Request.Context(), userID, input.OldPassword, input.NewPassword); err != nil {
 		if err == services.ErrInvalidCredentials {
 			utils.BadRequestResponse(c, "Current password is incorrect", nil)
 			return
@@ -239,7 +409,7 @@ func (h *AuthController) ForgotPassword(c *gin.Context) {
 	}
 
 	// Initiate password reset
-	if err := h.authService.ForgotPassword(input.Email); err != nil {
+	if err := h.authService.ForgotPassword(c.Request.Context(), input.Email); err != nil {
 		// Don't reveal if email exists or not
 		utils.SuccessResponse(c, "If the email exists, a password reset link has been sent", nil)
 		return
@@ -270,7 +440,7 @@ func (h *AuthController) ResetPassword(c *gin.Context) {
 	}
 
 	// Reset password
-	if err := h.authService.ResetPassword(input.Token, input.NewPassword); err != nil {
+	if err := h.authService.ResetPassword(c.Request.Context(), input.Token, input.NewPassword); err != nil {
 		if err == services.ErrInvalidToken {
 			utils.BadRequestResponse(c, "Invalid or expired reset token", nil)
 			return
@@ -298,7 +468,7 @@ func (h *AuthController) VerifyEmail(c *gin.Context) {
 	}
 
 	// Verify email
-	if err := h.authService.VerifyEmail(token); err != nil {
+	if err := h.authService.VerifyEmail(c.Request.Context(), token); err != nil {
 		if err == services.ErrInvalidToken {
 			utils.BadRequestResponse(c, "Invalid or expired verification token", nil)
 			return
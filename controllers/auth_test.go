@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+)
+
+// newTestAuthRouter serves the token routes against a throwaway SQLite
+// database with env overriding the configuration. Logout is made as the
+// user in the X-Test-User header, standing in for AuthMiddleware.
+func newTestAuthRouter(t *testing.T, env map[string]string) *gin.Engine {
+	t.Helper()
+
+	db, err := database.Connect(loadTestConfig(t, env))
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	handler := NewAuthController(services.NewAuthService(db, nil), services.NewUserService(db, nil), nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if id, err := strconv.ParseUint(c.GetHeader("X-Test-User"), 10, 64); err == nil {
+			c.Set(utils.ContextKeyUserID, uint(id))
+		}
+	})
+	router.POST("/api/v1/auth/register", handler.Register)
+	router.POST("/api/v1/auth/login", handler.Login)
+	router.POST("/api/v1/auth/refresh", handler.RefreshToken)
+	router.POST("/api/v1/auth/logout", handler.Logout)
+	return router
+}
+
+// postAuth posts body, if any, to an auth route with cookies attached and
+// decodes the tokens of the response
+func postAuth(t *testing.T, router *gin.Engine, path string, body any, cookies ...*http.Cookie) (*httptest.ResponseRecorder, *models.LoginResponse) {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	var response struct {
+		Data models.LoginResponse `json:"data"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder, &response.Data
+}
+
+// refreshCookie returns the refresh cookie set by a response, or nil
+func refreshCookie(recorder *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == refreshCookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestRefreshCookie(t *testing.T) {
+	router := newTestAuthRouter(t, map[string]string{
+		"AUTH_REFRESH_COOKIE":      "true",
+		"SESSION_COOKIE_SAME_SITE": "strict",
+	})
+	credentials := models.LoginInput{Email: "cookie@example.com", Password: "Password123!"}
+
+	recorder, registered := postAuth(t, router, "/api/v1/auth/register", models.RegisterInput{
+		Email: credentials.Email, Password: credentials.Password, ConfirmPassword: credentials.Password, Name: "Cookie",
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", recorder.Code, recorder.Body)
+	}
+	if refreshCookie(recorder) == nil {
+		t.Error("register: no refresh cookie")
+	}
+
+	recorder, login := postAuth(t, router, "/api/v1/auth/login", credentials)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", recorder.Code, recorder.Body)
+	}
+	cookie := refreshCookie(recorder)
+	if cookie == nil {
+		t.Fatal("login: no refresh cookie")
+	}
+	if cookie.Value == "" || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode || cookie.Path != refreshCookiePath || cookie.MaxAge <= 0 {
+		t.Errorf("login: cookie = %+v, want an HttpOnly, Secure, SameSite=Strict cookie on %s", cookie, refreshCookiePath)
+	}
+	if login.Tokens == nil || login.Tokens.AccessToken == "" || login.Tokens.RefreshToken != "" {
+		t.Errorf("login: tokens in the body = %+v, want the access token only", login.Tokens)
+	}
+
+	recorder, _ = postAuth(t, router, "/api/v1/auth/refresh", nil)
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("refresh without cookie or body: status %d, want %d", recorder.Code, http.StatusUnprocessableEntity)
+	}
+
+	recorder, _ = postAuth(t, router, "/api/v1/auth/refresh", nil, cookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("refresh from the cookie: status %d: %s", recorder.Code, recorder.Body)
+	}
+	rotated := refreshCookie(recorder)
+	if rotated == nil || rotated.Value == "" || rotated.Value == cookie.Value {
+		t.Errorf("refresh from the cookie: cookie = %+v, want a new refresh token", rotated)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/logout", nil)
+	req.Header.Set("X-Test-User", strconv.FormatUint(uint64(registered.User.ID), 10))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("logout: status %d: %s", recorder.Code, recorder.Body)
+	}
+	cleared := refreshCookie(recorder)
+	if cleared == nil || cleared.Value != "" || cleared.MaxAge >= 0 || cleared.Path != refreshCookiePath {
+		t.Errorf("logout: cookie = %+v, want the refresh cookie cleared", cleared)
+	}
+
+	if recorder, _ = postAuth(t, router, "/api/v1/auth/refresh", nil, rotated); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: status %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRefreshTokenInBodyWithoutCookie(t *testing.T) {
+	router := newTestAuthRouter(t, nil)
+	credentials := models.LoginInput{Email: "body@example.com", Password: "Password123!"}
+
+	recorder, _ := postAuth(t, router, "/api/v1/auth/register", models.RegisterInput{
+		Email: credentials.Email, Password: credentials.Password, ConfirmPassword: credentials.Password, Name: "Body",
+	})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", recorder.Code, recorder.Body)
+	}
+	recorder, login := postAuth(t, router, "/api/v1/auth/login", credentials)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", recorder.Code, recorder.Body)
+	}
+	if refreshCookie(recorder) != nil {
+		t.Error("login: refresh cookie set while AUTH_REFRESH_COOKIE is off")
+	}
+	if login.Tokens == nil || login.Tokens.RefreshToken == "" {
+		t.Fatalf("login: tokens in the body = %+v, want a refresh token", login.Tokens)
+	}
+
+	// A stray cookie is ignored while cookie delivery is off
+	stray := &http.Cookie{Name: refreshCookieName, Value: login.Tokens.RefreshToken}
+	if recorder, _ = postAuth(t, router, "/api/v1/auth/refresh", nil, stray); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("refresh from a cookie: status %d, want %d", recorder.Code, http.StatusUnprocessableEntity)
+	}
+
+	recorder, _ = postAuth(t, router, "/api/v1/auth/refresh", models.RefreshTokenInput{RefreshToken: login.Tokens.RefreshToken})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("refresh from the body: status %d: %s", recorder.Code, recorder.Body)
+	}
+	if refreshCookie(recorder) != nil {
+		t.Error("refresh: refresh cookie set while AUTH_REFRESH_COOKIE is off")
+	}
+}
+
+func TestRegisterAssignsDefaultRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantRole string
+	}{
+		{"default", nil, models.RoleUser},
+		{"configured", map[string]string{"AUTH_DEFAULT_ROLE": "moderator"}, models.RoleModerator},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestAuthRouter(t, tt.env)
+
+			// A role in the request is ignored
+			recorder, registered := postAuth(t, router, "/api/v1/auth/register", map[string]string{
+				"email":            "role@example.com",
+				"password":         "Password123!",
+				"confirm_password": "Password123!",
+				"name":             "Role",
+				"role":             models.RoleAdmin,
+			})
+			if recorder.Code != http.StatusCreated {
+				t.Fatalf("register: status %d: %s", recorder.Code, recorder.Body)
+			}
+			if registered.User == nil || registered.User.Role != tt.wantRole {
+				t.Errorf("registered user = %+v, want role %q", registered.User, tt.wantRole)
+			}
+		})
+	}
+}
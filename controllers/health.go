@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/pkg/diskspace"
+	"go-api-boilerplate/pkg/version"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errHealthCheckTimeout is reported when a dependency does not answer in time
+var errHealthCheckTimeout = errors.New("health check timed out")
+
+// dependency is a service checked by the readiness probe. The probe fails
+// only when a critical dependency is down.
+type dependency struct {
+	check    func(ctx context.Context) error
+	critical bool
+}
+
+// DependencyStatus is the result of checking one dependency
+type DependencyStatus struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DiskStatus is the free space on a volume the service writes to. Status is
+// "ok", "low" when free space is under HEALTH_DISK_MIN_FREE_PERCENT, or
+// "unknown" when the volume could not be read.
+type DiskStatus struct {
+	Status      string  `json:"status"`
+	Path        string  `json:"path"`
+	FreeBytes   uint64  `json:"free_bytes"`
+	TotalBytes  uint64  `json:"total_bytes"`
+	FreePercent float64 `json:"free_percent"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// HealthController handles liveness and readiness probes
+type HealthController struct {
+	dependencies map[string]dependency
+	timeout      time.Duration
+	// disks maps names to the paths whose volumes are checked for space
+	disks        map[string]string
+	minFree      float64
+	diskCritical bool
+	statDisk     func(path string) (diskspace.Usage, error)
+}
+
+// NewHealthController creates a new health handler. Redis is optional, so
+// an unavailable Redis marks the service degraded rather than not ready.
+func NewHealthController(db *database.DB, redis *services.RedisService) *HealthController {
+	dependencies := make(map[string]dependency)
+	if db != nil {
+		dependencies["database"] = dependency{check: database.HealthCheck, critical: true}
+	}
+	if redis != nil {
+		dependencies["redis"] = dependency{check: redis.HealthCheck}
+	}
+
+	cfg := config.Get()
+	return &HealthController{
+		dependencies: dependencies,
+		timeout:      cfg.Monitoring.HealthCheckTimeout,
+		disks: map[string]string{
+			"uploads": cfg.Upload.Path,
+			"streams": cfg.Stream.Path,
+		},
+		minFree:      cfg.Monitoring.HealthDiskMinFreePercent,
+		diskCritical: cfg.Monitoring.HealthDiskCritical,
+		statDisk:     diskspace.Stat,
+	}
+}
+
+// HealthCheck godoc
+// @Summary Liveness probe
+// @Description Report that the process is running
+// @Tags health
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Router /health [get]
+func (h *HealthController) HealthCheck(c *gin.Context) {
+	utils.SuccessResponse(c, "Service is healthy", gin.H{
+		"status":    "ok",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// Version godoc
+// @Summary Build information
+// @Description Report the version, git commit and build time of the running binary and its Go version
+// @Tags health
+// @Produce json
+// @Success 200 {object} version.Info
+// @Router /version [get]
+func (h *HealthController) Version(c *gin.Context) {
+	utils.SuccessResponse(c, "Version retrieved successfully", version.Get())
+}
+
+// ReadinessCheck godoc
+// @Summary Readiness probe
+// @Description Check dependencies, each bounded by HEALTH_CHECK_TIMEOUT, and the free space on the upload and stream volumes
+// @Tags health
+// @Produce json
+// @Success 200 {object} utils.Response
+// @Failure 503 {object} utils.Response
+// @Router /health/ready [get]
+func (h *HealthController) ReadinessCheck(c *gin.Context) {
+	results := h.checkDependencies(c.Request.Context())
+	disks := h.checkDisks()
+
+	status := "ok"
+	ready := true
+	for name, result := range results {
+		if result.Status == "up" {
+			continue
+		}
+		status = "degraded"
+		if h.dependencies[name].critical {
+			ready = false
+		}
+	}
+	for _, disk := range disks {
+		if disk.Status == "ok" {
+			continue
+		}
+		status = "degraded"
+		if disk.Status == "low" && h.diskCritical {
+			ready = false
+		}
+	}
+
+	if !ready {
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "Service is not ready", "NOT_READY", map[string]interface{}{
+			"dependencies": results,
+			"disks":        disks,
+		})
+		return
+	}
+
+	utils.SuccessResponse(c, "Service is ready", gin.H{
+		"status":       status,
+		"dependencies": results,
+		"disks":        disks,
+	})
+}
+
+// checkDisks reports the free space on each checked volume. It returns nil
+// when the check is disabled or the platform cannot report disk usage.
+func (h *HealthController) checkDisks() map[string]DiskStatus {
+	if h.minFree <= 0 {
+		return nil
+	}
+
+	results := make(map[string]DiskStatus, len(h.disks))
+	for name, path := range h.disks {
+		usage, err := h.statDisk(path)
+		if errors.Is(err, diskspace.ErrUnsupported) {
+			return nil
+		}
+
+		result := DiskStatus{Status: "ok", Path: path}
+		if err != nil {
+			result.Status = "unknown"
+			result.Error = err.Error()
+			results[name] = result
+			continue
+		}
+
+		result.FreeBytes = usage.FreeBytes
+		result.TotalBytes = usage.TotalBytes
+		result.FreePercent = math.Round(usage.FreePercent()*100) / 100
+		if usage.FreePercent() < h.minFree {
+			result.Status = "low"
+		}
+		results[name] = result
+	}
+	return results
+}
+
+// checkDependencies checks all dependencies concurrently. A check that
+// outlives the timeout is reported as down without waiting for it.
+func (h *HealthController) checkDependencies(parent context.Context) map[string]DependencyStatus {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]DependencyStatus, len(h.dependencies))
+	)
+
+	for name, dep := range h.dependencies {
+		wg.Add(1)
+		go func(name string, dep dependency) {
+			defer wg.Done()
+			result := h.checkDependency(parent, dep)
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, dep)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// checkDependency runs one check bounded by the configured timeout
+func (h *HealthController) checkDependency(parent context.Context, dep dependency) DependencyStatus {
+	timeout := h.timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- dep.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errHealthCheckTimeout
+	}
+
+	result := DependencyStatus{
+		Status:    "up",
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = "down"
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/pkg/diskspace"
+)
+
+// readiness runs the readiness probe of h and returns the status code, the
+// dependency results and how long the probe took
+func readiness(t *testing.T, h *HealthController) (int, map[string]DependencyStatus, time.Duration) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/ready", h.ReadinessCheck)
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	elapsed := time.Since(start)
+
+	var response struct {
+		Data struct {
+			Dependencies map[string]DependencyStatus `json:"dependencies"`
+		} `json:"data"`
+		Error struct {
+			Details struct {
+				Dependencies map[string]DependencyStatus `json:"dependencies"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode readiness response %s: %v", recorder.Body, err)
+	}
+	dependencies := response.Data.Dependencies
+	if dependencies == nil {
+		dependencies = response.Error.Details.Dependencies
+	}
+	return recorder.Code, dependencies, elapsed
+}
+
+func TestReadinessTimesOutSlowDependency(t *testing.T) {
+	loadTestConfig(t, nil)
+
+	// The stuck check ignores its context, like a driver without deadlines
+	stuck := make(chan struct{})
+	t.Cleanup(func() { close(stuck) })
+	hang := func(context.Context) error {
+		<-stuck
+		return nil
+	}
+	healthy := func(context.Context) error { return nil }
+
+	h := &HealthController{timeout: 50 * time.Millisecond}
+	h.dependencies = map[string]dependency{
+		"database": {check: hang, critical: true},
+		"redis":    {check: healthy},
+	}
+	code, results, elapsed := readiness(t, h)
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("stuck critical dependency: status %d, want 503", code)
+	}
+	if elapsed > time.Second {
+		t.Errorf("probe took %v despite the 50ms timeout", elapsed)
+	}
+	if got := results["database"]; got.Status != "down" || got.Error != errHealthCheckTimeout.Error() {
+		t.Errorf("database = %+v, want down with a timeout", got)
+	}
+	if got := results["redis"]; got.Status != "up" {
+		t.Errorf("redis = %+v, want up", got)
+	}
+
+	h.dependencies = map[string]dependency{
+		"database": {check: healthy, critical: true},
+		"redis":    {check: hang},
+	}
+	code, results, _ = readiness(t, h)
+	if code != http.StatusOK {
+		t.Errorf("stuck optional dependency: status %d, want 200", code)
+	}
+	if got := results["redis"]; got.Status != "down" {
+		t.Errorf("redis = %+v, want down", got)
+	}
+}
+
+func TestVersionEndpoint(t *testing.T) {
+	loadTestConfig(t, nil)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/version", (&HealthController{}).Version)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var response struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode version response %s: %v", recorder.Body, err)
+	}
+	want := map[string]string{
+		"version":    "dev",
+		"commit":     "dev",
+		"build_time": "dev",
+		"go_version": runtime.Version(),
+	}
+	for field, value := range want {
+		if got, ok := response.Data[field]; !ok || got != value {
+			t.Errorf("%s = %q, want %q", field, got, value)
+		}
+	}
+}
+
+// diskReadiness runs the readiness probe of h and returns the status code,
+// the reported status and the disk results
+func diskReadiness(t *testing.T, h *HealthController) (int, string, map[string]DiskStatus) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/ready", h.ReadinessCheck)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+	var response struct {
+		Data struct {
+			Status string                `json:"status"`
+			Disks  map[string]DiskStatus `json:"disks"`
+		} `json:"data"`
+		Error struct {
+			Details struct {
+				Disks map[string]DiskStatus `json:"disks"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode readiness response %s: %v", recorder.Body, err)
+	}
+	disks := response.Data.Disks
+	if disks == nil {
+		disks = response.Error.Details.Disks
+	}
+	return recorder.Code, response.Data.Status, disks
+}
+
+func TestReadinessDiskSpace(t *testing.T) {
+	loadTestConfig(t, nil)
+
+	const gib = 1 << 30
+	tests := []struct {
+		name     string
+		free     uint64
+		err      error
+		critical bool
+		wantCode int
+		// wantStatus is the overall status, which is only reported when ready
+		wantStatus  string
+		wantUploads string
+	}{
+		{"plenty of space", 40, nil, false, http.StatusOK, "ok", "ok"},
+		{"at the threshold", 10, nil, true, http.StatusOK, "ok", "ok"},
+		{"low space", 9, nil, false, http.StatusOK, "degraded", "low"},
+		{"low space when critical", 9, nil, true, http.StatusServiceUnavailable, "", "low"},
+		{"unreadable volume", 0, errors.New("permission denied"), true, http.StatusOK, "degraded", "unknown"},
+		{"unsupported platform", 0, diskspace.ErrUnsupported, true, http.StatusOK, "ok", ""},
+	}
+	for _, tt := range tests {
+		// The uploads volume has tt.free GiB of 100 free and the streams
+		// volume is half free
+		h := &HealthController{
+			disks:        map[string]string{"uploads": "/data/uploads", "streams": "/data/streams"},
+			minFree:      10,
+			diskCritical: tt.critical,
+			statDisk: func(path string) (diskspace.Usage, error) {
+				if tt.err != nil {
+					return diskspace.Usage{}, tt.err
+				}
+				if path == "/data/uploads" {
+					return diskspace.Usage{TotalBytes: 100 * gib, FreeBytes: tt.free * gib}, nil
+				}
+				return diskspace.Usage{TotalBytes: 100 * gib, FreeBytes: 50 * gib}, nil
+			},
+		}
+		code, status, disks := diskReadiness(t, h)
+		if code != tt.wantCode || status != tt.wantStatus {
+			t.Errorf("%s: status %d %q, want %d %q", tt.name, code, status, tt.wantCode, tt.wantStatus)
+		}
+		if tt.wantUploads == "" {
+			if disks != nil {
+				t.Errorf("%s: disks = %+v, want none reported", tt.name, disks)
+			}
+			continue
+		}
+
+		uploads := disks["uploads"]
+		if uploads.Status != tt.wantUploads || uploads.Path != "/data/uploads" {
+			t.Errorf("%s: uploads = %+v, want %s", tt.name, uploads, tt.wantUploads)
+		}
+		if tt.err != nil {
+			if uploads.Error != tt.err.Error() {
+				t.Errorf("%s: uploads error = %q, want %q", tt.name, uploads.Error, tt.err)
+			}
+			continue
+		}
+		if uploads.TotalBytes != 100*gib || uploads.FreeBytes != tt.free*gib || uploads.FreePercent != float64(tt.free) {
+			t.Errorf("%s: uploads = %+v, want %d GiB of 100 free", tt.name, uploads, tt.free)
+		}
+		if streams := disks["streams"]; streams.Status != "ok" || streams.FreePercent != 50 {
+			t.Errorf("%s: streams = %+v, want ok at 50%%", tt.name, streams)
+		}
+	}
+}
+
+func TestReadinessDiskCheckDisabled(t *testing.T) {
+	loadTestConfig(t, nil)
+
+	h := &HealthController{
+		disks:   map[string]string{"uploads": "/data/uploads"},
+		minFree: 0,
+		statDisk: func(string) (diskspace.Usage, error) {
+			t.Error("volume checked with HEALTH_DISK_MIN_FREE_PERCENT=0")
+			return diskspace.Usage{}, nil
+		},
+	}
+	if code, status, disks := diskReadiness(t, h); code != http.StatusOK || status != "ok" || disks != nil {
+		t.Errorf("disabled check: status %d %q, disks %+v", code, status, disks)
+	}
+}
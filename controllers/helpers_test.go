@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go-api-boilerplate/config"
+)
+
+// loadTestConfig loads the configuration from the environment with a
+// throwaway SQLite database and upload and video directories, no Redis and
+// env overriding the defaults
+func loadTestConfig(t *testing.T, env map[string]string) *config.Config {
+	t.Helper()
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("APP_DEBUG", "false")
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("JWT_SECRET", "test-secret-that-is-at-least-32-characters")
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_NAME", filepath.Join(t.TempDir(), "test.db"))
+	t.Setenv("DB_CONNECT_ATTEMPTS", "1")
+	t.Setenv("REDIS_PORT", "1")
+	t.Setenv("REDIS_CONNECT_ATTEMPTS", "1")
+	t.Setenv("UPLOAD_PATH", t.TempDir())
+	t.Setenv("STREAM_PATH", t.TempDir())
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	return cfg
+}
@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
 	"path/filepath"
-	"strings"
 
 	"go-api-boilerplate/config"
 	"go-api-boilerplate/services"
@@ -53,8 +55,76 @@ func (h *StreamController) StreamVideo(c *gin.Context) {
 
 	// Stream the video
 	if err := h.streamService.StreamVideo(c, videoPath); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.NotFoundResponse(c, "Video")
+		if h.handleVideoError(c, err, "Video") {
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to stream video")
+		return
+	}
+}
+
+// SignVideoURL godoc
+// @Summary Create a signed video URL
+// @Description Create an expiring signed URL that streams a video without an Authorization header, for use as a video element source
+// @Tags streaming
+// @Security Bearer
+// @Produce json
+// @Param id path string true "Video ID"
+// @Success 200 {object} services.SignedURL
+// @Failure 401 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /stream/sign/{id} [post]
+func (h *StreamController) SignVideoURL(c *gin.Context) {
+	videoID := c.Param("id")
+	if videoID == "" {
+		utils.BadRequestResponse(c, "Video ID is required", nil)
+		return
+	}
+
+	cfg := config.Get()
+	videoPath := filepath.Join(cfg.Stream.Path, videoID+".mp4")
+
+	signed, err := h.streamService.SignVideo(videoPath, "/api/v1/stream/signed/video/"+url.PathEscape(videoID))
+	if err != nil {
+		if h.handleVideoError(c, err, "Video") {
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to sign video URL")
+		return
+	}
+
+	utils.SuccessResponse(c, "Signed URL created successfully", signed)
+}
+
+// StreamVideoSigned godoc
+// @Summary Stream video with a signed URL
+// @Description Stream a video with range support, authorized by the signature from POST /stream/sign/{id} instead of a bearer token
+// @Tags streaming
+// @Param id path string true "Video ID"
+// @Param expires query int true "Expiry of the signed URL (Unix seconds)"
+// @Param signature query string true "Signature of the signed URL"
+// @Param Range header string false "Range header for partial content"
+// @Success 200 {file} binary
+// @Success 206 {file} binary
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /stream/signed/video/{id} [get]
+func (h *StreamController) StreamVideoSigned(c *gin.Context) {
+	videoID := c.Param("id")
+	if videoID == "" {
+		utils.BadRequestResponse(c, "Video ID is required", nil)
+		return
+	}
+
+	cfg := config.Get()
+	videoPath := filepath.Join(cfg.Stream.Path, videoID+".mp4")
+
+	if err := h.streamService.StreamVideoSigned(c, videoPath); err != nil {
+		if h.handleSignatureError(c, err) {
+			return
+		}
+		if h.handleVideoError(c, err, "Video") {
 			return
 		}
 		utils.InternalServerErrorResponse(c, "Failed to stream video")
@@ -64,13 +134,16 @@ func (h *StreamController) StreamVideo(c *gin.Context) {
 
 // StreamHLS godoc
 // @Summary Stream HLS content
-// @Description Stream HLS playlist or segments
+// @Description Stream HLS playlist or segments. With STREAM_HLS_SIGNED_URLS enabled the playlist URIs carry an expiring signature, and segments are authorized by it instead of the bearer token.
 // @Tags streaming
 // @Security Bearer
 // @Param id path string true "Video ID"
 // @Param path path string true "HLS file path"
+// @Param expires query int false "Expiry of a signed URI (Unix seconds)"
+// @Param signature query string false "Signature of a signed URI"
 // @Success 200 {file} binary
 // @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
 // @Failure 404 {object} utils.Response
 // @Router /stream/hls/{id}/{path} [get]
 func (h *StreamController) StreamHLS(c *gin.Context) {
@@ -88,8 +161,10 @@ func (h *StreamController) StreamHLS(c *gin.Context) {
 
 	// Stream HLS content
 	if err := h.streamService.StreamHLS(c, fullPath); err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.NotFoundResponse(c, "HLS content")
+		if h.handleSignatureError(c, err) {
+			return
+		}
+		if h.handleVideoError(c, err, "HLS content") {
 			return
 		}
 		utils.InternalServerErrorResponse(c, "Failed to stream HLS content")
@@ -121,8 +196,7 @@ func (h *StreamController) GetVideoInfo(c *gin.Context) {
 	// Get video info
 	info, err := h.streamService.GetVideoInfo(videoPath)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			utils.NotFoundResponse(c, "Video")
+		if h.handleVideoError(c, err, "Video") {
 			return
 		}
 		utils.InternalServerErrorResponse(c, "Failed to get video info")
@@ -131,3 +205,34 @@ func (h *StreamController) GetVideoInfo(c *gin.Context) {
 
 	utils.SuccessResponse(c, "Video info retrieved successfully", info)
 }
+
+// handleVideoError responds to missing or inaccessible videos, reporting
+// whether err was one. Videos are sensitive, so a denied one is reported as
+// missing.
+func (h *StreamController) handleVideoError(c *gin.Context, err error, resource string) bool {
+	switch {
+	case errors.Is(err, services.ErrVideoNotFound):
+		utils.NotFoundResponse(c, resource)
+	case errors.Is(err, services.ErrVideoAccessDenied):
+		utils.RespondNotFoundOrForbidden(c, resource, true)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleSignatureError responds to signed URL failures, reporting whether
+// err was one
+func (h *StreamController) handleSignatureError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrSignatureRequired):
+		utils.UnauthorizedResponse(c, "A signed URL or bearer token is required")
+	case errors.Is(err, services.ErrSignatureExpired):
+		utils.ErrorResponse(c, http.StatusForbidden, "Signed URL has expired", "SIGNATURE_EXPIRED", nil)
+	case errors.Is(err, services.ErrInvalidSignature):
+		utils.ErrorResponse(c, http.StatusForbidden, "Invalid URL signature", "INVALID_SIGNATURE", nil)
+	default:
+		return false
+	}
+	return true
+}
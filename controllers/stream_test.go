@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	middleware "go-api-boilerplate/middlewares"
+	"go-api-boilerplate/services"
+)
+
+func TestStreamErrorsOnRawRoutes(t *testing.T) {
+	loadTestConfig(t, nil)
+	handler := NewStreamController(services.NewStreamService(nil))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/info/:id", handler.GetVideoInfo)
+	router.GET("/video/:id", middleware.RawResponseMiddleware(), handler.StreamVideo)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/video/missing", `{"code":"NOT_FOUND","message":"Video not found"}`},
+		{"/info/missing", `{"success":false,"message":"Video not found","error":{"code":"NOT_FOUND","message":"Video not found"}}`},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if recorder.Code != http.StatusNotFound || recorder.Body.String() != tt.want {
+			t.Errorf("%s: %d %s, want 404 %s", tt.path, recorder.Code, recorder.Body, tt.want)
+		}
+	}
+}
+
+// newStreamRouter mounts the stream routes without authentication. Path
+// parameters are read from the raw path, so an escaped slash reaches the
+// handler the way a lenient proxy would forward it.
+func newStreamRouter(t *testing.T) (*gin.Engine, *services.StreamService) {
+	t.Helper()
+
+	streamService := services.NewStreamService(nil)
+	handler := NewStreamController(streamService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.UseRawPath = true
+	router.POST("/sign/:id", handler.SignVideoURL)
+	media := router.Group("", middleware.RawResponseMiddleware())
+	media.GET("/signed/video/:id", handler.StreamVideoSigned)
+	media.GET("/hls/:id/:path", handler.StreamHLS)
+	return router, streamService
+}
+
+// writeStreamFile writes content to the slash-separated name under dir
+func writeStreamFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// getStream sends a GET for target through router
+func getStream(router *gin.Engine, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+// signVideo requests a signed URL for a video and returns its query
+func signVideo(t *testing.T, router *gin.Engine, videoID string) url.Values {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/sign/"+videoID, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("sign %s: %d %s", videoID, recorder.Code, recorder.Body)
+	}
+
+	var resp struct {
+		Data services.SignedURL `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode signed URL: %v", err)
+	}
+	signed, err := url.Parse(resp.Data.URL)
+	if err != nil || signed.Path != "/api/v1/stream/signed/video/"+videoID {
+		t.Fatalf("signed URL = %q, want the signed video route", resp.Data.URL)
+	}
+	return signed.Query()
+}
+
+func TestStreamVideoSigned(t *testing.T) {
+	cfg := loadTestConfig(t, nil)
+	router, _ := newStreamRouter(t)
+	writeStreamFile(t, cfg.Stream.Path, "intro.mp4", "intro video")
+	writeStreamFile(t, cfg.Stream.Path, "other.mp4", "other video")
+
+	valid := signVideo(t, router, "intro")
+	expires, _ := strconv.ParseInt(valid.Get("expires"), 10, 64)
+	signature := valid.Get("signature")
+	flipped := signature[:len(signature)-1] + "0"
+	if flipped == signature {
+		flipped = signature[:len(signature)-1] + "1"
+	}
+
+	// A signature minted with a TTL in the past verifies but has expired
+	cfg.Stream.SignedURLTTL = -time.Minute
+	expired := signVideo(t, router, "intro")
+	cfg.Stream.SignedURLTTL = time.Hour
+
+	query := func(expires int64, signature string) string {
+		return url.Values{"expires": {strconv.FormatInt(expires, 10)}, "signature": {signature}}.Encode()
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantBody string
+	}{
+		{"valid signature", "/signed/video/intro?" + valid.Encode(), http.StatusOK, "intro video"},
+		{"tampered signature", "/signed/video/intro?" + query(expires, flipped), http.StatusForbidden, "INVALID_SIGNATURE"},
+		{"tampered expiry", "/signed/video/intro?" + query(expires+3600, signature), http.StatusForbidden, "INVALID_SIGNATURE"},
+		{"another video's signature", "/signed/video/other?" + valid.Encode(), http.StatusForbidden, "INVALID_SIGNATURE"},
+		{"expired signature", "/signed/video/intro?" + expired.Encode(), http.StatusForbidden, "SIGNATURE_EXPIRED"},
+		{"no signature", "/signed/video/intro", http.StatusUnauthorized, "UNAUTHORIZED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := getStream(router, tt.target)
+			if recorder.Code != tt.wantCode || !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Errorf("GET %s: %d %s, want %d containing %q", tt.target, recorder.Code, recorder.Body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestStreamHLSSignedPlaylist(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]string{"STREAM_HLS_SIGNED_URLS": "true"})
+	router, streamService := newStreamRouter(t)
+
+	const playlist = "#EXTM3U\n" +
+		"#EXT-X-MAP:URI=\"init.ts\"\n" +
+		"#EXTINF:4.0,\n" +
+		"seg0.ts\n" +
+		"#EXTINF:4.0,\n" +
+		"https://cdn.example.com/seg1.ts\n"
+	writeStreamFile(t, cfg.Stream.Path, "hls/intro/index.m3u8", playlist)
+	writeStreamFile(t, cfg.Stream.Path, "hls/intro/init.ts", "init segment")
+	writeStreamFile(t, cfg.Stream.Path, "hls/intro/seg0.ts", "segment 0")
+
+	if recorder := getStream(router, "/hls/intro/index.m3u8"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned playlist: %d, want 401", recorder.Code)
+	}
+
+	expires, signature := streamService.SignStreamURL("hls/intro/index.m3u8")
+	recorder := getStream(router, "/hls/intro/index.m3u8?"+url.Values{
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {signature},
+	}.Encode())
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("signed playlist: %d %s", recorder.Code, recorder.Body)
+	}
+
+	// Every relative URI the playlist lists must be fetchable as rewritten
+	want := map[string]string{"init.ts": "init segment", "seg0.ts": "segment 0"}
+	var signedQuery url.Values
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		uri := line
+		if start := strings.Index(line, `URI="`); start >= 0 {
+			uri = strings.TrimSuffix(line[start+len(`URI="`):], `"`)
+		} else if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(uri, "https://") {
+			if uri != "https://cdn.example.com/seg1.ts" {
+				t.Errorf("absolute URI rewritten to %q", uri)
+			}
+			continue
+		}
+
+		name, query, _ := strings.Cut(uri, "?")
+		body, ok := want[name]
+		if !ok {
+			t.Errorf("unexpected URI %q in playlist", uri)
+			continue
+		}
+		delete(want, name)
+		if name == "init.ts" {
+			signedQuery, _ = url.ParseQuery(query)
+		}
+
+		segment := getStream(router, "/hls/intro/"+uri)
+		if segment.Code != http.StatusOK || segment.Body.String() != body {
+			t.Errorf("GET %s: %d %q, want 200 %q", uri, segment.Code, segment.Body, body)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("playlist did not list %v", want)
+	}
+
+	// A segment needs its own signature
+	if recorder := getStream(router, "/hls/intro/seg0.ts"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned segment: %d, want 401", recorder.Code)
+	}
+	if recorder := getStream(router, "/hls/intro/seg0.ts?"+signedQuery.Encode()); recorder.Code != http.StatusForbidden {
+		t.Errorf("segment with another segment's signature: %d, want 403", recorder.Code)
+	}
+}
+
+func TestStreamHLSRejectsPathTraversal(t *testing.T) {
+	cfg := loadTestConfig(t, nil)
+	router, _ := newStreamRouter(t)
+
+	// Playlists just outside the stream directory, one in a sibling
+	// directory whose name starts with the stream directory's
+	parent, base := filepath.Split(cfg.Stream.Path)
+	writeStreamFile(t, parent, "secret.m3u8", "#EXTM3U\nsecret.ts\n")
+	writeStreamFile(t, parent, base+"-evil/secret.m3u8", "#EXTM3U\nsecret.ts\n")
+
+	for _, target := range []string{
+		"/hls/intro/" + url.PathEscape("../../../secret.m3u8"),
+		"/hls/intro/" + url.PathEscape("../../../"+base+"-evil/secret.m3u8"),
+		"/hls/" + url.PathEscape("../..") + "/secret.m3u8",
+	} {
+		recorder := getStream(router, target)
+		if recorder.Code != http.StatusNotFound || strings.Contains(recorder.Body.String(), "secret") {
+			t.Errorf("GET %s: %d %s, want 404", target, recorder.Code, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"go-api-boilerplate/config"
+	middleware "go-api-boilerplate/middlewares"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadController handles file upload requests
+type UploadController struct {
+	uploadService *services.UploadService
+}
+
+// NewUploadController creates a new upload handler
+func NewUploadController(uploadService *services.UploadService) *UploadController {
+	return &UploadController{
+		uploadService: uploadService,
+	}
+}
+
+// UploadFile godoc
+// @Summary Upload a file
+// @Description Upload a single file
+// @Tags upload
+// @Security Bearer
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "File to upload"
+// @Success 201 {object} services.FileInfo
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Failure 422 {object} utils.Response
+// @Failure 429 {object} utils.Response
+// @Router /upload [post]
+func (h *UploadController) UploadFile(c *gin.Context) {
+	fileInfo, err := h.uploadService.UploadFile(c, "file", services.UploadPolicyDefault)
+	if err != nil {
+		handleUploadError(c, err)
+		return
+	}
+
+	utils.CreatedResponse(c, "File uploaded successfully", fileInfo)
+}
+
+// UploadMultipleFiles godoc
+// @Summary Upload multiple files
+// @Description Upload several files in one request
+// @Tags upload
+// @Security Bearer
+// @Accept multipart/form-data
+// @Produce json
+// @Param files formData file true "Files to upload"
+// @Success 201 {array} services.FileInfo
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Failure 429 {object} utils.Response
+// @Router /upload/multiple [post]
+func (h *UploadController) UploadMultipleFiles(c *gin.Context) {
+	files, err := h.uploadService.UploadMultipleFiles(c, "files", services.UploadPolicyDefault)
+	if err != nil {
+		if len(files) == 0 {
+			handleUploadError(c, err)
+			return
+		}
+		// Partial success: report which files failed
+		utils.ErrorResponse(c, http.StatusMultiStatus, "Some files failed to upload", "PARTIAL_UPLOAD", map[string]interface{}{
+			"uploaded": files,
+			"error":    err.Error(),
+		})
+		return
+	}
+
+	utils.CreatedResponse(c, "Files uploaded successfully", files)
+}
+
+// ServeFile godoc
+// @Summary Download an uploaded file
+// @Description Download a stored upload by the URL returned when it was uploaded. Only the uploader and admins may download a file, except avatars, which any signed-in user may; others get 404. Requires authentication unless UPLOAD_PUBLIC_FILES is enabled; directories are never listed.
+// @Tags upload
+// @Security Bearer
+// @Param filepath path string true "Path below the upload directory"
+// @Success 200 {file} binary
+// @Success 206 {file} binary
+// @Failure 401 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /uploads/{filepath} [get]
+func (h *UploadController) ServeFile(c *gin.Context) {
+	rel := strings.TrimPrefix(c.Param("filepath"), "/")
+	if rel == "" {
+		utils.NotFoundResponse(c, "File")
+		return
+	}
+
+	filePath := filepath.Join(config.Get().Upload.Path, filepath.FromSlash(rel))
+	userID, _ := utils.UserIDFromContext(c)
+	stored, err := h.uploadService.AuthorizeFile(filePath, userID, middleware.IsAdmin(c))
+	if err == nil {
+		// Downloads are named after the uploaded file where it is known
+		downloadName := ""
+		if stored != nil {
+			downloadName = stored.OriginalName
+		}
+		err = h.uploadService.ServeFile(c, filePath, downloadName)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrFileNotFound):
+			utils.NotFoundResponse(c, "File")
+		case errors.Is(err, services.ErrFileAccessDenied):
+			// Uploads are sensitive: denied files look missing
+			utils.RespondNotFoundOrForbidden(c, "File", true)
+		default:
+			utils.InternalServerErrorResponse(c, "Failed to serve file")
+		}
+		return
+	}
+}
+
+// handleUploadError maps upload service errors to responses
+func handleUploadError(c *gin.Context, err error) {
+	var rejected *services.FileRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, "File rejected by content scan", "FILE_REJECTED", map[string]interface{}{
+			"reason": rejected.Reason,
+		})
+	case errors.Is(err, services.ErrEmptyFile):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error(), "EMPTY_FILE", nil)
+	case errors.Is(err, services.ErrExtensionMismatch):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error(), "EXTENSION_MISMATCH", nil)
+	case errors.Is(err, services.ErrAvatarNotImage):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error(), "INVALID_IMAGE", nil)
+	case errors.Is(err, services.ErrImageTooLarge):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error(), "IMAGE_TOO_LARGE", nil)
+	case errors.Is(err, services.ErrTooManyUploads):
+		utils.ErrorResponse(c, http.StatusTooManyRequests, "Too many uploads in progress, try again when one finishes", "TOO_MANY_UPLOADS", nil)
+	case errors.Is(err, services.ErrUploadTooLarge):
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error(), "FILE_TOO_LARGE", nil)
+	case errors.Is(err, services.ErrScannerUnavailable):
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "File scanning is temporarily unavailable", "SCANNER_UNAVAILABLE", nil)
+	default:
+		utils.BadRequestResponse(c, err.Error(), nil)
+	}
+}
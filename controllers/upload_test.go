@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+)
+
+// newTestUploadRouter serves the upload routes against a throwaway SQLite
+// database with env overriding the configuration. Requests are made as the user in the X-Test-User header with
+// the role in X-Test-Role, standing in for AuthMiddleware.
+func newTestUploadRouter(t *testing.T, env map[string]string) *gin.Engine {
+	t.Helper()
+
+	cfg := loadTestConfig(t, env)
+	db, err := database.Connect(cfg)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	handler := NewUploadController(services.NewUploadService(db))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.MaxMultipartMemory = cfg.Upload.MaxMultipartMemory
+	router.Use(func(c *gin.Context) {
+		if id, err := strconv.ParseUint(c.GetHeader("X-Test-User"), 10, 64); err == nil {
+			c.Set(utils.ContextKeyUserID, uint(id))
+			c.Set(utils.ContextKeyUserRole, c.GetHeader("X-Test-Role"))
+		}
+	})
+	router.POST("/api/v1/upload", handler.UploadFile)
+	router.POST("/api/v1/upload/multiple", handler.UploadMultipleFiles)
+	router.GET("/uploads/*filepath", handler.ServeFile)
+	return router
+}
+
+// postFile uploads content under filename as userID
+func postFile(router *gin.Engine, userID uint, filename string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", filename)
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-Test-User", strconv.FormatUint(uint64(userID), 10))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// uploadAs uploads a small PNG as userID and returns its URL
+func uploadAs(t *testing.T, router *gin.Engine, userID uint) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	var content bytes.Buffer
+	png.Encode(&content, img)
+
+	recorder := postFile(router, userID, "report.png", content.Bytes())
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("upload: status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var response struct {
+		Data services.FileInfo `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode upload response: %v", err)
+	}
+	return response.Data.URL
+}
+
+func TestServeFileOwnership(t *testing.T) {
+	router := newTestUploadRouter(t, nil)
+	url := uploadAs(t, router, 1)
+
+	download := func(url string, userID uint, role string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("X-Test-User", strconv.FormatUint(uint64(userID), 10))
+		req.Header.Set("X-Test-Role", role)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if got := download(url, 1, models.RoleUser); got.Code != http.StatusOK {
+		t.Errorf("owner: status %d, want 200", got.Code)
+	} else if got.Header().Get("Content-Disposition") != `attachment; filename=report.png` {
+		t.Errorf("owner: Content-Disposition = %q", got.Header().Get("Content-Disposition"))
+	}
+	if got := download(url, 2, models.RoleAdmin); got.Code != http.StatusOK {
+		t.Errorf("admin: status %d, want 200", got.Code)
+	}
+
+	// A file the user may not see must be indistinguishable from a
+	// missing one
+	denied := download(url, 2, models.RoleUser)
+	missing := download("/uploads/2026/01/01/missing.png", 2, models.RoleUser)
+	if denied.Code != http.StatusNotFound || missing.Code != http.StatusNotFound {
+		t.Fatalf("non-owner: status %d, missing: status %d, want 404 for both", denied.Code, missing.Code)
+	}
+	if denied.Body.String() != missing.Body.String() {
+		t.Errorf("non-owner body %s differs from missing body %s", denied.Body, missing.Body)
+	}
+}
+
+func TestUploadEmptyAndOneByteFiles(t *testing.T) {
+	t.Setenv("UPLOAD_ALLOWED_TYPES", "text/*")
+	router := newTestUploadRouter(t, nil)
+
+	tests := []struct {
+		name       string
+		content    []byte
+		wantStatus int
+		wantCode   string
+	}{
+		{"zero bytes", nil, http.StatusUnprocessableEntity, "EMPTY_FILE"},
+		{"one byte", []byte("a"), http.StatusCreated, ""},
+	}
+	for _, tt := range tests {
+		recorder := postFile(router, 1, "notes.txt", tt.content)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, recorder.Code, tt.wantStatus, recorder.Body)
+			continue
+		}
+
+		var response struct {
+			Data  services.FileInfo `json:"data"`
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", tt.name, err)
+		}
+		if response.Error.Code != tt.wantCode {
+			t.Errorf("%s: error code %q, want %q", tt.name, response.Error.Code, tt.wantCode)
+		}
+		if tt.wantStatus == http.StatusCreated && (response.Data.Size != int64(len(tt.content)) || !strings.HasPrefix(response.Data.MimeType, "text/plain")) {
+			t.Errorf("%s: stored %d bytes of %s, want %d bytes of text/plain", tt.name, response.Data.Size, response.Data.MimeType, len(tt.content))
+		}
+	}
+}
+
+// postFiles uploads each of contents, by filename, in the "files" field of
+// one request as userID
+func postFiles(router *gin.Engine, userID uint, contents map[string][]byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for filename, content := range contents {
+		part, _ := form.CreateFormFile("files", filename)
+		part.Write(content)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload/multiple", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-Test-User", strconv.FormatUint(uint64(userID), 10))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestUploadMultipleFilesTotalSize(t *testing.T) {
+	// Each file is under the per-file limit; together they are over the
+	// total
+	router := newTestUploadRouter(t, map[string]string{
+		"UPLOAD_MAX_SIZE":       "1024",
+		"UPLOAD_MAX_TOTAL_SIZE": "2048",
+		"MAX_MULTIPART_MEMORY":  "512",
+		"UPLOAD_ALLOWED_TYPES":  "text/*",
+	})
+	text := func(n int) []byte { return bytes.Repeat([]byte("a"), n) }
+
+	tests := []struct {
+		name     string
+		files    map[string][]byte
+		wantCode int
+	}{
+		{"under the total", map[string][]byte{"a.txt": text(1000), "b.txt": text(1000)}, http.StatusCreated},
+		{"over the total", map[string][]byte{"a.txt": text(1000), "b.txt": text(1000), "c.txt": text(1000)}, http.StatusRequestEntityTooLarge},
+		{"far over the total", map[string][]byte{"a.txt": text(1 << 20), "b.txt": text(1 << 20)}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		recorder := postFiles(router, 1, tt.files)
+		if recorder.Code != tt.wantCode {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, recorder.Code, tt.wantCode, recorder.Body)
+			continue
+		}
+		if tt.wantCode == http.StatusRequestEntityTooLarge && !strings.Contains(recorder.Body.String(), "FILE_TOO_LARGE") {
+			t.Errorf("%s: body %s, want FILE_TOO_LARGE", tt.name, recorder.Body)
+		}
+	}
+}
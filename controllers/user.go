@@ -0,0 +1,796 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-api-boilerplate/config"
+	middleware "go-api-boilerplate/middlewares"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserController handles user management requests
+type UserController struct {
+	userService   *services.UserService
+	authService   *services.AuthService
+	uploadService *services.UploadService
+}
+
+// NewUserController creates a new user handler
+func NewUserController(userService *services.UserService, authService *services.AuthService, uploadService *services.UploadService) *UserController {
+	return &UserController{
+		userService:   userService,
+		authService:   authService,
+		uploadService: uploadService,
+	}
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description List users with pagination, filtering and sorting
+// @Tags admin
+// @Security Bearer
+// @Produce json
+// @Param page query int false "Page number"
+// @Param per_page query int false "Items per page (PAGINATION_MAX_PER_PAGE, 100 by default)"
+// @Param sort_by query string false "Sort field (id, email, name, role, created_at, updated_at, last_login_at)"
+// @Param sort_order query string false "Sort order (asc, desc)"
+// @Param search query string false "Search by name or email"
+// @Param role query string false "Filter by role"
+// @Param is_active query bool false "Filter by active status"
+// @Param email_verified query bool false "Filter by email verification"
+// @Success 200 {object} utils.PaginatedResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/users [get]
+func (h *UserController) ListUsers(c *gin.Context) {
+	page, perPage, err := utils.GetPaginationParams(c)
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return
+	}
+
+	filter, ok := userFilterFromQuery(c)
+	if !ok {
+		return
+	}
+
+	meta, users, err := h.userService.FindPaginated(c.Request.Context(), page, perPage, filter)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSortField) || errors.Is(err, services.ErrInvalidSortOrder) {
+			utils.BadRequestResponse(c, err.Error(), nil)
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to retrieve users")
+		return
+	}
+
+	responses := make([]interface{}, len(users))
+	for i := range users {
+		responses[i] = users[i].ToResponse()
+	}
+
+	utils.PaginatedSuccessResponse(c, "Users retrieved successfully", responses, *meta)
+}
+
+// BatchGetUsers godoc
+// @Summary Get several users
+// @Description Get up to 100 users by ID in one request. Users are returned in request order and IDs without a user are listed as missing.
+// @Tags admin
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param input body models.BatchGetUsersInput true "User IDs"
+// @Success 200 {object} models.BatchGetUsersResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/users/batch [post]
+func (h *UserController) BatchGetUsers(c *gin.Context) {
+	var input models.BatchGetUsersInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
+	users, missing, err := h.userService.FindByIDs(c.Request.Context(), input.IDs)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyIDs) {
+			utils.BadRequestResponse(c, err.Error(), nil)
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to retrieve users")
+		return
+	}
+
+	response := models.BatchGetUsersResponse{
+		Users:   make([]*models.UserResponse, len(users)),
+		Missing: missing,
+	}
+	for i := range users {
+		response.Users[i] = users[i].ToResponse()
+	}
+
+	utils.SuccessResponse(c, "Users retrieved successfully", response)
+}
+
+// ExportUsers godoc
+// @Summary Export users
+// @Description Stream every user matching the list filters as CSV or JSON. Password hashes and tokens are never included.
+// @Tags admin
+// @Security Bearer
+// @Produce text/csv
+// @Produce json
+// @Param format query string false "Export format (csv, json)" default(csv)
+// @Param sort_by query string false "Sort field (id, email, name, role, created_at, updated_at, last_login_at)"
+// @Param sort_order query string false "Sort order (asc, desc)"
+// @Param search query string false "Search by name or email"
+// @Param role query string false "Filter by role"
+// @Param is_active query bool false "Filter by active status"
+// @Param email_verified query bool false "Filter by email verification"
+// @Success 200 {file} file
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/users/export [get]
+func (h *UserController) ExportUsers(c *gin.Context) {
+	format := strings.ToLower(c.DefaultQuery("format", "csv"))
+	if format != "csv" && format != "json" {
+		utils.BadRequestResponse(c, "format must be csv or json", nil)
+		return
+	}
+
+	filter, ok := userFilterFromQuery(c)
+	if !ok {
+		return
+	}
+	// Reject bad sort options before the response starts
+	if err := filter.Validate(); err != nil {
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return
+	}
+
+	filename := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "no-store")
+
+	var err error
+	if format == "json" {
+		err = h.exportJSON(c, filter)
+	} else {
+		err = h.exportCSV(c, filter)
+	}
+	if err != nil {
+		// The status is already sent, so the truncated body is all the client sees
+		logger.WithError(err).Error("User export failed")
+	}
+}
+
+// exportFlushEvery is how many exported users are written between flushes
+const exportFlushEvery = 500
+
+// userExportColumns is the CSV header of a user export
+var userExportColumns = []string{
+	"id", "email", "name", "avatar", "role", "is_active", "email_verified",
+	"email_verified_at", "last_login_at", "created_at", "updated_at",
+}
+
+// exportCSV streams users as CSV
+func (h *UserController) exportCSV(c *gin.Context, filter *services.UserFilter) error {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	if err := writer.Write(userExportColumns); err != nil {
+		return err
+	}
+
+	count := 0
+	err := h.userService.StreamUsers(c.Request.Context(), filter, func(user *models.User) error {
+		response := user.ToResponse()
+		if err := writer.Write([]string{
+			strconv.FormatUint(uint64(response.ID), 10),
+			csvSafe(response.Email),
+			csvSafe(response.Name),
+			csvSafe(response.Avatar),
+			response.Role,
+			strconv.FormatBool(response.IsActive),
+			strconv.FormatBool(response.EmailVerified),
+			formatExportTime(response.EmailVerifiedAt),
+			formatExportTime(response.LastLoginAt),
+			response.CreatedAt.UTC().Format(time.RFC3339),
+			response.UpdatedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+
+		if count++; count%exportFlushEvery == 0 {
+			writer.Flush()
+			c.Writer.Flush()
+		}
+		return writer.Error()
+	})
+
+	writer.Flush()
+	c.Writer.Flush()
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// exportJSON streams users as a JSON array
+func (h *UserController) exportJSON(c *gin.Context, filter *services.UserFilter) error {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	if _, err := c.Writer.WriteString("["); err != nil {
+		return err
+	}
+
+	count := 0
+	err := h.userService.StreamUsers(c.Request.Context(), filter, func(user *models.User) error {
+		data, err := json.Marshal(user.ToResponse())
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			if _, err := c.Writer.WriteString(","); err != nil {
+				return err
+			}
+		}
+		if _, err := c.Writer.Write(data); err != nil {
+			return err
+		}
+
+		if count++; count%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		c.Writer.Flush()
+		return err
+	}
+
+	_, err = c.Writer.WriteString("]")
+	c.Writer.Flush()
+	return err
+}
+
+// csvSafe keeps spreadsheet applications from evaluating a cell as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// formatExportTime formats an optional time for a CSV export
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// userFilterFromQuery reads the user list filters from the query string,
+// responding with 400 when a boolean filter is malformed
+func userFilterFromQuery(c *gin.Context) (*services.UserFilter, bool) {
+	filter := &services.UserFilter{
+		Search:    c.Query("search"),
+		Role:      c.Query("role"),
+		SortBy:    c.Query("sort_by"),
+		SortOrder: c.Query("sort_order"),
+	}
+	if value, ok := c.GetQuery("is_active"); ok {
+		isActive, err := strconv.ParseBool(value)
+		if err != nil {
+			utils.BadRequestResponse(c, "is_active must be a boolean", nil)
+			return nil, false
+		}
+		filter.IsActive = &isActive
+	}
+	if value, ok := c.GetQuery("email_verified"); ok {
+		emailVerified, err := strconv.ParseBool(value)
+		if err != nil {
+			utils.BadRequestResponse(c, "email_verified must be a boolean", nil)
+			return nil, false
+		}
+		filter.EmailVerified = &emailVerified
+	}
+	return filter, true
+}
+
+// GetProfile godoc
+// @Summary Get current user
+// @Description Get the authenticated user's profile. Responses served from a stale cache carry X-Cache: stale. The weak ETag changes whenever the user is updated; send it back in If-None-Match to get 304 while unchanged.
+// @Tags users
+// @Security Bearer
+// @Produce json
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} models.UserResponse
+// @Success 304 "Not modified"
+// @Failure 401 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /users/me [get]
+func (h *UserController) GetProfile(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "")
+		return
+	}
+
+	h.respondWithUser(c, userID, "Profile retrieved successfully")
+}
+
+// PatchProfile godoc
+// @Summary Update current user
+// @Description Partially update the authenticated user's name and avatar. Omitted or null fields are left unchanged; an empty avatar removes it.
+// @Tags users
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param input body models.PatchProfileInput true "Fields to change"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /users/profile [patch]
+func (h *UserController) PatchProfile(c *gin.Context) {
+	var input models.PatchProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "")
+		return
+	}
+
+	user, err := h.userService.Patch(c.Request.Context(), userID, &input)
+	if err != nil {
+		h.handleUpdateError(c, err, "Failed to update profile")
+		return
+	}
+
+	utils.SuccessResponse(c, "Profile updated successfully", user.ToResponse())
+}
+
+// UploadAvatar godoc
+// @Summary Upload an avatar
+// @Description Upload an image as the authenticated user's avatar. A resized copy is made for every UPLOAD_AVATAR_SIZES entry and returned in avatar_variants; the files of the previous avatar are deleted.
+// @Tags users
+// @Security Bearer
+// @Accept multipart/form-data
+// @Produce json
+// @Param avatar formData file true "Avatar image"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Failure 422 {object} utils.Response
+// @Failure 429 {object} utils.Response
+// @Router /users/avatar [post]
+func (h *UserController) UploadAvatar(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "")
+		return
+	}
+
+	avatar, err := h.uploadService.UploadAvatar(c, "avatar")
+	if err != nil {
+		handleUploadError(c, err)
+		return
+	}
+
+	user, err := h.userService.SetAvatar(c.Request.Context(), userID, avatar)
+	if err != nil {
+		// Nothing points to the new files
+		h.uploadService.DeleteURL(avatar.URL)
+		for _, url := range avatar.Variants {
+			h.uploadService.DeleteURL(url)
+		}
+		h.handleUpdateError(c, err, "Failed to update avatar")
+		return
+	}
+
+	utils.SuccessResponse(c, "Avatar updated successfully", user.ToResponse())
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Get a user by ID. Responses served from a stale cache carry X-Cache: stale. Supports If-None-Match like GET /users/me.
+// @Tags admin
+// @Security Bearer
+// @Produce json
+// @Param id path int true "User ID"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Success 200 {object} models.UserResponse
+// @Success 304 "Not modified"
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/users/{id} [get]
+func (h *UserController) GetUser(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || userID == 0 {
+		utils.BadRequestResponse(c, "Invalid user ID", nil)
+		return
+	}
+
+	h.respondWithUser(c, uint(userID), "User retrieved successfully")
+}
+
+// respondWithUser loads a user and writes it, marking stale cache hits and
+// answering 304 when the client's ETag is current
+func (h *UserController) respondWithUser(c *gin.Context, userID uint, message string) {
+	user, result, err := h.userService.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			utils.NotFoundResponse(c, "User")
+			return
+		}
+		utils.InternalServerErrorResponse(c, "Failed to retrieve user")
+		return
+	}
+
+	if result.Stale {
+		utils.MarkStale(c, result.CachedAt)
+	}
+	if utils.NotModified(c, utils.WeakETag(user.ID, user.UpdatedAt)) {
+		return
+	}
+	utils.SuccessResponse(c, message, user.ToResponse())
+}
+
+// UpdateUserRole godoc
+// @Summary Change a user's role
+// @Description Change a user's role. Admins cannot remove their own admin role.
+// @Tags admin
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param input body models.UpdateUserRoleInput true "New role"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/users/{id}/role [put]
+func (h *UserController) UpdateUserRole(c *gin.Context) {
+	actorID, userID, ok := h.parseTarget(c)
+	if !ok {
+		return
+	}
+
+	var input models.UpdateUserRoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
+	user, err := h.userService.UpdateUserRole(c.Request.Context(), actorID, userID, input.Role)
+	if err != nil {
+		h.handleUpdateError(c, err, "Failed to update user role")
+		return
+	}
+
+	h.invalidateUser(c, userID)
+	utils.SuccessResponse(c, "User role updated successfully", user.ToResponse())
+}
+
+// UpdateUserStatus godoc
+// @Summary Activate or deactivate a user
+// @Description Activate or deactivate a user. Users cannot deactivate their own account.
+// @Tags admin
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param input body models.UpdateUserStatusInput true "New status"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/users/{id}/status [put]
+func (h *UserController) UpdateUserStatus(c *gin.Context) {
+	actorID, userID, ok := h.parseTarget(c)
+	if !ok {
+		return
+	}
+
+	var input models.UpdateUserStatusInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		utils.ValidationErrorResponse(c, err.Error())
+		return
+	}
+
+	user, err := h.userService.UpdateUserStatus(c.Request.Context(), actorID, userID, *input.IsActive)
+	if err != nil {
+		h.handleUpdateError(c, err, "Failed to update user status")
+		return
+	}
+
+	h.invalidateUser(c, userID)
+	utils.SuccessResponse(c, "User status updated successfully", user.ToResponse())
+}
+
+// ImportUsers godoc
+// @Summary Import users from CSV
+// @Description Create users from a CSV with email, name and role columns and an optional password column, sent as the multipart field "file" or as a text/csv body. Each row is reported as created, skipped_duplicate or error. Users without a password get a random one and must reset it.
+// @Tags admin
+// @Security Bearer
+// @Accept multipart/form-data
+// @Accept text/csv
+// @Produce json
+// @Param file formData file false "CSV file"
+// @Success 200 {object} models.UserImportResult
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 413 {object} utils.Response
+// @Router /admin/users/import [post]
+func (h *UserController) ImportUsers(c *gin.Context) {
+	actorID, err := middleware.GetUserID(c)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "")
+		return
+	}
+
+	maxSize := config.Get().Import.MaxFileSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+
+	file, err := importSource(c)
+	if err != nil {
+		handleImportError(c, err, maxSize)
+		return
+	}
+
+	result, created, err := h.userService.ImportUsers(c.Request.Context(), file)
+	if err != nil {
+		handleImportError(c, err, maxSize)
+		return
+	}
+
+	logger.Infof("User %d imported users: %d created, %d skipped, %d failed",
+		actorID, result.Created, result.Skipped, result.Failed)
+	h.authService.SendVerificationEmails(created)
+
+	utils.SuccessResponse(c, "Users imported successfully", result)
+}
+
+// handleImportError maps user import errors to responses
+func handleImportError(c *gin.Context, err error, maxSize int64) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, "Import file is too large", "FILE_TOO_LARGE", map[string]interface{}{
+			"max_size": maxSize,
+		})
+	case errors.Is(err, errImportFileRequired),
+		errors.Is(err, services.ErrInvalidImportFile),
+		errors.Is(err, services.ErrImportTooManyRows):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		logger.WithError(err).Error("User import failed")
+		utils.InternalServerErrorResponse(c, "Failed to import users")
+	}
+}
+
+// errImportFileRequired is returned when an import request carries no CSV
+var errImportFileRequired = errors.New("a CSV file is required")
+
+// importSource returns the CSV of an import request without buffering it:
+// the "file" part of a multipart form, or the body of a text/csv request
+func importSource(c *gin.Context) (io.Reader, error) {
+	switch c.ContentType() {
+	case "multipart/form-data":
+		reader, err := c.Request.MultipartReader()
+		if err != nil {
+			return nil, errImportFileRequired
+		}
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil, errImportFileRequired
+			}
+			if err != nil {
+				return nil, err
+			}
+			if part.FormName() == "file" {
+				return part, nil
+			}
+		}
+	case "text/csv":
+		return c.Request.Body, nil
+	default:
+		return nil, errImportFileRequired
+	}
+}
+
+// DeleteUser godoc
+// @Summary Delete a user
+// @Description Soft delete a user, or permanently delete them with force=true. Both end the user's sessions, reset and verification tokens, refresh token and cached entries; a soft-deleted user keeps their data and can be restored, while a permanent delete also removes the user row and their avatar files. Users cannot delete their own account.
+// @Tags admin
+// @Security Bearer
+// @Produce json
+// @Param id path int true "User ID"
+// @Param force query bool false "Permanently delete the user"
+// @Success 200 {object} utils.Response
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/users/{id} [delete]
+func (h *UserController) DeleteUser(c *gin.Context) {
+	actorID, userID, ok := h.parseTarget(c)
+	if !ok {
+		return
+	}
+
+	force := false
+	if value, ok := c.GetQuery("force"); ok {
+		var err error
+		if force, err = strconv.ParseBool(value); err != nil {
+			utils.BadRequestResponse(c, "force must be a boolean", nil)
+			return
+		}
+	}
+
+	if force {
+		if err := h.userService.ForceDelete(c.Request.Context(), actorID, userID); err != nil {
+			h.handleUpdateError(c, err, "Failed to delete user")
+			return
+		}
+		utils.SuccessResponse(c, "User permanently deleted", nil)
+		return
+	}
+
+	if err := h.userService.Delete(c.Request.Context(), actorID, userID); err != nil {
+		h.handleUpdateError(c, err, "Failed to delete user")
+		return
+	}
+	utils.SuccessResponse(c, "User deleted successfully", nil)
+}
+
+// RestoreUser godoc
+// @Summary Restore a deleted user
+// @Description Undo the soft delete of a user so they can sign in again
+// @Tags admin
+// @Security Bearer
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} utils.Response
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Failure 404 {object} utils.Response
+// @Router /admin/users/{id}/restore [post]
+func (h *UserController) RestoreUser(c *gin.Context) {
+	actorID, userID, ok := h.parseTarget(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.Restore(c.Request.Context(), actorID, userID)
+	if err != nil {
+		h.handleUpdateError(c, err, "Failed to restore user")
+		return
+	}
+
+	utils.SuccessResponse(c, "User restored successfully", user.ToResponse())
+}
+
+// GetUserSummary godoc
+// @Summary User counts
+// @Description Aggregate user counts for dashboards: total, by role, active, verified and recent signups. Cached for up to a minute.
+// @Tags admin
+// @Security Bearer
+// @Produce json
+// @Success 200 {object} services.UserSummary
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/users/summary [get]
+func (h *UserController) GetUserSummary(c *gin.Context) {
+	summary, err := h.userService.Summary(c.Request.Context())
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to retrieve user summary")
+		return
+	}
+
+	utils.SuccessResponse(c, "User summary retrieved successfully", summary)
+}
+
+// ListDeletedUsers godoc
+// @Summary List deleted users
+// @Description List soft-deleted users that can be restored, most recently deleted first
+// @Tags admin
+// @Security Bearer
+// @Produce json
+// @Param page query int false "Page number"
+// @Param per_page query int false "Items per page (PAGINATION_MAX_PER_PAGE, 100 by default)"
+// @Success 200 {object} utils.PaginatedResponse
+// @Failure 401 {object} utils.Response
+// @Failure 403 {object} utils.Response
+// @Router /admin/users/deleted [get]
+func (h *UserController) ListDeletedUsers(c *gin.Context) {
+	page, perPage, err := utils.GetPaginationParams(c)
+	if err != nil {
+		utils.BadRequestResponse(c, err.Error(), nil)
+		return
+	}
+
+	meta, users, err := h.userService.FindTrashed(c.Request.Context(), page, perPage)
+	if err != nil {
+		utils.InternalServerErrorResponse(c, "Failed to retrieve deleted users")
+		return
+	}
+
+	responses := make([]interface{}, len(users))
+	for i := range users {
+		responses[i] = users[i].ToResponse()
+	}
+
+	utils.PaginatedSuccessResponse(c, "Deleted users retrieved successfully", responses, *meta)
+}
+
+// parseTarget returns the authenticated user and the user ID from the path
+func (h *UserController) parseTarget(c *gin.Context) (uint, uint, bool) {
+	actorID, err := middleware.GetUserID(c)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "")
+		return 0, 0, false
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || userID == 0 {
+		utils.BadRequestResponse(c, "Invalid user ID", nil)
+		return 0, 0, false
+	}
+
+	return actorID, uint(userID), true
+}
+
+// handleUpdateError maps user update errors to responses
+func (h *UserController) handleUpdateError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		utils.NotFoundResponse(c, "User")
+	case errors.Is(err, services.ErrSelfDemotion), errors.Is(err, services.ErrSelfDeactivation),
+		errors.Is(err, services.ErrSelfDeletion):
+		utils.ForbiddenResponse(c, err.Error())
+	case errors.Is(err, services.ErrInvalidRole):
+		utils.BadRequestResponse(c, err.Error(), nil)
+	default:
+		utils.InternalServerErrorResponse(c, message)
+	}
+}
+
+// invalidateUser makes a role or status change apply to the user's next
+// request. The change is already saved, so a failure is only logged.
+func (h *UserController) invalidateUser(c *gin.Context, userID uint) {
+	if err := h.authService.InvalidateUser(c.Request.Context(), userID); err != nil {
+		logger.Warnf("Failed to invalidate sessions for user %d: %v", userID, err)
+	}
+}
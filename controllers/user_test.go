@@ -0,0 +1,487 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+)
+
+// Secrets stored on every user created by newTestUserRouter, which no
+// response may contain
+const (
+	testPasswordHash = "$2a$10$secret-password-hash"
+	testRefreshToken = "secret-refresh-token"
+)
+
+// newTestUserRouter serves the user routes against a throwaway SQLite
+// database holding users with the given emails, returned in order. Requests
+// are made as the user in the X-Test-User header, standing in for
+// AuthMiddleware.
+func newTestUserRouter(t *testing.T, emails ...string) (*gin.Engine, []models.User) {
+	t.Helper()
+
+	db, err := database.Connect(loadTestConfig(t, nil))
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	users := make([]models.User, len(emails))
+	for i, email := range emails {
+		users[i] = models.User{
+			Email:        email,
+			Password:     testPasswordHash,
+			RefreshToken: testRefreshToken,
+			Name:         "User " + email,
+			Role:         models.RoleUser,
+		}
+		if err := db.Write.Create(&users[i]).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		// Creating fills in the column's default of true, so every other
+		// user is deactivated afterwards
+		if err := db.Write.Model(&users[i]).Update("is_active", i%2 == 0).Error; err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+	}
+
+	userService, uploadService := services.NewUserService(db, nil), services.NewUploadService(db)
+	userService.SetFileStore(uploadService)
+	handler := NewUserController(userService, services.NewAuthService(db, nil), uploadService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if id, err := strconv.ParseUint(c.GetHeader("X-Test-User"), 10, 64); err == nil {
+			c.Set(utils.ContextKeyUserID, uint(id))
+		}
+	})
+	router.GET("/api/v1/users/me", handler.GetProfile)
+	router.PATCH("/api/v1/users/profile", handler.PatchProfile)
+	router.POST("/api/v1/users/avatar", handler.UploadAvatar)
+	router.GET("/api/v1/admin/users/:id", handler.GetUser)
+	router.GET("/api/v1/admin/users", handler.ListUsers)
+	router.GET("/api/v1/admin/users/export", handler.ExportUsers)
+	router.GET("/api/v1/admin/users/summary", handler.GetUserSummary)
+	router.POST("/api/v1/admin/users/batch", handler.BatchGetUsers)
+	router.PUT("/api/v1/admin/users/:id/role", handler.UpdateUserRole)
+	return router, users
+}
+
+// assertNoSecrets fails when body contains a stored password hash or
+// refresh token
+func assertNoSecrets(t *testing.T, body string) {
+	t.Helper()
+
+	for _, secret := range []string{testPasswordHash, testRefreshToken, "password", "refresh_token"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("response contains %q", secret)
+		}
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	router, _ := newTestUserRouter(t, "a@example.com", "b@example.com", "=cmd@example.com")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantEmails []string
+	}{
+		{"every user", "", []string{"a@example.com", "b@example.com", "'=cmd@example.com"}},
+		{"list filters", "is_active=true", []string{"a@example.com", "'=cmd@example.com"}},
+		{"search", "search=b%40example", []string{"b@example.com"}},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"csv", "json"} {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/admin/users/export?sort_by=id&sort_order=asc&format="+format+"&"+tt.query, nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("%s as %s: status %d: %s", tt.name, format, recorder.Code, recorder.Body)
+			}
+
+			disposition := recorder.Header().Get("Content-Disposition")
+			if !regexp.MustCompile(`^attachment; filename="users-\d{8}T\d{6}Z\.` + format + `"$`).MatchString(disposition) {
+				t.Errorf("%s as %s: Content-Disposition = %q", tt.name, format, disposition)
+			}
+			assertNoSecrets(t, recorder.Body.String())
+
+			var emails []string
+			if format == "csv" {
+				rows, err := csv.NewReader(recorder.Body).ReadAll()
+				if err != nil {
+					t.Fatalf("%s: invalid CSV: %v", tt.name, err)
+				}
+				if strings.Join(rows[0], ",") != strings.Join(userExportColumns, ",") {
+					t.Errorf("%s: CSV header = %v", tt.name, rows[0])
+				}
+				for _, row := range rows[1:] {
+					emails = append(emails, row[1])
+				}
+			} else {
+				var exported []models.UserResponse
+				if err := json.Unmarshal(recorder.Body.Bytes(), &exported); err != nil {
+					t.Fatalf("%s: invalid JSON: %v", tt.name, err)
+				}
+				for _, user := range exported {
+					// JSON is not read by spreadsheets and is left unescaped
+					emails = append(emails, strings.Replace(user.Email, "=", "'=", 1))
+				}
+			}
+			if strings.Join(emails, ",") != strings.Join(tt.wantEmails, ",") {
+				t.Errorf("%s as %s: emails = %v, want %v", tt.name, format, emails, tt.wantEmails)
+			}
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/admin/users/export?format=xml", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: status %d, want 400", recorder.Code)
+	}
+}
+
+func TestBatchGetUsers(t *testing.T) {
+	router, users := newTestUserRouter(t, "a@example.com", "b@example.com", "c@example.com")
+
+	post := func(ids []uint) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.BatchGetUsersInput{IDs: ids})
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/batch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	a, b, c := users[0].ID, users[1].ID, users[2].ID
+	tests := []struct {
+		name        string
+		ids         []uint
+		wantIDs     []uint
+		wantMissing []uint
+	}{
+		{"input order", []uint{c, a, b}, []uint{c, a, b}, []uint{}},
+		{"missing IDs", []uint{b, 999, a, 998}, []uint{b, a}, []uint{999, 998}},
+		{"repeated IDs", []uint{a, a, 999, 999}, []uint{a}, []uint{999}},
+	}
+	for _, tt := range tests {
+		recorder := post(tt.ids)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.name, recorder.Code, recorder.Body)
+		}
+		assertNoSecrets(t, recorder.Body.String())
+
+		var response struct {
+			Data struct {
+				Users   []models.UserResponse `json:"users"`
+				Missing []uint                `json:"missing"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: invalid response: %v", tt.name, err)
+		}
+		var ids []uint
+		for _, user := range response.Data.Users {
+			ids = append(ids, user.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) || fmt.Sprint(response.Data.Missing) != fmt.Sprint(tt.wantMissing) {
+			t.Errorf("%s: users %v, missing %v, want %v, %v", tt.name, ids, response.Data.Missing, tt.wantIDs, tt.wantMissing)
+		}
+	}
+
+	tooMany := make([]uint, services.MaxBatchIDs+1)
+	for i := range tooMany {
+		tooMany[i] = uint(i + 1)
+	}
+	for _, ids := range [][]uint{tooMany, {}, {0}} {
+		if recorder := post(ids); recorder.Code != http.StatusBadRequest && recorder.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%d IDs: status %d, want it rejected", len(ids), recorder.Code)
+		}
+	}
+}
+
+func TestListUsersPagination(t *testing.T) {
+	router, _ := newTestUserRouter(t, "a@example.com", "b@example.com", "c@example.com")
+
+	tests := []struct {
+		query       string
+		wantStatus  int
+		wantPerPage string
+		wantPages   string
+	}{
+		{"", http.StatusOK, "20", "1"},
+		{"?per_page=2", http.StatusOK, "2", "2"},
+		{"?per_page=100", http.StatusOK, "100", "1"},
+		{"?per_page=101", http.StatusBadRequest, "", ""},
+		{"?per_page=0", http.StatusBadRequest, "", ""},
+		{"?per_page=ten", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/admin/users"+tt.query, nil))
+
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%q: status %d, want %d: %s", tt.query, recorder.Code, tt.wantStatus, recorder.Body)
+			continue
+		}
+		header := recorder.Header()
+		if header.Get("X-Per-Page") != tt.wantPerPage || header.Get("X-Total-Pages") != tt.wantPages {
+			t.Errorf("%q: X-Per-Page %q, X-Total-Pages %q, want %q, %q", tt.query, header.Get("X-Per-Page"), header.Get("X-Total-Pages"), tt.wantPerPage, tt.wantPages)
+		}
+	}
+}
+
+func TestGetUserSummary(t *testing.T) {
+	router, _ := newTestUserRouter(t, "a@example.com", "b@example.com", "c@example.com")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/admin/users/summary", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var response struct {
+		Data services.UserSummary `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	// Every other seeded user is deactivated and none are verified
+	got := response.Data
+	if got.Total != 3 || got.ByRole[models.RoleUser] != 3 || got.Active != 2 || got.Inactive != 1 ||
+		got.Verified != 0 || got.Unverified != 3 || got.NewLast7Days != 3 || got.NewLast30Days != 3 {
+		t.Errorf("summary = %+v, want the seeded users' counts", got)
+	}
+}
+
+func TestPatchProfile(t *testing.T) {
+	router, users := newTestUserRouter(t, "a@example.com")
+	user := users[0]
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantName   string
+		wantAvatar string
+	}{
+		{"set avatar", `{"avatar": "https://cdn.example.com/a.png"}`, http.StatusOK, "User a@example.com", "https://cdn.example.com/a.png"},
+		{"empty patch", `{}`, http.StatusOK, "User a@example.com", "https://cdn.example.com/a.png"},
+		{"null fields", `{"name": null, "avatar": null}`, http.StatusOK, "User a@example.com", "https://cdn.example.com/a.png"},
+		{"name only", `{"name": "Renamed"}`, http.StatusOK, "Renamed", "https://cdn.example.com/a.png"},
+		{"avatar cleared", `{"avatar": ""}`, http.StatusOK, "Renamed", ""},
+		{"name too short", `{"name": "A"}`, http.StatusUnprocessableEntity, "Renamed", ""},
+		{"name cleared", `{"name": ""}`, http.StatusUnprocessableEntity, "Renamed", ""},
+		{"fields not self-editable", `{"role": "admin", "email": "new@example.com", "is_active": false}`, http.StatusOK, "Renamed", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/profile", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-User", strconv.FormatUint(uint64(user.ID), 10))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, recorder.Code, tt.wantStatus, recorder.Body)
+		}
+
+		var stored models.User
+		if err := database.GetDB().Write.First(&stored, user.ID).Error; err != nil {
+			t.Fatalf("%s: failed to load user: %v", tt.name, err)
+		}
+		if stored.Name != tt.wantName || stored.Avatar != tt.wantAvatar {
+			t.Errorf("%s: name %q, avatar %q, want %q, %q", tt.name, stored.Name, stored.Avatar, tt.wantName, tt.wantAvatar)
+		}
+		if stored.Email != user.Email || stored.Role != models.RoleUser || !stored.IsActive {
+			t.Errorf("%s: email %q, role %q, active %v changed", tt.name, stored.Email, stored.Role, stored.IsActive)
+		}
+	}
+}
+
+func TestUpdateUserRoleValidation(t *testing.T) {
+	router, users := newTestUserRouter(t, "admin@example.com", "target@example.com")
+	admin, target := users[0], users[1]
+	if err := database.GetDB().Write.Model(&admin).Update("role", models.RoleAdmin).Error; err != nil {
+		t.Fatalf("failed to promote admin: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantRole   string
+	}{
+		{"moderator", `{"role": "moderator"}`, http.StatusOK, models.RoleModerator},
+		{"admin", `{"role": "admin"}`, http.StatusOK, models.RoleAdmin},
+		{"user", `{"role": "user"}`, http.StatusOK, models.RoleUser},
+		{"unknown role", `{"role": "superadmin"}`, http.StatusBadRequest, models.RoleUser},
+		{"wrong case", `{"role": "Admin"}`, http.StatusBadRequest, models.RoleUser},
+		{"missing role", `{}`, http.StatusUnprocessableEntity, models.RoleUser},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/v1/admin/users/%d/role", target.ID), strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-User", strconv.FormatUint(uint64(admin.ID), 10))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, recorder.Code, tt.wantStatus, recorder.Body)
+		}
+		var stored models.User
+		if err := database.GetDB().Write.First(&stored, target.ID).Error; err != nil {
+			t.Fatalf("%s: failed to load user: %v", tt.name, err)
+		}
+		if stored.Role != tt.wantRole {
+			t.Errorf("%s: role %q, want %q", tt.name, stored.Role, tt.wantRole)
+		}
+	}
+}
+
+func TestUserETag(t *testing.T) {
+	router, users := newTestUserRouter(t, "a@example.com", "b@example.com")
+	user := users[0]
+	caller := strconv.FormatUint(uint64(user.ID), 10)
+
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Test-User", caller)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	for i, path := range []string{"/api/v1/users/me", fmt.Sprintf("/api/v1/admin/users/%d", user.ID)} {
+		first := get(path, "")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("%s: status %d, ETag %q, want 200 with a weak ETag", path, first.Code, etag)
+		}
+		if cache := first.Header().Get("Cache-Control"); !strings.HasPrefix(cache, "private") {
+			t.Errorf("%s: Cache-Control = %q, want private", path, cache)
+		}
+
+		unchanged := get(path, etag)
+		if unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+			t.Errorf("%s with a current ETag: status %d with %d bytes, want an empty 304", path, unchanged.Code, unchanged.Body.Len())
+		}
+		if unchanged.Header().Get("ETag") != etag {
+			t.Errorf("%s: 304 ETag = %q, want %q", path, unchanged.Header().Get("ETag"), etag)
+		}
+
+		if other := get(fmt.Sprintf("/api/v1/admin/users/%d", users[1].ID), etag); other.Code != http.StatusOK {
+			t.Errorf("another user with %s's ETag: status %d, want 200", path, other.Code)
+		}
+
+		// An update changes the tag, so the old one no longer matches
+		time.Sleep(time.Millisecond)
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/profile", strings.NewReader(fmt.Sprintf(`{"name": "Renamed %d"}`, i)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-User", caller)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("PATCH profile: status %d: %s", recorder.Code, recorder.Body)
+		}
+
+		changed := get(path, etag)
+		newTag := changed.Header().Get("ETag")
+		if changed.Code != http.StatusOK || newTag == "" || newTag == etag {
+			t.Errorf("%s after an update: status %d, ETag %q, want 200 with a new ETag", path, changed.Code, newTag)
+		}
+		if !strings.Contains(changed.Body.String(), fmt.Sprintf("Renamed %d", i)) {
+			t.Errorf("%s after an update: body %s, want the new name", path, changed.Body)
+		}
+		if again := get(path, newTag); again.Code != http.StatusNotModified {
+			t.Errorf("%s with the new ETag: status %d, want 304", path, again.Code)
+		}
+	}
+}
+
+func TestUploadAvatarReturnsVariants(t *testing.T) {
+	router, users := newTestUserRouter(t, "a@example.com")
+	caller := strconv.FormatUint(uint64(users[0].ID), 10)
+
+	post := func(content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("avatar", "avatar.png")
+		part.Write(content)
+		form.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/avatar", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("X-Test-User", caller)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	var content bytes.Buffer
+	png.Encode(&content, image.NewRGBA(image.Rect(0, 0, 600, 300)))
+	recorder := post(content.Bytes())
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("upload: status %d: %s", recorder.Code, recorder.Body)
+	}
+	var uploaded struct {
+		Data models.UserResponse `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &uploaded); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if uploaded.Data.Avatar == "" {
+		t.Error("response has no avatar")
+	}
+	for _, name := range []string{"small", "medium", "large"} {
+		if url := uploaded.Data.AvatarVariants[name]; !strings.HasPrefix(url, "/uploads/") || url == uploaded.Data.Avatar {
+			t.Errorf("%s variant URL = %q", name, url)
+		}
+	}
+
+	// The profile returns the same variants
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/me", nil)
+	req.Header.Set("X-Test-User", caller)
+	profile := httptest.NewRecorder()
+	router.ServeHTTP(profile, req)
+	var fetched struct {
+		Data models.UserResponse `json:"data"`
+	}
+	json.Unmarshal(profile.Body.Bytes(), &fetched)
+	if fmt.Sprint(fetched.Data.AvatarVariants) != fmt.Sprint(uploaded.Data.AvatarVariants) {
+		t.Errorf("profile variants = %v, want %v", fetched.Data.AvatarVariants, uploaded.Data.AvatarVariants)
+	}
+
+	// A PNG that cannot be decoded is refused and leaves the avatar alone
+	if recorder := post(content.Bytes()[:64]); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("truncated image: status %d, want 422: %s", recorder.Code, recorder.Body)
+	}
+	var stored models.User
+	database.GetDB().Write.First(&stored, users[0].ID)
+	if stored.Avatar != uploaded.Data.Avatar {
+		t.Errorf("avatar after a refused upload = %q, want %q", stored.Avatar, uploaded.Data.Avatar)
+	}
+}
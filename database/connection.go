@@ -7,7 +7,11 @@ import (
 	"time"
 
 	"go-api-boilerplate/config"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/pkg/retry"
+	"go-api-boilerplate/pkg/tracing"
 
+	"github.com/uptrace/opentelemetry-go-extra/otelgorm"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"gorm.io/driver/mysql"
@@ -29,12 +33,53 @@ var db *DB
 
 // Connect establishes database connections based on configuration
 func Connect(cfg *config.Config) (*DB, error) {
-	var err error
 	db = &DB{}
 
 	// Setup logger
 	logConfig := setupLogger(cfg)
 
+	// Register custom serializers before any schema is parsed
+	RegisterSerializers()
+
+	// Retry while the database comes up, e.g. alongside it in docker compose
+	policy := retry.Policy{
+		Attempts: cfg.Database.ConnectAttempts,
+		Interval: cfg.Database.ConnectRetryInterval,
+	}
+	err := retry.Do(context.Background(), policy, "the "+cfg.Database.Driver+" database", func() error {
+		return connectDriver(cfg, logConfig)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Configure connection pool for SQL databases
+	if cfg.Database.Driver != "mongodb" {
+		configureConnectionPool(db.Write, cfg)
+		if db.Read != db.Write {
+			configureConnectionPool(db.Read, cfg)
+		}
+
+		// Instrument queries when tracing is enabled
+		if tracing.Enabled() {
+			if err := db.Write.Use(newTracingPlugin(cfg)); err != nil {
+				return nil, fmt.Errorf("failed to register tracing plugin: %w", err)
+			}
+			if db.Read != db.Write {
+				if err := db.Read.Use(newTracingPlugin(cfg)); err != nil {
+					return nil, fmt.Errorf("failed to register tracing plugin: %w", err)
+				}
+			}
+		}
+	}
+
+	return db, nil
+}
+
+// connectDriver opens the connections for the configured driver, marking
+// errors that another attempt cannot fix as permanent
+func connectDriver(cfg *config.Config, logConfig logger.Interface) error {
+	var err error
 	switch cfg.Database.Driver {
 	case "postgres":
 		db.Write, db.Read, err = connectPostgres(cfg, logConfig)
@@ -47,27 +92,18 @@ func Connect(cfg *config.Config) (*DB, error) {
 	case "mongodb":
 		db.MongoDB, err = connectMongoDB(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+			return fmt.Errorf("failed to connect to MongoDB: %w", err)
 		}
 		// Create dummy GORM connections for compatibility
 		db.Write, db.Read = createDummyGORMConnections()
 	default:
-		return nil, fmt.Errorf("unsupported database driver: %s", cfg.Database.Driver)
+		return retry.Permanent(fmt.Errorf("unsupported database driver: %s", cfg.Database.Driver))
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
-
-	// Configure connection pool for SQL databases
-	if cfg.Database.Driver != "mongodb" {
-		configureConnectionPool(db.Write, cfg)
-		if db.Read != db.Write {
-			configureConnectionPool(db.Read, cfg)
-		}
-	}
-
-	return db, nil
+	return nil
 }
 
 // setupLogger configures GORM logger
@@ -233,7 +269,8 @@ func connectMongoDB(cfg *config.Config) (*mongo.Database, error) {
 
 	clientOptions := options.Client().
 		ApplyURI(cfg.MongoDB.URI).
-		SetMaxPoolSize(cfg.MongoDB.MaxPoolSize)
+		SetMaxPoolSize(cfg.MongoDB.MaxPoolSize).
+		SetRegistry(mongoRegistry)
 
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
@@ -248,7 +285,9 @@ func connectMongoDB(cfg *config.Config) (*mongo.Database, error) {
 	return client.Database(cfg.MongoDB.Database), nil
 }
 
-// createDummyGORMConnections creates dummy GORM connections for MongoDB compatibility
+// createDummyGORMConnections creates dummy GORM connections for MongoDB
+// compatibility. Nothing written to them survives a restart, so services
+// that keep data on MongoDB must go through the repository package.
 func createDummyGORMConnections() (*gorm.DB, *gorm.DB) {
 	// Create in-memory SQLite database for compatibility
 	db, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
@@ -313,8 +352,9 @@ func Close() error {
 	return nil
 }
 
-// HealthCheck performs a health check on the database connections
-func HealthCheck() error {
+// HealthCheck performs a health check on the database connections, giving up
+// when ctx is done
+func HealthCheck(ctx context.Context) error {
 	if db == nil {
 		return fmt.Errorf("database not initialized")
 	}
@@ -325,7 +365,7 @@ func HealthCheck() error {
 		if err != nil {
 			return fmt.Errorf("failed to get write database: %w", err)
 		}
-		if err := sqlDB.Ping(); err != nil {
+		if err := sqlDB.PingContext(ctx); err != nil {
 			return fmt.Errorf("write database ping failed: %w", err)
 		}
 	}
@@ -335,16 +375,13 @@ func HealthCheck() error {
 		if err != nil {
 			return fmt.Errorf("failed to get read database: %w", err)
 		}
-		if err := sqlDB.Ping(); err != nil {
+		if err := sqlDB.PingContext(ctx); err != nil {
 			return fmt.Errorf("read database ping failed: %w", err)
 		}
 	}
 
 	// Check MongoDB
 	if db.MongoDB != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
 		if err := db.MongoDB.Client().Ping(ctx, nil); err != nil {
 			return fmt.Errorf("MongoDB ping failed: %w", err)
 		}
@@ -363,3 +400,48 @@ func IsMongoDB() bool {
 	cfg := config.Get()
 	return cfg.Database.Driver == "mongodb"
 }
+
+// Migrate creates or updates the tables for the application models
+func Migrate() error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	// MongoDB collections are schemaless; only their indexes are created
+	if IsMongoDB() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		return migrateMongo(ctx, db.MongoDB)
+	}
+
+	if err := db.Write.AutoMigrate(
+		&models.User{},
+		&models.Permission{},
+		&models.Session{},
+		&models.PasswordReset{},
+		&models.EmailVerification{},
+		&models.StoredFile{},
+		&models.AuditLog{},
+	); err != nil {
+		return err
+	}
+
+	// Stored files were once unique by hash across all uploaders; they are
+	// now deduplicated per uploader
+	migrator := db.Write.Migrator()
+	if migrator.HasIndex(&models.StoredFile{}, "idx_stored_files_hash") {
+		if err := migrator.DropIndex(&models.StoredFile{}, "idx_stored_files_hash"); err != nil {
+			return fmt.Errorf("failed to drop stored file hash index: %w", err)
+		}
+	}
+	return nil
+}
+
+// newTracingPlugin instruments queries with otelgorm. Bound query values
+// are left out of spans so they never leak credentials or personal data.
+func newTracingPlugin(cfg *config.Config) gorm.Plugin {
+	return otelgorm.NewPlugin(
+		otelgorm.WithDBName(cfg.Database.Name),
+		otelgorm.WithoutQueryVariables(),
+	)
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectFailsFastAtStartup(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"unsupported driver", map[string]string{
+			"DB_DRIVER":                 "oracle",
+			"DB_CONNECT_ATTEMPTS":       "5",
+			"DB_CONNECT_RETRY_INTERVAL": "2s",
+		}, "unsupported database driver: oracle"},
+		// MongoDB must answer rather than the services falling back to an
+		// in-memory SQLite database
+		{"unreachable MongoDB", map[string]string{
+			"DB_DRIVER":               "mongodb",
+			"MONGODB_URI":             "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=200",
+			"MONGODB_CONNECT_TIMEOUT": "500ms",
+		}, "failed to connect to MongoDB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, tt.env)
+
+			start := time.Now()
+			conn, err := Connect(cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Connect = %v, want %q", err, tt.wantErr)
+			}
+			if conn != nil {
+				t.Error("Connect returned a connection along with its error")
+			}
+			if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+				t.Errorf("Connect took %v to fail, want no retries", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"errors"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+// Driver error codes reported for a unique or primary key violation
+const (
+	pgUniqueViolation       = "23505"
+	mysqlDuplicateEntry     = 1062
+	sqlServerDuplicateKey   = 2627
+	sqlServerDuplicateIndex = 2601
+)
+
+// IsUniqueViolation reports whether err, or an error it wraps, is a unique
+// or primary key constraint violation from any of the supported drivers.
+// GORM's translated gorm.ErrDuplicatedKey is recognised too, so the result
+// does not depend on the TranslateError setting.
+func IsUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == pgUniqueViolation
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDuplicateEntry
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == sqlServerDuplicateKey || mssqlErr.Number == sqlServerDuplicateIndex
+	}
+
+	return mongo.IsDuplicateKeyError(err)
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"other error", errors.New("connection refused"), false},
+		{"not found", gorm.ErrRecordNotFound, false},
+		{"GORM translated", gorm.ErrDuplicatedKey, true},
+		{"Postgres unique", &pgconn.PgError{Code: "23505"}, true},
+		{"Postgres foreign key", &pgconn.PgError{Code: "23503"}, false},
+		{"MySQL duplicate entry", &mysqldriver.MySQLError{Number: 1062}, true},
+		{"MySQL foreign key", &mysqldriver.MySQLError{Number: 1452}, false},
+		{"SQLite unique", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, true},
+		{"SQLite primary key", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}, true},
+		{"SQLite not null", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull}, false},
+		{"SQL Server duplicate key", mssql.Error{Number: 2627}, true},
+		{"SQL Server duplicate index", mssql.Error{Number: 2601}, true},
+		{"SQL Server foreign key", mssql.Error{Number: 547}, false},
+		{"MongoDB duplicate key", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, true},
+		{"MongoDB other write error", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121}}}, false},
+		{"wrapped", fmt.Errorf("failed to create user: %w", &pgconn.PgError{Code: "23505"}), true},
+	}
+	for _, tt := range tests {
+		if got := IsUniqueViolation(tt.err); got != tt.want {
+			t.Errorf("%s: IsUniqueViolation(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsUniqueViolationFromSQLite(t *testing.T) {
+	db := newTestDB(t, loadTestConfig(t, nil))
+
+	type item struct {
+		ID   uint
+		Code string `gorm:"uniqueIndex"`
+	}
+	if err := db.Write.AutoMigrate(&item{}); err != nil {
+		t.Fatalf("AutoMigrate: %v", err)
+	}
+	if err := db.Write.Create(&item{Code: "a"}).Error; err != nil {
+		t.Fatalf("first insert: %v", err)
+	}
+
+	err := db.Write.Create(&item{Code: "a"}).Error
+	if !IsUniqueViolation(err) {
+		t.Errorf("duplicate insert: IsUniqueViolation(%v) = false", err)
+	}
+	err = db.Write.Create(&item{ID: 1, Code: "b"}).Error
+	if !IsUniqueViolation(err) {
+		t.Errorf("duplicate primary key: IsUniqueViolation(%v) = false", err)
+	}
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go-api-boilerplate/config"
+)
+
+// testEncryptionKey is the ENCRYPTION_KEY set by loadTestConfig
+const testEncryptionKey = "0123456789abcdef0123456789abcdef"
+
+// loadTestConfig loads the configuration from the environment with a
+// throwaway SQLite database and env overriding the defaults
+func loadTestConfig(t *testing.T, env map[string]string) *config.Config {
+	t.Helper()
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("APP_DEBUG", "false")
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("JWT_SECRET", "test-secret-that-is-at-least-32-characters")
+	t.Setenv("ENCRYPTION_KEY", testEncryptionKey)
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_NAME", filepath.Join(t.TempDir(), "test.db"))
+	t.Setenv("DB_CONNECT_ATTEMPTS", "1")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	return cfg
+}
+
+// newTestDB connects to the database of the loaded config
+func newTestDB(t *testing.T, cfg *config.Config) *DB {
+	t.Helper()
+
+	db, err := Connect(cfg)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { Close() })
+	return db
+}
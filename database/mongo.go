@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/gorm"
+)
+
+// mongoRegistry is shared by the MongoDB client and MongoRegistry
+var mongoRegistry = newMongoRegistry()
+
+// MongoRegistry returns the BSON registry the MongoDB client encodes and
+// decodes documents with. Use it when marshalling models by hand.
+func MongoRegistry() *bsoncodec.Registry {
+	return mongoRegistry
+}
+
+// newMongoRegistry returns the default registry with gorm.DeletedAt stored
+// as a date, or null while the document is not deleted, like the deleted_at
+// column of the SQL drivers. By default it would be stored as a document of
+// its Time and Valid fields, which deleted_at filters cannot match.
+func newMongoRegistry() *bsoncodec.Registry {
+	registry := bson.NewRegistry()
+	deletedAt := reflect.TypeOf(gorm.DeletedAt{})
+	registry.RegisterTypeEncoder(deletedAt, bsoncodec.ValueEncoderFunc(encodeDeletedAt))
+	registry.RegisterTypeDecoder(deletedAt, bsoncodec.ValueDecoderFunc(decodeDeletedAt))
+	return registry
+}
+
+// encodeDeletedAt writes a gorm.DeletedAt as a date or null
+func encodeDeletedAt(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	deletedAt := val.Interface().(gorm.DeletedAt)
+	if !deletedAt.Valid {
+		return vw.WriteNull()
+	}
+	return vw.WriteDateTime(deletedAt.Time.UnixMilli())
+}
+
+// decodeDeletedAt reads a gorm.DeletedAt from a date, null or undefined
+func decodeDeletedAt(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	var deletedAt gorm.DeletedAt
+	switch vr.Type() {
+	case bsontype.Null:
+		if err := vr.ReadNull(); err != nil {
+			return err
+		}
+	case bsontype.Undefined:
+		if err := vr.ReadUndefined(); err != nil {
+			return err
+		}
+	case bsontype.DateTime:
+		ms, err := vr.ReadDateTime()
+		if err != nil {
+			return err
+		}
+		deletedAt = gorm.DeletedAt{Time: time.UnixMilli(ms).UTC(), Valid: true}
+	default:
+		return fmt.Errorf("cannot decode %v into gorm.DeletedAt", vr.Type())
+	}
+
+	val.Set(reflect.ValueOf(deletedAt))
+	return nil
+}
+
+// mongoIndexes lists the indexes Migrate creates on MongoDB, matching the
+// unique and lookup indexes of the SQL tables
+var mongoIndexes = map[string][]mongo.IndexModel{
+	"users": {
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+	},
+	"sessions": {
+		{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"password_resets": {
+		{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"email_verifications": {
+		{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	},
+	"audit_logs": {
+		{Keys: bson.D{{Key: "target_id", Value: 1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}}},
+	},
+}
+
+// migrateMongo creates the indexes of mongoIndexes; existing indexes are
+// left as they are
+func migrateMongo(ctx context.Context, mongoDB *mongo.Database) error {
+	for collection, indexes := range mongoIndexes {
+		if _, err := mongoDB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gorm.io/gorm"
+
+	"go-api-boilerplate/models"
+)
+
+func TestMongoRegistryStoresDeletedAtAsDate(t *testing.T) {
+	deletedAt := time.Date(2026, 1, 2, 3, 4, 5, 6_000_000, time.UTC)
+
+	tests := []struct {
+		name string
+		in   gorm.DeletedAt
+		want any
+	}{
+		{"not deleted", gorm.DeletedAt{}, nil},
+		{"deleted", gorm.DeletedAt{Time: deletedAt, Valid: true}, deletedAt},
+	}
+	for _, tt := range tests {
+		data, err := bson.MarshalWithRegistry(MongoRegistry(), &models.User{ID: 7, Email: "a@example.com", DeletedAt: tt.in})
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", tt.name, err)
+		}
+
+		var raw bson.M
+		if err := bson.Unmarshal(data, &raw); err != nil {
+			t.Fatalf("%s: unmarshal raw: %v", tt.name, err)
+		}
+		if id, ok := raw["_id"].(int64); !ok || id != 7 {
+			t.Errorf("%s: _id = %#v, want 7", tt.name, raw["_id"])
+		}
+		switch want := tt.want.(type) {
+		case nil:
+			if raw["deleted_at"] != nil {
+				t.Errorf("%s: deleted_at = %#v, want null", tt.name, raw["deleted_at"])
+			}
+		case time.Time:
+			got, ok := raw["deleted_at"].(primitive.DateTime)
+			if !ok || !got.Time().Equal(want) {
+				t.Errorf("%s: deleted_at = %#v, want the date %v", tt.name, raw["deleted_at"], want)
+			}
+		}
+
+		var user models.User
+		if err := bson.UnmarshalWithRegistry(MongoRegistry(), data, &user); err != nil {
+			t.Fatalf("%s: unmarshal: %v", tt.name, err)
+		}
+		if user.ID != 7 || user.DeletedAt.Valid != tt.in.Valid || !user.DeletedAt.Time.Equal(tt.in.Time) {
+			t.Errorf("%s: round trip = %+v, want %+v", tt.name, user.DeletedAt, tt.in)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// readFromPrimaryKey marks a context whose reads must go to the primary
+type readFromPrimaryKey struct{}
+
+// ReadFromPrimary returns a context whose reads go to the primary (write)
+// connection instead of the read replica.
+//
+// Replicas apply the primary's changes asynchronously, so a read sent to
+// one shortly after a write may not see it: a user registered a moment ago
+// can be missing, or a row read before an update can be stale. Mark the
+// context for reads that must see the caller's own writes, such as the read
+// half of a read-modify-write or a check right after an insert. Every other
+// read should stay on the replica, which is what it is there to absorb.
+// Without DB_READ_HOST both connections are the same and the flag has no
+// effect.
+func ReadFromPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readFromPrimaryKey{}, true)
+}
+
+// ReadsFromPrimary reports whether ctx was marked by ReadFromPrimary
+func ReadsFromPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(readFromPrimaryKey{}).(bool)
+	return primary
+}
+
+// Reader returns the connection for reads made with ctx: the replica, or
+// the primary when ctx is marked by ReadFromPrimary
+func (d *DB) Reader(ctx context.Context) *gorm.DB {
+	if d.Read == nil || ReadsFromPrimary(ctx) {
+		return d.Write
+	}
+	return d.Read
+}
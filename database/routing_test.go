@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestReader(t *testing.T) {
+	write, read := &gorm.DB{}, &gorm.DB{}
+	plain := context.Background()
+	primary := ReadFromPrimary(plain)
+
+	tests := []struct {
+		name string
+		db   *DB
+		ctx  context.Context
+		want *gorm.DB
+	}{
+		{"replica", &DB{Write: write, Read: read}, plain, read},
+		{"read from primary", &DB{Write: write, Read: read}, primary, write},
+		{"flag kept by derived contexts", &DB{Write: write, Read: read}, context.WithValue(primary, struct{}{}, 1), write},
+		{"no replica", &DB{Write: write}, plain, write},
+	}
+	for _, tt := range tests {
+		if got := tt.db.Reader(tt.ctx); got != tt.want {
+			t.Errorf("%s: Reader returned the wrong connection", tt.name)
+		}
+	}
+
+	if ReadsFromPrimary(plain) || !ReadsFromPrimary(primary) {
+		t.Error("ReadsFromPrimary does not match the flag")
+	}
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/utils"
+
+	"gorm.io/gorm/schema"
+)
+
+// EncryptedSerializerName is the tag name used to mark encrypted columns
+const EncryptedSerializerName = "encrypted"
+
+// EncryptedSerializer transparently encrypts string columns at rest using
+// utils.Encrypt with the configured ENCRYPTION_KEY. Mark a field with
+//
+//	Phone string `gorm:"serializer:encrypted"`
+//
+// Supported field types are string and *string. Every write produces a new
+// random ciphertext, so encrypted columns cannot be used in WHERE clauses,
+// unique indexes, ORDER BY or joins - queries would compare ciphertext.
+type EncryptedSerializer struct{}
+
+// RegisterSerializers registers the custom GORM serializers
+func RegisterSerializers() {
+	schema.RegisterSerializer(EncryptedSerializerName, EncryptedSerializer{})
+}
+
+// Scan decrypts the database value into the field
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	if dbValue == nil {
+		return nil
+	}
+
+	var cipherText string
+	switch v := dbValue.(type) {
+	case string:
+		cipherText = v
+	case []byte:
+		cipherText = string(v)
+	default:
+		return fmt.Errorf("unsupported encrypted column value type %T for field %s", dbValue, field.Name)
+	}
+
+	plainText, err := utils.Decrypt(cipherText, config.Get().Encryption.Key)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt field %s: %w", field.Name, err)
+	}
+
+	fieldValue := field.ReflectValueOf(ctx, dst)
+	switch field.FieldType.Kind() {
+	case reflect.String:
+		fieldValue.SetString(plainText)
+	case reflect.Ptr:
+		if field.FieldType.Elem().Kind() != reflect.String {
+			return fmt.Errorf("encrypted serializer does not support field %s of type %s", field.Name, field.FieldType)
+		}
+		fieldValue.Set(reflect.ValueOf(&plainText))
+	default:
+		return fmt.Errorf("encrypted serializer does not support field %s of type %s", field.Name, field.FieldType)
+	}
+
+	return nil
+}
+
+// Value encrypts the field value before it is written
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var plainText string
+	switch v := fieldValue.(type) {
+	case string:
+		plainText = v
+	case *string:
+		if v == nil {
+			return nil, nil
+		}
+		plainText = *v
+	default:
+		return nil, fmt.Errorf("encrypted serializer does not support field %s of type %T", field.Name, fieldValue)
+	}
+
+	cipherText, err := utils.Encrypt(plainText, config.Get().Encryption.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt field %s: %w", field.Name, err)
+	}
+
+	return cipherText, nil
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"go-api-boilerplate/utils"
+)
+
+// secretRecord is a model with encrypted string and *string columns
+type secretRecord struct {
+	ID    uint
+	Phone string  `gorm:"serializer:encrypted"`
+	Note  *string `gorm:"serializer:encrypted"`
+}
+
+// rawSecret reads the stored columns of a secretRecord without decrypting
+func rawSecret(t *testing.T, db *DB, id uint) (phone, note sql.NullString) {
+	t.Helper()
+
+	row := db.Write.Raw("SELECT phone, note FROM secret_records WHERE id = ?", id).Row()
+	if err := row.Scan(&phone, &note); err != nil {
+		t.Fatalf("failed to read raw columns: %v", err)
+	}
+	return phone, note
+}
+
+func TestEncryptedSerializerRoundTrip(t *testing.T) {
+	db := newTestDB(t, loadTestConfig(t, nil))
+	if err := db.Write.AutoMigrate(&secretRecord{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	ctx := context.Background()
+
+	note := "call after 5pm"
+	tests := []struct {
+		name  string
+		phone string
+		note  *string
+	}{
+		{"string and pointer", "+15551234567", &note},
+		{"nil pointer", "+15557654321", nil},
+		{"empty string", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &secretRecord{Phone: tt.phone, Note: tt.note}
+			if err := db.Write.WithContext(ctx).Create(record).Error; err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			// The columns hold ciphertext that decrypts to the plaintext
+			phone, storedNote := rawSecret(t, db, record.ID)
+			if !phone.Valid || phone.String == tt.phone {
+				t.Errorf("phone column = %+v, want ciphertext", phone)
+			} else if plain, err := utils.Decrypt(phone.String, testEncryptionKey); err != nil || plain != tt.phone {
+				t.Errorf("phone column decrypts to %q, %v, want %q", plain, err, tt.phone)
+			}
+			switch {
+			case tt.note == nil && storedNote.Valid:
+				t.Errorf("note column = %q, want NULL", storedNote.String)
+			case tt.note != nil && (!storedNote.Valid || strings.Contains(storedNote.String, *tt.note)):
+				t.Errorf("note column = %+v, want ciphertext", storedNote)
+			}
+
+			var loaded secretRecord
+			if err := db.Write.WithContext(ctx).First(&loaded, record.ID).Error; err != nil {
+				t.Fatalf("First: %v", err)
+			}
+			if loaded.Phone != tt.phone {
+				t.Errorf("loaded phone = %q, want %q", loaded.Phone, tt.phone)
+			}
+			switch {
+			case tt.note == nil && loaded.Note != nil:
+				t.Errorf("loaded note = %q, want nil", *loaded.Note)
+			case tt.note != nil && (loaded.Note == nil || *loaded.Note != *tt.note):
+				t.Errorf("loaded note = %v, want %q", loaded.Note, *tt.note)
+			}
+		})
+	}
+}
+
+func TestEncryptedSerializerReadsLegacyCiphertext(t *testing.T) {
+	db := newTestDB(t, loadTestConfig(t, nil))
+	if err := db.Write.AutoMigrate(&secretRecord{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	// Seal a value the way Encrypt did before versioned ciphertexts: the raw
+	// key and nonce || sealed data
+	block, err := aes.NewCipher([]byte(testEncryptionKey))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	legacy := base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte("+15550000000"), nil))
+
+	if err := db.Write.Exec("INSERT INTO secret_records (id, phone) VALUES (?, ?)", 1, legacy).Error; err != nil {
+		t.Fatalf("failed to insert legacy row: %v", err)
+	}
+
+	var loaded secretRecord
+	if err := db.Write.First(&loaded, 1).Error; err != nil {
+		t.Fatalf("First: %v", err)
+	}
+	if loaded.Phone != "+15550000000" {
+		t.Errorf("loaded phone = %q, want +15550000000", loaded.Phone)
+	}
+}
+
+func TestEncryptedSerializerWrongKey(t *testing.T) {
+	cfg := loadTestConfig(t, nil)
+	db := newTestDB(t, cfg)
+	if err := db.Write.AutoMigrate(&secretRecord{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if err := db.Write.Create(&secretRecord{ID: 1, Phone: "+15551234567"}).Error; err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Rows written under another key fail to load rather than returning garbage
+	cfg.Encryption.Key = "fedcba9876543210fedcba9876543210"
+	var loaded secretRecord
+	if err := db.Write.First(&loaded, 1).Error; err == nil {
+		t.Errorf("First with the wrong key = %q, want an error", loaded.Phone)
+	}
+}
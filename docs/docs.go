@@ -44,8 +44,6 @@ var SwaggerInfo = &swag.Spec{
 	Description:      "A comprehensive Golang API boilerplate with REST and gRPC support",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
-	LeftDelim:        "{{",
-	RightDelim:       "}}",
 }
 
 func init() {
@@ -2,7 +2,9 @@ package interceptors
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"runtime/debug"
 	"strings"
 	"time"
@@ -10,12 +12,26 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
 	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/pkg/tracing"
+	"go-api-boilerplate/services"
 	"go-api-boilerplate/utils"
 )
 
+// ctxKey is the type for context keys set by the interceptors, so they
+// cannot collide with keys from other packages
+type ctxKey int
+
+const (
+	userIDKey ctxKey = iota
+	userEmailKey
+	userRoleKey
+	claimsKey
+)
+
 // LoggingInterceptor logs gRPC requests
 func LoggingInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -95,6 +111,66 @@ func StreamLoggingInterceptor() grpc.StreamServerInterceptor {
 	}
 }
 
+// TraceIDInterceptor exposes the trace ID of the span started by the
+// otelgrpc stats handler as response metadata
+func TraceIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		setTraceIDHeader(ctx)
+		return handler(ctx, req)
+	}
+}
+
+// StreamTraceIDInterceptor exposes the trace ID for streaming calls
+func StreamTraceIDInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		setTraceIDHeader(ss.Context())
+		return handler(srv, ss)
+	}
+}
+
+// DeadlineInterceptor gives unary calls that arrive without a deadline one of
+// timeout, so a client that never gives up cannot hold a handler forever. The
+// handler runs with the derived context, so context-aware database and Redis
+// calls stop early; if it has not returned when the deadline passes the call
+// fails with DeadlineExceeded and the late result is discarded. Place it
+// before RecoveryInterceptor so panics are recovered in the handler's
+// goroutine. Streams are long-lived and are not bounded. A timeout of 0
+// disables the interceptor.
+func DeadlineInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+		if _, ok := ctx.Deadline(); ok {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		type result struct {
+			resp interface{}
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			resp, err := handler(ctx, req)
+			done <- result{resp, err}
+		}()
+
+		select {
+		case r := <-done:
+			return r.resp, r.err
+		case <-ctx.Done():
+			logger.WithFields(map[string]interface{}{
+				"method":   info.FullMethod,
+				"deadline": timeout.String(),
+			}).Warn("gRPC handler exceeded the default deadline")
+			return nil, status.Error(codes.DeadlineExceeded, "request exceeded the server deadline")
+		}
+	}
+}
+
 // RecoveryInterceptor recovers from panics
 func RecoveryInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
@@ -136,7 +212,7 @@ func StreamRecoveryInterceptor() grpc.StreamServerInterceptor {
 }
 
 // AuthInterceptor validates authentication
-func AuthInterceptor() grpc.UnaryServerInterceptor {
+func AuthInterceptor(authService *services.AuthService) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		// Skip auth for certain methods
 		if shouldSkipAuth(info.FullMethod) {
@@ -163,23 +239,28 @@ func AuthInterceptor() grpc.UnaryServerInterceptor {
 		// Validate token
 		claims, err := utils.ValidateToken(token)
 		if err != nil {
-			if utils.IsTokenExpired(err) {
+			switch {
+			case utils.IsTokenExpired(err):
 				return nil, status.Errorf(codes.Unauthenticated, "token expired")
+			case errors.Is(err, utils.ErrInvalidAudience):
+				return nil, status.Errorf(codes.Unauthenticated, "invalid token audience")
+			case errors.Is(err, utils.ErrUntrustedIssuer):
+				return nil, status.Errorf(codes.Unauthenticated, "untrusted token issuer")
 			}
 			return nil, status.Errorf(codes.Unauthenticated, "invalid token")
 		}
 
-		// Add user info to context
-		ctx = context.WithValue(ctx, "user_id", claims.UserID)
-		ctx = context.WithValue(ctx, "user_email", claims.Email)
-		ctx = context.WithValue(ctx, "user_role", claims.Role)
+		if authService != nil && authService.IsTokenRevoked(ctx, claims) {
+			return nil, status.Errorf(codes.Unauthenticated, "token has been revoked")
+		}
 
-		return handler(ctx, req)
+		// Add user info to context
+		return handler(withClaims(ctx, claims), req)
 	}
 }
 
 // StreamAuthInterceptor validates authentication for streams
-func StreamAuthInterceptor() grpc.StreamServerInterceptor {
+func StreamAuthInterceptor(authService *services.AuthService) grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		// Skip auth for certain methods
 		if shouldSkipAuth(info.FullMethod) {
@@ -206,22 +287,25 @@ func StreamAuthInterceptor() grpc.StreamServerInterceptor {
 		// Validate token
 		claims, err := utils.ValidateToken(token)
 		if err != nil {
-			if utils.IsTokenExpired(err) {
+			switch {
+			case utils.IsTokenExpired(err):
 				return status.Errorf(codes.Unauthenticated, "token expired")
+			case errors.Is(err, utils.ErrInvalidAudience):
+				return status.Errorf(codes.Unauthenticated, "invalid token audience")
+			case errors.Is(err, utils.ErrUntrustedIssuer):
+				return status.Errorf(codes.Unauthenticated, "untrusted token issuer")
 			}
 			return status.Errorf(codes.Unauthenticated, "invalid token")
 		}
 
+		if authService != nil && authService.IsTokenRevoked(ss.Context(), claims) {
+			return status.Errorf(codes.Unauthenticated, "token has been revoked")
+		}
+
 		// Create wrapped stream with auth context
 		wrappedStream := &authenticatedServerStream{
 			ServerStream: ss,
-			ctx: context.WithValue(
-				context.WithValue(
-					context.WithValue(ss.Context(), "user_id", claims.UserID),
-					"user_email", claims.Email,
-				),
-				"user_role", claims.Role,
-			),
+			ctx:          withClaims(ss.Context(), claims),
 		}
 
 		return handler(srv, wrappedStream)
@@ -242,33 +326,164 @@ func ValidationInterceptor() grpc.UnaryServerInterceptor {
 	}
 }
 
-// RateLimitInterceptor implements rate limiting
-func RateLimitInterceptor(limit int, window time.Duration) grpc.UnaryServerInterceptor {
+// StreamValidationInterceptor validates every message received on a stream
+func StreamValidationInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingServerStream{ServerStream: ss})
+	}
+}
+
+// RateLimitInterceptor implements Redis-backed rate limiting for unary calls.
+// Quota is reported through x-ratelimit-* response headers, mirroring the
+// HTTP middleware. Requests are allowed through if Redis is unavailable.
+// Anonymous callers are keyed by address; x-forwarded-for is only believed
+// from trustedProxies, the IPs and CIDRs of TRUSTED_PROXIES.
+func RateLimitInterceptor(redis *services.RedisService, limit int, window time.Duration, trustedProxies []string) grpc.UnaryServerInterceptor {
+	proxies := parseTrustedProxies(trustedProxies)
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		// Extract client identifier
-		var key string
-		if userID := ctx.Value("user_id"); userID != nil {
-			key = fmt.Sprintf("grpc_rate:%v:%s", userID, info.FullMethod)
-		} else {
-			// Use IP address
-			md, _ := metadata.FromIncomingContext(ctx)
-			if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 {
-				key = fmt.Sprintf("grpc_rate:%s:%s", forwarded[0], info.FullMethod)
-			} else {
-				key = fmt.Sprintf("grpc_rate:unknown:%s", info.FullMethod)
-			}
+		if !redis.Available() {
+			return handler(ctx, req)
 		}
 
-		fmt.Printf("Key %s\n", key)
+		md, allowed, err := checkRateLimit(ctx, redis, info.FullMethod, limit, window, proxies)
+		if err != nil {
+			return handler(ctx, req)
+		}
 
-		// Check rate limit (would need Redis service)
-		// This is a simplified implementation
-		// In production, use Redis for distributed rate limiting
+		grpc.SetHeader(ctx, md)
+		if !allowed {
+			// Rejected calls are trailers-only, so repeat the quota there
+			grpc.SetTrailer(ctx, md)
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded")
+		}
 
 		return handler(ctx, req)
 	}
 }
 
+// StreamRateLimitInterceptor implements Redis-backed rate limiting for streams.
+// The limit applies to opening streams, not to individual messages.
+func StreamRateLimitInterceptor(redis *services.RedisService, limit int, window time.Duration, trustedProxies []string) grpc.StreamServerInterceptor {
+	proxies := parseTrustedProxies(trustedProxies)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !redis.Available() {
+			return handler(srv, ss)
+		}
+
+		md, allowed, err := checkRateLimit(ss.Context(), redis, info.FullMethod, limit, window, proxies)
+		if err != nil {
+			return handler(srv, ss)
+		}
+
+		ss.SetHeader(md)
+		if !allowed {
+			ss.SetTrailer(md)
+			return status.Errorf(codes.ResourceExhausted, "rate limit exceeded")
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// checkRateLimit counts the call against the caller's quota and returns the
+// rate limit metadata to send back
+func checkRateLimit(ctx context.Context, redis *services.RedisService, method string, limit int, window time.Duration, proxies []*net.IPNet) (metadata.MD, bool, error) {
+	allowed, remaining, err := redis.WithContext(ctx).RateLimitCheck(rateLimitKey(ctx, method, proxies), limit, window)
+	if err != nil {
+		return nil, false, err
+	}
+
+	md := metadata.Pairs(
+		"x-ratelimit-limit", fmt.Sprintf("%d", limit),
+		"x-ratelimit-remaining", fmt.Sprintf("%d", remaining),
+		"x-ratelimit-reset", fmt.Sprintf("%d", time.Now().Add(window).Unix()),
+	)
+
+	return md, allowed, nil
+}
+
+// rateLimitKey identifies the caller by user ID, falling back to client address
+func rateLimitKey(ctx context.Context, method string, proxies []*net.IPNet) string {
+	if userID, err := GetUserIDFromContext(ctx); err == nil {
+		return fmt.Sprintf("grpc_rate:user:%d:%s", userID, method)
+	}
+
+	if ip := clientIP(ctx, proxies); ip != "" {
+		return fmt.Sprintf("grpc_rate:ip:%s:%s", ip, method)
+	}
+
+	return fmt.Sprintf("grpc_rate:unknown:%s", method)
+}
+
+// clientIP returns the caller's address: the connection's peer, or when the
+// peer is a trusted proxy, the last x-forwarded-for address that is not
+// one, the way Gin resolves it for HTTP. It is empty when there is no peer.
+func clientIP(ctx context.Context, proxies []*net.IPNet) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	ip := p.Addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !trustedProxy(ip, proxies) {
+		return ip
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var hops []string
+	for _, header := range md.Get("x-forwarded-for") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop, proxies) {
+			break
+		}
+	}
+	return ip
+}
+
+// parseTrustedProxies turns TRUSTED_PROXIES entries into networks, single
+// IPs becoming one-address networks. Config validation has already
+// rejected malformed entries, which are skipped.
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	proxies := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			proxies = append(proxies, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return proxies
+}
+
+// trustedProxy reports whether ip is in one of the proxy networks
+func trustedProxy(ip string, proxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 // Helper functions
 
 // shouldSkipAuth checks if a method should skip authentication
@@ -280,6 +495,7 @@ func shouldSkipAuth(method string) bool {
 		"/boilerplate.v1.AuthService/ForgotPassword",
 		"/boilerplate.v1.AuthService/ResetPassword",
 		"/boilerplate.v1.AuthService/VerifyEmail",
+		"/boilerplate.v1.VersionService/GetVersion",
 		"/grpc.health.v1.Health/Check",
 		"/grpc.health.v1.Health/Watch",
 	}
@@ -293,6 +509,15 @@ func shouldSkipAuth(method string) bool {
 	return false
 }
 
+// withClaims stores the authenticated user's details in the context
+func withClaims(ctx context.Context, claims *utils.JWTClaims) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, claims.UserID)
+	ctx = context.WithValue(ctx, userEmailKey, claims.Email)
+	ctx = context.WithValue(ctx, userRoleKey, claims.Role)
+	ctx = context.WithValue(ctx, claimsKey, claims)
+	return ctx
+}
+
 // extractToken extracts token from authorization header
 func extractToken(auth string) (string, error) {
 	const prefix = "Bearer "
@@ -312,6 +537,32 @@ func (s *authenticatedServerStream) Context() context.Context {
 	return s.ctx
 }
 
+// validatingServerStream wraps ServerStream to validate received messages
+type validatingServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if v, ok := m.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// setTraceIDHeader sends the active trace ID in the response headers
+func setTraceIDHeader(ctx context.Context) {
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(tracing.TraceIDHeader), traceID))
+	}
+}
+
 // MetricsInterceptor collects metrics
 func MetricsInterceptor() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -341,12 +592,12 @@ func MetricsInterceptor() grpc.UnaryServerInterceptor {
 
 // GetUserIDFromContext extracts user ID from context
 func GetUserIDFromContext(ctx context.Context) (uint, error) {
-	userID := ctx.Value("user_id")
+	userID := ctx.Value(userIDKey)
 	if userID == nil {
 		return 0, status.Errorf(codes.Unauthenticated, "user not authenticated")
 	}
 
-	id, ok := userID.(uint)
+	id, ok := utils.ToUserID(userID)
 	if !ok {
 		return 0, status.Errorf(codes.Internal, "invalid user ID type")
 	}
@@ -354,9 +605,19 @@ func GetUserIDFromContext(ctx context.Context) (uint, error) {
 	return id, nil
 }
 
+// GetUserEmailFromContext extracts user email from context
+func GetUserEmailFromContext(ctx context.Context) (string, error) {
+	email, ok := ctx.Value(userEmailKey).(string)
+	if !ok {
+		return "", status.Errorf(codes.Unauthenticated, "user email not found")
+	}
+
+	return email, nil
+}
+
 // GetUserRoleFromContext extracts user role from context
 func GetUserRoleFromContext(ctx context.Context) (string, error) {
-	role := ctx.Value("user_role")
+	role := ctx.Value(userRoleKey)
 	if role == nil {
 		return "", status.Errorf(codes.Unauthenticated, "user role not found")
 	}
@@ -369,6 +630,17 @@ func GetUserRoleFromContext(ctx context.Context) (string, error) {
 	return roleStr, nil
 }
 
+// GetClaimsFromContext extracts the token claims, including any extra
+// claims, from context
+func GetClaimsFromContext(ctx context.Context) (*utils.JWTClaims, error) {
+	claims, ok := ctx.Value(claimsKey).(*utils.JWTClaims)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user not authenticated")
+	}
+
+	return claims, nil
+}
+
 // RequireRole creates an interceptor that requires specific roles
 func RequireRole(roles ...string) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -0,0 +1,416 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+)
+
+func TestRateLimitKeyTrustsOnlyConfiguredProxies(t *testing.T) {
+	proxies := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"spoofed header from a client", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted single IP", "192.0.2.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client-supplied hop before the proxy", "10.1.2.3:5000", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:5000", []string{"198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"trusted proxy without header", "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"garbage header", "10.1.2.3:5000", []string{"not-an-ip"}, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", tt.peer)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		if tt.forwarded != nil {
+			ctx = metadata.NewIncomingContext(ctx, metadata.MD{"x-forwarded-for": tt.forwarded})
+		}
+
+		if got, want := rateLimitKey(ctx, "/m", proxies), "grpc_rate:ip:"+tt.want+":/m"; got != want {
+			t.Errorf("%s: key = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+// newRateLimitedHealthClient serves the health service over an in-memory
+// connection behind the unary rate limit interceptor, allowing limit calls
+// per minute against a miniredis server
+func newRateLimitedHealthClient(t *testing.T, limit int) healthpb.HealthClient {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("JWT_SECRET", "test-secret-that-is-at-least-32-characters")
+	t.Setenv("REDIS_HOST", server.Host())
+	t.Setenv("REDIS_PORT", server.Port())
+	if _, err := config.Load(); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	redis, err := services.NewRedisService()
+	if err != nil {
+		t.Fatalf("failed to connect to Redis: %v", err)
+	}
+	t.Cleanup(func() { redis.Close() })
+
+	return newHealthClient(t, grpc.UnaryInterceptor(RateLimitInterceptor(redis, limit, time.Minute, nil)))
+}
+
+// newHealthClient serves the health service with opts over an in-memory
+// listener and returns a client for it
+func newHealthClient(t *testing.T, opts ...grpc.ServerOption) healthpb.HealthClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestRateLimitInterceptorReportsQuota(t *testing.T) {
+	client := newRateLimitedHealthClient(t, 2)
+
+	for i := 1; i <= 3; i++ {
+		var header, trailer metadata.MD
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Header(&header), grpc.Trailer(&trailer))
+
+		// Allowed calls report the quota in the headers; the rejected one
+		// is trailers-only, so it repeats it in the trailers
+		quota := header
+		wantCode, wantRemaining := codes.OK, 2-i
+		if i == 3 {
+			quota = trailer
+			wantCode, wantRemaining = codes.ResourceExhausted, 0
+		}
+		if status.Code(err) != wantCode {
+			t.Fatalf("call %d: got %v, want %v", i, err, wantCode)
+		}
+
+		if got := quota.Get("x-ratelimit-limit"); len(got) != 1 || got[0] != "2" {
+			t.Errorf("call %d: x-ratelimit-limit = %v, want 2", i, got)
+		}
+		if got := quota.Get("x-ratelimit-remaining"); len(got) != 1 || got[0] != strconv.Itoa(wantRemaining) {
+			t.Errorf("call %d: x-ratelimit-remaining = %v, want %d", i, got, wantRemaining)
+		}
+		reset := quota.Get("x-ratelimit-reset")
+		if len(reset) != 1 {
+			t.Fatalf("call %d: x-ratelimit-reset = %v, want one value", i, reset)
+		}
+		if at, err := strconv.ParseInt(reset[0], 10, 64); err != nil || at <= time.Now().Unix() {
+			t.Errorf("call %d: x-ratelimit-reset = %s, want a Unix time in the future", i, reset[0])
+		}
+	}
+}
+
+func TestTraceIDInterceptorExposesServerSpan(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	client := newHealthClient(t,
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithTracerProvider(provider))),
+		grpc.UnaryInterceptor(TraceIDInterceptor()),
+	)
+
+	var header metadata.MD
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.Header(&header)); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(ended))
+	}
+	if got := ended[0].Name(); got != "grpc.health.v1.Health/Check" {
+		t.Errorf("span name = %q, want grpc.health.v1.Health/Check", got)
+	}
+	want := ended[0].SpanContext().TraceID().String()
+	if got := header.Get("x-trace-id"); len(got) != 1 || got[0] != want {
+		t.Errorf("x-trace-id = %v, want %s", got, want)
+	}
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Slow"}
+
+	// slow waits for its context or a second, whichever comes first, and
+	// reports whether its context carried a deadline
+	slow := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		_, hasDeadline := ctx.Deadline()
+		select {
+		case <-ctx.Done():
+			return hasDeadline, ctx.Err()
+		case <-time.After(time.Second):
+			return hasDeadline, nil
+		}
+	}
+
+	t.Run("slow handler without a client deadline", func(t *testing.T) {
+		handlerCtx := make(chan context.Context, 1)
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			handlerCtx <- ctx
+			return slow(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := DeadlineInterceptor(50*time.Millisecond)(context.Background(), nil, info, handler)
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("call returned after %v, want about the 50ms default deadline", elapsed)
+		}
+		if status.Code(err) != codes.DeadlineExceeded || resp != nil {
+			t.Errorf("got %v, %v, want DeadlineExceeded", resp, err)
+		}
+
+		// The handler saw the derived context, so its downstream calls were
+		// cancelled too
+		ctx := <-handlerCtx
+		if _, ok := ctx.Deadline(); !ok || ctx.Err() == nil {
+			t.Error("handler context has no deadline or was not cancelled")
+		}
+	})
+
+	t.Run("fast handler", func(t *testing.T) {
+		handler := func(context.Context, interface{}) (interface{}, error) { return "done", nil }
+		resp, err := DeadlineInterceptor(time.Second)(context.Background(), nil, info, handler)
+		if err != nil || resp != "done" {
+			t.Errorf("got %v, %v, want the handler's response", resp, err)
+		}
+	})
+
+	t.Run("client deadline is kept", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := DeadlineInterceptor(50*time.Millisecond)(ctx, nil, info, slow)
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("call returned after %v, want the client's 200ms deadline", elapsed)
+		}
+		if err != context.DeadlineExceeded {
+			t.Errorf("got %v, want the handler's own context error", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		resp, err := DeadlineInterceptor(0)(context.Background(), nil, info, slow)
+		if err != nil || resp != false {
+			t.Errorf("got %v, %v, want the handler to run to completion without a deadline", resp, err)
+		}
+	})
+}
+
+// testMessage is a stream message that is valid when it has a name
+type testMessage struct {
+	Name string
+}
+
+func (m *testMessage) Validate() error {
+	if m.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// fakeServerStream delivers queued messages to RecvMsg, then io.EOF. Its
+// context is ctx, or the background context when ctx is nil.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	queue []testMessage
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	if len(s.queue) == 0 {
+		return io.EOF
+	}
+	*m.(*testMessage) = s.queue[0]
+	s.queue = s.queue[1:]
+	return nil
+}
+
+func TestStreamValidationInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		queue    []testMessage
+		wantCode codes.Code
+		wantSeen []string
+	}{
+		{"valid messages", []testMessage{{Name: "a"}, {Name: "b"}}, codes.OK, []string{"a", "b"}},
+		{"invalid message", []testMessage{{Name: "a"}, {}, {Name: "c"}}, codes.InvalidArgument, []string{"a"}},
+	}
+
+	interceptor := StreamValidationInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream", IsClientStream: true}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen []string
+			handler := func(srv interface{}, stream grpc.ServerStream) error {
+				for {
+					var msg testMessage
+					if err := stream.RecvMsg(&msg); err != nil {
+						if errors.Is(err, io.EOF) {
+							return nil
+						}
+						return err
+					}
+					seen = append(seen, msg.Name)
+				}
+			}
+
+			err := interceptor(nil, &fakeServerStream{queue: tt.queue}, info, handler)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("error = %v, want %v", err, tt.wantCode)
+			}
+			if len(seen) != len(tt.wantSeen) {
+				t.Fatalf("handler received %v, want %v", seen, tt.wantSeen)
+			}
+			for i := range seen {
+				if seen[i] != tt.wantSeen[i] {
+					t.Errorf("handler received %v, want %v", seen, tt.wantSeen)
+					break
+				}
+			}
+		})
+	}
+}
+
+// authContext returns an incoming context carrying authorization metadata
+func authContext(authorization string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", authorization))
+}
+
+func TestAuthInterceptorSetsUserContext(t *testing.T) {
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("JWT_SECRET", "test-secret-that-is-at-least-32-characters")
+	if _, err := config.Load(); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	tokens, err := utils.GenerateTokens(7, "a@example.com", "Ada", "admin", true)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      codes.Code
+	}{
+		{"valid token", "Bearer " + tokens.AccessToken, codes.OK},
+		{"refresh token", "Bearer " + tokens.RefreshToken, codes.Unauthenticated},
+		{"not a bearer token", tokens.AccessToken, codes.Unauthenticated},
+		{"garbage", "Bearer garbage", codes.Unauthenticated},
+	}
+
+	// checkUser reads the user back through the context getters
+	checkUser := func(t *testing.T, ctx context.Context) {
+		t.Helper()
+
+		userID, err := GetUserIDFromContext(ctx)
+		if err != nil || userID != 7 {
+			t.Errorf("GetUserIDFromContext = %d, %v, want 7", userID, err)
+		}
+		if email, err := GetUserEmailFromContext(ctx); err != nil || email != "a@example.com" {
+			t.Errorf("GetUserEmailFromContext = %q, %v", email, err)
+		}
+		if role, err := GetUserRoleFromContext(ctx); err != nil || role != "admin" {
+			t.Errorf("GetUserRoleFromContext = %q, %v", role, err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unaryInfo := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}
+			called := false
+			_, err := AuthInterceptor(nil)(authContext(tt.authorization), nil, unaryInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				checkUser(t, ctx)
+				return nil, nil
+			})
+			if status.Code(err) != tt.wantCode || called != (tt.wantCode == codes.OK) {
+				t.Errorf("unary: error = %v, handler called = %v, want %v", err, called, tt.wantCode)
+			}
+
+			streamInfo := &grpc.StreamServerInfo{FullMethod: "/test.Service/Watch", IsServerStream: true}
+			called = false
+			stream := &fakeServerStream{ctx: authContext(tt.authorization)}
+			err = StreamAuthInterceptor(nil)(nil, stream, streamInfo, func(srv interface{}, ss grpc.ServerStream) error {
+				called = true
+				checkUser(t, ss.Context())
+				return nil
+			})
+			if status.Code(err) != tt.wantCode || called != (tt.wantCode == codes.OK) {
+				t.Errorf("stream: error = %v, handler called = %v, want %v", err, called, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestUserContextGettersWithoutAuth(t *testing.T) {
+	ctx := context.Background()
+	if _, err := GetUserIDFromContext(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetUserIDFromContext = %v, want Unauthenticated", err)
+	}
+	if _, err := GetUserEmailFromContext(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetUserEmailFromContext = %v, want Unauthenticated", err)
+	}
+	if _, err := GetUserRoleFromContext(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetUserRoleFromContext = %v, want Unauthenticated", err)
+	}
+
+	// Values under bare string keys are not read as the user
+	ctx = context.WithValue(ctx, "user_id", uint(7))
+	if _, err := GetUserIDFromContext(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetUserIDFromContext read a string key: %v", err)
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -19,6 +22,9 @@ import (
 	"go-api-boilerplate/grpc/proto"
 	"go-api-boilerplate/grpc/server"
 	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/pkg/profiling"
+	"go-api-boilerplate/pkg/shutdown"
+	"go-api-boilerplate/pkg/tracing"
 	"go-api-boilerplate/services"
 )
 
@@ -34,6 +40,30 @@ func main() {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
 
+	// Initialize tracing (no-op when OTEL_ENABLED=false)
+	if err := tracing.Init(cfg); err != nil {
+		logger.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracing.Shutdown(ctx); err != nil {
+			logger.Warnf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	// Serve pprof on its own admin listener (no-op when PPROF_ENABLED=false)
+	if err := profiling.Init(cfg); err != nil {
+		logger.Fatalf("Failed to start pprof server: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := profiling.Shutdown(ctx); err != nil {
+			logger.Warnf("Failed to stop pprof server: %v", err)
+		}
+	}()
+
 	// Connect to database
 	db, err := database.Connect(cfg)
 	if err != nil {
@@ -41,6 +71,11 @@ func main() {
 	}
 	defer database.Close()
 
+	// Run schema migrations
+	if err := database.Migrate(); err != nil {
+		logger.Fatalf("Failed to run database migrations: %v", err)
+	}
+
 	// Initialize Redis
 	redisService, err := services.NewRedisService()
 	if err != nil {
@@ -48,40 +83,85 @@ func main() {
 		// Continue without Redis - it's optional
 	}
 
+	// Initialize services
+	authService := services.NewAuthService(db, redisService)
+	userService := services.NewUserService(db, redisService)
+
+	// User changes and cache invalidations reach every instance through
+	// the event bus; without Redis it only delivers locally
+	eventBus := services.NewEventBus(redisService)
+	defer eventBus.Close()
+	authService.SetEventBus(eventBus)
+	userService.SetEventBus(eventBus)
+
+	// Deleting a user ends their cookie sessions and removes their files
+	userService.SetSessionStore(services.NewSessionStore(cfg, redisService))
+	userService.SetFileStore(services.NewUploadService(db))
+
+	// Delete expired and used password reset and verification tokens
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	authService.StartTokenCleanup(cleanupCtx, cfg.Auth.TokenCleanupInterval)
+
 	// Create gRPC server with interceptors
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		interceptors.LoggingInterceptor(),
+		interceptors.DeadlineInterceptor(cfg.GRPC.DefaultDeadline),
+		interceptors.RecoveryInterceptor(),
+		interceptors.AuthInterceptor(authService),
+		interceptors.ValidationInterceptor(),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		interceptors.StreamLoggingInterceptor(),
+		interceptors.StreamRecoveryInterceptor(),
+		interceptors.StreamAuthInterceptor(authService),
+		interceptors.StreamValidationInterceptor(),
+	}
+
+	// Rate limiting runs after auth so authenticated callers are keyed by user
+	if cfg.RateLimit.Enabled && redisService.Available() {
+		unaryInterceptors = append(unaryInterceptors, interceptors.RateLimitInterceptor(redisService, cfg.RateLimit.Requests, cfg.RateLimit.Duration, cfg.Server.TrustedProxies))
+		streamInterceptors = append(streamInterceptors, interceptors.StreamRateLimitInterceptor(redisService, cfg.RateLimit.Requests, cfg.RateLimit.Duration, cfg.Server.TrustedProxies))
+	}
+
+	// The trace ID is exposed first so failed calls can still be correlated
+	if tracing.Enabled() {
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{interceptors.TraceIDInterceptor()}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{interceptors.StreamTraceIDInterceptor()}, streamInterceptors...)
+	}
+
 	opts := []grpc.ServerOption{
-		grpc.ChainUnaryInterceptor(
-			interceptors.LoggingInterceptor(),
-			interceptors.RecoveryInterceptor(),
-			interceptors.AuthInterceptor(),
-			interceptors.ValidationInterceptor(),
-		),
-		grpc.ChainStreamInterceptor(
-			interceptors.StreamLoggingInterceptor(),
-			interceptors.StreamRecoveryInterceptor(),
-			interceptors.StreamAuthInterceptor(),
-		),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
 	}
+	if tracing.Enabled() {
+		// otelgrpc starts the server span before any interceptor runs
+		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	}
+	serverOpts, err := server.ServerOptions(cfg)
+	if err != nil {
+		logger.Fatalf("Failed to configure gRPC server: %v", err)
+	}
+	opts = append(opts, serverOpts...)
 
 	grpcServer := grpc.NewServer(opts...)
 
-	// Initialize services
-	authService := services.NewAuthService(db, redisService)
-	userService := services.NewUserService(db)
-
 	// Register gRPC services
 	authServer := server.NewAuthServer(authService, userService)
 	userServer := server.NewUserServer(userService)
 
 	proto.RegisterAuthServiceServer(grpcServer, authServer)
 	proto.RegisterUserServiceServer(grpcServer, userServer)
+	proto.RegisterVersionServiceServer(grpcServer, server.NewVersionServer())
 
 	// Register health check
 	healthServer := health.NewServer()
 	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
 
-	// Register reflection service for debugging
-	reflection.Register(grpcServer)
+	// Reflection exposes the full service schema, so it stays off in production
+	if cfg.GRPCReflectionEnabled() {
+		reflection.Register(grpcServer)
+	}
 
 	// Create listener
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.App.GRPCPort))
@@ -91,7 +171,7 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		logger.Infof("Starting gRPC server on port %s", cfg.App.GRPCPort)
+		logger.Infof("Starting gRPC server on port %s (TLS: %t)", cfg.App.GRPCPort, cfg.TLS.Enabled)
 		if err := grpcServer.Serve(lis); err != nil {
 			logger.Fatalf("Failed to serve gRPC: %v", err)
 		}
@@ -103,6 +183,6 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down gRPC server...")
-	grpcServer.GracefulStop()
+	shutdown.GRPC(grpcServer, cfg.Server.ShutdownTimeout)
 	logger.Info("gRPC server exited")
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"google.golang.org/grpc/codes"
@@ -43,7 +44,7 @@ func (s *AuthServer) Login(ctx context.Context, req *proto.LoginRequest) (*proto
 	// In a real implementation, extract from metadata
 
 	// Authenticate user
-	user, err := s.authService.Login(req.Email, req.Password, clientIP)
+	user, err := s.authService.Login(ctx, req.Email, req.Password, clientIP)
 	if err != nil {
 		if err == services.ErrInvalidCredentials {
 			return nil, status.Errorf(codes.Unauthenticated, "invalid email or password")
@@ -55,7 +56,7 @@ func (s *AuthServer) Login(ctx context.Context, req *proto.LoginRequest) (*proto
 	}
 
 	// Generate tokens
-	tokens, err := s.authService.GenerateTokens(user)
+	tokens, err := s.authService.GenerateTokens(ctx, user)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to generate tokens")
 	}
@@ -82,15 +83,6 @@ func (s *AuthServer) Register(ctx context.Context, req *proto.RegisterRequest) (
 		return nil, status.Errorf(codes.InvalidArgument, "passwords do not match")
 	}
 
-	// Check if user already exists
-	exists, err := s.userService.UserExistsByEmail(req.Email)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to check user existence")
-	}
-	if exists {
-		return nil, status.Errorf(codes.AlreadyExists, "email already registered")
-	}
-
 	// Register user
 	input := &models.RegisterInput{
 		Email:           req.Email,
@@ -99,13 +91,16 @@ func (s *AuthServer) Register(ctx context.Context, req *proto.RegisterRequest) (
 		Name:            req.Name,
 	}
 
-	user, err := s.authService.Register(input)
+	user, err := s.authService.Register(ctx, input)
 	if err != nil {
+		if errors.Is(err, services.ErrUserAlreadyExists) {
+			return nil, status.Errorf(codes.AlreadyExists, "email already registered")
+		}
 		return nil, status.Errorf(codes.Internal, "registration failed")
 	}
 
 	// Generate tokens
-	tokens, err := s.authService.GenerateTokens(user)
+	tokens, err := s.authService.GenerateTokens(ctx, user)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to generate tokens")
 	}
@@ -127,7 +122,7 @@ func (s *AuthServer) RefreshToken(ctx context.Context, req *proto.RefreshTokenRe
 	}
 
 	// Refresh tokens
-	tokens, err := s.authService.RefreshTokens(req.RefreshToken)
+	tokens, err := s.authService.RefreshTokens(ctx, req.RefreshToken)
 	if err != nil {
 		if err == services.ErrInvalidToken {
 			return nil, status.Errorf(codes.Unauthenticated, "invalid refresh token")
@@ -152,7 +147,22 @@ func (s *AuthServer) Logout(ctx context.Context, req *proto.LogoutRequest) (*emp
 	}
 
 	// Logout user
-	if err := s.authService.Logout(userID, req.AccessToken); err != nil {
+	if err := s.authService.Logout(ctx, userID, req.AccessToken); err != nil {
+		return nil, status.Errorf(codes.Internal, "logout failed")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// LogoutAll revokes every token issued to the user
+func (s *AuthServer) LogoutAll(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
+	// Get user ID from context
+	userID, err := interceptors.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authService.LogoutAll(ctx, userID); err != nil {
 		return nil, status.Errorf(codes.Internal, "logout failed")
 	}
 
@@ -173,7 +183,7 @@ func (s *AuthServer) ChangePassword(ctx context.Context, req *proto.ChangePasswo
 	}
 
 	// Change password
-	if err := s.authService.ChangePassword(userID, req.OldPassword, req.NewPassword); err != nil {
+	if err := s.authService.ChangePassword(ctx, userID, req.OldPassword, req.NewPassword); err != nil {
 		if err == services.ErrInvalidCredentials {
 			return nil, status.Errorf(codes.InvalidArgument, "current password is incorrect")
 		}
@@ -190,7 +200,7 @@ func (s *AuthServer) ForgotPassword(ctx context.Context, req *proto.ForgotPasswo
 	}
 
 	// Initiate password reset
-	_ = s.authService.ForgotPassword(req.Email)
+	_ = s.authService.ForgotPassword(ctx, req.Email)
 	// Always return success to prevent email enumeration
 
 	return &emptypb.Empty{}, nil
@@ -208,7 +218,7 @@ func (s *AuthServer) ResetPassword(ctx context.Context, req *proto.ResetPassword
 	}
 
 	// Reset password
-	if err := s.authService.ResetPassword(req.Token, req.NewPassword); err != nil {
+	if err := s.authService.ResetPassword(ctx, req.Token, req.NewPassword); err != nil {
 		if err == services.ErrInvalidToken {
 			return nil, status.Errorf(codes.InvalidArgument, "invalid or expired reset token")
 		}
@@ -225,7 +235,7 @@ func (s *AuthServer) VerifyEmail(ctx context.Context, req *proto.VerifyEmailRequ
 	}
 
 	// Verify email
-	if err := s.authService.VerifyEmail(req.Token); err != nil {
+	if err := s.authService.VerifyEmail(ctx, req.Token); err != nil {
 		if err == services.ErrInvalidToken {
 			return nil, status.Errorf(codes.InvalidArgument, "invalid or expired verification token")
 		}
@@ -242,15 +252,20 @@ func (s *AuthServer) ValidateToken(ctx context.Context, req *proto.ValidateToken
 	}
 
 	// Validate token
-	user, err := s.authService.ValidateAccessToken(req.AccessToken)
+	user, err := s.authService.ValidateAccessToken(ctx, req.AccessToken)
 	if err != nil {
 		return &proto.ValidateTokenResponse{
 			Valid: false,
 		}, nil
 	}
 
-	// Get token expiration
-	claims, _ := utils.ParseTokenWithoutValidation(req.AccessToken)
+	// Get token expiration; a token without one is not treated as valid
+	claims, err := utils.ParseTokenWithoutValidation(req.AccessToken)
+	if err != nil || claims == nil || claims.ExpiresAt == nil {
+		return &proto.ValidateTokenResponse{
+			Valid: false,
+		}, nil
+	}
 
 	return &proto.ValidateTokenResponse{
 		Valid:     true,
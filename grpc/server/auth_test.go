@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/grpc/proto"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
+)
+
+// signToken signs access token claims for user with the configured secret
+func signToken(t *testing.T, user *models.User, expiresAt *jwt.NumericDate) string {
+	t.Helper()
+
+	claims := utils.JWTClaims{
+		UserID:   user.ID,
+		Email:    user.Email,
+		Role:     user.Role,
+		IsActive: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			ExpiresAt: expiresAt,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Get().JWT.Secret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestValidateTokenResponses(t *testing.T) {
+	loadTestConfig(t, nil)
+	db := newTestDB(t)
+	authService := services.NewAuthService(db, nil)
+	server := NewAuthServer(authService, services.NewUserService(db, nil))
+	ctx := context.Background()
+	user := createTestUser(t, db, "validate@example.com", models.RoleUser)
+
+	tokens, err := authService.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantValid bool
+	}{
+		{"valid token", tokens.AccessToken, true},
+		{"garbage", "not.a.token", false},
+		{"unsigned garbage", "garbage", false},
+		{"expired token", signToken(t, user, jwt.NewNumericDate(time.Now().Add(-time.Hour))), false},
+		{"token without expiry", signToken(t, user, nil), false},
+		{"refresh token", tokens.RefreshToken, false},
+	}
+	for _, tt := range tests {
+		resp, err := server.ValidateToken(ctx, &proto.ValidateTokenRequest{AccessToken: tt.token})
+		if err != nil {
+			t.Errorf("%s: ValidateToken: %v", tt.name, err)
+			continue
+		}
+		if resp.Valid != tt.wantValid {
+			t.Errorf("%s: valid = %v, want %v", tt.name, resp.Valid, tt.wantValid)
+		}
+		if tt.wantValid && (resp.User.GetId() != uint64(user.ID) || resp.ExpiresAt == nil) {
+			t.Errorf("%s: user %v, expires at %v", tt.name, resp.User, resp.ExpiresAt)
+		}
+	}
+
+	if _, err := server.ValidateToken(ctx, &proto.ValidateTokenRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("empty token: got %v, want InvalidArgument", err)
+	}
+}
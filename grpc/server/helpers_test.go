@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/utils"
+)
+
+// testPassword is the password of users created by createTestUser
+const testPassword = "Password123!"
+
+// loadTestConfig loads the configuration from the environment with a
+// throwaway SQLite database, no Redis and env overriding the defaults
+func loadTestConfig(t *testing.T, env map[string]string) *config.Config {
+	t.Helper()
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("APP_DEBUG", "false")
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("JWT_SECRET", "test-secret-that-is-at-least-32-characters")
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_NAME", filepath.Join(t.TempDir(), "test.db"))
+	t.Setenv("DB_CONNECT_ATTEMPTS", "1")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	return cfg
+}
+
+// newTestDB connects to and migrates the configured test database
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	db, err := database.Connect(config.Get())
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+// createTestUser stores an active user with testPassword
+func createTestUser(t *testing.T, db *database.DB, email, role string) *models.User {
+	t.Helper()
+
+	hash, err := utils.HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	user := &models.User{
+		Email:    email,
+		Password: hash,
+		Name:     "Test User",
+		Role:     role,
+		IsActive: true,
+	}
+	if err := db.Write.WithContext(context.Background()).Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
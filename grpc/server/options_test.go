@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"go-api-boilerplate/config"
+)
+
+// serveHealth serves the health service with the options ServerOptions
+// builds from cfg over an in-memory listener and dials it with opts, or
+// without transport security when none are given
+func serveHealth(t *testing.T, cfg *config.Config, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+
+	serverOpts, err := ServerOptions(cfg)
+	if err != nil {
+		t.Fatalf("ServerOptions: %v", err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(serverOpts...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	if len(opts) == 0 {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// service is the length of the service name checked, which makes
+		// up all but 3 bytes of the request
+		service  int
+		wantCode codes.Code
+	}{
+		{"request under the limit", map[string]string{"GRPC_MAX_RECV_MSG_SIZE": "1024"}, 1021, codes.NotFound},
+		{"request over the limit", map[string]string{"GRPC_MAX_RECV_MSG_SIZE": "1024"}, 1022, codes.ResourceExhausted},
+		// The response to a check of the server itself is 2 bytes
+		{"response at the limit", map[string]string{"GRPC_MAX_SEND_MSG_SIZE": "2"}, 0, codes.OK},
+		{"response over the limit", map[string]string{"GRPC_MAX_SEND_MSG_SIZE": "1"}, 0, codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := healthpb.NewHealthClient(serveHealth(t, loadTestConfig(t, tt.env)))
+
+			_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: strings.Repeat("x", tt.service)})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Check: %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestIdleConnectionsClosed(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]string{"GRPC_KEEPALIVE_MAX_CONNECTION_IDLE": "100ms"})
+	conn := serveHealth(t, cfg)
+
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	// The server sends GOAWAY once the connection has had no RPCs for
+	// GRPC_KEEPALIVE_MAX_CONNECTION_IDLE, leaving the client idle
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for state := conn.GetState(); state == connectivity.Ready; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			t.Fatal("idle connection was not closed")
+		}
+	}
+}
+
+// testCertificates are PEM files signed by a throwaway CA
+type testCertificates struct {
+	caFile, serverCert, serverKey string
+	pool                          *x509.CertPool
+	client                        tls.Certificate
+}
+
+// newTestCertificates creates a CA, a server certificate for localhost and
+// a client certificate, and writes the CA and server ones to a temporary
+// directory
+func newTestCertificates(t *testing.T) *testCertificates {
+	t.Helper()
+
+	dir := t.TempDir()
+	writePEM := func(name, kind string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		return key
+	}
+
+	caKey := newKey()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key := newKey()
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			DNSNames:     []string{"localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("failed to issue certificate: %v", err)
+		}
+		return der, key
+	}
+	keyDER := func(key *ecdsa.PrivateKey) []byte {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatalf("failed to marshal key: %v", err)
+		}
+		return der
+	}
+
+	serverDER, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	clientDER, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+
+	certs := &testCertificates{
+		caFile:     writePEM("ca.pem", "CERTIFICATE", caDER),
+		serverCert: writePEM("server.pem", "CERTIFICATE", serverDER),
+		serverKey:  writePEM("server-key.pem", "EC PRIVATE KEY", keyDER(serverKey)),
+		pool:       x509.NewCertPool(),
+		client:     tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey},
+	}
+	certs.pool.AddCert(ca)
+	return certs
+}
+
+func TestTLSConnections(t *testing.T) {
+	certs := newTestCertificates(t)
+	tlsEnv := map[string]string{"TLS_ENABLED": "true", "TLS_CERT_FILE": certs.serverCert, "TLS_KEY_FILE": certs.serverKey}
+	mtlsEnv := map[string]string{"TLS_CLIENT_CA_FILE": certs.caFile}
+	for key, value := range tlsEnv {
+		mtlsEnv[key] = value
+	}
+
+	withTLS := func(clientCerts ...tls.Certificate) grpc.DialOption {
+		return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      certs.pool,
+			ServerName:   "localhost",
+			Certificates: clientCerts,
+		}))
+	}
+	plaintext := grpc.WithTransportCredentials(insecure.NewCredentials())
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		dial   grpc.DialOption
+		wantOK bool
+	}{
+		{"plaintext by default", nil, plaintext, true},
+		{"TLS", tlsEnv, withTLS(), true},
+		{"plaintext client to a TLS server", tlsEnv, plaintext, false},
+		{"mutual TLS with a client certificate", mtlsEnv, withTLS(certs.client), true},
+		{"mutual TLS without a client certificate", mtlsEnv, withTLS(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := healthpb.NewHealthClient(serveHealth(t, loadTestConfig(t, tt.env), tt.dial))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Check: %v, want success: %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestServerOptionsTLSErrors(t *testing.T) {
+	certs := newTestCertificates(t)
+	notPEM := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		name    string
+		tls     config.TLSConfig
+		wantErr string
+	}{
+		{"missing certificate", config.TLSConfig{Enabled: true, CertFile: "missing.pem", KeyFile: certs.serverKey}, "failed to load TLS certificate"},
+		{"key of another certificate", config.TLSConfig{Enabled: true, CertFile: certs.caFile, KeyFile: certs.serverKey}, "failed to load TLS certificate"},
+		{"missing client CA", config.TLSConfig{Enabled: true, CertFile: certs.serverCert, KeyFile: certs.serverKey, ClientCAFile: "missing.pem"}, "failed to read TLS client CA"},
+		{"client CA without certificates", config.TLSConfig{Enabled: true, CertFile: certs.serverCert, KeyFile: certs.serverKey, ClientCAFile: notPEM}, "contains no certificates"},
+	}
+	for _, tt := range tests {
+		cfg := loadTestConfig(t, nil)
+		cfg.TLS = tt.tls
+		if _, err := ServerOptions(cfg); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: ServerOptions = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
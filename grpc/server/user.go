@@ -2,10 +2,14 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -14,6 +18,7 @@ import (
 	"go-api-boilerplate/grpc/proto"
 	"go-api-boilerplate/models"
 	"go-api-boilerplate/services"
+	"go-api-boilerplate/utils"
 )
 
 // UserServer implements the gRPC UserService
@@ -45,7 +50,7 @@ func (s *UserServer) GetUser(ctx context.Context, req *proto.GetUserRequest) (*p
 	}
 
 	// Get user
-	user, err := s.userService.FindByID(ctx, uint(req.Id))
+	user, result, err := s.userService.GetUser(ctx, uint(req.Id))
 	if err != nil {
 		if err == services.ErrUserNotFound {
 			return nil, status.Errorf(codes.NotFound, "user not found")
@@ -53,6 +58,10 @@ func (s *UserServer) GetUser(ctx context.Context, req *proto.GetUserRequest) (*p
 		return nil, status.Errorf(codes.Internal, "failed to retrieve user")
 	}
 
+	if result.Stale {
+		grpc.SetHeader(ctx, metadata.Pairs("x-cache", "stale"))
+	}
+
 	return modelUserToProto(user), nil
 }
 
@@ -99,28 +108,18 @@ func (s *UserServer) ListUsers(ctx context.Context, req *proto.ListUsersRequest)
 	if page < 1 {
 		page = 1
 	}
-	perPage := int(req.PerPage)
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
+	perPage, err := utils.NormalizePerPage(int(req.PerPage))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	// Build filter
-	filter := &services.UserFilter{
-		SortBy:    req.SortBy,
-		SortOrder: req.SortOrder,
-	}
+	filter := protoFilterToService(req.Filter)
+	filter.SortBy = req.SortBy
+	filter.SortOrder = req.SortOrder
 
-	if req.Filter != nil {
-		filter.Search = req.Filter.Search
-		filter.Role = req.Filter.Role
-		if req.Filter.IsActive {
-			isActive := true
-			filter.IsActive = &isActive
-		}
-		if req.Filter.EmailVerified {
-			emailVerified := true
-			filter.EmailVerified = &emailVerified
-		}
+	if err := filter.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	// Get users
@@ -174,9 +173,12 @@ func (s *UserServer) CreateUser(ctx context.Context, req *proto.CreateUserReques
 
 	user, err := s.userService.Create(ctx, input)
 	if err != nil {
-		if err == services.ErrUserAlreadyExists {
+		if errors.Is(err, services.ErrUserAlreadyExists) {
 			return nil, status.Errorf(codes.AlreadyExists, "user already exists")
 		}
+		if errors.Is(err, services.ErrInvalidRole) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		return nil, status.Errorf(codes.Internal, "failed to create user")
 	}
 
@@ -225,6 +227,9 @@ func (s *UserServer) UpdateUser(ctx context.Context, req *proto.UpdateUserReques
 		if err == services.ErrUserNotFound {
 			return nil, status.Errorf(codes.NotFound, "user not found")
 		}
+		if errors.Is(err, services.ErrInvalidRole) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		return nil, status.Errorf(codes.Internal, "failed to update user")
 	}
 
@@ -253,7 +258,7 @@ func (s *UserServer) DeleteUser(ctx context.Context, req *proto.DeleteUserReques
 	}
 
 	// Delete user
-	if err := s.userService.Delete(ctx, uint(req.Id)); err != nil {
+	if err := s.userService.Delete(ctx, currentUserID, uint(req.Id)); err != nil {
 		if err == services.ErrUserNotFound {
 			return nil, status.Errorf(codes.NotFound, "user not found")
 		}
@@ -263,7 +268,10 @@ func (s *UserServer) DeleteUser(ctx context.Context, req *proto.DeleteUserReques
 	return &emptypb.Empty{}, nil
 }
 
-// StreamUsers streams users in real-time
+// StreamUsers sends every user matching the filter and then each matching
+// user again whenever it is created or updated, until the client cancels.
+// Changes come from the user service's event bus, so they include changes
+// made on other instances when Redis is available.
 func (s *UserServer) StreamUsers(req *proto.StreamUsersRequest, stream proto.UserService_StreamUsersServer) error {
 	// Check permissions
 	currentUserRole, err := interceptors.GetUserRoleFromContext(stream.Context())
@@ -275,30 +283,24 @@ func (s *UserServer) StreamUsers(req *proto.StreamUsersRequest, stream proto.Use
 	}
 
 	// Build filter
-	filter := &services.UserFilter{}
-	if req.Filter != nil {
-		filter.Search = req.Filter.Search
-		filter.Role = req.Filter.Role
-		if req.Filter.IsActive {
-			isActive := true
-			filter.IsActive = &isActive
-		}
-		if req.Filter.EmailVerified {
-			emailVerified := true
-			filter.EmailVerified = &emailVerified
-		}
-	}
-
-	// This is a simplified implementation
-	// In a real system, you might:
-	// 1. Subscribe to database change events
-	// 2. Use a message queue for real-time updates
-	// 3. Implement server-sent events
-
-	// For now, we'll send current users and simulate updates
+	filter := protoFilterToService(req.Filter)
 	ctx := stream.Context()
 
-	// Send initial batch of users
+	// Subscribe before reading the current users so no change made in
+	// between is missed; changes arriving during the snapshot are queued
+	events := make(chan *services.UserEvent, streamUsersBuffer)
+	lagged := make(chan struct{})
+	var lagOnce sync.Once
+	unsubscribe := s.userService.SubscribeChanges(func(event *services.UserEvent) {
+		select {
+		case events <- event:
+		default:
+			lagOnce.Do(func() { close(lagged) })
+		}
+	})
+	defer unsubscribe()
+
+	// Send current users
 	users, err := s.userService.FindAll(ctx)
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to retrieve users")
@@ -315,14 +317,124 @@ func (s *UserServer) StreamUsers(req *proto.StreamUsersRequest, stream proto.Use
 		}
 	}
 
-	// Keep connection alive and send updates
-	// In production, subscribe to real events
-	<-ctx.Done()
+	// Then send users as they are created or updated. A deleted user has no
+	// state left to send, so deletions are not streamed.
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-lagged:
+			return status.Errorf(codes.ResourceExhausted, "stream fell behind user changes")
+		case event := <-events:
+			if event.Action == services.UserEventDeleted || !matchesFilter(&event.User, filter) {
+				continue
+			}
+			if err := stream.Send(modelUserToProto(&event.User)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// streamUsersBuffer is how many user changes a StreamUsers call may fall
+// behind by before it is ended
+const streamUsersBuffer = 256
+
+// Export batch sizes; a batch is one stream message
+const (
+	defaultExportBatchSize = 100
+	maxExportBatchSize     = 1000
+)
+
+// ExportUsers streams every user matching the filter in batches of
+// batch_size, reading through the same database cursor as the REST export.
+// Send blocks while the client's flow-control window is full, so a slow
+// consumer pauses the cursor instead of buffering the result set.
+func (s *UserServer) ExportUsers(req *proto.ExportUsersRequest, stream proto.UserService_ExportUsersServer) error {
+	ctx := stream.Context()
+
+	// Check permissions - only admins can export users
+	currentUserRole, err := interceptors.GetUserRoleFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if currentUserRole != models.RoleAdmin {
+		return status.Errorf(codes.PermissionDenied, "insufficient permissions")
+	}
+
+	batchSize := int(req.BatchSize)
+	switch {
+	case batchSize < 0 || batchSize > maxExportBatchSize:
+		return status.Errorf(codes.InvalidArgument, "batch_size must be between 1 and %d", maxExportBatchSize)
+	case batchSize == 0:
+		batchSize = defaultExportBatchSize
+	}
+
+	filter := protoFilterToService(req.Filter)
+	filter.SortBy = req.SortBy
+	filter.SortOrder = req.SortOrder
+	if err := filter.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	batch := make([]*proto.User, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := stream.Send(&proto.ExportUsersResponse{Users: batch}); err != nil {
+			return err
+		}
+		batch = make([]*proto.User, 0, batchSize)
+		return nil
+	}
+
+	err = s.userService.StreamUsers(ctx, filter, func(user *models.User) error {
+		batch = append(batch, modelUserToProto(user))
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if _, ok := status.FromError(err); ok {
+			// Send failures are already gRPC status errors
+			return err
+		}
+		return status.Errorf(codes.Internal, "failed to export users")
+	}
 	return nil
 }
 
 // Helper functions
 
+// protoFilterToService converts a proto user filter to a service filter.
+// Proto booleans cannot be unset, so only true narrows the results.
+func protoFilterToService(f *proto.UserFilter) *services.UserFilter {
+	filter := &services.UserFilter{}
+	if f == nil {
+		return filter
+	}
+
+	filter.Search = f.Search
+	filter.Role = f.Role
+	if f.IsActive {
+		isActive := true
+		filter.IsActive = &isActive
+	}
+	if f.EmailVerified {
+		emailVerified := true
+		filter.EmailVerified = &emailVerified
+	}
+	return filter
+}
+
 // modelUserToProto converts a model user to proto user
 func modelUserToProto(user *models.User) *proto.User {
 	protoUser := &proto.User{
@@ -358,8 +470,9 @@ func validateCreateUserRequest(req *proto.CreateUserRequest) error {
 	if req.Name == "" {
 		return fmt.Errorf("name is required")
 	}
-	if req.Role == "" {
-		req.Role = models.RoleUser
+	// An empty role gets the configured default in UserService.Create
+	if req.Role != "" && !models.IsValidRole(req.Role) {
+		return fmt.Errorf("invalid role: %s", req.Role)
 	}
 	return nil
 }
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/grpc/interceptors"
+	"go-api-boilerplate/grpc/proto"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/services"
+)
+
+// authenticatedContext runs a call as user through the auth interceptor and
+// returns the context it hands to the method
+func authenticatedContext(t *testing.T, user *models.User) context.Context {
+	t.Helper()
+
+	md := metadata.Pairs("authorization", "Bearer "+signToken(t, user, jwt.NewNumericDate(time.Now().Add(time.Hour))))
+	info := &grpc.UnaryServerInfo{FullMethod: "/boilerplate.v1.UserService/ListUsers"}
+	var authenticated context.Context
+	_, err := interceptors.AuthInterceptor(nil)(metadata.NewIncomingContext(context.Background(), md), nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+		authenticated = ctx
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("AuthInterceptor: %v", err)
+	}
+	return authenticated
+}
+
+func TestListUsersPagination(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		perPage     int32
+		wantCode    codes.Code
+		wantPerPage int32
+	}{
+		{"default", nil, 0, codes.OK, 20},
+		{"requested", nil, 2, codes.OK, 2},
+		{"at the maximum", nil, 100, codes.OK, 100},
+		{"over the maximum", nil, 101, codes.InvalidArgument, 0},
+		{"configured default", map[string]string{"PAGINATION_DEFAULT_PER_PAGE": "5"}, 0, codes.OK, 5},
+		{"over the maximum clamped", map[string]string{"PAGINATION_MAX_PER_PAGE": "50", "PAGINATION_REJECT_OVER_MAX": "false"}, 51, codes.OK, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, tt.env)
+			db := newTestDB(t)
+			admin := createTestUser(t, db, "admin@example.com", models.RoleAdmin)
+			createTestUser(t, db, "user@example.com", models.RoleUser)
+			server := NewUserServer(services.NewUserService(db, nil))
+
+			resp, err := server.ListUsers(authenticatedContext(t, admin), &proto.ListUsersRequest{PerPage: tt.perPage})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("ListUsers: %v, want %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if got := resp.Pagination.PerPage; got != tt.wantPerPage {
+				t.Errorf("per page = %d, want %d", got, tt.wantPerPage)
+			}
+			if resp.Pagination.Total != 2 {
+				t.Errorf("total = %d, want 2", resp.Pagination.Total)
+			}
+		})
+	}
+}
+
+// serveUsers serves the user service over an in-memory listener behind the
+// stream auth interceptor and returns a client for it
+func serveUsers(t *testing.T, db *database.DB) proto.UserServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.StreamInterceptor(interceptors.StreamAuthInterceptor(nil)))
+	proto.RegisterUserServiceServer(srv, NewUserServer(services.NewUserService(db, nil)))
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewUserServiceClient(conn)
+}
+
+// exportAll consumes an ExportUsers stream as user, returning the size of
+// each batch and the IDs received
+func exportAll(t *testing.T, client proto.UserServiceClient, user *models.User, req *proto.ExportUsersRequest) ([]int, []uint64, error) {
+	t.Helper()
+
+	token := signToken(t, user, jwt.NewNumericDate(time.Now().Add(time.Hour)))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	stream, err := client.ExportUsers(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var batches []int
+	var ids []uint64
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return batches, ids, nil
+		}
+		if err != nil {
+			return batches, ids, err
+		}
+		batches = append(batches, len(resp.Users))
+		for _, user := range resp.Users {
+			ids = append(ids, user.Id)
+		}
+	}
+}
+
+func TestExportUsersStream(t *testing.T) {
+	loadTestConfig(t, nil)
+	db := newTestDB(t)
+	admin := createTestUser(t, db, "admin@example.com", models.RoleAdmin)
+	moderator := createTestUser(t, db, "moderator@example.com", models.RoleModerator)
+	for i := 0; i < 23; i++ {
+		role := models.RoleUser
+		if i%4 == 0 {
+			role = models.RoleModerator
+		}
+		createTestUser(t, db, fmt.Sprintf("user%d@example.com", i), role)
+	}
+	client := serveUsers(t, db)
+
+	tests := []struct {
+		name        string
+		req         *proto.ExportUsersRequest
+		wantBatches []int
+	}{
+		{"default batch size", &proto.ExportUsersRequest{}, []int{25}},
+		{"batches of 10", &proto.ExportUsersRequest{BatchSize: 10}, []int{10, 10, 5}},
+		{"exact batches", &proto.ExportUsersRequest{BatchSize: 5}, []int{5, 5, 5, 5, 5}},
+		{"filtered by role", &proto.ExportUsersRequest{BatchSize: 4, Filter: &proto.UserFilter{Role: models.RoleModerator}}, []int{4, 3}},
+		{"no matches", &proto.ExportUsersRequest{Filter: &proto.UserFilter{Search: "nobody"}}, nil},
+	}
+	for _, tt := range tests {
+		batches, ids, err := exportAll(t, client, admin, tt.req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if fmt.Sprint(batches) != fmt.Sprint(tt.wantBatches) {
+			t.Errorf("%s: batches %v, want %v", tt.name, batches, tt.wantBatches)
+		}
+		seen := make(map[uint64]bool, len(ids))
+		for _, id := range ids {
+			if seen[id] {
+				t.Errorf("%s: user %d sent twice", tt.name, id)
+			}
+			seen[id] = true
+		}
+	}
+
+	// Sorting applies across batches
+	_, ids, err := exportAll(t, client, admin, &proto.ExportUsersRequest{BatchSize: 7, SortBy: "id", SortOrder: "desc"})
+	if err != nil || len(ids) != 25 || ids[0] < ids[24] {
+		t.Errorf("sorted export: %v, %v, want 25 users in descending ID order", ids, err)
+	}
+
+	errorCases := []struct {
+		name     string
+		user     *models.User
+		req      *proto.ExportUsersRequest
+		wantCode codes.Code
+	}{
+		{"batch size over the maximum", admin, &proto.ExportUsersRequest{BatchSize: maxExportBatchSize + 1}, codes.InvalidArgument},
+		{"negative batch size", admin, &proto.ExportUsersRequest{BatchSize: -1}, codes.InvalidArgument},
+		{"unknown sort field", admin, &proto.ExportUsersRequest{SortBy: "password"}, codes.InvalidArgument},
+		{"not an admin", moderator, &proto.ExportUsersRequest{}, codes.PermissionDenied},
+	}
+	for _, tt := range errorCases {
+		if _, _, err := exportAll(t, client, tt.user, tt.req); status.Code(err) != tt.wantCode {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.wantCode)
+		}
+	}
+}
+
+func TestUserRoleValidation(t *testing.T) {
+	loadTestConfig(t, map[string]string{"AUTH_DEFAULT_ROLE": "moderator"})
+	db := newTestDB(t)
+	admin := createTestUser(t, db, "admin@example.com", models.RoleAdmin)
+	target := createTestUser(t, db, "target@example.com", models.RoleUser)
+	server := NewUserServer(services.NewUserService(db, nil))
+	ctx := authenticatedContext(t, admin)
+
+	tests := []struct {
+		name     string
+		role     string
+		wantCode codes.Code
+		wantRole string
+	}{
+		{"default", "", codes.OK, models.RoleModerator},
+		{"user", models.RoleUser, codes.OK, models.RoleUser},
+		{"admin", models.RoleAdmin, codes.OK, models.RoleAdmin},
+		{"unknown", "superadmin", codes.InvalidArgument, ""},
+	}
+	for i, tt := range tests {
+		created, err := server.CreateUser(ctx, &proto.CreateUserRequest{
+			Email:    fmt.Sprintf("create%d@example.com", i),
+			Password: "Password123!",
+			Name:     "Created User",
+			Role:     tt.role,
+		})
+		if status.Code(err) != tt.wantCode {
+			t.Errorf("CreateUser with %s role: %v, want %v", tt.name, err, tt.wantCode)
+		} else if err == nil && created.Role != tt.wantRole {
+			t.Errorf("CreateUser with %s role: role %q, want %q", tt.name, created.Role, tt.wantRole)
+		}
+
+		if tt.role == "" {
+			continue
+		}
+		updated, err := server.UpdateUser(ctx, &proto.UpdateUserRequest{Id: uint64(target.ID), Role: tt.role})
+		if status.Code(err) != tt.wantCode {
+			t.Errorf("UpdateUser to %s role: %v, want %v", tt.name, err, tt.wantCode)
+		} else if err == nil && updated.Role != tt.wantRole {
+			t.Errorf("UpdateUser to %s role: role %q, want %q", tt.name, updated.Role, tt.wantRole)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"go-api-boilerplate/grpc/proto"
+	"go-api-boilerplate/pkg/version"
+)
+
+// VersionServer implements the gRPC VersionService
+type VersionServer struct {
+	proto.UnimplementedVersionServiceServer
+}
+
+// NewVersionServer creates a new version server
+func NewVersionServer() proto.VersionServiceServer {
+	return &VersionServer{}
+}
+
+// GetVersion returns the build information of the running binary
+func (s *VersionServer) GetVersion(ctx context.Context, _ *emptypb.Empty) (*proto.VersionInfo, error) {
+	info := version.Get()
+	return &proto.VersionInfo{
+		Version:   info.Version,
+		Commit:    info.Commit,
+		BuildTime: info.BuildTime,
+		GoVersion: info.GoVersion,
+	}, nil
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"go-api-boilerplate/grpc/interceptors"
+	"go-api-boilerplate/grpc/proto"
+)
+
+func TestGetVersion(t *testing.T) {
+	server := NewVersionServer()
+
+	// The method is public, so it is served without a token
+	info := &grpc.UnaryServerInfo{FullMethod: "/boilerplate.v1.VersionService/GetVersion"}
+	resp, err := interceptors.AuthInterceptor(nil)(context.Background(), &emptypb.Empty{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return server.GetVersion(ctx, req.(*emptypb.Empty))
+	})
+	if err != nil {
+		t.Fatalf("GetVersion without a token: %v", err)
+	}
+
+	// Test binaries are built without -ldflags
+	version := resp.(*proto.VersionInfo)
+	if version.Version != "dev" || version.Commit != "dev" || version.BuildTime != "dev" {
+		t.Errorf("GetVersion = %v, want dev build information", version)
+	}
+	if version.GoVersion != runtime.Version() {
+		t.Errorf("Go version = %q, want %q", version.GoVersion, runtime.Version())
+	}
+}
@@ -34,18 +34,39 @@ func (r *GormRepository[T]) getDB() *gorm.DB {
 	return r.db.Write
 }
 
-// getReadDB returns the read database connection
-func (r *GormRepository[T]) getReadDB() *gorm.DB {
+// getReadDB returns the read database connection, or the primary when ctx
+// is marked by database.ReadFromPrimary
+func (r *GormRepository[T]) getReadDB(ctx context.Context) *gorm.DB {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db.Reader(ctx)
+}
+
+// queryDB returns the connection new queries are built on. The context is
+// only known once a query runs, see newQuery.
+func (r *GormRepository[T]) queryDB() *gorm.DB {
 	if r.tx != nil {
 		return r.tx
 	}
 	return r.db.Read
 }
 
+// newQuery wraps a query built on queryDB. Outside a transaction the query
+// also keeps the primary, so it can still be moved there by the context it
+// runs with.
+func (r *GormRepository[T]) newQuery(db *gorm.DB) Query[T] {
+	query := &GormQuery[T]{db: db, model: r.model}
+	if r.tx == nil {
+		query.primary = r.db.Write
+	}
+	return query
+}
+
 // FindByID finds a record by its primary key
 func (r *GormRepository[T]) FindByID(ctx context.Context, id any) (*T, error) {
 	var result T
-	err := r.getReadDB().WithContext(ctx).First(&result, id).Error
+	err := r.getReadDB(ctx).WithContext(ctx).First(&result, id).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, ErrRecordNotFound
@@ -70,7 +91,7 @@ func (r *GormRepository[T]) FindOrFail(ctx context.Context, id any) (*T, error)
 // First gets the first result
 func (r *GormRepository[T]) First(ctx context.Context) (*T, error) {
 	var result T
-	err := r.getReadDB().WithContext(ctx).First(&result).Error
+	err := r.getReadDB(ctx).WithContext(ctx).First(&result).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, ErrRecordNotFound
@@ -94,8 +115,8 @@ func (r *GormRepository[T]) FirstOrFail(ctx context.Context) (*T, error) {
 
 // FindAll gets all records
 func (r *GormRepository[T]) FindAll(ctx context.Context) ([]T, error) {
-	var results []T
-	err := r.getReadDB().WithContext(ctx).Find(&results).Error
+	results := []T{}
+	err := r.getReadDB(ctx).WithContext(ctx).Find(&results).Error
 	return results, err
 }
 
@@ -116,6 +137,19 @@ func (r *GormRepository[T]) Update(ctx context.Context, id any, data *T) error {
 	return nil
 }
 
+// UpdatePartial updates only the given columns of a record; updated_at is
+// set automatically
+func (r *GormRepository[T]) UpdatePartial(ctx context.Context, id any, data map[string]any) error {
+	result := r.getDB().WithContext(ctx).Model(&r.model).Where("id = ?", id).Updates(data)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
 // Delete deletes a record by ID
 func (r *GormRepository[T]) Delete(ctx context.Context, id any) error {
 	result := r.getDB().WithContext(ctx).Delete(&r.model, id)
@@ -130,88 +164,58 @@ func (r *GormRepository[T]) Delete(ctx context.Context, id any) error {
 
 // Where creates a new query with a WHERE condition
 func (r *GormRepository[T]) Where(field string, value any) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Where(fmt.Sprintf("%s = ?", field), value),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Where(fmt.Sprintf("%s = ?", field), value))
 }
 
 // WhereIn creates a new query with a WHERE IN condition
 func (r *GormRepository[T]) WhereIn(field string, values []any) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Where(fmt.Sprintf("%s IN ?", field), values),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Where(fmt.Sprintf("%s IN ?", field), values))
 }
 
 // WhereNotIn creates a new query with a WHERE NOT IN condition
 func (r *GormRepository[T]) WhereNotIn(field string, values []any) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Where(fmt.Sprintf("%s NOT IN ?", field), values),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Where(fmt.Sprintf("%s NOT IN ?", field), values))
 }
 
 // WhereBetween creates a new query with a WHERE BETWEEN condition
 func (r *GormRepository[T]) WhereBetween(field string, start, end any) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Where(fmt.Sprintf("%s BETWEEN ? AND ?", field), start, end),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Where(fmt.Sprintf("%s BETWEEN ? AND ?", field), start, end))
 }
 
 // WhereNull creates a new query with a WHERE NULL condition
 func (r *GormRepository[T]) WhereNull(field string) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Where(fmt.Sprintf("%s IS NULL", field)),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Where(fmt.Sprintf("%s IS NULL", field)))
 }
 
 // WhereNotNull creates a new query with a WHERE NOT NULL condition
 func (r *GormRepository[T]) WhereNotNull(field string) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Where(fmt.Sprintf("%s IS NOT NULL", field)),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Where(fmt.Sprintf("%s IS NOT NULL", field)))
 }
 
 // With eager loads related data
 func (r *GormRepository[T]) With(relation string) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Preload(relation),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Preload(relation))
 }
 
 // OrderBy adds ordering to the query
 func (r *GormRepository[T]) OrderBy(field string, direction string) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Order(fmt.Sprintf("%s %s", field, direction)),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Order(fmt.Sprintf("%s %s", field, direction)))
 }
 
 // Limit adds a limit to the query
 func (r *GormRepository[T]) Limit(limit int) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Limit(limit),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Limit(limit))
 }
 
 // Offset adds an offset to the query
 func (r *GormRepository[T]) Offset(offset int) Query[T] {
-	return &GormQuery[T]{
-		db:    r.getReadDB().Offset(offset),
-		model: r.model,
-	}
+	return r.newQuery(r.queryDB().Offset(offset))
 }
 
 // Exists checks if any records match
 func (r *GormRepository[T]) Exists(ctx context.Context) (bool, error) {
 	var count int64
-	err := r.getReadDB().WithContext(ctx).Model(&r.model).Count(&count).Error
+	err := r.getReadDB(ctx).WithContext(ctx).Model(&r.model).Count(&count).Error
 	return count > 0, err
 }
 
@@ -224,28 +228,28 @@ func (r *GormRepository[T]) DoesNotExist(ctx context.Context) (bool, error) {
 // Count counts the number of records
 func (r *GormRepository[T]) Count(ctx context.Context) (int64, error) {
 	var count int64
-	err := r.getReadDB().WithContext(ctx).Model(&r.model).Count(&count).Error
+	err := r.getReadDB(ctx).WithContext(ctx).Model(&r.model).Count(&count).Error
 	return count, err
 }
 
 // Pluck extracts values from a single column
 func (r *GormRepository[T]) Pluck(ctx context.Context, field string) ([]any, error) {
-	var results []any
-	err := r.getReadDB().WithContext(ctx).Model(&r.model).Pluck(field, &results).Error
+	results := []any{}
+	err := r.getReadDB(ctx).WithContext(ctx).Model(&r.model).Pluck(field, &results).Error
 	return results, err
 }
 
 // PluckString extracts string values from a single column
 func (r *GormRepository[T]) PluckString(ctx context.Context, field string) ([]string, error) {
-	var results []string
-	err := r.getReadDB().WithContext(ctx).Model(&r.model).Pluck(field, &results).Error
+	results := []string{}
+	err := r.getReadDB(ctx).WithContext(ctx).Model(&r.model).Pluck(field, &results).Error
 	return results, err
 }
 
 // PluckInt extracts int values from a single column
 func (r *GormRepository[T]) PluckInt(ctx context.Context, field string) ([]int, error) {
-	var results []int
-	err := r.getReadDB().WithContext(ctx).Model(&r.model).Pluck(field, &results).Error
+	results := []int{}
+	err := r.getReadDB(ctx).WithContext(ctx).Model(&r.model).Pluck(field, &results).Error
 	return results, err
 }
 
@@ -287,6 +291,44 @@ func (r *GormRepository[T]) Decrement(ctx context.Context, id any, field string,
 		UpdateColumn(field, gorm.Expr(fmt.Sprintf("%s - ?", field), value)).Error
 }
 
+// Restore clears the deleted_at of a soft-deleted record, returning
+// ErrRecordNotFound when no deleted record has the ID
+func (r *GormRepository[T]) Restore(ctx context.Context, id any) error {
+	result := r.getDB().WithContext(ctx).Unscoped().Model(&r.model).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ForceDelete permanently deletes a record by ID, whether or not it is
+// soft-deleted
+func (r *GormRepository[T]) ForceDelete(ctx context.Context, id any) error {
+	result := r.getDB().WithContext(ctx).Unscoped().Delete(&r.model, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// WithTrashed creates a new query that includes soft-deleted records
+func (r *GormRepository[T]) WithTrashed() Query[T] {
+	return r.newQuery(r.queryDB().Unscoped())
+}
+
+// OnlyTrashed creates a new query limited to soft-deleted records
+func (r *GormRepository[T]) OnlyTrashed() Query[T] {
+	return r.newQuery(r.queryDB().Unscoped().Where("deleted_at IS NOT NULL"))
+}
+
 // WithTransaction creates a new repository instance with a transaction
 func (r *GormRepository[T]) WithTransaction(tx any) Repository[T] {
 	gormTx, ok := tx.(*gorm.DB)
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"time"
+
+	"go-api-boilerplate/database"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,19 +14,71 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// MongoRepository is a MongoDB implementation of Repository
+// mongoCounters is the collection holding the last ID handed out for each
+// collection whose model keys on an unsigned integer
+const mongoCounters = "counters"
+
+// MongoRepository is a MongoDB implementation of Repository. Models keyed on
+// an unsigned integer ID, like the GORM models, get sequential IDs from the
+// counters collection, and models with a DeletedAt field are soft deleted:
+// deleted documents keep a deleted_at date and are left out of queries.
 type MongoRepository[T any] struct {
-	collection *mongo.Collection
-	model      T
-	session    mongo.SessionContext
+	collection  *mongo.Collection
+	model       T
+	session     mongo.SessionContext
+	softDeletes bool
 }
 
 // NewMongoRepository creates a new MongoDB repository
 func NewMongoRepository[T any](collection *mongo.Collection, model T) Repository[T] {
 	return &MongoRepository[T]{
-		collection: collection,
-		model:      model,
+		collection:  collection,
+		model:       model,
+		softDeletes: hasField(model, "DeletedAt"),
+	}
+}
+
+// hasField reports whether model is a struct with the named field
+func hasField(model any, name string) bool {
+	t := reflect.TypeOf(model)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := t.FieldByName(name)
+	return ok
+}
+
+// scope returns the filter queries start from, which leaves out
+// soft-deleted documents
+func (r *MongoRepository[T]) scope() bson.M {
+	if r.softDeletes {
+		return bson.M{"deleted_at": nil}
+	}
+	return bson.M{}
+}
+
+// query starts a query with the given filter
+func (r *MongoRepository[T]) query(filter bson.M) *MongoQuery[T] {
+	return &MongoQuery[T]{
+		collection:  r.collection,
+		filter:      filter,
+		model:       r.model,
+		softDeletes: r.softDeletes,
+	}
+}
+
+// byID returns the filter matching the live document with the given ID
+func (r *MongoRepository[T]) byID(id any) (bson.M, error) {
+	value, err := r.toID(id)
+	if err != nil {
+		return nil, ErrInvalidID
 	}
+	filter := r.scope()
+	filter["_id"] = value
+	return filter, nil
 }
 
 func (r *MongoRepository[T]) CreateBatch(ctx context.Context, data []T) error {
@@ -31,6 +86,14 @@ func (r *MongoRepository[T]) CreateBatch(ctx context.Context, data []T) error {
 		return nil
 	}
 
+	docs := make([]*T, len(data))
+	for i := range data {
+		docs[i] = &data[i]
+	}
+	if err := r.prepareInsert(ctx, docs); err != nil {
+		return err
+	}
+
 	// Convert []T to []interface{}
 	documents := make([]interface{}, len(data))
 	for i, v := range data {
@@ -42,50 +105,150 @@ func (r *MongoRepository[T]) CreateBatch(ctx context.Context, data []T) error {
 }
 
 func (r *MongoRepository[T]) Create(ctx context.Context, data *T) error {
+	if err := r.prepareInsert(ctx, []*T{data}); err != nil {
+		return err
+	}
 	_, err := r.collection.InsertOne(ctx, data)
 	return err
 }
 
-// Update replaces the document with the given ID
+// prepareInsert fills in what GORM sets on insert: zero CreatedAt and
+// UpdatedAt fields become now and zero unsigned integer IDs are assigned
+// from the counters collection, as MongoDB only generates ObjectIDs
+func (r *MongoRepository[T]) prepareInsert(ctx context.Context, docs []*T) error {
+	// MongoDB stores milliseconds; truncate so the caller holds what is read back
+	now := time.Now().Truncate(time.Millisecond)
+
+	var ids []reflect.Value
+	for _, doc := range docs {
+		v := reflect.ValueOf(doc).Elem()
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		for _, name := range []string{"CreatedAt", "UpdatedAt"} {
+			if field := v.FieldByName(name); field.IsValid() && field.Type() == reflect.TypeOf(now) && field.IsZero() {
+				field.Set(reflect.ValueOf(now))
+			}
+		}
+		if id := v.FieldByName("ID"); id.IsValid() && id.CanUint() && id.Uint() == 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	last, err := r.reserveIDs(ctx, len(ids))
+	if err != nil {
+		return fmt.Errorf("failed to assign IDs: %w", err)
+	}
+	for i, id := range ids {
+		id.SetUint(last - uint64(len(ids)-1-i))
+	}
+	return nil
+}
+
+// reserveIDs advances the collection's counter by n and returns the last of
+// the n IDs reserved
+func (r *MongoRepository[T]) reserveIDs(ctx context.Context, n int) (uint64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := r.collection.Database().Collection(mongoCounters).FindOneAndUpdate(ctx,
+		bson.M{"_id": r.collection.Name()},
+		bson.M{"$inc": bson.M{"seq": n}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(counter.Seq), nil
+}
+
+// Update overwrites the fields of the document with the given ID with those
+// of data. The _id and created_at of the stored document are kept, fields
+// data does not encode are left alone and updated_at is bumped.
 func (r *MongoRepository[T]) Update(ctx context.Context, id any, data *T) error {
-	objectID, err := r.toObjectID(id)
+	raw, err := bson.MarshalWithRegistry(database.MongoRegistry(), data)
 	if err != nil {
-		return ErrInvalidID
+		return err
 	}
-	_, err = r.collection.ReplaceOne(ctx, bson.M{"_id": objectID}, data)
-	return err
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	delete(fields, "created_at")
+
+	return r.UpdatePartial(ctx, id, fields)
 }
 
-// Delete removes the document with the given ID
+// UpdatePartial sets only the given fields of the document with the given
+// ID, with updated_at bumped, like GORM's Updates with a map
+func (r *MongoRepository[T]) UpdatePartial(ctx context.Context, id any, data map[string]any) error {
+	filter, err := r.byID(id)
+	if err != nil {
+		return err
+	}
+
+	fields := make(bson.M, len(data)+1)
+	for field, value := range data {
+		fields[field] = value
+	}
+	delete(fields, "_id")
+	fields["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete removes the document with the given ID, or sets its deleted_at for
+// models that soft delete, returning ErrRecordNotFound when none matches
 func (r *MongoRepository[T]) Delete(ctx context.Context, id any) error {
-	objectID, err := r.toObjectID(id)
+	filter, err := r.byID(id)
 	if err != nil {
-		return ErrInvalidID
+		return err
 	}
-	_, err = r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
-	return err
+
+	var matched int64
+	if r.softDeletes {
+		result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"deleted_at": primitive.NewDateTimeFromTime(time.Now())}})
+		if err != nil {
+			return err
+		}
+		matched = result.MatchedCount
+	} else {
+		result, err := r.collection.DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+		matched = result.DeletedCount
+	}
+	if matched == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
 }
 
 func (r *MongoRepository[T]) Where(field string, value any) Query[T] {
-	filter := bson.M{field: value}
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     filter,
-		model:      r.model,
-	}
+	return r.query(r.scope()).Where(field, value)
 }
 
 // FindByID finds a record by its primary key
 func (r *MongoRepository[T]) FindByID(ctx context.Context, id any) (*T, error) {
 	var result T
 
-	// Convert string ID to ObjectID if needed
-	objectID, err := r.toObjectID(id)
+	filter, err := r.byID(id)
 	if err != nil {
-		return nil, ErrInvalidID
+		return nil, err
 	}
 
-	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&result)
+	err = r.collection.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrRecordNotFound
@@ -112,7 +275,7 @@ func (r *MongoRepository[T]) FindOrFail(ctx context.Context, id any) (*T, error)
 func (r *MongoRepository[T]) First(ctx context.Context) (*T, error) {
 	var result T
 	opts := options.FindOne().SetSort(bson.D{{Key: "_id", Value: 1}})
-	err := r.collection.FindOne(ctx, bson.D{}, opts).Decode(&result)
+	err := r.collection.FindOne(ctx, r.scope(), opts).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrRecordNotFound
@@ -133,34 +296,41 @@ func (r *MongoRepository[T]) FirstOrFail(ctx context.Context) (*T, error) {
 
 // All returns all records from the collection
 func (r *MongoRepository[T]) All(ctx context.Context) ([]T, error) {
-	cursor, err := r.collection.Find(ctx, bson.D{})
+	cursor, err := r.collection.Find(ctx, r.scope())
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	var results []T
+	results := []T{}
 	if err := cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
 	return results, nil
 }
 
-// toObjectID tries to convert the given ID to a MongoDB ObjectID
-func (r *MongoRepository[T]) toObjectID(id any) (primitive.ObjectID, error) {
+// toID converts the given ID to the value stored in _id: strings are
+// ObjectID hex strings, while integer IDs are stored as they are
+func (r *MongoRepository[T]) toID(id any) (any, error) {
 	switch v := id.(type) {
 	case string:
 		return primitive.ObjectIDFromHex(v)
 	case primitive.ObjectID:
 		return v, nil
+	}
+
+	switch reflect.ValueOf(id).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return id, nil
 	default:
-		return primitive.NilObjectID, fmt.Errorf("invalid ID type: %v", reflect.TypeOf(id))
+		return nil, fmt.Errorf("invalid ID type: %v", reflect.TypeOf(id))
 	}
 }
 
-// Count returns the total number of documents in the collection
+// Count returns the number of documents in the collection
 func (r *MongoRepository[T]) Count(ctx context.Context) (int64, error) {
-	count, err := r.collection.CountDocuments(ctx, bson.D{})
+	count, err := r.collection.CountDocuments(ctx, r.scope())
 	if err != nil {
 		return 0, err
 	}
@@ -181,25 +351,7 @@ func (r *MongoRepository[T]) DoesNotExist(ctx context.Context) (bool, error) {
 }
 
 func (r *MongoRepository[T]) Pluck(ctx context.Context, field string) ([]any, error) {
-	opts := options.Find().SetProjection(bson.M{field: 1, "_id": 0})
-	cursor, err := r.collection.Find(ctx, bson.D{}, opts)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
-
-	var results []bson.M
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, err
-	}
-
-	var plucked []any
-	for _, doc := range results {
-		if val, ok := doc[field]; ok {
-			plucked = append(plucked, val)
-		}
-	}
-	return plucked, nil
+	return r.query(r.scope()).Pluck(ctx, field)
 }
 
 func (r *MongoRepository[T]) PluckString(ctx context.Context, field string) ([]string, error) {
@@ -207,7 +359,7 @@ func (r *MongoRepository[T]) PluckString(ctx context.Context, field string) ([]s
 	if err != nil {
 		return nil, err
 	}
-	var result []string
+	result := []string{}
 	for _, v := range values {
 		if str, ok := v.(string); ok {
 			result = append(result, str)
@@ -221,7 +373,7 @@ func (r *MongoRepository[T]) PluckInt(ctx context.Context, field string) ([]int,
 	if err != nil {
 		return nil, err
 	}
-	var result []int
+	result := []int{}
 	for _, v := range values {
 		if i, ok := v.(int); ok {
 			result = append(result, i)
@@ -237,11 +389,11 @@ func (r *MongoRepository[T]) UpdateBatch(ctx context.Context, ids []any, data []
 	}
 
 	for i, id := range ids {
-		objectID, err := r.toObjectID(id)
+		filter, err := r.byID(id)
 		if err != nil {
-			return ErrInvalidID
+			return err
 		}
-		_, err = r.collection.ReplaceOne(ctx, bson.M{"_id": objectID}, data[i])
+		_, err = r.collection.ReplaceOne(ctx, filter, data[i])
 		if err != nil {
 			return err
 		}
@@ -249,28 +401,28 @@ func (r *MongoRepository[T]) UpdateBatch(ctx context.Context, ids []any, data []
 	return nil
 }
 
-// DeleteBatch deletes multiple documents by their IDs
+// DeleteBatch deletes multiple documents by their IDs, soft deleting them
+// for models that soft delete
 func (r *MongoRepository[T]) DeleteBatch(ctx context.Context, ids []any) error {
-	var objectIDs []primitive.ObjectID
+	values := make([]any, 0, len(ids))
 	for _, id := range ids {
-		objectID, err := r.toObjectID(id)
+		value, err := r.toID(id)
 		if err != nil {
 			return ErrInvalidID
 		}
-		objectIDs = append(objectIDs, objectID)
+		values = append(values, value)
 	}
 
-	_, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs}})
-	return err
+	return r.query(r.scope()).WhereIn("_id", values).Delete(ctx)
 }
 
 // Increment performs an atomic increment on a numeric field
 func (r *MongoRepository[T]) Increment(ctx context.Context, id any, field string, value int) error {
-	objectID, err := r.toObjectID(id)
+	filter, err := r.byID(id)
 	if err != nil {
-		return ErrInvalidID
+		return err
 	}
-	_, err = r.collection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{
+	_, err = r.collection.UpdateOne(ctx, filter, bson.M{
 		"$inc": bson.M{field: value},
 	})
 	return err
@@ -281,117 +433,90 @@ func (r *MongoRepository[T]) Decrement(ctx context.Context, id any, field string
 	return r.Increment(ctx, id, field, -value)
 }
 
+// Restore unsets the deleted_at of a soft-deleted document
+func (r *MongoRepository[T]) Restore(ctx context.Context, id any) error {
+	value, err := r.toID(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": value, "deleted_at": bson.M{"$ne": nil}},
+		bson.M{"$set": bson.M{"deleted_at": nil}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ForceDelete permanently removes the document with the given ID
+func (r *MongoRepository[T]) ForceDelete(ctx context.Context, id any) error {
+	value, err := r.toID(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": value})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// WithTrashed creates a query over every document, soft-deleted or not
+func (r *MongoRepository[T]) WithTrashed() Query[T] {
+	return r.query(bson.M{})
+}
+
+// OnlyTrashed creates a query limited to soft-deleted documents
+func (r *MongoRepository[T]) OnlyTrashed() Query[T] {
+	return r.query(bson.M{"deleted_at": bson.M{"$ne": nil}})
+}
+
 func (r *MongoRepository[T]) FindAll(ctx context.Context) ([]T, error) {
 	return r.All(ctx)
 }
 
 func (r *MongoRepository[T]) Limit(limit int) Query[T] {
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     bson.M{},
-		model:      r.model,
-		limit:      int64(limit),
-	}
+	return r.query(r.scope()).Limit(limit)
 }
 
 func (r *MongoRepository[T]) Offset(offset int) Query[T] {
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     bson.M{},
-		model:      r.model,
-		skip:       int64(offset),
-	}
+	return r.query(r.scope()).Offset(offset)
 }
 
 func (r *MongoRepository[T]) OrderBy(field string, direction string) Query[T] {
-	order := 1
-	if direction == "desc" {
-		order = -1
-	}
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     bson.M{},
-		sort:       bson.D{{Key: field, Value: order}},
-		model:      r.model,
-	}
+	return r.query(r.scope()).OrderBy(field, direction)
 }
 
 func (r *MongoRepository[T]) WhereBetween(field string, start, end any) Query[T] {
-	filter := bson.M{
-		field: bson.M{
-			"$gte": start,
-			"$lte": end,
-		},
-	}
-
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     filter,
-		model:      r.model,
-	}
+	return r.query(r.scope()).WhereBetween(field, start, end)
 }
 
 func (r *MongoRepository[T]) WhereIn(field string, values []any) Query[T] {
-	filter := bson.M{
-		field: bson.M{
-			"$in": values,
-		},
-	}
-
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     filter,
-		model:      r.model,
-	}
+	return r.query(r.scope()).WhereIn(field, values)
 }
 
 func (r *MongoRepository[T]) WhereNotIn(field string, values []any) Query[T] {
-	filter := bson.M{
-		field: bson.M{
-			"$nin": values,
-		},
-	}
-
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     filter,
-		model:      r.model,
-	}
+	return r.query(r.scope()).WhereNotIn(field, values)
 }
 
 func (r *MongoRepository[T]) WhereNotNull(field string) Query[T] {
-	filter := bson.M{
-		field: bson.M{
-			"$ne": nil,
-		},
-	}
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     filter,
-		model:      r.model,
-	}
+	return r.query(r.scope()).WhereNotNull(field)
 }
 
 func (r *MongoRepository[T]) WhereNull(field string) Query[T] {
-	filter := bson.M{
-		field: bson.M{
-			"$eq": nil,
-		},
-	}
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     filter,
-		model:      r.model,
-	}
+	return r.query(r.scope()).WhereNull(field)
 }
 
 func (r *MongoRepository[T]) With(relations string) Query[T] {
-	// Log or ignore relations since MongoDB doesn't use them
-	return &MongoQuery[T]{
-		collection: r.collection,
-		filter:     bson.M{},
-		model:      r.model,
-	}
+	// MongoDB doesn't use relations
+	return r.query(r.scope())
 }
 
 func (r *MongoRepository[T]) WithTransaction(tx any) Repository[T] {
@@ -401,8 +526,9 @@ func (r *MongoRepository[T]) WithTransaction(tx any) Repository[T] {
 	}
 
 	return &MongoRepository[T]{
-		collection: r.collection,
-		model:      r.model,
-		session:    sessionCtx,
+		collection:  r.collection,
+		model:       r.model,
+		session:     sessionCtx,
+		softDeletes: r.softDeletes,
 	}
 }
@@ -14,7 +14,9 @@ var (
 	ErrDuplicateRecord = errors.New("duplicate record")
 )
 
-// Repository defines the standard repository interface
+// Repository defines the standard repository interface. Methods returning a
+// list return an empty, non-nil slice when nothing matches, on every backend,
+// so they encode to [] rather than null.
 type Repository[T any] interface {
 	// Basic CRUD operations
 	FindByID(ctx context.Context, id any) (*T, error)
@@ -24,6 +26,7 @@ type Repository[T any] interface {
 	FindAll(ctx context.Context) ([]T, error)
 	Create(ctx context.Context, data *T) error
 	Update(ctx context.Context, id any, data *T) error
+	UpdatePartial(ctx context.Context, id any, data map[string]any) error
 	Delete(ctx context.Context, id any) error
 
 	// Query building
@@ -55,6 +58,12 @@ type Repository[T any] interface {
 	Increment(ctx context.Context, id any, field string, value int) error
 	Decrement(ctx context.Context, id any, field string, value int) error
 
+	// Soft deletes, for models with a deleted_at field
+	Restore(ctx context.Context, id any) error
+	ForceDelete(ctx context.Context, id any) error
+	WithTrashed() Query[T]
+	OnlyTrashed() Query[T]
+
 	// Transaction support
 	WithTransaction(tx any) Repository[T]
 }
@@ -100,6 +109,13 @@ type Query[T any] interface {
 	Delete(ctx context.Context) error
 	Update(ctx context.Context, data map[string]any) error
 
+	// Aggregates over a numeric field of the matching records; 0 when
+	// nothing matches
+	Sum(ctx context.Context, field string) (float64, error)
+	Avg(ctx context.Context, field string) (float64, error)
+	Min(ctx context.Context, field string) (float64, error)
+	Max(ctx context.Context, field string) (float64, error)
+
 	// Pagination
 	Paginate(page, perPage int) PaginatedResult[T]
 }
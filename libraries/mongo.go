@@ -202,7 +202,7 @@ func (r *MongoRepository[T]) Aggregate(ctx context.Context, pipeline []bson.M) (
 	}
 	defer cursor.Close(ctx)
 
-	var results []bson.M
+	results := []bson.M{}
 	if err := cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
@@ -242,7 +242,7 @@ func (r *MongoRepository[T]) TextSearch(ctx context.Context, searchText string)
 	}
 	defer cursor.Close(ctx)
 
-	var results []T
+	results := []T{}
 	if err := cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
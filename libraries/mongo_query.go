@@ -3,6 +3,7 @@ package libraries
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,20 +12,33 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// MongoQuery implements the Query interface for MongoDB
+// MongoQuery implements the Query interface for MongoDB. The field "id"
+// refers to _id, so queries on the ID read the same on every backend.
 type MongoQuery[T any] struct {
-	collection *mongo.Collection
-	filter     bson.M
-	sort       bson.D
-	limit      int64
-	skip       int64
-	projection bson.M
-	model      T
+	collection  *mongo.Collection
+	filter      bson.M
+	sort        bson.D
+	limit       int64
+	skip        int64
+	projection  bson.M
+	model       T
+	softDeletes bool
+}
+
+// mongoField maps a field name to the document key it is stored under
+func mongoField(field string) string {
+	if field == "id" {
+		return "_id"
+	}
+	return field
 }
 
 // Where adds a WHERE condition
 func (q *MongoQuery[T]) Where(field string, value any) Query[T] {
-	q.filter[field] = value
+	if q.filter == nil {
+		q.filter = bson.M{}
+	}
+	q.filter[mongoField(field)] = value
 	return q
 }
 
@@ -32,7 +46,7 @@ func (q *MongoQuery[T]) WhereIn(field string, values []any) Query[T] {
 	if q.filter == nil {
 		q.filter = bson.M{}
 	}
-	q.filter[field] = bson.M{"$in": values}
+	q.filter[mongoField(field)] = bson.M{"$in": values}
 	return q
 }
 
@@ -40,7 +54,7 @@ func (q *MongoQuery[T]) WhereNotIn(field string, values []any) Query[T] {
 	if q.filter == nil {
 		q.filter = bson.M{}
 	}
-	q.filter[field] = bson.M{"$nin": values}
+	q.filter[mongoField(field)] = bson.M{"$nin": values}
 	return q
 }
 
@@ -49,7 +63,7 @@ func (q *MongoQuery[T]) WhereBetween(field string, start, end any) Query[T] {
 	if q.filter == nil {
 		q.filter = bson.M{}
 	}
-	q.filter[field] = bson.M{
+	q.filter[mongoField(field)] = bson.M{
 		"$gte": start,
 		"$lte": end,
 	}
@@ -60,7 +74,7 @@ func (q *MongoQuery[T]) WhereNull(field string) Query[T] {
 	if q.filter == nil {
 		q.filter = bson.M{}
 	}
-	q.filter[field] = bson.M{"$eq": nil}
+	q.filter[mongoField(field)] = bson.M{"$eq": nil}
 	return q
 }
 
@@ -68,7 +82,7 @@ func (q *MongoQuery[T]) WhereNotNull(field string) Query[T] {
 	if q.filter == nil {
 		q.filter = bson.M{}
 	}
-	q.filter[field] = bson.M{"$ne": nil}
+	q.filter[mongoField(field)] = bson.M{"$ne": nil}
 	return q
 }
 
@@ -85,7 +99,7 @@ func (q *MongoQuery[T]) OrWhere(field string, value any) Query[T] {
 
 	// Add OR condition
 	orConditions, _ := q.filter["$or"].([]bson.M)
-	orConditions = append(orConditions, bson.M{field: value})
+	orConditions = append(orConditions, bson.M{mongoField(field): value})
 	q.filter["$or"] = orConditions
 
 	return q
@@ -110,19 +124,19 @@ func (q *MongoQuery[T]) OrderBy(field string, direction string) Query[T] {
 	if direction == "DESC" || direction == "desc" {
 		order = -1
 	}
-	q.sort = append(q.sort, bson.E{Key: field, Value: order})
+	q.sort = append(q.sort, bson.E{Key: mongoField(field), Value: order})
 	return q
 }
 
 // OrderByDesc adds descending order
 func (q *MongoQuery[T]) OrderByDesc(field string) Query[T] {
-	q.sort = append(q.sort, bson.E{Key: field, Value: -1})
+	q.sort = append(q.sort, bson.E{Key: mongoField(field), Value: -1})
 	return q
 }
 
 // OrderByAsc adds ascending order
 func (q *MongoQuery[T]) OrderByAsc(field string) Query[T] {
-	q.sort = append(q.sort, bson.E{Key: field, Value: 1})
+	q.sort = append(q.sort, bson.E{Key: mongoField(field), Value: 1})
 	return q
 }
 
@@ -156,7 +170,7 @@ func (q *MongoQuery[T]) Select(fields ...string) Query[T] {
 		q.projection = bson.M{}
 	}
 	for _, field := range fields {
-		q.projection[field] = 1
+		q.projection[mongoField(field)] = 1
 	}
 	return q
 }
@@ -190,7 +204,7 @@ func (q *MongoQuery[T]) Find(ctx context.Context) ([]T, error) {
 	}
 	defer cursor.Close(ctx)
 
-	var results []T
+	results := []T{}
 	if err := cursor.All(ctx, &results); err != nil {
 		return nil, err
 	}
@@ -253,10 +267,80 @@ func (q *MongoQuery[T]) Count(ctx context.Context) (int64, error) {
 	return q.collection.CountDocuments(ctx, q.filter)
 }
 
+// Sum returns the sum of a numeric field over the matching documents
+func (q *MongoQuery[T]) Sum(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "$sum", field)
+}
+
+// Avg returns the average of a numeric field over the matching documents
+func (q *MongoQuery[T]) Avg(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "$avg", field)
+}
+
+// Min returns the smallest value of a numeric field over the matching documents
+func (q *MongoQuery[T]) Min(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "$min", field)
+}
+
+// Max returns the largest value of a numeric field over the matching documents
+func (q *MongoQuery[T]) Max(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "$max", field)
+}
+
+// aggregate groups the matching documents into one and applies an
+// accumulator to a field
+func (q *MongoQuery[T]) aggregate(ctx context.Context, accumulator, field string) (float64, error) {
+	pipeline := []bson.M{
+		{"$match": q.filter},
+		{"$group": bson.M{"_id": nil, "value": bson.M{accumulator: "$" + field}}},
+	}
+
+	cursor, err := q.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		return 0, cursor.Err()
+	}
+
+	var result struct {
+		Value any `bson:"value"`
+	}
+	if err := cursor.Decode(&result); err != nil {
+		return 0, err
+	}
+	return toFloat64(result.Value)
+}
+
+// toFloat64 converts the numeric BSON types an accumulator can return
+func toFloat64(value any) (float64, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case primitive.Decimal128:
+		return strconv.ParseFloat(v.String(), 64)
+	default:
+		return 0, fmt.Errorf("aggregate result is not numeric: %T", value)
+	}
+}
+
 // Pluck extracts values from a column
 func (q *MongoQuery[T]) Pluck(ctx context.Context, field string) ([]any, error) {
 	// Set projection to only include the requested field
-	opts := options.Find().SetProjection(bson.M{field: 1, "_id": 0})
+	field = mongoField(field)
+	projection := bson.M{field: 1}
+	if field != "_id" {
+		projection["_id"] = 0
+	}
+	opts := options.Find().SetProjection(projection)
 
 	if len(q.sort) > 0 {
 		opts.SetSort(q.sort)
@@ -274,7 +358,7 @@ func (q *MongoQuery[T]) Pluck(ctx context.Context, field string) ([]any, error)
 	}
 	defer cursor.Close(ctx)
 
-	var results []any
+	results := []any{}
 	for cursor.Next(ctx) {
 		var doc bson.M
 		if err := cursor.Decode(&doc); err != nil {
@@ -288,8 +372,15 @@ func (q *MongoQuery[T]) Pluck(ctx context.Context, field string) ([]any, error)
 	return results, cursor.Err()
 }
 
-// Delete deletes matching records
+// Delete deletes matching records, setting their deleted_at instead for
+// models that soft delete
 func (q *MongoQuery[T]) Delete(ctx context.Context) error {
+	if q.softDeletes {
+		_, err := q.collection.UpdateMany(ctx, q.filter, bson.M{
+			"$set": bson.M{"deleted_at": primitive.NewDateTimeFromTime(time.Now())},
+		})
+		return err
+	}
 	_, err := q.collection.DeleteMany(ctx, q.filter)
 	return err
 }
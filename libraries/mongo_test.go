@@ -0,0 +1,184 @@
+package libraries
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// mongoTestItem is the model the Mongo repository tests store
+type mongoTestItem struct {
+	ID        uint      `bson:"_id"`
+	A         int       `bson:"a"`
+	Name      string    `bson:"name"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// runMongoMock runs fn against a repository over a mocked deployment, which
+// answers each command with the next response queued by AddMockResponses
+func runMongoMock(t *testing.T, fn func(mt *mtest.T, repo Repository[mongoTestItem])) {
+	t.Helper()
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("mock", func(mt *mtest.T) {
+		fn(mt, NewMongoRepository(mt.Coll, mongoTestItem{}))
+	})
+}
+
+// cursorResponse is the reply to a find or aggregate returning docs
+func cursorResponse(mt *mtest.T, docs ...bson.D) bson.D {
+	ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+	return mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, docs...)
+}
+
+func TestMongoEmptyResultsAreEmptyArrays(t *testing.T) {
+	runMongoMock(t, func(mt *mtest.T, repo Repository[mongoTestItem]) {
+		ctx := context.Background()
+
+		tests := []struct {
+			name string
+			run  func() (any, error)
+		}{
+			{"FindAll", func() (any, error) { return repo.FindAll(ctx) }},
+			{"Find", func() (any, error) { return repo.Where("a", 1).Find(ctx) }},
+			{"Pluck", func() (any, error) { return repo.Where("a", 1).Pluck(ctx, "name") }},
+			{"PluckString", func() (any, error) { return repo.PluckString(ctx, "name") }},
+			{"Paginate", func() (any, error) {
+				_, items, err := repo.Where("a", 1).Paginate(1, 10).Execute(ctx)
+				return items, err
+			}},
+		}
+		for _, tt := range tests {
+			mt.AddMockResponses(cursorResponse(mt), cursorResponse(mt))
+
+			got, err := tt.run()
+			if err != nil {
+				mt.Fatalf("%s: %v", tt.name, err)
+			}
+			mt.ClearMockResponses()
+			if body, _ := json.Marshal(got); string(body) != "[]" {
+				mt.Errorf("%s with no documents marshals as %s, want []", tt.name, body)
+			}
+		}
+	})
+}
+
+// lastUpdate returns the $set document of the last update command sent
+func lastUpdate(mt *mtest.T) bson.M {
+	mt.Helper()
+
+	event := mt.GetStartedEvent()
+	for next := event; next != nil; next = mt.GetStartedEvent() {
+		event = next
+	}
+	if event == nil || event.CommandName != "update" {
+		mt.Fatalf("no update command was sent")
+	}
+
+	var set bson.M
+	if err := event.Command.Lookup("updates", "0", "u", "$set").Unmarshal(&set); err != nil {
+		mt.Fatalf("update without $set: %v", err)
+	}
+	return set
+}
+
+func TestMongoUpdateOnlySetsGivenFields(t *testing.T) {
+	runMongoMock(t, func(mt *mtest.T, repo Repository[mongoTestItem]) {
+		ctx := context.Background()
+		matched := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+
+		mt.AddMockResponses(matched)
+		if err := repo.UpdatePartial(ctx, 7, map[string]any{"name": "renamed", "_id": 9}); err != nil {
+			mt.Fatalf("UpdatePartial: %v", err)
+		}
+		set := lastUpdate(mt)
+		if len(set) != 2 || set["name"] != "renamed" {
+			mt.Errorf("UpdatePartial set %v, want only name and updated_at", set)
+		}
+		if _, ok := set["updated_at"]; !ok {
+			mt.Error("UpdatePartial did not bump updated_at")
+		}
+
+		// A full update leaves created_at as it was stored
+		mt.AddMockResponses(matched)
+		if err := repo.Update(ctx, 7, &mongoTestItem{ID: 7, A: 3, Name: "full"}); err != nil {
+			mt.Fatalf("Update: %v", err)
+		}
+		set = lastUpdate(mt)
+		for _, field := range []string{"created_at", "_id"} {
+			if _, ok := set[field]; ok {
+				mt.Errorf("Update overwrote %s: %v", field, set)
+			}
+		}
+		if set["name"] != "full" || set["a"] != int32(3) {
+			mt.Errorf("Update set %v, want the struct's fields", set)
+		}
+		if updated, ok := set["updated_at"].(primitive.DateTime); !ok || time.Since(updated.Time()) > time.Minute {
+			mt.Errorf("Update set updated_at to %v, want now", set["updated_at"])
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+		if err := repo.UpdatePartial(ctx, 8, map[string]any{"name": "missing"}); err != ErrRecordNotFound {
+			mt.Errorf("UpdatePartial on a missing document = %v, want ErrRecordNotFound", err)
+		}
+	})
+}
+
+func TestMongoAggregatesHonourWhere(t *testing.T) {
+	four, err := primitive.ParseDecimal128("4")
+	if err != nil {
+		t.Fatalf("ParseDecimal128: %v", err)
+	}
+
+	runMongoMock(t, func(mt *mtest.T, repo Repository[mongoTestItem]) {
+		ctx := context.Background()
+
+		tests := []struct {
+			name   string
+			run    func(context.Context, string) (float64, error)
+			group  string
+			result any
+			want   float64
+		}{
+			{"Sum", repo.Where("name", "red").Sum, "$sum", int32(5), 5},
+			{"Avg", repo.Where("name", "red").Avg, "$avg", 2.5, 2.5},
+			{"Min", repo.Where("name", "red").Min, "$min", int64(1), 1},
+			{"Max", repo.Where("name", "red").Max, "$max", four, 4},
+		}
+		for _, tt := range tests {
+			mt.AddMockResponses(cursorResponse(mt, bson.D{{Key: "_id", Value: nil}, {Key: "value", Value: tt.result}}))
+			got, err := tt.run(ctx, "a")
+			if err != nil || got != tt.want {
+				mt.Errorf("%s(a) = %v, %v, want %v", tt.name, got, err, tt.want)
+			}
+
+			event := mt.GetStartedEvent()
+			if event == nil || event.CommandName != "aggregate" {
+				mt.Fatalf("%s did not run an aggregate", tt.name)
+			}
+			var pipeline []bson.M
+			if err := event.Command.Lookup("pipeline").Unmarshal(&pipeline); err != nil || len(pipeline) != 2 {
+				mt.Fatalf("%s: pipeline %v, %v", tt.name, pipeline, err)
+			}
+			if match, _ := pipeline[0]["$match"].(bson.M); match["name"] != "red" {
+				mt.Errorf("%s: $match = %v, want the Where filter", tt.name, pipeline[0]["$match"])
+			}
+			group, _ := pipeline[1]["$group"].(bson.M)
+			if value, _ := group["value"].(bson.M); value[tt.group] != "$a" {
+				mt.Errorf("%s: $group = %v, want %s of $a", tt.name, group, tt.group)
+			}
+		}
+
+		// No matching documents give no group at all
+		mt.AddMockResponses(cursorResponse(mt))
+		if got, err := repo.Where("name", "green").Sum(ctx, "a"); err != nil || got != 0 {
+			mt.Errorf("Sum over no documents = %v, %v, want 0", got, err)
+		}
+	})
+}
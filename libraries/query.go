@@ -2,7 +2,11 @@ package libraries
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"strings"
+
+	"go-api-boilerplate/database"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -12,6 +16,19 @@ import (
 type GormQuery[T any] struct {
 	db    *gorm.DB
 	model T
+	// primary is the write connection the query moves to when it runs with
+	// a context marked by database.ReadFromPrimary; nil in transactions
+	primary *gorm.DB
+}
+
+// session binds the query to ctx, moving it onto the primary's connection
+// pool when ctx is marked by database.ReadFromPrimary
+func (q *GormQuery[T]) session(ctx context.Context) *gorm.DB {
+	tx := q.db.WithContext(ctx)
+	if q.primary != nil && database.ReadsFromPrimary(ctx) {
+		tx.Statement.ConnPool = q.primary.Statement.ConnPool
+	}
+	return tx
 }
 
 // Where adds a WHERE condition using clause.Eq
@@ -61,11 +78,25 @@ func (q *GormQuery[T]) WhereNotNull(field string) Query[T] {
 	return q
 }
 
-// OrWhere adds an OR condition using clause.Or and clause.Eq
+// OrWhere ORs an equality condition with every condition added so far, so
+// Where("a", 1).OrWhere("b", 2) matches (a = 1 OR b = 2). Conditions added
+// afterwards are ANDed with the whole group.
 func (q *GormQuery[T]) OrWhere(field string, value any) Query[T] {
-	q.db = q.db.Clauses(clause.Where{Exprs: []clause.Expression{
-		clause.Or(clause.Eq{Column: field, Value: value}),
-	}})
+	condition := clause.Eq{Column: field, Value: value}
+
+	db := q.db.Clauses()
+	existing := db.Statement.Clauses["WHERE"]
+	where, ok := existing.Expression.(clause.Where)
+	if !ok || len(where.Exprs) == 0 {
+		q.db = db.Clauses(clause.Where{Exprs: []clause.Expression{condition}})
+		return q
+	}
+
+	existing.Expression = clause.Where{Exprs: []clause.Expression{
+		clause.Or(clause.And(where.Exprs...), condition),
+	}}
+	db.Statement.Clauses["WHERE"] = existing
+	q.db = db
 	return q
 }
 
@@ -113,12 +144,20 @@ func (q *GormQuery[T]) Offset(offset int) Query[T] {
 	return q
 }
 
-// GroupBy adds grouping
+// GroupBy adds a single GROUP BY clause over the given fields. Calling it
+// without fields leaves the query ungrouped.
 func (q *GormQuery[T]) GroupBy(fields ...string) Query[T] {
-	q.db = q.db.Group(fmt.Sprintf("%s", fields[0]))
-	for _, field := range fields[1:] {
-		q.db = q.db.Group(field)
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			columns = append(columns, field)
+		}
+	}
+	if len(columns) == 0 {
+		return q
 	}
+
+	q.db = q.db.Group(strings.Join(columns, ", "))
 	return q
 }
 
@@ -142,8 +181,8 @@ func (q *GormQuery[T]) Distinct() Query[T] {
 
 // Find executes the query and returns results
 func (q *GormQuery[T]) Find(ctx context.Context) ([]T, error) {
-	var results []T
-	err := q.db.WithContext(ctx).Find(&results).Error
+	results := []T{}
+	err := q.session(ctx).Find(&results).Error
 	if err != nil {
 		return nil, err
 	}
@@ -153,7 +192,7 @@ func (q *GormQuery[T]) Find(ctx context.Context) ([]T, error) {
 // First gets the first result
 func (q *GormQuery[T]) First(ctx context.Context) (*T, error) {
 	var result T
-	err := q.db.WithContext(ctx).First(&result).Error
+	err := q.session(ctx).First(&result).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return nil, ErrRecordNotFound
@@ -177,8 +216,7 @@ func (q *GormQuery[T]) FirstOrFail(ctx context.Context) (*T, error) {
 
 // Exists checks if records exist
 func (q *GormQuery[T]) Exists(ctx context.Context) (bool, error) {
-	var count int64
-	err := q.db.WithContext(ctx).Model(&q.model).Count(&count).Error
+	count, err := q.Count(ctx)
 	return count > 0, err
 }
 
@@ -188,28 +226,74 @@ func (q *GormQuery[T]) DoesNotExist(ctx context.Context) (bool, error) {
 	return !exists, err
 }
 
-// Count counts matching records
+// Count counts the rows the query would return. A single selected column
+// with Distinct counts distinct values; grouped queries and Distinct over
+// several columns are counted through a subquery, so they count groups and
+// distinct tuples rather than the underlying rows.
 func (q *GormQuery[T]) Count(ctx context.Context) (int64, error) {
+	tx := q.session(ctx).Model(&q.model)
+
 	var count int64
-	err := q.db.WithContext(ctx).Model(&q.model).Count(&count).Error
+	_, grouped := tx.Statement.Clauses["GROUP BY"]
+	if grouped || (tx.Statement.Distinct && len(tx.Statement.Selects) > 1) {
+		err := tx.Session(&gorm.Session{NewDB: true}).Table("(?) AS counted", tx).Count(&count).Error
+		return count, err
+	}
+
+	err := tx.Count(&count).Error
 	return count, err
 }
 
+// Sum returns the sum of a numeric column over the matching rows
+func (q *GormQuery[T]) Sum(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "SUM", field)
+}
+
+// Avg returns the average of a numeric column over the matching rows
+func (q *GormQuery[T]) Avg(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "AVG", field)
+}
+
+// Min returns the smallest value of a numeric column over the matching rows
+func (q *GormQuery[T]) Min(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "MIN", field)
+}
+
+// Max returns the largest value of a numeric column over the matching rows
+func (q *GormQuery[T]) Max(ctx context.Context, field string) (float64, error) {
+	return q.aggregate(ctx, "MAX", field)
+}
+
+// aggregate applies an SQL aggregate function to a column. Ordering and
+// limits are dropped since the result is a single row.
+func (q *GormQuery[T]) aggregate(ctx context.Context, function, field string) (float64, error) {
+	tx := q.session(ctx).Model(&q.model)
+	delete(tx.Statement.Clauses, "ORDER BY")
+	delete(tx.Statement.Clauses, "LIMIT")
+
+	var result sql.NullFloat64
+	err := tx.Select(function+"(?)", clause.Column{Name: field}).Row().Scan(&result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Float64, nil
+}
+
 // Pluck extracts values from a column
 func (q *GormQuery[T]) Pluck(ctx context.Context, field string) ([]any, error) {
-	var results []any
-	err := q.db.WithContext(ctx).Model(&q.model).Pluck(field, &results).Error
+	results := []any{}
+	err := q.session(ctx).Model(&q.model).Pluck(field, &results).Error
 	return results, err
 }
 
 // Delete deletes matching records
 func (q *GormQuery[T]) Delete(ctx context.Context) error {
-	return q.db.WithContext(ctx).Delete(&q.model).Error
+	return q.session(ctx).Delete(&q.model).Error
 }
 
 // Update updates matching records
 func (q *GormQuery[T]) Update(ctx context.Context, data map[string]any) error {
-	return q.db.WithContext(ctx).Model(&q.model).Updates(data).Error
+	return q.session(ctx).Model(&q.model).Updates(data).Error
 }
 
 // Paginate creates a paginated result
@@ -230,9 +314,9 @@ type GormPaginatedResult[T any] struct {
 
 // Execute executes the paginated query
 func (p *GormPaginatedResult[T]) Execute(ctx context.Context) (*PaginationMeta, []T, error) {
-	// Count total records
-	var total int64
-	if err := p.query.db.Model(&p.query.model).Count(&total).Error; err != nil {
+	// Count total records, or groups and distinct rows for such queries
+	total, err := p.query.Count(ctx)
+	if err != nil {
 		return nil, nil, err
 	}
 
@@ -251,8 +335,8 @@ func (p *GormPaginatedResult[T]) Execute(ctx context.Context) (*PaginationMeta,
 	}
 
 	// Get paginated results
-	var results []T
-	err := p.query.db.WithContext(ctx).
+	results := []T{}
+	err = p.query.session(ctx).
 		Limit(p.perPage).
 		Offset(offset).
 		Find(&results).Error
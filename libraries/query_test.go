@@ -0,0 +1,328 @@
+package libraries
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"go-api-boilerplate/database"
+)
+
+// queryTestItem is the model the query tests store
+type queryTestItem struct {
+	ID    uint
+	A     int
+	B     int
+	Email string
+	Team  string
+}
+
+// newQueryTestRepository stores items in a throwaway SQLite database and
+// returns a repository over them
+func newQueryTestRepository(t *testing.T, items ...queryTestItem) Repository[queryTestItem] {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&queryTestItem{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if len(items) > 0 {
+		if err := db.Create(&items).Error; err != nil {
+			t.Fatalf("failed to store items: %v", err)
+		}
+	}
+	return NewGormRepository(&database.DB{Write: db, Read: db}, queryTestItem{}, "query_test_items")
+}
+
+// querySQL returns the SQL a query runs for Find, without running it
+func querySQL(query Query[queryTestItem]) string {
+	db := query.(*GormQuery[queryTestItem]).db
+	var items []queryTestItem
+	return db.Session(&gorm.Session{DryRun: true}).Find(&items).Statement.SQL.String()
+}
+
+// ids returns the IDs of items
+func ids(items []queryTestItem) []uint {
+	result := make([]uint, len(items))
+	for i, item := range items {
+		result[i] = item.ID
+	}
+	return result
+}
+
+func TestOrWhereGroupsWithEarlierConditions(t *testing.T) {
+	repo := newQueryTestRepository(t,
+		queryTestItem{A: 1, B: 0},
+		queryTestItem{A: 0, B: 2},
+		queryTestItem{A: 1, B: 2},
+		queryTestItem{A: 0, B: 0},
+	)
+	ctx := context.Background()
+
+	query := repo.Where("a", 1).OrWhere("b", 2)
+	if sql := querySQL(query); !strings.HasSuffix(sql, "WHERE (a = ? OR `b` = ?)") {
+		t.Errorf("SQL = %s, want the conditions grouped as (a = ? OR b = ?)", sql)
+	}
+	items, err := query.OrderBy("id", "asc").Find(ctx)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if got := ids(items); len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("a = 1 OR b = 2 matched %v, want [1 2 3]", got)
+	}
+
+	// A condition added after the group is ANDed with all of it
+	items, err = repo.Where("a", 1).OrWhere("b", 2).Where("id", 2).Find(ctx)
+	if err != nil || len(items) != 1 || items[0].ID != 2 {
+		t.Errorf("(a = 1 OR b = 2) AND id = 2 matched %v, %v, want [2]", ids(items), err)
+	}
+
+	// OrWhere on its own is a plain condition
+	items, err = repo.WithTrashed().OrWhere("b", 2).Find(ctx)
+	if err != nil || len(items) != 2 {
+		t.Errorf("OrWhere alone matched %v, %v, want 2 items", ids(items), err)
+	}
+}
+
+func TestCountHonoursDistinctAndGroupBy(t *testing.T) {
+	repo := newQueryTestRepository(t,
+		queryTestItem{Email: "a@example.com", Team: "red"},
+		queryTestItem{Email: "a@example.com", Team: "red"},
+		queryTestItem{Email: "a@example.com", Team: "blue"},
+		queryTestItem{Email: "b@example.com", Team: "blue"},
+		queryTestItem{Email: "c@example.com", Team: "green"},
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		query Query[queryTestItem]
+		want  int64
+	}{
+		{"all rows", repo.WithTrashed(), 5},
+		{"distinct emails", repo.WithTrashed().Select("email").Distinct(), 3},
+		{"distinct email and team pairs", repo.WithTrashed().Select("email", "team").Distinct(), 4},
+		{"groups", repo.WithTrashed().Select("team").GroupBy("team"), 3},
+		{"filtered groups", repo.Where("email", "a@example.com").Select("team").GroupBy("team"), 2},
+	}
+	for _, tt := range tests {
+		if got, err := tt.query.Count(ctx); err != nil || got != tt.want {
+			t.Errorf("%s: Count = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+
+	meta, teams, err := repo.WithTrashed().Select("team").GroupBy("team").OrderBy("team", "asc").Paginate(1, 2).Execute(ctx)
+	if err != nil {
+		t.Fatalf("grouped Paginate: %v", err)
+	}
+	if meta.Total != 3 || meta.TotalPages != 2 || !meta.HasNext || len(teams) != 2 || teams[0].Team != "blue" {
+		t.Errorf("grouped pagination = %+v with %d teams, want 3 groups over 2 pages", meta, len(teams))
+	}
+
+	meta, _, err = repo.WithTrashed().Select("email").Distinct().Paginate(2, 2).Execute(ctx)
+	if err != nil || meta.Total != 3 || meta.HasNext || !meta.HasPrev {
+		t.Errorf("distinct pagination = %+v, %v, want 3 emails with page 2 the last", meta, err)
+	}
+}
+
+func TestEmptyResultsAreEmptyArrays(t *testing.T) {
+	repo := newQueryTestRepository(t, queryTestItem{A: 1, Email: "a@example.com"})
+	empty := newQueryTestRepository(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func() (any, error)
+	}{
+		{"FindAll", func() (any, error) { return empty.FindAll(ctx) }},
+		{"PluckString", func() (any, error) { return empty.PluckString(ctx, "email") }},
+		{"Find", func() (any, error) { return repo.Where("a", 2).Find(ctx) }},
+		{"Pluck", func() (any, error) { return repo.Where("a", 2).Pluck(ctx, "email") }},
+		{"Paginate", func() (any, error) {
+			_, items, err := repo.Where("a", 2).Paginate(1, 10).Execute(ctx)
+			return items, err
+		}},
+		{"Paginate past the last page", func() (any, error) {
+			_, items, err := repo.WithTrashed().Paginate(5, 10).Execute(ctx)
+			return items, err
+		}},
+	}
+	for _, tt := range tests {
+		got, err := tt.run()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if body, _ := json.Marshal(got); string(body) != "[]" {
+			t.Errorf("%s with no rows marshals as %s, want []", tt.name, body)
+		}
+	}
+}
+
+func TestGroupByBuildsOneClause(t *testing.T) {
+	repo := newQueryTestRepository(t)
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{"no fields", nil, "SELECT * FROM `query_test_items`"},
+		{"blank fields", []string{" ", ""}, "SELECT * FROM `query_test_items`"},
+		{"one field", []string{"team"}, "SELECT * FROM `query_test_items` GROUP BY `team`"},
+		{"several fields", []string{"team", " email"}, "SELECT * FROM `query_test_items` GROUP BY team, email"},
+	}
+	for _, tt := range tests {
+		if got := querySQL(repo.WithTrashed().GroupBy(tt.fields...)); got != tt.want {
+			t.Errorf("%s: SQL = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHavingFiltersGroups(t *testing.T) {
+	repo := newQueryTestRepository(t,
+		queryTestItem{A: 1, Team: "red"},
+		queryTestItem{A: 2, Team: "red"},
+		queryTestItem{A: 5, Team: "blue"},
+		queryTestItem{A: 1, Team: "green"},
+		queryTestItem{A: 1, Team: "green", Email: "x@example.com"},
+	)
+	ctx := context.Background()
+
+	query := repo.WithTrashed().Select("team").GroupBy("team").Having("SUM(a) > ?", 2).OrderBy("team", "asc")
+	teams, err := query.Find(ctx)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(teams) != 2 || teams[0].Team != "blue" || teams[1].Team != "red" {
+		t.Errorf("teams with SUM(a) > 2 = %+v, want blue and red", teams)
+	}
+
+	// Several group fields form one grouping over the pairs
+	pairs, err := repo.WithTrashed().Select("team", "email").GroupBy("team", "email").Having("COUNT(*) >= ?", 2).Find(ctx)
+	if err != nil || len(pairs) != 1 || pairs[0].Team != "red" {
+		t.Errorf("team and email pairs seen twice = %+v, %v, want red only", pairs, err)
+	}
+}
+
+func TestAggregatesHonourWhere(t *testing.T) {
+	repo := newQueryTestRepository(t,
+		queryTestItem{A: 1, Team: "red"},
+		queryTestItem{A: 4, Team: "red"},
+		queryTestItem{A: 10, Team: "blue"},
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		query Query[queryTestItem]
+		want  [4]float64
+	}{
+		{"all rows", repo.WithTrashed(), [4]float64{15, 5, 1, 10}},
+		{"filtered", repo.Where("team", "red"), [4]float64{5, 2.5, 1, 4}},
+		{"ordered and limited", repo.Where("team", "red").OrderBy("a", "desc").Limit(1), [4]float64{5, 2.5, 1, 4}},
+		{"no rows", repo.Where("team", "green"), [4]float64{0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		aggregates := []func(context.Context, string) (float64, error){tt.query.Sum, tt.query.Avg, tt.query.Min, tt.query.Max}
+		for i, name := range []string{"Sum", "Avg", "Min", "Max"} {
+			if got, err := aggregates[i](ctx, "a"); err != nil || got != tt.want[i] {
+				t.Errorf("%s: %s(a) = %v, %v, want %v", tt.name, name, got, err, tt.want[i])
+			}
+		}
+	}
+}
+
+// newLaggingTestRepository returns a repository whose read connection is a
+// replica that has not caught up: the primary holds items and the replica
+// is empty
+func newLaggingTestRepository(t *testing.T, items ...queryTestItem) Repository[queryTestItem] {
+	t.Helper()
+
+	open := func(name string) *gorm.DB {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), name)), &gorm.Config{
+			Logger: logger.Default.LogMode(logger.Silent),
+		})
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		if err := db.AutoMigrate(&queryTestItem{}); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+		return db
+	}
+	write, read := open("primary.db"), open("replica.db")
+	if err := write.Create(&items).Error; err != nil {
+		t.Fatalf("failed to store items: %v", err)
+	}
+	return NewGormRepository(&database.DB{Write: write, Read: read}, queryTestItem{}, "query_test_items")
+}
+
+func TestReadFromPrimaryRoutesToWriteConnection(t *testing.T) {
+	repo := newLaggingTestRepository(t, queryTestItem{ID: 1, A: 1, Team: "red"}, queryTestItem{ID: 2, A: 2, Team: "red"})
+
+	// Each read returns a number that is 0 on the empty replica and want on
+	// the primary
+	tests := []struct {
+		name string
+		read func(context.Context) (int, error)
+		want int
+	}{
+		{"FindByID", func(ctx context.Context) (int, error) {
+			item, err := repo.FindByID(ctx, 1)
+			if errors.Is(err, ErrRecordNotFound) {
+				return 0, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			return int(item.ID), nil
+		}, 1},
+		{"FindAll", func(ctx context.Context) (int, error) {
+			items, err := repo.FindAll(ctx)
+			return len(items), err
+		}, 2},
+		{"Count", func(ctx context.Context) (int, error) {
+			count, err := repo.Count(ctx)
+			return int(count), err
+		}, 2},
+		{"Where then Find", func(ctx context.Context) (int, error) {
+			items, err := repo.Where("team", "red").Find(ctx)
+			return len(items), err
+		}, 2},
+		{"OrderBy then Count", func(ctx context.Context) (int, error) {
+			count, err := repo.OrderBy("a", "desc").Count(ctx)
+			return int(count), err
+		}, 2},
+		{"Sum", func(ctx context.Context) (int, error) {
+			sum, err := repo.Where("team", "red").Sum(ctx, "a")
+			return int(sum), err
+		}, 3},
+		{"Paginate", func(ctx context.Context) (int, error) {
+			meta, _, err := repo.Where("team", "red").Paginate(1, 10).Execute(ctx)
+			if err != nil {
+				return 0, err
+			}
+			return int(meta.Total), nil
+		}, 2},
+	}
+	for _, tt := range tests {
+		if got, err := tt.read(context.Background()); err != nil || got != 0 {
+			t.Errorf("%s on the replica = %d, %v, want 0", tt.name, got, err)
+		}
+		if got, err := tt.read(database.ReadFromPrimary(context.Background())); err != nil || got != tt.want {
+			t.Errorf("%s from the primary = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
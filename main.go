@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
@@ -13,6 +14,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -21,11 +25,17 @@ import (
 	"go-api-boilerplate/config"
 	"go-api-boilerplate/controllers"
 	"go-api-boilerplate/database"
+	"go-api-boilerplate/docs"
 	grpcinterceptors "go-api-boilerplate/grpc/interceptors"
 	"go-api-boilerplate/grpc/proto"
 	grpcserver "go-api-boilerplate/grpc/server"
 	middleware "go-api-boilerplate/middlewares"
+	"go-api-boilerplate/models"
 	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/pkg/metrics"
+	"go-api-boilerplate/pkg/profiling"
+	"go-api-boilerplate/pkg/shutdown"
+	"go-api-boilerplate/pkg/tracing"
 	"go-api-boilerplate/services"
 )
 
@@ -41,6 +51,36 @@ func main() {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
 
+	// Apply the response timestamp and ID format
+	models.SetJSONFormat(models.JSONFormat{
+		EpochMillis: cfg.Response.TimeFormat == "epoch_millis",
+		StringIDs:   cfg.Response.IDFormat == "string",
+	})
+
+	// Initialize tracing (no-op when OTEL_ENABLED=false)
+	if err := tracing.Init(cfg); err != nil {
+		logger.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracing.Shutdown(ctx); err != nil {
+			logger.Warnf("Failed to flush traces: %v", err)
+		}
+	}()
+
+	// Serve pprof on its own admin listener (no-op when PPROF_ENABLED=false)
+	if err := profiling.Init(cfg); err != nil {
+		logger.Fatalf("Failed to start pprof server: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := profiling.Shutdown(ctx); err != nil {
+			logger.Warnf("Failed to stop pprof server: %v", err)
+		}
+	}()
+
 	// Connect to database
 	db, err := database.Connect(cfg)
 	if err != nil {
@@ -48,6 +88,11 @@ func main() {
 	}
 	defer database.Close()
 
+	// Run schema migrations
+	if err := database.Migrate(); err != nil {
+		logger.Fatalf("Failed to run database migrations: %v", err)
+	}
+
 	// Initialize Redis
 	redisService, err := services.NewRedisService()
 	if err != nil {
@@ -62,10 +107,29 @@ func main() {
 
 	// Initialize services
 	authService := services.NewAuthService(db, redisService)
-	userService := services.NewUserService(db)
-	uploadService := services.NewUploadService()
-	wsService := services.NewWebSocketService()
-	streamService := services.NewStreamService()
+	userService := services.NewUserService(db, redisService)
+	uploadService := services.NewUploadService(db)
+	uploadService.SetRedis(redisService)
+	wsService := services.NewWebSocketService(redisService)
+	streamService := services.NewStreamService(redisService)
+
+	// User changes and cache invalidations reach every instance through
+	// the event bus; without Redis it only delivers locally
+	eventBus := services.NewEventBus(redisService)
+	defer eventBus.Close()
+	authService.SetEventBus(eventBus)
+	userService.SetEventBus(eventBus)
+
+	// Session store for cookie-based authentication; deleting a user ends
+	// their sessions and removes their files
+	sessionStore := services.NewSessionStore(cfg, redisService)
+	userService.SetSessionStore(sessionStore)
+	userService.SetFileStore(uploadService)
+
+	// Delete expired and used password reset and verification tokens
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	authService.StartTokenCleanup(cleanupCtx, cfg.Auth.TokenCleanupInterval)
 
 	// Wait group for graceful shutdown
 	var wg sync.WaitGroup
@@ -74,7 +138,7 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := startRESTServer(cfg, db, redisService, authService, userService, uploadService, wsService, streamService); err != nil {
+		if err := startRESTServer(cfg, db, redisService, sessionStore, authService, userService, uploadService, wsService, streamService); err != nil {
 			logger.Fatalf("REST server failed: %v", err)
 		}
 	}()
@@ -83,7 +147,7 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := startGRPCServer(cfg, authService, userService); err != nil {
+		if err := startGRPCServer(cfg, redisService, authService, userService); err != nil {
 			logger.Fatalf("gRPC server failed: %v", err)
 		}
 	}()
@@ -105,6 +169,7 @@ func startRESTServer(
 	cfg *config.Config,
 	db *database.DB,
 	redis *services.RedisService,
+	sessionStore services.SessionStore,
 	authService *services.AuthService,
 	userService *services.UserService,
 	uploadService *services.UploadService,
@@ -112,21 +177,34 @@ func startRESTServer(
 	streamService *services.StreamService,
 ) error {
 	// Create router (reuse from api/main.go)
-	router := setupRouter(cfg, db, redis, authService, userService, uploadService, wsService, streamService)
+	router := setupRouter(cfg, db, redis, sessionStore, authService, userService, uploadService, wsService, streamService)
 
-	// Create HTTP server
+	// Create HTTP server; the tracker counts connections left at shutdown
+	conns := shutdown.NewConnTracker()
 	srv := &http.Server{
-		Addr:           fmt.Sprintf(":%s", cfg.App.Port),
-		Handler:        router,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+		Addr:              fmt.Sprintf(":%s", cfg.App.Port),
+		Handler:           router,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+		ConnState:         conns.Track,
+	}
+	if cfg.TLS.Enabled {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
 	}
 
 	// Start server in goroutine
 	go func() {
-		logger.Infof("Starting REST API on port %s", cfg.App.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Infof("Starting REST API on port %s (TLS: %t)", cfg.App.Port, cfg.TLS.Enabled)
+		var err error
+		if cfg.TLS.Enabled {
+			err = srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatalf("Failed to start REST server: %v", err)
 		}
 	}()
@@ -136,11 +214,9 @@ func startRESTServer(
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	if err := srv.Shutdown(ctx); err != nil {
+	// Graceful shutdown; WebSocket connections are hijacked, so the server
+	// cannot drain them and the service closes them itself
+	if err := shutdown.HTTP(srv, conns, cfg.Server.ShutdownTimeout, wsService.Shutdown); err != nil {
 		return fmt.Errorf("REST server forced to shutdown: %w", err)
 	}
 
@@ -149,23 +225,50 @@ func startRESTServer(
 
 func startGRPCServer(
 	cfg *config.Config,
+	redis *services.RedisService,
 	authService *services.AuthService,
 	userService *services.UserService,
 ) error {
 	// Create gRPC server with interceptors
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpcinterceptors.LoggingInterceptor(),
+		grpcinterceptors.DeadlineInterceptor(cfg.GRPC.DefaultDeadline),
+		grpcinterceptors.RecoveryInterceptor(),
+		grpcinterceptors.AuthInterceptor(authService),
+		grpcinterceptors.ValidationInterceptor(),
+	}
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpcinterceptors.StreamLoggingInterceptor(),
+		grpcinterceptors.StreamRecoveryInterceptor(),
+		grpcinterceptors.StreamAuthInterceptor(authService),
+		grpcinterceptors.StreamValidationInterceptor(),
+	}
+
+	// Rate limiting runs after auth so authenticated callers are keyed by user
+	if cfg.RateLimit.Enabled && redis.Available() {
+		unaryInterceptors = append(unaryInterceptors, grpcinterceptors.RateLimitInterceptor(redis, cfg.RateLimit.Requests, cfg.RateLimit.Duration, cfg.Server.TrustedProxies))
+		streamInterceptors = append(streamInterceptors, grpcinterceptors.StreamRateLimitInterceptor(redis, cfg.RateLimit.Requests, cfg.RateLimit.Duration, cfg.Server.TrustedProxies))
+	}
+
+	// The trace ID is exposed first so failed calls can still be correlated
+	if tracing.Enabled() {
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{grpcinterceptors.TraceIDInterceptor()}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{grpcinterceptors.StreamTraceIDInterceptor()}, streamInterceptors...)
+	}
+
 	opts := []grpc.ServerOption{
-		grpc.ChainUnaryInterceptor(
-			grpcinterceptors.LoggingInterceptor(),
-			grpcinterceptors.RecoveryInterceptor(),
-			grpcinterceptors.AuthInterceptor(),
-			grpcinterceptors.ValidationInterceptor(),
-		),
-		grpc.ChainStreamInterceptor(
-			grpcinterceptors.StreamLoggingInterceptor(),
-			grpcinterceptors.StreamRecoveryInterceptor(),
-			grpcinterceptors.StreamAuthInterceptor(),
-		),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	if tracing.Enabled() {
+		// otelgrpc starts the server span before any interceptor runs
+		opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	}
+	serverOpts, err := grpcserver.ServerOptions(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure gRPC server: %w", err)
 	}
+	opts = append(opts, serverOpts...)
 
 	grpcServer := grpc.NewServer(opts...)
 
@@ -175,13 +278,16 @@ func startGRPCServer(
 
 	proto.RegisterAuthServiceServer(grpcServer, authServer)
 	proto.RegisterUserServiceServer(grpcServer, userServer)
+	proto.RegisterVersionServiceServer(grpcServer, grpcserver.NewVersionServer())
 
 	// Register health check
 	healthServer := health.NewServer()
 	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
 
-	// Register reflection service for debugging
-	reflection.Register(grpcServer)
+	// Reflection exposes the full service schema, so it stays off in production
+	if cfg.GRPCReflectionEnabled() {
+		reflection.Register(grpcServer)
+	}
 
 	// Create listener
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.App.GRPCPort))
@@ -191,7 +297,7 @@ func startGRPCServer(
 
 	// Start server in goroutine
 	go func() {
-		logger.Infof("Starting gRPC server on port %s", cfg.App.GRPCPort)
+		logger.Infof("Starting gRPC server on port %s (TLS: %t)", cfg.App.GRPCPort, cfg.TLS.Enabled)
 		if err := grpcServer.Serve(lis); err != nil {
 			logger.Fatalf("Failed to serve gRPC: %v", err)
 		}
@@ -203,7 +309,7 @@ func startGRPCServer(
 	<-quit
 
 	// Graceful stop
-	grpcServer.GracefulStop()
+	shutdown.GRPC(grpcServer, cfg.Server.ShutdownTimeout)
 
 	return nil
 }
@@ -213,6 +319,7 @@ func setupRouter(
 	cfg *config.Config,
 	db *database.DB,
 	redis *services.RedisService,
+	sessionStore services.SessionStore,
 	authService *services.AuthService,
 	userService *services.UserService,
 	uploadService *services.UploadService,
@@ -221,24 +328,173 @@ func setupRouter(
 ) *gin.Engine {
 	router := gin.New()
 
+	// Multipart forms beyond this much memory spill to temporary files
+	router.MaxMultipartMemory = cfg.Upload.MaxMultipartMemory
+
+	// Only believe X-Forwarded-For from configured proxies; with none, the
+	// client IP used for rate limiting and audits is the direct peer
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
 	// Global middleware
 	router.Use(gin.Recovery())
 	router.Use(middleware.RequestIDMiddleware())
+	if tracing.Enabled() {
+		router.Use(middleware.TracingMiddleware(cfg.Tracing.ServiceName)...)
+	}
 	router.Use(middleware.LoggerMiddleware())
+	if cfg.Monitoring.MetricsEnabled {
+		router.Use(middleware.MetricsMiddleware(cfg.Monitoring.MetricsDropUnmatched))
+	}
 	router.Use(middleware.ErrorLoggerMiddleware())
 	router.Use(middleware.CORSMiddleware())
 	router.Use(middleware.SecureHeadersMiddleware())
+	if cfg.Compression.Enabled {
+		router.Use(middleware.CompressionMiddleware())
+	}
+	// Routes that stream or read long bodies run under their own deadlines;
+	// every route using UploadDeadlineMiddleware or StreamDeadlineMiddleware
+	// must be listed here
+	router.Use(middleware.TimeoutMiddleware(cfg.Server.RequestTimeout,
+		"/api/v1/upload",
+		"/api/v1/users/avatar",
+		"/api/v1/stream",
+		"/api/v1/ws",
+		"/api/v1/admin/users/import",
+		"/api/v1/admin/users/export",
+		"/uploads",
+	))
 
 	// Initialize handlers
-	healthHandler := controllers.NewHealthHandler(db, redis)
-	authHandler := controllers.NewAuthHandler(authService, userService)
-	userHandler := controllers.NewUserHandler(userService)
-	uploadHandler := controllers.NewUploadHandler(uploadService)
-	wsHandler := controllers.WebSocketController(wsService)
-	StreamController := controllers.NewStreamController(streamService)
+	healthHandler := controllers.NewHealthController(db, redis)
+	authHandler := controllers.NewAuthController(authService, userService, sessionStore)
+	userHandler := controllers.NewUserController(userService, authService, uploadService)
+	uploadHandler := controllers.NewUploadController(uploadService)
+	wsHandler := controllers.NewWebSocketController(wsService)
+	streamHandler := controllers.NewStreamController(streamService)
+
+	// Health probes
+	router.GET(cfg.Monitoring.HealthCheckPath, healthHandler.HealthCheck)
+	router.GET(cfg.Monitoring.HealthCheckPath+"/ready", healthHandler.ReadinessCheck)
+	router.GET("/version", healthHandler.Version)
 
 	// Routes setup (same as api/main.go)
 	// ... (copy route setup from api/main.go)
+	api := router.Group("/api/v1")
+	if cfg.RateLimit.Enabled {
+		api.Use(middleware.RateLimitMiddleware(redis, cfg.RateLimit.Requests, cfg.RateLimit.Duration))
+	}
+
+	// Auth routes
+	auth := api.Group("/auth")
+	{
+		auth.POST("/register", authHandler.Register)
+		auth.POST("/login", authHandler.Login)
+		auth.POST("/refresh", authHandler.RefreshToken)
+		auth.POST("/forgot-password", authHandler.ForgotPassword)
+		auth.POST("/reset-password", authHandler.ResetPassword)
+		auth.GET("/verify-email/:token", authHandler.VerifyEmail)
+		auth.POST("/logout", middleware.AuthMiddleware(authService), authHandler.Logout)
+		auth.POST("/logout-all", middleware.AuthMiddleware(authService), authHandler.LogoutAll)
+		auth.POST("/change-password", middleware.AuthMiddleware(authService), authHandler.ChangePassword)
+
+		// Cookie session routes
+		auth.POST("/session", authHandler.SessionLogin)
+		auth.DELETE("/session", middleware.SessionMiddleware(sessionStore), authHandler.SessionLogout)
+	}
+
+	// User routes
+	users := api.Group("/users", middleware.AuthMiddleware(authService))
+	{
+		users.GET("/me", userHandler.GetProfile)
+		users.PATCH("/profile", userHandler.PatchProfile)
+		users.POST("/avatar", middleware.UploadDeadlineMiddleware(cfg.Server.UploadTimeout), userHandler.UploadAvatar)
+	}
+
+	// Admin routes
+	admin := api.Group("/admin", middleware.AuthMiddleware(authService), middleware.RequireRole(models.RoleAdmin, models.RoleModerator))
+	{
+		admin.GET("/users", userHandler.ListUsers)
+		admin.GET("/users/export", middleware.StreamDeadlineMiddleware(cfg.Server.StreamWriteTimeout), userHandler.ExportUsers)
+		admin.POST("/users/batch", userHandler.BatchGetUsers)
+		admin.GET("/users/summary", userHandler.GetUserSummary)
+		admin.GET("/users/deleted", middleware.RequireRole(models.RoleAdmin), userHandler.ListDeletedUsers)
+		admin.GET("/users/:id", userHandler.GetUser)
+		admin.DELETE("/users/:id", middleware.RequireRole(models.RoleAdmin), userHandler.DeleteUser)
+		admin.POST("/users/:id/restore", middleware.RequireRole(models.RoleAdmin), userHandler.RestoreUser)
+		admin.POST("/users/import", middleware.RequireRole(models.RoleAdmin), middleware.UploadDeadlineMiddleware(cfg.Server.UploadTimeout), userHandler.ImportUsers)
+		admin.PUT("/users/:id/role", middleware.RequireRole(models.RoleAdmin), userHandler.UpdateUserRole)
+		admin.PUT("/users/:id/status", middleware.RequireRole(models.RoleAdmin), userHandler.UpdateUserStatus)
+	}
+
+	// Upload routes
+	upload := api.Group("/upload", middleware.UploadDeadlineMiddleware(cfg.Server.UploadTimeout), middleware.AuthMiddleware(authService))
+	{
+		upload.POST("", uploadHandler.UploadFile)
+		upload.POST("/multiple", uploadHandler.UploadMultipleFiles)
+	}
+
+	// Stored uploads; these bypass the service layer, so they are only
+	// public when UPLOAD_PUBLIC_FILES says everything uploaded is
+	files := router.Group("/uploads", middleware.StreamDeadlineMiddleware(cfg.Server.StreamWriteTimeout))
+	if cfg.RateLimit.Enabled {
+		files.Use(middleware.RateLimitMiddleware(redis, cfg.RateLimit.Requests, cfg.RateLimit.Duration))
+	}
+	if !cfg.Upload.PublicFiles {
+		files.Use(middleware.AuthMiddleware(authService))
+	}
+	files.GET("/*filepath", uploadHandler.ServeFile)
+
+	// Streaming routes
+	streaming := api.Group("/stream", middleware.StreamDeadlineMiddleware(cfg.Server.StreamWriteTimeout))
+	stream := streaming.Group("", middleware.AuthMiddleware(authService))
+	{
+		stream.GET("/info/:id", streamHandler.GetVideoInfo)
+		stream.POST("/sign/:id", streamHandler.SignVideoURL)
+	}
+	// Media is fetched by video elements and players, which cannot unwrap
+	// the envelope, so its errors are sent raw
+	media := streaming.Group("", middleware.RawResponseMiddleware())
+	media.GET("/video/:id", middleware.AuthMiddleware(authService), streamHandler.StreamVideo)
+	// Signed URLs carry their own authorization for video elements, which
+	// cannot send an Authorization header
+	media.GET("/signed/video/:id", streamHandler.StreamVideoSigned)
+	if cfg.Stream.HLSSignedURLs {
+		// Segments are authorized by the signature in the playlist rather
+		// than a bearer token, which the stream service checks
+		media.GET("/hls/:id/:path", middleware.OptionalAuthMiddleware(authService), streamHandler.StreamHLS)
+	} else {
+		media.GET("/hls/:id/:path", middleware.AuthMiddleware(authService), streamHandler.StreamHLS)
+	}
+
+	// WebSocket; the upgrade clears the server deadlines on the hijacked connection
+	api.GET("/ws", middleware.OptionalAuthMiddleware(authService), wsHandler.HandleWebSocket)
+
+	// Prometheus metrics
+	if cfg.Monitoring.MetricsEnabled {
+		router.GET(cfg.Monitoring.MetricsPath, gin.WrapH(metrics.Handler()))
+	}
+
+	// Swagger documentation
+	if cfg.Swagger.Enabled {
+		setupSwagger(router, cfg)
+	}
 
 	return router
 }
+
+// setupSwagger mounts Swagger UI with a relaxed CSP, protected by basic auth
+// outside development when SWAGGER_PROTECTED is enabled
+func setupSwagger(router *gin.Engine, cfg *config.Config) {
+	// Resolve host and base path at runtime instead of the generated annotations
+	docs.SwaggerInfo.Host = cfg.Swagger.Host
+	docs.SwaggerInfo.BasePath = cfg.Swagger.BasePath
+
+	swagger := router.Group("/swagger")
+	swagger.Use(middleware.ContentSecurityPolicyMiddleware(cfg.Security.SwaggerCSP))
+	if cfg.SwaggerRequiresAuth() {
+		swagger.Use(middleware.BasicAuthMiddleware(cfg.Swagger.Username, cfg.Swagger.Password, "Swagger"))
+	}
+	swagger.GET("/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+}
@@ -1,19 +1,25 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"go-api-boilerplate/config"
 	"go-api-boilerplate/models"
+	"go-api-boilerplate/pkg/logger"
 	"go-api-boilerplate/services"
 	"go-api-boilerplate/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware validates JWT tokens and adds user info to context
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware validates JWT tokens and adds user info to context. Tokens
+// revoked by a logout-all or password change are rejected when authService
+// is set.
+func AuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -34,28 +40,35 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Validate token
 		claims, err := utils.ValidateToken(token)
 		if err != nil {
-			if utils.IsTokenExpired(err) {
+			switch {
+			case utils.IsTokenExpired(err):
 				utils.UnauthorizedResponse(c, "Token has expired")
-			} else {
+			case errors.Is(err, utils.ErrInvalidAudience):
+				utils.UnauthorizedResponse(c, "Invalid token audience")
+			case errors.Is(err, utils.ErrUntrustedIssuer):
+				utils.UnauthorizedResponse(c, "Untrusted token issuer")
+			default:
 				utils.UnauthorizedResponse(c, "Invalid token")
 			}
 			c.Abort()
 			return
 		}
 
+		if authService != nil && authService.IsTokenRevoked(c.Request.Context(), claims) {
+			utils.UnauthorizedResponse(c, "Token has been revoked")
+			c.Abort()
+			return
+		}
+
 		// Set user info in context
-		c.Set("user_id", claims.UserID)
-		c.Set("user_email", claims.Email)
-		c.Set("user_name", claims.Name)
-		c.Set("user_role", claims.Role)
-		c.Set("is_active", claims.IsActive)
+		utils.SetUserContext(c, claims)
 
 		c.Next()
 	}
 }
 
 // OptionalAuthMiddleware validates JWT tokens if present but doesn't require them
-func OptionalAuthMiddleware() gin.HandlerFunc {
+func OptionalAuthMiddleware(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -73,17 +86,13 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 
 		// Validate token
 		claims, err := utils.ValidateToken(token)
-		if err != nil {
+		if err != nil || (authService != nil && authService.IsTokenRevoked(c.Request.Context(), claims)) {
 			c.Next()
 			return
 		}
 
 		// Set user info in context
-		c.Set("user_id", claims.UserID)
-		c.Set("user_email", claims.Email)
-		c.Set("user_name", claims.Name)
-		c.Set("user_role", claims.Role)
-		c.Set("is_active", claims.IsActive)
+		utils.SetUserContext(c, claims)
 
 		c.Next()
 	}
@@ -132,20 +141,22 @@ func RequireActiveUser() gin.HandlerFunc {
 	}
 }
 
-// RateLimitMiddleware implements rate limiting using Redis
-func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
+// RateLimitMiddleware implements rate limiting using Redis. Requests are
+// allowed through when Redis is unavailable.
+func RateLimitMiddleware(redisService *services.RedisService, limit int, window time.Duration) gin.HandlerFunc {
+	if !redisService.Available() {
+		logger.Warn("Redis unavailable: HTTP rate limiting is disabled")
+	}
+
 	return func(c *gin.Context) {
-		// Initialize Redis service
-		redisService, err := services.NewRedisService()
-		if err != nil {
-			// If Redis is not available, allow the request
+		if !redisService.Available() {
 			c.Next()
 			return
 		}
 
 		// Create rate limit key
 		var key string
-		userID, exists := c.Get("user_id")
+		userID, exists := utils.UserIDFromContext(c)
 		if exists {
 			key = fmt.Sprintf("rate_limit:user:%d:%s", userID, c.FullPath())
 		} else {
@@ -153,7 +164,7 @@ func RateLimitMiddleware(limit int, window time.Duration) gin.HandlerFunc {
 		}
 
 		// Check rate limit
-		allowed, remaining, err := redisService.RateLimitCheck(key, limit, window)
+		allowed, remaining, err := redisService.WithContext(c.Request.Context()).RateLimitCheck(key, limit, window)
 		if err != nil {
 			// If there's an error, allow the request
 			c.Next()
@@ -202,6 +213,24 @@ func APIKeyMiddleware() gin.HandlerFunc {
 	}
 }
 
+// BasicAuthMiddleware protects routes with HTTP basic authentication
+func BasicAuthMiddleware(username, password, realm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, pass, ok := c.Request.BasicAuth()
+		validUser := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		validPass := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+
+		if !ok || !validUser || !validPass {
+			c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+			utils.UnauthorizedResponse(c, "Invalid credentials")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // validateAPIKey validates the API key (placeholder implementation)
 func validateAPIKey(apiKey string) bool {
 	// Implement your API key validation logic here
@@ -211,12 +240,11 @@ func validateAPIKey(apiKey string) bool {
 
 // GetUserID gets the user ID from context
 func GetUserID(c *gin.Context) (uint, error) {
-	userID, exists := c.Get("user_id")
-	if !exists {
+	if _, exists := c.Get(utils.ContextKeyUserID); !exists {
 		return 0, fmt.Errorf("user ID not found in context")
 	}
 
-	id, ok := userID.(uint)
+	id, ok := utils.UserIDFromContext(c)
 	if !ok {
 		return 0, fmt.Errorf("invalid user ID type")
 	}
@@ -256,7 +284,7 @@ func GetUserRole(c *gin.Context) (string, error) {
 
 // IsAuthenticated checks if the user is authenticated
 func IsAuthenticated(c *gin.Context) bool {
-	_, exists := c.Get("user_id")
+	_, exists := utils.UserIDFromContext(c)
 	return exists
 }
 
@@ -299,36 +327,33 @@ func RequirePermission(permission string) gin.HandlerFunc {
 	}
 }
 
-// SessionMiddleware validates session-based authentication
-func SessionMiddleware() gin.HandlerFunc {
+// SessionMiddleware validates session-based authentication against the given store
+func SessionMiddleware(store services.SessionStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		cfg := config.Get()
+
 		// Get session ID from cookie
-		sessionID, err := c.Cookie("session_id")
+		sessionID, err := c.Cookie(cfg.Session.CookieName)
 		if err != nil || sessionID == "" {
 			utils.UnauthorizedResponse(c, "Session not found")
 			c.Abort()
 			return
 		}
 
-		// Initialize Redis service
-		redisService, err := services.NewRedisService()
-		if err != nil {
-			utils.InternalServerErrorResponse(c, "Failed to connect to session store")
-			c.Abort()
-			return
-		}
-
 		// Get session data
 		var sessionData map[string]interface{}
-		if err := redisService.SessionGet(sessionID, &sessionData); err != nil {
+		if err := store.Get(sessionID, &sessionData); err != nil {
 			utils.UnauthorizedResponse(c, "Invalid or expired session")
 			c.Abort()
 			return
 		}
 
 		// Set user info in context
-		if userID, ok := sessionData["user_id"].(float64); ok {
-			c.Set("user_id", uint(userID))
+		// JSON decodes numbers as float64; normalize to uint
+		if !utils.SetUserID(c, sessionData["user_id"]) {
+			utils.UnauthorizedResponse(c, "Invalid or expired session")
+			c.Abort()
+			return
 		}
 		if email, ok := sessionData["email"].(string); ok {
 			c.Set("user_email", email)
@@ -342,9 +367,10 @@ func SessionMiddleware() gin.HandlerFunc {
 		if isActive, ok := sessionData["is_active"].(bool); ok {
 			c.Set("is_active", isActive)
 		}
+		c.Set("session_id", sessionID)
 
 		// Extend session expiration
-		_ = redisService.SessionExtend(sessionID, 24*time.Hour)
+		_ = store.Extend(sessionID, cfg.Session.TTL)
 
 		c.Next()
 	}
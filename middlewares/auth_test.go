@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/services"
+)
+
+// newRateLimitedRouter trusts the configured proxies the way the API
+// router does and limits /ping to two requests per client
+func newRateLimitedRouter(t *testing.T, env map[string]string) *gin.Engine {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	env["REDIS_HOST"] = server.Host()
+	env["REDIS_PORT"] = server.Port()
+	loadTestConfig(t, env)
+
+	redis, err := services.NewRedisService()
+	if err != nil {
+		t.Fatalf("failed to connect to Redis: %v", err)
+	}
+	t.Cleanup(func() { redis.Close() })
+
+	router := newTestRouter()
+	if err := router.SetTrustedProxies(config.Get().Server.TrustedProxies); err != nil {
+		t.Fatalf("SetTrustedProxies: %v", err)
+	}
+	router.Use(RateLimitMiddleware(redis, 2, time.Minute))
+	router.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+	return router
+}
+
+// ping requests /ping from remoteAddr claiming to forward for forwardedFor
+func ping(router *gin.Engine, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+	return serve(router, req)
+}
+
+func TestSpoofedForwardedForIgnoredWithoutTrustedProxies(t *testing.T) {
+	router := newRateLimitedRouter(t, map[string]string{"TRUSTED_PROXIES": ""})
+
+	for i := 0; i < 3; i++ {
+		resp := ping(router, "203.0.113.7:5000", fmt.Sprintf("198.51.100.%d", i))
+		want := http.StatusOK
+		if i == 2 {
+			want = http.StatusTooManyRequests
+		}
+		if resp.Code != want {
+			t.Fatalf("request %d with a new spoofed IP: status %d, want %d", i+1, resp.Code, want)
+		}
+		if i < 2 && resp.Body.String() != "203.0.113.7" {
+			t.Errorf("client IP = %s, want the direct peer", resp.Body)
+		}
+	}
+}
+
+func TestForwardedForHonouredFromTrustedProxy(t *testing.T) {
+	router := newRateLimitedRouter(t, map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8"})
+
+	for i := 0; i < 3; i++ {
+		client := fmt.Sprintf("198.51.100.%d", i)
+		resp := ping(router, "10.1.2.3:5000", client)
+		if resp.Code != http.StatusOK || resp.Body.String() != client {
+			t.Errorf("client %s behind the proxy: status %d, IP %s", client, resp.Code, resp.Body)
+		}
+	}
+
+	// A client outside the trusted range is keyed on its own address
+	if resp := ping(router, "203.0.113.7:5000", "10.9.9.9"); resp.Body.String() != "203.0.113.7" {
+		t.Errorf("untrusted peer: client IP = %s, want the peer", resp.Body)
+	}
+}
+
+func TestRateLimitWithoutRedis(t *testing.T) {
+	loadTestConfig(t, nil)
+	server := miniredis.RunT(t)
+	config.Get().Redis.Host = server.Host()
+	config.Get().Redis.Port = server.Port()
+	stopped, err := services.NewRedisService()
+	if err != nil {
+		t.Fatalf("failed to connect to Redis: %v", err)
+	}
+	t.Cleanup(func() { stopped.Close() })
+	server.Close()
+
+	tests := []struct {
+		name  string
+		redis *services.RedisService
+	}{
+		{"no Redis", nil},
+		{"Redis stopped", stopped},
+	}
+	for _, tt := range tests {
+		router := newTestRouter(RateLimitMiddleware(tt.redis, 1, time.Minute))
+		router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		// Requests are let through rather than failed while Redis is down
+		for i := 0; i < 3; i++ {
+			resp := ping(router, "203.0.113.7:5000", "")
+			if resp.Code != http.StatusOK {
+				t.Errorf("%s: request %d: status %d, want 200", tt.name, i+1, resp.Code)
+			}
+			if limit := resp.Header().Get("X-RateLimit-Limit"); limit != "" {
+				t.Errorf("%s: X-RateLimit-Limit = %q without a counter", tt.name, limit)
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-api-boilerplate/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CompressionMiddleware compresses responses with gzip or deflate when the
+// client accepts it. Only responses of an allowed content type and at least
+// the minimum size are compressed. Range requests, partial content,
+// already encoded bodies and WebSocket upgrades pass through untouched so
+// Content-Length and Content-Range stay correct for streams and downloads.
+func CompressionMiddleware() gin.HandlerFunc {
+	cfg := config.Get().Compression
+
+	allowed := make(map[string]bool, len(cfg.ContentTypes))
+	for _, contentType := range cfg.ContentTypes {
+		allowed[strings.ToLower(strings.TrimSpace(contentType))] = true
+	}
+
+	return func(c *gin.Context) {
+		if c.GetHeader("Range") != "" || c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			encoding:       negotiateEncoding(c.GetHeader("Accept-Encoding")),
+			level:          cfg.Level,
+			minSize:        cfg.MinSize,
+			allowed:        allowed,
+			status:         http.StatusOK,
+		}
+		c.Writer = w
+		defer w.finish()
+
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip and skipping encodings with q=0
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				continue
+			}
+		}
+		accepted[name] = true
+	}
+
+	switch {
+	case accepted["gzip"] || accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compressWriter buffers the start of a response until it can decide
+// whether to compress, then either streams through an encoder or writes the
+// body unchanged
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	level    int
+	minSize  int
+	allowed  map[string]bool
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser
+}
+
+// WriteHeader records the status; it is sent once the body is inspected
+func (w *compressWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow commits the response without compression
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.decide(false)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Status returns the response status, including a status not yet sent
+func (w *compressWriter) Status() int {
+	if !w.decided {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+// Written reports whether the response has been committed
+func (w *compressWriter) Written() bool {
+	return w.decided || w.ResponseWriter.Written()
+}
+
+// Write buffers data until the minimum size is reached
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.minSize {
+			return len(data), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString buffers a string like Write
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush commits the buffered data so streamed responses are not held back
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) >= w.minSize)
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Hijack is not supported once the response may be encoded
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+// finish writes a response smaller than the minimum size, including the
+// status of an empty response, and closes the encoder
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
+
+// decide sends the headers, choosing compression when the body is large
+// enough and the response is eligible, then writes the buffered data
+func (w *compressWriter) decide(largeEnough bool) error {
+	w.decided = true
+	header := w.Header()
+
+	if w.eligible() {
+		header.Add("Vary", "Accept-Encoding")
+		if largeEnough && w.encoding != "" {
+			header.Set("Content-Encoding", w.encoding)
+			header.Del("Content-Length")
+			w.encoder = w.newEncoder()
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	data := w.buf
+	w.buf = nil
+	if w.encoder != nil {
+		_, err := w.encoder.Write(data)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(data)
+	return err
+}
+
+// eligible reports whether the response may be compressed at all
+func (w *compressWriter) eligible() bool {
+	switch w.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	if w.status < http.StatusOK {
+		return false
+	}
+
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return w.allowed[strings.ToLower(mediaType)]
+}
+
+// newEncoder creates the encoder for the negotiated encoding
+func (w *compressWriter) newEncoder() io.WriteCloser {
+	if w.encoding == "deflate" {
+		encoder, err := flate.NewWriter(w.ResponseWriter, w.level)
+		if err != nil {
+			encoder, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+		}
+		return encoder
+	}
+
+	encoder, err := gzip.NewWriterLevel(w.ResponseWriter, w.level)
+	if err != nil {
+		encoder = gzip.NewWriter(w.ResponseWriter)
+	}
+	return encoder
+}
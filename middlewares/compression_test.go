@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCompressionRouter serves a large and a small JSON body and a video
+// streamed with http.ServeContent
+func newCompressionRouter(t *testing.T, video []byte) *gin.Engine {
+	t.Helper()
+
+	loadTestConfig(t, nil)
+	router := newTestRouter(CompressionMiddleware())
+	router.GET("/json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": strings.Repeat("user ", 1000)})
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	router.GET("/video", func(c *gin.Context) {
+		c.Header("Content-Type", "video/mp4")
+		http.ServeContent(c.Writer, c.Request, "video.mp4", time.Now(), bytes.NewReader(video))
+	})
+	return router
+}
+
+func TestCompressionCompressesJSON(t *testing.T) {
+	router := newCompressionRouter(t, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	resp := serve(router, req)
+
+	if resp.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", resp.Header().Get("Content-Encoding"))
+	}
+	if resp.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", resp.Header().Get("Vary"))
+	}
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("body is not gzip: %v", err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil || !strings.Contains(string(body), "user user") {
+		t.Errorf("decompressed body %.40q, %v", body, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, deflate")
+	resp = serve(router, req)
+	if resp.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Content-Encoding = %q, want deflate", resp.Header().Get("Content-Encoding"))
+	}
+	if body, err := io.ReadAll(flate.NewReader(resp.Body)); err != nil || !strings.Contains(string(body), "user user") {
+		t.Errorf("inflated body %.40q, %v", body, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/json", nil)
+	if resp := serve(router, req); resp.Header().Get("Content-Encoding") != "" {
+		t.Errorf("compressed without Accept-Encoding: %q", resp.Header().Get("Content-Encoding"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if resp := serve(router, req); resp.Header().Get("Content-Encoding") != "" || resp.Body.String() != `{"ok":true}` {
+		t.Errorf("body under COMPRESSION_MIN_SIZE: encoding %q, body %s", resp.Header().Get("Content-Encoding"), resp.Body)
+	}
+}
+
+func TestCompressionSkipsStreamedVideo(t *testing.T) {
+	video := bytes.Repeat([]byte("frame"), 2000)
+	router := newCompressionRouter(t, video)
+
+	req := httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := serve(router, req)
+	if resp.Header().Get("Content-Encoding") != "" || !bytes.Equal(resp.Body.Bytes(), video) {
+		t.Errorf("full video: encoding %q, %d bytes, want the raw %d bytes", resp.Header().Get("Content-Encoding"), resp.Body.Len(), len(video))
+	}
+	if resp.Header().Get("Content-Length") != "10000" {
+		t.Errorf("Content-Length = %q, want 10000", resp.Header().Get("Content-Length"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/video", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=100-199")
+	resp = serve(router, req)
+	if resp.Code != http.StatusPartialContent || resp.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("range: status %d, encoding %q, want an unencoded 206", resp.Code, resp.Header().Get("Content-Encoding"))
+	}
+	if resp.Header().Get("Content-Range") != "bytes 100-199/10000" || !bytes.Equal(resp.Body.Bytes(), video[100:200]) {
+		t.Errorf("range: Content-Range %q with %d bytes", resp.Header().Get("Content-Range"), resp.Body.Len())
+	}
+}
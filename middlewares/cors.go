@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 
@@ -10,44 +11,100 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// CORSMiddleware handles Cross-Origin Resource Sharing
+// CORSMiddleware handles Cross-Origin Resource Sharing. The allowed origins
+// depend on the request path, so route groups such as the admin API can be
+// locked to other origins than the public API; see CORSAllowedOrigins.
+// Preflight requests are answered here and never reach the handlers.
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		cfg := config.Get()
 
 		// Get the origin from the request
 		origin := c.GetHeader("Origin")
+		allowedOrigins := cfg.CORSAllowedOrigins(c.Request.URL.Path)
 
 		// Check if origin is allowed
-		if isOriginAllowed(origin, cfg.CORS.AllowedOrigins) {
-			c.Header("Access-Control-Allow-Origin", origin)
-		} else if contains(cfg.CORS.AllowedOrigins, "*") {
-			c.Header("Access-Control-Allow-Origin", "*")
-		}
-
-		// Set other CORS headers
-		c.Header("Access-Control-Allow-Methods", strings.Join(cfg.CORS.AllowedMethods, ", "))
-		c.Header("Access-Control-Allow-Headers", strings.Join(cfg.CORS.AllowedHeaders, ", "))
-		c.Header("Access-Control-Expose-Headers", strings.Join(cfg.CORS.ExposedHeaders, ", "))
-
-		if cfg.CORS.AllowCredentials {
-			c.Header("Access-Control-Allow-Credentials", "true")
-		}
-
-		if cfg.CORS.MaxAge > 0 {
-			c.Header("Access-Control-Max-Age", fmt.Sprintf("%d", cfg.CORS.MaxAge))
+		allowOrigin := ""
+		if isOriginAllowed(origin, allowedOrigins) {
+			allowOrigin = origin
+		} else if contains(allowedOrigins, "*") {
+			allowOrigin = "*"
 		}
 
 		// Handle preflight requests
 		if c.Request.Method == http.MethodOptions {
-			c.AbortWithStatus(http.StatusNoContent)
+			handlePreflight(c, cfg.CORS, allowOrigin)
 			return
 		}
 
+		if allowOrigin != "" {
+			c.Header("Access-Control-Allow-Origin", allowOrigin)
+			c.Header("Access-Control-Expose-Headers", strings.Join(cfg.CORS.ExposedHeaders, ", "))
+			if cfg.CORS.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
 		c.Next()
 	}
 }
 
+// handlePreflight answers a preflight request with 204. The CORS headers,
+// including the cacheable Access-Control-Max-Age, are only sent when the
+// origin is allowed; a requested method or header that is not configured
+// gets 403 without them.
+func handlePreflight(c *gin.Context, cfg config.CORSConfig, allowOrigin string) {
+	c.Header("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	if allowOrigin == "" {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	method := c.GetHeader("Access-Control-Request-Method")
+	if method != "" && !containsFold(cfg.AllowedMethods, method) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	requested := splitHeaderList(c.GetHeader("Access-Control-Request-Headers"))
+	if !contains(cfg.AllowedHeaders, "*") {
+		for _, header := range requested {
+			if !containsFold(cfg.AllowedHeaders, header) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	c.Header("Access-Control-Allow-Origin", allowOrigin)
+	c.Header("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		c.Header("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	} else {
+		c.Header("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+	}
+	if cfg.AllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+	if cfg.MaxAge > 0 {
+		c.Header("Access-Control-Max-Age", fmt.Sprintf("%d", cfg.MaxAge))
+	}
+
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// splitHeaderList splits a comma-separated header value, dropping empty items
+func splitHeaderList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // isOriginAllowed checks if the origin is in the allowed list
 func isOriginAllowed(origin string, allowedOrigins []string) bool {
 	for _, allowed := range allowedOrigins {
@@ -78,39 +135,107 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
+// containsFold checks if a slice contains a string, ignoring case
+func containsFold(slice []string, item string) bool {
+	for _, s := range slice {
+		if strings.EqualFold(s, item) {
+			return true
+		}
+	}
+	return false
+}
+
 // SecureHeadersMiddleware adds security headers to responses
 func SecureHeadersMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		cfg := config.Get().Security
+
 		// Prevent MIME type sniffing
 		c.Header("X-Content-Type-Options", "nosniff")
 
 		// Prevent clickjacking
-		c.Header("X-Frame-Options", "DENY")
-
-		// Enable XSS protection
-		c.Header("X-XSS-Protection", "1; mode=block")
+		if cfg.FrameOptions != "" {
+			c.Header("X-Frame-Options", cfg.FrameOptions)
+		}
 
 		// Referrer policy
-		c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
+		if cfg.ReferrerPolicy != "" {
+			c.Header("Referrer-Policy", cfg.ReferrerPolicy)
+		}
 
 		// Content Security Policy
-		c.Header("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' 'unsafe-eval'; style-src 'self' 'unsafe-inline';")
-
-		// Strict Transport Security (HSTS)
-		if config.Get().IsProduction() {
-			c.Header("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
+		if cfg.ContentSecurityPolicy != "" {
+			c.Header("Content-Security-Policy", cfg.ContentSecurityPolicy)
 		}
 
-		// Feature Policy
-		c.Header("Feature-Policy", "camera 'none'; microphone 'none'; geolocation 'self';")
+		// Strict Transport Security (HSTS) is only honoured over HTTPS
+		if cfg.HSTSMaxAge > 0 && isHTTPS(c) {
+			c.Header("Strict-Transport-Security", hstsValue(cfg))
+		}
 
 		// Permissions Policy
-		c.Header("Permissions-Policy", "camera=(), microphone=(), geolocation=(self)")
+		if cfg.PermissionsPolicy != "" {
+			c.Header("Permissions-Policy", cfg.PermissionsPolicy)
+		}
+
+		c.Next()
+	}
+}
 
+// ContentSecurityPolicyMiddleware overrides the global CSP for a route group,
+// e.g. Swagger UI which needs inline scripts and styles
+func ContentSecurityPolicyMiddleware(policy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if policy != "" {
+			c.Header("Content-Security-Policy", policy)
+		}
 		c.Next()
 	}
 }
 
+// isHTTPS reports whether the request reached us over TLS. X-Forwarded-Proto
+// is only honoured from TRUSTED_PROXIES, so a direct caller can't claim HTTPS.
+func isHTTPS(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	if !strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
+		return false
+	}
+	return isTrustedProxy(c.RemoteIP(), config.Get().Server.TrustedProxies)
+}
+
+// isTrustedProxy reports whether ip matches one of the TRUSTED_PROXIES
+// entries, each a single IP or a CIDR
+func isTrustedProxy(ip string, proxies []string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// hstsValue builds the Strict-Transport-Security header value
+func hstsValue(cfg config.SecurityConfig) string {
+	value := fmt.Sprintf("max-age=%d", cfg.HSTSMaxAge)
+	if cfg.HSTSIncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if cfg.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
 // NoCacheMiddleware prevents caching of responses
 func NoCacheMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
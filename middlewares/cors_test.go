@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	loadTestConfig(t, nil)
+	router := newTestRouter(SecureHeadersMiddleware())
+	router.GET("/api", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/swagger", ContentSecurityPolicyMiddleware("default-src 'self'"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	plain := serve(router, httptest.NewRequest(http.MethodGet, "/api", nil))
+	want := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         "DENY",
+		"Referrer-Policy":         "strict-origin-when-cross-origin",
+		"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+	}
+	for header, value := range want {
+		if got := plain.Header().Get(header); got != value {
+			t.Errorf("%s = %q, want %q", header, got, value)
+		}
+	}
+	if got := plain.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS sent over plain HTTP: %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.TLS = &tls.ConnectionState{}
+	if got := serve(router, req).Header().Get("Strict-Transport-Security"); got != "max-age=63072000; includeSubDomains" {
+		t.Errorf("HSTS over TLS = %q", got)
+	}
+
+	swagger := serve(router, httptest.NewRequest(http.MethodGet, "/swagger", nil))
+	if got := swagger.Header().Get("Content-Security-Policy"); got != "default-src 'self'" {
+		t.Errorf("per-route CSP = %q, want the override", got)
+	}
+}
+
+func TestForwardedProtoTrustedOnlyFromProxies(t *testing.T) {
+	loadTestConfig(t, map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,192.168.1.5"})
+	router := newTestRouter(SecureHeadersMiddleware())
+	router.GET("/api", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		wantHSTS   bool
+	}{
+		{"trusted proxy network", "10.1.2.3:5000", "https", true},
+		{"trusted proxy address", "192.168.1.5:5000", "HTTPS", true},
+		{"trusted proxy over http", "10.1.2.3:5000", "http", false},
+		{"spoofed by a direct caller", "203.0.113.7:5000", "https", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+
+			got := serve(router, req).Header().Get("Strict-Transport-Security")
+			if (got != "") != tt.wantHSTS {
+				t.Errorf("HSTS = %q, want sent: %v", got, tt.wantHSTS)
+			}
+		})
+	}
+}
+
+func TestSecureHeadersFromConfig(t *testing.T) {
+	loadTestConfig(t, map[string]string{
+		"SECURITY_HSTS_MAX_AGE":            "600",
+		"SECURITY_HSTS_INCLUDE_SUBDOMAINS": "false",
+		"SECURITY_HSTS_PRELOAD":            "true",
+		"SECURITY_CSP":                     "default-src 'self'",
+		"SECURITY_FRAME_OPTIONS":           "SAMEORIGIN",
+		"SECURITY_REFERRER_POLICY":         "no-referrer",
+	})
+	router := newTestRouter(SecureHeadersMiddleware())
+	router.GET("/api", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.TLS = &tls.ConnectionState{}
+	got := serve(router, req).Header()
+
+	want := map[string]string{
+		"Strict-Transport-Security": "max-age=600; preload",
+		"Content-Security-Policy":   "default-src 'self'",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Referrer-Policy":           "no-referrer",
+	}
+	for header, value := range want {
+		if got.Get(header) != value {
+			t.Errorf("%s = %q, want %q", header, got.Get(header), value)
+		}
+	}
+}
+
+// corsRequest sends a request with an Origin header through router
+func corsRequest(router http.Handler, method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Origin", origin)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return serve(router, req)
+}
+
+func TestCORSPolicyPerRouteGroup(t *testing.T) {
+	loadTestConfig(t, map[string]string{
+		"CORS_ALLOWED_ORIGINS": "https://app.example.com",
+		"CORS_ROUTE_ORIGINS":   "/api/v1/admin=https://admin.internal.example.com,/webhooks=https://hooks.example.com",
+	})
+	router := newTestRouter(CORSMiddleware())
+	for _, path := range []string{"/api/v1/users", "/api/v1/admin/users", "/api/v1/administrators", "/webhooks/stripe"} {
+		router.GET(path, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+
+	tests := []struct {
+		path   string
+		origin string
+		want   string
+	}{
+		{"/api/v1/users", "https://app.example.com", "https://app.example.com"},
+		{"/api/v1/users", "https://admin.internal.example.com", ""},
+		{"/api/v1/admin/users", "https://admin.internal.example.com", "https://admin.internal.example.com"},
+		{"/api/v1/admin/users", "https://app.example.com", ""},
+		{"/api/v1/administrators", "https://app.example.com", "https://app.example.com"},
+		{"/webhooks/stripe", "https://hooks.example.com", "https://hooks.example.com"},
+		{"/webhooks/stripe", "https://app.example.com", ""},
+	}
+	for _, tt := range tests {
+		got := corsRequest(router, http.MethodGet, tt.path, tt.origin, nil).Header().Get("Access-Control-Allow-Origin")
+		if got != tt.want {
+			t.Errorf("%s from %s: Access-Control-Allow-Origin = %q, want %q", tt.path, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	loadTestConfig(t, map[string]string{
+		"CORS_ALLOWED_ORIGINS": "https://app.example.com",
+		"CORS_ALLOWED_METHODS": "GET,POST,PATCH",
+		"CORS_ALLOWED_HEADERS": "Content-Type,Authorization",
+		"CORS_MAX_AGE":         "600",
+	})
+	reached := false
+	router := newTestRouter(CORSMiddleware())
+	router.OPTIONS("/api/v1/users", func(c *gin.Context) { reached = true })
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		want    int
+		allowed bool
+	}{
+		{"allowed method and headers", "https://app.example.com", "PATCH", "content-type, Authorization", http.StatusNoContent, true},
+		{"allowed method without headers", "https://app.example.com", "post", "", http.StatusNoContent, true},
+		{"method not allowed", "https://app.example.com", "DELETE", "", http.StatusForbidden, false},
+		{"header not allowed", "https://app.example.com", "GET", "Content-Type, X-Secret", http.StatusForbidden, false},
+		{"origin not allowed", "https://evil.example.net", "GET", "", http.StatusNoContent, false},
+	}
+	for _, tt := range tests {
+		resp := corsRequest(router, http.MethodOptions, "/api/v1/users", tt.origin, map[string]string{
+			"Access-Control-Request-Method":  tt.method,
+			"Access-Control-Request-Headers": tt.headers,
+		})
+		if resp.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.Code, tt.want)
+		}
+
+		header := resp.Header()
+		if !tt.allowed {
+			for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Max-Age"} {
+				if header.Get(name) != "" {
+					t.Errorf("%s: %s = %q, want none", tt.name, name, header.Get(name))
+				}
+			}
+			continue
+		}
+
+		if header.Get("Access-Control-Allow-Origin") != tt.origin || header.Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: allow origin %q, max age %q", tt.name, header.Get("Access-Control-Allow-Origin"), header.Get("Access-Control-Max-Age"))
+		}
+		if header.Get("Access-Control-Allow-Methods") != "GET, POST, PATCH" {
+			t.Errorf("%s: Access-Control-Allow-Methods = %q", tt.name, header.Get("Access-Control-Allow-Methods"))
+		}
+		wantHeaders := "Content-Type, Authorization"
+		if tt.headers != "" {
+			wantHeaders = "content-type, Authorization"
+		}
+		if header.Get("Access-Control-Allow-Headers") != wantHeaders {
+			t.Errorf("%s: Access-Control-Allow-Headers = %q, want %q", tt.name, header.Get("Access-Control-Allow-Headers"), wantHeaders)
+		}
+	}
+
+	if reached {
+		t.Error("a preflight request reached the handler")
+	}
+}
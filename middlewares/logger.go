@@ -2,19 +2,30 @@ package middleware
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"go-api-boilerplate/config"
 	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/pkg/metrics"
 	"go-api-boilerplate/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-// LoggerMiddleware logs HTTP requests
+// LoggerMiddleware logs HTTP requests. Successful requests under
+// LOG_SKIP_PATHS are not logged and those under LOG_SAMPLE_PATHS are logged
+// one in LOG_SAMPLE_RATE times; 4xx and 5xx responses are always logged.
 func LoggerMiddleware() gin.HandlerFunc {
+	cfg := config.Get().Log
+	sampler := newLogSampler(cfg.SamplePaths, cfg.SampleRate)
+
 	return func(c *gin.Context) {
 		// Start timer
 		start := time.Now()
@@ -30,6 +41,10 @@ func LoggerMiddleware() gin.HandlerFunc {
 		// Get status code
 		statusCode := c.Writer.Status()
 
+		if statusCode < 400 && (exemptPath(path, cfg.SkipPaths) || !sampler.keep(path)) {
+			return
+		}
+
 		// Get client IP
 		clientIP := c.ClientIP()
 
@@ -71,6 +86,37 @@ func LoggerMiddleware() gin.HandlerFunc {
 	}
 }
 
+// logSampler keeps one in rate requests for each sampled path prefix
+type logSampler struct {
+	prefixes []string
+	counters []atomic.Uint64
+	rate     uint64
+}
+
+// newLogSampler creates a sampler with a counter per prefix
+func newLogSampler(prefixes []string, rate int) *logSampler {
+	if rate < 1 {
+		rate = 1
+	}
+	return &logSampler{
+		prefixes: prefixes,
+		counters: make([]atomic.Uint64, len(prefixes)),
+		rate:     uint64(rate),
+	}
+}
+
+// keep reports whether a request to path should be logged. Paths outside
+// every prefix are always kept; within a prefix the first request and then
+// every rate-th one are.
+func (s *logSampler) keep(path string) bool {
+	for i, prefix := range s.prefixes {
+		if exemptPath(path, []string{prefix}) {
+			return (s.counters[i].Add(1)-1)%s.rate == 0
+		}
+	}
+	return true
+}
+
 // RequestIDMiddleware adds a unique request ID to each request
 func RequestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -107,8 +153,12 @@ func (r responseBodyWriter) Write(b []byte) (int, error) {
 	return r.ResponseWriter.Write(b)
 }
 
-// BodyLoggerMiddleware logs request and response bodies (use with caution)
+// BodyLoggerMiddleware logs request and response bodies at debug level,
+// with the values of LOG_REDACT_KEYS replaced. Bodies that are not JSON are
+// left out of the log.
 func BodyLoggerMiddleware() gin.HandlerFunc {
+	redactKeys := config.Get().Log.RedactKeys
+
 	return func(c *gin.Context) {
 		// Skip for file uploads and downloads
 		contentType := c.GetHeader("Content-Type")
@@ -137,8 +187,8 @@ func BodyLoggerMiddleware() gin.HandlerFunc {
 		fields := logrus.Fields{
 			"method":        c.Request.Method,
 			"path":          c.Request.URL.Path,
-			"request_body":  maskSensitiveData(string(requestBody)),
-			"response_body": maskSensitiveData(w.body.String()),
+			"request_body":  redactBody(requestBody, redactKeys),
+			"response_body": redactBody(w.body.Bytes(), redactKeys),
 			"status":        c.Writer.Status(),
 		}
 
@@ -146,29 +196,61 @@ func BodyLoggerMiddleware() gin.HandlerFunc {
 	}
 }
 
-// maskSensitiveData masks sensitive information in logs
-func maskSensitiveData(data string) string {
-	// Define sensitive field patterns
-	sensitiveFields := []string{
-		"password",
-		"token",
-		"secret",
-		"authorization",
-		"api_key",
-		"credit_card",
-		"ssn",
+// redactedValue replaces the values of sensitive keys in logged bodies
+const redactedValue = "***"
+
+// redactBody returns a JSON body with the values of sensitive keys
+// replaced, at any depth. Empty bodies log as empty and anything that is
+// not JSON is omitted rather than risk logging secrets.
+func redactBody(body []byte, keys []string) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
 	}
 
-	masked := data
-	for _, field := range sensitiveFields {
-		// Simple masking - in production, use more sophisticated masking
-		if strings.Contains(strings.ToLower(masked), field) {
-			// This is a basic implementation - improve based on your needs
-			masked = strings.ReplaceAll(masked, field, field+"_MASKED")
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil || decoder.More() {
+		return "[non-JSON body omitted]"
+	}
+
+	redacted, err := json.Marshal(redactValue(data, keys))
+	if err != nil {
+		return "[non-JSON body omitted]"
+	}
+	return string(redacted)
+}
+
+// redactValue walks decoded JSON, replacing the values of sensitive keys
+func redactValue(value interface{}, keys []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if isSensitiveKey(key, keys) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactValue(child, keys)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child, keys)
 		}
 	}
+	return value
+}
 
-	return masked
+// isSensitiveKey reports whether key contains one of the sensitive keys,
+// ignoring case
+func isSensitiveKey(key string, keys []string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range keys {
+		if sensitive != "" && strings.Contains(key, strings.ToLower(sensitive)) {
+			return true
+		}
+	}
+	return false
 }
 
 // ErrorLoggerMiddleware logs errors with stack traces
@@ -260,29 +342,41 @@ func AuditLogMiddleware() gin.HandlerFunc {
 	}
 }
 
-// MetricsMiddleware collects metrics for monitoring
-func MetricsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		start := time.Now()
+// unmatchedRoute labels requests that match no registered route
+const unmatchedRoute = "unmatched"
 
-		// Process request
+// knownMethods are the request methods counted under their own name; any
+// other method is counted as OTHER
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true,
+	http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+	http.MethodOptions: true,
+}
+
+// MetricsMiddleware counts requests in http_requests_total by method, route
+// and status. The route is the Gin route template, such as
+// /api/v1/admin/users/:id, so IDs in the path do not each create a series.
+// Requests that match no route are counted under "unmatched", or not at all
+// when dropUnmatched is set.
+func MetricsMiddleware(dropUnmatched bool) gin.HandlerFunc {
+	requests := metrics.NewCounterVec("http_requests_total", "Total HTTP requests by method, route template and status", "method", "route", "status")
+
+	return func(c *gin.Context) {
 		c.Next()
 
-		// Collect metrics
-		duration := time.Since(start)
-		status := c.Writer.Status()
-		path := c.FullPath()
-		method := c.Request.Method
+		route := c.FullPath()
+		if route == "" {
+			if dropUnmatched {
+				return
+			}
+			route = unmatchedRoute
+		}
 
-		// Log metrics (in production, send to metrics system)
-		fields := logrus.Fields{
-			"type":        "metrics",
-			"method":      method,
-			"path":        path,
-			"status":      status,
-			"duration_ms": duration.Milliseconds(),
+		method := c.Request.Method
+		if !knownMethods[method] {
+			method = "OTHER"
 		}
 
-		logger.Get().WithFields(fields).Debug("Request metrics")
+		requests.Inc(method, route, strconv.Itoa(c.Writer.Status()))
 	}
 }
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/pkg/logger"
+)
+
+// captureLog loads the configuration with env and points the JSON logger
+// at a buffer, returning a function that decodes the entries written since
+// the last call
+func captureLog(t *testing.T, env map[string]string) func() []map[string]any {
+	t.Helper()
+
+	env["LOG_FORMAT"] = "json"
+	env["LOG_OUTPUT"] = "stdout"
+	env["APP_DEBUG"] = "false"
+	if err := logger.Init(loadTestConfig(t, env)); err != nil {
+		t.Fatalf("failed to initialise logger: %v", err)
+	}
+	var buf bytes.Buffer
+	logger.Get().SetOutput(&buf)
+	t.Cleanup(func() { logger.Get().SetOutput(os.Stdout) })
+
+	return func() []map[string]any {
+		var entries []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("log line is not JSON: %q", line)
+			}
+			entries = append(entries, entry)
+		}
+		buf.Reset()
+		return entries
+	}
+}
+
+// statusHandler responds with the status given in the ?status query
+func statusHandler(c *gin.Context) {
+	status, _ := strconv.Atoi(c.DefaultQuery("status", "200"))
+	c.Status(status)
+}
+
+func TestLoggerMiddlewareSampling(t *testing.T) {
+	entries := captureLog(t, map[string]string{
+		"LOG_LEVEL":        "info",
+		"LOG_SKIP_PATHS":   "/health,/metrics",
+		"LOG_SAMPLE_PATHS": "/api/v1/ping",
+		"LOG_SAMPLE_RATE":  "3",
+	})
+	router := newTestRouter(LoggerMiddleware())
+	for _, path := range []string{"/health", "/metrics", "/api/v1/ping", "/api/v1/users"} {
+		router.GET(path, statusHandler)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		requests int
+		want     int
+	}{
+		{"health check", "/health", 200, 5, 0},
+		{"metrics scrape", "/metrics", 200, 5, 0},
+		{"failing health check", "/health", 503, 3, 3},
+		{"sampled path", "/api/v1/ping", 200, 7, 3},
+		{"client error on a sampled path", "/api/v1/ping", 404, 4, 4},
+		{"server error on a sampled path", "/api/v1/ping", 500, 4, 4},
+		{"unsampled path", "/api/v1/users", 200, 4, 4},
+	}
+	for _, tt := range tests {
+		for i := 0; i < tt.requests; i++ {
+			serve(router, httptest.NewRequest(http.MethodGet, tt.path+"?status="+strconv.Itoa(tt.status), nil))
+		}
+		if got := len(entries()); got != tt.want {
+			t.Errorf("%s: %d of %d requests logged, want %d", tt.name, got, tt.requests, tt.want)
+		}
+	}
+}
+
+func TestLoggerMiddlewareFields(t *testing.T) {
+	entries := captureLog(t, map[string]string{"LOG_LEVEL": "info"})
+	router := newTestRouter(func(c *gin.Context) {
+		c.Set("user_id", "42")
+		c.Next()
+	}, LoggerMiddleware())
+	router.GET("/api/v1/users", statusHandler)
+
+	tests := []struct {
+		status int
+		level  string
+	}{
+		{200, "info"},
+		{422, "warning"},
+		{502, "error"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users?status="+strconv.Itoa(tt.status), nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		req.Header.Set("User-Agent", "test-agent")
+		serve(router, req)
+
+		logged := entries()
+		if len(logged) != 1 {
+			t.Fatalf("status %d: %d entries logged, want 1", tt.status, len(logged))
+		}
+		entry := logged[0]
+		want := map[string]any{
+			"level":      tt.level,
+			"message":    "HTTP Request",
+			"status":     float64(tt.status),
+			"method":     "GET",
+			"path":       "/api/v1/users",
+			"query":      "status=" + strconv.Itoa(tt.status),
+			"ip":         "203.0.113.7",
+			"user_agent": "test-agent",
+			"user_id":    "42",
+		}
+		for field, value := range want {
+			if entry[field] != value {
+				t.Errorf("status %d: %s = %v, want %v", tt.status, field, entry[field], value)
+			}
+		}
+		for _, field := range []string{"latency", "latency_ms"} {
+			if _, ok := entry[field]; !ok {
+				t.Errorf("status %d: %s missing", tt.status, field)
+			}
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	keys := []string{"password", "token", "secret", "authorization"}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"top-level key", `{"email":"a@example.com","password":"hunter2"}`, `{"email":"a@example.com","password":"***"}`},
+		{"nested object", `{"user":{"name":"ann","credentials":{"Secret":"s","pin":1234}}}`, `{"user":{"credentials":{"Secret":"***","pin":1234},"name":"ann"}}`},
+		{"key containing a sensitive word", `{"access_token":"a","REFRESH_TOKEN":"r"}`, `{"REFRESH_TOKEN":"***","access_token":"***"}`},
+		{"objects in arrays", `{"items":[{"token":"a"},{"id":2}]}`, `{"items":[{"token":"***"},{"id":2}]}`},
+		{"top-level array", `[{"Authorization":"Bearer x"},"password"]`, `[{"Authorization":"***"},"password"]`},
+		{"sensitive object replaced whole", `{"secret":{"id":1}}`, `{"secret":"***"}`},
+		{"sensitive word in a value", `{"note":"my password is safe"}`, `{"note":"my password is safe"}`},
+		{"large numbers kept", `{"id":12345678901234567890}`, `{"id":12345678901234567890}`},
+		{"empty", "  ", ""},
+		{"form body", "password=hunter2&user=ann", "[non-JSON body omitted]"},
+		{"concatenated JSON", `{"a":1}{"password":"x"}`, "[non-JSON body omitted]"},
+	}
+	for _, tt := range tests {
+		if got := redactBody([]byte(tt.body), keys); got != tt.want {
+			t.Errorf("%s: redactBody = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBodyLoggerMiddlewareRedacts(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		request      string
+		wantRequest  string
+		wantResponse string
+	}{
+		{
+			name:         "default keys",
+			env:          map[string]string{},
+			request:      `{"user":{"email":"a@example.com","Password":"hunter2"}}`,
+			wantRequest:  `{"user":{"Password":"***","email":"a@example.com"}}`,
+			wantResponse: `{"data":{"access_token":"***","user":{"id":1}}}`,
+		},
+		{
+			name:         "configured keys",
+			env:          map[string]string{"LOG_REDACT_KEYS": "email,id"},
+			request:      `{"user":{"email":"a@example.com","Password":"hunter2"}}`,
+			wantRequest:  `{"user":{"Password":"hunter2","email":"***"}}`,
+			wantResponse: `{"data":{"access_token":"abc","user":{"id":"***"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.env["LOG_LEVEL"] = "debug"
+			entries := captureLog(t, tt.env)
+			router := newTestRouter(BodyLoggerMiddleware())
+			router.POST("/api/v1/auth/login", func(c *gin.Context) {
+				c.Data(http.StatusOK, "application/json", []byte(`{"data":{"access_token":"abc","user":{"id":1}}}`))
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(tt.request))
+			req.Header.Set("Content-Type", "application/json")
+			resp := serve(router, req)
+			if !strings.Contains(resp.Body.String(), `"access_token":"abc"`) {
+				t.Errorf("client received %s, want the response unredacted", resp.Body)
+			}
+
+			logged := entries()
+			if len(logged) != 1 {
+				t.Fatalf("%d entries logged, want 1", len(logged))
+			}
+			if got := logged[0]["request_body"]; got != tt.wantRequest {
+				t.Errorf("request_body = %v, want %s", got, tt.wantRequest)
+			}
+			if got := logged[0]["response_body"]; got != tt.wantResponse {
+				t.Errorf("response_body = %v, want %s", got, tt.wantResponse)
+			}
+		})
+	}
+}
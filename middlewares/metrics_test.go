@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/pkg/metrics"
+)
+
+// requestSeries scrapes the default registry and returns the
+// http_requests_total samples
+func requestSeries(t *testing.T) []string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	var series []string
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if strings.HasPrefix(line, "http_requests_total{") {
+			series = append(series, line)
+		}
+	}
+	return series
+}
+
+func TestMetricsLabelByRouteTemplate(t *testing.T) {
+	tests := []struct {
+		name          string
+		dropUnmatched bool
+		want          []string
+	}{
+		{"unmatched counted", false, []string{
+			`http_requests_total{method="GET",route="/api/v1/users/:id",status="200"} 2`,
+			`http_requests_total{method="GET",route="unmatched",status="404"} 2`,
+		}},
+		{"unmatched dropped", true, []string{
+			`http_requests_total{method="GET",route="/api/v1/users/:id",status="200"} 2`,
+		}},
+	}
+	for _, tt := range tests {
+		router := newTestRouter(MetricsMiddleware(tt.dropUnmatched))
+		router.GET("/api/v1/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		for _, path := range []string{"/api/v1/users/123", "/api/v1/users/456", "/api/v1/missing/1", "/api/v1/missing/2"} {
+			serve(router, httptest.NewRequest(http.MethodGet, path, nil))
+		}
+
+		if got := requestSeries(t); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: series =\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"go-api-boilerplate/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RawResponseMiddleware makes the response helpers of the routes it guards
+// send resources, arrays and error objects without the standard envelope.
+// Register it before AuthMiddleware so authentication errors are raw too.
+func RawResponseMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		utils.SetRawResponse(c)
+		c.Next()
+	}
+}
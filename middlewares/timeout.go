@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimeoutMiddleware bounds how long a request may run. The handlers get a
+// request context that is cancelled after the timeout, so context-aware
+// database and Redis calls stop early; if the handlers have not returned by
+// then the client receives 503 REQUEST_TIMEOUT and anything they write
+// afterwards is discarded. Responses are buffered until the handlers return,
+// so streaming, WebSocket and upload routes must be exempted by passing
+// their path prefixes. A timeout of 0 disables the middleware.
+func TimeoutMiddleware(timeout time.Duration, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 || c.GetHeader("Upgrade") != "" || exemptPath(c.Request.URL.Path, exempt) {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		original := c.Writer
+		w := &timeoutWriter{
+			ResponseWriter: original,
+			header:         original.Header().Clone(),
+			status:         http.StatusOK,
+		}
+		c.Writer = w
+
+		// Read everything the timeout response needs before the handlers run
+		// concurrently with this goroutine
+		raw := utils.IsRawResponse(c)
+		method, path := c.Request.Method, c.Request.URL.Path
+
+		done := make(chan struct{})
+		var recovered interface{}
+		go func() {
+			defer close(done)
+			defer func() {
+				recovered = recover()
+			}()
+			c.Next()
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				logger.Warnf("%s %s timed out after %s", method, path, timeout)
+				w.timeout(raw)
+			}
+			// The handlers still hold the context; wait so it is not reused
+			<-done
+		}
+
+		c.Writer = original
+		if recovered != nil {
+			panic(recovered)
+		}
+		w.commit()
+	}
+}
+
+// StreamDeadlineMiddleware replaces the server write timeout for streaming
+// routes, whose responses can legitimately take much longer than an API
+// call. A timeout of 0 removes the write deadline.
+func StreamDeadlineMiddleware(writeTimeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		if err := rc.SetWriteDeadline(deadline(writeTimeout)); err != nil {
+			logger.Debugf("Failed to set stream write deadline: %v", err)
+		}
+		c.Next()
+	}
+}
+
+// UploadDeadlineMiddleware replaces the server read and write timeouts for
+// upload routes, so a large body can finish on a slow connection. The write
+// deadline is extended too because it starts counting when the request
+// headers are read. A timeout of 0 removes both deadlines.
+func UploadDeadlineMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		d := deadline(timeout)
+		if err := rc.SetReadDeadline(d); err != nil {
+			logger.Debugf("Failed to set upload read deadline: %v", err)
+		}
+		if err := rc.SetWriteDeadline(d); err != nil {
+			logger.Debugf("Failed to set upload write deadline: %v", err)
+		}
+		c.Next()
+	}
+}
+
+// exemptPath reports whether path is one of the prefixes or below one
+func exemptPath(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// timeoutWriter buffers a response so it can be dropped in favour of the
+// timeout response. It is shared by the handler goroutine and the
+// middleware, so every method takes the lock.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mu       sync.Mutex
+	header   http.Header
+	status   int
+	written  bool
+	buf      bytes.Buffer
+	timedOut bool
+}
+
+// Header returns the buffered headers
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader records the status until the response is committed
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow marks the response as written
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written = true
+}
+
+// Write buffers data, failing once the request has timed out
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	return w.buf.Write(data)
+}
+
+// WriteString buffers a string like Write
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Status returns the buffered status
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// Size returns the number of buffered body bytes, or -1 before any write
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.written {
+		return -1
+	}
+	return w.buf.Len()
+}
+
+// Written reports whether the handlers have written a response
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// Flush is a no-op; the response is sent when the handlers return
+func (w *timeoutWriter) Flush() {}
+
+// Hijack is not supported on a buffered response
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+// timeout sends the timeout response and discards later writes
+func (w *timeoutWriter) timeout(raw bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timedOut = true
+
+	info := &utils.ErrorInfo{Code: "REQUEST_TIMEOUT", Message: "Request timed out"}
+	var body interface{} = utils.Response{Success: false, Message: info.Message, Error: info}
+	if raw {
+		body = info
+	}
+	data, _ := json.Marshal(body)
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	w.ResponseWriter.Write(data)
+	w.ResponseWriter.Flush()
+}
+
+// commit copies the buffered response to the real writer unless the
+// timeout response was sent instead
+func (w *timeoutWriter) commit() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+
+	dst := w.ResponseWriter.Header()
+	for key := range dst {
+		delete(dst, key)
+	}
+	for key, values := range w.header {
+		dst[key] = values
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.written {
+		w.ResponseWriter.WriteHeaderNow()
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+}
+
+// deadline converts a timeout into an absolute deadline, where the zero
+// time means no deadline
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
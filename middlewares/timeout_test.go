@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	loadTestConfig(t, nil)
+
+	cancelled := make(chan error, 1)
+	router := newTestRouter(TimeoutMiddleware(50*time.Millisecond, "/api/v1/stream"))
+	router.GET("/slow", func(c *gin.Context) {
+		// Ignores its context, like a call that is not context-aware
+		time.Sleep(300 * time.Millisecond)
+		c.String(http.StatusOK, "late")
+	})
+	router.GET("/query", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		cancelled <- c.Request.Context().Err()
+	})
+	router.GET("/fast", func(c *gin.Context) {
+		c.Header("X-Handler", "fast")
+		c.String(http.StatusCreated, "done")
+	})
+	router.GET("/api/v1/stream/video", func(c *gin.Context) {
+		time.Sleep(100 * time.Millisecond)
+		c.String(http.StatusOK, "streamed")
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	get := func(path string) (*http.Response, string, time.Duration) {
+		t.Helper()
+		start := time.Now()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		// Headers arrive before the handler goroutine finishes
+		elapsed := time.Since(start)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body), elapsed
+	}
+
+	resp, body, elapsed := get("/slow")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("slow handler: status %d, want 503", resp.StatusCode)
+	}
+	if elapsed > 250*time.Millisecond {
+		t.Errorf("timeout response took %v, want about 50ms", elapsed)
+	}
+	if want := `{"success":false,"message":"Request timed out","error":{"code":"REQUEST_TIMEOUT","message":"Request timed out"}}`; body != want {
+		t.Errorf("slow handler body = %s, want only the timeout response", body)
+	}
+
+	if resp, _, _ := get("/query"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("context-aware handler: status %d, want 503", resp.StatusCode)
+	}
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("handler context error = %v, want DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("the handler's context was not cancelled")
+	}
+
+	resp, body, _ = get("/fast")
+	if resp.StatusCode != http.StatusCreated || body != "done" || resp.Header.Get("X-Handler") != "fast" {
+		t.Errorf("fast handler: status %d, body %q, header %q", resp.StatusCode, body, resp.Header.Get("X-Handler"))
+	}
+
+	resp, body, _ = get("/api/v1/stream/video")
+	if resp.StatusCode != http.StatusOK || body != "streamed" {
+		t.Errorf("exempt route: status %d, body %q, want 200 streamed", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"go-api-boilerplate/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// TracingMiddleware starts a server span for each request via otelgin,
+// continuing any incoming W3C trace context, and exposes the trace ID in
+// the response. Only register it when tracing is enabled.
+func TracingMiddleware(serviceName string) gin.HandlersChain {
+	return gin.HandlersChain{
+		otelgin.Middleware(serviceName),
+		traceIDMiddleware(),
+	}
+}
+
+// traceIDMiddleware exposes the active trace ID for debugging and log correlation
+func traceIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if traceID := tracing.TraceID(c.Request.Context()); traceID != "" {
+			c.Set("trace_id", traceID)
+			c.Header(tracing.TraceIDHeader, traceID)
+		}
+		c.Next()
+	}
+}
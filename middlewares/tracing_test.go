@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-api-boilerplate/pkg/tracing"
+)
+
+// recordSpans installs a global tracer provider that records finished spans
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	return recorder
+}
+
+func TestTracingMiddlewareContinuesIncomingTrace(t *testing.T) {
+	spans := recordSpans(t)
+
+	router := newTestRouter(TracingMiddleware("api-test")...)
+	router.GET("/api/v1/users/:id", func(c *gin.Context) {
+		// Stands in for an instrumented database or Redis call
+		_, child := otel.Tracer("test").Start(c.Request.Context(), "query")
+		child.End()
+
+		c.String(http.StatusOK, c.GetString("trace_id"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := serve(router, req)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	if got := w.Header().Get(tracing.TraceIDHeader); got != traceID {
+		t.Errorf("%s = %q, want %q", tracing.TraceIDHeader, got, traceID)
+	}
+	if got := w.Body.String(); got != traceID {
+		t.Errorf("trace_id in context = %q, want %q", got, traceID)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(ended))
+	}
+	child, server := ended[0], ended[1]
+
+	if server.Name() != "/api/v1/users/:id" {
+		t.Errorf("server span name = %q, want the route template", server.Name())
+	}
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span kind = %v, want server", server.SpanKind())
+	}
+	if got := server.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("server span parent = %s, want the incoming span", got)
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("handler span is not a child of the server span")
+	}
+}
+
+func TestTracingMiddlewareStartsNewTrace(t *testing.T) {
+	spans := recordSpans(t)
+
+	router := newTestRouter(TracingMiddleware("api-test")...)
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := serve(router, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(ended))
+	}
+	if ended[0].Parent().IsValid() {
+		t.Error("span without an incoming traceparent has a parent")
+	}
+	if got, want := w.Header().Get(tracing.TraceIDHeader), ended[0].SpanContext().TraceID().String(); got != want {
+		t.Errorf("%s = %q, want %q", tracing.TraceIDHeader, got, want)
+	}
+}
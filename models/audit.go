@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// Audit actions
+const (
+	AuditActionUserRoleChanged   = "user.role_changed"
+	AuditActionUserStatusChanged = "user.status_changed"
+	AuditActionUserDeleted       = "user.deleted"
+	AuditActionUserRestored      = "user.restored"
+	AuditActionUserForceDeleted  = "user.force_deleted"
+)
+
+// AuditLog records an administrative change with its before and after values
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey" json:"id" bson:"_id"`
+	ActorID    uint      `gorm:"not null;index" json:"actor_id" bson:"actor_id"`
+	Action     string    `gorm:"not null;index" json:"action" bson:"action"`
+	TargetType string    `gorm:"not null" json:"target_type" bson:"target_type"`
+	TargetID   uint      `gorm:"not null;index" json:"target_id" bson:"target_id"`
+	OldValue   string    `json:"old_value" bson:"old_value"`
+	NewValue   string    `json:"new_value" bson:"new_value"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
+// TableName specifies the table name for the AuditLog model
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// StoredFile tracks an uploaded file, who uploaded it and, with
+// deduplication, how many of their uploads share it
+type StoredFile struct {
+	ID   uint   `gorm:"primarykey" json:"id"`
+	Hash string `gorm:"index:idx_stored_files_owner_hash,priority:2;size:64;not null" json:"hash"`
+	Path string `gorm:"index;not null" json:"path"`
+	// UserID is the uploader; only they and admins may download the file
+	// unless it is Public
+	UserID   uint   `gorm:"index:idx_stored_files_owner_hash,priority:1;not null;default:0" json:"user_id"`
+	Public   bool   `gorm:"not null;default:false" json:"public"`
+	Filename string `gorm:"not null" json:"filename"`
+	// OriginalName is the sanitized client filename, sent back when the
+	// file is downloaded
+	OriginalName string    `json:"original_name"`
+	Size         int64     `json:"size"`
+	MimeType     string    `json:"mime_type"`
+	Extension    string    `json:"extension"`
+	RefCount     int       `gorm:"not null;default:1" json:"ref_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for the StoredFile model
+func (StoredFile) TableName() string {
+	return "stored_files"
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// JSONFormat selects how response DTOs encode timestamps and IDs. The zero
+// value keeps encoding/json's defaults: RFC 3339 times and numeric IDs.
+type JSONFormat struct {
+	// EpochMillis encodes timestamps as milliseconds since the Unix epoch
+	EpochMillis bool
+	// StringIDs encodes IDs as strings, which JavaScript clients can hold
+	// without losing precision above 2^53
+	StringIDs bool
+}
+
+var jsonFormat atomic.Pointer[JSONFormat]
+
+// SetJSONFormat sets the encoding used by response DTOs. Call it once at
+// startup, before responses are written.
+func SetJSONFormat(format JSONFormat) {
+	jsonFormat.Store(&format)
+}
+
+// currentJSONFormat returns the configured encoding
+func currentJSONFormat() JSONFormat {
+	if format := jsonFormat.Load(); format != nil {
+		return *format
+	}
+	return JSONFormat{}
+}
+
+// jsonID encodes an ID in the configured format
+func (f JSONFormat) jsonID(id uint64) any {
+	if f.StringIDs {
+		return strconv.FormatUint(id, 10)
+	}
+	return id
+}
+
+// jsonIDs encodes a list of IDs in the configured format
+func (f JSONFormat) jsonIDs(ids []uint) any {
+	if !f.StringIDs || ids == nil {
+		return ids
+	}
+	encoded := make([]string, len(ids))
+	for i, id := range ids {
+		encoded[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return encoded
+}
+
+// jsonTime encodes a timestamp in the configured format
+func (f JSONFormat) jsonTime(t time.Time) any {
+	if f.EpochMillis {
+		return t.UnixMilli()
+	}
+	return t
+}
+
+// jsonOptionalTime encodes an optional timestamp, returning nil so that
+// omitempty drops it when unset
+func (f JSONFormat) jsonOptionalTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return f.jsonTime(*t)
+}
+
+// flexibleID decodes an ID written in either format
+type flexibleID uint
+
+func (id *flexibleID) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+	value, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %s: %w", data, err)
+	}
+	*id = flexibleID(value)
+	return nil
+}
+
+// flexibleTime decodes a timestamp written in either format
+type flexibleTime time.Time
+
+func (t *flexibleTime) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] != '"' {
+		millis, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %s: %w", data, err)
+		}
+		*t = flexibleTime(time.UnixMilli(millis).UTC())
+		return nil
+	}
+	var value time.Time
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*t = flexibleTime(value)
+	return nil
+}
+
+// optionalTime converts a decoded optional timestamp
+func (t *flexibleTime) optionalTime() *time.Time {
+	if t == nil {
+		return nil
+	}
+	value := time.Time(*t)
+	return &value
+}
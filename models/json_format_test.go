@@ -0,0 +1,134 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// useJSONFormat sets the response encoding for the rest of the test
+func useJSONFormat(t *testing.T, format JSONFormat) {
+	t.Helper()
+
+	SetJSONFormat(format)
+	t.Cleanup(func() { SetJSONFormat(JSONFormat{}) })
+}
+
+// testUserResponse has an ID above 2^53 and every timestamp set
+func testUserResponse() *UserResponse {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	verified := created.Add(time.Hour)
+	return &UserResponse{
+		ID:              1<<53 + 1,
+		Email:           "a@example.com",
+		Name:            "Ann",
+		Role:            RoleUser,
+		IsActive:        true,
+		EmailVerified:   true,
+		EmailVerifiedAt: &verified,
+		CreatedAt:       created,
+		UpdatedAt:       created.Add(2 * time.Hour),
+	}
+}
+
+func TestUserResponseJSONFormat(t *testing.T) {
+	user := testUserResponse()
+	createdMillis := float64(user.CreatedAt.UnixMilli())
+
+	tests := []struct {
+		name        string
+		format      JSONFormat
+		wantID      any
+		wantCreated any
+	}{
+		{"defaults", JSONFormat{}, float64(user.ID), "2024-03-01T12:00:00.123456789Z"},
+		{"epoch millis", JSONFormat{EpochMillis: true}, float64(user.ID), createdMillis},
+		{"string IDs", JSONFormat{StringIDs: true}, "9007199254740993", "2024-03-01T12:00:00.123456789Z"},
+		{"both", JSONFormat{EpochMillis: true, StringIDs: true}, "9007199254740993", createdMillis},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useJSONFormat(t, tt.format)
+
+			data, err := json.Marshal(user)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if fields["id"] != tt.wantID {
+				t.Errorf("id = %#v, want %#v", fields["id"], tt.wantID)
+			}
+			if fields["created_at"] != tt.wantCreated {
+				t.Errorf("created_at = %#v, want %#v", fields["created_at"], tt.wantCreated)
+			}
+			if _, ok := fields["last_login_at"]; ok {
+				t.Errorf("unset last_login_at encoded as %#v", fields["last_login_at"])
+			}
+			if fields["email"] != user.Email || fields["is_active"] != true || fields["email_verified_at"] == nil {
+				t.Errorf("other fields = %s", data)
+			}
+
+			// Either format decodes back, to the millisecond when times are
+			// sent as numbers
+			var decoded UserResponse
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("decoding %s: %v", data, err)
+			}
+			precision := time.Nanosecond
+			if tt.format.EpochMillis {
+				precision = time.Millisecond
+			}
+			if decoded.ID != user.ID || !decoded.CreatedAt.Equal(user.CreatedAt.Truncate(precision)) ||
+				!decoded.EmailVerifiedAt.Equal(user.EmailVerifiedAt.Truncate(precision)) || decoded.LastLoginAt != nil {
+				t.Errorf("decoded %+v, want %+v", decoded, user)
+			}
+		})
+	}
+}
+
+func TestDefaultJSONFormatMatchesPlainEncoding(t *testing.T) {
+	useJSONFormat(t, JSONFormat{})
+	user := testUserResponse()
+
+	type plain UserResponse
+	want, _ := json.Marshal(plain(*user))
+	got, err := json.Marshal(user)
+	if err != nil || string(got) != string(want) {
+		t.Errorf("Marshal = %s, %v, want %s", got, err, want)
+	}
+}
+
+func TestBatchGetUsersResponseJSONFormat(t *testing.T) {
+	response := BatchGetUsersResponse{Users: []*UserResponse{}, Missing: []uint{3, 1 << 53}}
+
+	tests := []struct {
+		format JSONFormat
+		want   string
+	}{
+		{JSONFormat{}, `{"users":[],"missing":[3,9007199254740992]}`},
+		{JSONFormat{StringIDs: true}, `{"users":[],"missing":["3","9007199254740992"]}`},
+	}
+	for _, tt := range tests {
+		useJSONFormat(t, tt.format)
+		if got, err := json.Marshal(response); err != nil || string(got) != tt.want {
+			t.Errorf("%+v: Marshal = %s, %v, want %s", tt.format, got, err, tt.want)
+		}
+	}
+}
+
+func TestUserResponseRejectsInvalidFields(t *testing.T) {
+	for _, data := range []string{
+		`{"id": "abc", "created_at": 0, "updated_at": 0}`,
+		`{"id": -1, "created_at": 0, "updated_at": 0}`,
+		`{"id": 1, "created_at": 1.5, "updated_at": 0}`,
+		`{"id": 1, "created_at": "yesterday", "updated_at": 0}`,
+	} {
+		var decoded UserResponse
+		if err := json.Unmarshal([]byte(data), &decoded); err == nil {
+			t.Errorf("Unmarshal(%s) = %+v, want an error", data, decoded)
+		}
+	}
+}
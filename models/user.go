@@ -1,46 +1,91 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gorm.io/gorm"
 )
 
-// User represents a user in the system
+// User represents a user in the system. On MongoDB the uint ID is stored as
+// _id, assigned from the counters collection by the repository.
 type User struct {
-	ID              uint           `gorm:"primarykey" json:"id"`
-	Email           string         `gorm:"uniqueIndex;not null" json:"email"`
-	Password        string         `gorm:"not null" json:"-"`
-	Name            string         `gorm:"not null" json:"name"`
-	Avatar          string         `json:"avatar,omitempty"`
-	Role            string         `gorm:"default:'user'" json:"role"`
-	IsActive        bool           `gorm:"default:true" json:"is_active"`
-	EmailVerified   bool           `gorm:"default:false" json:"email_verified"`
-	EmailVerifiedAt *time.Time     `json:"email_verified_at,omitempty"`
-	LastLoginAt     *time.Time     `json:"last_login_at,omitempty"`
-	RefreshToken    string         `json:"-"`
-	CreatedAt       time.Time      `json:"created_at"`
-	UpdatedAt       time.Time      `json:"updated_at"`
-	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+	ID               uint           `gorm:"primarykey" json:"id" bson:"_id"`
+	Email            string         `gorm:"uniqueIndex;not null" json:"email" bson:"email"`
+	Password         string         `gorm:"not null" json:"-" bson:"password"`
+	Name             string         `gorm:"not null" json:"name" bson:"name"`
+	Avatar           string         `json:"avatar,omitempty" bson:"avatar"`
+	AvatarVariants   AvatarVariants `gorm:"type:text" json:"avatar_variants,omitempty" bson:"avatar_variants"`
+	Role             string         `gorm:"default:'user'" json:"role" bson:"role"`
+	IsActive         bool           `gorm:"default:true" json:"is_active" bson:"is_active"`
+	EmailVerified    bool           `gorm:"default:false" json:"email_verified" bson:"email_verified"`
+	EmailVerifiedAt  *time.Time     `json:"email_verified_at,omitempty" bson:"email_verified_at"`
+	LastLoginAt      *time.Time     `json:"last_login_at,omitempty" bson:"last_login_at"`
+	RefreshToken     string         `json:"-" bson:"refresh_token"`
+	TokensValidAfter *time.Time     `json:"-" bson:"tokens_valid_after"`
+	CreatedAt        time.Time      `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at" bson:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-" bson:"deleted_at"`
+}
+
+// AvatarVariants maps UPLOAD_AVATAR_SIZES names to the URLs of the resized
+// copies of an avatar. It is stored as a JSON column, NULL when empty.
+type AvatarVariants map[string]string
+
+// Value implements driver.Valuer
+func (v AvatarVariants) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(map[string]string(v))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (v *AvatarVariants) Scan(value any) error {
+	var data []byte
+	switch value := value.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	default:
+		return fmt.Errorf("cannot scan %T into AvatarVariants", value)
+	}
+	if len(data) == 0 {
+		*v = nil
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]string)(v))
 }
 
 // UserMongo represents a user in MongoDB
 type UserMongo struct {
-	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Email           string             `bson:"email" json:"email"`
-	Password        string             `bson:"password" json:"-"`
-	Name            string             `bson:"name" json:"name"`
-	Avatar          string             `bson:"avatar,omitempty" json:"avatar,omitempty"`
-	Role            string             `bson:"role" json:"role"`
-	IsActive        bool               `bson:"is_active" json:"is_active"`
-	EmailVerified   bool               `bson:"email_verified" json:"email_verified"`
-	EmailVerifiedAt *time.Time         `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
-	LastLoginAt     *time.Time         `bson:"last_login_at,omitempty" json:"last_login_at,omitempty"`
-	RefreshToken    string             `bson:"refresh_token,omitempty" json:"-"`
-	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
-	DeletedAt       *time.Time         `bson:"deleted_at,omitempty" json:"-"`
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email            string             `bson:"email" json:"email"`
+	Password         string             `bson:"password" json:"-"`
+	Name             string             `bson:"name" json:"name"`
+	Avatar           string             `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	AvatarVariants   AvatarVariants     `bson:"avatar_variants,omitempty" json:"avatar_variants,omitempty"`
+	Role             string             `bson:"role" json:"role"`
+	IsActive         bool               `bson:"is_active" json:"is_active"`
+	EmailVerified    bool               `bson:"email_verified" json:"email_verified"`
+	EmailVerifiedAt  *time.Time         `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+	LastLoginAt      *time.Time         `bson:"last_login_at,omitempty" json:"last_login_at,omitempty"`
+	RefreshToken     string             `bson:"refresh_token,omitempty" json:"-"`
+	TokensValidAfter *time.Time         `bson:"tokens_valid_after,omitempty" json:"-"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+	DeletedAt        *time.Time         `bson:"deleted_at,omitempty" json:"-"`
 }
 
 // TableName specifies the table name for the User model
@@ -68,6 +113,15 @@ const (
 	RoleUser      = "user"
 )
 
+// IsValidRole reports whether role is one of the known role constants
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleModerator, RoleUser:
+		return true
+	}
+	return false
+}
+
 // CreateUserInput represents the input for creating a user
 type CreateUserInput struct {
 	Email    string `json:"email" binding:"required,email"`
@@ -85,6 +139,74 @@ type UpdateUserInput struct {
 	EmailVerified *bool  `json:"email_verified,omitempty"`
 }
 
+// PatchProfileInput represents a partial update of the caller's own
+// profile. Omitted or null fields are left unchanged and an empty avatar
+// clears it; other user fields cannot be changed this way.
+type PatchProfileInput struct {
+	Name   *string `json:"name" binding:"omitempty,min=2,max=100"`
+	Avatar *string `json:"avatar" binding:"omitempty,max=500"`
+}
+
+// UpdateUserRoleInput represents the input for changing a user's role. The
+// role is checked with IsValidRole, so unknown roles are a 400 like in the
+// other create and update paths.
+type UpdateUserRoleInput struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// UpdateUserStatusInput represents the input for activating or deactivating a user
+type UpdateUserStatusInput struct {
+	IsActive *bool `json:"is_active" binding:"required"`
+}
+
+// BatchGetUsersInput represents the input for fetching several users at once
+type BatchGetUsersInput struct {
+	IDs []uint `json:"ids" binding:"required,min=1,dive,gt=0"`
+}
+
+// BatchGetUsersResponse returns users in request order and the IDs with no user
+type BatchGetUsersResponse struct {
+	Users   []*UserResponse `json:"users"`
+	Missing []uint          `json:"missing"`
+}
+
+// MarshalJSON encodes the missing IDs in the format set by SetJSONFormat
+func (r BatchGetUsersResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Users   []*UserResponse `json:"users"`
+		Missing any             `json:"missing"`
+	}{
+		Users:   r.Users,
+		Missing: currentJSONFormat().jsonIDs(r.Missing),
+	})
+}
+
+// Import row statuses
+const (
+	ImportStatusCreated          = "created"
+	ImportStatusSkippedDuplicate = "skipped_duplicate"
+	ImportStatusError            = "error"
+)
+
+// UserImportRowResult reports what happened to one row of a user import.
+// Row counts from 1 at the first line after the header.
+type UserImportRowResult struct {
+	Row               int    `json:"row"`
+	Email             string `json:"email"`
+	Status            string `json:"status"`
+	UserID            uint   `json:"user_id,omitempty"`
+	PasswordGenerated bool   `json:"password_generated,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// UserImportResult summarizes a user import
+type UserImportResult struct {
+	Created int                   `json:"created"`
+	Skipped int                   `json:"skipped"`
+	Failed  int                   `json:"failed"`
+	Rows    []UserImportRowResult `json:"rows"`
+}
+
 // LoginInput represents the input for user login
 type LoginInput struct {
 	Email    string `json:"email" binding:"required,email"`
@@ -106,24 +228,89 @@ type ChangePasswordInput struct {
 	ConfirmNewPassword string `json:"confirm_new_password" binding:"required,eqfield=NewPassword"`
 }
 
-// RefreshTokenInput represents the input for refreshing tokens
+// RefreshTokenInput represents the input for refreshing tokens. The token
+// may be omitted when it is sent in the refresh cookie instead.
 type RefreshTokenInput struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 // UserResponse represents the user response structure
 type UserResponse struct {
-	ID              uint       `json:"id"`
-	Email           string     `json:"email"`
-	Name            string     `json:"name"`
-	Avatar          string     `json:"avatar,omitempty"`
-	Role            string     `json:"role"`
-	IsActive        bool       `json:"is_active"`
-	EmailVerified   bool       `json:"email_verified"`
-	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
-	LastLoginAt     *time.Time `json:"last_login_at,omitempty"`
-	CreatedAt       time.Time  `json:"created_at"`
-	UpdatedAt       time.Time  `json:"updated_at"`
+	ID              uint              `json:"id"`
+	Email           string            `json:"email"`
+	Name            string            `json:"name"`
+	Avatar          string            `json:"avatar,omitempty"`
+	AvatarVariants  map[string]string `json:"avatar_variants,omitempty"`
+	Role            string            `json:"role"`
+	IsActive        bool              `json:"is_active"`
+	EmailVerified   bool              `json:"email_verified"`
+	EmailVerifiedAt *time.Time        `json:"email_verified_at,omitempty"`
+	LastLoginAt     *time.Time        `json:"last_login_at,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+// MarshalJSON encodes the user with the timestamp and ID format set by
+// SetJSONFormat
+func (r UserResponse) MarshalJSON() ([]byte, error) {
+	type plain UserResponse
+	format := currentJSONFormat()
+	if format == (JSONFormat{}) {
+		return json.Marshal(plain(r))
+	}
+
+	return json.Marshal(struct {
+		ID              any               `json:"id"`
+		Email           string            `json:"email"`
+		Name            string            `json:"name"`
+		Avatar          string            `json:"avatar,omitempty"`
+		AvatarVariants  map[string]string `json:"avatar_variants,omitempty"`
+		Role            string            `json:"role"`
+		IsActive        bool              `json:"is_active"`
+		EmailVerified   bool              `json:"email_verified"`
+		EmailVerifiedAt any               `json:"email_verified_at,omitempty"`
+		LastLoginAt     any               `json:"last_login_at,omitempty"`
+		CreatedAt       any               `json:"created_at"`
+		UpdatedAt       any               `json:"updated_at"`
+	}{
+		ID:              format.jsonID(uint64(r.ID)),
+		Email:           r.Email,
+		Name:            r.Name,
+		Avatar:          r.Avatar,
+		AvatarVariants:  r.AvatarVariants,
+		Role:            r.Role,
+		IsActive:        r.IsActive,
+		EmailVerified:   r.EmailVerified,
+		EmailVerifiedAt: format.jsonOptionalTime(r.EmailVerifiedAt),
+		LastLoginAt:     format.jsonOptionalTime(r.LastLoginAt),
+		CreatedAt:       format.jsonTime(r.CreatedAt),
+		UpdatedAt:       format.jsonTime(r.UpdatedAt),
+	})
+}
+
+// UnmarshalJSON accepts either timestamp and ID format, so users cached
+// under one setting still decode after it changes
+func (r *UserResponse) UnmarshalJSON(data []byte) error {
+	type plain UserResponse
+	var decoded struct {
+		plain
+		ID              flexibleID    `json:"id"`
+		EmailVerifiedAt *flexibleTime `json:"email_verified_at"`
+		LastLoginAt     *flexibleTime `json:"last_login_at"`
+		CreatedAt       flexibleTime  `json:"created_at"`
+		UpdatedAt       flexibleTime  `json:"updated_at"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*r = UserResponse(decoded.plain)
+	r.ID = uint(decoded.ID)
+	r.EmailVerifiedAt = decoded.EmailVerifiedAt.optionalTime()
+	r.LastLoginAt = decoded.LastLoginAt.optionalTime()
+	r.CreatedAt = time.Time(decoded.CreatedAt)
+	r.UpdatedAt = time.Time(decoded.UpdatedAt)
+	return nil
 }
 
 // ToResponse converts User to UserResponse
@@ -133,6 +320,7 @@ func (u *User) ToResponse() *UserResponse {
 		Email:           u.Email,
 		Name:            u.Name,
 		Avatar:          u.Avatar,
+		AvatarVariants:  u.AvatarVariants,
 		Role:            u.Role,
 		IsActive:        u.IsActive,
 		EmailVerified:   u.EmailVerified,
@@ -150,6 +338,7 @@ func (u *UserMongo) ToResponse() *UserResponse {
 		Email:           u.Email,
 		Name:            u.Name,
 		Avatar:          u.Avatar,
+		AvatarVariants:  u.AvatarVariants,
 		Role:            u.Role,
 		IsActive:        u.IsActive,
 		EmailVerified:   u.EmailVerified,
@@ -163,7 +352,7 @@ func (u *UserMongo) ToResponse() *UserResponse {
 // AuthTokens represents the authentication tokens
 type AuthTokens struct {
 	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 	TokenType    string `json:"token_type"`
 	ExpiresIn    int64  `json:"expires_in"`
 }
@@ -186,15 +375,15 @@ type Permission struct {
 
 // Session represents a user session
 type Session struct {
-	ID        uint      `gorm:"primarykey" json:"id"`
-	UserID    uint      `gorm:"not null;index" json:"user_id"`
-	Token     string    `gorm:"uniqueIndex;not null" json:"token"`
-	IPAddress string    `json:"ip_address"`
-	UserAgent string    `json:"user_agent"`
-	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	User      *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	ID        uint      `gorm:"primarykey" json:"id" bson:"_id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id" bson:"user_id"`
+	Token     string    `gorm:"uniqueIndex;not null" json:"token" bson:"token"`
+	IPAddress string    `json:"ip_address" bson:"ip_address"`
+	UserAgent string    `json:"user_agent" bson:"user_agent"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at" bson:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+	User      *User     `gorm:"foreignKey:UserID" json:"user,omitempty" bson:"-"`
 }
 
 // IsExpired checks if the session is expired
@@ -202,15 +391,16 @@ func (s *Session) IsExpired() bool {
 	return time.Now().After(s.ExpiresAt)
 }
 
-// PasswordReset represents a password reset request
+// PasswordReset represents a password reset request. Token holds the
+// SHA-256 hash of the token sent to the user.
 type PasswordReset struct {
-	ID        uint       `gorm:"primarykey" json:"id"`
-	UserID    uint       `gorm:"not null;index" json:"user_id"`
-	Token     string     `gorm:"uniqueIndex;not null" json:"token"`
-	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
-	UsedAt    *time.Time `json:"used_at,omitempty"`
-	CreatedAt time.Time  `json:"created_at"`
-	User      *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	ID        uint       `gorm:"primarykey" json:"id" bson:"_id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id" bson:"user_id"`
+	Token     string     `gorm:"uniqueIndex;not null" json:"-" bson:"token"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" bson:"used_at"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	User      *User      `gorm:"foreignKey:UserID" json:"user,omitempty" bson:"-"`
 }
 
 // IsExpired checks if the password reset token is expired
@@ -222,3 +412,25 @@ func (pr *PasswordReset) IsExpired() bool {
 func (pr *PasswordReset) IsUsed() bool {
 	return pr.UsedAt != nil
 }
+
+// EmailVerification represents an email verification request. Token holds
+// the SHA-256 hash of the token sent to the user.
+type EmailVerification struct {
+	ID        uint       `gorm:"primarykey" json:"id" bson:"_id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id" bson:"user_id"`
+	Token     string     `gorm:"uniqueIndex;not null" json:"-" bson:"token"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at" bson:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" bson:"used_at"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	User      *User      `gorm:"foreignKey:UserID" json:"user,omitempty" bson:"-"`
+}
+
+// IsExpired checks if the verification token is expired
+func (ev *EmailVerification) IsExpired() bool {
+	return time.Now().After(ev.ExpiresAt)
+}
+
+// IsUsed checks if the verification token has been used
+func (ev *EmailVerification) IsUsed() bool {
+	return ev.UsedAt != nil
+}
@@ -0,0 +1,28 @@
+package diskspace
+
+import "errors"
+
+// ErrUnsupported is returned by Stat on platforms where disk usage cannot be
+// read
+var ErrUnsupported = errors.New("disk usage is not supported on this platform")
+
+// Usage describes the space on the volume holding a path
+type Usage struct {
+	TotalBytes uint64 `json:"total_bytes"`
+	// FreeBytes is the space available to unprivileged users, which excludes
+	// blocks reserved for root
+	FreeBytes uint64 `json:"free_bytes"`
+}
+
+// FreePercent returns the free space as a percentage of the volume size
+func (u Usage) FreePercent() float64 {
+	if u.TotalBytes == 0 {
+		return 0
+	}
+	return float64(u.FreeBytes) / float64(u.TotalBytes) * 100
+}
+
+// Stat reports the usage of the volume holding path
+func Stat(path string) (Usage, error) {
+	return stat(path)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package diskspace
+
+func stat(string) (Usage, error) {
+	return Usage{}, ErrUnsupported
+}
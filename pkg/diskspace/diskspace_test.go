@@ -0,0 +1,41 @@
+package diskspace
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFreePercent(t *testing.T) {
+	tests := []struct {
+		usage Usage
+		want  float64
+	}{
+		{Usage{TotalBytes: 200, FreeBytes: 50}, 25},
+		{Usage{TotalBytes: 200, FreeBytes: 200}, 100},
+		{Usage{TotalBytes: 200}, 0},
+		{Usage{}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.usage.FreePercent(); got != tt.want {
+			t.Errorf("%+v.FreePercent() = %v, want %v", tt.usage, got, tt.want)
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	usage, err := Stat(t.TempDir())
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if usage.TotalBytes == 0 || usage.FreeBytes > usage.TotalBytes {
+		t.Errorf("Stat = %+v, want free space within a non-empty volume", usage)
+	}
+
+	if _, err := Stat(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Stat of a missing path succeeded")
+	}
+}
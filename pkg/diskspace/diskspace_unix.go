@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package diskspace
+
+import "golang.org/x/sys/unix"
+
+func stat(path string) (Usage, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return Usage{}, err
+	}
+
+	bsize := uint64(fs.Bsize)
+	return Usage{
+		TotalBytes: uint64(fs.Blocks) * bsize,
+		FreeBytes:  uint64(fs.Bavail) * bsize,
+	}, nil
+}
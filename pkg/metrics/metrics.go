@@ -0,0 +1,338 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the Prometheus text exposition format content type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector is a metric that can be written in the Prometheus text format
+type Collector interface {
+	Name() string
+	Write(w io.Writer)
+}
+
+// Registry holds the collectors exposed by the metrics endpoint
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+var defaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]Collector)}
+}
+
+// Register adds a collector, replacing any collector with the same name
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[c.Name()] = c
+}
+
+// Write writes all collectors sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := make([]Collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	for _, c := range collectors {
+		c.Write(w)
+	}
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.Write(w)
+	})
+}
+
+// Register adds a collector to the default registry
+func Register(c Collector) {
+	defaultRegistry.Register(c)
+}
+
+// Handler serves the default registry
+func Handler() http.Handler {
+	return defaultRegistry.Handler()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	name string
+	help string
+	bits uint64
+}
+
+// NewGauge creates and registers a gauge
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	Register(g)
+	return g
+}
+
+// Name returns the metric name
+func (g *Gauge) Name() string { return g.name }
+
+// Set sets the gauge value
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Add adds delta to the gauge value
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, old, next) {
+			return
+		}
+	}
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Write writes the gauge in the text format
+func (g *Gauge) Write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, nil, g.Value())
+}
+
+// GaugeFunc is a gauge whose value is read from a function at scrape time
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge backed by fn
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	Register(g)
+	return g
+}
+
+// Name returns the metric name
+func (g *GaugeFunc) Name() string { return g.name }
+
+// Write writes the gauge in the text format
+func (g *GaugeFunc) Write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, nil, g.fn())
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name  string
+	help  string
+	value uint64
+}
+
+// NewCounter creates and registers a counter
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	Register(c)
+	return c
+}
+
+// Name returns the metric name
+func (c *Counter) Name() string { return c.name }
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the current counter value
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Write writes the counter in the text format
+func (c *Counter) Write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	writeSample(w, c.name, nil, float64(c.Value()))
+}
+
+// CounterVec is a set of counters that share a name and are told apart by
+// label values. Every distinct combination of values becomes a series, so
+// label values must come from a small, bounded set.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.RWMutex
+	series map[string]*labeledCounter
+}
+
+// labeledCounter is one series of a CounterVec
+type labeledCounter struct {
+	values []string
+	value  uint64
+}
+
+// NewCounterVec creates and registers a counter vector with the given label
+// names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*labeledCounter),
+	}
+	Register(v)
+	return v
+}
+
+// Name returns the metric name
+func (v *CounterVec) Name() string { return v.name }
+
+// Inc increments the series with the given label values, in the order of
+// the label names, by one
+func (v *CounterVec) Inc(values ...string) {
+	v.Add(1, values...)
+}
+
+// Add increments the series with the given label values by n
+func (v *CounterVec) Add(n uint64, values ...string) {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.series[key]
+	v.mu.RUnlock()
+	if !ok {
+		v.mu.Lock()
+		if c, ok = v.series[key]; !ok {
+			c = &labeledCounter{values: append([]string(nil), values...)}
+			v.series[key] = c
+		}
+		v.mu.Unlock()
+	}
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the value of the series with the given label values
+func (v *CounterVec) Value(values ...string) uint64 {
+	v.mu.RLock()
+	c, ok := v.series[strings.Join(values, "\xff")]
+	v.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	return atomic.LoadUint64(&c.value)
+}
+
+// Series returns the number of series the vector holds
+func (v *CounterVec) Series() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.series)
+}
+
+// Write writes every series in the text format, sorted by label values
+func (v *CounterVec) Write(w io.Writer) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make([]*labeledCounter, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, v.series[key])
+	}
+	v.mu.RUnlock()
+
+	writeHeader(w, v.name, v.help, "counter")
+	pairs := make([]string, 2*len(v.labels))
+	for _, c := range series {
+		for i, label := range v.labels {
+			pairs[2*i] = label
+			pairs[2*i+1] = c.values[i]
+		}
+		writeSample(w, v.name, pairs, float64(atomic.LoadUint64(&c.value)))
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines for a metric
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// writeSample writes a single sample line with optional label pairs
+func writeSample(w io.Writer, name string, labels []string, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
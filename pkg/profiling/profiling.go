@@ -0,0 +1,59 @@
+package profiling
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/pkg/logger"
+)
+
+var server *http.Server
+
+// Init starts the pprof endpoints on PPROF_ADDR. The handlers are mounted on
+// a dedicated mux and listener so they are never reachable through the
+// public router. It is a no-op when PPROF_ENABLED is false.
+func Init(cfg *config.Config) error {
+	if !cfg.Monitoring.PprofEnabled {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	lis, err := net.Listen("tcp", cfg.Monitoring.PprofAddr)
+	if err != nil {
+		return err
+	}
+
+	// No write timeout: CPU profiles and traces stream for their ?seconds=
+	server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		logger.Infof("Serving pprof on %s", lis.Addr())
+		if err := server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warnf("pprof server stopped: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown stops the pprof server if it was started
+func Shutdown(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
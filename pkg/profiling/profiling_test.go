@@ -0,0 +1,66 @@
+package profiling
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"go-api-boilerplate/config"
+)
+
+// freeAddr returns a loopback address with a port nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func TestInitDisabledIsNoop(t *testing.T) {
+	addr := freeAddr(t)
+	cfg := &config.Config{Monitoring: config.MonitoringConfig{PprofAddr: addr}}
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if server != nil {
+		t.Error("pprof server started with PPROF_ENABLED=false")
+	}
+	if _, err := http.Get("http://" + addr + "/debug/pprof/"); err == nil {
+		t.Error("pprof reachable with PPROF_ENABLED=false")
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestInitServesPprof(t *testing.T) {
+	addr := freeAddr(t)
+	cfg := &config.Config{Monitoring: config.MonitoringConfig{PprofEnabled: true, PprofAddr: addr}}
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() { server = nil })
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/symbol"} {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: status %d, want 200", path, resp.StatusCode)
+		}
+	}
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := http.Get("http://" + addr + "/debug/pprof/"); err == nil {
+		t.Error("pprof reachable after Shutdown")
+	}
+}
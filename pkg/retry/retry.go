@@ -0,0 +1,83 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-api-boilerplate/pkg/logger"
+)
+
+// DefaultMaxInterval caps the wait between attempts when a Policy sets none
+const DefaultMaxInterval = 30 * time.Second
+
+// Policy bounds the retries of Do: Attempts calls in total, waiting
+// Interval after the first failure and twice as long after each further
+// one, up to MaxInterval
+type Policy struct {
+	Attempts    int
+	Interval    time.Duration
+	MaxInterval time.Duration
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so Do returns it at once instead of retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Do calls fn until it succeeds, logging each failed attempt under name. It
+// gives up and returns the last error once the attempts run out, fn returns
+// a Permanent error or ctx is done. A policy with fewer than one attempt
+// still calls fn once.
+func Do(ctx context.Context, policy Policy, name string, fn func() error) error {
+	attempts := max(policy.Attempts, 1)
+	maxInterval := policy.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = DefaultMaxInterval
+	}
+	wait := min(policy.Interval, maxInterval)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			if attempt > 1 {
+				logger.Infof("Connected to %s on attempt %d of %d", name, attempt, attempts)
+			}
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if attempt >= attempts {
+			if attempts > 1 {
+				return fmt.Errorf("giving up on %s after %d attempts: %w", name, attempts, err)
+			}
+			return err
+		}
+
+		logger.Warnf("Attempt %d of %d to connect to %s failed, retrying in %s: %v", attempt, attempts, name, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		wait = min(wait*2, maxInterval)
+	}
+}
@@ -0,0 +1,101 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingConnector returns a connect function that fails until its
+// succeedOn-th call, or always when succeedOn is 0, and records when it was
+// called
+func failingConnector(succeedOn int, calls *[]time.Time) func() error {
+	return func() error {
+		*calls = append(*calls, time.Now())
+		if len(*calls) == succeedOn {
+			return nil
+		}
+		return errors.New("connection refused")
+	}
+}
+
+func TestDoSucceedsOnThirdAttempt(t *testing.T) {
+	var calls []time.Time
+	policy := Policy{Attempts: 5, Interval: 20 * time.Millisecond}
+
+	if err := Do(context.Background(), policy, "the test database", failingConnector(3, &calls)); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("connect called %d times, want 3", len(calls))
+	}
+
+	// The wait doubles after each failure
+	for i, want := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if got := calls[i+1].Sub(calls[i]); got < want {
+			t.Errorf("wait before attempt %d = %v, want at least %v", i+2, got, want)
+		}
+	}
+}
+
+func TestDoGivesUp(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    Policy
+		wantCalls int
+	}{
+		{"after the attempts", Policy{Attempts: 3, Interval: time.Millisecond}, 3},
+		{"single attempt", Policy{Attempts: 1, Interval: time.Millisecond}, 1},
+		{"no attempts configured", Policy{}, 1},
+	}
+	for _, tt := range tests {
+		var calls []time.Time
+		err := Do(context.Background(), tt.policy, "the test database", failingConnector(0, &calls))
+		if err == nil {
+			t.Errorf("%s: Do succeeded, want the last error", tt.name)
+		}
+		if len(calls) != tt.wantCalls {
+			t.Errorf("%s: connect called %d times, want %d", tt.name, len(calls), tt.wantCalls)
+		}
+	}
+}
+
+func TestDoStopsOnPermanentError(t *testing.T) {
+	refused := errors.New("authentication failed")
+	calls := 0
+	err := Do(context.Background(), Policy{Attempts: 5, Interval: time.Millisecond}, "the test database", func() error {
+		calls++
+		return Permanent(refused)
+	})
+	if err != refused || calls != 1 {
+		t.Errorf("got %v after %d calls, want the unwrapped error after 1", err, calls)
+	}
+}
+
+func TestDoStopsWhenContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var calls []time.Time
+	start := time.Now()
+	err := Do(ctx, Policy{Attempts: 5, Interval: time.Minute}, "the test database", failingConnector(0, &calls))
+	if err == nil || len(calls) != 1 {
+		t.Errorf("got %v after %d calls, want the first error", err, len(calls))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do returned after %v, want it to stop with the context", elapsed)
+	}
+}
+
+func TestDoCapsTheInterval(t *testing.T) {
+	var calls []time.Time
+	start := time.Now()
+	policy := Policy{Attempts: 4, Interval: time.Hour, MaxInterval: 10 * time.Millisecond}
+	if err := Do(context.Background(), policy, "the test database", failingConnector(4, &calls)); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do took %v, want the waits capped at MaxInterval", elapsed)
+	}
+}
@@ -0,0 +1,99 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"go-api-boilerplate/pkg/logger"
+)
+
+// ConnTracker follows the state of an HTTP server's connections so the
+// ones still open when the shutdown window runs out can be reported. Install
+// Track as the server's ConnState hook.
+type ConnTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+// NewConnTracker creates an empty tracker
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{conns: make(map[net.Conn]http.ConnState)}
+}
+
+// Track records a connection state change. Hijacked connections, such as
+// WebSocket upgrades, leave the server's hands and are no longer counted.
+func (t *ConnTracker) Track(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(t.conns, conn)
+	default:
+		t.conns[conn] = state
+	}
+}
+
+// Open returns the number of connections the server still holds
+func (t *ConnTracker) Open() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// HTTP shuts srv down within timeout. The listeners close at once, idle
+// keep-alive connections are closed and in-flight requests are left to
+// finish. drain runs alongside to disconnect hijacked connections, which the
+// server no longer tracks, and returns how many it had to close when the
+// context expired. Requests still running at the timeout are logged and
+// their connections closed forcibly.
+func HTTP(srv *http.Server, tracker *ConnTracker, timeout time.Duration, drain func(context.Context) int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	drained := make(chan int, 1)
+	go func() {
+		if drain == nil {
+			drained <- 0
+			return
+		}
+		drained <- drain(ctx)
+	}()
+
+	err := srv.Shutdown(ctx)
+	hijacked := <-drained
+
+	if errors.Is(err, context.DeadlineExceeded) || hijacked > 0 {
+		open := 0
+		if tracker != nil {
+			open = tracker.Open()
+		}
+		logger.Warnf("Shutdown timeout of %s reached with %d HTTP and %d upgraded connections still open; closing them", timeout, open, hijacked)
+		return srv.Close()
+	}
+	return err
+}
+
+// GRPC stops srv gracefully, refusing new RPCs and waiting for pending ones
+// and open streams, then stops it forcibly once timeout expires
+func GRPC(srv *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Warnf("Shutdown timeout of %s reached with gRPC calls still running; stopping the server", timeout)
+		srv.Stop()
+		<-done
+	}
+}
@@ -0,0 +1,189 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// startHTTP serves handler on a local port with a connection tracker and
+// returns the server and its URL
+func startHTTP(t *testing.T, handler http.Handler) (*http.Server, *ConnTracker, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	tracker := NewConnTracker()
+	srv := &http.Server{Handler: handler, ConnState: tracker.Track}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return srv, tracker, "http://" + listener.Addr().String()
+}
+
+// get requests url in the background, sending the error, if any, once
+// the response body has been read
+func get(url string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		result <- err
+	}()
+	return result
+}
+
+func TestHTTPDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	srv, tracker, url := startHTTP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("done"))
+	}))
+
+	requests := []<-chan error{get(url), get(url)}
+	<-started
+	<-started
+	if open := tracker.Open(); open != 2 {
+		t.Fatalf("tracker counts %d connections, want 2", open)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- HTTP(srv, tracker, 5*time.Second, nil) }()
+
+	// The listener closes as soon as shutdown starts
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", url[len("http://"):], time.Second)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("new connections still accepted after shutdown started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	for i, result := range requests {
+		if err := <-result; err != nil {
+			t.Errorf("in-flight request %d: %v", i+1, err)
+		}
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("HTTP = %v, want a clean shutdown", err)
+	}
+	if open := tracker.Open(); open != 0 {
+		t.Errorf("%d connections left open", open)
+	}
+}
+
+func TestHTTPClosesConnectionsAtTimeout(t *testing.T) {
+	started := make(chan struct{})
+	srv, tracker, url := startHTTP(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+
+	request := get(url)
+	<-started
+
+	begin := time.Now()
+	if err := HTTP(srv, tracker, 100*time.Millisecond, nil); err != nil {
+		t.Errorf("HTTP = %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s with a 100ms timeout", elapsed)
+	}
+	if err := <-request; err == nil {
+		t.Error("request still running at the timeout completed, want its connection closed")
+	}
+}
+
+// watchHealth serves the health service on a local port and opens a Watch
+// stream, which stays open until the server ends it
+func watchHealth(t *testing.T) (*grpc.Server, healthpb.Health_WatchClient) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("first health update: %v", err)
+	}
+	return srv, stream
+}
+
+func TestGRPCStopsOpenStreamsAtTimeout(t *testing.T) {
+	srv, stream := watchHealth(t)
+
+	begin := time.Now()
+	GRPC(srv, 100*time.Millisecond)
+	elapsed := time.Since(begin)
+	if elapsed < 100*time.Millisecond {
+		t.Errorf("GRPC returned after %s, before the timeout, with a stream open", elapsed)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("GRPC took %s with a 100ms timeout", elapsed)
+	}
+
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Recv after shutdown = %v, want Unavailable", err)
+	}
+}
+
+func TestGRPCStopsPromptlyWhenIdle(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	served := make(chan struct{})
+	go func() {
+		srv.Serve(listener)
+		close(served)
+	}()
+
+	begin := time.Now()
+	GRPC(srv, 5*time.Second)
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("GRPC with nothing running took %s", elapsed)
+	}
+	<-served
+}
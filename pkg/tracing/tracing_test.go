@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-api-boilerplate/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// initTracing initializes tracing against endpoint and restores the global
+// provider when the test ends
+func initTracing(t *testing.T, endpoint string, ratio float64) {
+	t.Helper()
+
+	cfg := &config.Config{Tracing: config.TracingConfig{
+		Enabled:       true,
+		ServiceName:   "tracing-test",
+		Endpoint:      endpoint,
+		SamplingRatio: ratio,
+	}}
+	if err := Init(cfg); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	t.Cleanup(func() {
+		Shutdown(context.Background())
+		provider = nil
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+}
+
+func TestInitDisabledIsNoop(t *testing.T) {
+	if err := Init(&config.Config{}); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if Enabled() {
+		t.Fatal("Enabled() = true with tracing disabled")
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "http://localhost:4318/", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://collector:4318/otel", want: "https://collector:4318/otel/v1/traces"},
+		{endpoint: "http://localhost:4318/v1/traces", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "localhost:4318", wantErr: true},
+		{endpoint: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := tracesURL(tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tracesURL(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("tracesURL(%q) = %q, want %q", tt.endpoint, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdownExportsSpans(t *testing.T) {
+	received := make(chan *coltracepb.ExportTraceServiceRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("export path = %q, want /v1/traces", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Errorf("decode export request: %v", err)
+		}
+		received <- req
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	initTracing(t, collector.URL, 1)
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "work")
+	traceID := TraceID(ctx)
+	span.End()
+
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	var req *coltracepb.ExportTraceServiceRequest
+	select {
+	case req = <-received:
+	default:
+		t.Fatal("no spans were exported on shutdown")
+	}
+
+	rs := req.GetResourceSpans()
+	if len(rs) != 1 || len(rs[0].GetScopeSpans()) != 1 || len(rs[0].GetScopeSpans()[0].GetSpans()) != 1 {
+		t.Fatalf("exported %v, want a single span", req)
+	}
+	exported := rs[0].GetScopeSpans()[0].GetSpans()[0]
+	if exported.GetName() != "work" {
+		t.Errorf("span name = %q, want work", exported.GetName())
+	}
+	if got := trace.TraceID(exported.GetTraceId()).String(); got != traceID {
+		t.Errorf("exported trace ID = %s, want %s", got, traceID)
+	}
+
+	var service string
+	for _, attr := range rs[0].GetResource().GetAttributes() {
+		if attr.GetKey() == "service.name" {
+			service = attr.GetValue().GetStringValue()
+		}
+	}
+	if service != "tracing-test" {
+		t.Errorf("service.name = %q, want tracing-test", service)
+	}
+}
+
+func TestSamplingHonoursParentDecision(t *testing.T) {
+	// Nothing is exported with a zero ratio, so the endpoint is never dialled
+	initTracing(t, "http://127.0.0.1:1", 0)
+
+	_, root := otel.Tracer("test").Start(context.Background(), "root")
+	defer root.End()
+	if root.SpanContext().IsSampled() {
+		t.Error("root span sampled with a zero sampling ratio")
+	}
+
+	carrier := propagation.HeaderCarrier(http.Header{})
+	carrier.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	parent := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+
+	ctx, child := otel.Tracer("test").Start(parent, "child")
+	defer child.End()
+	if !child.SpanContext().IsSampled() {
+		t.Error("child of a sampled remote parent was not sampled")
+	}
+	if got := TraceID(ctx); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID = %q, want the remote parent's trace ID", got)
+	}
+}
+
+func TestTraceIDWithoutSpan(t *testing.T) {
+	if got := TraceID(context.Background()); got != "" {
+		t.Errorf("TraceID = %q, want empty", got)
+	}
+}
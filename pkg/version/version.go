@@ -0,0 +1,34 @@
+package version
+
+import "runtime"
+
+// Build information, set at link time with
+//
+//	-ldflags "-X go-api-boilerplate/pkg/version.Version=v1.2.3
+//	          -X go-api-boilerplate/pkg/version.Commit=$(git rev-parse HEAD)
+//	          -X go-api-boilerplate/pkg/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Builds without them report the defaults below.
+var (
+	Version   = "dev"
+	Commit    = "dev"
+	BuildTime = "dev"
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
@@ -0,0 +1,22 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	// Without -ldflags every field but the Go version reports "dev"
+	if got, want := Get(), (Info{"dev", "dev", "dev", runtime.Version()}); got != want {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+
+	defer func(version, commit, buildTime string) {
+		Version, Commit, BuildTime = version, commit, buildTime
+	}(Version, Commit, BuildTime)
+	Version, Commit, BuildTime = "v1.2.3", "0123abc", "2026-01-02T03:04:05Z"
+
+	if got, want := Get(), (Info{"v1.2.3", "0123abc", "2026-01-02T03:04:05Z", runtime.Version()}); got != want {
+		t.Errorf("Get() with build flags = %+v, want %+v", got, want)
+	}
+}
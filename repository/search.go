@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/gorm"
+)
+
+// Full-text search for users runs on name and email. Each backend uses its
+// native full-text index when one exists and otherwise falls back to a
+// substring match, which scans the table. Recommended indexes:
+//
+// PostgreSQL (full-text, plus trigram indexes to speed up the ILIKE fallback):
+//
+//	CREATE INDEX idx_users_search ON users USING gin (to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(email, '')));
+//	CREATE EXTENSION IF NOT EXISTS pg_trgm;
+//	CREATE INDEX idx_users_name_trgm ON users USING gin (name gin_trgm_ops);
+//	CREATE INDEX idx_users_email_trgm ON users USING gin (email gin_trgm_ops);
+//
+// MySQL:
+//
+//	CREATE FULLTEXT INDEX idx_users_search ON users (name, email);
+//
+// MongoDB:
+//
+//	db.users.createIndex({ name: "text", email: "text" })
+//
+// SQLite and SQL Server always use LIKE. Index detection runs once per
+// repository, so restart the service after creating an index.
+
+// postgresSearchVector must match the expression of the PostgreSQL index
+const postgresSearchVector = "to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(email, ''))"
+
+// likeEscaper escapes LIKE wildcards using '!' as the escape character,
+// which needs no quoting in any supported SQL dialect
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// textSearch caches whether a table or collection has a full-text index
+type textSearch struct {
+	once    sync.Once
+	indexed bool
+}
+
+// hasIndex runs detect once and caches the result
+func (t *textSearch) hasIndex(detect func() bool) bool {
+	t.once.Do(func() {
+		t.indexed = detect()
+	})
+	return t.indexed
+}
+
+// searchSQL applies a full-text search on name and email to the query
+func searchSQL(db *gorm.DB, table, query string, fts *textSearch) *gorm.DB {
+	switch db.Dialector.Name() {
+	case "postgres":
+		if fts.hasIndex(func() bool { return postgresHasSearchIndex(db, table) }) {
+			return db.Where(postgresSearchVector+" @@ plainto_tsquery('simple', ?)", query)
+		}
+		pattern := "%" + likeEscaper.Replace(query) + "%"
+		return db.Where("name ILIKE ? ESCAPE '!' OR email ILIKE ? ESCAPE '!'", pattern, pattern)
+
+	case "mysql":
+		if fts.hasIndex(func() bool { return mysqlHasFulltextIndex(db, table) }) {
+			return db.Where("MATCH(name, email) AGAINST (? IN NATURAL LANGUAGE MODE)", query)
+		}
+	}
+
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	return db.Where("name LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!'", pattern, pattern)
+}
+
+// postgresHasSearchIndex reports whether the table has a to_tsvector index
+func postgresHasSearchIndex(db *gorm.DB, table string) bool {
+	var count int64
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Raw("SELECT COUNT(*) FROM pg_indexes WHERE tablename = ? AND indexdef ILIKE ?", table, "%to_tsvector%").
+		Scan(&count).Error
+	return err == nil && count > 0
+}
+
+// mysqlHasFulltextIndex reports whether the table has a FULLTEXT index
+func mysqlHasFulltextIndex(db *gorm.DB, table string) bool {
+	var count int64
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Raw("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_type = 'FULLTEXT'", table).
+		Scan(&count).Error
+	return err == nil && count > 0
+}
+
+// searchMongo builds the filter and options for a user search
+func searchMongo(ctx context.Context, collection *mongo.Collection, query string, fts *textSearch) (bson.M, *options.FindOptions) {
+	if fts.hasIndex(func() bool { return mongoHasTextIndex(ctx, collection) }) {
+		filter := bson.M{"$text": bson.M{"$search": query}}
+		opts := options.Find().SetSort(bson.M{"score": bson.M{"$meta": "textScore"}})
+		return filter, opts
+	}
+
+	// Quote the query so user input is matched literally
+	pattern := regexp.QuoteMeta(query)
+	filter := bson.M{
+		"$or": []bson.M{
+			{"name": bson.M{"$regex": pattern, "$options": "i"}},
+			{"email": bson.M{"$regex": pattern, "$options": "i"}},
+		},
+	}
+	return filter, options.Find()
+}
+
+// mongoHasTextIndex reports whether the collection has a text index
+func mongoHasTextIndex(ctx context.Context, collection *mongo.Collection) bool {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return false
+	}
+	defer cursor.Close(ctx)
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return false
+	}
+
+	for _, index := range indexes {
+		switch key := index["key"].(type) {
+		case bson.M:
+			if _, ok := key["_fts"]; ok {
+				return true
+			}
+		case bson.D:
+			for _, elem := range key {
+				if elem.Key == "_fts" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"go-api-boilerplate/models"
+)
+
+// detected returns a textSearch whose index detection already ran
+func detected(indexed bool) *textSearch {
+	fts := &textSearch{}
+	fts.hasIndex(func() bool { return indexed })
+	return fts
+}
+
+// dryRunDB opens a dialector that builds statements without a server
+func dryRunDB(t *testing.T, dialector gorm.Dialector) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(dialector, &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("failed to open dry-run database: %v", err)
+	}
+	return db
+}
+
+func TestSearchSQLPerDialect(t *testing.T) {
+	postgresDB := dryRunDB(t, postgres.New(postgres.Config{DSN: "host=localhost dbname=test"}))
+	mysqlDB := dryRunDB(t, mysql.New(mysql.Config{DSN: "test@tcp(localhost)/test", SkipInitializeWithVersion: true}))
+	sqliteDB := dryRunDB(t, sqlite.Open(filepath.Join(t.TempDir(), "dry.db")))
+
+	tests := []struct {
+		name     string
+		db       *gorm.DB
+		indexed  bool
+		query    string
+		wantSQL  string
+		wantVars []interface{}
+	}{
+		{
+			name:     "postgres full-text",
+			db:       postgresDB,
+			indexed:  true,
+			query:    "jane doe",
+			wantSQL:  `SELECT * FROM "users" WHERE to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(email, '')) @@ plainto_tsquery('simple', $1) AND "users"."deleted_at" IS NULL`,
+			wantVars: []interface{}{"jane doe"},
+		},
+		{
+			name:     "postgres without an index",
+			db:       postgresDB,
+			query:    "jane",
+			wantSQL:  `SELECT * FROM "users" WHERE (name ILIKE $1 ESCAPE '!' OR email ILIKE $2 ESCAPE '!') AND "users"."deleted_at" IS NULL`,
+			wantVars: []interface{}{"%jane%", "%jane%"},
+		},
+		{
+			name:     "mysql full-text",
+			db:       mysqlDB,
+			indexed:  true,
+			query:    "jane doe",
+			wantSQL:  "SELECT * FROM `users` WHERE MATCH(name, email) AGAINST (? IN NATURAL LANGUAGE MODE) AND `users`.`deleted_at` IS NULL",
+			wantVars: []interface{}{"jane doe"},
+		},
+		{
+			name:     "mysql without an index",
+			db:       mysqlDB,
+			query:    "jane",
+			wantSQL:  "SELECT * FROM `users` WHERE (name LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!') AND `users`.`deleted_at` IS NULL",
+			wantVars: []interface{}{"%jane%", "%jane%"},
+		},
+		{
+			name:     "sqlite always uses LIKE",
+			db:       sqliteDB,
+			indexed:  true,
+			query:    "jane",
+			wantSQL:  "SELECT * FROM `users` WHERE (name LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!') AND `users`.`deleted_at` IS NULL",
+			wantVars: []interface{}{"%jane%", "%jane%"},
+		},
+		{
+			name:     "wildcards are escaped",
+			db:       sqliteDB,
+			query:    "100%_off!",
+			wantSQL:  "SELECT * FROM `users` WHERE (name LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!') AND `users`.`deleted_at` IS NULL",
+			wantVars: []interface{}{"%100!%!_off!!%", "%100!%!_off!!%"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var users []models.User
+			stmt := searchSQL(tt.db.Session(&gorm.Session{}), "users", tt.query, detected(tt.indexed)).Find(&users).Statement
+
+			if got := stmt.SQL.String(); got != tt.wantSQL {
+				t.Errorf("SQL = %s\nwant  %s", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(stmt.Vars, tt.wantVars) {
+				t.Errorf("vars = %v, want %v", stmt.Vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestSearchSQLMatchesLiterally(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "search.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	for _, user := range []models.User{
+		{Name: "100% Jane", Email: "jane@example.com"},
+		{Name: "1000 Jones", Email: "jones@example.com"},
+		{Name: "Under_score", Email: "under@example.com"},
+		{Name: "Underscore", Email: "plain@example.com"},
+	} {
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"100%", []string{"100% Jane"}},
+		{"under_", []string{"Under_score"}},
+		{"EXAMPLE.COM", []string{"100% Jane", "1000 Jones", "Under_score", "Underscore"}},
+		{"nobody", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var users []models.User
+			if err := searchSQL(db, "users", tt.query, detected(false)).Order("id").Find(&users).Error; err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			var names []string
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("search %q = %v, want %v", tt.query, names, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMongo(t *testing.T) {
+	ctx := context.Background()
+
+	filter, opts := searchMongo(ctx, nil, "jane doe", detected(true))
+	if want := (bson.M{"$text": bson.M{"$search": "jane doe"}}); !reflect.DeepEqual(filter, want) {
+		t.Errorf("indexed filter = %v, want %v", filter, want)
+	}
+	if want := (bson.M{"score": bson.M{"$meta": "textScore"}}); !reflect.DeepEqual(opts.Sort, want) {
+		t.Errorf("indexed sort = %v, want %v", opts.Sort, want)
+	}
+
+	// Without a text index the query is matched literally
+	filter, _ = searchMongo(ctx, nil, "a.b+(c)", detected(false))
+	pattern := `a\.b\+\(c\)`
+	want := bson.M{"$or": []bson.M{
+		{"name": bson.M{"$regex": pattern, "$options": "i"}},
+		{"email": bson.M{"$regex": pattern, "$options": "i"}},
+	}}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("fallback filter = %v, want %v", filter, want)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
 )
 
 var (
@@ -34,6 +35,23 @@ type UserRepository interface {
 	VerifyEmail(ctx context.Context, id any) error
 	ChangePassword(ctx context.Context, id any, hashedPassword string) error
 	CountByRole(ctx context.Context, role string) (int64, error)
+
+	// Listings, see user_list.go
+	List(ctx context.Context, filter *UserFilter, offset, limit int) ([]models.User, int64, error)
+	Each(ctx context.Context, filter *UserFilter, fn func(*models.User) error) error
+	Totals(ctx context.Context, createdSince ...time.Time) (*UserTotals, error)
+
+	// Transactions, audit log and token bookkeeping, see user_tokens.go
+	Transaction(ctx context.Context, fn func(users UserRepository) error) error
+	Audit(ctx context.Context, entry *models.AuditLog) error
+	RevokeAccess(ctx context.Context, id uint) error
+	CreatePasswordReset(ctx context.Context, reset *models.PasswordReset) error
+	FindPasswordReset(ctx context.Context, tokenHash string) (*models.PasswordReset, error)
+	ClaimPasswordReset(ctx context.Context, id uint) error
+	CreateEmailVerification(ctx context.Context, verification *models.EmailVerification) error
+	FindEmailVerification(ctx context.Context, tokenHash string) (*models.EmailVerification, error)
+	ClaimEmailVerification(ctx context.Context, id uint) error
+	DeleteSpentTokens(ctx context.Context, now time.Time) (int64, error)
 }
 
 // userRepository implements UserRepository
@@ -41,6 +59,8 @@ type userRepository struct {
 	libraries.Repository[models.User]
 	db         *database.DB
 	collection *mongo.Collection // For MongoDB
+	tx         *gorm.DB          // Set inside Transaction on SQL databases
+	fts        textSearch
 }
 
 // NewUserRepository creates a new user repository
@@ -68,8 +88,8 @@ func NewUserRepository(db *database.DB) UserRepository {
 func (r *userRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	result, err := r.Where("email", email).First(ctx)
 	if err != nil {
-		if err == ErrRecordNotFound {
-			return nil, fmt.Errorf("user with email %s not found", email)
+		if errors.Is(err, libraries.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user with email %s not found: %w", email, err)
 		}
 		return nil, err
 	}
@@ -91,59 +111,45 @@ func (r *userRepository) FindVerified(ctx context.Context) ([]models.User, error
 	return r.Where("email_verified", true).Find(ctx)
 }
 
-// Search searches users by name or email
+// Search runs a full-text search on name and email, using the backend's
+// full-text index when present (see search.go for the indexes to create)
 func (r *userRepository) Search(ctx context.Context, query string) ([]models.User, error) {
-	if database.IsMongoDB() {
-		// MongoDB text search
-		filter := bson.M{
-			"$or": []bson.M{
-				{"name": bson.M{"$regex": query, "$options": "i"}},
-				{"email": bson.M{"$regex": query, "$options": "i"}},
-			},
-		}
+	var users []models.User
 
-		cursor, err := r.collection.Find(ctx, filter)
+	if database.IsMongoDB() {
+		filter, opts := searchMongo(ctx, r.collection, query, &r.fts)
+		cursor, err := r.collection.Find(ctx, filter, opts)
 		if err != nil {
 			return nil, err
 		}
 		defer cursor.Close(ctx)
 
-		var users []models.User
 		if err := cursor.All(ctx, &users); err != nil {
 			return nil, err
 		}
-
 		return users, nil
-	} else {
-		// SQL LIKE search
-		searchPattern := "%" + query + "%"
-		var users []models.User
-		err := r.db.Read.WithContext(ctx).
-			Where("name LIKE ? OR email LIKE ?", searchPattern, searchPattern).
-			Find(&users).Error
-		return users, err
 	}
+
+	err := searchSQL(r.db.Reader(ctx).WithContext(ctx), "users", query, &r.fts).Find(&users).Error
+	return users, err
 }
 
 // UpdateLastLogin updates the last login timestamp
 func (r *userRepository) UpdateLastLogin(ctx context.Context, id any) error {
-	now := time.Now()
-	return r.Update(ctx, id, &models.User{LastLoginAt: &now})
+	return r.UpdatePartial(ctx, id, map[string]any{"last_login_at": time.Now()})
 }
 
 // VerifyEmail marks email as verified
 func (r *userRepository) VerifyEmail(ctx context.Context, id any) error {
-	now := time.Now()
-	user := &models.User{
-		EmailVerified:   true,
-		EmailVerifiedAt: &now,
-	}
-	return r.Update(ctx, id, user)
+	return r.UpdatePartial(ctx, id, map[string]any{
+		"email_verified":    true,
+		"email_verified_at": time.Now(),
+	})
 }
 
 // ChangePassword updates user password
 func (r *userRepository) ChangePassword(ctx context.Context, id any, hashedPassword string) error {
-	return r.Update(ctx, id, &models.User{Password: hashedPassword})
+	return r.UpdatePartial(ctx, id, map[string]any{"password": hashedPassword})
 }
 
 // CountByRole counts users by role
@@ -167,6 +173,7 @@ type UserMongoRepository interface {
 type userMongoRepository struct {
 	libraries.Repository[models.UserMongo]
 	collection *mongo.Collection
+	fts        textSearch
 }
 
 // NewUserMongoRepository creates a new MongoDB user repository
@@ -184,8 +191,8 @@ func NewUserMongoRepository(db *mongo.Database) UserMongoRepository {
 func (r *userMongoRepository) FindByEmail(ctx context.Context, email string) (*models.UserMongo, error) {
 	result, err := r.Where("email", email).First(ctx)
 	if err != nil {
-		if err == ErrRecordNotFound {
-			return nil, fmt.Errorf("user with email %s not found", email)
+		if errors.Is(err, libraries.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user with email %s not found: %w", email, err)
 		}
 		return nil, err
 	}
@@ -202,16 +209,11 @@ func (r *userMongoRepository) FindActive(ctx context.Context) ([]models.UserMong
 	return r.Where("is_active", true).Find(ctx)
 }
 
-// Search searches users by name or email in MongoDB
+// Search runs a full-text search on name and email in MongoDB, using the
+// text index when present
 func (r *userMongoRepository) Search(ctx context.Context, query string) ([]models.UserMongo, error) {
-	filter := bson.M{
-		"$or": []bson.M{
-			{"name": bson.M{"$regex": query, "$options": "i"}},
-			{"email": bson.M{"$regex": query, "$options": "i"}},
-		},
-	}
-
-	cursor, err := r.collection.Find(ctx, filter)
+	filter, opts := searchMongo(ctx, r.collection, query, &r.fts)
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
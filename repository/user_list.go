@@ -0,0 +1,360 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidSortField = errors.New("invalid sort field")
+	ErrInvalidSortOrder = errors.New("invalid sort order")
+)
+
+// userSortFields lists the columns user listings may be sorted by
+var userSortFields = map[string]bool{
+	"id":            true,
+	"email":         true,
+	"name":          true,
+	"role":          true,
+	"created_at":    true,
+	"updated_at":    true,
+	"last_login_at": true,
+}
+
+// UserFilter holds filtering and sorting options for user listings
+type UserFilter struct {
+	Search        string
+	Role          string
+	IsActive      *bool
+	EmailVerified *bool
+	SortBy        string
+	SortOrder     string
+}
+
+// Validate normalizes the sort options, defaulting to newest first, and
+// rejects fields outside the whitelist
+func (f *UserFilter) Validate() error {
+	f.SortBy = strings.ToLower(strings.TrimSpace(f.SortBy))
+	f.SortOrder = strings.ToLower(strings.TrimSpace(f.SortOrder))
+
+	if f.SortBy == "" {
+		f.SortBy = "created_at"
+	}
+	if !userSortFields[f.SortBy] {
+		return fmt.Errorf("%w: %s", ErrInvalidSortField, f.SortBy)
+	}
+
+	if f.SortOrder == "" {
+		f.SortOrder = "desc"
+	}
+	if f.SortOrder != "asc" && f.SortOrder != "desc" {
+		return fmt.Errorf("%w: %s", ErrInvalidSortOrder, f.SortOrder)
+	}
+
+	return nil
+}
+
+// UserTotals holds aggregate counts over the users that are not deleted
+type UserTotals struct {
+	Total    int64
+	Active   int64
+	Verified int64
+	// Created holds, for each time passed to Totals, how many users were
+	// created at or after it
+	Created []int64
+	ByRole  map[string]int64
+}
+
+// List returns the users matching a validated filter, in its sort order,
+// skipping offset users and returning at most limit, together with the
+// number of users matching the filter
+func (r *userRepository) List(ctx context.Context, filter *UserFilter, offset, limit int) ([]models.User, int64, error) {
+	users := []models.User{}
+
+	if database.IsMongoDB() {
+		conditions := userFilterMongo(filter)
+		total, err := r.collection.CountDocuments(ctx, conditions)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		opts := options.Find().SetSort(userSortMongo(filter)).SetSkip(int64(offset)).SetLimit(int64(limit))
+		cursor, err := r.collection.Find(ctx, conditions, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := cursor.All(ctx, &users); err != nil {
+			return nil, 0, err
+		}
+		return users, total, nil
+	}
+
+	query := r.userFilterSQL(ctx, filter)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order(filter.SortBy + " " + filter.SortOrder).
+		Offset(offset).
+		Limit(limit).
+		Find(&users).Error
+	return users, total, err
+}
+
+// Each calls fn for every user matching a validated filter, in its sort
+// order, reading them through a cursor so memory use does not grow with the
+// number of users. Iteration stops at the first error from fn, which is
+// returned unchanged.
+func (r *userRepository) Each(ctx context.Context, filter *UserFilter, fn func(*models.User) error) error {
+	if database.IsMongoDB() {
+		cursor, err := r.collection.Find(ctx, userFilterMongo(filter), options.Find().SetSort(userSortMongo(filter)))
+		if err != nil {
+			return fmt.Errorf("failed to list users: %w", err)
+		}
+		defer cursor.Close(ctx)
+
+		for cursor.Next(ctx) {
+			var user models.User
+			if err := cursor.Decode(&user); err != nil {
+				return fmt.Errorf("failed to read user: %w", err)
+			}
+			if err := fn(&user); err != nil {
+				return err
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			return fmt.Errorf("failed to list users: %w", err)
+		}
+		return nil
+	}
+
+	query := r.userFilterSQL(ctx, filter).Order(filter.SortBy + " " + filter.SortOrder)
+	rows, err := query.Rows()
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		if err := query.ScanRows(rows, &user); err != nil {
+			return fmt.Errorf("failed to read user: %w", err)
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	return nil
+}
+
+// Totals counts the users that are not deleted, in total, by status and by
+// role, and those created since each of createdSince. SQL databases answer
+// with two aggregate queries and MongoDB with two aggregation pipelines.
+func (r *userRepository) Totals(ctx context.Context, createdSince ...time.Time) (*UserTotals, error) {
+	if database.IsMongoDB() {
+		return r.totalsMongo(ctx, createdSince)
+	}
+
+	selects := []string{
+		"COUNT(*)",
+		"COALESCE(SUM(CASE WHEN is_active = ? THEN 1 ELSE 0 END), 0)",
+		"COALESCE(SUM(CASE WHEN email_verified = ? THEN 1 ELSE 0 END), 0)",
+	}
+	args := []any{true, true}
+	for _, since := range createdSince {
+		selects = append(selects, "COALESCE(SUM(CASE WHEN created_at >= ? THEN 1 ELSE 0 END), 0)")
+		args = append(args, since)
+	}
+
+	totals := &UserTotals{Created: make([]int64, len(createdSince))}
+	dest := []any{&totals.Total, &totals.Active, &totals.Verified}
+	for i := range totals.Created {
+		dest = append(dest, &totals.Created[i])
+	}
+
+	err := r.readDB(ctx).Model(&models.User{}).
+		Select(strings.Join(selects, ", "), args...).
+		Row().Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []struct {
+		Role  string
+		Count int64
+	}
+	err = r.readDB(ctx).Model(&models.User{}).
+		Select("role, COUNT(*) AS count").
+		Group("role").
+		Scan(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+
+	totals.ByRole = make(map[string]int64, len(roles))
+	for _, role := range roles {
+		totals.ByRole[role.Role] = role.Count
+	}
+	return totals, nil
+}
+
+// totalsMongo computes Totals with aggregation pipelines
+func (r *userRepository) totalsMongo(ctx context.Context, createdSince []time.Time) (*UserTotals, error) {
+	live := bson.M{"$match": bson.M{"deleted_at": nil}}
+	countIf := func(condition any) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{condition, 1, 0}}}
+	}
+
+	group := bson.M{
+		"_id":      nil,
+		"total":    bson.M{"$sum": 1},
+		"active":   countIf(bson.M{"$eq": bson.A{"$is_active", true}}),
+		"verified": countIf(bson.M{"$eq": bson.A{"$email_verified", true}}),
+	}
+	for i, since := range createdSince {
+		group[fmt.Sprintf("created_%d", i)] = countIf(bson.M{"$gte": bson.A{"$created_at", since}})
+	}
+
+	counts, err := aggregateMongo(ctx, r.collection, bson.A{live, bson.M{"$group": group}})
+	if err != nil {
+		return nil, err
+	}
+
+	totals := &UserTotals{Created: make([]int64, len(createdSince))}
+	if len(counts) > 0 {
+		totals.Total = mongoCount(counts[0]["total"])
+		totals.Active = mongoCount(counts[0]["active"])
+		totals.Verified = mongoCount(counts[0]["verified"])
+		for i := range totals.Created {
+			totals.Created[i] = mongoCount(counts[0][fmt.Sprintf("created_%d", i)])
+		}
+	}
+
+	roles, err := aggregateMongo(ctx, r.collection, bson.A{
+		live,
+		bson.M{"$group": bson.M{"_id": "$role", "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	totals.ByRole = make(map[string]int64, len(roles))
+	for _, role := range roles {
+		name, _ := role["_id"].(string)
+		totals.ByRole[name] = mongoCount(role["count"])
+	}
+	return totals, nil
+}
+
+// aggregateMongo runs a pipeline and returns every resulting document
+func aggregateMongo(ctx context.Context, collection *mongo.Collection, pipeline bson.A) ([]bson.M, error) {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// mongoCount reads a count returned by $sum, which is an int32 until it
+// outgrows it
+func mongoCount(value any) int64 {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+// readDB returns the connection listings read from: the transaction the
+// repository is bound to, or the replica chosen for ctx
+func (r *userRepository) readDB(ctx context.Context) *gorm.DB {
+	if r.tx != nil {
+		return r.tx.WithContext(ctx)
+	}
+	return r.db.Reader(ctx).WithContext(ctx)
+}
+
+// userFilterSQL builds the user query for a validated filter
+func (r *userRepository) userFilterSQL(ctx context.Context, filter *UserFilter) *gorm.DB {
+	query := r.readDB(ctx).Model(&models.User{})
+	if filter.Search != "" {
+		pattern := "%" + filter.Search + "%"
+		query = query.Where("name LIKE ? OR email LIKE ?", pattern, pattern)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.IsActive != nil {
+		query = query.Where("is_active = ?", *filter.IsActive)
+	}
+	if filter.EmailVerified != nil {
+		query = query.Where("email_verified = ?", *filter.EmailVerified)
+	}
+	return query
+}
+
+// userFilterMongo builds the MongoDB filter for a validated filter. The
+// search is a case-insensitive substring match on name and email, like LIKE
+// on the SQL databases.
+func userFilterMongo(filter *UserFilter) bson.M {
+	conditions := bson.M{"deleted_at": nil}
+	if filter.Search != "" {
+		pattern := regexp.QuoteMeta(filter.Search)
+		conditions["$or"] = bson.A{
+			bson.M{"name": bson.M{"$regex": pattern, "$options": "i"}},
+			bson.M{"email": bson.M{"$regex": pattern, "$options": "i"}},
+		}
+	}
+	if filter.Role != "" {
+		conditions["role"] = filter.Role
+	}
+	if filter.IsActive != nil {
+		conditions["is_active"] = *filter.IsActive
+	}
+	if filter.EmailVerified != nil {
+		conditions["email_verified"] = *filter.EmailVerified
+	}
+	return conditions
+}
+
+// userSortMongo returns the MongoDB sort for a validated filter
+func userSortMongo(filter *UserFilter) bson.D {
+	field := filter.SortBy
+	if field == "id" {
+		field = "_id"
+	}
+	order := 1
+	if filter.SortOrder == "desc" {
+		order = -1
+	}
+	return bson.D{{Key: field, Value: order}}
+}
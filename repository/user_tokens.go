@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/libraries"
+	"go-api-boilerplate/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gorm.io/gorm"
+)
+
+// ErrTokenUsed is returned when claiming a single-use token that another
+// request already used
+var ErrTokenUsed = errors.New("token already used")
+
+// MongoDB collections holding the records users own besides their document
+const (
+	sessionsCollection           = "sessions"
+	passwordResetsCollection     = "password_resets"
+	emailVerificationsCollection = "email_verifications"
+	auditLogsCollection          = "audit_logs"
+)
+
+// Transaction runs fn with a repository whose writes, including those to
+// the audit log and tokens, commit together when fn returns nil and roll
+// back otherwise. Inside a transaction fn runs with the same repository.
+//
+// On MongoDB fn runs without a transaction, as those need a replica set:
+// its writes apply one by one and stay applied when a later one fails.
+// Callers order their writes so that a partial run is safe to repeat.
+func (r *userRepository) Transaction(ctx context.Context, fn func(users UserRepository) error) error {
+	if database.IsMongoDB() || r.tx != nil {
+		return fn(r)
+	}
+
+	return r.db.Write.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&userRepository{
+			Repository: r.Repository.WithTransaction(tx),
+			db:         r.db,
+			tx:         tx,
+		})
+	})
+}
+
+// writeDB returns the connection writes and token lookups go to: the
+// transaction the repository is bound to, or the primary
+func (r *userRepository) writeDB(ctx context.Context) *gorm.DB {
+	if r.tx != nil {
+		return r.tx.WithContext(ctx)
+	}
+	return r.db.Write.WithContext(ctx)
+}
+
+// mongoCollection returns a collection of the MongoDB database
+func (r *userRepository) mongoCollection(name string) *mongo.Collection {
+	return r.db.MongoDB.Collection(name)
+}
+
+// Audit records an entry in the audit log
+func (r *userRepository) Audit(ctx context.Context, entry *models.AuditLog) error {
+	return createRecord(ctx, r, auditLogsCollection, entry)
+}
+
+// RevokeAccess deletes a user's sessions and reset and verification tokens
+// and clears their refresh token, whether or not the user is soft-deleted
+func (r *userRepository) RevokeAccess(ctx context.Context, id uint) error {
+	if database.IsMongoDB() {
+		for _, name := range []string{sessionsCollection, passwordResetsCollection, emailVerificationsCollection} {
+			if _, err := r.mongoCollection(name).DeleteMany(ctx, bson.M{"user_id": id}); err != nil {
+				return fmt.Errorf("failed to delete user data: %w", err)
+			}
+		}
+		if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"refresh_token": ""}}); err != nil {
+			return fmt.Errorf("failed to revoke refresh token: %w", err)
+		}
+		return nil
+	}
+
+	for _, model := range []any{&models.Session{}, &models.PasswordReset{}, &models.EmailVerification{}} {
+		if err := r.writeDB(ctx).Where("user_id = ?", id).Delete(model).Error; err != nil {
+			return fmt.Errorf("failed to delete user data: %w", err)
+		}
+	}
+	if err := r.writeDB(ctx).Unscoped().Model(&models.User{}).Where("id = ?", id).UpdateColumn("refresh_token", "").Error; err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordReset stores a password reset token
+func (r *userRepository) CreatePasswordReset(ctx context.Context, reset *models.PasswordReset) error {
+	return createRecord(ctx, r, passwordResetsCollection, reset)
+}
+
+// FindPasswordReset finds a password reset by the hash of its token on the
+// primary, returning libraries.ErrRecordNotFound when none matches
+func (r *userRepository) FindPasswordReset(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	return findToken[models.PasswordReset](ctx, r, passwordResetsCollection, tokenHash)
+}
+
+// ClaimPasswordReset marks a password reset as used, returning ErrTokenUsed
+// when it already was
+func (r *userRepository) ClaimPasswordReset(ctx context.Context, id uint) error {
+	return claimToken[models.PasswordReset](ctx, r, passwordResetsCollection, id)
+}
+
+// CreateEmailVerification stores an email verification token
+func (r *userRepository) CreateEmailVerification(ctx context.Context, verification *models.EmailVerification) error {
+	return createRecord(ctx, r, emailVerificationsCollection, verification)
+}
+
+// FindEmailVerification finds an email verification by the hash of its
+// token on the primary, returning libraries.ErrRecordNotFound when none
+// matches
+func (r *userRepository) FindEmailVerification(ctx context.Context, tokenHash string) (*models.EmailVerification, error) {
+	return findToken[models.EmailVerification](ctx, r, emailVerificationsCollection, tokenHash)
+}
+
+// ClaimEmailVerification marks an email verification as used, returning
+// ErrTokenUsed when it already was
+func (r *userRepository) ClaimEmailVerification(ctx context.Context, id uint) error {
+	return claimToken[models.EmailVerification](ctx, r, emailVerificationsCollection, id)
+}
+
+// DeleteSpentTokens deletes password reset and email verification tokens
+// that expired before now or have been used, returning how many were removed
+func (r *userRepository) DeleteSpentTokens(ctx context.Context, now time.Time) (int64, error) {
+	var removed int64
+
+	if database.IsMongoDB() {
+		spent := bson.M{"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lt": now}},
+			bson.M{"used_at": bson.M{"$ne": nil}},
+		}}
+		for _, name := range []string{passwordResetsCollection, emailVerificationsCollection} {
+			result, err := r.mongoCollection(name).DeleteMany(ctx, spent)
+			if err != nil {
+				return removed, err
+			}
+			removed += result.DeletedCount
+		}
+		return removed, nil
+	}
+
+	for _, model := range []any{&models.PasswordReset{}, &models.EmailVerification{}} {
+		result := r.writeDB(ctx).Where("expires_at < ? OR used_at IS NOT NULL", now).Delete(model)
+		if result.Error != nil {
+			return removed, result.Error
+		}
+		removed += result.RowsAffected
+	}
+	return removed, nil
+}
+
+// createRecord inserts a record owned by a user. On MongoDB it goes through
+// a MongoRepository, which assigns its uint ID.
+func createRecord[T any](ctx context.Context, r *userRepository, collection string, record *T) error {
+	if database.IsMongoDB() {
+		var model T
+		return libraries.NewMongoRepository(r.mongoCollection(collection), model).Create(ctx, record)
+	}
+	return r.writeDB(ctx).Create(record).Error
+}
+
+// findToken finds a token record by its hash
+func findToken[T any](ctx context.Context, r *userRepository, collection, tokenHash string) (*T, error) {
+	var token T
+	var err error
+	if database.IsMongoDB() {
+		err = r.mongoCollection(collection).FindOne(ctx, bson.M{"token": tokenHash}).Decode(&token)
+	} else {
+		err = r.writeDB(ctx).Where("token = ?", tokenHash).First(&token).Error
+	}
+
+	if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, libraries.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// claimToken sets used_at on an unused token, so only one of several
+// concurrent requests can use it
+func claimToken[T any](ctx context.Context, r *userRepository, collection string, id uint) error {
+	var claimed int64
+	if database.IsMongoDB() {
+		result, err := r.mongoCollection(collection).UpdateOne(ctx,
+			bson.M{"_id": id, "used_at": nil},
+			bson.M{"$set": bson.M{"used_at": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+		claimed = result.MatchedCount
+	} else {
+		result := r.writeDB(ctx).Model(new(T)).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		claimed = result.RowsAffected
+	}
+
+	if claimed == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
@@ -16,7 +16,8 @@ mkdir -p grpc/proto
 protoc --go_out=. --go_opt=paths=source_relative \
     --go-grpc_out=. --go-grpc_opt=paths=source_relative \
     grpc/proto/user.proto \
-    grpc/proto/auth.proto
+    grpc/proto/auth.proto \
+    grpc/proto/version.proto
 
 if [ $? -eq 0 ]; then
     echo -e "${GREEN}Proto files generated successfully!${NC}"
@@ -33,6 +34,8 @@ echo "Checking generated files..."
 # - grpc/proto/user_grpc.pb.go
 # - grpc/proto/auth.pb.go
 # - grpc/proto/auth_grpc.pb.go
+# - grpc/proto/version.pb.go
+# - grpc/proto/version_grpc.pb.go
 
 if [ -f "grpc/proto/user.pb.go" ] && [ -f "grpc/proto/auth.pb.go" ]; then
     echo -e "${GREEN}All proto files generated correctly!${NC}"
@@ -1,13 +1,17 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"go-api-boilerplate/config"
 	"go-api-boilerplate/database"
+	"go-api-boilerplate/libraries"
 	"go-api-boilerplate/models"
 	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/repository"
 	"go-api-boilerplate/utils"
 )
 
@@ -21,19 +25,55 @@ var (
 // AuthService handles authentication logic
 type AuthService struct {
 	db    *database.DB
+	users repository.UserRepository
 	redis *RedisService
+	// events announces registrations; nil until SetEventBus
+	events *EventBus
+	// extraClaims supplies application claims for access tokens; nil until
+	// SetExtraClaims
+	extraClaims ExtraClaimsFunc
 }
 
+// ExtraClaimsFunc returns application-defined claims, such as a tenant ID,
+// to sign into a user's access token. The names of the standard claims are
+// reserved.
+type ExtraClaimsFunc func(ctx context.Context, user *models.User) (map[string]interface{}, error)
+
 // NewAuthService creates a new auth service
 func NewAuthService(db *database.DB, redis *RedisService) *AuthService {
+	if !redis.Available() {
+		logger.Warn("Redis unavailable: auth caching and token blacklisting are disabled")
+	}
+
 	return &AuthService{
 		db:    db,
+		users: repository.NewUserRepository(db),
 		redis: redis,
 	}
 }
 
+// SetEventBus publishes a UserEvent on bus for every registration
+func (s *AuthService) SetEventBus(bus *EventBus) {
+	s.events = bus
+}
+
+// SetExtraClaims signs the claims returned by fn into every access token
+// issued by GenerateTokens. Set it before serving requests.
+func (s *AuthService) SetExtraClaims(fn ExtraClaimsFunc) {
+	s.extraClaims = fn
+}
+
 // Register creates a new user account
-func (s *AuthService) Register(input *models.RegisterInput) (*models.User, error) {
+func (s *AuthService) Register(ctx context.Context, input *models.RegisterInput) (*models.User, error) {
+	email := utils.NormalizeEmail(input.Email)
+
+	// Check if user already exists, on the primary so that an account
+	// registered moments ago is seen even if the replica lags
+	ctx = database.ReadFromPrimary(ctx)
+	if err := checkEmailAvailable(ctx, s.users, email); err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := utils.HashPassword(input.Password)
 	if err != nil {
@@ -42,17 +82,28 @@ func (s *AuthService) Register(input *models.RegisterInput) (*models.User, error
 
 	// Create user
 	user := &models.User{
-		Email:    input.Email,
+		Email:    email,
 		Password: hashedPassword,
 		Name:     input.Name,
-		Role:     models.RoleUser,
+		Role:     config.Get().Auth.DefaultRole,
 	}
 
 	// Save to database
-	if err := s.db.Write.Create(user).Error; err != nil {
+	if err := s.users.Create(ctx, user); err != nil {
+		// A concurrent request can insert the same email between the
+		// existence check and this insert; the unique index catches it
+		if database.IsUniqueViolation(err) {
+			return nil, ErrUserAlreadyExists
+		}
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
+	if s.events != nil {
+		if err := s.events.Publish(TopicUserChanged, UserEvent{Action: UserEventCreated, User: *user}); err != nil {
+			logger.Warnf("Failed to announce registration of user %d: %v", user.ID, err)
+		}
+	}
+
 	// Send verification email (implement email service)
 	go s.sendVerificationEmail(user)
 
@@ -60,10 +111,10 @@ func (s *AuthService) Register(input *models.RegisterInput) (*models.User, error
 }
 
 // Login authenticates a user
-func (s *AuthService) Login(email, password, ipAddress string) (*models.User, error) {
+func (s *AuthService) Login(ctx context.Context, email, password, ipAddress string) (*models.User, error) {
 	// Find user by email
-	var user models.User
-	if err := s.db.Read.Where("email = ?", email).First(&user).Error; err != nil {
+	user, err := s.users.FindByEmail(ctx, utils.NormalizeEmail(email))
+	if err != nil {
 		return nil, ErrInvalidCredentials
 	}
 
@@ -77,26 +128,58 @@ func (s *AuthService) Login(email, password, ipAddress string) (*models.User, er
 		return nil, ErrUserNotActive
 	}
 
+	// Move the hash to the configured algorithm and cost while the password
+	// is known; the old hash keeps working if this fails
+	if utils.PasswordNeedsRehash(user.Password) {
+		s.upgradePasswordHash(ctx, user, password)
+	}
+
 	// Update last login
 	now := time.Now()
 	user.LastLoginAt = &now
-	s.db.Write.Save(&user)
+	if err := s.users.UpdateLastLogin(ctx, user.ID); err != nil {
+		logger.Warnf("Failed to record last login for user %d: %v", user.ID, err)
+	}
 
 	// Log login attempt (implement audit logging)
 	go s.logLoginAttempt(user.ID, ipAddress, true)
 
-	return &user, nil
+	return user, nil
+}
+
+// upgradePasswordHash re-hashes a verified password with the configured
+// algorithm and stores it
+func (s *AuthService) upgradePasswordHash(ctx context.Context, user *models.User, password string) {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		logger.Warnf("Failed to upgrade password hash for user %d: %v", user.ID, err)
+		return
+	}
+	if err := s.users.ChangePassword(ctx, user.ID, hashedPassword); err != nil {
+		logger.Warnf("Failed to upgrade password hash for user %d: %v", user.ID, err)
+		return
+	}
+	user.Password = hashedPassword
 }
 
 // GenerateTokens generates JWT tokens for a user
-func (s *AuthService) GenerateTokens(user *models.User) (*models.AuthTokens, error) {
+func (s *AuthService) GenerateTokens(ctx context.Context, user *models.User) (*models.AuthTokens, error) {
+	var extra map[string]interface{}
+	if s.extraClaims != nil {
+		var err error
+		if extra, err = s.extraClaims(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to build token claims: %w", err)
+		}
+	}
+
 	// Generate tokens
-	tokenPair, err := utils.GenerateTokens(
+	tokenPair, err := utils.GenerateTokensWithClaims(
 		user.ID,
 		user.Email,
 		user.Name,
 		user.Role,
 		user.IsActive,
+		extra,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tokens: %w", err)
@@ -104,14 +187,14 @@ func (s *AuthService) GenerateTokens(user *models.User) (*models.AuthTokens, err
 
 	// Store refresh token
 	user.RefreshToken = tokenPair.RefreshToken
-	if err := s.db.Write.Save(user).Error; err != nil {
+	if err := s.users.UpdatePartial(ctx, user.ID, map[string]any{"refresh_token": tokenPair.RefreshToken}); err != nil {
 		return nil, fmt.Errorf("failed to save refresh token: %w", err)
 	}
 
 	// Cache user data in Redis
-	if s.redis != nil {
+	if s.redis.Available() {
 		cacheKey := fmt.Sprintf("user:%d", user.ID)
-		s.redis.CacheSet("auth", cacheKey, user.ToResponse(), 24*time.Hour)
+		s.redis.WithContext(ctx).CacheSet("auth", cacheKey, user.ToResponse(), config.Get().UserCacheTTL())
 	}
 
 	return &models.AuthTokens{
@@ -123,21 +206,28 @@ func (s *AuthService) GenerateTokens(user *models.User) (*models.AuthTokens, err
 }
 
 // RefreshTokens refreshes authentication tokens
-func (s *AuthService) RefreshTokens(refreshToken string) (*models.AuthTokens, error) {
+func (s *AuthService) RefreshTokens(ctx context.Context, refreshToken string) (*models.AuthTokens, error) {
 	// Validate refresh token
-	userID, err := utils.ValidateRefreshToken(refreshToken)
+	claims, err := utils.ValidateRefreshTokenClaims(refreshToken)
 	if err != nil {
 		return nil, ErrInvalidToken
 	}
 
-	// Find user
-	var user models.User
-	if err := s.db.Read.First(&user, userID).Error; err != nil {
+	// Find user on the primary, which holds the latest stored token and
+	// revocation time
+	user, err := s.users.FindByID(database.ReadFromPrimary(ctx), claims.UserID)
+	if err != nil {
 		return nil, ErrInvalidToken
 	}
 
 	// Verify stored refresh token matches
-	if user.RefreshToken != refreshToken {
+	if user.RefreshToken == "" || user.RefreshToken != refreshToken {
+		return nil, ErrInvalidToken
+	}
+
+	// Reject refresh tokens issued before a logout-all, password change or
+	// reset, even should the stored token not have been cleared
+	if user.TokensValidAfter != nil && claims.IssuedAt.Before(*user.TokensValidAfter) {
 		return nil, ErrInvalidToken
 	}
 
@@ -147,41 +237,148 @@ func (s *AuthService) RefreshTokens(refreshToken string) (*models.AuthTokens, er
 	}
 
 	// Generate new tokens
-	return s.GenerateTokens(&user)
+	return s.GenerateTokens(ctx, user)
 }
 
 // Logout invalidates user tokens
-func (s *AuthService) Logout(userID uint, token string) error {
-	// Clear refresh token from database
-	if err := s.db.Write.Model(&models.User{}).Where("id = ?", userID).
-		Update("refresh_token", "").Error; err != nil {
+func (s *AuthService) Logout(ctx context.Context, userID uint, token string) error {
+	// Clear refresh token from database; a user deleted meanwhile has none
+	err := s.users.UpdatePartial(ctx, userID, map[string]any{"refresh_token": ""})
+	if err != nil && !errors.Is(err, libraries.ErrRecordNotFound) {
 		return fmt.Errorf("failed to clear refresh token: %w", err)
 	}
 
 	// Blacklist the access token in Redis
-	if s.redis != nil {
+	if s.redis.Available() {
 		// Parse token to get expiration
 		claims, _ := utils.ParseTokenWithoutValidation(token)
 		if claims != nil && claims.ExpiresAt != nil {
 			ttl := time.Until(claims.ExpiresAt.Time)
 			if ttl > 0 {
-				s.redis.CacheSet("blacklist", token, true, ttl)
+				s.redis.WithContext(ctx).CacheSet("blacklist", token, true, ttl)
 			}
 		}
 
 		// Clear user cache
 		cacheKey := fmt.Sprintf("user:%d", userID)
-		s.redis.CacheDelete("auth", cacheKey)
+		s.redis.WithContext(ctx).CacheDelete("auth", cacheKey)
+	} else {
+		logger.Warnf("Redis unavailable: access token for user %d stays valid until it expires", userID)
+	}
+
+	return nil
+}
+
+// LogoutAll signs the user out of every device by clearing the stored
+// refresh token and rejecting all access tokens issued up to now
+func (s *AuthService) LogoutAll(ctx context.Context, userID uint) error {
+	return s.revokeTokens(ctx, userID)
+}
+
+// InvalidateUser revokes the user's existing access and refresh tokens so a
+// role or status change applies to their next request, which must then be
+// made with a new token. Cached copies of the user are dropped by the
+// UserService method that made the change.
+func (s *AuthService) InvalidateUser(ctx context.Context, userID uint) error {
+	return s.revokeTokens(ctx, userID)
+}
+
+// revokeTokens clears the user's stored refresh token and records that
+// tokens issued up to now are no longer valid. The time is stored on the
+// user row and mirrored in Redis so the per-request check usually avoids a
+// database query.
+func (s *AuthService) revokeTokens(ctx context.Context, userID uint) error {
+	validAfter := revocationTime(time.Now())
+	err := s.users.UpdatePartial(ctx, userID, map[string]any{"refresh_token": "", "tokens_valid_after": validAfter})
+	if err != nil && !errors.Is(err, libraries.ErrRecordNotFound) {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+
+	if s.redis.Available() {
+		// Keep the marker until every token issued before it has expired
+		cfg := config.Get()
+		ttl := cfg.JWT.Expiry
+		if cfg.JWT.RefreshExpiry > ttl {
+			ttl = cfg.JWT.RefreshExpiry
+		}
+
+		key := fmt.Sprintf("user:%d", userID)
+		s.redis.WithContext(ctx).CacheSet("tokens_valid_after", key, validAfter.Format(time.RFC3339Nano), ttl)
+		s.redis.WithContext(ctx).CacheDelete("auth", key)
 	}
 
 	return nil
 }
 
+// revocationTime returns the tokens_valid_after time for a revocation at
+// now: the next whole millisecond, the precision of token issue times and of
+// every supported database. Tokens issued up to now fall strictly before it
+// even after rounding, while a sign-in moments later is valid again.
+func revocationTime(now time.Time) time.Time {
+	return now.Truncate(time.Millisecond).Add(time.Millisecond)
+}
+
+// IsTokenRevoked reports whether the token was issued before the user's
+// last logout-all, password change or reset
+func (s *AuthService) IsTokenRevoked(ctx context.Context, claims *utils.JWTClaims) bool {
+	if claims.IssuedAt == nil {
+		return false
+	}
+
+	validAfter, ok := s.tokensValidAfter(ctx, claims.UserID)
+	if !ok {
+		return false
+	}
+
+	return claims.IssuedAt.Before(validAfter)
+}
+
+// noRevocation is cached for users who never revoked their tokens
+const noRevocation = "0"
+
+// tokensValidAfter returns the user's revocation time. Redis is checked
+// first; on a miss the database value is cached.
+func (s *AuthService) tokensValidAfter(ctx context.Context, userID uint) (time.Time, bool) {
+	key := fmt.Sprintf("user:%d", userID)
+	if value, err := s.redis.WithContext(ctx).CacheGet("tokens_valid_after", key); err == nil {
+		if value == noRevocation {
+			return time.Time{}, false
+		}
+		// Values in another format were cached by an older release and are
+		// read again from the database
+		if validAfter, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return validAfter, true
+		}
+	}
+
+	// Read from the primary: a revocation must take effect at once, which a
+	// lagging replica cannot promise
+	user, err := s.users.Where("id", userID).Select("tokens_valid_after").First(database.ReadFromPrimary(ctx))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	cached := noRevocation
+	if user.TokensValidAfter != nil {
+		cached = user.TokensValidAfter.Format(time.RFC3339Nano)
+	}
+	if s.redis.Available() {
+		s.redis.WithContext(ctx).CacheSet("tokens_valid_after", key, cached, config.Get().JWT.Expiry)
+	}
+
+	if user.TokensValidAfter == nil {
+		return time.Time{}, false
+	}
+	return *user.TokensValidAfter, true
+}
+
 // ChangePassword changes user password
-func (s *AuthService) ChangePassword(userID uint, oldPassword, newPassword string) error {
-	// Find user
-	var user models.User
-	if err := s.db.Read.First(&user, userID).Error; err != nil {
+func (s *AuthService) ChangePassword(ctx context.Context, userID uint, oldPassword, newPassword string) error {
+	// Find user on the primary: the old password is checked against the
+	// hash a change moments ago may have replaced
+	ctx = database.ReadFromPrimary(ctx)
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
 		return fmt.Errorf("user not found: %w", err)
 	}
 
@@ -197,24 +394,23 @@ func (s *AuthService) ChangePassword(userID uint, oldPassword, newPassword strin
 	}
 
 	// Update password
-	user.Password = hashedPassword
-	if err := s.db.Write.Save(&user).Error; err != nil {
+	if err := s.users.ChangePassword(ctx, userID, hashedPassword); err != nil {
 		return fmt.Errorf("failed to update password: %w", err)
 	}
 
 	// Invalidate all tokens (force re-login)
-	if s.redis != nil {
-		s.redis.CacheDelete("auth", fmt.Sprintf("user:%d", userID))
+	if err := s.revokeTokens(ctx, userID); err != nil {
+		return err
 	}
 
 	return nil
 }
 
 // ForgotPassword initiates password reset process
-func (s *AuthService) ForgotPassword(email string) error {
+func (s *AuthService) ForgotPassword(ctx context.Context, email string) error {
 	// Find user by email
-	var user models.User
-	if err := s.db.Read.Where("email = ?", email).First(&user).Error; err != nil {
+	user, err := s.users.FindByEmail(ctx, utils.NormalizeEmail(email))
+	if err != nil {
 		// Don't reveal if user exists
 		return nil
 	}
@@ -222,34 +418,32 @@ func (s *AuthService) ForgotPassword(email string) error {
 	// Generate reset token
 	token := utils.GeneratePasswordResetToken()
 
-	// Save reset token
+	// Save reset token; only its hash is stored
 	resetRequest := &models.PasswordReset{
 		UserID:    user.ID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(1 * time.Hour),
+		Token:     utils.HashSHA256(token),
+		ExpiresAt: time.Now().Add(config.Get().Auth.PasswordResetTTL),
 	}
 
-	if err := s.db.Write.Create(resetRequest).Error; err != nil {
+	if err := s.users.CreatePasswordReset(ctx, resetRequest); err != nil {
 		return fmt.Errorf("failed to save reset token: %w", err)
 	}
 
 	// Send reset email
-	go s.sendPasswordResetEmail(&user, token)
+	go s.sendPasswordResetEmail(user, token)
 
 	return nil
 }
 
 // ResetPassword resets user password with token
-func (s *AuthService) ResetPassword(token, newPassword string) error {
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
 	// Find valid reset request
-	var resetRequest models.PasswordReset
-	if err := s.db.Read.Where("token = ? AND used_at IS NULL", token).
-		Preload("User").First(&resetRequest).Error; err != nil {
+	resetRequest, err := s.users.FindPasswordReset(ctx, utils.HashSHA256(token))
+	if err != nil {
 		return ErrInvalidToken
 	}
 
-	// Check if expired
-	if resetRequest.IsExpired() {
+	if resetRequest.IsUsed() || resetRequest.IsExpired() {
 		return ErrInvalidToken
 	}
 
@@ -260,62 +454,68 @@ func (s *AuthService) ResetPassword(token, newPassword string) error {
 	}
 
 	// Update password in transaction
-	tx := s.db.Write.Begin()
-
-	// Update user password
-	if err := tx.Model(&models.User{}).Where("id = ?", resetRequest.UserID).
-		Update("password", hashedPassword).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to update password: %w", err)
-	}
-
-	// Mark reset token as used
-	now := time.Now()
-	resetRequest.UsedAt = &now
-	if err := tx.Save(&resetRequest).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to update reset token: %w", err)
-	}
-
-	tx.Commit()
+	err = s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		// Claim the token first so concurrent requests cannot both use it
+		if err := claimToken(users.ClaimPasswordReset(ctx, resetRequest.ID)); err != nil {
+			return err
+		}
 
-	// Clear any cached data
-	if s.redis != nil {
-		s.redis.CacheDelete("auth", fmt.Sprintf("user:%d", resetRequest.UserID))
+		// Update user password
+		if err := users.ChangePassword(ctx, resetRequest.UserID, hashedPassword); err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// Invalidate all tokens issued before the reset
+	return s.revokeTokens(ctx, resetRequest.UserID)
 }
 
 // VerifyEmail verifies user email address
-func (s *AuthService) VerifyEmail(token string) error {
-	// This is a simplified implementation
-	// In production, you'd have a proper email verification token system
-
-	// Find user by verification token (implement proper token storage)
-	var user models.User
-	// This is placeholder logic - implement proper verification token lookup
-	if err := s.db.Write.Where("email_verified = false").First(&user).Error; err != nil {
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	// Find valid verification request
+	verification, err := s.users.FindEmailVerification(ctx, utils.HashSHA256(token))
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if verification.IsUsed() || verification.IsExpired() {
 		return ErrInvalidToken
 	}
 
 	// Mark email as verified
-	now := time.Now()
-	user.EmailVerified = true
-	user.EmailVerifiedAt = &now
+	err = s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		if err := claimToken(users.ClaimEmailVerification(ctx, verification.ID)); err != nil {
+			return err
+		}
 
-	if err := s.db.Write.Save(&user).Error; err != nil {
-		return fmt.Errorf("failed to verify email: %w", err)
+		if err := users.VerifyEmail(ctx, verification.UserID); err != nil {
+			return fmt.Errorf("failed to verify email: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drop the cached user so the new status is visible
+	if s.redis.Available() {
+		key := fmt.Sprintf("user:%d", verification.UserID)
+		s.redis.WithContext(ctx).CacheDelete("auth", key)
+		s.redis.WithContext(ctx).CacheDelete(staleCachePrefix, key)
 	}
 
 	return nil
 }
 
 // ValidateAccessToken validates an access token
-func (s *AuthService) ValidateAccessToken(token string) (*models.User, error) {
+func (s *AuthService) ValidateAccessToken(ctx context.Context, token string) (*models.User, error) {
 	// Check if token is blacklisted
-	if s.redis != nil {
-		blacklisted, err := s.redis.Exists(fmt.Sprintf("blacklist:%s", token))
+	if s.redis.Available() {
+		blacklisted, err := s.redis.WithContext(ctx).Exists(fmt.Sprintf("blacklist:%s", token))
 		if err == nil && blacklisted > 0 {
 			return nil, ErrInvalidToken
 		}
@@ -327,11 +527,16 @@ func (s *AuthService) ValidateAccessToken(token string) (*models.User, error) {
 		return nil, ErrInvalidToken
 	}
 
+	// Reject tokens issued before a logout-all or password change
+	if s.IsTokenRevoked(ctx, claims) {
+		return nil, ErrInvalidToken
+	}
+
 	// Try to get user from cache first
-	if s.redis != nil {
+	if s.redis.Available() {
 		cacheKey := fmt.Sprintf("user:%d", claims.UserID)
 		var userResp models.UserResponse
-		if err := s.redis.CacheGetJSON("auth", cacheKey, &userResp); err == nil {
+		if err := s.redis.WithContext(ctx).CacheGetJSON("auth", cacheKey, &userResp); err == nil {
 			// Convert response back to user (simplified)
 			return &models.User{
 				ID:            userResp.ID,
@@ -345,34 +550,117 @@ func (s *AuthService) ValidateAccessToken(token string) (*models.User, error) {
 	}
 
 	// Get from database
-	var user models.User
-	if err := s.db.Read.First(&user, claims.UserID).Error; err != nil {
+	user, err := s.users.FindByID(ctx, claims.UserID)
+	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
 
 	// Cache for future requests
-	if s.redis != nil {
+	if s.redis.Available() {
 		cacheKey := fmt.Sprintf("user:%d", user.ID)
-		s.redis.CacheSet("auth", cacheKey, user.ToResponse(), 24*time.Hour)
+		s.redis.WithContext(ctx).CacheSet("auth", cacheKey, user.ToResponse(), config.Get().UserCacheTTL())
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// CleanupExpiredTokens deletes password reset and email verification tokens
+// that have expired or been used, returning how many were removed
+func (s *AuthService) CleanupExpiredTokens(ctx context.Context) (int64, error) {
+	removed, err := s.users.DeleteSpentTokens(ctx, time.Now())
+	if err != nil {
+		return removed, fmt.Errorf("failed to clean up tokens: %w", err)
+	}
+	return removed, nil
+}
+
+// StartTokenCleanup runs CleanupExpiredTokens every interval until ctx is
+// done. An interval of 0 disables the cleanup. With Redis connected, a lock
+// held for the whole interval makes a single replica run each cleanup.
+func (s *AuthService) StartTokenCleanup(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// The lock is left to expire rather than released so replicas
+				// ticking later in the same interval skip the run
+				_, acquired, err := s.redis.WithContext(ctx).Lock("token_cleanup", interval)
+				if err == nil && !acquired {
+					continue
+				}
+				if err != nil && !errors.Is(err, ErrRedisUnavailable) {
+					logger.Warnf("Token cleanup lock failed, running locally: %v", err)
+				}
+
+				removed, err := s.CleanupExpiredTokens(ctx)
+				if err != nil {
+					logger.Warnf("Token cleanup failed: %v", err)
+				} else if removed > 0 {
+					logger.Infof("Removed %d expired or used tokens", removed)
+				}
+			}
+		}
+	}()
 }
 
 // Helper methods
 
+// claimToken reports the outcome of claiming a single-use token, failing
+// with ErrInvalidToken when another request already used it
+func claimToken(err error) error {
+	if errors.Is(err, repository.ErrTokenUsed) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update token: %w", err)
+	}
+	return nil
+}
+
+// SendVerificationEmails sends verification emails to users created outside
+// of registration, such as by a bulk import, in the background
+func (s *AuthService) SendVerificationEmails(users []models.User) {
+	go func() {
+		for i := range users {
+			s.sendVerificationEmail(&users[i])
+		}
+	}()
+}
+
 func (s *AuthService) sendVerificationEmail(user *models.User) {
 	// Implement email sending logic
 	// This would integrate with an email service like SendGrid, AWS SES, etc.
 	token := utils.GenerateEmailVerificationToken()
-	// Store token and send email
-	logger.Infof("Sending verification email to %s with token: %s", user.Email, token)
+
+	// Store the token hash; the plain token only goes out in the email
+	verification := &models.EmailVerification{
+		UserID:    user.ID,
+		Token:     utils.HashSHA256(token),
+		ExpiresAt: time.Now().Add(config.Get().Auth.EmailVerificationTTL),
+	}
+	if err := s.users.CreateEmailVerification(context.Background(), verification); err != nil {
+		logger.Warnf("Failed to save verification token for user %d: %v", user.ID, err)
+		return
+	}
+
+	// The token is a credential: it goes in the email, never in the logs
+	logger.Infof("Sending verification email to %s", user.Email)
 }
 
 func (s *AuthService) sendPasswordResetEmail(user *models.User, token string) {
-	// Implement email sending logic
-	resetURL := fmt.Sprintf("https://example.com/reset-password?token=%s", token)
-	logger.Infof("Sending password reset email to %s with URL: %s", user.Email, resetURL)
+	// Implement email sending logic, with a link such as
+	// https://example.com/reset-password?token=<token>. Like the
+	// verification token, the reset token must not be logged.
+	logger.Infof("Sending password reset email to %s", user.Email)
 }
 
 func (s *AuthService) logLoginAttempt(userID uint, ipAddress string, success bool) {
@@ -382,7 +670,7 @@ func (s *AuthService) logLoginAttempt(userID uint, ipAddress string, success boo
 
 // IsTokenBlacklisted checks if a token is blacklisted
 func (s *AuthService) IsTokenBlacklisted(token string) bool {
-	if s.redis == nil {
+	if !s.redis.Available() {
 		return false
 	}
 
@@ -0,0 +1,584 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/utils"
+)
+
+func newTestAuthService(t *testing.T) (*AuthService, *UserService) {
+	t.Helper()
+
+	loadTestConfig(t, nil)
+	db := newTestDB(t)
+	return NewAuthService(db, nil), NewUserService(db, nil)
+}
+
+func TestChangePasswordRevokesExistingTokens(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, auth.db, "change@example.com", "user")
+
+	tokens, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	if _, err := auth.ValidateAccessToken(ctx, tokens.AccessToken); err != nil {
+		t.Fatalf("access token rejected before the change: %v", err)
+	}
+
+	if err := auth.ChangePassword(ctx, user.ID, testPassword, "NewPassword456!"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	if _, err := auth.ValidateAccessToken(ctx, tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("old access token: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := auth.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("old refresh token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRefreshTokenIssuedBeforeRevocationIsRejected(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, auth.db, "revoked@example.com", "user")
+
+	tokens, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	if err := auth.LogoutAll(ctx, user.ID); err != nil {
+		t.Fatalf("LogoutAll: %v", err)
+	}
+
+	// Put the old token back, as a row restored from a backup would
+	if err := auth.db.Write.Model(user).Update("refresh_token", tokens.RefreshToken).Error; err != nil {
+		t.Fatalf("failed to restore refresh token: %v", err)
+	}
+	if _, err := auth.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("refresh token issued before logout-all: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestLogoutAllAllowsImmediateSignIn(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, auth.db, "logoutall@example.com", "user")
+
+	old, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	if err := auth.LogoutAll(ctx, user.ID); err != nil {
+		t.Fatalf("LogoutAll: %v", err)
+	}
+
+	// Sign in again within the same second as the revocation
+	signedIn, err := auth.Login(ctx, user.Email, testPassword, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	fresh, err := auth.GenerateTokens(ctx, signedIn)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+
+	if _, err := auth.ValidateAccessToken(ctx, old.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("access token from before logout-all: got %v, want ErrInvalidToken", err)
+	}
+	if _, err := auth.ValidateAccessToken(ctx, fresh.AccessToken); err != nil {
+		t.Errorf("access token from after logout-all rejected: %v", err)
+	}
+	if _, err := auth.RefreshTokens(ctx, fresh.RefreshToken); err != nil {
+		t.Errorf("refresh token from after logout-all rejected: %v", err)
+	}
+}
+
+func TestRevocationTimeIsAfterEarlierIssueTimes(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 999_999_999, time.UTC)
+	validAfter := revocationTime(now)
+
+	if !now.Truncate(time.Millisecond).Before(validAfter) {
+		t.Errorf("token issued at %v is not before %v", now, validAfter)
+	}
+	if validAfter.Sub(now) > time.Millisecond {
+		t.Errorf("revocation at %v lasts until %v", now, validAfter)
+	}
+}
+
+func TestResetPasswordTokens(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, auth.db, "reset@example.com", "user")
+
+	store := func(token string, expiresAt time.Time, used bool) {
+		t.Helper()
+		reset := &models.PasswordReset{UserID: user.ID, Token: utils.HashSHA256(token), ExpiresAt: expiresAt}
+		if used {
+			usedAt := time.Now()
+			reset.UsedAt = &usedAt
+		}
+		if err := auth.db.Write.Create(reset).Error; err != nil {
+			t.Fatalf("failed to store reset token: %v", err)
+		}
+	}
+	store("expired-token", time.Now().Add(-time.Minute), false)
+	store("used-token", time.Now().Add(time.Hour), true)
+	store("valid-token", time.Now().Add(time.Hour), false)
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", "expired-token", ErrInvalidToken},
+		{"already used", "used-token", ErrInvalidToken},
+		{"unknown", "unknown-token", ErrInvalidToken},
+		{"valid", "valid-token", nil},
+		{"valid token used twice", "valid-token", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		if err := auth.ResetPassword(ctx, tt.token, "NewPassword456!"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := auth.Login(ctx, user.Email, "NewPassword456!", "127.0.0.1"); err != nil {
+		t.Errorf("login with the reset password: %v", err)
+	}
+}
+
+func TestVerifyEmailTokens(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, auth.db, "verify@example.com", "user")
+
+	store := func(token string, expiresAt time.Time) {
+		t.Helper()
+		verification := &models.EmailVerification{UserID: user.ID, Token: utils.HashSHA256(token), ExpiresAt: expiresAt}
+		if err := auth.db.Write.Create(verification).Error; err != nil {
+			t.Fatalf("failed to store verification token: %v", err)
+		}
+	}
+	store("expired-token", time.Now().Add(-time.Minute))
+	store("valid-token", time.Now().Add(time.Hour))
+
+	if err := auth.VerifyEmail(ctx, "expired-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expired token: got %v, want ErrInvalidToken", err)
+	}
+	if err := auth.VerifyEmail(ctx, "valid-token"); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	if err := auth.VerifyEmail(ctx, "valid-token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("reused token: got %v, want ErrInvalidToken", err)
+	}
+
+	var verified models.User
+	if err := auth.db.Write.First(&verified, user.ID).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if !verified.EmailVerified {
+		t.Error("email is not marked verified")
+	}
+}
+
+func TestCleanupExpiredTokens(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	user := createTestUser(t, auth.db, "cleanup@example.com", "user")
+
+	usedAt := time.Now()
+	resets := []models.PasswordReset{
+		{UserID: user.ID, Token: "a", ExpiresAt: time.Now().Add(-time.Minute)},
+		{UserID: user.ID, Token: "b", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt},
+		{UserID: user.ID, Token: "c", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	if err := auth.db.Write.Create(&resets).Error; err != nil {
+		t.Fatalf("failed to store reset tokens: %v", err)
+	}
+
+	removed, err := auth.CleanupExpiredTokens(context.Background())
+	if err != nil {
+		t.Fatalf("CleanupExpiredTokens: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d tokens, want 2", removed)
+	}
+}
+
+func TestRegisterWithEmailOfDeletedUser(t *testing.T) {
+	auth, users := newTestAuthService(t)
+	ctx := context.Background()
+	admin := createTestUser(t, auth.db, "admin@example.com", models.RoleAdmin)
+	user := createTestUser(t, auth.db, "gone@example.com", models.RoleUser)
+
+	if err := users.Delete(ctx, admin.ID, user.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	input := &models.RegisterInput{Email: "Gone@Example.com", Password: testPassword, ConfirmPassword: testPassword, Name: "Again"}
+	_, err := auth.Register(ctx, input)
+	if !errors.Is(err, ErrAccountDeleted) || !errors.Is(err, ErrUserAlreadyExists) {
+		t.Errorf("Register: got %v, want ErrAccountDeleted", err)
+	}
+
+	if _, err := users.Create(ctx, &models.CreateUserInput{Email: user.Email, Password: testPassword, Name: "Again"}); !errors.Is(err, ErrAccountDeleted) {
+		t.Errorf("Create: got %v, want ErrAccountDeleted", err)
+	}
+
+	// Once the account is gone for good the email is free again
+	if err := users.ForceDelete(ctx, admin.ID, user.ID); err != nil {
+		t.Fatalf("ForceDelete: %v", err)
+	}
+	if _, err := auth.Register(ctx, input); err != nil {
+		t.Errorf("Register after ForceDelete: %v", err)
+	}
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	auth, users := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, auth.db, "upgrade@example.com", "user")
+	password := config.Get().Password
+
+	storedHash := func() string {
+		t.Helper()
+		found, err := users.FindByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("FindByID: %v", err)
+		}
+		return found.Password
+	}
+	if hash := storedHash(); !strings.HasPrefix(hash, "$2a$") {
+		t.Fatalf("stored hash %q is not bcrypt", hash)
+	}
+
+	tests := []struct {
+		name       string
+		configure  func()
+		wantPrefix string
+	}{
+		{"bcrypt to argon2id", func() {
+			password.Hash = utils.PasswordHashArgon2id
+			password.Argon2Memory, password.Argon2Iterations, password.Argon2Parallelism = 1024, 1, 1
+		}, "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{"more argon2id iterations", func() {
+			password.Argon2Iterations = 2
+		}, "$argon2id$v=19$m=1024,t=2,p=1$"},
+		{"argon2id back to bcrypt", func() {
+			password.Hash = utils.PasswordHashBcrypt
+			password.BcryptCost = 5
+		}, "$2a$05$"},
+		{"higher bcrypt cost", func() {
+			password.BcryptCost = 6
+		}, "$2a$06$"},
+	}
+	for _, tt := range tests {
+		tt.configure()
+		config.Get().Password = password
+
+		if _, err := auth.Login(ctx, user.Email, testPassword, "127.0.0.1"); err != nil {
+			t.Fatalf("%s: Login: %v", tt.name, err)
+		}
+		upgraded := storedHash()
+		if !strings.HasPrefix(upgraded, tt.wantPrefix) {
+			t.Errorf("%s: stored hash %q, want prefix %q", tt.name, upgraded, tt.wantPrefix)
+		}
+
+		// The upgraded hash verifies and is kept on the next login
+		if _, err := auth.Login(ctx, user.Email, testPassword, "127.0.0.1"); err != nil {
+			t.Fatalf("%s: Login with the upgraded hash: %v", tt.name, err)
+		}
+		if hash := storedHash(); hash != upgraded {
+			t.Errorf("%s: hash changed again on the next login", tt.name)
+		}
+	}
+}
+
+func TestGenerateTokensSignsExtraClaims(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, auth.db, "tenant@example.com", "user")
+
+	auth.SetExtraClaims(func(_ context.Context, user *models.User) (map[string]interface{}, error) {
+		return map[string]interface{}{"tenant_id": fmt.Sprintf("tenant-%d", user.ID)}, nil
+	})
+
+	tokens, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	refreshed, err := auth.RefreshTokens(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshTokens: %v", err)
+	}
+
+	want := fmt.Sprintf("tenant-%d", user.ID)
+	for name, token := range map[string]string{"issued": tokens.AccessToken, "refreshed": refreshed.AccessToken} {
+		claims, err := utils.ValidateToken(token)
+		if err != nil {
+			t.Fatalf("%s: ValidateToken: %v", name, err)
+		}
+		if tenant, _ := claims.ExtraString("tenant_id"); tenant != want || claims.UserID != user.ID {
+			t.Errorf("%s: tenant_id %q for user %d, want %q for user %d", name, tenant, claims.UserID, want, user.ID)
+		}
+	}
+
+	failed := errors.New("tenant lookup failed")
+	auth.SetExtraClaims(func(context.Context, *models.User) (map[string]interface{}, error) { return nil, failed })
+	if _, err := auth.GenerateTokens(ctx, user); !errors.Is(err, failed) {
+		t.Errorf("failing claims: got %v, want the error of the claims func", err)
+	}
+}
+
+func TestAuthWithoutRedis(t *testing.T) {
+	tests := []struct {
+		name  string
+		redis func(t *testing.T) *RedisService
+	}{
+		{"no Redis", func(t *testing.T) *RedisService { return nil }},
+		{"Redis stopped", func(t *testing.T) *RedisService {
+			redis, server := newTestRedis(t)
+			server.Close()
+			return redis
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, nil)
+			db := newTestDB(t)
+			auth := NewAuthService(db, tt.redis(t))
+			ctx := context.Background()
+			created := createTestUser(t, db, "noredis@example.com", "user")
+
+			user, err := auth.Login(ctx, created.Email, testPassword, "127.0.0.1")
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			tokens, err := auth.GenerateTokens(ctx, user)
+			if err != nil {
+				t.Fatalf("GenerateTokens: %v", err)
+			}
+			if _, err := auth.ValidateAccessToken(ctx, tokens.AccessToken); err != nil {
+				t.Fatalf("ValidateAccessToken: %v", err)
+			}
+
+			refreshed, err := auth.RefreshTokens(ctx, tokens.RefreshToken)
+			if err != nil {
+				t.Fatalf("RefreshTokens: %v", err)
+			}
+			if _, err := auth.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("replayed refresh token: got %v, want ErrInvalidToken", err)
+			}
+
+			if err := auth.Logout(ctx, user.ID, refreshed.AccessToken); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+			if _, err := auth.RefreshTokens(ctx, refreshed.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("refresh token after logout: got %v, want ErrInvalidToken", err)
+			}
+			// Without a blacklist the access token stays valid until it
+			// expires, as documented
+			if _, err := auth.ValidateAccessToken(ctx, refreshed.AccessToken); err != nil {
+				t.Errorf("access token after logout without Redis: %v", err)
+			}
+
+			// Revoking every token is recorded in the database and still
+			// applies
+			if err := auth.LogoutAll(ctx, user.ID); err != nil {
+				t.Fatalf("LogoutAll: %v", err)
+			}
+			if _, err := auth.ValidateAccessToken(ctx, refreshed.AccessToken); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("access token after logout-all: got %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestRegisterNormalizesEmail(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		first     string
+		second    string
+		wantStore string
+	}{
+		{"case", nil, "user@example.com", "User@Example.COM", "user@example.com"},
+		{"surrounding space", nil, "Space@Example.com", "  space@example.com ", "space@example.com"},
+		{"Gmail aliases", map[string]string{"AUTH_EMAIL_STRIP_GMAIL_ALIASES": "true"}, "First.Last@gmail.com", "firstlast+promo@Gmail.com", "firstlast@gmail.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, tt.env)
+			auth := NewAuthService(newTestDB(t), nil)
+			ctx := context.Background()
+
+			input := &models.RegisterInput{Email: tt.first, Password: testPassword, ConfirmPassword: testPassword, Name: "First"}
+			user, err := auth.Register(ctx, input)
+			if err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			if user.Email != tt.wantStore {
+				t.Errorf("stored email = %q, want %q", user.Email, tt.wantStore)
+			}
+
+			input = &models.RegisterInput{Email: tt.second, Password: testPassword, ConfirmPassword: testPassword, Name: "Second"}
+			if _, err := auth.Register(ctx, input); !errors.Is(err, ErrUserAlreadyExists) {
+				t.Errorf("Register %q after %q: got %v, want ErrUserAlreadyExists", tt.second, tt.first, err)
+			}
+			if _, err := auth.Login(ctx, tt.second, testPassword, "127.0.0.1"); err != nil {
+				t.Errorf("Login as %q: %v", tt.second, err)
+			}
+		})
+	}
+}
+
+func TestLogoutBlacklistsOnlyUnexpiredTokens(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+	db := newTestDB(t)
+	auth := NewAuthService(db, redis)
+	ctx := context.Background()
+	user := createTestUser(t, db, "logout@example.com", "user")
+
+	valid, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, utils.JWTClaims{
+		UserID:   user.ID,
+		IsActive: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   fmt.Sprint(user.ID),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+	}).SignedString([]byte(config.Get().JWT.Secret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		token           string
+		wantBlacklisted bool
+	}{
+		{"empty", "", false},
+		{"garbage", "garbage", false},
+		{"garbage segments", "a.b.c", false},
+		{"expired token", expired, false},
+		{"valid token", valid.AccessToken, true},
+	}
+	for _, tt := range tests {
+		if err := auth.Logout(ctx, user.ID, tt.token); err != nil {
+			t.Errorf("%s: Logout: %v", tt.name, err)
+		}
+		if got := server.Exists("blacklist:" + tt.token); got != tt.wantBlacklisted {
+			t.Errorf("%s: blacklisted = %v, want %v", tt.name, got, tt.wantBlacklisted)
+		}
+	}
+}
+
+func TestAuthServiceHonoursCancelledContext(t *testing.T) {
+	auth, _ := newTestAuthService(t)
+	user := createTestUser(t, auth.db, "cancel@example.com", "user")
+	tokens, err := auth.GenerateTokens(context.Background(), user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{"Register", func() error {
+			_, err := auth.Register(ctx, &models.RegisterInput{Email: "new@example.com", Password: testPassword, Name: "New"})
+			return err
+		}},
+		{"ChangePassword", func() error { return auth.ChangePassword(ctx, user.ID, testPassword, "NewPassword456!") }},
+		{"ValidateAccessToken", func() error {
+			_, err := auth.ValidateAccessToken(ctx, tokens.AccessToken)
+			return err
+		}},
+		{"GenerateTokens", func() error {
+			_, err := auth.GenerateTokens(ctx, user)
+			return err
+		}},
+		{"LogoutAll", func() error { return auth.LogoutAll(ctx, user.ID) }},
+	}
+	for _, tt := range tests {
+		if err := tt.run(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with a cancelled context: %v, want context.Canceled", tt.name, err)
+		}
+	}
+
+	// Login and RefreshTokens do not say why they failed
+	if _, err := auth.Login(ctx, user.Email, testPassword, "127.0.0.1"); err == nil {
+		t.Error("Login succeeded with a cancelled context")
+	}
+	if _, err := auth.RefreshTokens(ctx, tokens.RefreshToken); err == nil {
+		t.Error("RefreshTokens succeeded with a cancelled context")
+	}
+
+	// None of the cancelled calls changed anything
+	var count int64
+	auth.db.Write.Model(&models.User{}).Where("email = ?", "new@example.com").Count(&count)
+	if count != 0 {
+		t.Error("Register created the user after the context was cancelled")
+	}
+	background := context.Background()
+	if _, err := auth.Login(background, user.Email, testPassword, "127.0.0.1"); err != nil {
+		t.Errorf("password changed by the cancelled call: %v", err)
+	}
+	if _, err := auth.RefreshTokens(background, tokens.RefreshToken); err != nil {
+		t.Errorf("refresh token consumed by the cancelled calls: %v", err)
+	}
+}
+
+func TestUserCacheExpiresWithAccessToken(t *testing.T) {
+	loadTestConfig(t, map[string]string{"AUTH_CACHE_TTL": "24h", "JWT_EXPIRY": "10m"})
+	redis, server := newTestRedis(t)
+	db := newTestDB(t)
+	auth := NewAuthService(db, redis)
+	ctx := context.Background()
+	user := createTestUser(t, db, "cache@example.com", "user")
+
+	// cacheTTL returns the TTL of the user's cache entry
+	cacheTTL := func() time.Duration {
+		for _, key := range server.Keys() {
+			if strings.HasSuffix(key, fmt.Sprintf("auth:user:%d", user.ID)) {
+				return server.TTL(key)
+			}
+		}
+		t.Fatal("user data was not cached")
+		return 0
+	}
+
+	tokens, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	if got := cacheTTL(); got <= 0 || got > 10*time.Minute {
+		t.Errorf("cached by GenerateTokens for %v, want at most JWT_EXPIRY", got)
+	}
+
+	// A cache miss on validation caches the user again under the same cap
+	server.FlushAll()
+	if _, err := auth.ValidateAccessToken(ctx, tokens.AccessToken); err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if got := cacheTTL(); got <= 0 || got > 10*time.Minute {
+		t.Errorf("cached by ValidateAccessToken for %v, want at most JWT_EXPIRY", got)
+	}
+}
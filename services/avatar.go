@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"go-api-boilerplate/config"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+)
+
+// ErrAvatarNotImage is returned when an uploaded avatar cannot be decoded
+// as an image
+var ErrAvatarNotImage = errors.New("avatar could not be decoded as an image")
+
+// avatarJPEGQuality is the quality of JPEG avatar variants
+const avatarJPEGQuality = 85
+
+// AvatarUpload is a stored avatar and the URLs of its resized variants,
+// keyed by UPLOAD_AVATAR_SIZES name
+type AvatarUpload struct {
+	URL      string            `json:"url"`
+	Variants map[string]string `json:"variants"`
+}
+
+// UploadAvatar stores an uploaded avatar under the avatar upload policy and
+// writes a resized copy of it for every UPLOAD_AVATAR_SIZES entry. Images
+// are only ever scaled down. When a variant cannot be made, the files
+// already written are removed again. The upload slot is held until the
+// variants are written too.
+func (s *UploadService) UploadAvatar(c *gin.Context, formField string) (*AvatarUpload, error) {
+	release, err := s.acquireUploadSlot(c)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	info, err := s.uploadFile(c, formField, UploadPolicyAvatar)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := s.createAvatarVariants(info.Path, uploaderOf(c, UploadPolicyAvatar))
+	if err != nil {
+		s.DeleteFile(info.Path)
+		return nil, err
+	}
+
+	return &AvatarUpload{URL: info.URL, Variants: variants}, nil
+}
+
+// createAvatarVariants decodes the image at srcPath and writes one scaled
+// copy per configured size. JPEG sources give JPEG variants; other formats
+// give PNG so transparency is kept. Variants are recorded against owner
+// like the original.
+func (s *UploadService) createAvatarVariants(srcPath string, owner fileOwner) (map[string]string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open avatar: %w", err)
+	}
+	img, format, err := image.Decode(src)
+	src.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAvatarNotImage, err)
+	}
+
+	ext, mimeType := ".png", "image/png"
+	if format == "jpeg" {
+		ext, mimeType = ".jpg", "image/jpeg"
+	}
+
+	uploadPath := s.getUploadPath()
+	if err := os.MkdirAll(uploadPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	variants := make(map[string]string, len(s.config.Upload.AvatarSizes))
+	written := make([]string, 0, len(s.config.Upload.AvatarSizes))
+	for _, size := range s.config.Upload.AvatarSizes {
+		// Variants get their own names: a deduplicated original may be
+		// shared by several of the user's avatars, its variants never are
+		variantPath := filepath.Join(uploadPath, s.generateUniqueFilename("_"+size.Name+ext))
+		err := writeAvatarVariant(variantPath, scaleToFit(img, size), format)
+		if err == nil && s.tracksFiles() {
+			err = s.recordFile(variantPath, mimeType, ext, owner)
+		}
+		if err != nil {
+			for _, path := range written {
+				s.DeleteFile(path)
+			}
+			return nil, fmt.Errorf("failed to write %s avatar: %w", size.Name, err)
+		}
+		written = append(written, variantPath)
+		variants[size.Name] = s.getFileURL(variantPath)
+	}
+
+	return variants, nil
+}
+
+// scaleToFit scales img down to fit in a size.Size square, keeping its
+// aspect ratio. Smaller images are copied at their own size.
+func scaleToFit(img image.Image, size config.AvatarSize) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size.Size || height > size.Size {
+		if width >= height {
+			height = max(1, height*size.Size/width)
+			width = size.Size
+		} else {
+			width = max(1, width*size.Size/height)
+			height = size.Size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// writeAvatarVariant encodes img to path, as JPEG for JPEG sources and PNG
+// otherwise
+func writeAvatarVariant(path string, img image.Image, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if format == "jpeg" {
+		err = jpeg.Encode(file, img, &jpeg.Options{Quality: avatarJPEGQuality})
+	} else {
+		err = png.Encode(file, img)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
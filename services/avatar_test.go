@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-api-boilerplate/models"
+)
+
+// testImage returns a width x height image encoded as format, jpeg or png
+func testImage(t *testing.T, width, height int, format string) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+// avatarPaths returns the paths of an avatar's original and variants
+func avatarPaths(s *UploadService, avatar *AvatarUpload) []string {
+	paths := []string{filepath.Join(s.config.Upload.Path, strings.TrimPrefix(avatar.URL, "/uploads/"))}
+	for _, url := range avatar.Variants {
+		paths = append(paths, filepath.Join(s.config.Upload.Path, strings.TrimPrefix(url, "/uploads/")))
+	}
+	return paths
+}
+
+// assertFiles fails unless every path exists when want is true, or none
+// does when it is false
+func assertFiles(t *testing.T, what string, paths []string, want bool) {
+	t.Helper()
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: %s exists: %v, want %v", what, filepath.Base(path), err == nil, want)
+		}
+	}
+}
+
+func TestUploadAvatarCreatesVariants(t *testing.T) {
+	s := newTestUploadService(t, map[string]string{"UPLOAD_AVATAR_SIZES": "small:16,large:64"})
+
+	type size struct{ width, height int }
+	tests := []struct {
+		name       string
+		content    []byte
+		wantFormat string
+		want       map[string]size
+	}{
+		{"wide PNG", testImage(t, 100, 50, "png"), "png", map[string]size{"small": {16, 8}, "large": {64, 32}}},
+		{"tall JPEG", testImage(t, 40, 80, "jpeg"), "jpeg", map[string]size{"small": {8, 16}, "large": {32, 64}}},
+		{"small PNG not scaled up", testImage(t, 10, 12, "png"), "png", map[string]size{"small": {10, 12}, "large": {10, 12}}},
+	}
+	for _, tt := range tests {
+		avatar, err := s.UploadAvatar(uploadTestContext(t, 1, "avatar", tt.content), "file")
+		if err != nil {
+			t.Fatalf("%s: UploadAvatar: %v", tt.name, err)
+		}
+		if len(avatar.Variants) != len(tt.want) {
+			t.Errorf("%s: variants %v, want %d", tt.name, avatar.Variants, len(tt.want))
+		}
+
+		for name, want := range tt.want {
+			url, ok := avatar.Variants[name]
+			if !ok || url == avatar.URL {
+				t.Errorf("%s: %s variant URL = %q", tt.name, name, url)
+				continue
+			}
+			file, err := os.Open(filepath.Join(s.config.Upload.Path, strings.TrimPrefix(url, "/uploads/")))
+			if err != nil {
+				t.Errorf("%s: %s variant not stored: %v", tt.name, name, err)
+				continue
+			}
+			cfg, format, err := image.DecodeConfig(file)
+			file.Close()
+			if err != nil || format != tt.wantFormat || cfg.Width != want.width || cfg.Height != want.height {
+				t.Errorf("%s: %s variant is a %dx%d %s (%v), want %dx%d %s",
+					tt.name, name, cfg.Width, cfg.Height, format, err, want.width, want.height, tt.wantFormat)
+			}
+		}
+	}
+
+	var recorded int64
+	s.db.Write.Model(&models.StoredFile{}).Where("user_id = ?", 1).Count(&recorded)
+	if want := int64(len(tests) * 3); recorded != want {
+		t.Errorf("%d stored files recorded, want %d: the originals and every variant", recorded, want)
+	}
+}
+
+func TestUploadAvatarRejectsUndecodableImage(t *testing.T) {
+	s := newTestUploadService(t, nil)
+
+	// A PNG header passes the type and dimension checks but cannot be
+	// decoded
+	_, err := s.UploadAvatar(uploadTestContext(t, 1, "avatar.png", pngHeader(8, 8)), "file")
+	if !errors.Is(err, ErrAvatarNotImage) {
+		t.Fatalf("UploadAvatar = %v, want ErrAvatarNotImage", err)
+	}
+	if count := storedFiles(t, s); count != 0 {
+		t.Errorf("%d files left behind by the rejected upload", count)
+	}
+	var recorded int64
+	s.db.Write.Model(&models.StoredFile{}).Count(&recorded)
+	if recorded != 0 {
+		t.Errorf("%d stored file rows left behind by the rejected upload", recorded)
+	}
+}
+
+func TestAvatarFilesRemovedWhenReplaced(t *testing.T) {
+	_, users := newTestAuthService(t)
+	uploads := NewUploadService(users.db)
+	users.SetFileStore(uploads)
+	ctx := context.Background()
+	user := createTestUser(t, users.db, "avatar@example.com", models.RoleUser)
+
+	setAvatar := func(seed int) *AvatarUpload {
+		t.Helper()
+
+		avatar, err := uploads.UploadAvatar(uploadTestContext(t, user.ID, "avatar.png", testImage(t, 100+seed, 100, "png")), "file")
+		if err != nil {
+			t.Fatalf("UploadAvatar: %v", err)
+		}
+		updated, err := users.SetAvatar(ctx, user.ID, avatar)
+		if err != nil {
+			t.Fatalf("SetAvatar: %v", err)
+		}
+		if response := updated.ToResponse(); response.Avatar != avatar.URL || len(response.AvatarVariants) != 3 {
+			t.Errorf("response avatar %q with variants %v, want %q and 3 variants", response.Avatar, response.AvatarVariants, avatar.URL)
+		}
+		return avatar
+	}
+
+	first := setAvatar(1)
+	assertFiles(t, "first avatar", avatarPaths(uploads, first), true)
+
+	second := setAvatar(2)
+	assertFiles(t, "replaced avatar", avatarPaths(uploads, first), false)
+	assertFiles(t, "new avatar", avatarPaths(uploads, second), true)
+
+	// Pointing the avatar at a URL drops the uploaded files and variants
+	updated, err := users.Update(ctx, user.ID, &models.UpdateUserInput{Avatar: "https://cdn.example.com/a.png"})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	assertFiles(t, "avatar replaced by a URL", avatarPaths(uploads, second), false)
+	if updated.AvatarVariants != nil {
+		t.Errorf("variants after Update = %v, want none", updated.AvatarVariants)
+	}
+
+	third := setAvatar(3)
+	cleared := ""
+	if _, err := users.Patch(ctx, user.ID, &models.PatchProfileInput{Avatar: &cleared}); err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	assertFiles(t, "cleared avatar", avatarPaths(uploads, third), false)
+
+	stored, err := users.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if stored.Avatar != "" || len(stored.AvatarVariants) != 0 {
+		t.Errorf("stored avatar %q with variants %v, want none", stored.Avatar, stored.AvatarVariants)
+	}
+	if count := storedFiles(t, uploads); count != 0 {
+		t.Errorf("%d files left after the avatar was cleared", count)
+	}
+}
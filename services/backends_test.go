@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/repository"
+	"go-api-boilerplate/utils"
+)
+
+// forEachBackend runs test against SQLite and, when MONGODB_TEST_URI names
+// a MongoDB server, against a throwaway database on it. The services are
+// built on the connected database; users must be created through them, as
+// test helpers writing with GORM do not reach MongoDB.
+func forEachBackend(t *testing.T, test func(t *testing.T, auth *AuthService, users *UserService)) {
+	t.Run("sqlite", func(t *testing.T) {
+		auth, users := newTestAuthService(t)
+		test(t, auth, users)
+	})
+
+	t.Run("mongodb", func(t *testing.T) {
+		uri := os.Getenv("MONGODB_TEST_URI")
+		if uri == "" {
+			t.Skip("MONGODB_TEST_URI is not set")
+		}
+
+		loadTestConfig(t, map[string]string{
+			"DB_DRIVER":        "mongodb",
+			"MONGODB_URI":      uri,
+			"MONGODB_DATABASE": fmt.Sprintf("boilerplate_test_%d", time.Now().UnixNano()),
+		})
+		db := newTestDB(t)
+		t.Cleanup(func() { db.MongoDB.Drop(context.Background()) })
+
+		test(t, NewAuthService(db, nil), NewUserService(db, nil))
+	})
+}
+
+// countAuditLogs counts the audit log entries recording action
+func countAuditLogs(t *testing.T, db *database.DB, action string) int64 {
+	t.Helper()
+
+	var count int64
+	var err error
+	if database.IsMongoDB() {
+		count, err = db.MongoDB.Collection("audit_logs").CountDocuments(context.Background(), bson.M{"action": action})
+	} else {
+		err = db.Write.Model(&models.AuditLog{}).Where("action = ?", action).Count(&count).Error
+	}
+	if err != nil {
+		t.Fatalf("failed to count audit log entries: %v", err)
+	}
+	return count
+}
+
+func TestUserServiceBackends(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, _ *AuthService, users *UserService) {
+		ctx := context.Background()
+
+		create := func(email, name, role string) *models.User {
+			t.Helper()
+			user, err := users.Create(ctx, &models.CreateUserInput{Email: email, Password: testPassword, Name: name, Role: role})
+			if err != nil {
+				t.Fatalf("Create %s: %v", email, err)
+			}
+			return user
+		}
+		admin := create("admin@example.com", "Ada Admin", models.RoleAdmin)
+		alice := create("alice@example.com", "Alice", models.RoleUser)
+		bob := create("bob@example.com", "Bob", models.RoleUser)
+		if admin.ID == 0 || alice.ID == admin.ID || bob.ID == alice.ID {
+			t.Fatalf("IDs are not assigned: %d, %d, %d", admin.ID, alice.ID, bob.ID)
+		}
+
+		if _, err := users.Create(ctx, &models.CreateUserInput{Email: "Alice@Example.com", Password: testPassword, Name: "Again"}); !errors.Is(err, ErrUserAlreadyExists) {
+			t.Errorf("duplicate email: got %v, want ErrUserAlreadyExists", err)
+		}
+
+		meta, page, err := users.FindPaginated(ctx, 1, 1, &UserFilter{Role: models.RoleUser, SortBy: "email", SortOrder: "asc"})
+		if err != nil {
+			t.Fatalf("FindPaginated: %v", err)
+		}
+		if meta.Total != 2 || len(page) != 1 || page[0].ID != alice.ID {
+			t.Errorf("first page of users: total %d, %d users, want 2 and alice first", meta.Total, len(page))
+		}
+		_, page, err = users.FindPaginated(ctx, 2, 1, &UserFilter{Role: models.RoleUser, SortBy: "email", SortOrder: "asc"})
+		if err != nil || len(page) != 1 || page[0].ID != bob.ID {
+			t.Errorf("second page of users: %v, %d users, want bob", err, len(page))
+		}
+		_, page, err = users.FindPaginated(ctx, 1, 10, &UserFilter{Search: "ALI"})
+		if err != nil || len(page) != 1 || page[0].ID != alice.ID {
+			t.Errorf("search: %v, %d users, want alice only", err, len(page))
+		}
+
+		var streamed []string
+		err = users.StreamUsers(ctx, &UserFilter{SortBy: "id", SortOrder: "asc"}, func(user *models.User) error {
+			streamed = append(streamed, user.Email)
+			return nil
+		})
+		if err != nil || strings.Join(streamed, ",") != "admin@example.com,alice@example.com,bob@example.com" {
+			t.Errorf("StreamUsers: %v, got %v", err, streamed)
+		}
+
+		updated, err := users.UpdateUserRole(ctx, admin.ID, alice.ID, models.RoleModerator)
+		if err != nil || updated.Role != models.RoleModerator {
+			t.Fatalf("UpdateUserRole: %v", err)
+		}
+		if found, err := users.FindByID(ctx, alice.ID); err != nil || found.Role != models.RoleModerator {
+			t.Errorf("role after UpdateUserRole: %v", err)
+		}
+		if n := countAuditLogs(t, users.db, models.AuditActionUserRoleChanged); n != 1 {
+			t.Errorf("role changes audited: %d, want 1", n)
+		}
+
+		if err := users.Delete(ctx, admin.ID, bob.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := users.FindByID(ctx, bob.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("deleted user: got %v, want ErrUserNotFound", err)
+		}
+		_, trashed, err := users.FindTrashed(ctx, 1, 10)
+		if err != nil || len(trashed) != 1 || trashed[0].ID != bob.ID {
+			t.Errorf("FindTrashed: %v, %d users, want bob", err, len(trashed))
+		}
+
+		summary, err := users.Summary(ctx)
+		if err != nil {
+			t.Fatalf("Summary: %v", err)
+		}
+		if summary.Total != 2 || summary.Active != 2 || summary.NewLast7Days != 2 || summary.ByRole[models.RoleModerator] != 1 {
+			t.Errorf("Summary = %+v, want 2 live users with one moderator", summary)
+		}
+
+		if _, err := users.Restore(ctx, admin.ID, bob.ID); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		if _, err := users.FindByID(ctx, bob.ID); err != nil {
+			t.Errorf("restored user: %v", err)
+		}
+
+		if err := users.ForceDelete(ctx, admin.ID, bob.ID); err != nil {
+			t.Fatalf("ForceDelete: %v", err)
+		}
+		if _, trashed, _ := users.FindTrashed(ctx, 1, 10); len(trashed) != 0 {
+			t.Errorf("force-deleted user is still in the trash")
+		}
+		if _, err := users.Create(ctx, &models.CreateUserInput{Email: bob.Email, Password: testPassword, Name: "Bob"}); err != nil {
+			t.Errorf("reusing the email of a force-deleted user: %v", err)
+		}
+
+		csv := "email,name,role\ncarol@example.com,Carol,user\nalice@example.com,Alice,user\n"
+		result, imported, err := users.ImportUsers(ctx, strings.NewReader(csv))
+		if err != nil {
+			t.Fatalf("ImportUsers: %v", err)
+		}
+		if result.Created != 1 || result.Skipped != 1 || len(imported) != 1 || imported[0].ID == 0 {
+			t.Errorf("ImportUsers = %+v, want carol created and alice skipped", result)
+		}
+	})
+}
+
+func TestAuthTokensBackends(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, auth *AuthService, users *UserService) {
+		ctx := context.Background()
+		user, err := users.Create(ctx, &models.CreateUserInput{Email: "tokens@example.com", Password: testPassword, Name: "Tokens"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		usedAt := time.Now()
+		resets := []*models.PasswordReset{
+			{UserID: user.ID, Token: utils.HashSHA256("valid-token"), ExpiresAt: time.Now().Add(time.Hour)},
+			{UserID: user.ID, Token: utils.HashSHA256("expired-token"), ExpiresAt: time.Now().Add(-time.Minute)},
+			{UserID: user.ID, Token: utils.HashSHA256("used-token"), ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt},
+		}
+		for _, reset := range resets {
+			if err := auth.users.CreatePasswordReset(ctx, reset); err != nil {
+				t.Fatalf("CreatePasswordReset: %v", err)
+			}
+		}
+		verification := &models.EmailVerification{UserID: user.ID, Token: utils.HashSHA256("verify-token"), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := auth.users.CreateEmailVerification(ctx, verification); err != nil {
+			t.Fatalf("CreateEmailVerification: %v", err)
+		}
+
+		if err := auth.ResetPassword(ctx, "valid-token", "NewPassword456!"); err != nil {
+			t.Fatalf("ResetPassword: %v", err)
+		}
+		if err := auth.ResetPassword(ctx, "valid-token", "OtherPassword789!"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("reused reset token: got %v, want ErrInvalidToken", err)
+		}
+		if _, err := auth.Login(ctx, user.Email, "NewPassword456!", "127.0.0.1"); err != nil {
+			t.Errorf("login with the reset password: %v", err)
+		}
+
+		if err := auth.VerifyEmail(ctx, "verify-token"); err != nil {
+			t.Fatalf("VerifyEmail: %v", err)
+		}
+		if err := auth.VerifyEmail(ctx, "verify-token"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("reused verification token: got %v, want ErrInvalidToken", err)
+		}
+		if found, err := users.FindByID(ctx, user.ID); err != nil || !found.EmailVerified {
+			t.Errorf("email is not marked verified: %v", err)
+		}
+
+		// The expired, used, claimed reset tokens and the claimed
+		// verification are all spent
+		removed, err := auth.CleanupExpiredTokens(ctx)
+		if err != nil || removed != 4 {
+			t.Errorf("CleanupExpiredTokens: removed %d, %v, want 4", removed, err)
+		}
+
+		tokens, err := auth.GenerateTokens(ctx, user)
+		if err != nil {
+			t.Fatalf("GenerateTokens: %v", err)
+		}
+		if err := auth.LogoutAll(ctx, user.ID); err != nil {
+			t.Fatalf("LogoutAll: %v", err)
+		}
+		if _, err := auth.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("refresh after LogoutAll: got %v, want ErrInvalidToken", err)
+		}
+	})
+}
+
+func TestUserTransactionRollsBack(t *testing.T) {
+	auth, users := newTestAuthService(t)
+	ctx := context.Background()
+	user := createTestUser(t, users.db, "rollback@example.com", models.RoleUser)
+
+	failed := errors.New("failed")
+	err := auth.users.Transaction(ctx, func(tx repository.UserRepository) error {
+		reset := &models.PasswordReset{UserID: user.ID, Token: "rolled-back", ExpiresAt: time.Now().Add(time.Hour)}
+		if err := tx.CreatePasswordReset(ctx, reset); err != nil {
+			return err
+		}
+		if err := tx.UpdatePartial(ctx, user.ID, map[string]interface{}{"name": "Changed"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Transaction: got %v, want the error of fn", err)
+	}
+
+	if _, err := auth.users.FindPasswordReset(ctx, "rolled-back"); err == nil {
+		t.Error("password reset written in a rolled back transaction was kept")
+	}
+	if found, err := users.FindByID(ctx, user.ID); err != nil || found.Name != user.Name {
+		t.Errorf("user change in a rolled back transaction was kept: %v", err)
+	}
+}
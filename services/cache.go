@@ -0,0 +1,79 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/pkg/logger"
+)
+
+// staleCachePrefix namespaces the last-known copies kept by ReadThrough
+const staleCachePrefix = "stale"
+
+// CacheResult describes where a read-through value came from
+type CacheResult struct {
+	Stale    bool
+	CachedAt time.Time
+}
+
+// staleEntry is the cached copy of a value and when it was loaded
+type staleEntry struct {
+	Value    json.RawMessage `json:"value"`
+	CachedAt time.Time       `json:"cached_at"`
+}
+
+// ReadThrough loads a value and keeps its last-known copy in Redis for
+// CACHE_STALE_TTL. When load fails and CACHE_SERVE_STALE_ON_ERROR is enabled,
+// the copy is returned instead, marked stale. Errors listed in passthrough,
+// such as not-found errors, are always returned as-is. Only the JSON form of
+// the value is cached, so fields hidden from JSON are empty on stale values.
+func ReadThrough[T any](redis *RedisService, key string, load func() (T, error), passthrough ...error) (T, CacheResult, error) {
+	value, err := load()
+	if err == nil {
+		storeStale(redis, key, value)
+		return value, CacheResult{}, nil
+	}
+
+	for _, target := range passthrough {
+		if errors.Is(err, target) {
+			return value, CacheResult{}, err
+		}
+	}
+
+	if !config.Get().Cache.ServeStaleOnError || !redis.Available() {
+		return value, CacheResult{}, err
+	}
+
+	var entry staleEntry
+	if cacheErr := redis.CacheGetJSON(staleCachePrefix, key, &entry); cacheErr != nil {
+		return value, CacheResult{}, err
+	}
+
+	var stale T
+	if decodeErr := json.Unmarshal(entry.Value, &stale); decodeErr != nil {
+		return value, CacheResult{}, err
+	}
+
+	logger.Warnf("Serving stale cache for %s from %s: %v", key, entry.CachedAt.Format(time.RFC3339), err)
+	return stale, CacheResult{Stale: true, CachedAt: entry.CachedAt}, nil
+}
+
+// storeStale records the last-known copy of a value
+func storeStale(redis *RedisService, key string, value interface{}) {
+	cfg := config.Get().Cache
+	if !cfg.ServeStaleOnError || !redis.Available() {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	entry := staleEntry{Value: data, CachedAt: time.Now()}
+	if err := redis.CacheSet(staleCachePrefix, key, entry, cfg.StaleTTL); err != nil {
+		logger.Warnf("Failed to store stale cache for %s: %v", key, err)
+	}
+}
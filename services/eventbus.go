@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go-api-boilerplate/pkg/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// eventChannelPrefix namespaces event bus topics among Redis channels
+const eventChannelPrefix = "events:"
+
+// Event bus reconnect backoff
+const (
+	eventBusMinBackoff = 100 * time.Millisecond
+	eventBusMaxBackoff = 30 * time.Second
+)
+
+// eventEnvelope is an event as published to Redis. Origin identifies the
+// publishing instance, which has already delivered the event locally.
+type eventEnvelope struct {
+	Origin  string          `json:"origin"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// EventBus is an application event bus over Redis pub/sub. Events are JSON
+// encoded and delivered to the handlers subscribed to their topic on every
+// instance. Handlers on the publishing instance run before Publish returns,
+// so local subscribers see an event as soon as it happens; other instances
+// receive it through Redis shortly after. Without Redis the bus only
+// delivers locally. Delivery is at most once: events published while an
+// instance is disconnected from Redis are lost to it.
+type EventBus struct {
+	redis  *RedisService
+	origin string
+
+	mu       sync.RWMutex
+	handlers map[string]map[uint64]func(json.RawMessage)
+	nextID   uint64
+	pubsub   *redis.PubSub
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewEventBus creates an event bus on redis, which may be unavailable
+func NewEventBus(redis *RedisService) *EventBus {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EventBus{
+		redis:    redis,
+		origin:   hex.EncodeToString(buf),
+		handlers: make(map[string]map[uint64]func(json.RawMessage)),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Publish JSON encodes event and delivers it to the topic's subscribers.
+// Local handlers have run when it returns; the returned error only reports
+// a failure to reach other instances.
+func (b *EventBus) Publish(topic string, event any) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", topic, err)
+	}
+
+	b.dispatch(topic, payload)
+
+	if !b.redis.Available() {
+		return nil
+	}
+	data, err := json.Marshal(eventEnvelope{Origin: b.origin, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", topic, err)
+	}
+	if err := b.redis.Publish(eventChannelPrefix+topic, data); err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", topic, err)
+	}
+	return nil
+}
+
+// Subscribe calls handler with the JSON payload of every event published on
+// topic until the returned function is called. Handlers run one at a time
+// on the publisher's goroutine or the bus's receive loop, so they must
+// return quickly and hand slow work off.
+func (b *EventBus) Subscribe(topic string, handler func(json.RawMessage)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := b.nextID
+	first := len(b.handlers[topic]) == 0
+	if first {
+		b.handlers[topic] = make(map[uint64]func(json.RawMessage))
+	}
+	b.handlers[topic][id] = handler
+
+	if first && b.redis.Available() {
+		b.subscribeRemote(topic)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.unsubscribe(topic, id) })
+	}
+}
+
+// Close stops receiving events from other instances
+func (b *EventBus) Close() error {
+	b.cancel()
+
+	b.mu.Lock()
+	pubsub := b.pubsub
+	b.pubsub = nil
+	b.mu.Unlock()
+
+	if pubsub == nil {
+		return nil
+	}
+	err := pubsub.Close()
+	<-b.done
+	return err
+}
+
+// subscribeRemote subscribes the Redis connection to topic, opening it and
+// starting the receive loop on first use. Callers hold b.mu.
+func (b *EventBus) subscribeRemote(topic string) {
+	if b.ctx.Err() != nil {
+		return
+	}
+	if b.pubsub == nil {
+		b.pubsub = b.redis.Subscribe()
+		go b.receive(b.pubsub)
+	}
+	if err := b.pubsub.Subscribe(b.ctx, eventChannelPrefix+topic); err != nil {
+		// The channel is remembered and subscribed again on reconnect
+		logger.Warnf("Failed to subscribe to %s events: %v", topic, err)
+	}
+}
+
+// unsubscribe removes a handler, dropping the Redis subscription with the
+// topic's last handler
+func (b *EventBus) unsubscribe(topic string, id uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.handlers[topic], id)
+	if len(b.handlers[topic]) > 0 {
+		return
+	}
+	delete(b.handlers, topic)
+
+	if b.pubsub != nil {
+		if err := b.pubsub.Unsubscribe(b.ctx, eventChannelPrefix+topic); err != nil {
+			logger.Warnf("Failed to unsubscribe from %s events: %v", topic, err)
+		}
+	}
+}
+
+// receive delivers events from other instances until Close. A failed
+// receive is retried with exponential backoff; go-redis reconnects and
+// subscribes to every channel again on the next call.
+func (b *EventBus) receive(pubsub *redis.PubSub) {
+	defer close(b.done)
+
+	backoff := eventBusMinBackoff
+	for {
+		msg, err := pubsub.ReceiveMessage(b.ctx)
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			logger.Warnf("Event bus lost its Redis connection, reconnecting in %s: %v", backoff, err)
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, eventBusMaxBackoff)
+			continue
+		}
+		backoff = eventBusMinBackoff
+
+		var envelope eventEnvelope
+		if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+			logger.Warnf("Discarding malformed event on %s: %v", msg.Channel, err)
+			continue
+		}
+		if envelope.Origin == b.origin {
+			continue
+		}
+		b.dispatch(strings.TrimPrefix(msg.Channel, eventChannelPrefix), envelope.Payload)
+	}
+}
+
+// dispatch calls the topic's handlers with payload. A panicking handler is
+// logged and does not stop the others.
+func (b *EventBus) dispatch(topic string, payload json.RawMessage) {
+	b.mu.RLock()
+	handlers := make([]func(json.RawMessage), 0, len(b.handlers[topic]))
+	for _, handler := range b.handlers[topic] {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Errorf("Event handler for %s panicked: %v", topic, r)
+				}
+			}()
+			handler(payload)
+		}()
+	}
+}
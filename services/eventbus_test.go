@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"go-api-boilerplate/models"
+)
+
+// newTestEventBus creates an event bus on redis that is closed with the test
+func newTestEventBus(t *testing.T, redis *RedisService) *EventBus {
+	t.Helper()
+
+	bus := NewEventBus(redis)
+	t.Cleanup(func() { bus.Close() })
+	return bus
+}
+
+// collect subscribes to topic and returns a channel receiving every payload
+func collect(bus *EventBus, topic string) (<-chan string, func()) {
+	received := make(chan string, 16)
+	unsubscribe := bus.Subscribe(topic, func(payload json.RawMessage) {
+		received <- string(payload)
+	})
+	return received, unsubscribe
+}
+
+// waitSubscribed waits until n connections are subscribed to topic's Redis
+// channel, as a publish sent earlier would not reach them
+func waitSubscribed(t *testing.T, server *miniredis.Miniredis, topic string, n int) {
+	t.Helper()
+
+	channel := eventChannelPrefix + topic
+	deadline := time.Now().Add(5 * time.Second)
+	for server.PubSubNumSub(channel)[channel] != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has %d subscribers, want %d", channel, server.PubSubNumSub(channel)[channel], n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// expectEvent fails unless want arrives on received within a few seconds
+func expectEvent(t *testing.T, what string, received <-chan string, want string) {
+	t.Helper()
+
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("%s: received %s, want %s", what, got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("%s: %s not received", what, want)
+	}
+}
+
+// expectNoEvent fails if anything arrives on received shortly
+func expectNoEvent(t *testing.T, what string, received <-chan string) {
+	t.Helper()
+
+	select {
+	case got := <-received:
+		t.Errorf("%s: unexpectedly received %s", what, got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestEventBusDeliversAcrossInstances(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+	first, second := newTestEventBus(t, redis), newTestEventBus(t, redis)
+
+	local, _ := collect(first, "test")
+	remote, unsubscribe := collect(second, "test")
+	waitSubscribed(t, server, "test", 2)
+
+	if err := first.Publish("test", map[string]int{"n": 1}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	// Local handlers have run by the time Publish returns
+	select {
+	case got := <-local:
+		if got != `{"n":1}` {
+			t.Errorf("local handler received %s", got)
+		}
+	default:
+		t.Error("local handler had not run when Publish returned")
+	}
+	expectEvent(t, "other instance", remote, `{"n":1}`)
+	// The publisher skips its own event when Redis echoes it back
+	expectNoEvent(t, "publishing instance", local)
+
+	if err := second.Publish("test", "reply"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	expectEvent(t, "reply", local, `"reply"`)
+	expectEvent(t, "reply on its own instance", remote, `"reply"`)
+
+	// Other topics and unsubscribed handlers are not called
+	if err := first.Publish("other", 1); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	unsubscribe()
+	waitSubscribed(t, server, "test", 1)
+	if err := first.Publish("test", 2); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	expectNoEvent(t, "unsubscribed handler", remote)
+}
+
+func TestEventBusWithoutRedis(t *testing.T) {
+	loadTestConfig(t, nil)
+	bus := newTestEventBus(t, nil)
+
+	bus.Subscribe("test", func(json.RawMessage) { panic("handler failed") })
+	received, unsubscribe := collect(bus, "test")
+
+	if err := bus.Publish("test", []string{"a"}); err != nil {
+		t.Fatalf("Publish without Redis = %v, want local delivery only", err)
+	}
+	expectEvent(t, "local delivery past a panicking handler", received, `["a"]`)
+
+	unsubscribe()
+	bus.Publish("test", "after")
+	expectNoEvent(t, "unsubscribed handler", received)
+
+	if err := bus.Publish("test", func() {}); err == nil {
+		t.Error("Publish of a value JSON cannot encode succeeded")
+	}
+}
+
+func TestEventBusCloseStopsReceiving(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+	publisher, bus := newTestEventBus(t, redis), NewEventBus(redis)
+
+	received, _ := collect(bus, "test")
+	waitSubscribed(t, server, "test", 1)
+
+	closed := make(chan error, 1)
+	go func() { closed <- bus.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	select {
+	case <-bus.done:
+	default:
+		t.Error("receive loop still running after Close")
+	}
+	waitSubscribed(t, server, "test", 0)
+
+	publisher.Publish("test", "after close")
+	expectNoEvent(t, "closed bus", received)
+
+	// Subscribing again does not reopen the connection
+	collect(bus, "again")
+	if bus.pubsub != nil {
+		t.Error("Subscribe after Close opened a new Redis subscription")
+	}
+
+	if err := NewEventBus(redis).Close(); err != nil {
+		t.Errorf("Close of an unused bus = %v", err)
+	}
+}
+
+func TestEventBusReconnects(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+	publisher, bus := newTestEventBus(t, redis), newTestEventBus(t, redis)
+
+	received, _ := collect(bus, "test")
+	waitSubscribed(t, server, "test", 1)
+
+	server.Close()
+	time.Sleep(50 * time.Millisecond)
+	if err := server.Restart(); err != nil {
+		t.Fatalf("failed to restart Redis: %v", err)
+	}
+
+	// The subscription is restored without subscribing again
+	waitSubscribed(t, server, "test", 1)
+	if err := publisher.Publish("test", "after restart"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	expectEvent(t, "after reconnecting", received, `"after restart"`)
+}
+
+func TestUserServiceEvents(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// Two instances of the service sharing Redis and the database
+	users, other := NewUserService(db, redis), NewUserService(db, redis)
+	users.SetEventBus(newTestEventBus(t, redis))
+	observer := newTestEventBus(t, redis)
+	other.SetEventBus(observer)
+
+	changes := make(chan *UserEvent, 4)
+	other.SubscribeChanges(func(event *UserEvent) { changes <- event })
+	invalidations, _ := collect(observer, TopicCacheInvalidated)
+	waitSubscribed(t, server, TopicUserChanged, 1)
+	waitSubscribed(t, server, TopicCacheInvalidated, 2)
+
+	user := createTestUser(t, db, "events@example.com", models.RoleUser)
+	if err := redis.CacheSet("auth", fmt.Sprintf("user:%d", user.ID), "cached", time.Minute); err != nil {
+		t.Fatalf("CacheSet: %v", err)
+	}
+
+	if _, err := users.Update(ctx, user.ID, &models.UpdateUserInput{Name: "Renamed"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	select {
+	case event := <-changes:
+		if event.Action != UserEventUpdated || event.User.ID != user.ID || event.User.Name != "Renamed" {
+			t.Errorf("user event = %s %+v", event.Action, event.User)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("other instance did not receive the user event")
+	}
+	expectEvent(t, "cache invalidation", invalidations, fmt.Sprintf(`{"prefixes":["auth","stale"],"keys":["user:%d"]}`, user.ID))
+	if server.Exists(fmt.Sprintf("auth:user:%d", user.ID)) {
+		t.Error("cached user not dropped after the update")
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import "go-api-boilerplate/models"
+
+// Event bus topics
+const (
+	// TopicUserChanged carries a UserEvent whenever a user is created,
+	// updated or deleted
+	TopicUserChanged = "user.changed"
+	// TopicCacheInvalidated carries a CacheInvalidation whenever cached
+	// entries must be dropped
+	TopicCacheInvalidated = "cache.invalidated"
+)
+
+// UserEvent actions
+const (
+	UserEventCreated = "created"
+	UserEventUpdated = "updated"
+	UserEventDeleted = "deleted"
+)
+
+// UserEvent reports a change to a user. User holds the user as it is after
+// the change; for deleted users only its ID is set.
+type UserEvent struct {
+	Action string      `json:"action"`
+	User   models.User `json:"user"`
+}
+
+// CacheInvalidation names cache entries to drop: Keys under each of
+// Prefixes, as used with RedisService.CacheDelete
+type CacheInvalidation struct {
+	Prefixes []string `json:"prefixes"`
+	Keys     []string `json:"keys"`
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF header decoding
+	_ "image/jpeg" // register JPEG header decoding
+	_ "image/png"  // register PNG header decoding
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+
+	_ "golang.org/x/image/webp" // register WebP header decoding
+)
+
+// ErrImageTooLarge is returned when an image's dimensions exceed the
+// configured limits
+var ErrImageTooLarge = errors.New("image dimensions too large")
+
+// checkImageDimensions reads only the image header from r and rejects
+// images wider, taller or with more pixels than the upload limits, so a
+// small file that decodes to gigabytes never reaches a decoder. Content
+// that is not a GIF, JPEG, PNG or WebP image is left to the other checks.
+func (s *UploadService) checkImageDimensions(r io.Reader) error {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil
+		}
+		return fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	limits := s.config.Upload
+	if cfg.Width > limits.ImageMaxWidth || cfg.Height > limits.ImageMaxHeight ||
+		int64(cfg.Width)*int64(cfg.Height) > limits.ImageMaxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds the limit of %dx%d and %d pixels",
+			ErrImageTooLarge, cfg.Width, cfg.Height, limits.ImageMaxWidth, limits.ImageMaxHeight, limits.ImageMaxPixels)
+	}
+	return nil
+}
+
+// checkUploadedImage applies checkImageDimensions to an uploaded image and
+// rewinds it; other content types pass through
+func (s *UploadService) checkUploadedImage(file multipart.File, mimeType string) error {
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.checkImageDimensions(file); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return err
+}
+
+// checkImageFile applies checkImageDimensions to a stored file
+func (s *UploadService) checkImageFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("file not found")
+		}
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.checkImageDimensions(file)
+}
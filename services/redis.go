@@ -2,22 +2,99 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"go-api-boilerplate/config"
+	"go-api-boilerplate/pkg/metrics"
+	"go-api-boilerplate/pkg/retry"
+	"go-api-boilerplate/pkg/tracing"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrRedisUnavailable is returned by RedisService methods when Redis is not connected
+var ErrRedisUnavailable = errors.New("redis is not available")
+
+// ErrLockNotAcquired is returned by WithLock when another holder has the lock
+var ErrLockNotAcquired = errors.New("lock is held by another instance")
+
+// ErrCacheMiss is returned by Get when the key does not exist
+var ErrCacheMiss = errors.New("key not found")
+
+// Cache lookup counters by key prefix. Errors, such as Redis being down,
+// are counted apart from misses so an outage does not pass for a cold cache.
+var (
+	cacheHits   = metrics.NewCounterVec("cache_hits_total", "Cache lookups answered from Redis, by key prefix", "prefix")
+	cacheMisses = metrics.NewCounterVec("cache_misses_total", "Cache lookups for keys not in Redis, by key prefix", "prefix")
+	cacheErrors = metrics.NewCounterVec("cache_errors_total", "Cache lookups that failed because Redis was unavailable or errored, by key prefix", "prefix")
+)
+
+// lockPrefix namespaces distributed lock keys
+const lockPrefix = "lock"
+
+// releaseLockScript deletes a lock only while it still holds the caller's
+// token, so an expired lock taken over by another instance is left alone
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// slotPrefix namespaces counting semaphore keys
+const slotPrefix = "slots"
+
+// acquireSlotScript takes a semaphore slot unless all limit slots are held,
+// refreshing the key's expiry so slots leaked by a crashed holder are
+// eventually freed
+var acquireSlotScript = redis.NewScript(`
+local held = redis.call("INCR", KEYS[1])
+if held > tonumber(ARGV[1]) then
+	redis.call("DECR", KEYS[1])
+	return 0
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return 1
+`)
+
+// releaseSlotScript gives a semaphore slot back, deleting the key with the
+// last one
+var releaseSlotScript = redis.NewScript(`
+if redis.call("DECR", KEYS[1]) <= 0 then
+	redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// ackQueueScript pops the given values off the front of a list, stopping at
+// the first entry that differs, e.g. because QueuePush trimmed the list
+var ackQueueScript = redis.NewScript(`
+local removed = 0
+for i = 1, #ARGV do
+	if redis.call("LINDEX", KEYS[1], 0) ~= ARGV[i] then
+		break
+	end
+	redis.call("LPOP", KEYS[1])
+	removed = removed + 1
+end
+return removed
+`)
+
 // RedisService handles Redis operations
 type RedisService struct {
 	client *redis.Client
 	ctx    context.Context
 }
 
-// NewRedisService creates a new Redis service
+// NewRedisService creates a new Redis service. On failure it returns a nil
+// service; every method is safe to call on nil and returns ErrRedisUnavailable.
 func NewRedisService() (*RedisService, error) {
 	cfg := config.Get()
 
@@ -29,10 +106,26 @@ func NewRedisService() (*RedisService, error) {
 		MinIdleConns: cfg.Redis.MinIdleConns,
 	})
 
+	// Instrument commands when tracing is enabled
+	if tracing.Enabled() {
+		if err := redisotel.InstrumentTracing(client); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to instrument Redis tracing: %w", err)
+		}
+	}
+
 	ctx := context.Background()
 
-	// Test connection
-	if err := client.Ping(ctx).Err(); err != nil {
+	// Test connection, retrying while Redis comes up
+	policy := retry.Policy{
+		Attempts: cfg.Redis.ConnectAttempts,
+		Interval: cfg.Redis.ConnectRetryInterval,
+	}
+	err := retry.Do(ctx, policy, "Redis", func() error {
+		return client.Ping(ctx).Err()
+	})
+	if err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
@@ -42,8 +135,29 @@ func NewRedisService() (*RedisService, error) {
 	}, nil
 }
 
+// Available reports whether the service is connected
+func (r *RedisService) Available() bool {
+	return r != nil && r.client != nil
+}
+
+// WithContext returns a copy of the service whose commands run on ctx, so
+// they are traced as children of the caller's span. Commands are not
+// cancelled with ctx: a client hanging up must not leave a token
+// revocation or slot release half done, and the client timeouts still
+// bound every command.
+func (r *RedisService) WithContext(ctx context.Context) *RedisService {
+	if !r.Available() {
+		return r
+	}
+	return &RedisService{client: r.client, ctx: context.WithoutCancel(ctx)}
+}
+
 // Set stores a key-value pair with optional expiration
 func (r *RedisService) Set(key string, value interface{}, expiration time.Duration) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
+
 	// Convert value to JSON if it's not a string
 	var data string
 	switch v := value.(type) {
@@ -62,9 +176,13 @@ func (r *RedisService) Set(key string, value interface{}, expiration time.Durati
 
 // Get retrieves a value by key
 func (r *RedisService) Get(key string) (string, error) {
+	if !r.Available() {
+		return "", ErrRedisUnavailable
+	}
+
 	result, err := r.client.Get(r.ctx, key).Result()
 	if err == redis.Nil {
-		return "", fmt.Errorf("key not found")
+		return "", ErrCacheMiss
 	}
 	return result, err
 }
@@ -81,41 +199,66 @@ func (r *RedisService) GetJSON(key string, dest interface{}) error {
 
 // Delete removes a key
 func (r *RedisService) Delete(keys ...string) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.Del(r.ctx, keys...).Err()
 }
 
 // Exists checks if a key exists
 func (r *RedisService) Exists(keys ...string) (int64, error) {
+	if !r.Available() {
+		return 0, ErrRedisUnavailable
+	}
 	return r.client.Exists(r.ctx, keys...).Result()
 }
 
 // Expire sets expiration on a key
 func (r *RedisService) Expire(key string, expiration time.Duration) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.Expire(r.ctx, key, expiration).Err()
 }
 
 // TTL gets the time to live for a key
 func (r *RedisService) TTL(key string) (time.Duration, error) {
+	if !r.Available() {
+		return 0, ErrRedisUnavailable
+	}
 	return r.client.TTL(r.ctx, key).Result()
 }
 
 // Increment increments a numeric value
 func (r *RedisService) Increment(key string) (int64, error) {
+	if !r.Available() {
+		return 0, ErrRedisUnavailable
+	}
 	return r.client.Incr(r.ctx, key).Result()
 }
 
 // IncrementBy increments a numeric value by a specific amount
 func (r *RedisService) IncrementBy(key string, value int64) (int64, error) {
+	if !r.Available() {
+		return 0, ErrRedisUnavailable
+	}
 	return r.client.IncrBy(r.ctx, key, value).Result()
 }
 
 // Decrement decrements a numeric value
 func (r *RedisService) Decrement(key string) (int64, error) {
+	if !r.Available() {
+		return 0, ErrRedisUnavailable
+	}
 	return r.client.Decr(r.ctx, key).Result()
 }
 
 // SetNX sets a key only if it doesn't exist
 func (r *RedisService) SetNX(key string, value interface{}, expiration time.Duration) (bool, error) {
+	if !r.Available() {
+		return false, ErrRedisUnavailable
+	}
+
 	var data string
 	switch v := value.(type) {
 	case string:
@@ -133,142 +276,226 @@ func (r *RedisService) SetNX(key string, value interface{}, expiration time.Dura
 
 // HSet sets a field in a hash
 func (r *RedisService) HSet(key string, field string, value interface{}) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.HSet(r.ctx, key, field, value).Err()
 }
 
 // HGet gets a field from a hash
 func (r *RedisService) HGet(key string, field string) (string, error) {
+	if !r.Available() {
+		return "", ErrRedisUnavailable
+	}
 	return r.client.HGet(r.ctx, key, field).Result()
 }
 
 // HGetAll gets all fields from a hash
 func (r *RedisService) HGetAll(key string) (map[string]string, error) {
+	if !r.Available() {
+		return nil, ErrRedisUnavailable
+	}
 	return r.client.HGetAll(r.ctx, key).Result()
 }
 
 // HDelete deletes fields from a hash
 func (r *RedisService) HDelete(key string, fields ...string) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.HDel(r.ctx, key, fields...).Err()
 }
 
 // LPush pushes values to the left of a list
 func (r *RedisService) LPush(key string, values ...interface{}) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.LPush(r.ctx, key, values...).Err()
 }
 
 // RPush pushes values to the right of a list
 func (r *RedisService) RPush(key string, values ...interface{}) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.RPush(r.ctx, key, values...).Err()
 }
 
 // LPop pops a value from the left of a list
 func (r *RedisService) LPop(key string) (string, error) {
+	if !r.Available() {
+		return "", ErrRedisUnavailable
+	}
 	return r.client.LPop(r.ctx, key).Result()
 }
 
 // RPop pops a value from the right of a list
 func (r *RedisService) RPop(key string) (string, error) {
+	if !r.Available() {
+		return "", ErrRedisUnavailable
+	}
 	return r.client.RPop(r.ctx, key).Result()
 }
 
 // LRange gets a range of values from a list
 func (r *RedisService) LRange(key string, start, stop int64) ([]string, error) {
+	if !r.Available() {
+		return nil, ErrRedisUnavailable
+	}
 	return r.client.LRange(r.ctx, key, start, stop).Result()
 }
 
 // LLen gets the length of a list
 func (r *RedisService) LLen(key string) (int64, error) {
+	if !r.Available() {
+		return 0, ErrRedisUnavailable
+	}
 	return r.client.LLen(r.ctx, key).Result()
 }
 
 // SAdd adds members to a set
 func (r *RedisService) SAdd(key string, members ...interface{}) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.SAdd(r.ctx, key, members...).Err()
 }
 
 // SRemove removes members from a set
 func (r *RedisService) SRemove(key string, members ...interface{}) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.SRem(r.ctx, key, members...).Err()
 }
 
 // SMembers gets all members of a set
 func (r *RedisService) SMembers(key string) ([]string, error) {
+	if !r.Available() {
+		return nil, ErrRedisUnavailable
+	}
 	return r.client.SMembers(r.ctx, key).Result()
 }
 
 // SIsMember checks if a value is a member of a set
 func (r *RedisService) SIsMember(key string, member interface{}) (bool, error) {
+	if !r.Available() {
+		return false, ErrRedisUnavailable
+	}
 	return r.client.SIsMember(r.ctx, key, member).Result()
 }
 
 // ZAdd adds members to a sorted set
 func (r *RedisService) ZAdd(key string, members ...redis.Z) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.ZAdd(r.ctx, key, members...).Err()
 }
 
 // ZRange gets a range of members from a sorted set
 func (r *RedisService) ZRange(key string, start, stop int64) ([]string, error) {
+	if !r.Available() {
+		return nil, ErrRedisUnavailable
+	}
 	return r.client.ZRange(r.ctx, key, start, stop).Result()
 }
 
 // ZRangeWithScores gets a range of members with scores from a sorted set
 func (r *RedisService) ZRangeWithScores(key string, start, stop int64) ([]redis.Z, error) {
+	if !r.Available() {
+		return nil, ErrRedisUnavailable
+	}
 	return r.client.ZRangeWithScores(r.ctx, key, start, stop).Result()
 }
 
 // ZScore gets the score of a member in a sorted set
 func (r *RedisService) ZScore(key string, member string) (float64, error) {
+	if !r.Available() {
+		return 0, ErrRedisUnavailable
+	}
 	return r.client.ZScore(r.ctx, key, member).Result()
 }
 
 // ZRem removes members from a sorted set
 func (r *RedisService) ZRem(key string, members ...interface{}) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.ZRem(r.ctx, key, members...).Err()
 }
 
 // Publish publishes a message to a channel
 func (r *RedisService) Publish(channel string, message interface{}) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.Publish(r.ctx, channel, message).Err()
 }
 
 // Subscribe subscribes to channels
 func (r *RedisService) Subscribe(channels ...string) *redis.PubSub {
+	if !r.Available() {
+		return nil
+	}
 	return r.client.Subscribe(r.ctx, channels...)
 }
 
 // PSubscribe subscribes to channel patterns
 func (r *RedisService) PSubscribe(patterns ...string) *redis.PubSub {
+	if !r.Available() {
+		return nil
+	}
 	return r.client.PSubscribe(r.ctx, patterns...)
 }
 
 // Pipeline creates a pipeline for batch operations
 func (r *RedisService) Pipeline() redis.Pipeliner {
+	if !r.Available() {
+		return nil
+	}
 	return r.client.Pipeline()
 }
 
 // Watch watches keys for changes
 func (r *RedisService) Watch(fn func(*redis.Tx) error, keys ...string) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.Watch(r.ctx, fn, keys...)
 }
 
 // FlushDB flushes the current database
 func (r *RedisService) FlushDB() error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
 	return r.client.FlushDB(r.ctx).Err()
 }
 
 // Close closes the Redis connection
 func (r *RedisService) Close() error {
+	if !r.Available() {
+		return nil
+	}
 	return r.client.Close()
 }
 
 // GetClient returns the underlying Redis client
 func (r *RedisService) GetClient() *redis.Client {
+	if !r.Available() {
+		return nil
+	}
 	return r.client
 }
 
-// HealthCheck performs a health check on Redis
-func (r *RedisService) HealthCheck() error {
-	return r.client.Ping(r.ctx).Err()
+// HealthCheck performs a health check on Redis, giving up when ctx is done
+func (r *RedisService) HealthCheck(ctx context.Context) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
+	return r.client.Ping(ctx).Err()
 }
 
 // Cache-specific methods
@@ -279,16 +506,29 @@ func (r *RedisService) CacheSet(prefix, key string, value interface{}, expiratio
 	return r.Set(cacheKey, value, expiration)
 }
 
-// CacheGet gets a value with a specific cache key pattern
+// CacheGet gets a value with a specific cache key pattern and counts the
+// lookup as a hit, miss or error for the prefix
 func (r *RedisService) CacheGet(prefix, key string) (string, error) {
 	cacheKey := fmt.Sprintf("%s:%s", prefix, key)
-	return r.Get(cacheKey)
+	value, err := r.Get(cacheKey)
+	switch {
+	case err == nil:
+		cacheHits.Inc(prefix)
+	case errors.Is(err, ErrCacheMiss):
+		cacheMisses.Inc(prefix)
+	default:
+		cacheErrors.Inc(prefix)
+	}
+	return value, err
 }
 
 // CacheGetJSON gets and unmarshals a JSON value with a specific cache key pattern
 func (r *RedisService) CacheGetJSON(prefix, key string, dest interface{}) error {
-	cacheKey := fmt.Sprintf("%s:%s", prefix, key)
-	return r.GetJSON(cacheKey, dest)
+	data, err := r.CacheGet(prefix, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), dest)
 }
 
 // CacheDelete deletes values with a specific cache key pattern
@@ -302,6 +542,10 @@ func (r *RedisService) CacheDelete(prefix string, keys ...string) error {
 
 // CacheFlush flushes all keys with a specific prefix
 func (r *RedisService) CacheFlush(prefix string) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
+
 	pattern := fmt.Sprintf("%s:*", prefix)
 	keys, err := r.client.Keys(r.ctx, pattern).Result()
 	if err != nil {
@@ -359,3 +603,133 @@ func (r *RedisService) SessionDelete(sessionID string) error {
 func (r *RedisService) SessionExtend(sessionID string, expiration time.Duration) error {
 	return r.Expire(fmt.Sprintf("session:%s", sessionID), expiration)
 }
+
+// Queue helpers
+
+// QueuePush appends value to a list, keeping only the newest maxLen entries
+// and resetting the list TTL. maxLen and ttl are ignored when not positive.
+func (r *RedisService) QueuePush(key string, value interface{}, maxLen int64, ttl time.Duration) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.RPush(r.ctx, key, value)
+	if maxLen > 0 {
+		pipe.LTrim(r.ctx, key, -maxLen, -1)
+	}
+	if ttl > 0 {
+		pipe.Expire(r.ctx, key, ttl)
+	}
+	_, err := pipe.Exec(r.ctx)
+	return err
+}
+
+// QueueRange returns every entry of a list in order without removing them;
+// QueueAck removes them once they are handled
+func (r *RedisService) QueueRange(key string) ([]string, error) {
+	if !r.Available() {
+		return nil, ErrRedisUnavailable
+	}
+	return r.client.LRange(r.ctx, key, 0, -1).Result()
+}
+
+// QueueAck removes values, a prefix of what QueueRange returned, from the
+// front of a list. Entries pushed or trimmed since are left alone.
+func (r *RedisService) QueueAck(key string, values []string) error {
+	if !r.Available() {
+		return ErrRedisUnavailable
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return ackQueueScript.Run(r.ctx, r.client, []string{key}, args...).Err()
+}
+
+// Lock helpers
+
+// Lock acquires a distributed lock on key for ttl using SET NX PX with a
+// random token. When acquired, release drops the lock if this caller still
+// holds it; it is safe to call more than once. When another holder has the
+// lock, acquired is false and release is a no-op. The lock expires after ttl
+// even if never released, so ttl must outlast the protected work.
+func (r *RedisService) Lock(key string, ttl time.Duration) (release func(), acquired bool, err error) {
+	noop := func() {}
+	if !r.Available() {
+		return noop, false, ErrRedisUnavailable
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return noop, false, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	lockKey := fmt.Sprintf("%s:%s", lockPrefix, key)
+
+	acquired, err = r.client.SetNX(r.ctx, lockKey, token, ttl).Result()
+	if err != nil {
+		return noop, false, err
+	}
+	if !acquired {
+		return noop, false, nil
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			releaseLockScript.Run(r.ctx, r.client, []string{lockKey}, token)
+		})
+	}
+	return release, true, nil
+}
+
+// WithLock runs fn while holding the lock on key, releasing it afterwards.
+// It returns ErrLockNotAcquired without running fn when another holder has
+// the lock, and ErrRedisUnavailable when Redis is not connected.
+func (r *RedisService) WithLock(key string, ttl time.Duration, fn func() error) error {
+	release, acquired, err := r.Lock(key, ttl)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrLockNotAcquired
+	}
+	defer release()
+
+	return fn()
+}
+
+// Semaphore helpers
+
+// AcquireSlot takes one of limit slots of the counting semaphore key. When
+// acquired, release gives the slot back and is safe to call more than once;
+// otherwise release is a no-op. The count expires ttl after the last
+// acquisition, so ttl must outlast the work a slot protects.
+func (r *RedisService) AcquireSlot(key string, limit int, ttl time.Duration) (release func(), acquired bool, err error) {
+	noop := func() {}
+	if !r.Available() {
+		return noop, false, ErrRedisUnavailable
+	}
+
+	slotKey := fmt.Sprintf("%s:%s", slotPrefix, key)
+	taken, err := acquireSlotScript.Run(r.ctx, r.client, []string{slotKey}, limit, ttl.Milliseconds()).Int()
+	if err != nil {
+		return noop, false, err
+	}
+	if taken == 0 {
+		return noop, false, nil
+	}
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			releaseSlotScript.Run(r.ctx, r.client, []string{slotKey})
+		})
+	}
+	return release, true, nil
+}
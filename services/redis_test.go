@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithContextNestsRedisSpans(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, _ := newTestRedis(t)
+
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	if err := redisotel.InstrumentTracing(redis.client, redisotel.WithTracerProvider(provider)); err != nil {
+		t.Fatalf("InstrumentTracing: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, request := provider.Tracer("test").Start(ctx, "request")
+	scoped := redis.WithContext(ctx)
+
+	if err := scoped.Set("greeting", "hello", time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	release, acquired, err := scoped.Lock("job", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("Lock = %v, %v, want acquired", acquired, err)
+	}
+
+	// The request ending must not stop the lock from being released
+	cancel()
+	release()
+	request.End()
+
+	if _, acquired, err := redis.Lock("job", time.Minute); err != nil || !acquired {
+		t.Errorf("Lock after release = %v, %v, want acquired", acquired, err)
+	}
+
+	var commands int
+	for _, span := range spans.Ended() {
+		if span.Name() == "request" || span.SpanContext().TraceID() != request.SpanContext().TraceID() {
+			continue
+		}
+		commands++
+		if span.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the request span", span.Name())
+		}
+	}
+	// SET, the lock's SET NX and the release script, which may take an
+	// EVALSHA and an EVAL
+	if commands < 3 {
+		t.Errorf("recorded %d Redis spans in the request trace, want at least 3", commands)
+	}
+}
+
+func TestWithContextOnUnavailableRedis(t *testing.T) {
+	var redis *RedisService
+	if scoped := redis.WithContext(context.Background()); scoped.Available() {
+		t.Fatal("WithContext on a nil service is available")
+	}
+	if err := redis.WithContext(context.Background()).Set("key", "value", 0); err != ErrRedisUnavailable {
+		t.Errorf("Set = %v, want ErrRedisUnavailable", err)
+	}
+}
+
+func TestLockRefusesHeldLock(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, _ := newTestRedis(t)
+
+	release, acquired, err := redis.Lock("job", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("first Lock = %v, %v, want acquired", acquired, err)
+	}
+
+	if _, acquired, err := redis.Lock("job", time.Minute); err != nil || acquired {
+		t.Errorf("second Lock = %v, %v, want refused", acquired, err)
+	}
+
+	// Releasing twice is safe and frees the lock
+	release()
+	release()
+	if _, acquired, err := redis.Lock("job", time.Minute); err != nil || !acquired {
+		t.Errorf("Lock after release = %v, %v, want acquired", acquired, err)
+	}
+}
+
+func TestLockExpiresAfterTTL(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+
+	if _, acquired, err := redis.Lock("job", time.Minute); err != nil || !acquired {
+		t.Fatalf("Lock = %v, %v, want acquired", acquired, err)
+	}
+	if ttl := server.TTL(lockPrefix + ":job"); ttl != time.Minute {
+		t.Errorf("lock TTL = %v, want 1m", ttl)
+	}
+
+	// A holder that never releases loses the lock once the TTL passes
+	server.FastForward(time.Minute + time.Second)
+	if _, acquired, err := redis.Lock("job", time.Minute); err != nil || !acquired {
+		t.Errorf("Lock after expiry = %v, %v, want acquired", acquired, err)
+	}
+}
+
+func TestLockReleaseKeepsAnotherHoldersLock(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+
+	staleRelease, _, err := redis.Lock("job", time.Minute)
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	server.FastForward(time.Minute + time.Second)
+
+	if _, acquired, err := redis.Lock("job", time.Minute); err != nil || !acquired {
+		t.Fatalf("Lock after expiry = %v, %v, want acquired", acquired, err)
+	}
+	token, _ := server.Get(lockPrefix + ":job")
+
+	// The first holder's release must not delete the new holder's token
+	staleRelease()
+	if got, err := server.Get(lockPrefix + ":job"); err != nil || got != token {
+		t.Errorf("lock after stale release = %q, %v, want the new holder's token", got, err)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	loadTestConfig(t, nil)
+	connected, _ := newTestRedis(t)
+	stopped, stoppedServer := newTestRedis(t)
+	stoppedServer.Close()
+
+	held, _ := newTestRedis(t)
+	if _, acquired, err := held.Lock("job", time.Minute); err != nil || !acquired {
+		t.Fatalf("Lock = %v, %v, want acquired", acquired, err)
+	}
+
+	tests := []struct {
+		name    string
+		redis   *RedisService
+		wantRun bool
+		wantErr func(error) bool
+	}{
+		{"free lock", connected, true, func(err error) bool { return err == nil }},
+		{"held lock", held, false, func(err error) bool { return errors.Is(err, ErrLockNotAcquired) }},
+		{"nil redis", nil, false, func(err error) bool { return errors.Is(err, ErrRedisUnavailable) }},
+		{"stopped redis", stopped, false, func(err error) bool { return err != nil && !errors.Is(err, ErrLockNotAcquired) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := false
+			err := tt.redis.WithLock("job", time.Minute, func() error {
+				ran = true
+				return nil
+			})
+			if !tt.wantErr(err) {
+				t.Errorf("WithLock error = %v", err)
+			}
+			if ran != tt.wantRun {
+				t.Errorf("fn ran = %v, want %v", ran, tt.wantRun)
+			}
+		})
+	}
+
+	// The lock is released once fn returns, including when it fails
+	fnErr := errors.New("job failed")
+	if err := connected.WithLock("job", time.Minute, func() error { return fnErr }); !errors.Is(err, fnErr) {
+		t.Errorf("WithLock error = %v, want fn's error", err)
+	}
+	if _, acquired, err := connected.Lock("job", time.Minute); err != nil || !acquired {
+		t.Errorf("Lock after WithLock = %v, %v, want acquired", acquired, err)
+	}
+}
+
+func TestAcquireSlot(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+	key := slotPrefix + ":uploads"
+
+	acquire := func() (func(), bool) {
+		t.Helper()
+		release, acquired, err := redis.AcquireSlot("uploads", 2, time.Minute)
+		if err != nil {
+			t.Fatalf("AcquireSlot: %v", err)
+		}
+		return release, acquired
+	}
+
+	first, ok1 := acquire()
+	second, ok2 := acquire()
+	if !ok1 || !ok2 {
+		t.Fatalf("first two slots acquired = %v, %v, want both", ok1, ok2)
+	}
+	if _, acquired := acquire(); acquired {
+		t.Fatal("third slot acquired with a limit of 2")
+	}
+
+	// Releasing twice gives back a single slot
+	first()
+	first()
+	if got, _ := server.Get(key); got != "1" {
+		t.Errorf("held slots after one release = %s, want 1", got)
+	}
+	third, acquired := acquire()
+	if !acquired {
+		t.Fatal("slot not acquired after a release")
+	}
+	if _, acquired := acquire(); acquired {
+		t.Error("slot acquired past the limit after a double release")
+	}
+
+	// The key goes away with the last slot
+	second()
+	third()
+	if server.Exists(key) {
+		t.Errorf("%s left after every slot was released", key)
+	}
+}
+
+func TestAcquireSlotExpiresAfterCrashedHolder(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+
+	// A holder that crashes never releases its slots
+	for i := 0; i < 2; i++ {
+		if _, acquired, err := redis.AcquireSlot("uploads", 2, time.Minute); err != nil || !acquired {
+			t.Fatalf("AcquireSlot = %v, %v, want acquired", acquired, err)
+		}
+	}
+	if ttl := server.TTL(slotPrefix + ":uploads"); ttl != time.Minute {
+		t.Errorf("slot TTL = %v, want 1m", ttl)
+	}
+	if _, acquired, _ := redis.AcquireSlot("uploads", 2, time.Minute); acquired {
+		t.Fatal("slot acquired with every slot held")
+	}
+
+	server.FastForward(time.Minute + time.Second)
+	release, acquired, err := redis.AcquireSlot("uploads", 2, time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("AcquireSlot after the TTL = %v, %v, want acquired", acquired, err)
+	}
+	release()
+}
+
+func TestCacheLookupMetrics(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+
+	// The counters are global, so each prefix is only used here
+	type counts struct{ hits, misses, errors uint64 }
+	read := func(prefix string) counts {
+		return counts{cacheHits.Value(prefix), cacheMisses.Value(prefix), cacheErrors.Value(prefix)}
+	}
+
+	if err := redis.CacheSet("test_metrics", "present", "value", time.Minute); err != nil {
+		t.Fatalf("CacheSet: %v", err)
+	}
+	if err := redis.CacheSet("test_metrics_json", "present", map[string]string{"a": "b"}, time.Minute); err != nil {
+		t.Fatalf("CacheSet: %v", err)
+	}
+
+	var dest map[string]string
+	tests := []struct {
+		name     string
+		prefix   string
+		lookup   func() error
+		wantMiss bool
+		want     counts
+	}{
+		{"hit", "test_metrics", func() error { _, err := redis.CacheGet("test_metrics", "present"); return err }, false, counts{1, 0, 0}},
+		{"miss", "test_metrics", func() error { _, err := redis.CacheGet("test_metrics", "absent"); return err }, true, counts{1, 1, 0}},
+		{"JSON hit", "test_metrics_json", func() error { return redis.CacheGetJSON("test_metrics_json", "present", &dest) }, false, counts{1, 0, 0}},
+		{"JSON miss", "test_metrics_json", func() error { return redis.CacheGetJSON("test_metrics_json", "absent", &dest) }, true, counts{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		if err := tt.lookup(); (tt.wantMiss && !errors.Is(err, ErrCacheMiss)) || (!tt.wantMiss && err != nil) {
+			t.Errorf("%s: lookup = %v, want a miss: %v", tt.name, err, tt.wantMiss)
+		}
+		if got := read(tt.prefix); got != tt.want {
+			t.Errorf("%s: hits, misses, errors = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// A lookup failing because Redis is down is an error, not a miss
+	server.Close()
+	if _, err := redis.CacheGet("test_metrics", "present"); err == nil || errors.Is(err, ErrCacheMiss) {
+		t.Errorf("CacheGet with Redis down = %v, want a connection error", err)
+	}
+	if got, want := read("test_metrics"), (counts{1, 1, 1}); got != want {
+		t.Errorf("with Redis down: hits, misses, errors = %+v, want %+v", got, want)
+	}
+
+	var exposed strings.Builder
+	cacheErrors.Write(&exposed)
+	if !strings.Contains(exposed.String(), `cache_errors_total{prefix="test_metrics"} 1`) {
+		t.Errorf("exposition lacks the error series:\n%s", exposed.String())
+	}
+}
+
+func TestUserCacheLookupsCounted(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, _ := newTestRedis(t)
+	db := newTestDB(t)
+	auth := NewAuthService(db, redis)
+	ctx := context.Background()
+	user := createTestUser(t, db, "cached@example.com", "user")
+
+	tokens, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	// Logging in fills the cache, so drop the entry to start cold
+	redis.CacheDelete("auth", fmt.Sprintf("user:%d", user.ID))
+
+	hits, misses := cacheHits.Value("auth"), cacheMisses.Value("auth")
+	for i := 0; i < 3; i++ {
+		if _, err := auth.ValidateAccessToken(ctx, tokens.AccessToken); err != nil {
+			t.Fatalf("ValidateAccessToken: %v", err)
+		}
+	}
+	if got := cacheMisses.Value("auth") - misses; got != 1 {
+		t.Errorf("auth cache misses = %d, want 1 for the cold lookup", got)
+	}
+	if got := cacheHits.Value("auth") - hits; got != 2 {
+		t.Errorf("auth cache hits = %d, want 2", got)
+	}
+}
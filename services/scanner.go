@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"go-api-boilerplate/config"
+)
+
+// ErrScannerUnavailable is returned when the scanner could not be reached
+var ErrScannerUnavailable = errors.New("file scanner unavailable")
+
+// FileRejectedError is returned when a scanner flags an uploaded file
+type FileRejectedError struct {
+	Reason string
+}
+
+func (e *FileRejectedError) Error() string {
+	return fmt.Sprintf("file rejected: %s", e.Reason)
+}
+
+// FileScanner inspects uploaded content before it is persisted
+type FileScanner interface {
+	Scan(r io.Reader) (clean bool, reason string, err error)
+}
+
+// NewFileScanner returns the scanner configured for uploads
+func NewFileScanner(cfg *config.Config) FileScanner {
+	if !cfg.Upload.ScanEnabled {
+		return NoopScanner{}
+	}
+	return NewClamAVScanner(cfg.Upload.ClamdAddress, cfg.Upload.ScanTimeout)
+}
+
+// NoopScanner accepts every file
+type NoopScanner struct{}
+
+// Scan always reports the file as clean
+func (NoopScanner) Scan(r io.Reader) (bool, string, error) {
+	return true, "", nil
+}
+
+// ClamAVScanner scans files with clamd over TCP using the INSTREAM command
+type ClamAVScanner struct {
+	address   string
+	timeout   time.Duration
+	chunkSize int
+}
+
+// NewClamAVScanner creates a scanner for the clamd daemon at address
+func NewClamAVScanner(address string, timeout time.Duration) *ClamAVScanner {
+	return &ClamAVScanner{
+		address:   address,
+		timeout:   timeout,
+		chunkSize: 32 * 1024,
+	}
+}
+
+// Scan streams the content to clamd and interprets its verdict
+func (s *ClamAVScanner) Scan(r io.Reader) (bool, string, error) {
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return false, "", fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+	defer conn.Close()
+
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return false, "", fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+
+	// Send content as length-prefixed chunks, terminated by a zero length
+	buf := make([]byte, s.chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return false, "", fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return false, "", fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return false, "", fmt.Errorf("failed to read file for scanning: %w", readErr)
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return false, "", fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+
+	// Read the verdict, e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && err != io.EOF {
+		return false, "", fmt.Errorf("%w: %v", ErrScannerUnavailable, err)
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+
+	switch {
+	case strings.HasSuffix(reply, "OK"):
+		return true, "", nil
+	case strings.HasSuffix(reply, "FOUND"):
+		reason := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return false, reason, nil
+	default:
+		return false, "", fmt.Errorf("clamd error: %s", reply)
+	}
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/pkg/logger"
+)
+
+// Session store drivers
+const (
+	SessionStoreRedis  = "redis"
+	SessionStoreMemory = "memory"
+)
+
+// ErrSessionNotFound is returned when a session does not exist or has expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore persists session data keyed by session ID. Sessions recorded
+// with Track can be ended together with DeleteUser, e.g. when the user is
+// deleted.
+type SessionStore interface {
+	Get(sessionID string, dest interface{}) error
+	Set(sessionID string, data interface{}, ttl time.Duration) error
+	Delete(sessionID string) error
+	Extend(sessionID string, ttl time.Duration) error
+	Track(userID uint, sessionID string) error
+	DeleteUser(userID uint) error
+}
+
+// NewSessionStore returns the session store selected by SESSION_STORE.
+// The Redis store falls back to memory when Redis is unavailable.
+func NewSessionStore(cfg *config.Config, redis *RedisService) SessionStore {
+	if cfg.Session.Store == SessionStoreRedis {
+		if redis.Available() {
+			return NewRedisSessionStore(redis)
+		}
+		logger.Warn("Redis is unavailable, falling back to in-memory session store")
+	}
+	return NewMemorySessionStore()
+}
+
+// RedisSessionStore stores sessions in Redis
+type RedisSessionStore struct {
+	redis *RedisService
+}
+
+// NewRedisSessionStore creates a Redis backed session store
+func NewRedisSessionStore(redis *RedisService) *RedisSessionStore {
+	return &RedisSessionStore{redis: redis}
+}
+
+// Get loads the session data into dest
+func (s *RedisSessionStore) Get(sessionID string, dest interface{}) error {
+	count, err := s.redis.Exists(fmt.Sprintf("session:%s", sessionID))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSessionNotFound
+	}
+	return s.redis.SessionGet(sessionID, dest)
+}
+
+// Set stores the session data with the given TTL
+func (s *RedisSessionStore) Set(sessionID string, data interface{}, ttl time.Duration) error {
+	return s.redis.SessionSet(sessionID, data, ttl)
+}
+
+// Delete removes the session
+func (s *RedisSessionStore) Delete(sessionID string) error {
+	return s.redis.SessionDelete(sessionID)
+}
+
+// Extend resets the session TTL
+func (s *RedisSessionStore) Extend(sessionID string, ttl time.Duration) error {
+	return s.redis.SessionExtend(sessionID, ttl)
+}
+
+// Track records the session as belonging to the user. Sessions that have
+// since ended are dropped from the user's set, which keeps it bounded by the
+// number of live sessions.
+func (s *RedisSessionStore) Track(userID uint, sessionID string) error {
+	key := userSessionsKey(userID)
+	members, err := s.redis.SMembers(key)
+	if err != nil {
+		return err
+	}
+
+	var ended []interface{}
+	for _, member := range members {
+		count, err := s.redis.Exists(fmt.Sprintf("session:%s", member))
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			ended = append(ended, member)
+		}
+	}
+	if len(ended) > 0 {
+		if err := s.redis.SRemove(key, ended...); err != nil {
+			return err
+		}
+	}
+
+	return s.redis.SAdd(key, sessionID)
+}
+
+// DeleteUser removes every session tracked for the user
+func (s *RedisSessionStore) DeleteUser(userID uint) error {
+	key := userSessionsKey(userID)
+	members, err := s.redis.SMembers(key)
+	if err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		if err := s.redis.SessionDelete(member); err != nil {
+			return err
+		}
+	}
+	return s.redis.Delete(key)
+}
+
+// userSessionsKey returns the Redis set holding a user's session IDs
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("user_sessions:%d", userID)
+}
+
+// memorySession is a serialized session and its expiry
+type memorySession struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemorySessionStore keeps sessions in process memory. Sessions are lost on
+// restart and not shared between instances, so use it for tests and local
+// development only.
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	users     map[uint]map[string]bool
+	lastPrune time.Time
+}
+
+// NewMemorySessionStore creates an in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:  make(map[string]memorySession),
+		users:     make(map[uint]map[string]bool),
+		lastPrune: time.Now(),
+	}
+}
+
+// Get loads the session data into dest
+func (s *MemorySessionStore) Get(sessionID string, dest interface{}) error {
+	s.mu.Lock()
+	session, ok := s.lookup(sessionID)
+	s.mu.Unlock()
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	// Round-trip through JSON so callers see the same types as with Redis
+	return json.Unmarshal(session.data, dest)
+}
+
+// Set stores the session data with the given TTL
+func (s *MemorySessionStore) Set(sessionID string, data interface{}, ttl time.Duration) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneExpired()
+	s.sessions[sessionID] = memorySession{
+		data:      encoded,
+		expiresAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+// Delete removes the session
+func (s *MemorySessionStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, sessionID)
+	return nil
+}
+
+// Extend resets the session TTL
+func (s *MemorySessionStore) Extend(sessionID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.lookup(sessionID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.expiresAt = time.Now().Add(ttl)
+	s.sessions[sessionID] = session
+	return nil
+}
+
+// Track records the session as belonging to the user, dropping sessions of
+// the user that have since ended
+func (s *MemorySessionStore) Track(userID uint, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.users[userID]
+	if ids == nil {
+		ids = make(map[string]bool)
+		s.users[userID] = ids
+	}
+	for id := range ids {
+		if _, ok := s.lookup(id); !ok {
+			delete(ids, id)
+		}
+	}
+	ids[sessionID] = true
+	return nil
+}
+
+// DeleteUser removes every session tracked for the user
+func (s *MemorySessionStore) DeleteUser(userID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.users[userID] {
+		delete(s.sessions, id)
+	}
+	delete(s.users, userID)
+	return nil
+}
+
+// lookup returns a live session, dropping it if expired. Callers hold s.mu.
+func (s *MemorySessionStore) lookup(sessionID string) (memorySession, bool) {
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return memorySession{}, false
+	}
+	if time.Now().After(session.expiresAt) {
+		delete(s.sessions, sessionID)
+		return memorySession{}, false
+	}
+	return session, true
+}
+
+// pruneExpired drops expired sessions at most once a minute. Callers hold s.mu.
+func (s *MemorySessionStore) pruneExpired() {
+	now := time.Now()
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+
+	for id, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
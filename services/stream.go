@@ -1,17 +1,26 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"go-api-boilerplate/config"
 	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,12 +28,41 @@ import (
 // StreamService handles video streaming operations
 type StreamService struct {
 	config *config.Config
+	redis  *RedisService
 }
 
-// NewStreamService creates a new stream service
-func NewStreamService() *StreamService {
+// transcodeLockTTL bounds how long a transcode holds its cluster-wide lock
+const transcodeLockTTL = 30 * time.Minute
+
+var (
+	// ErrTranscodeInProgress is returned when another instance is already
+	// transcoding the same video
+	ErrTranscodeInProgress = errors.New("video is already being transcoded")
+	// ErrSignatureRequired is returned when a signed URL is needed but the
+	// request carries no signature
+	ErrSignatureRequired = errors.New("stream signature required")
+	// ErrInvalidSignature is returned when a stream signature does not match
+	ErrInvalidSignature = errors.New("invalid stream signature")
+	// ErrSignatureExpired is returned when a signed stream URL has expired
+	ErrSignatureExpired = errors.New("stream signature expired")
+	// ErrVideoNotFound is returned when a video, playlist or segment does
+	// not exist
+	ErrVideoNotFound = errors.New("video not found")
+	// ErrVideoAccessDenied is returned when a path resolves outside the
+	// stream directory or the file cannot be read
+	ErrVideoAccessDenied = errors.New("video access denied")
+)
+
+// hlsURIAttribute matches the URI="..." attribute of HLS tags such as
+// EXT-X-MEDIA and EXT-X-I-FRAME-STREAM-INF
+var hlsURIAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// NewStreamService creates a new stream service. Redis, when available,
+// keeps each video from being transcoded by more than one instance at once.
+func NewStreamService(redis *RedisService) *StreamService {
 	return &StreamService{
 		config: config.Get(),
+		redis:  redis,
 	}
 }
 
@@ -39,7 +77,10 @@ func (s *StreamService) StreamVideo(c *gin.Context, videoPath string) error {
 	video, err := os.Open(videoPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("video not found")
+			return ErrVideoNotFound
+		}
+		if os.IsPermission(err) {
+			return ErrVideoAccessDenied
 		}
 		return fmt.Errorf("failed to open video: %w", err)
 	}
@@ -54,6 +95,18 @@ func (s *StreamService) StreamVideo(c *gin.Context, videoPath string) error {
 	// Get file size
 	fileSize := stat.Size()
 
+	// Validators let a client resuming a download check the file has not
+	// changed since it fetched the earlier bytes
+	etag := videoETag(stat)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+
+	// A client that already has this version only needs to hear so
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && ifNoneMatchHit(ifNoneMatch, etag) {
+		c.Status(http.StatusNotModified)
+		return nil
+	}
+
 	// Parse range header
 	rangeHeader := c.GetHeader("Range")
 	if rangeHeader == "" {
@@ -62,6 +115,13 @@ func (s *StreamService) StreamVideo(c *gin.Context, videoPath string) error {
 		return nil
 	}
 
+	// A range of a different version of the file would corrupt the
+	// client's copy, so send the whole current file instead
+	if ifRange := c.GetHeader("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, etag, stat.ModTime()) {
+		s.serveFullVideo(c, video, fileSize)
+		return nil
+	}
+
 	// Parse range values
 	start, end, err := s.parseRange(rangeHeader, fileSize)
 	if err != nil {
@@ -73,6 +133,40 @@ func (s *StreamService) StreamVideo(c *gin.Context, videoPath string) error {
 	return s.servePartialVideo(c, video, start, end, fileSize)
 }
 
+// SignedURL is an expiring URL that streams a video without a bearer token
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SignVideo returns a signed URL for streaming a video through baseURL,
+// the path of the signed streaming route
+func (s *StreamService) SignVideo(videoPath, baseURL string) (*SignedURL, error) {
+	if err := s.validateVideoPath(videoPath); err != nil {
+		return nil, err
+	}
+
+	resource, err := s.streamResource(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	expires, signature := s.SignStreamURL(resource)
+	return &SignedURL{
+		URL:       fmt.Sprintf("%s?expires=%d&signature=%s", baseURL, expires, signature),
+		ExpiresAt: time.Unix(expires, 0).UTC(),
+	}, nil
+}
+
+// StreamVideoSigned streams a video like StreamVideo, authorizing the
+// request by its signed URL parameters instead of a bearer token
+func (s *StreamService) StreamVideoSigned(c *gin.Context, videoPath string) error {
+	if err := s.verifySignedRequest(c, videoPath); err != nil {
+		return err
+	}
+	return s.StreamVideo(c, videoPath)
+}
+
 // validateVideoPath validates and sanitizes the video path
 func (s *StreamService) validateVideoPath(videoPath string) error {
 	// Get absolute path
@@ -87,15 +181,19 @@ func (s *StreamService) validateVideoPath(videoPath string) error {
 		return fmt.Errorf("invalid stream directory: %w", err)
 	}
 
-	// Ensure video is within stream directory
-	if !strings.HasPrefix(absPath, streamDir) {
-		return fmt.Errorf("video path is outside stream directory")
+	// Ensure video is within stream directory, not a sibling sharing its
+	// name as a prefix
+	if !strings.HasPrefix(absPath, streamDir+string(filepath.Separator)) {
+		return ErrVideoAccessDenied
 	}
 
 	// Check if file exists
 	if _, err := os.Stat(absPath); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("video not found")
+			return ErrVideoNotFound
+		}
+		if os.IsPermission(err) {
+			return ErrVideoAccessDenied
 		}
 		return fmt.Errorf("failed to access video: %w", err)
 	}
@@ -103,6 +201,41 @@ func (s *StreamService) validateVideoPath(videoPath string) error {
 	return nil
 }
 
+// videoETag returns a strong ETag for a video derived from its size and
+// modification time. If-Range only accepts strong validators, and a video
+// is only replaced whole, so the pair identifies its content.
+func videoETag(stat os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", stat.Size(), stat.ModTime().UnixNano())
+}
+
+// ifNoneMatchHit reports whether an If-None-Match header is "*" or lists
+// etag. Unlike If-Range, the comparison is weak, so W/ prefixes are ignored.
+func ifNoneMatchHit(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether an If-Range validator still describes the
+// file: an ETag must match etag exactly, as weak ETags never match, and an
+// HTTP date must equal modTime to the second
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		return ifRange == etag
+	}
+
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return date.Unix() == modTime.Unix()
+}
+
 // parseRange parses the Range header
 func (s *StreamService) parseRange(rangeHeader string, fileSize int64) (start, end int64, err error) {
 	// Range format: bytes=start-end
@@ -224,34 +357,182 @@ func (s *StreamService) getContentType(filename string) string {
 	}
 }
 
-// StreamHLS handles HLS (HTTP Live Streaming) requests
+// StreamHLS handles HLS (HTTP Live Streaming) requests. With
+// STREAM_HLS_SIGNED_URLS enabled, segments need the signature the playlist
+// added to their URI, and playlists need one unless the caller is
+// authenticated.
 func (s *StreamService) StreamHLS(c *gin.Context, playlistPath string) error {
-	// Validate playlist path
-	if err := s.validateVideoPath(playlistPath); err != nil {
-		return err
-	}
-
-	// Check if it's a playlist or segment request
+	// Check if it's a playlist or segment request. Signatures are verified
+	// before the path, so a rejected request cannot tell whether the file
+	// exists.
 	if strings.HasSuffix(playlistPath, ".m3u8") {
+		if s.config.Stream.HLSSignedURLs {
+			_, authenticated := utils.UserIDFromContext(c)
+			if !authenticated || c.Query("signature") != "" {
+				if err := s.verifySignedRequest(c, playlistPath); err != nil {
+					return err
+				}
+			}
+		}
+		if err := s.validateVideoPath(playlistPath); err != nil {
+			return err
+		}
 		return s.serveHLSPlaylist(c, playlistPath)
 	} else if strings.HasSuffix(playlistPath, ".ts") {
+		if s.config.Stream.HLSSignedURLs {
+			if err := s.verifySignedRequest(c, playlistPath); err != nil {
+				return err
+			}
+		}
+		if err := s.validateVideoPath(playlistPath); err != nil {
+			return err
+		}
 		return s.serveHLSSegment(c, playlistPath)
 	}
 
 	return fmt.Errorf("unsupported HLS file type")
 }
 
+// SignStreamURL signs a resource, a slash-separated path relative to the
+// stream directory, returning the expiry and signature query parameters
+// that make a URL for it valid for STREAM_SIGNED_URL_TTL
+func (s *StreamService) SignStreamURL(resource string) (expires int64, signature string) {
+	expires = time.Now().Add(s.config.Stream.SignedURLTTL).Unix()
+	return expires, s.signature(resource, expires)
+}
+
+// VerifyStreamSignature checks the expiry and signature query parameters of
+// a signed URL for resource
+func (s *StreamService) VerifyStreamSignature(resource, expires, signature string) error {
+	if signature == "" {
+		return ErrSignatureRequired
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	given, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(given, s.mac(resource, expiresAt)) {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().Unix() > expiresAt {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// signature returns the hex HMAC-SHA256 of a resource and its expiry
+func (s *StreamService) signature(resource string, expires int64) string {
+	return hex.EncodeToString(s.mac(resource, expires))
+}
+
+// mac computes the HMAC-SHA256 of a resource and its expiry
+func (s *StreamService) mac(resource string, expires int64) []byte {
+	key := s.config.Stream.SigningKey
+	if key == "" {
+		key = s.config.JWT.Secret
+	}
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(resource + "\n" + strconv.FormatInt(expires, 10)))
+	return h.Sum(nil)
+}
+
+// verifySignedRequest checks the signature query parameters of a request
+// for a file in the stream directory
+func (s *StreamService) verifySignedRequest(c *gin.Context, filePath string) error {
+	resource, err := s.streamResource(filePath)
+	if err != nil {
+		return err
+	}
+	return s.VerifyStreamSignature(resource, c.Query("expires"), c.Query("signature"))
+}
+
+// streamResource converts a file path into the slash-separated path relative
+// to the stream directory that signatures cover
+func (s *StreamService) streamResource(filePath string) (string, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", fmt.Errorf("invalid video path: %w", err)
+	}
+	streamDir, err := filepath.Abs(s.config.Stream.Path)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream directory: %w", err)
+	}
+	rel, err := filepath.Rel(streamDir, absPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid video path: %w", err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// signPlaylist appends a signature to every relative URI in a playlist, so
+// the segments and variant playlists it lists can be fetched without an
+// Authorization header. Absolute URIs point elsewhere and are left as is.
+func (s *StreamService) signPlaylist(content []byte, playlistPath string) ([]byte, error) {
+	resource, err := s.streamResource(playlistPath)
+	if err != nil {
+		return nil, err
+	}
+	dir := path.Dir(resource)
+
+	sign := func(uri string) string {
+		if uri == "" || strings.HasPrefix(uri, "/") || strings.Contains(uri, "://") {
+			return uri
+		}
+		target := uri
+		if i := strings.IndexAny(target, "?#"); i >= 0 {
+			target = target[:i]
+		}
+		expires, signature := s.SignStreamURL(path.Join(dir, target))
+		sep := "?"
+		if strings.Contains(uri, "?") {
+			sep = "&"
+		}
+		return fmt.Sprintf("%s%sexpires=%d&signature=%s", uri, sep, expires, signature)
+	}
+
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			line = hlsURIAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + sign(hlsURIAttribute.FindStringSubmatch(attr)[1]) + `"`
+			})
+		default:
+			line = sign(line)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
 // serveHLSPlaylist serves HLS playlist files
 func (s *StreamService) serveHLSPlaylist(c *gin.Context, playlistPath string) error {
 	// Read playlist file
 	content, err := os.ReadFile(playlistPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("playlist not found")
+			return ErrVideoNotFound
 		}
 		return fmt.Errorf("failed to read playlist: %w", err)
 	}
 
+	if s.config.Stream.HLSSignedURLs {
+		if content, err = s.signPlaylist(content, playlistPath); err != nil {
+			return err
+		}
+	}
+
 	// Set headers
 	c.Header("Content-Type", "application/x-mpegURL")
 	c.Header("Cache-Control", "no-cache")
@@ -267,7 +548,7 @@ func (s *StreamService) serveHLSSegment(c *gin.Context, segmentPath string) erro
 	segment, err := os.Open(segmentPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("segment not found")
+			return ErrVideoNotFound
 		}
 		return fmt.Errorf("failed to open segment: %w", err)
 	}
@@ -468,8 +749,25 @@ func (s *StreamService) GetAvailableQualities(videoID string) ([]QualityLevel, e
 	return available, nil
 }
 
-// TranscodeVideo transcodes video to different qualities
+// TranscodeVideo transcodes video to different qualities, returning
+// ErrTranscodeInProgress when another instance holds the video's lock
 func (s *StreamService) TranscodeVideo(inputPath string, qualities []QualityLevel) error {
+	lockKey := "transcode:" + filepath.Clean(inputPath)
+	err := s.redis.WithLock(lockKey, transcodeLockTTL, func() error {
+		return s.transcode(inputPath, qualities)
+	})
+	switch {
+	case errors.Is(err, ErrLockNotAcquired):
+		return ErrTranscodeInProgress
+	case errors.Is(err, ErrRedisUnavailable):
+		return s.transcode(inputPath, qualities)
+	default:
+		return err
+	}
+}
+
+// transcode performs the transcoding of a single video
+func (s *StreamService) transcode(inputPath string, qualities []QualityLevel) error {
 	// This is a placeholder for video transcoding
 	// You would use ffmpeg or similar tool to transcode videos
 	// Example:
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/config"
+)
+
+// newVideoRouter serves the video at path through StreamVideo on /video
+func newVideoRouter(path string) *gin.Engine {
+	streamService := NewStreamService(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/video", func(c *gin.Context) {
+		if err := streamService.StreamVideo(c, path); err != nil {
+			c.Status(http.StatusInternalServerError)
+		}
+	})
+	return router
+}
+
+// writeVideo writes content to name in the stream directory with modTime
+func writeVideo(t *testing.T, name, content string, modTime time.Time) string {
+	t.Helper()
+
+	path := filepath.Join(config.Get().Stream.Path, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write video: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set video times: %v", err)
+	}
+	return path
+}
+
+// statETag returns the ETag of the file at path
+func statETag(t *testing.T, path string) string {
+	t.Helper()
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat video: %v", err)
+	}
+	return videoETag(stat)
+}
+
+func TestVideoETag(t *testing.T) {
+	loadTestConfig(t, map[string]string{"STREAM_PATH": t.TempDir()})
+	modTime := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	path := writeVideo(t, "intro.mp4", "intro video", modTime)
+
+	etag := statETag(t, path)
+	if etag[0] != '"' || etag[len(etag)-1] != '"' {
+		t.Errorf("ETag %s is not a strong, quoted validator", etag)
+	}
+	if again := statETag(t, path); again != etag {
+		t.Errorf("ETag of an unchanged file changed from %s to %s", etag, again)
+	}
+
+	// Same content rewritten later, and new content of the same size at
+	// the same time, are both new versions
+	writeVideo(t, "intro.mp4", "intro video", modTime.Add(time.Second))
+	if touched := statETag(t, path); touched == etag {
+		t.Error("ETag did not change with the modification time")
+	}
+	writeVideo(t, "intro.mp4", "longer intro video", modTime)
+	if resized := statETag(t, path); resized == etag {
+		t.Error("ETag did not change with the size")
+	}
+}
+
+func TestStreamVideoIfNoneMatch(t *testing.T) {
+	loadTestConfig(t, map[string]string{"STREAM_PATH": t.TempDir()})
+	path := writeVideo(t, "intro.mp4", "intro video", time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC))
+	router := newVideoRouter(path)
+	etag := statETag(t, path)
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantCode    int
+	}{
+		{"no validator", "", http.StatusOK},
+		{"current ETag", etag, http.StatusNotModified},
+		{"weak current ETag", "W/" + etag, http.StatusNotModified},
+		{"listed among others", `"stale", ` + etag, http.StatusNotModified},
+		{"any version", "*", http.StatusNotModified},
+		{"stale ETag", `"stale"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/video", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			if got := recorder.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %s, want %s", got, etag)
+			}
+			wantBody := "intro video"
+			if tt.wantCode == http.StatusNotModified {
+				wantBody = ""
+			}
+			if recorder.Body.String() != wantBody {
+				t.Errorf("body = %q, want %q", recorder.Body, wantBody)
+			}
+		})
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	etag := `"b-18a"`
+	modTime := time.Date(2026, 3, 4, 5, 6, 7, 500_000_000, time.UTC)
+
+	tests := []struct {
+		name    string
+		ifRange string
+		want    bool
+	}{
+		{"matching ETag", etag, true},
+		{"matching ETag with spaces", " " + etag + " ", true},
+		{"stale ETag", `"b-17f"`, false},
+		{"weak ETag", "W/" + etag, false},
+		{"matching date", modTime.Format(http.TimeFormat), true},
+		{"older date", modTime.Add(-time.Minute).Format(http.TimeFormat), false},
+		{"newer date", modTime.Add(time.Minute).Format(http.TimeFormat), false},
+		{"malformed", "yesterday", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ifRangeMatches(tt.ifRange, etag, modTime); got != tt.want {
+				t.Errorf("ifRangeMatches(%q) = %v, want %v", tt.ifRange, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamVideoIfRange(t *testing.T) {
+	loadTestConfig(t, map[string]string{"STREAM_PATH": t.TempDir()})
+	modTime := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	path := writeVideo(t, "intro.mp4", "intro video", modTime)
+	router := newVideoRouter(path)
+	etag := statETag(t, path)
+
+	tests := []struct {
+		name     string
+		ifRange  string
+		wantCode int
+		wantBody string
+	}{
+		{"no validator", "", http.StatusPartialContent, "intro"},
+		{"matching ETag", etag, http.StatusPartialContent, "intro"},
+		{"stale ETag", `"stale"`, http.StatusOK, "intro video"},
+		{"weak ETag", "W/" + etag, http.StatusOK, "intro video"},
+		{"matching date", modTime.Format(http.TimeFormat), http.StatusPartialContent, "intro"},
+		{"stale date", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, "intro video"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/video", nil)
+			req.Header.Set("Range", "bytes=0-4")
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.wantCode || recorder.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", recorder.Code, recorder.Body, tt.wantCode, tt.wantBody)
+			}
+			if tt.wantCode == http.StatusPartialContent {
+				if got := recorder.Header().Get("Content-Range"); got != "bytes 0-4/11" {
+					t.Errorf("Content-Range = %q, want bytes 0-4/11", got)
+				}
+			}
+		})
+	}
+}
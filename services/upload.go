@@ -1,32 +1,93 @@
 package services
 
 import (
-	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"go-api-boilerplate/config"
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
 	"go-api-boilerplate/utils"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+var (
+	// ErrUploadTooLarge is returned when an upload request exceeds the
+	// per-file or total size limit
+	ErrUploadTooLarge = errors.New("upload too large")
+	// ErrExtensionMismatch is returned when a file's content does not match
+	// the type its name claims
+	ErrExtensionMismatch = errors.New("file extension does not match its content")
+	// ErrEmptyFile is returned when an uploaded file has no content
+	ErrEmptyFile = errors.New("file is empty")
+	// ErrFileNotFound is returned when a stored file does not exist
+	ErrFileNotFound = errors.New("file not found")
+	// ErrFileAccessDenied is returned when a path resolves outside the
+	// upload directory or the file cannot be read
+	ErrFileAccessDenied = errors.New("file access denied")
+)
+
+// executableExtensions are rejected unless the content really is of that
+// type, as web servers and browsers may run or render them
+var executableExtensions = map[string]bool{
+	".php": true, ".phtml": true, ".phar": true, ".asp": true, ".aspx": true,
+	".jsp": true, ".cgi": true, ".pl": true, ".py": true, ".sh": true,
+	".exe": true, ".bat": true, ".cmd": true, ".js": true, ".mjs": true,
+	".html": true, ".htm": true, ".svg": true,
+}
+
+// Upload policies select the MIME types an endpoint accepts; see
+// config.UploadAllowedTypes
+const (
+	UploadPolicyDefault  = ""
+	UploadPolicyAvatar   = "avatar"
+	UploadPolicyDocument = "document"
+	UploadPolicyVideo    = "video"
+)
+
+// multipartOverhead is the room left above the file size limits for the
+// multipart boundaries, part headers and other form fields
+const multipartOverhead = 1 << 20
+
 // UploadService handles file upload operations
 type UploadService struct {
-	config *config.Config
+	db      *database.DB
+	config  *config.Config
+	scanner FileScanner
+	slots   *uploadSlots
 }
 
 // NewUploadService creates a new upload service
-func NewUploadService() *UploadService {
+func NewUploadService(db *database.DB) *UploadService {
+	cfg := config.Get()
 	return &UploadService{
-		config: config.Get(),
+		db:      db,
+		config:  cfg,
+		scanner: NewFileScanner(cfg),
+		slots:   newUploadSlots(),
+	}
+}
+
+// SetScanner replaces the scanner used to inspect uploads
+func (s *UploadService) SetScanner(scanner FileScanner) {
+	if scanner == nil {
+		scanner = NoopScanner{}
 	}
+	s.scanner = scanner
 }
 
 // FileInfo represents uploaded file information
@@ -42,64 +103,70 @@ type FileInfo struct {
 	UploadedAt   time.Time `json:"uploaded_at"`
 }
 
-// UploadFile handles single file upload
-func (s *UploadService) UploadFile(c *gin.Context, formField string) (*FileInfo, error) {
-	// Get file from form
-	file, header, err := c.Request.FormFile(formField)
+// UploadFile handles single file upload, accepting the MIME types of the
+// given upload policy. It takes one of the user's concurrent upload slots
+// for the duration and fails with ErrTooManyUploads when none is free.
+func (s *UploadService) UploadFile(c *gin.Context, formField, policy string) (*FileInfo, error) {
+	release, err := s.acquireUploadSlot(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get file from form: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	defer release()
 
-	// Validate file size
-	if header.Size > s.config.Upload.MaxSize {
-		return nil, fmt.Errorf("file size exceeds maximum allowed size of %d bytes", s.config.Upload.MaxSize)
-	}
+	return s.uploadFile(c, formField, policy)
+}
 
-	// Detect MIME type
-	mtype, err := s.detectMimeType(file)
+// uploadFile stores the file in formField without taking an upload slot
+func (s *UploadService) uploadFile(c *gin.Context, formField, policy string) (*FileInfo, error) {
+	maxSize := s.config.Upload.MaxSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+
+	// Get file from form
+	file, header, err := c.Request.FormFile(formField)
 	if err != nil {
-		return nil, fmt.Errorf("failed to detect file type: %w", err)
+		if tooLarge(err) {
+			return nil, fmt.Errorf("%w: file exceeds %d bytes", ErrUploadTooLarge, maxSize)
+		}
+		return nil, fmt.Errorf("failed to get file from form: %w", err)
 	}
+	defer file.Close()
 
-	// Validate MIME type
-	if !s.isAllowedType(mtype.String()) {
-		return nil, fmt.Errorf("file type %s is not allowed", mtype.String())
-	}
+	return s.processUploadedFile(file, header, s.config.UploadAllowedTypes(policy), uploaderOf(c, policy))
+}
 
-	// Generate unique filename
-	ext := filepath.Ext(header.Filename)
-	if ext == "" {
-		ext = mtype.Extension()
-	}
-	filename := s.generateUniqueFilename(ext)
+// fileOwner is who an upload is recorded against
+type fileOwner struct {
+	userID uint
+	// public files may be downloaded by any user, as avatars are
+	public bool
+}
 
-	// Create upload directory
-	uploadPath := s.getUploadPath()
-	if err := os.MkdirAll(uploadPath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create upload directory: %w", err)
-	}
+// uploaderOf returns the owner of a file uploaded in request c under
+// policy
+func uploaderOf(c *gin.Context, policy string) fileOwner {
+	userID, _ := utils.UserIDFromContext(c)
+	return fileOwner{userID: userID, public: policy == UploadPolicyAvatar}
+}
 
-	// Save file
-	filePath := filepath.Join(uploadPath, filename)
-	fileInfo, err := s.saveFile(file, filePath, header)
+// UploadMultipleFiles handles multiple file uploads under an upload policy.
+// Besides the per-file limit, the files of one request together may not
+// exceed UPLOAD_MAX_TOTAL_SIZE; a request over it is rejected before any
+// file is stored. The request takes a single upload slot, like UploadFile.
+func (s *UploadService) UploadMultipleFiles(c *gin.Context, formField, policy string) ([]*FileInfo, error) {
+	release, err := s.acquireUploadSlot(c)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save file: %w", err)
+		return nil, err
 	}
+	defer release()
 
-	// Update file info
-	fileInfo.OriginalName = header.Filename
-	fileInfo.MimeType = mtype.String()
-	fileInfo.Extension = ext
-	fileInfo.URL = s.getFileURL(filename)
-
-	return fileInfo, nil
-}
+	maxTotal := s.config.Upload.MaxTotalSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxTotal+multipartOverhead)
 
-// UploadMultipleFiles handles multiple file uploads
-func (s *UploadService) UploadMultipleFiles(c *gin.Context, formField string) ([]*FileInfo, error) {
 	form, err := c.MultipartForm()
 	if err != nil {
+		if tooLarge(err) {
+			return nil, fmt.Errorf("%w: files exceed %d bytes in total", ErrUploadTooLarge, maxTotal)
+		}
 		return nil, fmt.Errorf("failed to parse multipart form: %w", err)
 	}
 
@@ -108,6 +175,16 @@ func (s *UploadService) UploadMultipleFiles(c *gin.Context, formField string) ([
 		return nil, fmt.Errorf("no files found in form field: %s", formField)
 	}
 
+	var total int64
+	for _, fileHeader := range files {
+		total += fileHeader.Size
+	}
+	if total > maxTotal {
+		return nil, fmt.Errorf("%w: files exceed %d bytes in total", ErrUploadTooLarge, maxTotal)
+	}
+
+	allowed := s.config.UploadAllowedTypes(policy)
+	owner := uploaderOf(c, policy)
 	var uploadedFiles []*FileInfo
 	var errors []string
 
@@ -117,10 +194,10 @@ func (s *UploadService) UploadMultipleFiles(c *gin.Context, formField string) ([
 			errors = append(errors, fmt.Sprintf("%s: failed to open", fileHeader.Filename))
 			continue
 		}
-		defer file.Close()
 
-		// Process each file
-		fileInfo, err := s.processUploadedFile(file, fileHeader)
+		// Process each file, closing it before the next one is opened
+		fileInfo, err := s.processUploadedFile(file, fileHeader, allowed, owner)
+		file.Close()
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("%s: %v", fileHeader.Filename, err))
 			continue
@@ -136,11 +213,22 @@ func (s *UploadService) UploadMultipleFiles(c *gin.Context, formField string) ([
 	return uploadedFiles, nil
 }
 
-// processUploadedFile processes a single uploaded file
-func (s *UploadService) processUploadedFile(file multipart.File, header *multipart.FileHeader) (*FileInfo, error) {
+// tooLarge reports whether a form parsing error came from a request body
+// size limit
+func tooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
+
+// processUploadedFile validates, scans and stores a single uploaded file
+// whose MIME type must be one of allowed, recording it against owner
+func (s *UploadService) processUploadedFile(file multipart.File, header *multipart.FileHeader, allowed []string, owner fileOwner) (*FileInfo, error) {
 	// Validate file size
+	if header.Size == 0 {
+		return nil, ErrEmptyFile
+	}
 	if header.Size > s.config.Upload.MaxSize {
-		return nil, fmt.Errorf("file size exceeds maximum allowed size")
+		return nil, fmt.Errorf("file size exceeds maximum allowed size of %d bytes", s.config.Upload.MaxSize)
 	}
 
 	// Detect MIME type
@@ -150,16 +238,46 @@ func (s *UploadService) processUploadedFile(file multipart.File, header *multipa
 	}
 
 	// Validate MIME type
-	if !s.isAllowedType(mtype.String()) {
-		return nil, fmt.Errorf("file type not allowed")
+	if !isAllowedType(mtype.String(), allowed) {
+		return nil, fmt.Errorf("file type %s is not allowed", mtype.String())
 	}
 
-	// Generate unique filename
-	ext := filepath.Ext(header.Filename)
-	if ext == "" {
-		ext = mtype.Extension()
+	// The claimed extension must agree with the content
+	originalName := SanitizeFilename(header.Filename)
+	if err := checkExtension(filepath.Ext(originalName), mtype); err != nil {
+		return nil, err
+	}
+
+	// Reject pixel bombs before anything decodes them
+	if err := s.checkUploadedImage(file, mtype.String()); err != nil {
+		return nil, err
+	}
+
+	// Scan content before anything is written to disk
+	if err := s.scanFile(file); err != nil {
+		return nil, err
+	}
+
+	// Reuse an identical stored file when deduplication is enabled
+	if s.dedupEnabled() {
+		contentHash, err := hashFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash file: %w", err)
+		}
+
+		existing, err := s.acquireStoredFile(contentHash, owner)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return s.storedFileInfo(existing, originalName), nil
+		}
 	}
-	filename := s.generateUniqueFilename(ext)
+
+	// Generate unique filename; the stored extension always comes from the
+	// detected type, never from the claimed name
+	ext := mtype.Extension()
+	filename := s.generateFilename(originalName, ext)
 
 	// Create upload directory
 	uploadPath := s.getUploadPath()
@@ -175,14 +293,183 @@ func (s *UploadService) processUploadedFile(file multipart.File, header *multipa
 	}
 
 	// Update file info
-	fileInfo.OriginalName = header.Filename
+	fileInfo.OriginalName = originalName
 	fileInfo.MimeType = mtype.String()
 	fileInfo.Extension = ext
-	fileInfo.URL = s.getFileURL(filename)
+	fileInfo.URL = s.getFileURL(filePath)
+
+	if s.tracksFiles() {
+		return s.registerStoredFile(fileInfo, owner)
+	}
 
 	return fileInfo, nil
 }
 
+// tracksFiles reports whether uploads are recorded in the stored_files
+// table. Without it only admins can download them, see AuthorizeFile.
+func (s *UploadService) tracksFiles() bool {
+	return s.db != nil && !database.IsMongoDB()
+}
+
+// dedupEnabled reports whether content-addressed storage is active
+func (s *UploadService) dedupEnabled() bool {
+	return s.config.Upload.Dedup && s.tracksFiles()
+}
+
+// acquireStoredFile looks up an identical file stored by the same owner and
+// takes a reference on it. Files are never shared between users, so one
+// user's uploads cannot reveal what another has stored.
+func (s *UploadService) acquireStoredFile(hash string, owner fileOwner) (*models.StoredFile, error) {
+	var stored models.StoredFile
+	err := s.db.Write.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND hash = ? AND public = ?", owner.userID, hash, owner.public).First(&stored).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&stored).UpdateColumn("ref_count", gorm.Expr("ref_count + ?", 1)).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to look up stored file: %w", err)
+	}
+
+	// The blob may have been removed out of band; store it again
+	if _, err := os.Stat(stored.Path); err != nil {
+		s.db.Write.Delete(&stored)
+		return nil, nil
+	}
+
+	return &stored, nil
+}
+
+// registerStoredFile records a newly written file against its owner. The
+// file is removed again when it cannot be recorded, as nobody but an admin
+// could download it. Two identical uploads racing each other are both
+// kept.
+func (s *UploadService) registerStoredFile(fileInfo *FileInfo, owner fileOwner) (*FileInfo, error) {
+	stored := &models.StoredFile{
+		Hash:         fileInfo.Hash,
+		Path:         fileInfo.Path,
+		UserID:       owner.userID,
+		Public:       owner.public,
+		Filename:     fileInfo.Filename,
+		OriginalName: fileInfo.OriginalName,
+		Size:         fileInfo.Size,
+		MimeType:     fileInfo.MimeType,
+		Extension:    fileInfo.Extension,
+		RefCount:     1,
+	}
+
+	if err := s.db.Write.Create(stored).Error; err != nil {
+		os.Remove(fileInfo.Path)
+		return nil, fmt.Errorf("failed to record stored file: %w", err)
+	}
+
+	return fileInfo, nil
+}
+
+// recordFile records a file the service wrote itself, such as an avatar
+// variant, against owner. The file is removed when it cannot be recorded.
+func (s *UploadService) recordFile(path, mimeType, extension string, owner fileOwner) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	stored := &models.StoredFile{
+		Path:      path,
+		UserID:    owner.userID,
+		Public:    owner.public,
+		Filename:  filepath.Base(path),
+		Size:      stat.Size(),
+		MimeType:  mimeType,
+		Extension: extension,
+		RefCount:  1,
+	}
+	if err := s.db.Write.Create(stored).Error; err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to record stored file: %w", err)
+	}
+	return nil
+}
+
+// storedFileInfo builds the FileInfo for a deduplicated upload
+func (s *UploadService) storedFileInfo(stored *models.StoredFile, originalName string) *FileInfo {
+	return &FileInfo{
+		Filename:     stored.Filename,
+		OriginalName: originalName,
+		Size:         stored.Size,
+		MimeType:     stored.MimeType,
+		Extension:    stored.Extension,
+		Path:         stored.Path,
+		URL:          s.getFileURL(stored.Path),
+		Hash:         stored.Hash,
+		UploadedAt:   stored.CreatedAt,
+	}
+}
+
+// releaseStoredFile drops a reference to a stored file. It reports whether
+// the blob should be deleted from disk and whether the path was tracked.
+func (s *UploadService) releaseStoredFile(path string) (removeBlob bool, tracked bool, err error) {
+	err = s.db.Write.Transaction(func(tx *gorm.DB) error {
+		var stored models.StoredFile
+		if err := tx.Where("path = ?", path).First(&stored).Error; err != nil {
+			return err
+		}
+		tracked = true
+
+		if stored.RefCount > 1 {
+			return tx.Model(&stored).UpdateColumn("ref_count", gorm.Expr("ref_count - ?", 1)).Error
+		}
+
+		removeBlob = true
+		return tx.Delete(&stored).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, false, nil
+	}
+	return removeBlob, tracked, err
+}
+
+// hashFile computes the SHA-256 of the file and rewinds it
+func hashFile(file multipart.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// scanFile runs the configured scanner and rewinds the file afterwards
+func (s *UploadService) scanFile(file multipart.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	clean, reason, err := s.scanner.Scan(file)
+	if err != nil {
+		return fmt.Errorf("failed to scan file: %w", err)
+	}
+	if !clean {
+		return &FileRejectedError{Reason: reason}
+	}
+
+	// Rewind so the file can be saved
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
 // saveFile saves the uploaded file to disk
 func (s *UploadService) saveFile(src multipart.File, destPath string, header *multipart.FileHeader) (*FileInfo, error) {
 	// Create destination file
@@ -198,7 +485,7 @@ func (s *UploadService) saveFile(src multipart.File, destPath string, header *mu
 	}
 
 	// Create hash calculator
-	hash := md5.New()
+	hash := sha256.New()
 
 	// Copy file and calculate hash
 	writer := io.MultiWriter(dst, hash)
@@ -220,19 +507,23 @@ func (s *UploadService) saveFile(src multipart.File, destPath string, header *mu
 	}, nil
 }
 
-// detectMimeType detects the MIME type of a file
+// detectMimeType detects the MIME type of a file from its first bytes,
+// returning ErrEmptyFile when it has none
 func (s *UploadService) detectMimeType(file multipart.File) (*mimetype.MIME, error) {
 	// Reset file pointer
 	if _, err := file.Seek(0, 0); err != nil {
 		return nil, err
 	}
 
-	// Read first 512 bytes for detection
+	// Read first 512 bytes for detection; shorter files end the read early
 	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
+	if n == 0 {
+		return nil, ErrEmptyFile
+	}
 
 	// Reset file pointer again
 	if _, err := file.Seek(0, 0); err != nil {
@@ -243,9 +534,40 @@ func (s *UploadService) detectMimeType(file multipart.File) (*mimetype.MIME, err
 	return mimetype.Detect(buffer[:n]), nil
 }
 
-// isAllowedType checks if a MIME type is allowed
-func (s *UploadService) isAllowedType(mimeType string) bool {
-	for _, allowed := range s.config.Upload.AllowedTypes {
+// checkExtension rejects a claimed extension that names a different type
+// than the detected content, such as image.jpg holding a script. An
+// extension of an unknown type is accepted, since the file is stored
+// under the detected type's extension, except for executable extensions.
+func checkExtension(claimed string, detected *mimetype.MIME) error {
+	claimed = strings.ToLower(claimed)
+	if claimed == "" {
+		return nil
+	}
+
+	claimedType := ""
+	if t := mime.TypeByExtension(claimed); t != "" {
+		claimedType, _, _ = mime.ParseMediaType(t)
+	}
+	for m := detected; m != nil; m = m.Parent() {
+		if m.Extension() == claimed || (claimedType != "" && m.Is(claimedType)) {
+			return nil
+		}
+	}
+
+	// Plain text is the fallback for any text content, such as .csv or .md
+	if claimedType != "" && strings.HasPrefix(claimedType, "text/") && detected.Is("text/plain") && !executableExtensions[claimed] {
+		return nil
+	}
+	if claimedType == "" && !executableExtensions[claimed] {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s content cannot be stored as %s", ErrExtensionMismatch, detected.String(), claimed)
+}
+
+// isAllowedType checks if a MIME type is in an allowed list
+func isAllowedType(mimeType string, allowedTypes []string) bool {
+	for _, allowed := range allowedTypes {
 		if allowed == mimeType {
 			return true
 		}
@@ -260,6 +582,29 @@ func (s *UploadService) isAllowedType(mimeType string) bool {
 	return false
 }
 
+// Filename strategies for stored uploads
+const (
+	FilenameStrategyRandom = "random"
+	FilenameStrategySlug   = "slug"
+	FilenameStrategyUUID   = "uuid"
+)
+
+// generateFilename generates a unique filename using the configured strategy
+func (s *UploadService) generateFilename(originalName, extension string) string {
+	switch s.config.Upload.FilenameStrategy {
+	case FilenameStrategyUUID:
+		return utils.GenerateUUID() + extension
+	case FilenameStrategySlug:
+		slug := slugify(strings.TrimSuffix(originalName, filepath.Ext(originalName)))
+		if slug == "" {
+			slug = "file"
+		}
+		return fmt.Sprintf("%s_%s%s", slug, strings.ToLower(utils.GenerateRandomString(6)), extension)
+	default:
+		return s.generateUniqueFilename(extension)
+	}
+}
+
 // generateUniqueFilename generates a unique filename
 func (s *UploadService) generateUniqueFilename(extension string) string {
 	timestamp := time.Now().Unix()
@@ -267,6 +612,73 @@ func (s *UploadService) generateUniqueFilename(extension string) string {
 	return fmt.Sprintf("%d_%s%s", timestamp, random, extension)
 }
 
+// reservedFilenames are device names that cannot be used as files on Windows
+var reservedFilenames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename strips directories, control characters and reserved
+// names from a client supplied filename so it is safe to store and echo
+// back in Content-Disposition
+func SanitizeFilename(name string) string {
+	// Drop any directory components, including Windows separators
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+
+	base := strings.ToUpper(strings.TrimSuffix(name, filepath.Ext(name)))
+	if name == "" || reservedFilenames[base] {
+		return "file" + filepath.Ext(name)
+	}
+
+	// Keep names within common filesystem limits
+	if len(name) > 200 {
+		ext := filepath.Ext(name)
+		if len(ext) > 20 {
+			ext = ""
+		}
+		name = name[:200-len(ext)] + ext
+		name = strings.ToValidUTF8(name, "")
+	}
+
+	return name
+}
+
+// slugify lowercases a name and replaces anything but ASCII letters and
+// digits with single hyphens
+func slugify(name string) string {
+	var b strings.Builder
+	lastHyphen := true
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			lastHyphen = false
+			continue
+		}
+		if !lastHyphen {
+			b.WriteByte('-')
+			lastHyphen = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > 64 {
+		slug = strings.TrimSuffix(slug[:64], "-")
+	}
+	return slug
+}
+
 // getUploadPath returns the upload directory path
 func (s *UploadService) getUploadPath() string {
 	// Create date-based subdirectory
@@ -275,11 +687,14 @@ func (s *UploadService) getUploadPath() string {
 	return filepath.Join(s.config.Upload.Path, subDir)
 }
 
-// getFileURL returns the URL for accessing a file
-func (s *UploadService) getFileURL(filename string) string {
-	// This should be configured based on your setup
-	// For example, if serving files through a CDN or specific route
-	return fmt.Sprintf("/uploads/%s", filename)
+// getFileURL returns the URL the /uploads route serves a stored file at,
+// which keeps its date-based subdirectories
+func (s *UploadService) getFileURL(filePath string) string {
+	rel, err := filepath.Rel(s.config.Upload.Path, filePath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(filePath)
+	}
+	return "/uploads/" + filepath.ToSlash(rel)
 }
 
 // DeleteFile deletes a file from storage
@@ -296,10 +711,24 @@ func (s *UploadService) DeleteFile(filePath string) error {
 	}
 
 	// Security check: ensure file is within upload directory
-	if !strings.HasPrefix(absPath, uploadDir) {
+	if !strings.HasPrefix(absPath, uploadDir+string(filepath.Separator)) {
 		return fmt.Errorf("file path is outside upload directory")
 	}
 
+	// Shared blobs are only removed when the last reference goes away
+	if s.tracksFiles() {
+		removeBlob, tracked, err := s.releaseStoredFile(filePath)
+		if err == nil && !tracked && filePath != absPath {
+			removeBlob, tracked, err = s.releaseStoredFile(absPath)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to release stored file: %w", err)
+		}
+		if tracked && !removeBlob {
+			return nil
+		}
+	}
+
 	// Delete the file
 	if err := os.Remove(absPath); err != nil {
 		if os.IsNotExist(err) {
@@ -311,6 +740,47 @@ func (s *UploadService) DeleteFile(filePath string) error {
 	return nil
 }
 
+// DeleteUserFiles deletes every file recorded against a user and its
+// record, shared or not, as when the user is permanently deleted. Files
+// that cannot be removed keep their record and are reported in the error.
+func (s *UploadService) DeleteUserFiles(userID uint) error {
+	if !s.tracksFiles() || userID == 0 {
+		return nil
+	}
+
+	var stored []models.StoredFile
+	if err := s.db.Write.Where("user_id = ?", userID).Find(&stored).Error; err != nil {
+		return fmt.Errorf("failed to list stored files: %w", err)
+	}
+
+	var failed []string
+	for i := range stored {
+		if err := os.Remove(stored[i].Path); err != nil && !os.IsNotExist(err) {
+			failed = append(failed, fmt.Sprintf("%s: %v", stored[i].Path, err))
+			continue
+		}
+		if err := s.db.Write.Delete(&stored[i]).Error; err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", stored[i].Path, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to delete %d of %d files: %s", len(failed), len(stored), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// DeleteURL deletes the stored file served at an /uploads URL, as returned
+// in FileInfo.URL. URLs outside /uploads do not refer to a stored file and
+// are ignored.
+func (s *UploadService) DeleteURL(fileURL string) error {
+	rel, ok := strings.CutPrefix(fileURL, "/uploads/")
+	if !ok || rel == "" {
+		return nil
+	}
+	return s.DeleteFile(filepath.Join(s.config.Upload.Path, filepath.FromSlash(rel)))
+}
+
 // GetFileInfo retrieves information about a file
 func (s *UploadService) GetFileInfo(filePath string) (*FileInfo, error) {
 	// Get file stats
@@ -339,7 +809,7 @@ func (s *UploadService) GetFileInfo(filePath string) (*FileInfo, error) {
 
 	// Calculate file hash
 	file.Seek(0, 0)
-	hash := md5.New()
+	hash := sha256.New()
 	if _, err := io.Copy(hash, file); err != nil {
 		return nil, fmt.Errorf("failed to calculate hash: %w", err)
 	}
@@ -351,7 +821,7 @@ func (s *UploadService) GetFileInfo(filePath string) (*FileInfo, error) {
 		MimeType:   mtype.String(),
 		Extension:  filepath.Ext(filePath),
 		Path:       filePath,
-		URL:        s.getFileURL(filepath.Base(filePath)),
+		URL:        s.getFileURL(filePath),
 		Hash:       hashSum,
 		UploadedAt: stat.ModTime(),
 	}, nil
@@ -360,6 +830,9 @@ func (s *UploadService) GetFileInfo(filePath string) (*FileInfo, error) {
 // ValidateFile validates a file before processing
 func (s *UploadService) ValidateFile(file multipart.File, header *multipart.FileHeader) error {
 	// Check file size
+	if header.Size == 0 {
+		return ErrEmptyFile
+	}
 	if header.Size > s.config.Upload.MaxSize {
 		return fmt.Errorf("file size %d exceeds maximum allowed size of %d bytes", header.Size, s.config.Upload.MaxSize)
 	}
@@ -370,15 +843,20 @@ func (s *UploadService) ValidateFile(file multipart.File, header *multipart.File
 		return fmt.Errorf("failed to detect file type: %w", err)
 	}
 
-	if !s.isAllowedType(mtype.String()) {
+	if !isAllowedType(mtype.String(), s.config.Upload.AllowedTypes) {
 		return fmt.Errorf("file type %s is not allowed", mtype.String())
 	}
 
-	return nil
+	return s.checkUploadedImage(file, mtype.String())
 }
 
 // ResizeImage resizes an uploaded image (requires additional image processing library)
 func (s *UploadService) ResizeImage(filePath string, width, height int) (string, error) {
+	// Never hand an oversized image to a decoder
+	if err := s.checkImageFile(filePath); err != nil {
+		return "", err
+	}
+
 	// This is a placeholder - implement actual image resizing logic
 	// You might want to use libraries like:
 	// - github.com/disintegration/imaging
@@ -390,6 +868,11 @@ func (s *UploadService) ResizeImage(filePath string, width, height int) (string,
 
 // CreateThumbnail creates a thumbnail for an image
 func (s *UploadService) CreateThumbnail(filePath string, maxWidth, maxHeight int) (string, error) {
+	// Never hand an oversized image to a decoder
+	if err := s.checkImageFile(filePath); err != nil {
+		return "", err
+	}
+
 	// This is a placeholder - implement actual thumbnail creation
 	// You might want to use libraries like:
 	// - github.com/disintegration/imaging
@@ -444,8 +927,43 @@ func (s *UploadService) ChunkedUpload(c *gin.Context, fileID string, chunkNumber
 	return nil, fmt.Errorf("chunked upload not implemented")
 }
 
-// ServeFile serves a file for download
-func (s *UploadService) ServeFile(c *gin.Context, filePath string) error {
+// AuthorizeFile checks that a user may download the stored file at
+// filePath: its uploader and admins always may, other users only when it
+// is public, like an avatar. Files without a record, such as those uploaded
+// before ownership was recorded or on MongoDB, are served to admins only.
+// Everything is allowed with UPLOAD_PUBLIC_FILES. It returns the file's
+// record, or nil when there is none, and ErrFileAccessDenied when denied.
+func (s *UploadService) AuthorizeFile(filePath string, userID uint, isAdmin bool) (*models.StoredFile, error) {
+	var stored *models.StoredFile
+	if s.tracksFiles() {
+		paths := []string{filePath}
+		if absPath, err := filepath.Abs(filePath); err == nil && absPath != filePath {
+			paths = append(paths, absPath)
+		}
+
+		var record models.StoredFile
+		err := s.db.Read.Where("path IN ?", paths).First(&record).Error
+		switch {
+		case err == nil:
+			stored = &record
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, fmt.Errorf("failed to look up stored file: %w", err)
+		}
+	}
+
+	if s.config.Upload.PublicFiles || isAdmin {
+		return stored, nil
+	}
+	if stored == nil || (!stored.Public && (userID == 0 || stored.UserID != userID)) {
+		return nil, ErrFileAccessDenied
+	}
+	return stored, nil
+}
+
+// ServeFile serves a file for download. downloadName is the original
+// filename recorded at upload time (FileInfo.OriginalName) and is sent in
+// Content-Disposition; the stored name is used when it is empty.
+func (s *UploadService) ServeFile(c *gin.Context, filePath string, downloadName string) error {
 	// Security check
 	absPath, err := filepath.Abs(filePath)
 	if err != nil {
@@ -457,19 +975,70 @@ func (s *UploadService) ServeFile(c *gin.Context, filePath string) error {
 		return fmt.Errorf("invalid upload directory: %w", err)
 	}
 
-	if !strings.HasPrefix(absPath, uploadDir) {
-		return fmt.Errorf("file path is outside upload directory")
+	if !strings.HasPrefix(absPath, uploadDir+string(filepath.Separator)) {
+		return ErrFileAccessDenied
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(absPath); err != nil {
+	// Open the file
+	file, err := os.Open(absPath)
+	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("file not found")
+			return ErrFileNotFound
+		}
+		if os.IsPermission(err) {
+			return ErrFileAccessDenied
 		}
 		return fmt.Errorf("failed to access file: %w", err)
 	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to access file: %w", err)
+	}
+	if stat.IsDir() {
+		return ErrFileNotFound
+	}
+
+	// Validators and content type enable conditional and range requests
+	etag, mimeType := s.fileValidators(filePath, absPath, file, stat)
+	c.Header("ETag", etag)
+	c.Header("Content-Type", mimeType)
 
-	// Serve the file
-	c.File(absPath)
+	// Suggest the original name to the browser
+	if downloadName == "" {
+		downloadName = filepath.Base(absPath)
+	}
+	downloadName = SanitizeFilename(downloadName)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": downloadName}))
+	// Uploaded HTML or SVG opened in the browser must not run script with
+	// the API's origin
+	c.Header("Content-Security-Policy", "sandbox")
+
+	// ServeContent handles Range, If-None-Match and If-Modified-Since
+	http.ServeContent(c.Writer, c.Request, downloadName, stat.ModTime(), file)
 	return nil
 }
+
+// fileValidators returns the ETag and MIME type for a served file. Recorded
+// files use their stored SHA-256 hash and MIME type; other files get a weak
+// ETag derived from size and modification time and a sniffed MIME type.
+func (s *UploadService) fileValidators(filePath, absPath string, file *os.File, stat os.FileInfo) (string, string) {
+	if s.tracksFiles() {
+		var stored models.StoredFile
+		err := s.db.Read.Where("path IN ?", []string{filePath, absPath}).First(&stored).Error
+		if err == nil && stored.Hash != "" && stored.MimeType != "" {
+			return fmt.Sprintf("%q", stored.Hash), stored.MimeType
+		}
+	}
+
+	etag := fmt.Sprintf("W/\"%x-%x\"", stat.Size(), stat.ModTime().UnixNano())
+
+	mimeType := "application/octet-stream"
+	if mtype, err := mimetype.DetectReader(file); err == nil {
+		mimeType = mtype.String()
+	}
+	file.Seek(0, io.SeekStart)
+
+	return etag, mimeType
+}
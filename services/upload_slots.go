@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrTooManyUploads is returned when a user already has
+// UPLOAD_MAX_CONCURRENT_PER_USER uploads in progress
+var ErrTooManyUploads = errors.New("too many concurrent uploads")
+
+// uploadSlotFallbackTTL bounds how long slots leaked in Redis by a crashed
+// instance survive when uploads have no deadline
+const uploadSlotFallbackTTL = time.Hour
+
+// uploadSlots counts the uploads each user has in progress. The counts live
+// in Redis when it is available so the limit holds across instances, and in
+// process otherwise.
+type uploadSlots struct {
+	redis *RedisService
+
+	mu   sync.Mutex
+	held map[uint]int
+}
+
+// newUploadSlots creates in-process upload slots
+func newUploadSlots() *uploadSlots {
+	return &uploadSlots{held: make(map[uint]int)}
+}
+
+// acquire claims one of limit slots for userID, returning ErrTooManyUploads
+// when all are taken. Should Redis fail, the in-process count is used so
+// uploads keep working on a per-instance limit.
+func (u *uploadSlots) acquire(ctx context.Context, userID uint, limit int, ttl time.Duration) (release func(), err error) {
+	if u.redis.Available() {
+		release, acquired, err := u.redis.WithContext(ctx).AcquireSlot(fmt.Sprintf("uploads:user:%d", userID), limit, ttl)
+		if err == nil {
+			if !acquired {
+				return nil, ErrTooManyUploads
+			}
+			return release, nil
+		}
+		logger.Warnf("Failed to claim upload slot in Redis, limiting uploads per instance: %v", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.held[userID] >= limit {
+		return nil, ErrTooManyUploads
+	}
+	u.held[userID]++
+
+	var once sync.Once
+	return func() { once.Do(func() { u.release(userID) }) }, nil
+}
+
+// release frees an in-process slot claimed by acquire
+func (u *uploadSlots) release(userID uint) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.held[userID] <= 1 {
+		delete(u.held, userID)
+	} else {
+		u.held[userID]--
+	}
+}
+
+// SetRedis shares the per-user upload slots across instances through redis
+func (s *UploadService) SetRedis(redis *RedisService) {
+	s.slots.redis = redis
+}
+
+// acquireUploadSlot claims one of the signed-in user's concurrent upload
+// slots. Anonymous uploads and a limit of 0 are not limited.
+func (s *UploadService) acquireUploadSlot(c *gin.Context) (release func(), err error) {
+	limit := s.config.Upload.MaxConcurrentPerUser
+	userID, ok := utils.UserIDFromContext(c)
+	if limit <= 0 || !ok || userID == 0 {
+		return func() {}, nil
+	}
+
+	// A slot must outlast the upload holding it, which the upload deadline
+	// bounds
+	ttl := uploadSlotFallbackTTL
+	if timeout := s.config.Server.UploadTimeout; timeout > 0 {
+		ttl = timeout + time.Minute
+	}
+	return s.slots.acquire(c.Request.Context(), userID, limit, ttl)
+}
@@ -0,0 +1,794 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/utils"
+)
+
+// testPNG returns a small PNG image whose pixels depend on seed
+func testPNG(t *testing.T, seed uint8) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = seed
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// uploadTestContext returns a Gin context for a multipart request from
+// userID with content in the form field "file"
+func uploadTestContext(t *testing.T, userID uint, filename string, content []byte) *gin.Context {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/upload", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	if userID != 0 {
+		c.Set(utils.ContextKeyUserID, userID)
+	}
+	return c
+}
+
+func newTestUploadService(t *testing.T, env map[string]string) *UploadService {
+	t.Helper()
+
+	loadTestConfig(t, env)
+	return NewUploadService(newTestDB(t))
+}
+
+func TestAuthorizeFileAllowsOwnerAndAdmins(t *testing.T) {
+	s := newTestUploadService(t, nil)
+
+	info, err := s.UploadFile(uploadTestContext(t, 1, "owned.png", testPNG(t, 1)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  uint
+		isAdmin bool
+		want    error
+	}{
+		{"owner", 1, false, nil},
+		{"other user", 2, false, ErrFileAccessDenied},
+		{"anonymous", 0, false, ErrFileAccessDenied},
+		{"admin", 2, true, nil},
+	}
+	for _, tt := range tests {
+		stored, err := s.AuthorizeFile(info.Path, tt.userID, tt.isAdmin)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if err == nil && (stored == nil || stored.UserID != 1) {
+			t.Errorf("%s: record = %+v, want one owned by user 1", tt.name, stored)
+		}
+	}
+}
+
+func TestAuthorizeFileWithoutRecordIsAdminOnly(t *testing.T) {
+	s := newTestUploadService(t, nil)
+	path := filepath.Join(s.config.Upload.Path, "legacy.png")
+
+	if _, err := s.AuthorizeFile(path, 1, false); !errors.Is(err, ErrFileAccessDenied) {
+		t.Errorf("user: got %v, want ErrFileAccessDenied", err)
+	}
+	if _, err := s.AuthorizeFile(path, 1, true); err != nil {
+		t.Errorf("admin: %v", err)
+	}
+}
+
+func TestAuthorizeFileWithPublicFiles(t *testing.T) {
+	s := newTestUploadService(t, map[string]string{"UPLOAD_PUBLIC_FILES": "true"})
+
+	info, err := s.UploadFile(uploadTestContext(t, 1, "owned.png", testPNG(t, 1)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if _, err := s.AuthorizeFile(info.Path, 0, false); err != nil {
+		t.Errorf("anonymous with UPLOAD_PUBLIC_FILES: %v", err)
+	}
+}
+
+func TestAvatarsAreReadableByOtherUsers(t *testing.T) {
+	s := newTestUploadService(t, nil)
+
+	avatar, err := s.UploadAvatar(uploadTestContext(t, 1, "me.png", testPNG(t, 1)), "file")
+	if err != nil {
+		t.Fatalf("UploadAvatar: %v", err)
+	}
+
+	urls := []string{avatar.URL}
+	for _, url := range avatar.Variants {
+		urls = append(urls, url)
+	}
+	for _, url := range urls {
+		path := filepath.Join(s.config.Upload.Path, filepath.FromSlash(strings.TrimPrefix(url, "/uploads/")))
+		stored, err := s.AuthorizeFile(path, 2, false)
+		if err != nil {
+			t.Errorf("%s: %v", url, err)
+			continue
+		}
+		if stored.UserID != 1 || !stored.Public {
+			t.Errorf("%s: record = %+v, want a public file owned by user 1", url, stored)
+		}
+	}
+}
+
+func TestDedupIsPerUploader(t *testing.T) {
+	s := newTestUploadService(t, map[string]string{"UPLOAD_DEDUP": "true"})
+	content := testPNG(t, 1)
+
+	first, err := s.UploadFile(uploadTestContext(t, 1, "a.png", content), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	again, err := s.UploadFile(uploadTestContext(t, 1, "b.png", content), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	other, err := s.UploadFile(uploadTestContext(t, 2, "c.png", content), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	if again.Path != first.Path {
+		t.Errorf("same user's identical upload stored at %s, want %s", again.Path, first.Path)
+	}
+	if other.Path == first.Path {
+		t.Error("another user's identical upload shares the first user's file")
+	}
+	if _, err := s.AuthorizeFile(other.Path, 2, false); err != nil {
+		t.Errorf("second uploader denied their own file: %v", err)
+	}
+
+	var stored models.StoredFile
+	if err := s.db.Write.Where("path = ?", first.Path).First(&stored).Error; err != nil {
+		t.Fatalf("failed to load stored file: %v", err)
+	}
+	if stored.RefCount != 2 {
+		t.Errorf("ref count = %d, want 2", stored.RefCount)
+	}
+}
+
+func TestDeleteURLKeepsSharedBlobs(t *testing.T) {
+	s := newTestUploadService(t, map[string]string{"UPLOAD_DEDUP": "true"})
+	content := testPNG(t, 1)
+
+	first, err := s.UploadFile(uploadTestContext(t, 1, "a.png", content), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	second, err := s.UploadFile(uploadTestContext(t, 1, "b.png", content), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if second.URL != first.URL {
+		t.Fatalf("identical upload served at %s, want %s", second.URL, first.URL)
+	}
+
+	refCount := func() int {
+		var stored models.StoredFile
+		if err := s.db.Write.Where("path = ?", first.Path).First(&stored).Error; err != nil {
+			return 0
+		}
+		return stored.RefCount
+	}
+
+	steps := []struct {
+		name      string
+		wantRefs  int
+		wantExist bool
+	}{
+		{"delete one of two", 1, true},
+		{"delete the last", 0, false},
+	}
+	for _, step := range steps {
+		if err := s.DeleteURL(first.URL); err != nil {
+			t.Fatalf("%s: DeleteURL: %v", step.name, err)
+		}
+		if got := refCount(); got != step.wantRefs {
+			t.Errorf("%s: ref count = %d, want %d", step.name, got, step.wantRefs)
+		}
+		if _, err := os.Stat(first.Path); (err == nil) != step.wantExist {
+			t.Errorf("%s: blob exists = %v, want %v", step.name, err == nil, step.wantExist)
+		}
+	}
+}
+
+func TestStoredFileRefCounting(t *testing.T) {
+	s := newTestUploadService(t, map[string]string{"UPLOAD_DEDUP": "true"})
+	owner := fileOwner{userID: 1}
+
+	uploaded, err := s.UploadFile(uploadTestContext(t, 1, "a.png", testPNG(t, 1)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	if stored, err := s.acquireStoredFile("unknown", owner); err != nil || stored != nil {
+		t.Errorf("acquireStoredFile of an unknown hash = %v, %v, want nothing", stored, err)
+	}
+	if stored, err := s.acquireStoredFile(uploaded.Hash, fileOwner{userID: 2}); err != nil || stored != nil {
+		t.Errorf("acquireStoredFile for another owner = %v, %v, want nothing", stored, err)
+	}
+	stored, err := s.acquireStoredFile(uploaded.Hash, owner)
+	if err != nil || stored == nil || stored.Path != uploaded.Path {
+		t.Fatalf("acquireStoredFile = %v, %v, want %s", stored, err, uploaded.Path)
+	}
+
+	releases := []struct {
+		path                    string
+		wantRemove, wantTracked bool
+	}{
+		{uploaded.Path, false, true},
+		{uploaded.Path, true, true},
+		{uploaded.Path, false, false},
+	}
+	for i, tt := range releases {
+		remove, tracked, err := s.releaseStoredFile(tt.path)
+		if err != nil || remove != tt.wantRemove || tracked != tt.wantTracked {
+			t.Errorf("release %d = %v, %v, %v, want %v, %v", i+1, remove, tracked, err, tt.wantRemove, tt.wantTracked)
+		}
+	}
+
+	// A blob removed out of band is not handed out again
+	again, err := s.UploadFile(uploadTestContext(t, 1, "a.png", testPNG(t, 1)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	os.Remove(again.Path)
+	if stored, err := s.acquireStoredFile(again.Hash, owner); err != nil || stored != nil {
+		t.Errorf("acquireStoredFile of a missing blob = %v, %v, want nothing", stored, err)
+	}
+}
+
+func TestDeleteFileStaysInUploadDir(t *testing.T) {
+	s := newTestUploadService(t, nil)
+	uploadDir := s.config.Upload.Path
+
+	sibling := uploadDir + "-other"
+	if err := os.Mkdir(sibling, 0o755); err != nil {
+		t.Fatalf("failed to create sibling directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(sibling) })
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"sibling directory", filepath.Join(sibling, "secret.txt")},
+		{"traversal", filepath.Join(uploadDir, "..", filepath.Base(sibling), "secret.txt")},
+		{"upload directory itself", uploadDir},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(filepath.Join(sibling, "secret.txt"), []byte("secret"), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := s.DeleteFile(tt.path); err == nil || !strings.Contains(err.Error(), "outside upload directory") {
+			t.Errorf("%s: DeleteFile = %v, want it refused", tt.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(sibling, "secret.txt")); err != nil {
+			t.Errorf("%s: file outside the upload directory deleted", tt.name)
+		}
+	}
+}
+
+func TestServeFileUsesOriginalName(t *testing.T) {
+	s := newTestUploadService(t, nil)
+
+	info, err := s.UploadFile(uploadTestContext(t, 1, "../reports/Q3 \"final\".png", testPNG(t, 1)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	stored, err := s.AuthorizeFile(info.Path, 1, false)
+	if err != nil {
+		t.Fatalf("AuthorizeFile: %v", err)
+	}
+	if stored.OriginalName != "Q3 final.png" {
+		t.Errorf("original name = %q, want the sanitized client name", stored.OriginalName)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, info.URL, nil)
+	if err := s.ServeFile(c, info.Path, stored.OriginalName); err != nil {
+		t.Fatalf("ServeFile: %v", err)
+	}
+	if got, want := recorder.Header().Get("Content-Disposition"), `attachment; filename="Q3 final.png"`; got != want {
+		t.Errorf("Content-Disposition = %q, want %q", got, want)
+	}
+}
+
+func TestServeFileRangesAndConditionalRequests(t *testing.T) {
+	s := newTestUploadService(t, nil)
+	content := testPNG(t, 2)
+
+	info, err := s.UploadFile(uploadTestContext(t, 1, "image.png", content), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	serve := func(headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, info.URL, nil)
+		for key, value := range headers {
+			c.Request.Header.Set(key, value)
+		}
+		if err := s.ServeFile(c, info.Path, ""); err != nil {
+			t.Fatalf("ServeFile: %v", err)
+		}
+		// Gin writes a status without a body once the handler returns
+		c.Writer.WriteHeaderNow()
+		return recorder
+	}
+
+	full := serve(nil)
+	if full.Code != http.StatusOK || !bytes.Equal(full.Body.Bytes(), content) {
+		t.Fatalf("full response: status %d, %d bytes, want 200 and the file", full.Code, full.Body.Len())
+	}
+	etag := full.Header().Get("ETag")
+	if etag != `"`+info.Hash+`"` {
+		t.Errorf("ETag = %q, want the quoted stored hash", etag)
+	}
+	if got := full.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want the stored MIME type", got)
+	}
+	lastModified := full.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Error("Last-Modified is missing")
+	}
+
+	partial := serve(map[string]string{"Range": "bytes=0-9"})
+	if partial.Code != http.StatusPartialContent || !bytes.Equal(partial.Body.Bytes(), content[:10]) {
+		t.Errorf("range response: status %d, body %q, want 206 and the first 10 bytes", partial.Code, partial.Body.Bytes())
+	}
+	if got := partial.Header().Get("Content-Range"); !strings.HasPrefix(got, "bytes 0-9/") {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	if got := serve(map[string]string{"If-None-Match": etag}); got.Code != http.StatusNotModified || got.Body.Len() != 0 {
+		t.Errorf("If-None-Match: status %d with %d bytes, want an empty 304", got.Code, got.Body.Len())
+	}
+	if got := serve(map[string]string{"If-Modified-Since": lastModified}); got.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status %d, want 304", got.Code)
+	}
+	if got := serve(map[string]string{"If-None-Match": `"stale"`}); got.Code != http.StatusOK {
+		t.Errorf("stale ETag: status %d, want 200", got.Code)
+	}
+}
+
+// heldUpload is an upload whose request body stalls before its last bytes
+// until finish is called
+type heldUpload struct {
+	finish func()
+	done   chan error
+}
+
+// startHeldUpload starts uploading content as userID and returns once the
+// service is reading the body, and so holds an upload slot
+func startHeldUpload(t *testing.T, s *UploadService, userID uint, content []byte) *heldUpload {
+	t.Helper()
+
+	c := uploadTestContext(t, userID, "held.png", content)
+	body, _ := io.ReadAll(c.Request.Body)
+	reader, writer := io.Pipe()
+	c.Request.Body = reader
+
+	release := make(chan struct{})
+	upload := &heldUpload{finish: sync.OnceFunc(func() { close(release) }), done: make(chan error, 1)}
+	t.Cleanup(upload.finish)
+
+	go func() {
+		_, err := s.UploadFile(c, "file", UploadPolicyDefault)
+		upload.done <- err
+	}()
+
+	// The write returns once the service reads it; the slot is taken first
+	split := len(body) - 8
+	if _, err := writer.Write(body[:split]); err != nil {
+		t.Fatalf("failed to start the upload: %v", err)
+	}
+	go func() {
+		<-release
+		writer.Write(body[split:])
+		writer.Close()
+	}()
+	return upload
+}
+
+func TestUploadConcurrencyLimitPerUser(t *testing.T) {
+	const limit = 2
+
+	// With Redis stopped the slots fall back to the in-process count
+	for _, backend := range []string{"in process", "redis", "redis stopped"} {
+		t.Run(backend, func(t *testing.T) {
+			s := newTestUploadService(t, map[string]string{"UPLOAD_MAX_CONCURRENT_PER_USER": strconv.Itoa(limit)})
+			if backend != "in process" {
+				redis, server := newTestRedis(t)
+				s.SetRedis(redis)
+				if backend == "redis stopped" {
+					server.Close()
+				}
+			}
+
+			var held []*heldUpload
+			for i := 0; i < limit; i++ {
+				held = append(held, startHeldUpload(t, s, 1, testPNG(t, uint8(i))))
+			}
+
+			if _, err := s.UploadFile(uploadTestContext(t, 1, "extra.png", testPNG(t, 10)), "file", UploadPolicyDefault); !errors.Is(err, ErrTooManyUploads) {
+				t.Errorf("upload %d of user 1: got %v, want ErrTooManyUploads", limit+1, err)
+			}
+			if _, err := s.UploadFile(uploadTestContext(t, 2, "other.png", testPNG(t, 11)), "file", UploadPolicyDefault); err != nil {
+				t.Errorf("upload of another user: %v", err)
+			}
+
+			for i, upload := range held {
+				upload.finish()
+				if err := <-upload.done; err != nil {
+					t.Errorf("held upload %d: %v", i, err)
+				}
+			}
+
+			// Finished and failed uploads give their slot back
+			for i := 0; i <= limit; i++ {
+				if _, err := s.UploadFile(uploadTestContext(t, 1, "notes.txt", []byte("not an image")), "file", UploadPolicyDefault); errors.Is(err, ErrTooManyUploads) || err == nil {
+					t.Errorf("rejected upload %d: got %v, want a type error", i, err)
+				}
+			}
+			if _, err := s.UploadFile(uploadTestContext(t, 1, "after.png", testPNG(t, 12)), "file", UploadPolicyDefault); err != nil {
+				t.Errorf("upload after the others finished: %v", err)
+			}
+		})
+	}
+}
+
+// memoryFile is an in-memory multipart.File
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+func TestDetectMimeTypeOfShortFiles(t *testing.T) {
+	s := newTestUploadService(t, nil)
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+		wantErr error
+	}{
+		{"zero bytes", nil, "", ErrEmptyFile},
+		{"one byte", []byte("a"), "text/plain", nil},
+		{"short PNG", testPNG(t, 1), "image/png", nil},
+	}
+	for _, tt := range tests {
+		mtype, err := s.detectMimeType(memoryFile{bytes.NewReader(tt.content)})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !mtype.Is(tt.want) {
+			t.Errorf("%s: detected %s, want %s", tt.name, mtype, tt.want)
+		}
+	}
+}
+
+// multiUploadTestContext returns a Gin context for a multipart request from
+// userID with each of files, by name, in the form field "files"
+func multiUploadTestContext(t *testing.T, userID uint, files map[string][]byte) *gin.Context {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := form.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatalf("failed to create form file: %v", err)
+		}
+		part.Write(content)
+	}
+	form.Close()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/upload/multiple", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	c.Set(utils.ContextKeyUserID, userID)
+	return c
+}
+
+func TestUploadMultipleFilesTotalSizeLimit(t *testing.T) {
+	files := map[string][]byte{"a.png": testPNG(t, 1), "b.png": testPNG(t, 2), "c.png": testPNG(t, 3)}
+	var total int
+	for _, content := range files {
+		total += len(content)
+	}
+
+	tests := []struct {
+		name     string
+		maxTotal int
+		wantErr  error
+	}{
+		{"under the limit", total + 1, nil},
+		{"at the limit", total, nil},
+		{"over the limit", total - 1, ErrUploadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := strconv.Itoa(tt.maxTotal)
+			s := newTestUploadService(t, map[string]string{"UPLOAD_MAX_SIZE": limit, "UPLOAD_MAX_TOTAL_SIZE": limit})
+
+			uploaded, err := s.UploadMultipleFiles(multiUploadTestContext(t, 1, files), "files", UploadPolicyDefault)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UploadMultipleFiles: %v, want %v", err, tt.wantErr)
+			}
+
+			var stored int64
+			s.db.Write.Model(&models.StoredFile{}).Count(&stored)
+			if tt.wantErr != nil {
+				if len(uploaded) != 0 || stored != 0 {
+					t.Errorf("rejected request stored %d files, %d records", len(uploaded), stored)
+				}
+				return
+			}
+			if len(uploaded) != len(files) || stored != int64(len(files)) {
+				t.Errorf("stored %d files, %d records, want %d", len(uploaded), stored, len(files))
+			}
+		})
+	}
+}
+
+// pngHeader returns the signature and IHDR chunk of a PNG image declaring
+// width x height pixels, with no image data
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12] = 8 // bit depth
+	ihdr[13] = 6 // RGBA
+
+	header := []byte("\x89PNG\r\n\x1a\n")
+	header = binary.BigEndian.AppendUint32(header, 13)
+	header = append(header, ihdr...)
+	return binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestCheckUploadedImageRejectsPixelBombs(t *testing.T) {
+	s := newTestUploadService(t, map[string]string{
+		"UPLOAD_IMAGE_MAX_WIDTH":  "1000",
+		"UPLOAD_IMAGE_MAX_HEIGHT": "1000",
+		"UPLOAD_IMAGE_MAX_PIXELS": "500000",
+	})
+
+	tests := []struct {
+		name     string
+		content  []byte
+		mimeType string
+		wantErr  error
+	}{
+		{"small image", pngHeader(100, 100), "image/png", nil},
+		{"at the limits", pngHeader(1000, 500), "image/png", nil},
+		{"too wide", pngHeader(1001, 1), "image/png", ErrImageTooLarge},
+		{"too tall", pngHeader(1, 1001), "image/png", ErrImageTooLarge},
+		{"too many pixels", pngHeader(1000, 501), "image/png", ErrImageTooLarge},
+		{"decompression bomb", pngHeader(60000, 60000), "image/png", ErrImageTooLarge},
+		{"not an image", pngHeader(60000, 60000), "application/pdf", nil},
+	}
+	for _, tt := range tests {
+		file := memoryFile{bytes.NewReader(tt.content)}
+		if err := s.checkUploadedImage(file, tt.mimeType); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if offset, _ := file.Seek(0, io.SeekCurrent); tt.wantErr == nil && offset != 0 {
+			t.Errorf("%s: file left at offset %d, want it rewound", tt.name, offset)
+		}
+	}
+}
+
+func TestUploadFileRejectsPixelBomb(t *testing.T) {
+	s := newTestUploadService(t, nil)
+
+	_, err := s.UploadFile(uploadTestContext(t, 1, "bomb.png", pngHeader(100000, 100000)), "file", UploadPolicyDefault)
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("UploadFile: %v, want %v", err, ErrImageTooLarge)
+	}
+
+	var stored int64
+	s.db.Write.Model(&models.StoredFile{}).Count(&stored)
+	if stored != 0 {
+		t.Errorf("rejected image recorded %d times", stored)
+	}
+}
+
+func TestCheckExtension(t *testing.T) {
+	png := testPNG(t, 1)
+	pdf := []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+	php := []byte("<?php echo shell_exec($_GET['cmd']); ?>\n")
+	csv := []byte("name,email\nAda,ada@example.com\n")
+
+	tests := []struct {
+		name    string
+		content []byte
+		claimed string
+		wantErr bool
+	}{
+		{"matching extension", png, ".png", false},
+		{"upper case extension", png, ".PNG", false},
+		{"no extension", png, "", false},
+		{"unknown extension", png, ".upload", false},
+		{"image named as another image type", png, ".jpg", true},
+		{"image named as a script", png, ".php", true},
+		{"image named as a page", png, ".html", true},
+		{"document named as an image", pdf, ".png", true},
+		{"document", pdf, ".pdf", false},
+		{"script named as an image", php, ".jpg", true},
+		{"text with a text extension", csv, ".csv", false},
+		{"text named as a script", csv, ".sh", true},
+	}
+	for _, tt := range tests {
+		err := checkExtension(tt.claimed, mimetype.Detect(tt.content))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkExtension(%q) = %v, want error: %v", tt.name, tt.claimed, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrExtensionMismatch) {
+			t.Errorf("%s: error %v is not ErrExtensionMismatch", tt.name, err)
+		}
+	}
+}
+
+// storedFiles counts the files under the upload directory
+func storedFiles(t *testing.T, s *UploadService) int {
+	t.Helper()
+
+	count := 0
+	filepath.WalkDir(s.config.Upload.Path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+func TestUploadFileStoresDetectedExtension(t *testing.T) {
+	s := newTestUploadService(t, nil)
+
+	info, err := s.UploadFile(uploadTestContext(t, 1, "photo", testPNG(t, 2)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if info.Extension != ".png" || filepath.Ext(info.Path) != ".png" || info.OriginalName != "photo" {
+		t.Errorf("stored %s with extension %q for original %q, want .png", info.Path, info.Extension, info.OriginalName)
+	}
+
+	_, err = s.UploadFile(uploadTestContext(t, 1, "evil.php", testPNG(t, 3)), "file", UploadPolicyDefault)
+	if !errors.Is(err, ErrExtensionMismatch) {
+		t.Errorf("image named evil.php: %v, want %v", err, ErrExtensionMismatch)
+	}
+	if got := storedFiles(t, s); got != 1 {
+		t.Errorf("%d files stored, want only the accepted one", got)
+	}
+}
+
+// fakeScanner returns a fixed verdict for every file
+type fakeScanner struct {
+	clean  bool
+	reason string
+	err    error
+}
+
+func (f fakeScanner) Scan(r io.Reader) (bool, string, error) {
+	io.Copy(io.Discard, r)
+	return f.clean, f.reason, f.err
+}
+
+func TestUploadFileRejectedByScanner(t *testing.T) {
+	tests := []struct {
+		name       string
+		scanner    FileScanner
+		wantReason string
+		wantErr    error
+	}{
+		{"infected", fakeScanner{reason: "Eicar-Test-Signature"}, "Eicar-Test-Signature", nil},
+		{"scanner down", fakeScanner{err: ErrScannerUnavailable}, "", ErrScannerUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestUploadService(t, nil)
+			s.SetScanner(tt.scanner)
+
+			_, err := s.UploadFile(uploadTestContext(t, 1, "photo.png", testPNG(t, 4)), "file", UploadPolicyDefault)
+			var rejected *FileRejectedError
+			if errors.As(err, &rejected) != (tt.wantReason != "") {
+				t.Fatalf("UploadFile: %v, want a rejection: %v", err, tt.wantReason != "")
+			}
+			if rejected != nil && rejected.Reason != tt.wantReason {
+				t.Errorf("rejection reason = %q, want %q", rejected.Reason, tt.wantReason)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("UploadFile: %v, want %v", err, tt.wantErr)
+			}
+			if got := storedFiles(t, s); got != 0 {
+				t.Errorf("%d files stored after the scan failed", got)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	long := strings.Repeat("é", 150) + ".pdf"
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"unix traversal", "../../etc/passwd", "passwd"},
+		{"windows traversal", `..\..\windows\system32\cmd.exe`, "cmd.exe"},
+		{"only dots", "..", "file"},
+		{"hidden file", ".htaccess", "htaccess"},
+		{"control characters", "re\x00po\nrt\x7f.pdf", "report.pdf"},
+		{"quotes and separators", `a"b<c>d|e:f*g?.txt`, "abcdefg.txt"},
+		{"reserved device name", "CON", "file"},
+		{"reserved name with extension", "nul.txt", "file.txt"},
+		{"reserved name in another case", "Com1.png", "file.png"},
+		{"empty", "", "file"},
+		{"spaces only", "   ", "file"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeFilename(tt.in); got != tt.want {
+			t.Errorf("%s: SanitizeFilename(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+
+	// Long names are cut to 200 bytes on a rune boundary, keeping the
+	// extension
+	got := SanitizeFilename(long)
+	if len(got) > 200 || !utf8.ValidString(got) || !strings.HasSuffix(got, ".pdf") {
+		t.Errorf("long name sanitized to %d bytes %q", len(got), got)
+	}
+}
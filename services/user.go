@@ -0,0 +1,757 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/libraries"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/repository"
+	"go-api-boilerplate/utils"
+)
+
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrInvalidSortField  = repository.ErrInvalidSortField
+	ErrInvalidSortOrder  = repository.ErrInvalidSortOrder
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrSelfDemotion      = errors.New("you cannot remove your own admin role")
+	ErrSelfDeactivation  = errors.New("you cannot deactivate your own account")
+	ErrSelfDeletion      = errors.New("you cannot delete your own account")
+	ErrTooManyIDs        = errors.New("too many IDs requested")
+	// ErrAccountDeleted is returned when the email belongs to a
+	// soft-deleted user, whose row keeps the email until it is restored or
+	// permanently deleted. It wraps ErrUserAlreadyExists.
+	ErrAccountDeleted = fmt.Errorf("%w: the account was deleted", ErrUserAlreadyExists)
+)
+
+// MaxBatchIDs is the largest number of IDs accepted by FindByIDs
+const MaxBatchIDs = 100
+
+// UserFilter holds filtering and sorting options for user listings
+type UserFilter = repository.UserFilter
+
+// ClampPerPage applies PAGINATION_DEFAULT_PER_PAGE to a page size that is
+// not positive and caps it at PAGINATION_MAX_PER_PAGE. Callers that reject
+// oversized pages check them with utils.NormalizePerPage first.
+func ClampPerPage(perPage int) int {
+	cfg := config.Get().Pagination
+	if perPage < 1 {
+		return cfg.DefaultPerPage
+	}
+	if perPage > cfg.MaxPerPage {
+		return cfg.MaxPerPage
+	}
+	return perPage
+}
+
+// UserFileStore deletes stored files that user records point to, such as
+// avatars, and everything a user uploaded; UploadService implements it
+type UserFileStore interface {
+	DeleteURL(fileURL string) error
+	DeleteUserFiles(userID uint) error
+}
+
+// UserService handles user management. Every read and write goes through
+// the user repository, so the service runs on each supported database.
+type UserService struct {
+	db    *database.DB
+	users repository.UserRepository
+	redis *RedisService
+	// files and sessions are cleaned up when a user is deleted; either may
+	// be nil
+	files    UserFileStore
+	sessions SessionStore
+	// events announces user changes and cache invalidations; nil until
+	// SetEventBus
+	events *EventBus
+}
+
+// NewUserService creates a new user service
+func NewUserService(db *database.DB, redis *RedisService) *UserService {
+	return &UserService{
+		db:    db,
+		users: repository.NewUserRepository(db),
+		redis: redis,
+	}
+}
+
+// SetEventBus publishes user changes and cache invalidations on bus and
+// drops the invalidated cache entries announced on it
+func (s *UserService) SetEventBus(bus *EventBus) {
+	s.events = bus
+	bus.Subscribe(TopicCacheInvalidated, func(payload json.RawMessage) {
+		var invalidation CacheInvalidation
+		if err := json.Unmarshal(payload, &invalidation); err != nil {
+			logger.Warnf("Discarding malformed cache invalidation: %v", err)
+			return
+		}
+		s.deleteCache(invalidation)
+	})
+}
+
+// SubscribeChanges calls handler for every UserEvent until the returned
+// function is called. Without an event bus there are no events and the
+// function does nothing.
+func (s *UserService) SubscribeChanges(handler func(*UserEvent)) (unsubscribe func()) {
+	if s.events == nil {
+		return func() {}
+	}
+	return s.events.Subscribe(TopicUserChanged, func(payload json.RawMessage) {
+		var event UserEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			logger.Warnf("Discarding malformed user event: %v", err)
+			return
+		}
+		handler(&event)
+	})
+}
+
+// SetFileStore sets where a permanently deleted user's files are removed from
+func (s *UserService) SetFileStore(files UserFileStore) {
+	s.files = files
+}
+
+// SetSessionStore sets the cookie session store whose sessions are ended
+// when a user is deleted
+func (s *UserService) SetSessionStore(sessions SessionStore) {
+	s.sessions = sessions
+}
+
+// FindByID finds a user by ID
+func (s *UserService) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	return findUser(ctx, s.users, id)
+}
+
+// GetUser finds a user by ID for read endpoints. When the database fails
+// it may answer from a stale cached copy, see ReadThrough.
+func (s *UserService) GetUser(ctx context.Context, id uint) (*models.User, CacheResult, error) {
+	return ReadThrough(s.redis.WithContext(ctx), fmt.Sprintf("user:%d", id), func() (*models.User, error) {
+		return s.FindByID(ctx, id)
+	}, ErrUserNotFound)
+}
+
+// FindByIDs loads several users in one query. Users are returned in the
+// order of ids, with repeated IDs returned once, and IDs that match no user
+// are returned as missing. More than MaxBatchIDs IDs returns ErrTooManyIDs.
+func (s *UserService) FindByIDs(ctx context.Context, ids []uint) ([]models.User, []uint, error) {
+	if len(ids) > MaxBatchIDs {
+		return nil, nil, fmt.Errorf("%w: limit is %d", ErrTooManyIDs, MaxBatchIDs)
+	}
+	if len(ids) == 0 {
+		return []models.User{}, []uint{}, nil
+	}
+
+	values := make([]any, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	found, err := s.users.WhereIn("id", values).Find(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find users: %w", err)
+	}
+
+	byID := make(map[uint]models.User, len(found))
+	for _, user := range found {
+		byID[user.ID] = user
+	}
+
+	users := make([]models.User, 0, len(found))
+	missing := []uint{}
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if user, ok := byID[id]; ok {
+			users = append(users, user)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return users, missing, nil
+}
+
+// FindByEmail finds a user by email
+func (s *UserService) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.users.FindByEmail(ctx, utils.NormalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, libraries.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	return user, nil
+}
+
+// FindAll returns every user
+func (s *UserService) FindAll(ctx context.Context) ([]models.User, error) {
+	users, err := s.users.FindAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
+}
+
+// FindPaginated returns a page of users matching the filter. perPage is
+// clamped by ClampPerPage and unknown sort fields return ErrInvalidSortField.
+func (s *UserService) FindPaginated(ctx context.Context, page, perPage int, filter *UserFilter) (*utils.PaginationMeta, []models.User, error) {
+	if filter == nil {
+		filter = &UserFilter{}
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	perPage = ClampPerPage(perPage)
+
+	users, total, err := s.users.List(ctx, filter, utils.GetOffset(page, perPage), perPage)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	meta := utils.CalculatePaginationMeta(page, perPage, total)
+	return &meta, users, nil
+}
+
+// StreamUsers calls fn for every user matching the filter, in the filter's
+// sort order, reading them through a database cursor so memory use does not
+// grow with the number of users. Iteration stops at the first error from fn.
+func (s *UserService) StreamUsers(ctx context.Context, filter *UserFilter, fn func(*models.User) error) error {
+	if filter == nil {
+		filter = &UserFilter{}
+	}
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	return s.users.Each(ctx, filter, fn)
+}
+
+// Create creates a new user
+func (s *UserService) Create(ctx context.Context, input *models.CreateUserInput) (*models.User, error) {
+	role := input.Role
+	if role == "" {
+		role = config.Get().Auth.DefaultRole
+	} else if !models.IsValidRole(role) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRole, role)
+	}
+
+	if err := checkEmailAvailable(ctx, s.users, utils.NormalizeEmail(input.Email)); err != nil {
+		return nil, err
+	}
+
+	// Hash password
+	hashedPassword, err := utils.HashPassword(input.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := &models.User{
+		Email:    utils.NormalizeEmail(input.Email),
+		Password: hashedPassword,
+		Name:     input.Name,
+		Role:     role,
+		IsActive: true,
+	}
+
+	if err := s.users.Create(ctx, user); err != nil {
+		if database.IsUniqueViolation(err) {
+			return nil, ErrUserAlreadyExists
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	s.publishUserEvent(UserEventCreated, user)
+	return user, nil
+}
+
+// Update applies the non-empty fields of input to the user
+func (s *UserService) Update(ctx context.Context, id uint, input *models.UpdateUserInput) (*models.User, error) {
+	if input.Role != "" && !models.IsValidRole(input.Role) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRole, input.Role)
+	}
+
+	// The returned user is the one read here with the updates applied, so
+	// read it from the primary rather than a possibly stale replica
+	ctx = database.ReadFromPrimary(ctx)
+	user, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]any{}
+	if input.Name != "" {
+		updates["name"] = input.Name
+		user.Name = input.Name
+	}
+	replaced := *user
+	if input.Avatar != "" && input.Avatar != user.Avatar {
+		updates["avatar"] = input.Avatar
+		updates["avatar_variants"] = nil
+		user.Avatar = input.Avatar
+		user.AvatarVariants = nil
+	}
+	if input.Role != "" {
+		updates["role"] = input.Role
+		user.Role = input.Role
+	}
+	if input.IsActive != nil {
+		updates["is_active"] = *input.IsActive
+		user.IsActive = *input.IsActive
+	}
+	if input.EmailVerified != nil {
+		updates["email_verified"] = *input.EmailVerified
+		user.EmailVerified = *input.EmailVerified
+	}
+
+	if len(updates) > 0 {
+		if err := s.users.UpdatePartial(ctx, id, updates); err != nil {
+			if errors.Is(err, libraries.ErrRecordNotFound) {
+				return nil, ErrUserNotFound
+			}
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+		s.invalidateUserCache(id)
+		s.publishUserEvent(UserEventUpdated, user)
+		if user.Avatar != replaced.Avatar {
+			s.deleteUserFiles(&replaced)
+		}
+	}
+
+	return user, nil
+}
+
+// Patch applies a partial profile update: fields left nil are unchanged,
+// while a field set to an empty value is stored empty
+func (s *UserService) Patch(ctx context.Context, id uint, input *models.PatchProfileInput) (*models.User, error) {
+	ctx = database.ReadFromPrimary(ctx)
+	user, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	replaced := *user
+	updates := map[string]any{}
+	if input.Name != nil {
+		updates["name"] = *input.Name
+		user.Name = *input.Name
+	}
+	if input.Avatar != nil && *input.Avatar != user.Avatar {
+		updates["avatar"] = *input.Avatar
+		updates["avatar_variants"] = nil
+		user.Avatar = *input.Avatar
+		user.AvatarVariants = nil
+	}
+
+	if len(updates) > 0 {
+		if err := s.users.UpdatePartial(ctx, id, updates); err != nil {
+			if errors.Is(err, libraries.ErrRecordNotFound) {
+				return nil, ErrUserNotFound
+			}
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+		s.invalidateUserCache(id)
+		s.publishUserEvent(UserEventUpdated, user)
+		if user.Avatar != replaced.Avatar {
+			s.deleteUserFiles(&replaced)
+		}
+	}
+
+	return user, nil
+}
+
+// SetAvatar points the user's avatar at a freshly uploaded image and its
+// variants and removes the stored files of the avatar it replaces. With
+// UPLOAD_DEDUP the new image may share the old one's blob; deleting the old
+// URL then only drops the extra reference the upload took.
+func (s *UserService) SetAvatar(ctx context.Context, id uint, avatar *AvatarUpload) (*models.User, error) {
+	// The files of the avatar read here are deleted, so it must be current
+	ctx = database.ReadFromPrimary(ctx)
+	user, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	replaced := *user
+	err = s.users.UpdatePartial(ctx, id, map[string]any{
+		"avatar":          avatar.URL,
+		"avatar_variants": models.AvatarVariants(avatar.Variants),
+	})
+	if err != nil {
+		if errors.Is(err, libraries.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to update avatar: %w", err)
+	}
+	user.Avatar = avatar.URL
+	user.AvatarVariants = avatar.Variants
+
+	s.invalidateUserCache(id)
+	s.publishUserEvent(UserEventUpdated, user)
+	s.deleteUserFiles(&replaced)
+	return user, nil
+}
+
+// UpdateUserRole changes a user's role and records the change in the audit
+// log. An admin cannot change their own role away from admin.
+func (s *UserService) UpdateUserRole(ctx context.Context, actorID, userID uint, role string) (*models.User, error) {
+	if !models.IsValidRole(role) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRole, role)
+	}
+
+	var user *models.User
+	err := s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		var err error
+		if user, err = findUser(ctx, users, userID); err != nil {
+			return err
+		}
+
+		if actorID == userID && user.Role == models.RoleAdmin && role != models.RoleAdmin {
+			return ErrSelfDemotion
+		}
+		if user.Role == role {
+			return nil
+		}
+
+		oldRole := user.Role
+		if err := users.UpdatePartial(ctx, userID, map[string]any{"role": role}); err != nil {
+			return fmt.Errorf("failed to update user role: %w", err)
+		}
+		user.Role = role
+
+		return users.Audit(ctx, &models.AuditLog{
+			ActorID:    actorID,
+			Action:     models.AuditActionUserRoleChanged,
+			TargetType: "user",
+			TargetID:   userID,
+			OldValue:   oldRole,
+			NewValue:   role,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateUserCache(userID)
+	s.publishUserEvent(UserEventUpdated, user)
+	return user, nil
+}
+
+// UpdateUserStatus activates or deactivates a user and records the change
+// in the audit log. Users cannot deactivate their own account.
+func (s *UserService) UpdateUserStatus(ctx context.Context, actorID, userID uint, isActive bool) (*models.User, error) {
+	if actorID == userID && !isActive {
+		return nil, ErrSelfDeactivation
+	}
+
+	var user *models.User
+	err := s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		var err error
+		if user, err = findUser(ctx, users, userID); err != nil {
+			return err
+		}
+
+		if user.IsActive == isActive {
+			return nil
+		}
+
+		if err := users.UpdatePartial(ctx, userID, map[string]any{"is_active": isActive}); err != nil {
+			return fmt.Errorf("failed to update user status: %w", err)
+		}
+		user.IsActive = isActive
+
+		return users.Audit(ctx, &models.AuditLog{
+			ActorID:    actorID,
+			Action:     models.AuditActionUserStatusChanged,
+			TargetType: "user",
+			TargetID:   userID,
+			OldValue:   strconv.FormatBool(!isActive),
+			NewValue:   strconv.FormatBool(isActive),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateUserCache(userID)
+	s.publishUserEvent(UserEventUpdated, user)
+	return user, nil
+}
+
+// Delete soft deletes a user and records it in the audit log. The user row
+// and their files are kept so the user can be restored, but every way back
+// in is revoked: sessions, reset and verification tokens and the refresh
+// token are removed and cached entries dropped. See ForceDelete for
+// permanent deletion.
+func (s *UserService) Delete(ctx context.Context, actorID, id uint) error {
+	if actorID == id {
+		return ErrSelfDeletion
+	}
+
+	err := s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		if err := users.Delete(ctx, id); err != nil {
+			if errors.Is(err, libraries.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		if err := users.RevokeAccess(ctx, id); err != nil {
+			return err
+		}
+		return s.audit(ctx, users, actorID, models.AuditActionUserDeleted, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.endUserSessions(id)
+	s.purgeUserCache(id)
+	s.publishUserEvent(UserEventDeleted, &models.User{ID: id})
+	return nil
+}
+
+// Restore undoes the soft delete of a user and records it in the audit
+// log. The user has to sign in again, as Delete revoked their sessions.
+func (s *UserService) Restore(ctx context.Context, actorID, id uint) (*models.User, error) {
+	var user *models.User
+	err := s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		if err := users.Restore(ctx, id); err != nil {
+			if errors.Is(err, libraries.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("failed to restore user: %w", err)
+		}
+
+		var err error
+		if user, err = users.FindByID(ctx, id); err != nil {
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+		return s.audit(ctx, users, actorID, models.AuditActionUserRestored, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateUserCache(id)
+	s.publishUserEvent(UserEventUpdated, user)
+	return user, nil
+}
+
+// ForceDelete permanently deletes a user, soft-deleted or not, together with
+// their sessions, reset and verification tokens, cached entries, avatar and
+// every file they uploaded. The audit log keeps a record of the deletion, without the user's
+// details. Database rows are removed in one transaction; files and cache
+// entries are removed after it commits and failures there are only logged.
+func (s *UserService) ForceDelete(ctx context.Context, actorID, id uint) error {
+	if actorID == id {
+		return ErrSelfDeletion
+	}
+
+	var user *models.User
+	err := s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		var err error
+		user, err = users.WithTrashed().Where("id", id).Select("id", "avatar", "avatar_variants").First(ctx)
+		if err != nil {
+			if errors.Is(err, libraries.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+
+		if err := users.RevokeAccess(ctx, id); err != nil {
+			return err
+		}
+
+		if err := users.ForceDelete(ctx, id); err != nil {
+			if errors.Is(err, libraries.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+
+		return s.audit(ctx, users, actorID, models.AuditActionUserForceDeleted, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.endUserSessions(id)
+	s.purgeUserCache(id)
+	s.publishUserEvent(UserEventDeleted, &models.User{ID: id})
+	s.deleteUserFiles(user)
+	if s.files != nil {
+		if err := s.files.DeleteUserFiles(id); err != nil {
+			logger.Warnf("Failed to delete uploaded files of user %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// endUserSessions ends the user's cookie sessions
+func (s *UserService) endUserSessions(id uint) {
+	if s.sessions == nil {
+		return
+	}
+	if err := s.sessions.DeleteUser(id); err != nil {
+		logger.Warnf("Failed to end sessions of user %d: %v", id, err)
+	}
+}
+
+// deleteUserFiles removes the stored files user points to, the avatar and
+// each of its variants, after the user is deleted or the avatar replaced
+func (s *UserService) deleteUserFiles(user *models.User) {
+	if s.files == nil {
+		return
+	}
+	urls := make([]string, 0, len(user.AvatarVariants)+1)
+	if user.Avatar != "" {
+		urls = append(urls, user.Avatar)
+	}
+	for _, url := range user.AvatarVariants {
+		urls = append(urls, url)
+	}
+	for _, url := range urls {
+		if err := s.files.DeleteURL(url); err != nil {
+			logger.Warnf("Failed to delete avatar file %s of user %d: %v", url, user.ID, err)
+		}
+	}
+}
+
+// FindTrashed returns a page of soft-deleted users, most recently deleted first
+func (s *UserService) FindTrashed(ctx context.Context, page, perPage int) (*utils.PaginationMeta, []models.User, error) {
+	if page < 1 {
+		page = 1
+	}
+	perPage = ClampPerPage(perPage)
+
+	meta, users, err := s.users.OnlyTrashed().
+		OrderByDesc("deleted_at").
+		Paginate(page, perPage).
+		Execute(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list deleted users: %w", err)
+	}
+
+	pagination := utils.PaginationMeta(*meta)
+	return &pagination, users, nil
+}
+
+// audit records an action on a user without before and after values
+func (s *UserService) audit(ctx context.Context, users repository.UserRepository, actorID uint, action string, userID uint) error {
+	return users.Audit(ctx, &models.AuditLog{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   userID,
+	})
+}
+
+// findUser finds a user by ID through users, which may be bound to a
+// transaction
+func findUser(ctx context.Context, users repository.UserRepository, id uint) (*models.User, error) {
+	user, err := users.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, libraries.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	return user, nil
+}
+
+// invalidateUserCache drops the cached copies of a user that authentication
+// and profile reads use, so a change applies to the next request
+func (s *UserService) invalidateUserCache(id uint) {
+	s.invalidateCache(id, "auth", staleCachePrefix)
+}
+
+// purgeUserCache drops every cached copy of a user so a deleted user is not
+// served from Redis
+func (s *UserService) purgeUserCache(id uint) {
+	s.invalidateCache(id, "auth", staleCachePrefix, "tokens_valid_after")
+}
+
+// invalidateCache drops a user's key under each cache prefix. With an event
+// bus the invalidation is published, so every instance drops its entries;
+// this instance does so before Publish returns.
+func (s *UserService) invalidateCache(id uint, prefixes ...string) {
+	invalidation := CacheInvalidation{
+		Prefixes: prefixes,
+		Keys:     []string{fmt.Sprintf("user:%d", id)},
+	}
+	if s.events == nil {
+		s.deleteCache(invalidation)
+		return
+	}
+	if err := s.events.Publish(TopicCacheInvalidated, invalidation); err != nil {
+		logger.Warnf("Failed to announce cache invalidation for user %d: %v", id, err)
+	}
+}
+
+// deleteCache deletes the keys of an invalidation under each of its prefixes
+func (s *UserService) deleteCache(invalidation CacheInvalidation) {
+	if !s.redis.Available() || len(invalidation.Keys) == 0 {
+		return
+	}
+
+	for _, prefix := range invalidation.Prefixes {
+		if err := s.redis.CacheDelete(prefix, invalidation.Keys...); err != nil {
+			logger.Warnf("Failed to purge %s cache for %v: %v", prefix, invalidation.Keys, err)
+		}
+	}
+}
+
+// publishUserEvent announces a change to a user
+func (s *UserService) publishUserEvent(action string, user *models.User) {
+	if s.events == nil {
+		return
+	}
+	if err := s.events.Publish(TopicUserChanged, UserEvent{Action: action, User: *user}); err != nil {
+		logger.Warnf("Failed to announce %s user %d: %v", action, user.ID, err)
+	}
+}
+
+// checkEmailAvailable returns ErrUserAlreadyExists when a user has the
+// normalized email and ErrAccountDeleted when a soft-deleted user does
+func checkEmailAvailable(ctx context.Context, users repository.UserRepository, email string) error {
+	exists, err := users.Where("email", email).Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if exists {
+		return ErrUserAlreadyExists
+	}
+
+	deleted, err := users.OnlyTrashed().Where("email", email).Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check user existence: %w", err)
+	}
+	if deleted {
+		return ErrAccountDeleted
+	}
+	return nil
+}
+
+// UserExistsByEmail checks whether a user with the email exists
+func (s *UserService) UserExistsByEmail(email string) (bool, error) {
+	exists, err := s.users.Where("email", utils.NormalizeEmail(email)).Exists(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("failed to check user existence: %w", err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go-api-boilerplate/config"
+	"go-api-boilerplate/models"
+	"go-api-boilerplate/repository"
+	"go-api-boilerplate/utils"
+)
+
+var (
+	ErrInvalidImportFile = errors.New("invalid import file")
+	ErrImportTooManyRows = errors.New("import exceeds the maximum number of rows")
+)
+
+// importHashWorkers bounds how many passwords of a batch are hashed at once
+const importHashWorkers = 4
+
+// generatedPasswordLength is the length of passwords created for rows
+// without one
+const generatedPasswordLength = 24
+
+// pendingImport is a validated row waiting for its batch to be inserted
+type pendingImport struct {
+	index     int
+	password  string
+	generated bool
+	user      models.User
+}
+
+// ImportUsers creates users from a CSV with a header naming the email, name
+// and role columns, plus an optional password column. Rows are read one at
+// a time, validated and inserted in batches of IMPORT_BATCH_SIZE inside a
+// single transaction. Invalid rows and emails that already exist are
+// reported per row without aborting the import. Rows without a password get
+// a random one, so those users sign in through the password reset flow.
+//
+// More than IMPORT_MAX_ROWS rows returns ErrImportTooManyRows and a malformed
+// CSV returns ErrInvalidImportFile; both roll back the import. The created
+// users are returned alongside the result.
+func (s *UserService) ImportUsers(ctx context.Context, r io.Reader) (*models.UserImportResult, []models.User, error) {
+	cfg := config.Get().Import
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, importReadError(err, "missing header")
+	}
+	columns, err := importColumns(header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &models.UserImportResult{Rows: []models.UserImportRowResult{}}
+	var created []models.User
+
+	err = s.users.Transaction(ctx, func(users repository.UserRepository) error {
+		seen := make(map[string]bool)
+		batch := make([]pendingImport, 0, cfg.BatchSize)
+
+		for row := 1; ; row++ {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return importReadError(err, fmt.Sprintf("row %d", row))
+			}
+			if row > cfg.MaxRows {
+				return fmt.Errorf("%w: limit is %d", ErrImportTooManyRows, cfg.MaxRows)
+			}
+
+			pending, rowResult := parseImportRow(row, record, columns)
+			if rowResult.Status == "" && seen[pending.user.Email] {
+				rowResult.Status = models.ImportStatusSkippedDuplicate
+			}
+			result.Rows = append(result.Rows, rowResult)
+			if rowResult.Status != "" {
+				continue
+			}
+
+			seen[pending.user.Email] = true
+			pending.index = len(result.Rows) - 1
+			batch = append(batch, pending)
+
+			if len(batch) == cfg.BatchSize {
+				inserted, err := s.insertImportBatch(ctx, users, batch, result)
+				if err != nil {
+					return err
+				}
+				created = append(created, inserted...)
+				batch = batch[:0]
+			}
+		}
+
+		inserted, err := s.insertImportBatch(ctx, users, batch, result)
+		if err != nil {
+			return err
+		}
+		created = append(created, inserted...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, row := range result.Rows {
+		switch row.Status {
+		case models.ImportStatusCreated:
+			result.Created++
+		case models.ImportStatusSkippedDuplicate:
+			result.Skipped++
+		default:
+			result.Failed++
+		}
+	}
+
+	return result, created, nil
+}
+
+// insertImportBatch skips rows whose email is already registered, hashes
+// the remaining passwords and inserts those users
+func (s *UserService) insertImportBatch(ctx context.Context, repo repository.UserRepository, batch []pendingImport, result *models.UserImportResult) ([]models.User, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+
+	emails := make([]any, len(batch))
+	for i, pending := range batch {
+		emails[i] = pending.user.Email
+	}
+
+	// Soft-deleted users still hold their email in the unique index
+	existing, err := repo.WithTrashed().WhereIn("email", emails).Pluck(ctx, "email")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check user existence: %w", err)
+	}
+	registered := make(map[string]bool, len(existing))
+	for _, email := range existing {
+		switch email := email.(type) {
+		case string:
+			registered[utils.NormalizeEmail(email)] = true
+		case []byte:
+			registered[utils.NormalizeEmail(string(email))] = true
+		}
+	}
+
+	fresh := make([]pendingImport, 0, len(batch))
+	for _, pending := range batch {
+		if registered[pending.user.Email] {
+			result.Rows[pending.index].Status = models.ImportStatusSkippedDuplicate
+			continue
+		}
+		fresh = append(fresh, pending)
+	}
+	if len(fresh) == 0 {
+		return nil, nil
+	}
+
+	users, err := hashImportPasswords(fresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.CreateBatch(ctx, users); err != nil {
+		return nil, fmt.Errorf("failed to create users: %w", err)
+	}
+
+	for i, pending := range fresh {
+		row := &result.Rows[pending.index]
+		row.Status = models.ImportStatusCreated
+		row.UserID = users[i].ID
+		row.PasswordGenerated = pending.generated
+	}
+
+	return users, nil
+}
+
+// hashImportPasswords hashes the passwords of a batch concurrently and
+// returns the users ready to insert
+func hashImportPasswords(batch []pendingImport) ([]models.User, error) {
+	users := make([]models.User, len(batch))
+	errs := make([]error, len(batch))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, importHashWorkers)
+	for i := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			users[i] = batch[i].user
+			users[i].Password, errs[i] = utils.HashPassword(batch[i].password)
+		}(i)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// importColumns maps the required and optional column names of an import
+// header to their positions
+func importColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate column %q", ErrInvalidImportFile, name)
+		}
+		columns[name] = i
+	}
+
+	for _, required := range []string{"email", "name", "role"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidImportFile, required)
+		}
+	}
+	return columns, nil
+}
+
+// parseImportRow validates one CSV record. A result with an empty status
+// means the row is valid and pending insertion.
+func parseImportRow(row int, record []string, columns map[string]int) (pendingImport, models.UserImportRowResult) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	email := utils.NormalizeEmail(field("email"))
+	result := models.UserImportRowResult{Row: row, Email: email}
+	fail := func(message string) (pendingImport, models.UserImportRowResult) {
+		result.Status = models.ImportStatusError
+		result.Error = message
+		return pendingImport{}, result
+	}
+
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		return fail("invalid email")
+	}
+
+	name := field("name")
+	if length := utf8.RuneCountInString(name); length < 2 || length > 100 {
+		return fail("name must be between 2 and 100 characters")
+	}
+
+	role := strings.ToLower(field("role"))
+	if role == "" {
+		role = config.Get().Auth.DefaultRole
+	} else if !models.IsValidRole(role) {
+		return fail(fmt.Sprintf("invalid role %q", role))
+	}
+
+	password := field("password")
+	generated := password == ""
+	if generated {
+		password = utils.GenerateRandomString(generatedPasswordLength)
+	} else if len(password) < 8 {
+		return fail("password must be at least 8 characters")
+	}
+
+	return pendingImport{
+		password:  password,
+		generated: generated,
+		user: models.User{
+			Email:    email,
+			Name:     name,
+			Role:     role,
+			IsActive: true,
+		},
+	}, result
+}
+
+// importReadError reports CSV syntax errors as ErrInvalidImportFile and
+// passes other read errors, such as a body size limit, through unchanged
+func importReadError(err error, where string) error {
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s", ErrInvalidImportFile, where)
+	}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidImportFile, where, parseErr.Err)
+	}
+	return err
+}
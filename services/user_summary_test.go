@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+)
+
+// seedSummaryUsers stores users covering each count in the summary
+func seedSummaryUsers(t *testing.T, db *database.DB) {
+	t.Helper()
+
+	now := time.Now()
+	seeds := []struct {
+		email    string
+		role     string
+		active   bool
+		verified bool
+		created  time.Time
+		deleted  bool
+	}{
+		{"admin@example.com", models.RoleAdmin, true, true, now.AddDate(0, 0, -90), false},
+		{"moderator@example.com", models.RoleModerator, true, false, now.AddDate(0, 0, -20), false},
+		{"new@example.com", models.RoleUser, true, true, now.AddDate(0, 0, -1), false},
+		{"recent@example.com", models.RoleUser, false, false, now.AddDate(0, 0, -10), false},
+		{"old@example.com", models.RoleUser, false, true, now.AddDate(0, 0, -45), false},
+		{"deleted@example.com", models.RoleUser, true, true, now.AddDate(0, 0, -2), true},
+	}
+	for _, seed := range seeds {
+		user := createTestUser(t, db, seed.email, seed.role)
+		err := db.Write.Model(user).Updates(map[string]any{
+			"is_active":      seed.active,
+			"email_verified": seed.verified,
+			"created_at":     seed.created,
+		}).Error
+		if err == nil && seed.deleted {
+			err = db.Write.Delete(user).Error
+		}
+		if err != nil {
+			t.Fatalf("failed to seed %s: %v", seed.email, err)
+		}
+	}
+}
+
+func TestUserSummaryCounts(t *testing.T) {
+	loadTestConfig(t, nil)
+	db := newTestDB(t)
+	seedSummaryUsers(t, db)
+
+	summary, err := NewUserService(db, nil).Summary(context.Background())
+	if err != nil {
+		t.Fatalf("Summary: %v", err)
+	}
+
+	// The soft-deleted user is not counted
+	got := *summary
+	got.GeneratedAt = time.Time{}
+	want := UserSummary{
+		Total:         5,
+		ByRole:        map[string]int64{models.RoleAdmin: 1, models.RoleModerator: 1, models.RoleUser: 3},
+		Active:        3,
+		Inactive:      2,
+		Verified:      3,
+		Unverified:    2,
+		NewLast7Days:  1,
+		NewLast30Days: 3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summary = %+v, want %+v", got, want)
+	}
+	if time.Since(summary.GeneratedAt) > time.Minute {
+		t.Errorf("GeneratedAt = %v, want now", summary.GeneratedAt)
+	}
+}
+
+func TestUserSummaryEmpty(t *testing.T) {
+	loadTestConfig(t, nil)
+	summary, err := NewUserService(newTestDB(t), nil).Summary(context.Background())
+	if err != nil {
+		t.Fatalf("Summary: %v", err)
+	}
+	if summary.Total != 0 || summary.ByRole == nil || len(summary.ByRole) != 0 {
+		t.Errorf("Summary with no users = %+v, want zero counts and an empty role map", summary)
+	}
+}
+
+func TestUserSummaryCached(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, server := newTestRedis(t)
+	db := newTestDB(t)
+	service := NewUserService(db, redis)
+	ctx := context.Background()
+	createTestUser(t, db, "first@example.com", models.RoleUser)
+
+	first, err := service.Summary(ctx)
+	if err != nil || first.Total != 1 {
+		t.Fatalf("Summary = %+v, %v, want 1 user", first, err)
+	}
+	if ttl := server.TTL("stats:user_summary"); ttl <= 0 || ttl > userSummaryCacheTTL {
+		t.Errorf("cached summary TTL = %v, want up to %v", ttl, userSummaryCacheTTL)
+	}
+
+	// Until the cache expires the new user is not counted
+	createTestUser(t, db, "second@example.com", models.RoleUser)
+	if cached, err := service.Summary(ctx); err != nil || cached.Total != 1 || !cached.GeneratedAt.Equal(first.GeneratedAt) {
+		t.Errorf("Summary within the TTL = %+v, %v, want the cached one", cached, err)
+	}
+
+	server.FastForward(userSummaryCacheTTL)
+	if fresh, err := service.Summary(ctx); err != nil || fresh.Total != 2 {
+		t.Errorf("Summary after the TTL = %+v, %v, want 2 users", fresh, err)
+	}
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+
+	"go-api-boilerplate/database"
+	"go-api-boilerplate/models"
+)
+
+func TestUpdateUserRoleSelfDemotionGuard(t *testing.T) {
+	_, users := newTestAuthService(t)
+	ctx := context.Background()
+	admin := createTestUser(t, users.db, "admin@example.com", models.RoleAdmin)
+	other := createTestUser(t, users.db, "other@example.com", models.RoleAdmin)
+
+	if _, err := users.UpdateUserRole(ctx, admin.ID, admin.ID, models.RoleUser); !errors.Is(err, ErrSelfDemotion) {
+		t.Errorf("demoting yourself: got %v, want ErrSelfDemotion", err)
+	}
+	if _, err := users.UpdateUserRole(ctx, admin.ID, admin.ID, models.RoleAdmin); err != nil {
+		t.Errorf("keeping your own admin role: %v", err)
+	}
+	if _, err := users.UpdateUserStatus(ctx, admin.ID, admin.ID, false); !errors.Is(err, ErrSelfDeactivation) {
+		t.Errorf("deactivating yourself: got %v, want ErrSelfDeactivation", err)
+	}
+
+	updated, err := users.UpdateUserRole(ctx, admin.ID, other.ID, models.RoleUser)
+	if err != nil {
+		t.Fatalf("demoting another admin: %v", err)
+	}
+	if updated.Role != models.RoleUser {
+		t.Errorf("role = %q, want %q", updated.Role, models.RoleUser)
+	}
+
+	var entry models.AuditLog
+	if err := users.db.Write.Where("action = ?", models.AuditActionUserRoleChanged).First(&entry).Error; err != nil {
+		t.Fatalf("no audit log entry for the role change: %v", err)
+	}
+	if entry.ActorID != admin.ID || entry.TargetID != other.ID || entry.OldValue != models.RoleAdmin || entry.NewValue != models.RoleUser {
+		t.Errorf("audit log entry = %+v", entry)
+	}
+
+	var count int64
+	users.db.Write.Model(&models.AuditLog{}).Count(&count)
+	if count != 1 {
+		t.Errorf("audit log has %d entries, want 1: refused and no-op changes are not recorded", count)
+	}
+}
+
+func TestInvalidateUserRevokesTokens(t *testing.T) {
+	auth, users := newTestAuthService(t)
+	ctx := context.Background()
+	admin := createTestUser(t, users.db, "admin@example.com", models.RoleAdmin)
+	user := createTestUser(t, users.db, "user@example.com", models.RoleUser)
+
+	tokens, err := auth.GenerateTokens(ctx, user)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	if _, err := users.UpdateUserRole(ctx, admin.ID, user.ID, models.RoleModerator); err != nil {
+		t.Fatalf("UpdateUserRole: %v", err)
+	}
+	if err := auth.InvalidateUser(ctx, user.ID); err != nil {
+		t.Fatalf("InvalidateUser: %v", err)
+	}
+
+	if _, err := auth.ValidateAccessToken(ctx, tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token with the old role: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestForceDeleteRemovesRelatedRowsAndFiles(t *testing.T) {
+	_, users := newTestAuthService(t)
+	uploads := NewUploadService(users.db)
+	users.SetFileStore(uploads)
+	ctx := context.Background()
+	admin := createTestUser(t, users.db, "admin@example.com", models.RoleAdmin)
+	user := createTestUser(t, users.db, "doomed@example.com", models.RoleUser)
+	other := createTestUser(t, users.db, "other@example.com", models.RoleUser)
+
+	related := []interface{}{
+		&models.Session{UserID: user.ID, Token: "session", ExpiresAt: time.Now().Add(time.Hour)},
+		&models.PasswordReset{UserID: user.ID, Token: "reset", ExpiresAt: time.Now().Add(time.Hour)},
+		&models.EmailVerification{UserID: user.ID, Token: "verify", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for _, row := range related {
+		if err := users.db.Write.Create(row).Error; err != nil {
+			t.Fatalf("failed to create %T: %v", row, err)
+		}
+	}
+
+	document, err := uploads.UploadFile(uploadTestContext(t, user.ID, "doc.png", testPNG(t, 1)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	avatar, err := uploads.UploadAvatar(uploadTestContext(t, user.ID, "me.png", testPNG(t, 2)), "file")
+	if err != nil {
+		t.Fatalf("UploadAvatar: %v", err)
+	}
+	if _, err := users.SetAvatar(ctx, user.ID, avatar); err != nil {
+		t.Fatalf("SetAvatar: %v", err)
+	}
+	kept, err := uploads.UploadFile(uploadTestContext(t, other.ID, "doc.png", testPNG(t, 1)), "file", UploadPolicyDefault)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	var paths []string
+	if err := users.db.Write.Model(&models.StoredFile{}).Where("user_id = ?", user.ID).Pluck("path", &paths).Error; err != nil {
+		t.Fatalf("failed to list stored files: %v", err)
+	}
+	if want := 2 + len(avatar.Variants); len(paths) != want {
+		t.Fatalf("user has %d stored files, want %d", len(paths), want)
+	}
+
+	if err := users.ForceDelete(ctx, admin.ID, user.ID); err != nil {
+		t.Fatalf("ForceDelete: %v", err)
+	}
+
+	for _, model := range []interface{}{&models.User{}, &models.Session{}, &models.PasswordReset{}, &models.EmailVerification{}, &models.StoredFile{}} {
+		column := "user_id"
+		if _, ok := model.(*models.User); ok {
+			column = "id"
+		}
+		var count int64
+		users.db.Write.Unscoped().Model(model).Where(column+" = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%d %T rows of the deleted user remain", count, model)
+		}
+	}
+	for _, path := range append(paths, document.Path) {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("file %s of the deleted user remains", path)
+		}
+	}
+	if _, err := os.Stat(kept.Path); err != nil {
+		t.Errorf("another user's file was removed: %v", err)
+	}
+}
+
+func TestFindPaginatedValidatesSort(t *testing.T) {
+	_, users := newTestAuthService(t)
+	ctx := context.Background()
+	createTestUser(t, users.db, "b@example.com", models.RoleUser)
+	createTestUser(t, users.db, "a@example.com", models.RoleUser)
+
+	if _, _, err := users.FindPaginated(ctx, 1, 10, &UserFilter{SortBy: "password"}); !errors.Is(err, ErrInvalidSortField) {
+		t.Errorf("sorting by password: got %v, want ErrInvalidSortField", err)
+	}
+	if _, _, err := users.FindPaginated(ctx, 1, 10, &UserFilter{SortOrder: "sideways"}); !errors.Is(err, ErrInvalidSortOrder) {
+		t.Errorf("unknown order: got %v, want ErrInvalidSortOrder", err)
+	}
+
+	_, page, err := users.FindPaginated(ctx, 1, 10, &UserFilter{SortBy: " Email ", SortOrder: "ASC"})
+	if err != nil {
+		t.Fatalf("sorting by email: %v", err)
+	}
+	if len(page) != 2 || page[0].Email != "a@example.com" {
+		t.Errorf("users sorted by email start with %v", page)
+	}
+
+	meta, _, err := users.FindPaginated(ctx, 1, 500, &UserFilter{})
+	if err != nil || meta.PerPage != 100 {
+		t.Errorf("oversized page: %v, per page %v, want capped at 100", err, meta)
+	}
+}
+
+func TestUserUpdatesVisibleOnNextValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		eventBus bool
+	}{
+		{"without an event bus", false},
+		{"with an event bus", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, nil)
+			redis, _ := newTestRedis(t)
+			db := newTestDB(t)
+			auth := NewAuthService(db, redis)
+			users := NewUserService(db, redis)
+			if tt.eventBus {
+				bus := NewEventBus(redis)
+				t.Cleanup(func() { bus.Close() })
+				users.SetEventBus(bus)
+			}
+			ctx := context.Background()
+			admin := createTestUser(t, db, "admin@example.com", models.RoleAdmin)
+			user := createTestUser(t, db, "member@example.com", models.RoleUser)
+
+			tokens, err := auth.GenerateTokens(ctx, user)
+			if err != nil {
+				t.Fatalf("GenerateTokens: %v", err)
+			}
+			// validate reads the user for the token, from the cache once
+			// GenerateTokens has filled it
+			validate := func() *models.User {
+				t.Helper()
+				got, err := auth.ValidateAccessToken(ctx, tokens.AccessToken)
+				if err != nil {
+					t.Fatalf("ValidateAccessToken: %v", err)
+				}
+				return got
+			}
+			validate()
+
+			if _, err := users.UpdateUserRole(ctx, admin.ID, user.ID, models.RoleModerator); err != nil {
+				t.Fatalf("UpdateUserRole: %v", err)
+			}
+			if got := validate(); got.Role != models.RoleModerator {
+				t.Errorf("role after UpdateUserRole = %q, want %q", got.Role, models.RoleModerator)
+			}
+
+			if _, err := users.Update(ctx, user.ID, &models.UpdateUserInput{Name: "Renamed", Role: models.RoleUser}); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if got := validate(); got.Name != "Renamed" || got.Role != models.RoleUser {
+				t.Errorf("after Update: name %q, role %q", got.Name, got.Role)
+			}
+
+			if _, err := users.UpdateUserStatus(ctx, admin.ID, user.ID, false); err != nil {
+				t.Fatalf("UpdateUserStatus: %v", err)
+			}
+			if got := validate(); got.IsActive {
+				t.Error("user still active after UpdateUserStatus")
+			}
+		})
+	}
+}
+
+func TestPatchProfileOmittedAndClearedFields(t *testing.T) {
+	_, users := newTestAuthService(t)
+	ctx := context.Background()
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name       string
+		input      models.PatchProfileInput
+		wantName   string
+		wantAvatar string
+	}{
+		{"nothing given", models.PatchProfileInput{}, "Test User", "https://cdn.example.com/a.png"},
+		{"name only", models.PatchProfileInput{Name: str("Renamed")}, "Renamed", "https://cdn.example.com/a.png"},
+		{"avatar only", models.PatchProfileInput{Avatar: str("https://cdn.example.com/b.png")}, "Test User", "https://cdn.example.com/b.png"},
+		{"avatar cleared", models.PatchProfileInput{Avatar: str("")}, "Test User", ""},
+		{"both", models.PatchProfileInput{Name: str("Both"), Avatar: str("")}, "Both", ""},
+	}
+	for i, tt := range tests {
+		user := createTestUser(t, users.db, fmt.Sprintf("patch%d@example.com", i), models.RoleUser)
+		users.db.Write.Model(user).Update("avatar", "https://cdn.example.com/a.png")
+
+		patched, err := users.Patch(ctx, user.ID, &tt.input)
+		if err != nil {
+			t.Fatalf("%s: Patch: %v", tt.name, err)
+		}
+		stored, err := users.FindByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("%s: FindByID: %v", tt.name, err)
+		}
+		for _, got := range []*models.User{patched, stored} {
+			if got.Name != tt.wantName || got.Avatar != tt.wantAvatar {
+				t.Errorf("%s: name %q, avatar %q, want %q, %q", tt.name, got.Name, got.Avatar, tt.wantName, tt.wantAvatar)
+			}
+		}
+		if stored.Email != user.Email || stored.Role != models.RoleUser || !stored.IsActive {
+			t.Errorf("%s: other fields changed: %+v", tt.name, stored)
+		}
+	}
+
+	if _, err := users.Patch(ctx, 999, &models.PatchProfileInput{Name: str("Nobody")}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Patch of a missing user = %v, want ErrUserNotFound", err)
+	}
+}
+
+// raceNextInsert stores a user with email just before the next insert
+// starts, as a concurrent request would after the service's existence
+// check has passed
+func raceNextInsert(t *testing.T, db *database.DB, email string) {
+	t.Helper()
+
+	name := "test:race_insert:" + email
+	raced := false
+	callbacks := db.Write.Callback().Create()
+	err := callbacks.Before("gorm:begin_transaction").Register(name, func(*gorm.DB) {
+		if !raced {
+			raced = true
+			createTestUser(t, db, email, models.RoleUser)
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to register callback: %v", err)
+	}
+	t.Cleanup(func() { callbacks.Remove(name) })
+}
+
+func TestCreateRacingDuplicateEmail(t *testing.T) {
+	auth, users := newTestAuthService(t)
+	ctx := context.Background()
+
+	raceNextInsert(t, users.db, "register@example.com")
+	_, err := auth.Register(ctx, &models.RegisterInput{Email: "register@example.com", Password: testPassword, Name: "Racer"})
+	if !errors.Is(err, ErrUserAlreadyExists) {
+		t.Errorf("Register racing a duplicate = %v, want ErrUserAlreadyExists", err)
+	}
+
+	raceNextInsert(t, users.db, "create@example.com")
+	_, err = users.Create(ctx, &models.CreateUserInput{Email: "create@example.com", Password: testPassword, Name: "Racer"})
+	if !errors.Is(err, ErrUserAlreadyExists) {
+		t.Errorf("Create racing a duplicate = %v, want ErrUserAlreadyExists", err)
+	}
+}
+
+func TestRoleValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		wantRole string
+		wantErr  bool
+	}{
+		{"default", "", models.RoleModerator, false},
+		{"user", models.RoleUser, models.RoleUser, false},
+		{"moderator", models.RoleModerator, models.RoleModerator, false},
+		{"admin", models.RoleAdmin, models.RoleAdmin, false},
+		{"unknown", "superadmin", "", true},
+		{"wrong case", "Admin", "", true},
+	}
+
+	loadTestConfig(t, map[string]string{"AUTH_DEFAULT_ROLE": "moderator"})
+	db := newTestDB(t)
+	auth, users := NewAuthService(db, nil), NewUserService(db, nil)
+	ctx := context.Background()
+	existing := createTestUser(t, db, "existing@example.com", models.RoleUser)
+	columns := map[string]int{"email": 0, "name": 1, "role": 2}
+
+	for i, tt := range tests {
+		user, err := users.Create(ctx, &models.CreateUserInput{
+			Email:    fmt.Sprintf("create%d@example.com", i),
+			Password: testPassword,
+			Name:     "Created User",
+			Role:     tt.role,
+		})
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidRole) {
+				t.Errorf("Create with %s role = %v, want ErrInvalidRole", tt.name, err)
+			}
+		} else if err != nil || user.Role != tt.wantRole {
+			t.Errorf("Create with %s role = %v, %v, want role %q", tt.name, user, err, tt.wantRole)
+		}
+
+		if tt.role != "" {
+			user, err := users.Update(ctx, existing.ID, &models.UpdateUserInput{Role: tt.role})
+			if tt.wantErr != errors.Is(err, ErrInvalidRole) || (!tt.wantErr && (err != nil || user.Role != tt.wantRole)) {
+				t.Errorf("Update to %s role = %v, %v", tt.name, user, err)
+			}
+		}
+
+		// The CSV import lower-cases roles before checking them
+		pending, result := parseImportRow(i+2, []string{fmt.Sprintf("import%d@example.com", i), "Imported User", tt.role}, columns)
+		if tt.role == "Admin" {
+			if result.Status != "" || pending.user.Role != models.RoleAdmin {
+				t.Errorf("import with %s role: %+v, want admin", tt.name, result)
+			}
+		} else if (result.Status == models.ImportStatusError) != tt.wantErr || (!tt.wantErr && pending.user.Role != tt.wantRole) {
+			t.Errorf("import with %s role: %+v, role %q", tt.name, result, pending.user.Role)
+		}
+	}
+
+	// A rejected update leaves the role as it was
+	users.Update(ctx, existing.ID, &models.UpdateUserInput{Role: models.RoleUser})
+	if _, err := users.Update(ctx, existing.ID, &models.UpdateUserInput{Name: "Renamed", Role: "root"}); !errors.Is(err, ErrInvalidRole) {
+		t.Fatalf("Update to an unknown role = %v, want ErrInvalidRole", err)
+	}
+	stored, err := users.FindByID(ctx, existing.ID)
+	if err != nil || stored.Role != models.RoleUser || stored.Name == "Renamed" {
+		t.Errorf("user after a rejected update = %+v, %v", stored, err)
+	}
+
+	// Self-registered users get the configured default
+	registered, err := auth.Register(ctx, &models.RegisterInput{
+		Email:           "register@example.com",
+		Password:        testPassword,
+		ConfirmPassword: testPassword,
+		Name:            "Registered User",
+	})
+	if err != nil || registered.Role != models.RoleModerator {
+		t.Errorf("Register = %v, %v, want the moderator role", registered, err)
+	}
+}
+
+// withLaggingReplica returns a copy of db whose read connection is an empty
+// replica that has not caught up with any write
+func withLaggingReplica(t *testing.T, db *database.DB) *database.DB {
+	t.Helper()
+
+	replica, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "replica.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open replica: %v", err)
+	}
+	if err := replica.AutoMigrate(&models.User{}); err != nil {
+		t.Fatalf("failed to migrate replica: %v", err)
+	}
+	return &database.DB{Write: db.Write, Read: replica}
+}
+
+func TestReadYourWritesWithLaggingReplica(t *testing.T) {
+	loadTestConfig(t, nil)
+	db := withLaggingReplica(t, newTestDB(t))
+	auth, users := NewAuthService(db, nil), NewUserService(db, nil)
+	ctx := context.Background()
+	user := createTestUser(t, db, "fresh@example.com", models.RoleUser)
+
+	// Plain reads go to the replica, which has not seen the user yet
+	if _, err := users.FindByID(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("FindByID on the replica = %v, want ErrUserNotFound", err)
+	}
+
+	if updated, err := users.Update(ctx, user.ID, &models.UpdateUserInput{Name: "Updated"}); err != nil || updated.Name != "Updated" {
+		t.Errorf("Update = %v, %v, want the user read from the primary", updated, err)
+	}
+	name := "Patched"
+	if patched, err := users.Patch(ctx, user.ID, &models.PatchProfileInput{Name: &name}); err != nil || patched.Name != name {
+		t.Errorf("Patch = %v, %v, want the user read from the primary", patched, err)
+	}
+	if err := auth.ChangePassword(ctx, user.ID, testPassword, "NewPassword456!"); err != nil {
+		t.Errorf("ChangePassword = %v, want the user read from the primary", err)
+	}
+
+	// Registration sees an account deleted moments ago, which only the
+	// primary knows about, instead of failing on the unique index
+	if err := db.Write.Delete(user).Error; err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	_, err := auth.Register(ctx, &models.RegisterInput{
+		Email:           user.Email,
+		Password:        testPassword,
+		ConfirmPassword: testPassword,
+		Name:            "Again",
+	})
+	if !errors.Is(err, ErrAccountDeleted) {
+		t.Errorf("Register with a just-deleted email = %v, want ErrAccountDeleted", err)
+	}
+}
@@ -1,34 +1,98 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-api-boilerplate/config"
 	"go-api-boilerplate/pkg/logger"
+	"go-api-boilerplate/pkg/metrics"
 	"go-api-boilerplate/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+var (
+	ErrTooManyConnections     = errors.New("too many WebSocket connections")
+	ErrTooManyUserConnections = errors.New("too many WebSocket connections for this user")
+	ErrUserNotConnected       = errors.New("user is not connected")
+)
+
+var (
+	wsMessagesReceived = metrics.NewCounterVec("websocket_messages_received_total", "WebSocket messages received from clients, by message type", "type")
+	wsMessagesSent     = metrics.NewCounterVec("websocket_messages_sent_total", "WebSocket messages written to clients, by message type", "type")
+	wsConnectionErrors = metrics.NewCounterVec("websocket_connection_errors_total", "WebSocket connections that were rejected or failed, by reason", "reason")
+	wsConnectionCloses = metrics.NewCounterVec("websocket_connections_closed_total", "WebSocket connections closed, by reason", "reason")
+)
+
+// WebSocket close reasons, as recorded in websocket_connections_closed_total
+const (
+	wsCloseClient        = "client"
+	wsCloseTimeout       = "timeout"
+	wsCloseReadError     = "read_error"
+	wsCloseWriteError    = "write_error"
+	wsCloseMessageTooBig = "message_too_big"
+	wsCloseRateLimited   = "rate_limited"
+	wsCloseSlowClient    = "slow_client"
+	wsCloseShutdown      = "shutdown"
+)
+
+// wsInboundTypes are the message types clients send that the server
+// handles; any other type is counted as custom so clients cannot grow the
+// metric's label set
+var wsInboundTypes = map[string]bool{
+	"ping":       true,
+	"join_room":  true,
+	"leave_room": true,
+	"broadcast":  true,
+}
+
+// RoomAuthorizer decides whether a client may join a room
+type RoomAuthorizer func(client *Client, room string) bool
+
+// AllowAllRooms is the default RoomAuthorizer, letting any client join any room
+func AllowAllRooms(*Client, string) bool {
+	return true
+}
+
 // WebSocketService manages WebSocket connections
 type WebSocketService struct {
-	config    *config.Config
-	upgrader  websocket.Upgrader
-	hub       *Hub
-	broadcast chan *Message
+	config        *config.Config
+	redis         *RedisService
+	upgrader      websocket.Upgrader
+	hub           *Hub
+	broadcast     chan *Message
+	authorizeRoom RoomAuthorizer
 }
 
 // Hub maintains active WebSocket connections
 type Hub struct {
-	clients    map[*Client]bool
+	clients map[*Client]bool
+	// users indexes authenticated clients by user ID so messages for a user
+	// do not scan every connection
+	users      map[uint]map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
+	// count mirrors len(clients) and is only changed while mu is held,
+	// so it can be read without taking the lock
+	count int64
+	// slots counts connections including upgrades in progress and is used
+	// to enforce the connection limits; userSlots holds the per-user counts
+	slots     int
+	userSlots map[uint]int
 }
 
 // Client represents a WebSocket client
@@ -36,13 +100,40 @@ type Client struct {
 	ID      string
 	UserID  uint
 	conn    *websocket.Conn
-	send    chan []byte
+	send    chan outboundMessage
 	hub     *Hub
 	service *WebSocketService
 	rooms   map[string]bool
 	mu      sync.RWMutex
+	// sendMu guards send so it is closed exactly once and never written
+	// after closing
+	sendMu sync.Mutex
+	closed bool
+	// limiter throttles inbound messages; only readPump uses it
+	limiter *messageLimiter
+	// closeFrame is the close message writePump sends once send is closed;
+	// writeDone is closed when writePump returns
+	closeFrame []byte
+	writeDone  chan struct{}
+	// closeReason is why the connection closed, set once under sendMu
+	closeReason string
 }
 
+// outboundMessage is an encoded message queued for writePump; kind is its
+// type, used to label the sent messages metric
+type outboundMessage struct {
+	kind string
+	data []byte
+}
+
+// closeGracePeriod is how long readPump lets writePump flush queued
+// messages and the close frame before closing the connection
+const closeGracePeriod = time.Second
+
+// wsCompressionMinSize is the smallest message compressed when
+// permessage-deflate is negotiated
+const wsCompressionMinSize = 128
+
 // Message represents a WebSocket message
 type Message struct {
 	Type      string          `json:"type"`
@@ -52,29 +143,40 @@ type Message struct {
 	Timestamp time.Time       `json:"timestamp"`
 }
 
-// NewWebSocketService creates a new WebSocket service
-func NewWebSocketService() *WebSocketService {
+// NewWebSocketService creates a new WebSocket service. redis backs the
+// offline message queue and may be nil.
+func NewWebSocketService(redis *RedisService) *WebSocketService {
 	cfg := config.Get()
 
 	hub := &Hub{
 		clients:    make(map[*Client]bool),
+		users:      make(map[uint]map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		userSlots:  make(map[uint]int),
 	}
 
 	service := &WebSocketService{
 		config: cfg,
+		redis:  redis,
 		upgrader: websocket.Upgrader{
-			ReadBufferSize:  cfg.WebSocket.ReadBufferSize,
-			WriteBufferSize: cfg.WebSocket.WriteBufferSize,
-			CheckOrigin: func(r *http.Request) bool {
-				// Configure origin checking based on your security requirements
-				return true
-			},
+			ReadBufferSize:    cfg.WebSocket.ReadBufferSize,
+			WriteBufferSize:   cfg.WebSocket.WriteBufferSize,
+			EnableCompression: cfg.WebSocket.Compression,
 		},
-		hub:       hub,
-		broadcast: make(chan *Message, 256),
+		hub:           hub,
+		broadcast:     make(chan *Message, 256),
+		authorizeRoom: AllowAllRooms,
 	}
+	// A rejected origin makes Upgrade respond with 403 Forbidden
+	service.upgrader.CheckOrigin = service.checkOrigin
+
+	metrics.NewGaugeFunc("websocket_connected_clients", "Number of connected WebSocket clients", func() float64 {
+		return float64(service.GetConnectedClients())
+	})
+	metrics.NewGaugeFunc("websocket_active_rooms", "Number of WebSocket rooms with at least one client", func() float64 {
+		return float64(service.GetActiveRooms())
+	})
 
 	// Start hub
 	go hub.run()
@@ -86,27 +188,57 @@ func NewWebSocketService() *WebSocketService {
 // HandleWebSocket handles WebSocket upgrade requests
 func (s *WebSocketService) HandleWebSocket(c *gin.Context) {
 	// Get user ID from context (if authenticated)
-	var userID uint
-	if id, exists := c.Get("user_id"); exists {
-		userID = id.(uint)
+	userID, _ := utils.UserIDFromContext(c)
+
+	// Claim a connection slot; it is released when the client unregisters
+	if err := s.hub.reserve(userID, s.config.WebSocket.MaxConnections, s.config.WebSocket.MaxConnectionsPerUser); err != nil {
+		if errors.Is(err, ErrTooManyUserConnections) {
+			wsConnectionErrors.Inc("too_many_user_connections")
+		} else {
+			wsConnectionErrors.Inc("too_many_connections")
+		}
+		logger.Warnf("Rejected WebSocket connection for user %d: %v", userID, err)
+		utils.ErrorResponse(c, http.StatusTooManyRequests, err.Error(), "TOO_MANY_CONNECTIONS", nil)
+		return
 	}
 
 	// Upgrade connection
 	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
+		s.hub.release(userID)
+		wsConnectionErrors.Inc("upgrade_failed")
 		logger.WithError(err).Error("Failed to upgrade WebSocket connection")
 		return
 	}
+	if s.config.WebSocket.Compression {
+		// The level is validated with the configuration
+		_ = conn.SetCompressionLevel(s.config.WebSocket.CompressionLevel)
+	}
 
 	// Create client
 	client := &Client{
-		ID:      utils.GenerateUUID(),
-		UserID:  userID,
-		conn:    conn,
-		send:    make(chan []byte, 256),
-		hub:     s.hub,
-		service: s,
-		rooms:   make(map[string]bool),
+		ID:        utils.GenerateUUID(),
+		UserID:    userID,
+		conn:      conn,
+		send:      make(chan outboundMessage, 256),
+		hub:       s.hub,
+		service:   s,
+		rooms:     make(map[string]bool),
+		limiter:   newMessageLimiter(s.config.WebSocket.MessageRate, s.config.WebSocket.MessageBurst),
+		writeDone: make(chan struct{}),
+	}
+
+	// Send welcome message
+	welcome := map[string]interface{}{
+		"message":   "Connected to WebSocket server",
+		"client_id": client.ID,
+	}
+	client.SendJSON("welcome", welcome)
+
+	// Queue messages kept while the user was offline before registering,
+	// so they are written ahead of any live message
+	if userID != 0 {
+		s.deliverQueued(client)
 	}
 
 	// Register client
@@ -116,12 +248,60 @@ func (s *WebSocketService) HandleWebSocket(c *gin.Context) {
 	go client.writePump()
 	go client.readPump()
 
-	// Send welcome message
-	welcome := map[string]interface{}{
-		"message":   "Connected to WebSocket server",
-		"client_id": client.ID,
+	// Deliver messages queued while the client was registering, which
+	// still counted as offline
+	if userID != 0 {
+		s.deliverQueued(client)
 	}
-	client.SendJSON("welcome", welcome)
+}
+
+// checkOrigin allows the upgrade when the Origin header matches
+// WS_ALLOWED_ORIGINS, or CORS_ALLOWED_ORIGINS when that is empty. Requests
+// without an Origin header come from non-browser clients and are allowed.
+func (s *WebSocketService) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	allowed := s.config.WebSocket.AllowedOrigins
+	if len(allowed) == 0 {
+		allowed = s.config.CORS.AllowedOrigins
+	}
+
+	if originAllowed(origin, allowed, s.config.IsProduction()) {
+		return true
+	}
+
+	logger.Warnf("Rejected WebSocket upgrade from origin %s", origin)
+	return false
+}
+
+// originAllowed matches an origin against exact entries and "*.domain"
+// wildcards. A bare "*" only matches outside production.
+func originAllowed(origin string, allowed []string, production bool) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, entry := range allowed {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "*":
+			if !production {
+				return true
+			}
+		case strings.HasPrefix(entry, "*."):
+			if strings.HasSuffix(host, strings.ToLower(entry[1:])) {
+				return true
+			}
+		case strings.EqualFold(entry, origin):
+			return true
+		}
+	}
+	return false
 }
 
 // run manages the hub
@@ -129,29 +309,108 @@ func (h *Hub) run() {
 	for {
 		select {
 		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
+			h.addClient(client)
 			logger.Infof("Client registered: %s", client.ID)
 
 		case client := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				h.mu.Unlock()
+			if h.removeClient(client) {
 				logger.Infof("Client unregistered: %s", client.ID)
-			} else {
-				h.mu.Unlock()
 			}
 		}
 	}
 }
 
-// runBroadcast handles broadcast messages
+// addClient adds a client to the hub and updates the connected count
+func (h *Hub) addClient(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.clients[client] {
+		h.clients[client] = true
+		atomic.AddInt64(&h.count, 1)
+
+		if client.UserID != 0 {
+			if h.users[client.UserID] == nil {
+				h.users[client.UserID] = make(map[*Client]bool)
+			}
+			h.users[client.UserID][client] = true
+		}
+	}
+}
+
+// removeClient removes a client, closing its send channel, and reports
+// whether it was still registered
+func (h *Hub) removeClient(client *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	delete(h.clients, client)
+	if userClients := h.users[client.UserID]; userClients != nil {
+		delete(userClients, client)
+		if len(userClients) == 0 {
+			delete(h.users, client.UserID)
+		}
+	}
+	client.closeSend()
+	atomic.AddInt64(&h.count, -1)
+	h.releaseLocked(client.UserID)
+	wsConnectionCloses.Inc(client.closedFor())
+	return true
+}
+
+// reserve claims a connection slot before the upgrade so concurrent
+// handshakes cannot exceed the limits. Anonymous clients only count
+// towards the global limit. A limit of 0 is unlimited.
+func (h *Hub) reserve(userID uint, maxTotal, maxPerUser int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if maxTotal > 0 && h.slots >= maxTotal {
+		return ErrTooManyConnections
+	}
+	if userID != 0 && maxPerUser > 0 && h.userSlots[userID] >= maxPerUser {
+		return ErrTooManyUserConnections
+	}
+
+	h.slots++
+	if userID != 0 {
+		h.userSlots[userID]++
+	}
+	return nil
+}
+
+// release frees a slot claimed by reserve
+func (h *Hub) release(userID uint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.releaseLocked(userID)
+}
+
+// releaseLocked frees a connection slot. Callers hold h.mu.
+func (h *Hub) releaseLocked(userID uint) {
+	if h.slots > 0 {
+		h.slots--
+	}
+	if userID == 0 {
+		return
+	}
+	if h.userSlots[userID] <= 1 {
+		delete(h.userSlots, userID)
+	} else {
+		h.userSlots[userID]--
+	}
+}
+
+// runBroadcast handles broadcast messages until Close closes the channel
 func (s *WebSocketService) runBroadcast() {
 	for {
-		message := <-s.broadcast
+		message, ok := <-s.broadcast
+		if !ok {
+			return
+		}
 		s.hub.broadcast(message)
 	}
 }
@@ -164,9 +423,9 @@ func (h *Hub) broadcast(message *Message) {
 		return
 	}
 
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	var slow []*Client
 
+	h.mu.RLock()
 	for client := range h.clients {
 		// If room is specified, only send to clients in that room
 		if message.Room != "" {
@@ -179,24 +438,92 @@ func (h *Hub) broadcast(message *Message) {
 			}
 		}
 
-		select {
-		case client.send <- data:
-		default:
-			// Client's send channel is full, close it
-			close(client.send)
-			delete(h.clients, client)
+		if !client.trySend(message.Type, data) {
+			slow = append(slow, client)
 		}
 	}
+	h.mu.RUnlock()
+
+	h.evict(slow)
+}
+
+// evict hands clients whose send buffer is full to the hub loop for
+// removal. It must be called without holding h.mu.
+func (h *Hub) evict(clients []*Client) {
+	for _, client := range clients {
+		logger.Warnf("Dropping slow WebSocket client %s", client.ID)
+		client.setCloseReason(wsCloseSlowClient)
+		h.unregister <- client
+	}
+}
+
+// trySend queues data, a message of type kind, without blocking and
+// reports whether it was queued
+func (c *Client) trySend(kind string, data []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- outboundMessage{kind: kind, data: data}:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeSend closes the send channel once, which stops writePump
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// setCloseReason records why the connection is closing and reports whether
+// it did; the first reason recorded wins
+func (c *Client) setCloseReason(reason string) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closeReason != "" {
+		return false
+	}
+	c.closeReason = reason
+	return true
+}
+
+// closedFor returns the recorded close reason. Connections closed without
+// one were closed by the server, during shutdown, or by the client.
+func (c *Client) closedFor() string {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.closeReason == "" {
+		return wsCloseClient
+	}
+	return c.closeReason
 }
 
 // readPump reads messages from the WebSocket connection
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
+		select {
+		case <-c.writeDone:
+		case <-time.After(closeGracePeriod):
+		}
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(c.service.config.WebSocket.MaxMessageSize)
+	// The size limit is enforced here rather than with SetReadLimit, which
+	// closes the connection without telling the client why
+	maxSize := c.service.config.WebSocket.MaxMessageSize
 	c.conn.SetReadDeadline(time.Now().Add(c.service.config.WebSocket.PongWait))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(c.service.config.WebSocket.PongWait))
@@ -204,15 +531,42 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		var message Message
-		err := c.conn.ReadJSON(&message)
+		data, err := c.readMessage(maxSize)
+		if errors.Is(err, errMessageTooBig) {
+			logger.Debugf("Closing WebSocket client %s: message exceeds %d bytes", c.ID, maxSize)
+			c.setCloseReason(wsCloseMessageTooBig)
+			c.closeWithError(websocket.CloseMessageTooBig, fmt.Sprintf("Message exceeds %d bytes", maxSize))
+			break
+		}
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				logger.WithError(err).Errorf("WebSocket error for client %s", c.ID)
-			}
+			c.recordReadError(err)
+			break
+		}
+
+		// Malformed messages count against the rate limit too
+		if !c.limiter.allow(time.Now()) {
+			logger.Warnf("Closing WebSocket client %s: message rate limit exceeded", c.ID)
+			c.setCloseReason(wsCloseRateLimited)
+			c.closeWithError(websocket.ClosePolicyViolation, "Message rate limit exceeded")
 			break
 		}
 
+		// A malformed message is the client's mistake, not a broken
+		// connection: report it and keep reading
+		var message Message
+		if err := json.Unmarshal(data, &message); err != nil {
+			wsMessagesReceived.Inc("invalid")
+			logger.Debugf("Invalid message from WebSocket client %s: %v", c.ID, err)
+			c.SendError("Invalid message: malformed JSON")
+			continue
+		}
+
+		if wsInboundTypes[message.Type] {
+			wsMessagesReceived.Inc(message.Type)
+		} else {
+			wsMessagesReceived.Inc("custom")
+		}
+
 		// Add metadata
 		message.UserID = c.UserID
 		message.Timestamp = time.Now()
@@ -222,12 +576,117 @@ func (c *Client) readPump() {
 	}
 }
 
+// recordReadError records why reading from the connection failed. Normal
+// closes by the client are expected; anything else is logged and counted as
+// a connection error, unless the server was already closing the connection.
+func (c *Client) recordReadError(err error) {
+	var netErr net.Error
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		c.setCloseReason(wsCloseClient)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// No pong arrived within WS_PONG_WAIT
+		if c.setCloseReason(wsCloseTimeout) {
+			wsConnectionErrors.Inc(wsCloseTimeout)
+		}
+	default:
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			logger.WithError(err).Errorf("WebSocket error for client %s", c.ID)
+		}
+		if c.setCloseReason(wsCloseReadError) {
+			wsConnectionErrors.Inc(wsCloseReadError)
+		}
+	}
+}
+
+// errMessageTooBig is returned by readMessage for messages over the limit
+var errMessageTooBig = errors.New("message too big")
+
+// readMessage reads the next message, reading at most one byte past maxSize
+// so an oversized message is detected without buffering it. A maxSize of 0
+// is unlimited.
+func (c *Client) readMessage(maxSize int64) ([]byte, error) {
+	_, reader, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		return io.ReadAll(reader)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, errMessageTooBig
+	}
+	return data, nil
+}
+
+// closeWithError tells the client why it is disconnected: an error message
+// followed by a close frame carrying code and reason. The caller then ends
+// readPump, which unregisters the client.
+func (c *Client) closeWithError(code int, reason string) {
+	c.SendError(reason)
+	c.setCloseFrame(code, reason)
+}
+
+// setCloseFrame sets the close frame writePump sends, unless the client is
+// already closing
+func (c *Client) setCloseFrame(code int, reason string) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.closed && c.closeFrame == nil {
+		c.closeFrame = websocket.FormatCloseMessage(code, reason)
+	}
+}
+
+// messageLimiter is a token bucket holding up to burst tokens, refilled at
+// rate tokens per second. A nil limiter allows everything.
+type messageLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newMessageLimiter returns a full bucket, or nil when rate is not positive
+func newMessageLimiter(rate float64, burst int) *messageLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &messageLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if one is available
+func (l *messageLimiter) allow(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
 // writePump writes messages to the WebSocket connection
 func (c *Client) writePump() {
 	ticker := time.NewTicker(c.service.config.WebSocket.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		close(c.writeDone)
 	}()
 
 	for {
@@ -235,15 +694,32 @@ func (c *Client) writePump() {
 		case message, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				frame := c.closeFrame
+				if frame == nil {
+					frame = []byte{}
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, frame)
 				return
 			}
 
-			c.conn.WriteMessage(websocket.TextMessage, message)
+			// Small messages grow when deflated. This only has an effect
+			// when compression was negotiated; binary frames carrying
+			// already compressed data should be written with it off too.
+			c.conn.EnableWriteCompression(len(message.data) >= wsCompressionMinSize)
+			if err := c.conn.WriteMessage(websocket.TextMessage, message.data); err != nil {
+				if c.setCloseReason(wsCloseWriteError) {
+					wsConnectionErrors.Inc(wsCloseWriteError)
+				}
+				return
+			}
+			wsMessagesSent.Inc(message.kind)
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				if c.setCloseReason(wsCloseWriteError) {
+					wsConnectionErrors.Inc(wsCloseWriteError)
+				}
 				return
 			}
 		}
@@ -273,8 +749,13 @@ func (c *Client) handleMessage(message *Message) {
 		c.LeaveRoom(data["room"])
 
 	case "broadcast":
-		// Forward to broadcast channel
-		c.service.broadcast <- message
+		// Forward to broadcast channel without blocking the read loop, so a
+		// full channel sheds client broadcasts instead of stalling everyone
+		select {
+		case c.service.broadcast <- message:
+		default:
+			c.SendError("Server is busy, broadcast dropped")
+		}
 
 	default:
 		// Custom message handling
@@ -306,12 +787,10 @@ func (c *Client) SendJSON(messageType string, data interface{}) error {
 		return err
 	}
 
-	select {
-	case c.send <- messageBytes:
-		return nil
-	default:
+	if !c.trySend(messageType, messageBytes) {
 		return fmt.Errorf("client send buffer is full")
 	}
+	return nil
 }
 
 // SendError sends an error message to the client
@@ -319,8 +798,25 @@ func (c *Client) SendError(errorMsg string) {
 	c.SendJSON("error", map[string]string{"error": errorMsg})
 }
 
-// JoinRoom adds the client to a room
+// SetRoomAuthorizer sets the check JoinRoom consults, e.g. to keep private
+// or direct message rooms to their members. nil restores AllowAllRooms. Set
+// it before clients connect.
+func (s *WebSocketService) SetRoomAuthorizer(authorize RoomAuthorizer) {
+	if authorize == nil {
+		authorize = AllowAllRooms
+	}
+	s.authorizeRoom = authorize
+}
+
+// JoinRoom adds the client to a room if the room authorizer allows it, and
+// sends the client an error otherwise
 func (c *Client) JoinRoom(room string) {
+	if !c.service.authorizeRoom(c, room) {
+		logger.Warnf("Client %s was refused room %s", c.ID, room)
+		c.SendError(fmt.Sprintf("Not allowed to join room %s", room))
+		return
+	}
+
 	c.mu.Lock()
 	c.rooms[room] = true
 	c.mu.Unlock()
@@ -375,33 +871,155 @@ func (s *WebSocketService) BroadcastToUser(userID uint, messageType string, data
 		return err
 	}
 
+	return s.sendToUser(userID, messageType, messageBytes)
+}
+
+// BroadcastToUserOrQueue sends a message to a user, queueing it for
+// delivery on their next connection when they are offline. Without Redis or
+// with WS_OFFLINE_QUEUE_ENABLED=false it behaves like BroadcastToUser.
+// Users connected to another instance count as offline here.
+func (s *WebSocketService) BroadcastToUserOrQueue(userID uint, messageType string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	message := Message{
+		Type:      messageType,
+		Data:      jsonData,
+		Timestamp: time.Now(),
+	}
+
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	err = s.sendToUser(userID, messageType, messageBytes)
+	if !errors.Is(err, ErrUserNotConnected) || !s.offlineQueueEnabled() {
+		return err
+	}
+
+	cfg := s.config.WebSocket
+	if err := s.redis.QueuePush(offlineQueueKey(userID), messageBytes, cfg.OfflineQueueMaxLength, cfg.OfflineQueueTTL); err != nil {
+		return fmt.Errorf("failed to queue message: %w", err)
+	}
+	return nil
+}
+
+// BroadcastToUsers sends a message to every connection of each listed user,
+// encoding it once. Users that are not connected are skipped, and repeated
+// IDs receive the message once.
+func (s *WebSocketService) BroadcastToUsers(userIDs []uint, messageType string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	message := Message{
+		Type:      messageType,
+		Data:      jsonData,
+		Timestamp: time.Now(),
+	}
+
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	s.sendToUsers(userIDs, messageType, messageBytes)
+	return nil
+}
+
+// sendToUser queues an encoded message of type kind on every connection of
+// the user
+func (s *WebSocketService) sendToUser(userID uint, kind string, messageBytes []byte) error {
+	if s.sendToUsers([]uint{userID}, kind, messageBytes) == 0 {
+		return fmt.Errorf("%w: %d", ErrUserNotConnected, userID)
+	}
+	return nil
+}
+
+// sendToUsers queues an encoded message of type kind on every connection of
+// the users, looking them up in the hub's user index, and returns how many
+// users were connected
+func (s *WebSocketService) sendToUsers(userIDs []uint, kind string, messageBytes []byte) int {
+	var slow []*Client
+	found := 0
+	seen := make(map[uint]bool, len(userIDs))
+
 	s.hub.mu.RLock()
-	defer s.hub.mu.RUnlock()
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
 
-	sent := false
-	for client := range s.hub.clients {
-		if client.UserID == userID {
-			select {
-			case client.send <- messageBytes:
-				sent = true
-			default:
-				// Client buffer is full
+		clients := s.hub.users[userID]
+		if len(clients) == 0 {
+			continue
+		}
+		found++
+		for client := range clients {
+			if !client.trySend(kind, messageBytes) {
+				slow = append(slow, client)
 			}
 		}
 	}
+	s.hub.mu.RUnlock()
+
+	s.hub.evict(slow)
+	return found
+}
+
+// offlineQueueEnabled reports whether offline messages can be queued
+func (s *WebSocketService) offlineQueueEnabled() bool {
+	return s.config.WebSocket.OfflineQueueEnabled && s.redis.Available()
+}
 
-	if !sent {
-		return fmt.Errorf("user %d is not connected", userID)
+// offlineQueueKey returns the Redis list holding a user's queued messages
+func offlineQueueKey(userID uint) string {
+	return fmt.Sprintf("ws:offline:%d", userID)
+}
+
+// deliverQueued sends messages queued while the user was offline, in
+// order. A message is removed from the queue only once it is in the
+// client's send buffer; those that do not fit stay queued for the next
+// connection.
+func (s *WebSocketService) deliverQueued(client *Client) {
+	if !s.offlineQueueEnabled() {
+		return
 	}
 
-	return nil
+	key := offlineQueueKey(client.UserID)
+	queued, err := s.redis.QueueRange(key)
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to load queued messages for user %d", client.UserID)
+		return
+	}
+
+	delivered := 0
+	for _, message := range queued {
+		// Queued messages are ones this service encoded, so only the type
+		// is read back
+		var header struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(message), &header)
+		if !client.trySend(header.Type, []byte(message)) {
+			break
+		}
+		delivered++
+	}
+
+	if err := s.redis.QueueAck(key, queued[:delivered]); err != nil {
+		logger.WithError(err).Warnf("Failed to remove delivered messages for user %d", client.UserID)
+	}
 }
 
 // GetConnectedClients returns the number of connected clients
 func (s *WebSocketService) GetConnectedClients() int {
-	s.hub.mu.RLock()
-	defer s.hub.mu.RUnlock()
-	return len(s.hub.clients)
+	return int(atomic.LoadInt64(&s.hub.count))
 }
 
 // GetRoomClients returns the number of clients in a specific room
@@ -421,6 +1039,60 @@ func (s *WebSocketService) GetRoomClients(room string) int {
 	return count
 }
 
+// GetActiveRooms returns the number of rooms with at least one client
+func (s *WebSocketService) GetActiveRooms() int {
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+
+	rooms := make(map[string]bool)
+	for client := range s.hub.clients {
+		client.mu.RLock()
+		for room := range client.rooms {
+			rooms[room] = true
+		}
+		client.mu.RUnlock()
+	}
+
+	return len(rooms)
+}
+
+// shutdownPollInterval is how often Shutdown checks whether clients have
+// disconnected
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown sends every client a going-away close frame after its queued
+// messages and waits for the connections to close. Connections still open
+// when ctx is done are closed forcibly; their number is returned.
+func (s *WebSocketService) Shutdown(ctx context.Context) int {
+	s.hub.mu.RLock()
+	for client := range s.hub.clients {
+		client.setCloseReason(wsCloseShutdown)
+		client.setCloseFrame(websocket.CloseGoingAway, "Server shutting down")
+		client.closeSend()
+	}
+	s.hub.mu.RUnlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		remaining := s.GetConnectedClients()
+		if remaining == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			s.hub.mu.RLock()
+			for client := range s.hub.clients {
+				client.conn.Close()
+			}
+			s.hub.mu.RUnlock()
+			return remaining
+		case <-ticker.C:
+		}
+	}
+}
+
 // Close gracefully shuts down the WebSocket service
 func (s *WebSocketService) Close() {
 	s.hub.mu.Lock()
@@ -428,9 +1100,15 @@ func (s *WebSocketService) Close() {
 
 	// Close all client connections
 	for client := range s.hub.clients {
+		client.closeSend()
 		client.conn.Close()
 		delete(s.hub.clients, client)
+		wsConnectionCloses.Inc(wsCloseShutdown)
 	}
+	s.hub.users = make(map[uint]map[*Client]bool)
+	atomic.StoreInt64(&s.hub.count, 0)
+	s.hub.slots = 0
+	s.hub.userSlots = make(map[uint]int)
 
 	close(s.broadcast)
 }
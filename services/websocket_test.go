@@ -0,0 +1,959 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"go-api-boilerplate/pkg/metrics"
+	"go-api-boilerplate/utils"
+)
+
+// newTestWebSocketServer serves the WebSocket service on a test server and
+// returns its ws:// URL
+func newTestWebSocketServer(t *testing.T, s *WebSocketService) string {
+	t.Helper()
+	return newTestWebSocketServerForUser(t, s, 0)
+}
+
+// newTestWebSocketServerForUser is newTestWebSocketServer with every
+// connection authenticated as userID, or anonymous when it is 0
+func newTestWebSocketServerForUser(t *testing.T, s *WebSocketService, userID uint) string {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if userID != 0 {
+		router.Use(func(c *gin.Context) {
+			c.Set(utils.ContextKeyUserID, userID)
+		})
+	}
+	router.GET("/ws", s.HandleWebSocket)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+// dialTestWebSocket connects to url and reads the welcome message
+func dialTestWebSocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("failed to read welcome message: %v", err)
+	}
+	return conn
+}
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestBroadcastEvictsStalledClient(t *testing.T) {
+	loadTestConfig(t, nil)
+	s := NewWebSocketService(nil)
+	url := newTestWebSocketServer(t, s)
+
+	// The stalled client never reads again, so its send buffer fills up
+	stalled := dialTestWebSocket(t, url)
+	reader := dialTestWebSocket(t, url)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			if _, _, err := reader.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Clients come and go while the broadcasts run
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err == nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	payload := strings.Repeat("x", 32*1024)
+	for i := 0; i < 2000 && s.GetConnectedClients() >= 2; i++ {
+		if err := s.BroadcastToRoom("", "stress", payload); err != nil {
+			t.Fatalf("BroadcastToRoom: %v", err)
+		}
+	}
+	close(done)
+
+	// The server closes the stalled connection once it has been evicted;
+	// the reader may be evicted too on a loaded machine
+	stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := stalled.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			t.Error("stalled client was not evicted")
+		}
+		break
+	}
+
+	reader.Close()
+	wg.Wait()
+}
+
+func TestCloseStopsBroadcastLoop(t *testing.T) {
+	loadTestConfig(t, nil)
+	s := NewWebSocketService(nil)
+	url := newTestWebSocketServer(t, s)
+	dialTestWebSocket(t, url)
+
+	s.Close()
+	if got := s.GetConnectedClients(); got != 0 {
+		t.Errorf("connected clients after Close = %d, want 0", got)
+	}
+
+	// runBroadcast must return rather than hand the hub nil messages, which
+	// panic as soon as a client is connected
+	dialTestWebSocket(t, url)
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestOfflineQueueDeliveredInOrderOnConnect(t *testing.T) {
+	loadTestConfig(t, map[string]string{"WS_OFFLINE_QUEUE_ENABLED": "true"})
+	redis, server := newTestRedis(t)
+	s := NewWebSocketService(redis)
+	defer s.Close()
+
+	const userID = 7
+	for i := 0; i < 3; i++ {
+		if err := s.BroadcastToUserOrQueue(userID, "notice", i); err != nil {
+			t.Fatalf("BroadcastToUserOrQueue: %v", err)
+		}
+	}
+
+	conn := dialTestWebSocket(t, newTestWebSocketServerForUser(t, s, userID))
+	for want := 0; want < 3; want++ {
+		var message Message
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("failed to read queued message %d: %v", want, err)
+		}
+		var got int
+		if err := json.Unmarshal(message.Data, &got); err != nil || message.Type != "notice" || got != want {
+			t.Fatalf("message %d = %s %s, want notice %d", want, message.Type, message.Data, want)
+		}
+	}
+
+	if server.Exists(offlineQueueKey(userID)) {
+		t.Error("delivered messages are still queued")
+	}
+}
+
+func TestQueueAckKeepsMessagesQueuedSinceRange(t *testing.T) {
+	loadTestConfig(t, nil)
+	redis, _ := newTestRedis(t)
+	key := offlineQueueKey(7)
+
+	for _, value := range []string{"a", "b"} {
+		if err := redis.QueuePush(key, value, 0, time.Minute); err != nil {
+			t.Fatalf("QueuePush: %v", err)
+		}
+	}
+	read, err := redis.QueueRange(key)
+	if err != nil {
+		t.Fatalf("QueueRange: %v", err)
+	}
+	if err := redis.QueuePush(key, "c", 0, time.Minute); err != nil {
+		t.Fatalf("QueuePush: %v", err)
+	}
+
+	// Only the first message was sent before the connection went away
+	if err := redis.QueueAck(key, read[:1]); err != nil {
+		t.Fatalf("QueueAck: %v", err)
+	}
+
+	left, err := redis.QueueRange(key)
+	if err != nil {
+		t.Fatalf("QueueRange: %v", err)
+	}
+	if strings.Join(left, ",") != "b,c" {
+		t.Errorf("queue after ack = %v, want [b c]", left)
+	}
+}
+
+// dialStatus attempts a WebSocket connection with the given Origin header
+// and returns the HTTP status of the handshake response
+func dialStatus(t *testing.T, url, origin string) int {
+	t.Helper()
+
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil {
+		conn.Close()
+	}
+	if resp == nil {
+		t.Fatalf("no handshake response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestWebSocketCheckOrigin(t *testing.T) {
+	loadTestConfig(t, map[string]string{
+		"CORS_ALLOWED_ORIGINS": "https://app.example.com,*.example.org",
+	})
+	url := newTestWebSocketServer(t, NewWebSocketService(nil))
+
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"https://app.example.com", http.StatusSwitchingProtocols},
+		{"https://api.example.org", http.StatusSwitchingProtocols},
+		{"", http.StatusSwitchingProtocols},
+		{"https://evil.example.net", http.StatusForbidden},
+		{"https://app.example.com.evil.net", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := dialStatus(t, url, tt.origin); got != tt.want {
+			t.Errorf("origin %q: status %d, want %d", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestWebSocketAllowedOriginsOverrideCORS(t *testing.T) {
+	loadTestConfig(t, map[string]string{
+		"CORS_ALLOWED_ORIGINS": "https://app.example.com",
+		"WS_ALLOWED_ORIGINS":   "https://live.example.com",
+	})
+	url := newTestWebSocketServer(t, NewWebSocketService(nil))
+
+	if got := dialStatus(t, url, "https://live.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("WebSocket origin: status %d, want 101", got)
+	}
+	if got := dialStatus(t, url, "https://app.example.com"); got != http.StatusForbidden {
+		t.Errorf("REST-only origin: status %d, want 403", got)
+	}
+}
+
+func TestWebSocketWildcardOriginRefusedInProduction(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]string{"WS_ALLOWED_ORIGINS": "*"})
+	development := newTestWebSocketServer(t, NewWebSocketService(nil))
+	if got := dialStatus(t, development, "https://anywhere.example.com"); got != http.StatusSwitchingProtocols {
+		t.Errorf("* in development: status %d, want 101", got)
+	}
+
+	cfg.App.Env = "production"
+	production := newTestWebSocketServer(t, NewWebSocketService(nil))
+	if got := dialStatus(t, production, "https://anywhere.example.com"); got != http.StatusForbidden {
+		t.Errorf("* in production: status %d, want 403", got)
+	}
+}
+
+func TestWebSocketConnectionLimitPerUser(t *testing.T) {
+	loadTestConfig(t, map[string]string{"WS_MAX_CONNECTIONS_PER_USER": "3"})
+	s := NewWebSocketService(nil)
+	url := newTestWebSocketServerForUser(t, s, 7)
+
+	var conns []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conns = append(conns, dialTestWebSocket(t, url))
+	}
+	if got := dialStatus(t, url, ""); got != http.StatusTooManyRequests {
+		t.Fatalf("connection over the limit: status %d, want 429", got)
+	}
+
+	// Another user has slots of their own
+	if got := dialStatus(t, newTestWebSocketServerForUser(t, s, 8), ""); got != http.StatusSwitchingProtocols {
+		t.Errorf("other user: status %d, want 101", got)
+	}
+
+	// Disconnecting frees the slot
+	conns[0].Close()
+	if !waitFor(t, 5*time.Second, func() bool { return dialStatus(t, url, "") == http.StatusSwitchingProtocols }) {
+		t.Error("the slot of a closed connection was not freed")
+	}
+}
+
+func TestWebSocketGlobalConnectionLimit(t *testing.T) {
+	loadTestConfig(t, map[string]string{"WS_MAX_CONNECTIONS": "2"})
+	s := NewWebSocketService(nil)
+	url := newTestWebSocketServer(t, s)
+
+	dialTestWebSocket(t, url)
+	dialTestWebSocket(t, url)
+	if got := dialStatus(t, url, ""); got != http.StatusTooManyRequests {
+		t.Errorf("connection over the global limit: status %d, want 429", got)
+	}
+}
+
+func TestMalformedMessageKeepsConnection(t *testing.T) {
+	loadTestConfig(t, nil)
+	s := NewWebSocketService(nil)
+	defer s.Close()
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s))
+
+	frames := []struct {
+		name     string
+		data     string
+		wantType string
+	}{
+		{"malformed JSON", `{"type": "ping"`, "error"},
+		{"valid ping", `{"type": "ping"}`, "pong"},
+		{"not an object", `"ping"`, "error"},
+		{"ping after another bad frame", `{"type": "ping"}`, "pong"},
+	}
+	for _, frame := range frames {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame.data)); err != nil {
+			t.Fatalf("%s: failed to send: %v", frame.name, err)
+		}
+
+		var message Message
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("%s: connection closed: %v", frame.name, err)
+		}
+		if message.Type != frame.wantType {
+			t.Errorf("%s: got a %q message, want %q", frame.name, message.Type, frame.wantType)
+		}
+	}
+
+	if got := s.GetConnectedClients(); got != 1 {
+		t.Errorf("connected clients = %d, want 1", got)
+	}
+}
+
+// scrapeMetric scrapes the value of series, a metric name with any labels,
+// from the metrics endpoint
+func scrapeMetric(t *testing.T, series string) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			return value
+		}
+	}
+	t.Fatalf("%s not exported", series)
+	return ""
+}
+
+func TestConnectedClientsGauge(t *testing.T) {
+	loadTestConfig(t, nil)
+	s := NewWebSocketService(nil)
+
+	const n = 50
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = &Client{
+			ID:     strconv.Itoa(i),
+			UserID: uint(i%5 + 1),
+			send:   make(chan outboundMessage, 1),
+			hub:    s.hub,
+			rooms:  make(map[string]bool),
+		}
+	}
+
+	// Readers poll the count and the gauge while clients come and go
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if got := s.GetConnectedClients(); got < 0 || got > n {
+						t.Errorf("connected clients = %d, want 0 to %d", got, n)
+					}
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.hub.reserve(client.UserID, 0, 0)
+			s.hub.register <- client
+		}()
+	}
+	wg.Wait()
+	if !waitFor(t, time.Second, func() bool { return s.GetConnectedClients() == n }) {
+		t.Fatalf("connected clients after register = %d, want %d", s.GetConnectedClients(), n)
+	}
+	if got := scrapeMetric(t, "websocket_connected_clients"); got != strconv.Itoa(n) {
+		t.Errorf("gauge after register = %s, want %d", got, n)
+	}
+
+	// Each client is unregistered twice, as readPump and an eviction may
+	// both do, and its send channel closed concurrently
+	for _, client := range clients {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			s.hub.unregister <- client
+		}()
+		go func() {
+			defer wg.Done()
+			s.hub.unregister <- client
+		}()
+		go func() {
+			defer wg.Done()
+			client.closeSend()
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	if !waitFor(t, time.Second, func() bool { return s.GetConnectedClients() == 0 }) {
+		t.Fatalf("connected clients after unregister = %d, want 0", s.GetConnectedClients())
+	}
+	if got := scrapeMetric(t, "websocket_connected_clients"); got != "0" {
+		t.Errorf("gauge after unregister = %s, want 0", got)
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	if len(s.hub.clients) != 0 || len(s.hub.users) != 0 || s.hub.slots != 0 || len(s.hub.userSlots) != 0 {
+		t.Errorf("hub left %d clients, %d users, %d slots, %d user slots", len(s.hub.clients), len(s.hub.users), s.hub.slots, len(s.hub.userSlots))
+	}
+}
+
+// readUntilClose reads messages until the server closes the connection and
+// returns their types, the last error message and the close frame
+func readUntilClose(t *testing.T, conn *websocket.Conn) ([]string, string, *websocket.CloseError) {
+	t.Helper()
+
+	var types []string
+	var lastError string
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var message Message
+		err := conn.ReadJSON(&message)
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return types, lastError, closeErr
+		}
+		if err != nil {
+			t.Fatalf("connection failed without a close frame: %v", err)
+		}
+
+		types = append(types, message.Type)
+		if message.Type == "error" {
+			var data map[string]string
+			json.Unmarshal(message.Data, &data)
+			lastError = data["error"]
+		}
+	}
+}
+
+func TestMessageBurstClosesWithPolicyViolation(t *testing.T) {
+	loadTestConfig(t, map[string]string{"WS_MESSAGE_RATE": "0.1", "WS_MESSAGE_BURST": "3"})
+	s := NewWebSocketService(nil)
+	defer s.Close()
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s))
+
+	for i := 0; i < 5; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "ping"}`)); err != nil {
+			t.Fatalf("message %d: failed to send: %v", i+1, err)
+		}
+	}
+
+	types, reason, closeErr := readUntilClose(t, conn)
+	if strings.Join(types, ",") != "pong,pong,pong,error" {
+		t.Errorf("messages before the close = %v, want three pongs and an error", types)
+	}
+	if reason != "Message rate limit exceeded" {
+		t.Errorf("error message = %q", reason)
+	}
+	if closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "Message rate limit exceeded" {
+		t.Errorf("close frame = %d %q, want a policy violation", closeErr.Code, closeErr.Text)
+	}
+	if !waitFor(t, time.Second, func() bool { return s.GetConnectedClients() == 0 }) {
+		t.Error("the throttled client is still connected")
+	}
+}
+
+func TestMessageLimiterRefills(t *testing.T) {
+	limiter := newMessageLimiter(2, 2)
+	start := limiter.last
+
+	steps := []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, true},
+		{0, true},
+		{0, false},
+		{250 * time.Millisecond, false},
+		{500 * time.Millisecond, true},
+		{500 * time.Millisecond, false},
+		{10 * time.Second, true},
+		{10 * time.Second, true},
+		{10 * time.Second, false},
+	}
+	for i, step := range steps {
+		if got := limiter.allow(start.Add(step.after)); got != step.want {
+			t.Errorf("step %d at +%v: allow = %v, want %v", i+1, step.after, got, step.want)
+		}
+	}
+
+	if newMessageLimiter(0, 5) != nil || !(*messageLimiter)(nil).allow(time.Now()) {
+		t.Error("a zero rate should not limit")
+	}
+}
+
+func TestBroadcastDroppedWhenChannelFull(t *testing.T) {
+	client := &Client{
+		ID:      "spammer",
+		send:    make(chan outboundMessage, 1),
+		service: &WebSocketService{broadcast: make(chan *Message)},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.handleMessage(&Message{Type: "broadcast", Data: json.RawMessage(`{}`)})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a broadcast blocked on the full channel")
+	}
+
+	message := <-client.send
+	if message.kind != "error" || !strings.Contains(string(message.data), "broadcast dropped") {
+		t.Errorf("client was sent %s %s, want the dropped broadcast error", message.kind, message.data)
+	}
+}
+
+func TestOversizedMessageClosesWithReason(t *testing.T) {
+	loadTestConfig(t, map[string]string{"WS_MAX_MESSAGE_SIZE": "64"})
+	s := NewWebSocketService(nil)
+	defer s.Close()
+	conn := dialTestWebSocket(t, newTestWebSocketServer(t, s))
+
+	// A message of exactly the limit is read as usual
+	ping := `{"type": "ping"}`
+	fits := ping + strings.Repeat(" ", 64-len(ping))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(fits)); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(fits+" ")); err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+
+	types, reason, closeErr := readUntilClose(t, conn)
+	if strings.Join(types, ",") != "pong,error" {
+		t.Errorf("messages before the close = %v, want a pong and an error", types)
+	}
+	if reason != "Message exceeds 64 bytes" {
+		t.Errorf("error message = %q", reason)
+	}
+	if closeErr.Code != websocket.CloseMessageTooBig || closeErr.Text != reason {
+		t.Errorf("close frame = %d %q, want %d with the same reason", closeErr.Code, closeErr.Text, websocket.CloseMessageTooBig)
+	}
+}
+
+// joinRoom asks to join room over conn and returns the reply
+func joinRoom(t *testing.T, conn *websocket.Conn, room string) Message {
+	t.Helper()
+
+	data, _ := json.Marshal(map[string]string{"room": room})
+	if err := conn.WriteJSON(Message{Type: "join_room", Data: data}); err != nil {
+		t.Fatalf("failed to send join_room: %v", err)
+	}
+	var reply Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("failed to read join_room reply: %v", err)
+	}
+	return reply
+}
+
+func TestRoomAuthorizer(t *testing.T) {
+	loadTestConfig(t, nil)
+	s := NewWebSocketService(nil)
+	defer s.Close()
+
+	// Only user 1 may join the private room
+	s.SetRoomAuthorizer(func(client *Client, room string) bool {
+		return room != "private" || client.UserID == 1
+	})
+	member := dialTestWebSocket(t, newTestWebSocketServerForUser(t, s, 1))
+	outsider := dialTestWebSocket(t, newTestWebSocketServerForUser(t, s, 2))
+
+	tests := []struct {
+		name     string
+		conn     *websocket.Conn
+		room     string
+		wantType string
+	}{
+		{"member joins the private room", member, "private", "room_joined"},
+		{"outsider refused the private room", outsider, "private", "error"},
+		{"outsider joins a public room", outsider, "lobby", "room_joined"},
+	}
+	for _, tt := range tests {
+		reply := joinRoom(t, tt.conn, tt.room)
+		if reply.Type != tt.wantType {
+			t.Errorf("%s: got a %q reply %s, want %q", tt.name, reply.Type, reply.Data, tt.wantType)
+		}
+		if tt.wantType == "error" && !strings.Contains(string(reply.Data), "Not allowed to join room private") {
+			t.Errorf("%s: error %s does not name the room", tt.name, reply.Data)
+		}
+	}
+	if got := s.GetRoomClients("private"); got != 1 {
+		t.Errorf("private room has %d clients, want only the member", got)
+	}
+
+	// A room broadcast does not reach the refused client
+	if err := s.BroadcastToRoom("private", "secret", "for members"); err != nil {
+		t.Fatalf("BroadcastToRoom: %v", err)
+	}
+	var message Message
+	member.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := member.ReadJSON(&message); err != nil || message.Type != "secret" {
+		t.Errorf("member got %v, %v, want the room broadcast", message.Type, err)
+	}
+	outsider.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if err := outsider.ReadJSON(&message); err == nil {
+		t.Errorf("refused client received a %q message", message.Type)
+	}
+}
+
+func TestRoomAuthorizerDefaultsToAllowAll(t *testing.T) {
+	loadTestConfig(t, nil)
+	s := NewWebSocketService(nil)
+	defer s.Close()
+	url := newTestWebSocketServerForUser(t, s, 2)
+
+	if reply := joinRoom(t, dialTestWebSocket(t, url), "private"); reply.Type != "room_joined" {
+		t.Errorf("default authorizer: got a %q reply, want room_joined", reply.Type)
+	}
+
+	s.SetRoomAuthorizer(func(*Client, string) bool { return false })
+	s.SetRoomAuthorizer(nil)
+	if reply := joinRoom(t, dialTestWebSocket(t, url), "private"); reply.Type != "room_joined" {
+		t.Errorf("after SetRoomAuthorizer(nil): got a %q reply, want room_joined", reply.Type)
+	}
+}
+
+// newBenchmarkHub returns a service whose hub holds connections for users
+// 1 to users, perUser each, with send buffers of capacity messages
+func newBenchmarkHub(users, perUser, capacity int) *WebSocketService {
+	hub := &Hub{
+		clients: make(map[*Client]bool),
+		users:   make(map[uint]map[*Client]bool),
+	}
+	for userID := 1; userID <= users; userID++ {
+		for i := 0; i < perUser; i++ {
+			hub.addClient(&Client{
+				ID:     strconv.Itoa(userID) + "-" + strconv.Itoa(i),
+				UserID: uint(userID),
+				send:   make(chan outboundMessage, capacity),
+				hub:    hub,
+			})
+		}
+	}
+	return &WebSocketService{hub: hub}
+}
+
+// scanSendToUser is how messages for a user were sent before the hub
+// indexed clients by user, scanning every connection
+func scanSendToUser(h *Hub, userID uint, kind string, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.UserID == userID {
+			client.trySend(kind, data)
+		}
+	}
+}
+
+// drainSends empties the send buffers of every client in the hub
+func drainSends(h *Hub) {
+	for client := range h.clients {
+		for len(client.send) > 0 {
+			<-client.send
+		}
+	}
+}
+
+func BenchmarkSendToUsers(b *testing.B) {
+	const users, perUser, targets = 5000, 2, 100
+
+	userIDs := make([]uint, targets)
+	for i := range userIDs {
+		userIDs[i] = uint(i*(users/targets) + 1)
+	}
+	data := []byte(`{"type":"notice"}`)
+
+	b.Run("index", func(b *testing.B) {
+		s := newBenchmarkHub(users, perUser, 64)
+		for i := 0; i < b.N; i++ {
+			if s.sendToUsers(userIDs, "notice", data) != targets {
+				b.Fatal("not every user was found")
+			}
+			if i%64 == 63 {
+				b.StopTimer()
+				drainSends(s.hub)
+				b.StartTimer()
+			}
+		}
+	})
+
+	b.Run("scan", func(b *testing.B) {
+		s := newBenchmarkHub(users, perUser, 64)
+		for i := 0; i < b.N; i++ {
+			for _, userID := range userIDs {
+				scanSendToUser(s.hub, userID, "notice", data)
+			}
+			if i%64 == 63 {
+				b.StopTimer()
+				drainSends(s.hub)
+				b.StartTimer()
+			}
+		}
+	})
+}
+
+// countingConn counts the bytes read from a connection
+type countingConn struct {
+	net.Conn
+	read atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func TestWebSocketCompression(t *testing.T) {
+	// A large, repetitive message, which deflate shrinks to a fraction
+	large := strings.Repeat("compressible payload ", 200)
+
+	tests := []struct {
+		name           string
+		enabled        string
+		wantNegotiated bool
+	}{
+		{"enabled", "true", true},
+		{"disabled", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, map[string]string{"WS_COMPRESSION": tt.enabled, "WS_COMPRESSION_LEVEL": "9"})
+			s := NewWebSocketService(nil)
+			defer s.Close()
+
+			var counted *countingConn
+			dialer := websocket.Dialer{
+				EnableCompression: true,
+				NetDial: func(network, addr string) (net.Conn, error) {
+					conn, err := net.Dial(network, addr)
+					if err != nil {
+						return nil, err
+					}
+					counted = &countingConn{Conn: conn}
+					return counted, nil
+				},
+			}
+			conn, resp, err := dialer.Dial(newTestWebSocketServerForUser(t, s, 1), nil)
+			if err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			defer conn.Close()
+
+			negotiated := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+			if negotiated != tt.wantNegotiated {
+				t.Fatalf("permessage-deflate negotiated: %v, want %v", negotiated, tt.wantNegotiated)
+			}
+
+			// Small and large messages both round-trip
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			var message Message
+			if err := conn.ReadJSON(&message); err != nil || message.Type != "welcome" {
+				t.Fatalf("welcome message: %v, %v", message.Type, err)
+			}
+			if err := conn.WriteJSON(Message{Type: "ping"}); err != nil {
+				t.Fatalf("failed to send ping: %v", err)
+			}
+			if err := conn.ReadJSON(&message); err != nil || message.Type != "pong" {
+				t.Fatalf("ping reply: %v, %v", message.Type, err)
+			}
+
+			before := counted.read.Load()
+			if err := s.BroadcastToUser(1, "notice", large); err != nil {
+				t.Fatalf("BroadcastToUser: %v", err)
+			}
+			if err := conn.ReadJSON(&message); err != nil {
+				t.Fatalf("failed to read large message: %v", err)
+			}
+			var got string
+			if err := json.Unmarshal(message.Data, &got); err != nil || message.Type != "notice" || got != large {
+				t.Fatalf("large message did not round-trip: %s %.40s, %v", message.Type, message.Data, err)
+			}
+
+			wire := counted.read.Load() - before
+			if compressed := wire < int64(len(large))/4; compressed != tt.wantNegotiated {
+				t.Errorf("%d byte message took %d bytes on the wire, want compressed: %v", len(large), wire, tt.wantNegotiated)
+			}
+		})
+	}
+}
+
+func TestWebSocketMessageMetrics(t *testing.T) {
+	loadTestConfig(t, nil)
+	s := NewWebSocketService(nil)
+	defer s.Close()
+	conn := dialTestWebSocket(t, newTestWebSocketServerForUser(t, s, 1))
+
+	// The counters are shared by every test, so compare against their
+	// values before each message
+	sent := wsMessagesSent.Value("metrics_test")
+	if err := s.BroadcastToUser(1, "metrics_test", "hello"); err != nil {
+		t.Fatalf("BroadcastToUser: %v", err)
+	}
+	var message Message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&message); err != nil || message.Type != "metrics_test" {
+		t.Fatalf("sent message: %v, %v", message.Type, err)
+	}
+	if !waitFor(t, 5*time.Second, func() bool { return wsMessagesSent.Value("metrics_test") == sent+1 }) {
+		t.Errorf("sent metrics_test messages = %d, want %d", wsMessagesSent.Value("metrics_test"), sent+1)
+	}
+	if got, want := scrapeMetric(t, `websocket_messages_sent_total{type="metrics_test"}`), strconv.FormatUint(sent+1, 10); got != want {
+		t.Errorf("exported sent metrics_test messages = %s, want %s", got, want)
+	}
+
+	frames := []struct {
+		name      string
+		data      string
+		wantLabel string
+		wantReply string
+	}{
+		{"ping", `{"type": "ping"}`, "ping", "pong"},
+		{"join_room", `{"type": "join_room", "data": {"room": "metrics"}}`, "join_room", "room_joined"},
+		{"unknown type", `{"type": "metrics_test"}`, "custom", ""},
+		{"malformed JSON", `{"type": "ping"`, "invalid", "error"},
+	}
+	for _, frame := range frames {
+		received := wsMessagesReceived.Value(frame.wantLabel)
+		replies := wsMessagesSent.Value(frame.wantReply)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame.data)); err != nil {
+			t.Fatalf("%s: failed to send: %v", frame.name, err)
+		}
+		if frame.wantReply != "" {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.ReadJSON(&message); err != nil || message.Type != frame.wantReply {
+				t.Fatalf("%s: reply %v, %v, want %s", frame.name, message.Type, err, frame.wantReply)
+			}
+		}
+
+		if !waitFor(t, 5*time.Second, func() bool { return wsMessagesReceived.Value(frame.wantLabel) == received+1 }) {
+			t.Errorf("%s: received %s messages = %d, want %d", frame.name, frame.wantLabel, wsMessagesReceived.Value(frame.wantLabel), received+1)
+		}
+		if frame.wantReply != "" && !waitFor(t, 5*time.Second, func() bool { return wsMessagesSent.Value(frame.wantReply) == replies+1 }) {
+			t.Errorf("%s: sent %s messages = %d, want %d", frame.name, frame.wantReply, wsMessagesSent.Value(frame.wantReply), replies+1)
+		}
+	}
+	if wsMessagesReceived.Value("metrics_test") != 0 {
+		t.Error("unknown message type counted under its own label, want custom")
+	}
+
+	if got := scrapeMetric(t, "websocket_active_rooms"); got != "1" {
+		t.Errorf("exported active rooms = %s, want 1", got)
+	}
+}
+
+func TestWebSocketCloseMetrics(t *testing.T) {
+	loadTestConfig(t, map[string]string{"WS_MAX_MESSAGE_SIZE": "64", "WS_MAX_CONNECTIONS_PER_USER": "1"})
+	s := NewWebSocketService(nil)
+	url := newTestWebSocketServerForUser(t, s, 1)
+
+	tests := []struct {
+		reason string
+		close  func(conn *websocket.Conn)
+	}{
+		{wsCloseClient, func(conn *websocket.Conn) {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		}},
+		{wsCloseMessageTooBig, func(conn *websocket.Conn) {
+			conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat(" ", 65)))
+		}},
+		{wsCloseShutdown, func(*websocket.Conn) { s.Close() }},
+	}
+	for _, tt := range tests {
+		closes := wsConnectionCloses.Value(tt.reason)
+		conn := dialTestWebSocket(t, url)
+
+		// The user's one slot is taken, so another connection is refused
+		rejected := wsConnectionErrors.Value("too_many_user_connections")
+		if got := dialStatus(t, url, ""); got != http.StatusTooManyRequests {
+			t.Errorf("%s: second connection status %d, want 429", tt.reason, got)
+		}
+		if got := wsConnectionErrors.Value("too_many_user_connections"); got != rejected+1 {
+			t.Errorf("%s: rejected connections = %d, want %d", tt.reason, got, rejected+1)
+		}
+
+		tt.close(conn)
+		if !waitFor(t, 5*time.Second, func() bool { return wsConnectionCloses.Value(tt.reason) == closes+1 }) {
+			t.Errorf("%s: closes = %d, want %d", tt.reason, wsConnectionCloses.Value(tt.reason), closes+1)
+		}
+		if !waitFor(t, 5*time.Second, func() bool { return s.GetConnectedClients() == 0 }) {
+			t.Fatalf("%s: connection still open", tt.reason)
+		}
+		conn.Close()
+	}
+}
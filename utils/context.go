@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin context keys for the authenticated user
+const (
+	ContextKeyUserID    = "user_id"
+	ContextKeyUserEmail = "user_email"
+	ContextKeyUserName  = "user_name"
+	ContextKeyUserRole  = "user_role"
+	ContextKeyIsActive  = "is_active"
+	ContextKeyClaims    = "claims"
+)
+
+// SetUserContext stores the authenticated user's claims in the Gin context.
+// The user ID is always stored as a uint.
+func SetUserContext(c *gin.Context, claims *JWTClaims) {
+	c.Set(ContextKeyUserID, claims.UserID)
+	c.Set(ContextKeyUserEmail, claims.Email)
+	c.Set(ContextKeyUserName, claims.Name)
+	c.Set(ContextKeyUserRole, claims.Role)
+	c.Set(ContextKeyIsActive, claims.IsActive)
+	c.Set(ContextKeyClaims, claims)
+}
+
+// ClaimsFromContext returns the authenticated user's token claims, including
+// any extra claims, from the Gin context
+func ClaimsFromContext(c *gin.Context) (*JWTClaims, bool) {
+	value, exists := c.Get(ContextKeyClaims)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*JWTClaims)
+	return claims, ok
+}
+
+// SetUserID stores a user ID in the Gin context, normalizing it to uint
+func SetUserID(c *gin.Context, value interface{}) bool {
+	id, ok := ToUserID(value)
+	if !ok {
+		return false
+	}
+	c.Set(ContextKeyUserID, id)
+	return true
+}
+
+// UserIDFromContext returns the authenticated user ID from the Gin context
+func UserIDFromContext(c *gin.Context) (uint, bool) {
+	value, exists := c.Get(ContextKeyUserID)
+	if !exists {
+		return 0, false
+	}
+	return ToUserID(value)
+}
+
+// ToUserID converts the representations a user ID may take (claims, JSON
+// decoded session data, metadata strings) to uint without panicking
+func ToUserID(value interface{}) (uint, bool) {
+	switch v := value.(type) {
+	case uint:
+		return v, v > 0
+	case uint64:
+		return uint(v), v > 0
+	case uint32:
+		return uint(v), v > 0
+	case int:
+		return uint(v), v > 0
+	case int64:
+		return uint(v), v > 0
+	case int32:
+		return uint(v), v > 0
+	case float64:
+		if v <= 0 || v != math.Trunc(v) || v >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint(v), true
+	case json.Number:
+		id, err := strconv.ParseUint(v.String(), 10, 64)
+		return uint(id), err == nil && id > 0
+	case string:
+		id, err := strconv.ParseUint(v, 10, 64)
+		return uint(id), err == nil && id > 0
+	default:
+		return 0, false
+	}
+}
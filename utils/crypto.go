@@ -7,45 +7,50 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"strings"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/hkdf"
 )
 
-// HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
-	}
-	return string(bytes), nil
-}
+// Ciphertext format versions. Version 1 derives a per-message AES-256 key
+// from the configured secret with HKDF-SHA256 and a random salt, and is laid
+// out as version || salt || nonce || sealed data. Legacy (unversioned) values
+// used the raw key zero-padded/truncated to 32 bytes and are still decryptable.
+const (
+	encryptionVersion1 byte = 0x01
+	encryptionSaltSize      = 16
+)
 
-// CheckPassword compares a password with its hash
-func CheckPassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
+var (
+	ErrEmptyEncryptionKey = errors.New("encryption key must not be empty")
+	ErrInvalidCiphertext  = errors.New("invalid ciphertext")
+)
 
-// Encrypt encrypts data using AES
+// Encrypt encrypts data using AES-256-GCM with a derived key
 func Encrypt(plainText string, key string) (string, error) {
-	// Ensure key is 32 bytes
-	keyBytes := make([]byte, 32)
-	copy(keyBytes, []byte(key))
+	if key == "" {
+		return "", ErrEmptyEncryptionKey
+	}
+
+	// Derive a message key from a fresh salt
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", fmt.Errorf("failed to create salt: %w", err)
+	}
 
-	block, err := aes.NewCipher(keyBytes)
+	keyBytes, err := deriveEncryptionKey(key, salt)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return "", err
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(keyBytes)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return "", err
 	}
 
 	// Create nonce
@@ -54,18 +59,20 @@ func Encrypt(plainText string, key string) (string, error) {
 		return "", fmt.Errorf("failed to create nonce: %w", err)
 	}
 
-	// Encrypt the data
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plainText), nil)
+	// Header is authenticated so the version and salt can't be swapped
+	header := append([]byte{encryptionVersion1}, salt...)
+	header = append(header, nonce...)
+	ciphertext := gcm.Seal(header, nonce, []byte(plainText), header[:1+encryptionSaltSize])
 
 	// Encode to base64
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// Decrypt decrypts data using AES
+// Decrypt decrypts data produced by Encrypt, including legacy values
 func Decrypt(cipherText string, key string) (string, error) {
-	// Ensure key is 32 bytes
-	keyBytes := make([]byte, 32)
-	copy(keyBytes, []byte(key))
+	if key == "" {
+		return "", ErrEmptyEncryptionKey
+	}
 
 	// Decode from base64
 	data, err := base64.StdEncoding.DecodeString(cipherText)
@@ -73,21 +80,70 @@ func Decrypt(cipherText string, key string) (string, error) {
 		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
 	}
 
-	block, err := aes.NewCipher(keyBytes)
+	if len(data) > 0 && data[0] == encryptionVersion1 {
+		plaintext, err := decryptV1(data, key)
+		if err == nil {
+			return plaintext, nil
+		}
+		// A legacy value may start with the version byte by chance;
+		// GCM authentication tells the two apart.
+		if legacy, legacyErr := decryptLegacy(data, key); legacyErr == nil {
+			return legacy, nil
+		}
+		return "", err
+	}
+
+	return decryptLegacy(data, key)
+}
+
+// decryptV1 decrypts a version 1 payload
+func decryptV1(data []byte, key string) (string, error) {
+	if len(data) < 1+encryptionSaltSize {
+		return "", ErrInvalidCiphertext
+	}
+
+	aad := data[:1+encryptionSaltSize]
+	salt := data[1 : 1+encryptionSaltSize]
+
+	keyBytes, err := deriveEncryptionKey(key, salt)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return "", err
 	}
 
-	// Create GCM mode
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(keyBytes)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return "", err
+	}
+
+	rest := data[1+encryptionSaltSize:]
+	if len(rest) < gcm.NonceSize() {
+		return "", ErrInvalidCiphertext
+	}
+
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// decryptLegacy decrypts values written before key derivation was added
+func decryptLegacy(data []byte, key string) (string, error) {
+	// Legacy key handling: zero-pad or truncate to 32 bytes
+	keyBytes := make([]byte, 32)
+	copy(keyBytes, []byte(key))
+
+	gcm, err := newGCM(keyBytes)
+	if err != nil {
+		return "", err
 	}
 
 	// Extract nonce
 	nonceSize := gcm.NonceSize()
 	if len(data) < nonceSize {
-		return "", fmt.Errorf("ciphertext too short")
+		return "", ErrInvalidCiphertext
 	}
 
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
@@ -101,6 +157,32 @@ func Decrypt(cipherText string, key string) (string, error) {
 	return string(plaintext), nil
 }
 
+// deriveEncryptionKey derives a 32-byte AES key from the secret and salt
+func deriveEncryptionKey(key string, salt []byte) ([]byte, error) {
+	keyBytes := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, []byte(key), salt, []byte("go-api-boilerplate encryption v1"))
+	if _, err := io.ReadFull(kdf, keyBytes); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return keyBytes, nil
+}
+
+// newGCM creates an AES-GCM AEAD for the given key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	// Create GCM mode
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return gcm, nil
+}
+
 // GenerateRandomString generates a random string of specified length
 func GenerateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, key := range []string{"k", "a key that is longer than thirty-two bytes in total"} {
+		cipherText, err := Encrypt("secret value", key)
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		if plain, err := Decrypt(cipherText, key); err != nil || plain != "secret value" {
+			t.Errorf("Decrypt with %q = %q, %v", key, plain, err)
+		}
+	}
+
+	// Keys sharing their first 32 bytes used to be the same key
+	long := strings.Repeat("x", 32)
+	cipherText, _ := Encrypt("secret value", long+"a")
+	if _, err := Decrypt(cipherText, long+"b"); err == nil {
+		t.Error("a key differing past byte 32 decrypted the value")
+	}
+}
+
+func TestEncryptRejectsEmptyKey(t *testing.T) {
+	if _, err := Encrypt("secret value", ""); !errors.Is(err, ErrEmptyEncryptionKey) {
+		t.Errorf("Encrypt: got %v, want ErrEmptyEncryptionKey", err)
+	}
+	if _, err := Decrypt("AA==", ""); !errors.Is(err, ErrEmptyEncryptionKey) {
+		t.Errorf("Decrypt: got %v, want ErrEmptyEncryptionKey", err)
+	}
+}
+
+func TestDecryptRejectsTamperedCiphertext(t *testing.T) {
+	cipherText, err := Encrypt("secret value", "key")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(cipherText)
+
+	// Flip a bit in the version, the salt, the nonce and the sealed data
+	for _, i := range []int{0, 1, 1 + encryptionSaltSize, len(data) - 1} {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 0x01
+		if _, err := Decrypt(base64.StdEncoding.EncodeToString(tampered), "key"); err == nil {
+			t.Errorf("byte %d tampered: decrypted without error", i)
+		}
+	}
+
+	if _, err := Decrypt(base64.StdEncoding.EncodeToString(data[:10]), "key"); err == nil {
+		t.Error("truncated ciphertext decrypted without error")
+	}
+	if _, err := Decrypt(cipherText, "other key"); err == nil {
+		t.Error("ciphertext decrypted with the wrong key")
+	}
+}
+
+func TestDecryptLegacyCiphertext(t *testing.T) {
+	// Seal a value the way Encrypt did before versioning: the raw key
+	// zero-padded to 32 bytes and nonce || sealed data
+	key := make([]byte, 32)
+	copy(key, "legacy key")
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	io.ReadFull(rand.Reader, nonce)
+	legacy := base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte("old value"), nil))
+
+	if plain, err := Decrypt(legacy, "legacy key"); err != nil || plain != "old value" {
+		t.Errorf("Decrypt legacy value = %q, %v", plain, err)
+	}
+}
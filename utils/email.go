@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"strings"
+
+	"go-api-boilerplate/config"
+)
+
+// NormalizeEmail returns the canonical form used to store and look up
+// emails: trimmed and lowercased. With AUTH_EMAIL_STRIP_GMAIL_ALIASES,
+// dots and +suffixes are also removed from Gmail addresses, since Gmail
+// delivers all of those variants to the same inbox.
+//
+// Rows written before normalization must be lowercased once, and a
+// case-insensitive unique index keeps the database consistent with it:
+//
+//	UPDATE users SET email = LOWER(email);
+//	CREATE UNIQUE INDEX idx_users_email_lower ON users (LOWER(email));  -- PostgreSQL, SQLite
+//
+// MySQL's default collations already compare case-insensitively.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if !config.Get().Auth.EmailStripGmailAliases {
+		return email
+	}
+
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || (domain != "gmail.com" && domain != "googlemail.com") {
+		return email
+	}
+
+	local, _, _ = strings.Cut(local, "+")
+	local = strings.ReplaceAll(local, ".", "")
+	return local + "@gmail.com"
+}
@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email       string
+		wantDefault string
+		wantAliases string
+	}{
+		{"user@example.com", "user@example.com", "user@example.com"},
+		{"  User@Example.COM ", "user@example.com", "user@example.com"},
+		{"first.last+news@example.com", "first.last+news@example.com", "first.last+news@example.com"},
+		{"First.Last+news@Gmail.com", "first.last+news@gmail.com", "firstlast@gmail.com"},
+		{"f.irst.last@googlemail.com", "f.irst.last@googlemail.com", "firstlast@gmail.com"},
+		{"not-an-email", "not-an-email", "not-an-email"},
+	}
+
+	loadTestConfig(t, nil)
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.email); got != tt.wantDefault {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.email, got, tt.wantDefault)
+		}
+	}
+
+	loadTestConfig(t, map[string]string{"AUTH_EMAIL_STRIP_GMAIL_ALIASES": "true"})
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.email); got != tt.wantAliases {
+			t.Errorf("NormalizeEmail(%q) stripping Gmail aliases = %q, want %q", tt.email, got, tt.wantAliases)
+		}
+	}
+}
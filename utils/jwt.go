@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"go-api-boilerplate/config"
@@ -10,22 +12,126 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWTClaims represents the JWT claims
+var (
+	// ErrInvalidAudience is returned when a token's audience does not match JWT_AUDIENCE
+	ErrInvalidAudience = errors.New("invalid token audience")
+	// ErrUntrustedIssuer is returned when a token's issuer is not trusted
+	ErrUntrustedIssuer = errors.New("untrusted token issuer")
+	// ErrReservedClaim is returned when an extra claim would replace one of
+	// the typed or registered claims
+	ErrReservedClaim = errors.New("reserved token claim")
+	// ErrInvalidSubject is returned when a token's subject is not a valid
+	// user ID, or does not match the token's user_id claim
+	ErrInvalidSubject = errors.New("invalid user ID in token")
+)
+
+func init() {
+	// Issue times carry milliseconds so that a token issued just after a
+	// revocation can be told from one issued just before it
+	jwt.TimePrecision = time.Millisecond
+}
+
+// reservedClaims are the claim names JWTClaims sets itself
+var reservedClaims = map[string]bool{
+	"user_id":   true,
+	"email":     true,
+	"name":      true,
+	"role":      true,
+	"is_active": true,
+	"iss":       true,
+	"sub":       true,
+	"aud":       true,
+	"exp":       true,
+	"nbf":       true,
+	"iat":       true,
+	"jti":       true,
+}
+
+// JWTClaims represents the JWT claims. Extra holds application-defined
+// claims, such as a tenant ID or feature flags, which are signed at the top
+// level of the token next to the typed ones; it is nil when a token has
+// none.
 type JWTClaims struct {
-	UserID   uint   `json:"user_id"`
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Role     string `json:"role"`
-	IsActive bool   `json:"is_active"`
+	UserID   uint                   `json:"user_id"`
+	Email    string                 `json:"email"`
+	Name     string                 `json:"name"`
+	Role     string                 `json:"role"`
+	IsActive bool                   `json:"is_active"`
+	Extra    map[string]interface{} `json:"-"`
 	jwt.RegisteredClaims
 }
 
+// jwtClaimsFields has the fields of JWTClaims without its JSON methods
+type jwtClaimsFields JWTClaims
+
+// MarshalJSON encodes the typed claims and merges Extra into them
+func (c JWTClaims) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(jwtClaimsFields(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+	if err := checkExtraClaims(c.Extra); err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]interface{}, len(c.Extra))
+	for key, value := range c.Extra {
+		merged[key] = value
+	}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON decodes the typed claims and collects every other claim in
+// Extra
+func (c *JWTClaims) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*jwtClaimsFields)(c)); err != nil {
+		return err
+	}
+
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for key := range reservedClaims {
+		delete(all, key)
+	}
+	c.Extra = nil
+	if len(all) > 0 {
+		c.Extra = all
+	}
+	return nil
+}
+
+// checkExtraClaims rejects extra claims that would replace a reserved one
+func checkExtraClaims(extra map[string]interface{}) error {
+	for key := range extra {
+		if reservedClaims[key] {
+			return fmt.Errorf("%w: %s", ErrReservedClaim, key)
+		}
+	}
+	return nil
+}
+
 // GenerateTokens generates both access and refresh tokens
 func GenerateTokens(userID uint, email, name, role string, isActive bool) (*TokenPair, error) {
+	return GenerateTokensWithClaims(userID, email, name, role, isActive, nil)
+}
+
+// GenerateTokensWithClaims generates both access and refresh tokens, signing
+// extra into the access token. Extra claims must be JSON encodable and may
+// not use the names of the typed or registered claims.
+func GenerateTokensWithClaims(userID uint, email, name, role string, isActive bool, extra map[string]interface{}) (*TokenPair, error) {
 	cfg := config.Get()
 
+	if err := checkExtraClaims(extra); err != nil {
+		return nil, err
+	}
+
 	// Generate access token
-	accessToken, err := generateAccessToken(userID, email, name, role, isActive, cfg)
+	accessToken, err := generateAccessToken(userID, email, name, role, isActive, extra, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
@@ -44,7 +150,7 @@ func GenerateTokens(userID uint, email, name, role string, isActive bool) (*Toke
 }
 
 // generateAccessToken generates an access token
-func generateAccessToken(userID uint, email, name, role string, isActive bool, cfg *config.Config) (string, error) {
+func generateAccessToken(userID uint, email, name, role string, isActive bool, extra map[string]interface{}, cfg *config.Config) (string, error) {
 	now := time.Now()
 	expiresAt := now.Add(cfg.JWT.Expiry)
 
@@ -54,9 +160,11 @@ func generateAccessToken(userID uint, email, name, role string, isActive bool, c
 		Name:     name,
 		Role:     role,
 		IsActive: isActive,
+		Extra:    extra,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    cfg.JWT.Issuer,
 			Subject:   fmt.Sprintf("%d", userID),
+			Audience:  tokenAudience(cfg),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			NotBefore: jwt.NewNumericDate(now),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -76,6 +184,7 @@ func generateRefreshToken(userID uint, cfg *config.Config) (string, error) {
 	claims := jwt.RegisteredClaims{
 		Issuer:    cfg.JWT.Issuer,
 		Subject:   fmt.Sprintf("%d", userID),
+		Audience:  tokenAudience(cfg),
 		ExpiresAt: jwt.NewNumericDate(expiresAt),
 		NotBefore: jwt.NewNumericDate(now),
 		IssuedAt:  jwt.NewNumericDate(now),
@@ -86,7 +195,48 @@ func generateRefreshToken(userID uint, cfg *config.Config) (string, error) {
 	return token.SignedString([]byte(cfg.JWT.Secret))
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// tokenAudience returns the audience to sign tokens with, if configured
+func tokenAudience(cfg *config.Config) jwt.ClaimStrings {
+	if cfg.JWT.Audience == "" {
+		return nil
+	}
+	return jwt.ClaimStrings{cfg.JWT.Audience}
+}
+
+// validateAudienceAndIssuer enforces JWT_AUDIENCE and JWT_TRUSTED_ISSUERS.
+// Each check is skipped when its setting is empty.
+func validateAudienceAndIssuer(claims *jwt.RegisteredClaims, cfg *config.Config) error {
+	if cfg.JWT.Audience != "" {
+		valid := false
+		for _, aud := range claims.Audience {
+			if aud == cfg.JWT.Audience {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return ErrInvalidAudience
+		}
+	}
+
+	if len(cfg.JWT.TrustedIssuers) > 0 {
+		trusted := claims.Issuer == cfg.JWT.Issuer
+		for _, issuer := range cfg.JWT.TrustedIssuers {
+			if claims.Issuer == issuer {
+				trusted = true
+				break
+			}
+		}
+		if !trusted {
+			return ErrUntrustedIssuer
+		}
+	}
+
+	return nil
+}
+
+// ValidateToken validates a JWT token and returns the claims. Expiry and
+// not-before times are checked with JWT_LEEWAY of tolerance for clock skew.
 func ValidateToken(tokenString string) (*JWTClaims, error) {
 	cfg := config.Get()
 
@@ -96,7 +246,7 @@ func ValidateToken(tokenString string) (*JWTClaims, error) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(cfg.JWT.Secret), nil
-	})
+	}, jwt.WithLeeway(cfg.JWT.Leeway))
 
 	if err != nil {
 		return nil, err
@@ -107,6 +257,14 @@ func ValidateToken(tokenString string) (*JWTClaims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	if err := validateAudienceAndIssuer(&claims.RegisteredClaims, cfg); err != nil {
+		return nil, err
+	}
+
+	if userID, err := parseSubject(claims.Subject); err != nil || userID != claims.UserID {
+		return nil, ErrInvalidSubject
+	}
+
 	// Check if user is active
 	if !claims.IsActive {
 		return nil, errors.New("user account is deactivated")
@@ -115,8 +273,25 @@ func ValidateToken(tokenString string) (*JWTClaims, error) {
 	return claims, nil
 }
 
-// ValidateRefreshToken validates a refresh token
+// RefreshClaims holds what a validated refresh token says about its user
+type RefreshClaims struct {
+	UserID   uint
+	IssuedAt time.Time
+}
+
+// ValidateRefreshToken validates a refresh token, with the same JWT_LEEWAY
+// tolerance as ValidateToken, and returns its user ID
 func ValidateRefreshToken(tokenString string) (uint, error) {
+	claims, err := ValidateRefreshTokenClaims(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+// ValidateRefreshTokenClaims validates a refresh token like
+// ValidateRefreshToken and returns its user ID and issue time
+func ValidateRefreshTokenClaims(tokenString string) (*RefreshClaims, error) {
 	cfg := config.Get()
 
 	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
@@ -125,24 +300,41 @@ func ValidateRefreshToken(tokenString string) (uint, error) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(cfg.JWT.Secret), nil
-	})
+	}, jwt.WithLeeway(cfg.JWT.Leeway))
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(*jwt.RegisteredClaims)
 	if !ok || !token.Valid {
-		return 0, errors.New("invalid refresh token")
+		return nil, errors.New("invalid refresh token")
 	}
 
-	// Parse user ID from subject
-	var userID uint
-	if _, err := fmt.Sscanf(claims.Subject, "%d", &userID); err != nil {
-		return 0, errors.New("invalid user ID in token")
+	if err := validateAudienceAndIssuer(claims, cfg); err != nil {
+		return nil, err
 	}
 
-	return userID, nil
+	userID, err := parseSubject(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh := &RefreshClaims{UserID: userID}
+	if claims.IssuedAt != nil {
+		refresh.IssuedAt = claims.IssuedAt.Time
+	}
+	return refresh, nil
+}
+
+// parseSubject parses the user ID a token's subject holds. Anything but a
+// positive decimal integer that fits a uint is rejected.
+func parseSubject(subject string) (uint, error) {
+	userID, err := strconv.ParseUint(subject, 10, strconv.IntSize)
+	if err != nil || userID == 0 {
+		return 0, ErrInvalidSubject
+	}
+	return uint(userID), nil
 }
 
 // ExtractTokenFromHeader extracts the token from the Authorization header
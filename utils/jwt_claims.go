@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// ExtraClaim returns the extra claim named key
+func (c *JWTClaims) ExtraClaim(key string) (interface{}, bool) {
+	value, ok := c.Extra[key]
+	return value, ok
+}
+
+// ExtraString returns the extra claim named key if it is a string
+func (c *JWTClaims) ExtraString(key string) (string, bool) {
+	value, ok := c.Extra[key].(string)
+	return value, ok
+}
+
+// ExtraBool returns the extra claim named key if it is a boolean
+func (c *JWTClaims) ExtraBool(key string) (bool, bool) {
+	value, ok := c.Extra[key].(bool)
+	return value, ok
+}
+
+// ExtraInt returns the extra claim named key if it is a whole number.
+// Claims read from a token are JSON numbers, which decode as float64.
+func (c *JWTClaims) ExtraInt(key string) (int64, bool) {
+	switch v := c.Extra[key].(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// ExtraStrings returns the extra claim named key if it is a list of
+// strings, such as a set of feature flags
+func (c *JWTClaims) ExtraStrings(key string) ([]string, bool) {
+	switch v := c.Extra[key].(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, s)
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"go-api-boilerplate/config"
+)
+
+// signTestToken signs claims with the configured secret
+func signTestToken(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Get().JWT.Secret))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestTokenLeeway(t *testing.T) {
+	loadTestConfig(t, nil)
+	if leeway := config.Get().JWT.Leeway; leeway != 30*time.Second {
+		t.Fatalf("default JWT_LEEWAY = %v, want 30s", leeway)
+	}
+
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		notBefore time.Duration
+		want      error
+	}{
+		{"expired a few seconds ago", -5 * time.Second, -time.Hour, nil},
+		{"valid a few seconds from now", time.Hour, 5 * time.Second, nil},
+		{"expired well past the leeway", -5 * time.Minute, -time.Hour, jwt.ErrTokenExpired},
+		{"valid well past the leeway", time.Hour, 5 * time.Minute, jwt.ErrTokenNotValidYet},
+	}
+	for _, tt := range tests {
+		now := time.Now()
+		registered := jwt.RegisteredClaims{
+			Subject:   "7",
+			ExpiresAt: jwt.NewNumericDate(now.Add(tt.expiresIn)),
+			NotBefore: jwt.NewNumericDate(now.Add(tt.notBefore)),
+			IssuedAt:  jwt.NewNumericDate(now.Add(-time.Hour)),
+		}
+
+		access := signTestToken(t, JWTClaims{UserID: 7, IsActive: true, RegisteredClaims: registered})
+		if _, err := ValidateToken(access); !errors.Is(err, tt.want) {
+			t.Errorf("%s: access token: got %v, want %v", tt.name, err, tt.want)
+		}
+
+		refresh := signTestToken(t, registered)
+		if _, err := ValidateRefreshToken(refresh); !errors.Is(err, tt.want) {
+			t.Errorf("%s: refresh token: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTokenLeewayIsConfigurable(t *testing.T) {
+	loadTestConfig(t, map[string]string{"JWT_LEEWAY": "0s"})
+
+	now := time.Now()
+	token := signTestToken(t, JWTClaims{UserID: 7, IsActive: true, RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(-5 * time.Second)),
+	}})
+	if _, err := ValidateToken(token); !IsTokenExpired(err) {
+		t.Errorf("token expired 5s ago without leeway: got %v, want expired", err)
+	}
+}
+
+func TestExtraClaims(t *testing.T) {
+	loadTestConfig(t, nil)
+
+	extra := map[string]interface{}{
+		"tenant_id": "acme",
+		"seats":     25,
+		"beta":      true,
+		"features":  []string{"reports", "exports"},
+	}
+	tokens, err := GenerateTokensWithClaims(7, "a@example.com", "Ada", "admin", true, extra)
+	if err != nil {
+		t.Fatalf("GenerateTokensWithClaims: %v", err)
+	}
+
+	claims, err := ValidateToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != 7 || claims.Email != "a@example.com" || claims.Role != "admin" || !claims.IsActive || claims.Subject != "7" {
+		t.Errorf("typed claims = %+v, want them unchanged by the extras", claims)
+	}
+	if tenant, ok := claims.ExtraString("tenant_id"); !ok || tenant != "acme" {
+		t.Errorf("tenant_id = %q, %v, want acme", tenant, ok)
+	}
+	if seats, ok := claims.ExtraInt("seats"); !ok || seats != 25 {
+		t.Errorf("seats = %d, %v, want 25", seats, ok)
+	}
+	if beta, ok := claims.ExtraBool("beta"); !ok || !beta {
+		t.Errorf("beta = %v, %v, want true", beta, ok)
+	}
+	if features, ok := claims.ExtraStrings("features"); !ok || len(features) != 2 || features[0] != "reports" {
+		t.Errorf("features = %v, %v, want [reports exports]", features, ok)
+	}
+	if _, ok := claims.ExtraString("seats"); ok {
+		t.Error("ExtraString read a number")
+	}
+	if _, ok := claims.ExtraClaim("user_id"); ok {
+		t.Error("a typed claim is listed among the extras")
+	}
+
+	tokens, err = GenerateTokens(7, "a@example.com", "Ada", "admin", true)
+	if err != nil {
+		t.Fatalf("GenerateTokens: %v", err)
+	}
+	if claims, err = ValidateToken(tokens.AccessToken); err != nil || claims.Extra != nil {
+		t.Errorf("token without extras: Extra = %v, %v, want nil", claims.Extra, err)
+	}
+}
+
+func TestExtraClaimsCannotReplaceReservedClaims(t *testing.T) {
+	loadTestConfig(t, nil)
+
+	for _, key := range []string{"user_id", "role", "is_active", "sub", "exp"} {
+		_, err := GenerateTokensWithClaims(7, "a@example.com", "Ada", "user", true, map[string]interface{}{key: "x"})
+		if !errors.Is(err, ErrReservedClaim) {
+			t.Errorf("%s: got %v, want ErrReservedClaim", key, err)
+		}
+	}
+}
+
+func TestTokenSubjectMustBeUserID(t *testing.T) {
+	loadTestConfig(t, nil)
+
+	tests := []struct {
+		name    string
+		subject string
+		want    error
+	}{
+		{"matching user ID", "7", nil},
+		{"trailing garbage", "7abc", ErrInvalidSubject},
+		{"not a number", "abc", ErrInvalidSubject},
+		{"negative", "-7", ErrInvalidSubject},
+		{"zero", "0", ErrInvalidSubject},
+		{"missing", "", ErrInvalidSubject},
+		{"overflowing", "18446744073709551623", ErrInvalidSubject},
+	}
+	for _, tt := range tests {
+		registered := jwt.RegisteredClaims{
+			Subject:   tt.subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+
+		access := signTestToken(t, JWTClaims{UserID: 7, IsActive: true, RegisteredClaims: registered})
+		if claims, err := ValidateToken(access); !errors.Is(err, tt.want) {
+			t.Errorf("%s: access token: got %v, want %v", tt.name, err, tt.want)
+		} else if err == nil && claims.UserID != 7 {
+			t.Errorf("%s: access token user ID = %d, want 7", tt.name, claims.UserID)
+		}
+
+		refresh := signTestToken(t, registered)
+		if userID, err := ValidateRefreshToken(refresh); !errors.Is(err, tt.want) {
+			t.Errorf("%s: refresh token: got %v, want %v", tt.name, err, tt.want)
+		} else if err == nil && userID != 7 {
+			t.Errorf("%s: refresh token user ID = %d, want 7", tt.name, userID)
+		}
+	}
+
+	// The subject of an access token must name the user in user_id
+	mismatched := signTestToken(t, JWTClaims{UserID: 8, IsActive: true, RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "7",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	if _, err := ValidateToken(mismatched); !errors.Is(err, ErrInvalidSubject) {
+		t.Errorf("user_id 8 with subject 7: got %v, want ErrInvalidSubject", err)
+	}
+}
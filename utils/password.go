@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"go-api-boilerplate/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms, selected with PASSWORD_HASH. The algorithm is
+// recognisable from the stored hash: bcrypt hashes start with $2a$ or $2b$
+// and argon2id hashes use the PHC string format,
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// argon2Params are the cost parameters encoded in an argon2id hash
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// HashPassword hashes a password with the configured algorithm
+func HashPassword(password string) (string, error) {
+	cfg := config.Get().Password
+
+	if cfg.Hash == PasswordHashArgon2id {
+		return hashArgon2id(password, configuredArgon2Params(cfg))
+	}
+
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cfg.BcryptCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(bytes), nil
+}
+
+// CheckPassword compares a password with its hash, whichever supported
+// algorithm made it
+func CheckPassword(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// PasswordNeedsRehash reports whether a hash was made with another algorithm
+// than the configured one or with weaker parameters, so the password should
+// be hashed again the next time it is known, i.e. on login
+func PasswordNeedsRehash(hash string) bool {
+	cfg := config.Get().Password
+
+	if cfg.Hash == PasswordHashArgon2id {
+		if !strings.HasPrefix(hash, "$argon2id$") {
+			return true
+		}
+		params, _, _, err := parseArgon2id(hash)
+		if err != nil {
+			return true
+		}
+		want := configuredArgon2Params(cfg)
+		return params.memory < want.memory || params.iterations < want.iterations || params.parallelism < want.parallelism
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost < cfg.BcryptCost
+}
+
+// configuredArgon2Params returns the argon2id parameters from configuration
+func configuredArgon2Params(cfg config.PasswordConfig) argon2Params {
+	return argon2Params{
+		memory:      cfg.Argon2Memory,
+		iterations:  cfg.Argon2Iterations,
+		parallelism: uint8(cfg.Argon2Parallelism),
+	}
+}
+
+// hashArgon2id hashes a password with argon2id and a random salt
+func hashArgon2id(password string, params argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.memory, params.iterations, params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// parseArgon2id splits an argon2id hash into its parameters, salt and key
+func parseArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashPasswordTagsAlgorithm(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantPrefix string
+	}{
+		{"bcrypt", map[string]string{"PASSWORD_HASH": "bcrypt", "PASSWORD_BCRYPT_COST": "5"}, "$2a$05$"},
+		{"argon2id", map[string]string{
+			"PASSWORD_HASH":               "argon2id",
+			"PASSWORD_ARGON2_MEMORY":      "1024",
+			"PASSWORD_ARGON2_ITERATIONS":  "1",
+			"PASSWORD_ARGON2_PARALLELISM": "1",
+		}, "$argon2id$v=19$m=1024,t=1,p=1$"},
+	}
+	for _, tt := range tests {
+		loadTestConfig(t, tt.env)
+
+		hash, err := HashPassword("Password123!")
+		if err != nil {
+			t.Fatalf("%s: HashPassword: %v", tt.name, err)
+		}
+		if !strings.HasPrefix(hash, tt.wantPrefix) {
+			t.Errorf("%s: hash %q, want prefix %q", tt.name, hash, tt.wantPrefix)
+		}
+		if !CheckPassword("Password123!", hash) {
+			t.Errorf("%s: the password does not match its hash", tt.name)
+		}
+		if CheckPassword("Password124!", hash) {
+			t.Errorf("%s: another password matches the hash", tt.name)
+		}
+		if PasswordNeedsRehash(hash) {
+			t.Errorf("%s: a fresh hash needs rehashing", tt.name)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	loadTestConfig(t, map[string]string{"PASSWORD_HASH": "bcrypt", "PASSWORD_BCRYPT_COST": "4"})
+	bcrypt4, _ := HashPassword("Password123!")
+	loadTestConfig(t, map[string]string{"PASSWORD_HASH": "argon2id", "PASSWORD_ARGON2_MEMORY": "1024", "PASSWORD_ARGON2_ITERATIONS": "1", "PASSWORD_ARGON2_PARALLELISM": "1"})
+	argon1, _ := HashPassword("Password123!")
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		hash string
+		want bool
+	}{
+		{"same bcrypt cost", map[string]string{"PASSWORD_HASH": "bcrypt", "PASSWORD_BCRYPT_COST": "4"}, bcrypt4, false},
+		{"lower bcrypt cost", map[string]string{"PASSWORD_HASH": "bcrypt", "PASSWORD_BCRYPT_COST": "5"}, bcrypt4, true},
+		{"argon2id under bcrypt", map[string]string{"PASSWORD_HASH": "bcrypt", "PASSWORD_BCRYPT_COST": "4"}, argon1, true},
+		{"bcrypt under argon2id", map[string]string{"PASSWORD_HASH": "argon2id", "PASSWORD_ARGON2_MEMORY": "1024", "PASSWORD_ARGON2_ITERATIONS": "1", "PASSWORD_ARGON2_PARALLELISM": "1"}, bcrypt4, true},
+		{"same argon2id parameters", map[string]string{"PASSWORD_HASH": "argon2id", "PASSWORD_ARGON2_MEMORY": "1024", "PASSWORD_ARGON2_ITERATIONS": "1", "PASSWORD_ARGON2_PARALLELISM": "1"}, argon1, false},
+		{"more argon2id memory", map[string]string{"PASSWORD_HASH": "argon2id", "PASSWORD_ARGON2_MEMORY": "2048", "PASSWORD_ARGON2_ITERATIONS": "1", "PASSWORD_ARGON2_PARALLELISM": "1"}, argon1, true},
+		{"malformed argon2id", map[string]string{"PASSWORD_HASH": "argon2id"}, "$argon2id$v=19$bad", true},
+	}
+	for _, tt := range tests {
+		loadTestConfig(t, tt.env)
+		if got := PasswordNeedsRehash(tt.hash); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,13 +1,21 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+
+	"go-api-boilerplate/config"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ContextKeyRawResponse marks a request whose responses skip the envelope
+const ContextKeyRawResponse = "raw_response"
+
 // Response represents a standard API response
 type Response struct {
 	Success bool        `json:"success"`
@@ -42,18 +50,45 @@ type PaginatedResponse struct {
 	Error      *ErrorInfo     `json:"error,omitempty"`
 }
 
+// RawJSON sends v as the whole response body, without the envelope
+func RawJSON(c *gin.Context, statusCode int, v interface{}) {
+	c.JSON(statusCode, v)
+}
+
+// SetRawResponse makes the response helpers skip the envelope for the rest
+// of the request
+func SetRawResponse(c *gin.Context) {
+	c.Set(ContextKeyRawResponse, true)
+}
+
+// IsRawResponse reports whether the envelope is disabled for the request
+func IsRawResponse(c *gin.Context) bool {
+	return c.GetBool(ContextKeyRawResponse)
+}
+
 // SuccessResponse sends a success response
 func SuccessResponse(c *gin.Context, message string, data interface{}) {
-	c.JSON(http.StatusOK, Response{
-		Success: true,
-		Message: message,
-		Data:    data,
-	})
+	successResponse(c, http.StatusOK, message, data)
 }
 
 // CreatedResponse sends a created response
 func CreatedResponse(c *gin.Context, message string, data interface{}) {
-	c.JSON(http.StatusCreated, Response{
+	successResponse(c, http.StatusCreated, message, data)
+}
+
+// successResponse wraps data in the envelope, or sends it bare on raw
+// routes. A raw response without data has an empty body.
+func successResponse(c *gin.Context, statusCode int, message string, data interface{}) {
+	if IsRawResponse(c) {
+		if data == nil {
+			c.Status(statusCode)
+			return
+		}
+		RawJSON(c, statusCode, data)
+		return
+	}
+
+	c.JSON(statusCode, Response{
 		Success: true,
 		Message: message,
 		Data:    data,
@@ -65,16 +100,23 @@ func NoContentResponse(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// ErrorResponse sends an error response
+// ErrorResponse sends an error response. Raw routes get the ErrorInfo
+// object on its own.
 func ErrorResponse(c *gin.Context, statusCode int, message string, errorCode string, details map[string]interface{}) {
+	info := &ErrorInfo{
+		Code:    errorCode,
+		Message: message,
+		Details: details,
+	}
+	if IsRawResponse(c) {
+		RawJSON(c, statusCode, info)
+		return
+	}
+
 	c.JSON(statusCode, Response{
 		Success: false,
 		Message: message,
-		Error: &ErrorInfo{
-			Code:    errorCode,
-			Message: message,
-			Details: details,
-		},
+		Error:   info,
 	})
 }
 
@@ -108,6 +150,18 @@ func NotFoundResponse(c *gin.Context, resource string) {
 	ErrorResponse(c, http.StatusNotFound, message, "NOT_FOUND", nil)
 }
 
+// RespondNotFoundOrForbidden sends the response for a request denied access
+// to a resource that exists. Sensitive resources, whose existence must not
+// be disclosed, get the same 404 as a missing one so callers cannot probe for
+// them; others get 403.
+func RespondNotFoundOrForbidden(c *gin.Context, resource string, sensitive bool) {
+	if sensitive {
+		NotFoundResponse(c, resource)
+		return
+	}
+	ForbiddenResponse(c, "")
+}
+
 // ConflictResponse sends a conflict response
 func ConflictResponse(c *gin.Context, message string, details map[string]interface{}) {
 	ErrorResponse(c, http.StatusConflict, message, "CONFLICT", details)
@@ -128,8 +182,60 @@ func InternalServerErrorResponse(c *gin.Context, message string) {
 	ErrorResponse(c, http.StatusInternalServerError, message, "INTERNAL_ERROR", nil)
 }
 
-// PaginatedSuccessResponse sends a paginated success response
+// MarkStale flags a response served from a stale cached copy
+func MarkStale(c *gin.Context, cachedAt time.Time) {
+	c.Header("X-Cache", "stale")
+	c.Header("Warning", `110 - "Response is Stale"`)
+	if age := int64(time.Since(cachedAt).Seconds()); age > 0 {
+		c.Header("Age", strconv.FormatInt(age, 10))
+	}
+}
+
+// WeakETag builds a weak entity tag for a record from its ID and last
+// update time
+func WeakETag(id uint, updatedAt time.Time) string {
+	return fmt.Sprintf("W/\"%x-%x\"", id, updatedAt.UnixNano())
+}
+
+// NotModified sets etag as the response's validator, with private caching
+// that must revalidate, and responds 304 when the request's If-None-Match
+// lists it. It returns true when the response has been sent. Tags are
+// compared weakly, as If-None-Match requires.
+func NotModified(c *gin.Context, etag string) bool {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+
+	ifNoneMatch := strings.TrimSpace(c.GetHeader("If-None-Match"))
+	if ifNoneMatch == "" {
+		return false
+	}
+	if ifNoneMatch != "*" {
+		matched := false
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	c.AbortWithStatus(http.StatusNotModified)
+	return true
+}
+
+// PaginatedSuccessResponse sends a paginated success response. The
+// pagination headers are always set; the body is a bare array on raw routes
+// or when RESPONSE_LIST_ENVELOPE is false.
 func PaginatedSuccessResponse(c *gin.Context, message string, data interface{}, pagination PaginationMeta) {
+	SetPaginationHeaders(c, pagination)
+	if IsRawResponse(c) || !config.Get().Response.ListEnvelope {
+		RawJSON(c, http.StatusOK, data)
+		return
+	}
+
 	c.JSON(http.StatusOK, PaginatedResponse{
 		Success:    true,
 		Message:    message,
@@ -138,13 +244,53 @@ func PaginatedSuccessResponse(c *gin.Context, message string, data interface{},
 	})
 }
 
+// SetPaginationHeaders describes a page in the X-Total-Count, X-Page,
+// X-Per-Page and X-Total-Pages headers, with first, prev, next and last
+// links in the Link header
+func SetPaginationHeaders(c *gin.Context, pagination PaginationMeta) {
+	c.Header("X-Total-Count", strconv.FormatInt(pagination.Total, 10))
+	c.Header("X-Page", strconv.Itoa(pagination.Page))
+	c.Header("X-Per-Page", strconv.Itoa(pagination.PerPage))
+	c.Header("X-Total-Pages", strconv.Itoa(pagination.TotalPages))
+
+	if c.Request == nil || c.Request.URL == nil {
+		return
+	}
+
+	link := func(page int, rel string) string {
+		u := *c.Request.URL
+		query := u.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(pagination.PerPage))
+		u.RawQuery = query.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	var links []string
+	if pagination.TotalPages > 0 {
+		links = append(links, link(1, "first"))
+	}
+	if pagination.HasPrev {
+		links = append(links, link(pagination.Page-1, "prev"))
+	}
+	if pagination.HasNext {
+		links = append(links, link(pagination.Page+1, "next"))
+	}
+	if pagination.TotalPages > 0 {
+		links = append(links, link(pagination.TotalPages, "last"))
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+}
+
 // CalculatePaginationMeta calculates pagination metadata
 func CalculatePaginationMeta(page, perPage int, total int64) PaginationMeta {
 	if page < 1 {
 		page = 1
 	}
 	if perPage < 1 {
-		perPage = 10
+		perPage = config.Get().Pagination.DefaultPerPage
 	}
 
 	totalPages := int(total) / perPage
@@ -162,10 +308,33 @@ func CalculatePaginationMeta(page, perPage int, total int64) PaginationMeta {
 	}
 }
 
-// GetPaginationParams extracts pagination parameters from request
-func GetPaginationParams(c *gin.Context) (page, perPage int) {
+// ErrPerPageTooLarge is returned for page sizes over PAGINATION_MAX_PER_PAGE
+// when PAGINATION_REJECT_OVER_MAX is enabled
+var ErrPerPageTooLarge = errors.New("per_page exceeds the maximum")
+
+// NormalizePerPage applies the pagination limits to a requested page size.
+// Sizes below 1 mean none was requested and get PAGINATION_DEFAULT_PER_PAGE;
+// sizes over PAGINATION_MAX_PER_PAGE are clamped to it or, when
+// PAGINATION_REJECT_OVER_MAX is set, return ErrPerPageTooLarge.
+func NormalizePerPage(perPage int) (int, error) {
+	cfg := config.Get().Pagination
+	if perPage < 1 {
+		return cfg.DefaultPerPage, nil
+	}
+	if perPage > cfg.MaxPerPage {
+		if cfg.RejectOverMax {
+			return 0, fmt.Errorf("%w of %d", ErrPerPageTooLarge, cfg.MaxPerPage)
+		}
+		return cfg.MaxPerPage, nil
+	}
+	return perPage, nil
+}
+
+// GetPaginationParams extracts pagination parameters from request. An
+// invalid page falls back to the first page; per_page must be a positive
+// integer and is normalized by NormalizePerPage, whose error is returned.
+func GetPaginationParams(c *gin.Context) (page, perPage int, err error) {
 	page = 1
-	perPage = 10
 
 	if p, exists := c.GetQuery("page"); exists {
 		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
@@ -174,12 +343,18 @@ func GetPaginationParams(c *gin.Context) (page, perPage int) {
 	}
 
 	if pp, exists := c.GetQuery("per_page"); exists {
-		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= 100 {
-			perPage = parsed
+		parsed, err := strconv.Atoi(pp)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("per_page must be a positive integer")
 		}
+		perPage = parsed
 	}
 
-	return page, perPage
+	perPage, err = NormalizePerPage(perPage)
+	if err != nil {
+		return 0, 0, err
+	}
+	return page, perPage, nil
 }
 
 // GetOffset calculates the offset for pagination
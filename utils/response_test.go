@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizePerPageBoundaries(t *testing.T) {
+	tests := []struct {
+		name    string
+		reject  string
+		perPage int
+		want    int
+		wantErr error
+	}{
+		{"none requested", "true", 0, 20, nil},
+		{"negative", "true", -5, 20, nil},
+		{"smallest", "true", 1, 1, nil},
+		{"largest", "true", 100, 100, nil},
+		{"over the maximum rejected", "true", 101, 0, ErrPerPageTooLarge},
+		{"over the maximum clamped", "false", 101, 100, nil},
+		{"far over the maximum clamped", "false", 5000, 100, nil},
+	}
+	for _, tt := range tests {
+		loadTestConfig(t, map[string]string{"PAGINATION_REJECT_OVER_MAX": tt.reject})
+
+		got, err := NormalizePerPage(tt.perPage)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: NormalizePerPage(%d) = %d, %v, want %d, %v", tt.name, tt.perPage, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGetPaginationParams(t *testing.T) {
+	loadTestConfig(t, nil)
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		query       string
+		wantPage    int
+		wantPerPage int
+		wantErr     bool
+	}{
+		{"", 1, 20, false},
+		{"?page=3&per_page=100", 3, 100, false},
+		{"?page=0&per_page=1", 1, 1, false},
+		{"?per_page=101", 0, 0, true},
+		{"?per_page=0", 0, 0, true},
+		{"?per_page=ten", 0, 0, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/users"+tt.query, nil)
+
+		page, perPage, err := GetPaginationParams(c)
+		if page != tt.wantPage || perPage != tt.wantPerPage || (err != nil) != tt.wantErr {
+			t.Errorf("%q: got %d, %d, %v, want %d, %d, error %v", tt.query, page, perPage, err, tt.wantPage, tt.wantPerPage, tt.wantErr)
+		}
+	}
+}
+
+func TestGetPaginationParamsFromConfig(t *testing.T) {
+	limits := map[string]string{"PAGINATION_DEFAULT_PER_PAGE": "5", "PAGINATION_MAX_PER_PAGE": "50"}
+	clamped := map[string]string{"PAGINATION_DEFAULT_PER_PAGE": "5", "PAGINATION_MAX_PER_PAGE": "50", "PAGINATION_REJECT_OVER_MAX": "false"}
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		query       string
+		wantPage    int
+		wantPerPage int
+		wantErr     string
+	}{
+		{"configured default", limits, "", 1, 5, ""},
+		{"configured maximum", limits, "?per_page=50", 1, 50, ""},
+		{"over the configured maximum", limits, "?per_page=51", 0, 0, "per_page exceeds the maximum of 50"},
+		{"over the maximum clamped", clamped, "?page=2&per_page=51", 2, 50, ""},
+		{"invalid page falls back to the first", limits, "?page=abc&per_page=7", 1, 7, ""},
+		{"negative page falls back to the first", limits, "?page=-2", 1, 5, ""},
+		{"negative per_page", clamped, "?per_page=-3", 0, 0, "per_page must be a positive integer"},
+		{"empty per_page", clamped, "?per_page=", 0, 0, "per_page must be a positive integer"},
+		{"fractional per_page", clamped, "?per_page=2.5", 0, 0, "per_page must be a positive integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loadTestConfig(t, tt.env)
+			gin.SetMode(gin.TestMode)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/users"+tt.query, nil)
+
+			page, perPage, err := GetPaginationParams(c)
+			if page != tt.wantPage || perPage != tt.wantPerPage {
+				t.Errorf("got page %d, per page %d, want %d, %d", page, perPage, tt.wantPage, tt.wantPerPage)
+			}
+			if gotErr := fmt.Sprint(err); (tt.wantErr == "" && err != nil) || (tt.wantErr != "" && gotErr != tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	etag := WeakETag(42, updated)
+
+	if other := WeakETag(42, updated.Add(time.Nanosecond)); other == etag {
+		t.Errorf("WeakETag unchanged by an update: %s", etag)
+	}
+	if other := WeakETag(43, updated); other == etag {
+		t.Errorf("WeakETag the same for another ID: %s", etag)
+	}
+	if !strings.HasPrefix(etag, `W/"`) || !strings.HasSuffix(etag, `"`) {
+		t.Errorf("WeakETag = %s, want a quoted weak tag", etag)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"no header", "", false},
+		{"same tag", etag, true},
+		{"strong form of the tag", strings.TrimPrefix(etag, "W/"), true},
+		{"in a list", `W/"1-1", ` + etag, true},
+		{"any", "*", true},
+		{"other tag", `W/"2a-1"`, false},
+		{"unquoted tag", etag[3 : len(etag)-1], false},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/users/me", nil)
+		if tt.ifNoneMatch != "" {
+			c.Request.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+
+		if got := NotModified(c, etag); got != tt.want {
+			t.Errorf("%s: NotModified = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := recorder.Header().Get("ETag"); got != etag {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, got, etag)
+		}
+		if got := recorder.Header().Get("Cache-Control"); !strings.HasPrefix(got, "private") {
+			t.Errorf("%s: Cache-Control = %q, want private", tt.name, got)
+		}
+		c.Writer.WriteHeaderNow()
+		if tt.want && (recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0) {
+			t.Errorf("%s: status %d with %d bytes, want an empty 304", tt.name, recorder.Code, recorder.Body.Len())
+		}
+	}
+}